@@ -0,0 +1,65 @@
+// +build !nomemcache
+
+package cache
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"sync/atomic"
+	"time"
+
+	"github.com/bradfitz/gomemcache/memcache"
+)
+
+func NewMemcached(prefix string, servers ...string) (*MemcachedCache, error) {
+	return &MemcachedCache{prefix: prefix, client: memcache.New(servers...)}, nil
+}
+
+type MemcachedCache struct {
+	prefix   string
+	client   *memcache.Client
+	timeouts uint64
+}
+
+func (m *MemcachedCache) Get(k string) ([]byte, error) {
+	key := sha1.Sum([]byte(k))
+	hk := hex.EncodeToString(key[:])
+	done := make(chan bool, 1)
+
+	var err error
+	var item *memcache.Item
+
+	go func() {
+		item, err = m.client.Get(m.prefix + hk)
+		done <- true
+	}()
+
+	timeout := time.After(50 * time.Millisecond)
+
+	select {
+	case <-timeout:
+		atomic.AddUint64(&m.timeouts, 1)
+		return nil, ErrTimeout
+	case <-done:
+	}
+
+	if err != nil {
+		// translate to internal cache miss error
+		if err == memcache.ErrCacheMiss {
+			err = ErrNotFound
+		}
+		return nil, err
+	}
+
+	return item.Value, nil
+}
+
+func (m *MemcachedCache) Set(k string, v []byte, expire int32) {
+	key := sha1.Sum([]byte(k))
+	hk := hex.EncodeToString(key[:])
+	go m.client.Set(&memcache.Item{Key: m.prefix + hk, Value: v, Expiration: expire})
+}
+
+func (m *MemcachedCache) Timeouts() uint64 {
+	return atomic.LoadUint64(&m.timeouts)
+}
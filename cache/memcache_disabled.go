@@ -0,0 +1,20 @@
+// +build nomemcache
+
+package cache
+
+import (
+	"fmt"
+)
+
+type MemcachedCache struct {
+	NullCache
+}
+
+func (m *MemcachedCache) Timeouts() uint64 {
+	return 0
+}
+
+// NewMemcached always fails, as carbonapi was built without memcache support
+func NewMemcached(prefix string, servers ...string) (*MemcachedCache, error) {
+	return nil, fmt.Errorf("carbonapi was built with 'nomemcache' tag, memcache cache is not available")
+}
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/go-graphite/carbonapi/carbonapipb"
+	"github.com/go-graphite/carbonapi/date"
+	"github.com/go-graphite/carbonapi/expr"
+	"github.com/go-graphite/carbonapi/expr/types"
+	"github.com/go-graphite/carbonapi/pkg/parser"
+	util "github.com/go-graphite/carbonapi/util/ctx"
+	"github.com/go-graphite/carbonapi/util/instance"
+
+	pb "github.com/go-graphite/protocol/carbonapi_v3_pb"
+	"github.com/satori/go.uuid"
+	"go.uber.org/zap"
+)
+
+// exprDebugConfig enables /debug/expr endpoint. As it allows to run arbitrary expressions and exposes internals of
+// their evaluation, it's only available with basic auth credentials.
+type exprDebugConfig struct {
+	Enabled  bool   `mapstructure:"enabled"`
+	Username string `mapstructure:"username"`
+	Password string `mapstructure:"password"`
+}
+
+// MarshalJSON hides password, as config is logged and exported via expvar
+func (c exprDebugConfig) MarshalJSON() ([]byte, error) {
+	type debugConfig exprDebugConfig
+	hidden := debugConfig(c)
+	if hidden.Password != "" {
+		hidden.Password = "<hidden>"
+	}
+	return json.Marshal(hidden)
+}
+
+// authorized checks basic auth credentials of the request
+func (c exprDebugConfig) authorized(r *http.Request) bool {
+	if !c.Enabled || c.Username == "" {
+		return false
+	}
+	username, password, ok := r.BasicAuth()
+	if !ok {
+		return false
+	}
+	userOk := subtle.ConstantTimeCompare([]byte(username), []byte(c.Username)) == 1
+	passOk := subtle.ConstantTimeCompare([]byte(password), []byte(c.Password)) == 1
+	return userOk && passOk
+}
+
+// exprNode is JSON representation of parsed expression
+type exprNode struct {
+	Type      string              `json:"type"`
+	Target    string              `json:"target,omitempty"`
+	Value     interface{}         `json:"value,omitempty"`
+	Args      []exprNode          `json:"args,omitempty"`
+	NamedArgs map[string]exprNode `json:"namedArgs,omitempty"`
+}
+
+func newExprNode(e parser.Expr) exprNode {
+	switch {
+	case e.IsName():
+		return exprNode{Type: "name", Target: e.Target()}
+	case e.IsConst():
+		return exprNode{Type: "const", Value: e.FloatValue()}
+	case e.IsString():
+		return exprNode{Type: "string", Value: e.StringValue()}
+	}
+
+	n := exprNode{Type: "func", Target: e.Target()}
+	for _, arg := range e.Args() {
+		n.Args = append(n.Args, newExprNode(arg))
+	}
+	if len(e.NamedArgs()) > 0 {
+		n.NamedArgs = make(map[string]exprNode, len(e.NamedArgs()))
+		for k, arg := range e.NamedArgs() {
+			n.NamedArgs[k] = newExprNode(arg)
+		}
+	}
+	return n
+}
+
+type exprDebugFetch struct {
+	Metric string `json:"metric"`
+	From   int64  `json:"from"`
+	Until  int64  `json:"until"`
+}
+
+type exprDebugTimings struct {
+	Parse    float64 `json:"parse"`
+	Fetch    float64 `json:"fetch,omitempty"`
+	Evaluate float64 `json:"evaluate,omitempty"`
+}
+
+type exprDebugResponse struct {
+	Target  string           `json:"target"`
+	From    int64            `json:"from"`
+	Until   int64            `json:"until"`
+	Tree    *exprNode        `json:"tree,omitempty"`
+	Fetches []exprDebugFetch `json:"fetches,omitempty"`
+	// Rewritten contains targets that expression was rewritten to (e.x. by applyByNode), they are not evaluated
+	Rewritten []string          `json:"rewritten,omitempty"`
+	Result    json.RawMessage   `json:"result,omitempty"`
+	Errors    map[string]string `json:"errors,omitempty"`
+	Timings   exprDebugTimings  `json:"timings"`
+}
+
+// exprDebugHandler returns parse tree of the target, requests it needs to make and, if eval=1 is specified, result
+// of evaluation with timings of every stage
+func exprDebugHandler(w http.ResponseWriter, r *http.Request) {
+	t0 := time.Now()
+	uuid := uuid.NewV4()
+	ctx := util.SetUUID(r.Context(), uuid.String())
+	username, _, _ := r.BasicAuth()
+	srcIP, srcPort := splitRemoteAddr(r.RemoteAddr)
+
+	accessLogger := instance.Logger("access")
+	var accessLogDetails = carbonapipb.AccessLogDetails{
+		Handler:       "exprDebug",
+		Username:      username,
+		CarbonapiUUID: uuid.String(),
+		URL:           r.URL.RequestURI(),
+		PeerIP:        srcIP,
+		PeerPort:      srcPort,
+		Host:          r.Host,
+		Referer:       r.Referer(),
+		Format:        jsonFormat,
+		URI:           r.RequestURI,
+	}
+
+	logAsError := false
+	defer func() {
+		deferredAccessLogging(accessLogger, &accessLogDetails, t0, logAsError)
+	}()
+
+	if !config.ExprDebug.Enabled {
+		http.NotFound(w, r)
+		accessLogDetails.HTTPCode = http.StatusNotFound
+		accessLogDetails.Reason = "expression debugging is disabled"
+		logAsError = true
+		return
+	}
+
+	if !config.ExprDebug.authorized(r) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="carbonapi"`)
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		accessLogDetails.HTTPCode = http.StatusUnauthorized
+		accessLogDetails.Reason = "unauthorized"
+		logAsError = true
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest)+": "+err.Error(), http.StatusBadRequest)
+		accessLogDetails.HTTPCode = http.StatusBadRequest
+		accessLogDetails.Reason = err.Error()
+		logAsError = true
+		return
+	}
+
+	target := r.FormValue("target")
+	if target == "" {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		accessLogDetails.HTTPCode = http.StatusBadRequest
+		accessLogDetails.Reason = "no target specified"
+		logAsError = true
+		return
+	}
+	accessLogDetails.Targets = []string{target}
+
+	qtz := r.FormValue("tz")
+	res := exprDebugResponse{
+		Target: target,
+		From:   date.DateParamToEpoch(r.FormValue("from"), qtz, timeNow().Add(-24*time.Hour).Unix(), config.defaultTimeZone),
+		Until:  date.DateParamToEpoch(r.FormValue("until"), qtz, timeNow().Unix(), config.defaultTimeZone),
+		Errors: make(map[string]string),
+	}
+	accessLogDetails.From = res.From
+	accessLogDetails.Until = res.Until
+
+	res.exec(ctx, target, parser.TruthyBool(r.FormValue("eval")), &accessLogDetails)
+
+	var b []byte
+	if parser.TruthyBool(r.FormValue("pretty")) {
+		b, err = json.MarshalIndent(res, "", "\t")
+	} else {
+		b, err = json.Marshal(res)
+	}
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		accessLogDetails.HTTPCode = http.StatusInternalServerError
+		accessLogDetails.Reason = err.Error()
+		logAsError = true
+		return
+	}
+
+	writeResponse(w, b, jsonFormat, "")
+}
+
+// exec parses target and, if eval is set, fetches the data and evaluates expression. All the errors are reported
+// as part of the response, as debugging them is the whole point of the endpoint.
+func (res *exprDebugResponse) exec(ctx context.Context, target string, eval bool, accessLogDetails *carbonapipb.AccessLogDetails) {
+	tParse := time.Now()
+	exp, rest, err := parser.ParseExpr(target)
+	if err == nil && rest != "" {
+		err = parser.ErrUnexpectedCharacter
+	}
+	if err == nil {
+		exp, err = parser.ExpandTemplates(exp, nil)
+	}
+	res.Timings.Parse = time.Since(tParse).Seconds()
+	if err != nil {
+		res.Errors["parse"] = buildParseErrorString(target, rest, err)
+		return
+	}
+
+	tree := newExprNode(exp)
+	res.Tree = &tree
+
+	from32, until32 := res.From, res.Until
+	var req pb.MultiFetchRequest
+	for _, m := range exp.Metrics() {
+		f := exprDebugFetch{Metric: m.Metric, From: m.From + from32, Until: m.Until + until32}
+		res.Fetches = append(res.Fetches, f)
+		req.Metrics = append(req.Metrics, pb.FetchRequest{
+			Name:           f.Metric,
+			PathExpression: f.Metric,
+			StartTime:      f.From,
+			StopTime:       f.Until,
+		})
+	}
+
+	if !eval {
+		return
+	}
+
+	metricMap := make(map[parser.MetricRequest][]*types.MetricData)
+	if len(req.Metrics) > 0 {
+		tFetch := time.Now()
+		config.limiter.enter()
+		data, stats, err := config.zipper.Render(ctx, req)
+		config.limiter.leave()
+		res.Timings.Fetch = time.Since(tFetch).Seconds()
+		if stats != nil {
+			accessLogDetails.ZipperRequests += stats.ZipperRequests
+			accessLogDetails.TotalMetricsCount += stats.TotalMetricsCount
+		}
+		if err != nil {
+			res.Errors["fetch"] = err.Error()
+		}
+
+		for _, m := range data {
+			mfetch := parser.MetricRequest{
+				Metric: m.PathExpression,
+				From:   m.RequestStartTime,
+				Until:  m.RequestStopTime,
+			}
+			if mfetch.From == 0 || mfetch.Until == 0 {
+				for _, f := range res.Fetches {
+					if f.Metric == m.PathExpression {
+						mfetch.From, mfetch.Until = f.From, f.Until
+						break
+					}
+				}
+			}
+			metricMap[mfetch] = append(metricMap[mfetch], m)
+		}
+		for mfetch := range metricMap {
+			expr.SortMetrics(metricMap[mfetch], mfetch)
+		}
+	}
+
+	tEval := time.Now()
+	defer func() {
+		res.Timings.Evaluate = time.Since(tEval).Seconds()
+		if r := recover(); r != nil {
+			instance.Logger("exprDebug").Error("panic during eval",
+				zap.String("target", target),
+				zap.Any("reason", r),
+				zap.Stack("stack"),
+			)
+			res.Errors["evaluate"] = "panic during evaluation, see logs for details"
+		}
+	}()
+
+	rewritten, newTargets, err := expr.RewriteExpr(exp, from32, until32, metricMap)
+	if err != nil && err != parser.ErrSeriesDoesNotExist {
+		res.Errors["evaluate"] = err.Error()
+		return
+	}
+	if rewritten {
+		sort.Strings(newTargets)
+		res.Rewritten = newTargets
+		return
+	}
+
+	results, err := expr.EvalExprWithLimits(exp, from32, until32, metricMap, config.EvalLimits)
+	if err != nil && err != parser.ErrSeriesDoesNotExist {
+		res.Errors["evaluate"] = err.Error()
+		return
+	}
+	res.Result = types.MarshalJSON(results, false)
+}
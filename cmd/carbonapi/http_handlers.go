@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -16,13 +17,15 @@ import (
 	"github.com/go-graphite/carbonapi/expr/metadata"
 	"github.com/go-graphite/carbonapi/expr/types"
 	"github.com/go-graphite/carbonapi/intervalset"
+	"github.com/go-graphite/carbonapi/phases"
 	"github.com/go-graphite/carbonapi/pkg/parser"
 	util "github.com/go-graphite/carbonapi/util/ctx"
+	"github.com/go-graphite/carbonapi/util/instance"
+	zipperTypes "github.com/go-graphite/carbonapi/zipper/types"
 
 	"github.com/dgryski/httputil"
 	pb "github.com/go-graphite/protocol/carbonapi_v3_pb"
 	pickle "github.com/lomik/og-rek"
-	"github.com/lomik/zapwriter"
 	"github.com/satori/go.uuid"
 	"go.uber.org/zap"
 )
@@ -63,10 +66,23 @@ func initHandlers() *http.ServeMux {
 	r.HandleFunc("/functions", functionsHandler)
 	r.HandleFunc("/functions/", functionsHandler)
 
+	r.HandleFunc("/debug/expr", exprDebugHandler)
+	r.HandleFunc("/debug/expr/", exprDebugHandler)
+
 	r.HandleFunc("/", usageHandler)
 	return r
 }
 
+// setPartialResultHeader marks response as incomplete and lists backends that have failed
+func setPartialResultHeader(w http.ResponseWriter, failedServers map[string]struct{}) {
+	servers := make([]string, 0, len(failedServers))
+	for s := range failedServers {
+		servers = append(servers, s)
+	}
+	sort.Strings(servers)
+	w.Header().Set(util.HeaderPartialResult, strings.Join(servers, ","))
+}
+
 func writeResponse(w http.ResponseWriter, b []byte, format string, jsonp string) {
 
 	switch format {
@@ -122,14 +138,14 @@ func renderHandler(w http.ResponseWriter, r *http.Request) {
 	ctx := util.SetUUID(r.Context(), uuid.String())
 	username, _, _ := r.BasicAuth()
 
-	logger := zapwriter.Logger("render").With(
+	logger := instance.Logger("render").With(
 		zap.String("carbonapi_uuid", uuid.String()),
 		zap.String("username", username),
 	)
 
 	srcIP, srcPort := splitRemoteAddr(r.RemoteAddr)
 
-	accessLogger := zapwriter.Logger("access")
+	accessLogger := instance.Logger("access")
 	var accessLogDetails = carbonapipb.AccessLogDetails{
 		Handler:       "render",
 		Username:      username,
@@ -166,6 +182,20 @@ func renderHandler(w http.ResponseWriter, r *http.Request) {
 	template := r.FormValue("template")
 	useCache := !parser.TruthyBool(r.FormValue("noCache"))
 
+	strict := config.Strict
+	if v := r.FormValue("strict"); v != "" {
+		strict = parser.TruthyBool(v)
+	}
+	ctx = util.SetStrict(ctx, strict)
+
+	// template[name]=value parameters are substituted into template() functions of the targets
+	templateParams := make(map[string]string)
+	for k, v := range r.Form {
+		if strings.HasPrefix(k, "template[") && strings.HasSuffix(k, "]") && len(v) > 0 {
+			templateParams[k[len("template["):len(k)-1]] = v[0]
+		}
+	}
+
 	var jsonp string
 
 	if format == jsonFormat {
@@ -251,6 +281,9 @@ func renderHandler(w http.ResponseWriter, r *http.Request) {
 	var results []*types.MetricData
 	errors := make(map[string]string)
 	metricMap := make(map[parser.MetricRequest][]*types.MetricData)
+	failedServers := make(map[string]struct{})
+	// dashboards often repeat the same target, it's evaluated once and its results are copied
+	evaluated := make(map[string][]*types.MetricData)
 
 	var metrics []string
 	var targetIdx = 0
@@ -258,6 +291,11 @@ func renderHandler(w http.ResponseWriter, r *http.Request) {
 		var target = targets[targetIdx]
 		targetIdx++
 
+		if res, ok := evaluated[target]; ok {
+			results = append(results, copyMetricData(res)...)
+			continue
+		}
+
 		exp, e, err := parser.ParseExpr(target)
 
 		if err != nil || e != "" {
@@ -269,6 +307,16 @@ func renderHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
+		exp, err = parser.ExpandTemplates(exp, templateParams)
+		if err != nil {
+			msg := buildParseErrorString(target, "", err)
+			http.Error(w, msg, http.StatusBadRequest)
+			accessLogDetails.Reason = msg
+			accessLogDetails.HTTPCode = http.StatusBadRequest
+			logAsError = true
+			return
+		}
+
 		// Splitting requets into batches is now done by carbonzipper
 		pathExprTimeMap := make(map[string]requestInterval)
 		var req pb.MultiFetchRequest
@@ -300,12 +348,29 @@ func renderHandler(w http.ResponseWriter, r *http.Request) {
 			if stats != nil {
 				accessLogDetails.ZipperRequests += stats.ZipperRequests
 				accessLogDetails.TotalMetricsCount += stats.TotalMetricsCount
+				for _, s := range stats.FailedServers {
+					failedServers[s] = struct{}{}
+				}
+			}
+			config.limiter.leave()
+			if err == zipperTypes.ErrPartialResponse {
+				http.Error(w, err.Error(), http.StatusBadGateway)
+				accessLogDetails.HTTPCode = http.StatusBadGateway
+				accessLogDetails.Reason = err.Error()
+				logAsError = true
+				return
+			}
+			if err == zipperTypes.ErrQuorumNotReached {
+				http.Error(w, err.Error(), http.StatusServiceUnavailable)
+				accessLogDetails.HTTPCode = http.StatusServiceUnavailable
+				accessLogDetails.Reason = err.Error()
+				logAsError = true
+				return
 			}
 			if err != nil {
 				errors[target] = err.Error()
 			}
 
-			config.limiter.leave()
 			for _, m := range r {
 				var f int64
 				var u int64
@@ -351,6 +416,7 @@ func renderHandler(w http.ResponseWriter, r *http.Request) {
 		if rewritten {
 			targets = append(targets, newTargets...)
 		} else {
+			var limitErr error
 			func() {
 				defer func() {
 					if r := recover(); r != nil {
@@ -361,7 +427,13 @@ func renderHandler(w http.ResponseWriter, r *http.Request) {
 						)
 					}
 				}()
-				exprs, err := expr.EvalExpr(exp, from32, until32, metricMap)
+				t0 := time.Now()
+				exprs, err := expr.EvalExprWithLimits(exp, from32, until32, metricMap, config.EvalLimits)
+				phases.Since(phases.Evaluate, t0)
+				if expr.IsLimitError(err) {
+					limitErr = err
+					return
+				}
 				if err != nil && err != parser.ErrSeriesDoesNotExist {
 					errors[target] = err.Error()
 					accessLogDetails.Reason = err.Error()
@@ -369,7 +441,15 @@ func renderHandler(w http.ResponseWriter, r *http.Request) {
 					return
 				}
 				results = append(results, exprs...)
+				evaluated[target] = exprs
 			}()
+			if limitErr != nil {
+				http.Error(w, limitErr.Error(), http.StatusBadRequest)
+				accessLogDetails.HTTPCode = http.StatusBadRequest
+				accessLogDetails.Reason = limitErr.Error()
+				logAsError = true
+				return
+			}
 		}
 	}
 
@@ -380,13 +460,14 @@ func renderHandler(w http.ResponseWriter, r *http.Request) {
 		results = append(results, &types.MetricData{})
 	}
 
+	tEncode := time.Now()
 	switch format {
 	case jsonFormat:
 		if maxDataPoints, _ := strconv.Atoi(r.FormValue("maxDataPoints")); maxDataPoints != 0 {
 			types.ConsolidateJSON(maxDataPoints, results)
 		}
 
-		body = types.MarshalJSON(results)
+		body = types.MarshalJSON(results, parser.TruthyBool(r.FormValue("noNullPoints")))
 	case protobufFormat, protobuf3Format:
 		body, err = types.MarshalProtobuf(results)
 		if err != nil {
@@ -409,10 +490,15 @@ func renderHandler(w http.ResponseWriter, r *http.Request) {
 	case svgFormat:
 		body = png.MarshalSVGRequest(r, results, template)
 	}
+	phases.Since(phases.Encode, tEncode)
 
+	// partial responses are not cached, next request might get all the data
+	if len(failedServers) > 0 {
+		setPartialResultHeader(w, failedServers)
+	}
 	writeResponse(w, body, format, jsonp)
 
-	if len(results) != 0 {
+	if len(results) != 0 && len(failedServers) == 0 {
 		tc := time.Now()
 		config.queryCache.Set(cacheKey, body, cacheTimeout)
 		td := time.Since(tc).Nanoseconds()
@@ -426,6 +512,16 @@ func renderHandler(w http.ResponseWriter, r *http.Request) {
 	accessLogDetails.HaveNonFatalErrors = gotErrors
 }
 
+// copyMetricData returns shallow copies of the series, so options like color can be set for every copy separately
+func copyMetricData(series []*types.MetricData) []*types.MetricData {
+	res := make([]*types.MetricData, 0, len(series))
+	for _, s := range series {
+		c := *s
+		res = append(res, &c)
+	}
+	return res
+}
+
 // Find handler and it's helper functions
 
 type treejson struct {
@@ -444,7 +540,8 @@ func findTreejson(multiGlobs *pb.MultiGlobResponse) ([]byte, error) {
 
 	var tree = make([]treejson, 0)
 
-	seen := make(map[string]struct{})
+	// index of the node in the tree, path that is both a leaf and a branch is returned as a single node with both flags
+	seen := make(map[string]int)
 
 	for _, globs := range multiGlobs.Metrics {
 		basepath := globs.Name
@@ -463,25 +560,23 @@ func findTreejson(multiGlobs *pb.MultiGlobResponse) ([]byte, error) {
 				name = name[i+1:]
 			}
 
-			if _, ok := seen[name]; ok {
-				continue
-			}
-			seen[name] = struct{}{}
-
-			t := treejson{
-				ID:      basepath + name,
-				Context: treejsonContext,
-				Text:    name,
+			i, ok := seen[name]
+			if !ok {
+				i = len(tree)
+				seen[name] = i
+				tree = append(tree, treejson{
+					ID:      basepath + name,
+					Context: treejsonContext,
+					Text:    name,
+				})
 			}
 
 			if g.IsLeaf {
-				t.Leaf = 1
+				tree[i].Leaf = 1
 			} else {
-				t.AllowChildren = 1
-				t.Expandable = 1
+				tree[i].AllowChildren = 1
+				tree[i].Expandable = 1
 			}
-
-			tree = append(tree, t)
 		}
 	}
 
@@ -569,7 +664,7 @@ func findHandler(w http.ResponseWriter, r *http.Request) {
 	query := r.Form["query"]
 	srcIP, srcPort := splitRemoteAddr(r.RemoteAddr)
 
-	accessLogger := zapwriter.Logger("access")
+	accessLogger := instance.Logger("access")
 	var accessLogDetails = carbonapipb.AccessLogDetails{
 		Handler:       "find",
 		Username:      username,
@@ -696,7 +791,7 @@ func infoHandler(w http.ResponseWriter, r *http.Request) {
 		format = jsonFormat
 	}
 
-	accessLogger := zapwriter.Logger("access")
+	accessLogger := instance.Logger("access")
 	var accessLogDetails = carbonapipb.AccessLogDetails{
 		Handler:       "info",
 		Username:      username,
@@ -762,7 +857,7 @@ func infoHandler(w http.ResponseWriter, r *http.Request) {
 
 func lbcheckHandler(w http.ResponseWriter, r *http.Request) {
 	t0 := time.Now()
-	accessLogger := zapwriter.Logger("access")
+	accessLogger := instance.Logger("access")
 
 	w.Write([]byte("Ok\n"))
 
@@ -784,7 +879,7 @@ func lbcheckHandler(w http.ResponseWriter, r *http.Request) {
 
 func versionHandler(w http.ResponseWriter, r *http.Request) {
 	t0 := time.Now()
-	accessLogger := zapwriter.Logger("access")
+	accessLogger := instance.Logger("access")
 
 	if config.GraphiteWeb09Compatibility {
 		w.Write([]byte("0.9.15\n"))
@@ -814,7 +909,7 @@ func functionsHandler(w http.ResponseWriter, r *http.Request) {
 
 	srcIP, srcPort := splitRemoteAddr(r.RemoteAddr)
 
-	accessLogger := zapwriter.Logger("access")
+	accessLogger := instance.Logger("access")
 	var accessLogDetails = carbonapipb.AccessLogDetails{
 		Handler:  "functions",
 		Username: username,
@@ -939,6 +1034,7 @@ supported requests:
 	/metrics/find/?query=
 	/info/?target=
 	/functions/
+	/debug/expr/?target= (if enabled)
 `)
 
 func usageHandler(w http.ResponseWriter, r *http.Request) {
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/go-graphite/carbonapi/util/instance"
+	"github.com/lomik/zapwriter"
+)
+
+func TestAccessLogInstance(t *testing.T) {
+	defer zapwriter.Test()()
+	defer instance.Set(instance.Name())
+	instance.Set("api-a")
+
+	req, rr := setUpRequest(t, "/render/?target=foo.bar&format=json")
+	renderHandler(rr, req)
+
+	out := zapwriter.TestCapture()
+	if !strings.Contains(out, "api-a") {
+		t.Fatalf("access log doesn't have instance name: %s", out)
+	}
+}
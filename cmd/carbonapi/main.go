@@ -22,11 +22,16 @@ import (
 	"github.com/facebookgo/pidfile"
 	"github.com/go-graphite/carbonapi/cache"
 	"github.com/go-graphite/carbonapi/carbonapipb"
+	"github.com/go-graphite/carbonapi/expr"
 	"github.com/go-graphite/carbonapi/expr/functions/cairo/png"
 	"github.com/go-graphite/carbonapi/expr/helper"
 	"github.com/go-graphite/carbonapi/mstats"
+	"github.com/go-graphite/carbonapi/phases"
 	"github.com/go-graphite/carbonapi/pkg/parser"
+	"github.com/go-graphite/carbonapi/util/instance"
+	realZipper "github.com/go-graphite/carbonapi/zipper"
 	zipperCfg "github.com/go-graphite/carbonapi/zipper/config"
+	zipperHelper "github.com/go-graphite/carbonapi/zipper/helper"
 	zipperTypes "github.com/go-graphite/carbonapi/zipper/types"
 	"github.com/gorilla/handlers"
 	"github.com/peterbourgon/g2g"
@@ -83,6 +88,17 @@ var zipperMetrics = struct {
 	InfoErrors   *expvar.Int
 
 	Timeouts *expvar.Int
+	NotFound *expvar.Int
+
+	RetryBudgetExhausted expvar.Func
+	DecodeErrors         expvar.Func
+	DecodeAnomalies      expvar.Func
+	QuarantinedServers   expvar.Func
+	DivergentPoints      expvar.Func
+	MismatchedPoints     expvar.Func
+	ProtocolDowngrades   expvar.Func
+	CarbonlinkErrors     expvar.Func
+	TruncatedResponses   expvar.Func
 
 	CacheSize   expvar.Func
 	CacheItems  expvar.Func
@@ -101,6 +117,7 @@ var zipperMetrics = struct {
 	InfoErrors:   expvar.NewInt("zipper_info_errors"),
 
 	Timeouts: expvar.NewInt("zipper_timeouts"),
+	NotFound: expvar.NewInt("zipper_not_found"),
 
 	CacheHits:   expvar.NewInt("zipper_cache_hits"),
 	CacheMisses: expvar.NewInt("zipper_cache_misses"),
@@ -181,6 +198,7 @@ var config = struct {
 	TimezoneString             string             `mapstructure:"tz"`
 	UnicodeRangeTables         []string           `mapstructure:"unicodeRangeTables"`
 	Graphite                   graphiteConfig     `mapstructure:"graphite"`
+	Instance                   string             `mapstructure:"instance"`
 	IdleConnections            int                `mapstructure:"idleConnections"`
 	PidFile                    string             `mapstructure:"pidFile"`
 	SendGlobsAsIs              bool               `mapstructure:"sendGlobsAsIs"`
@@ -191,9 +209,12 @@ var config = struct {
 	ExpireDelaySec             int32              `mapstructure:"expireDelaySec"`
 	GraphiteWeb09Compatibility bool               `mapstructure:"graphite09compat"`
 	IgnoreClientTimeout        bool               `mapstructure:"ignoreClientTimeout"`
+	Strict                     bool               `mapstructure:"strict"`
 	DefaultColors              map[string]string  `mapstructure:"defaultColors"`
 	GraphTemplates             string             `mapstructure:"graphTemplates"`
 	FunctionsConfigs           map[string]string  `mapstructure:"functionsConfig"`
+	ExprDebug                  exprDebugConfig    `mapstructure:"exprDebug"`
+	EvalLimits                 expr.Limits        `mapstructure:"evalLimits"`
 
 	queryCache cache.BytesCache
 	findCache  cache.BytesCache
@@ -241,6 +262,7 @@ var config = struct {
 			Connect: 200 * time.Millisecond,
 		},
 		KeepAliveInterval: 30 * time.Second,
+		ConsolidateBy:     "avg",
 
 		MaxIdleConnsPerHost: 100,
 	},
@@ -253,6 +275,7 @@ func zipperStats(stats *zipperTypes.Stats) {
 		return
 	}
 	zipperMetrics.Timeouts.Add(stats.Timeouts)
+	zipperMetrics.NotFound.Add(stats.NotFound)
 	zipperMetrics.FindErrors.Add(stats.FindErrors)
 	zipperMetrics.RenderErrors.Add(stats.RenderErrors)
 	zipperMetrics.InfoErrors.Add(stats.InfoErrors)
@@ -264,6 +287,7 @@ func zipperStats(stats *zipperTypes.Stats) {
 var graphTemplates map[string]png.PictureParams
 
 func setUpConfig(logger *zap.Logger) {
+	instance.Set(config.Instance)
 	config.Cache.MemcachedServers = viper.GetStringSlice("cache.memcachedServers")
 	if n := viper.GetString("logger.logger"); n != "" {
 		config.Logger[0].Logger = n
@@ -374,6 +398,26 @@ func setUpConfig(logger *zap.Logger) {
 
 	config.limiter = newLimiter(config.Concurency)
 
+	zipperMetrics.RetryBudgetExhausted = expvar.Func(func() interface{} { return zipperHelper.GetRetryBudget().Exhausted() })
+	expvar.Publish("zipper_retry_budget_exhausted", zipperMetrics.RetryBudgetExhausted)
+	zipperMetrics.DecodeErrors = expvar.Func(func() interface{} { return zipperHelper.DecodeErrors() })
+	expvar.Publish("zipper_decode_errors", zipperMetrics.DecodeErrors)
+	zipperMetrics.DecodeAnomalies = expvar.Func(func() interface{} { return zipperHelper.DecodeAnomaliesByKind() })
+	expvar.Publish("zipper_decode_anomalies", zipperMetrics.DecodeAnomalies)
+	zipperMetrics.QuarantinedServers = expvar.Func(func() interface{} { return zipperHelper.QuarantinedServers() })
+	expvar.Publish("zipper_quarantined_servers", zipperMetrics.QuarantinedServers)
+	zipperMetrics.DivergentPoints = expvar.Func(func() interface{} { return zipperTypes.DivergentPoints() })
+	expvar.Publish("zipper_divergent_points", zipperMetrics.DivergentPoints)
+	zipperMetrics.MismatchedPoints = expvar.Func(func() interface{} { return zipperTypes.MismatchedPoints() })
+	expvar.Publish("zipper_replica_mismatches", zipperMetrics.MismatchedPoints)
+	zipperMetrics.ProtocolDowngrades = expvar.Func(func() interface{} { return realZipper.ProtocolDowngrades() })
+	expvar.Publish("zipper_protocol_downgrades", zipperMetrics.ProtocolDowngrades)
+	zipperMetrics.CarbonlinkErrors = expvar.Func(func() interface{} { return realZipper.CarbonlinkErrors() })
+	expvar.Publish("zipper_carbonlink_errors", zipperMetrics.CarbonlinkErrors)
+	zipperMetrics.TruncatedResponses = expvar.Func(func() interface{} { return zipperHelper.TruncatedResponses() })
+	expvar.Publish("zipper_truncated_responses", zipperMetrics.TruncatedResponses)
+	phases.Publish("phase_")
+
 	switch config.Cache.Type {
 	case "memcache":
 		if len(config.Cache.MemcachedServers) == 0 {
@@ -383,14 +427,18 @@ func setUpConfig(logger *zap.Logger) {
 		logger.Info("memcached configured",
 			zap.Strings("servers", config.Cache.MemcachedServers),
 		)
-		config.queryCache = cache.NewMemcached("capi", config.Cache.MemcachedServers...)
+		mcache, err := cache.NewMemcached("capi", config.Cache.MemcachedServers...)
+		if err != nil {
+			logger.Fatal("failed to create memcache cache",
+				zap.Error(err),
+			)
+		}
+		config.queryCache = mcache
 		// find cache is only used if SendGlobsAsIs is false.
 		if !config.SendGlobsAsIs {
 			config.findCache = cache.NewExpireCache(0)
 		}
 
-		mcache := config.queryCache.(*cache.MemcachedCache)
-
 		apiMetrics.MemcacheTimeouts = expvar.Func(func() interface{} {
 			return mcache.Timeouts()
 		})
@@ -491,13 +539,7 @@ func setUpConfig(logger *zap.Logger) {
 		graphite := g2g.NewGraphite(host, config.Graphite.Interval, 10*time.Second)
 
 		hostname, _ := os.Hostname()
-		hostname = strings.Replace(hostname, ".", "_", -1)
-
-		prefix := config.Graphite.Prefix
-
-		pattern := config.Graphite.Pattern
-		pattern = strings.Replace(pattern, "{prefix}", prefix, -1)
-		pattern = strings.Replace(pattern, "{fqdn}", hostname, -1)
+		pattern := instance.GraphitePattern(config.Graphite.Pattern, config.Graphite.Prefix, config.Instance, hostname)
 
 		graphite.Register(fmt.Sprintf("%s.requests", pattern), apiMetrics.Requests)
 		graphite.Register(fmt.Sprintf("%s.request_cache_hits", pattern), apiMetrics.RequestCacheHits)
@@ -534,6 +576,25 @@ func setUpConfig(logger *zap.Logger) {
 		graphite.Register(fmt.Sprintf("%s.zipper.info_errors", pattern), zipperMetrics.InfoErrors)
 
 		graphite.Register(fmt.Sprintf("%s.zipper.timeouts", pattern), zipperMetrics.Timeouts)
+		graphite.Register(fmt.Sprintf("%s.zipper.not_found", pattern), zipperMetrics.NotFound)
+		graphite.Register(fmt.Sprintf("%s.zipper.retry_budget_exhausted", pattern), zipperMetrics.RetryBudgetExhausted)
+		graphite.Register(fmt.Sprintf("%s.zipper.decode_errors", pattern), zipperMetrics.DecodeErrors)
+		for _, kind := range zipperHelper.AnomalyKinds {
+			kind := kind
+			graphite.Register(fmt.Sprintf("%s.zipper.decode_anomalies.%s", pattern, kind), expvar.Func(func() interface{} { return zipperHelper.DecodeAnomalies(kind) }))
+		}
+		graphite.Register(fmt.Sprintf("%s.zipper.quarantined_servers", pattern), zipperMetrics.QuarantinedServers)
+		graphite.Register(fmt.Sprintf("%s.zipper.divergent_points", pattern), zipperMetrics.DivergentPoints)
+		graphite.Register(fmt.Sprintf("%s.zipper.replica_mismatches", pattern), zipperMetrics.MismatchedPoints)
+		graphite.Register(fmt.Sprintf("%s.zipper.protocol_downgrades", pattern), zipperMetrics.ProtocolDowngrades)
+		graphite.Register(fmt.Sprintf("%s.zipper.carbonlink_errors", pattern), zipperMetrics.CarbonlinkErrors)
+		graphite.Register(fmt.Sprintf("%s.zipper.truncated_responses", pattern), zipperMetrics.TruncatedResponses)
+
+		for name, h := range phases.All() {
+			for bucket, v := range h.Buckets() {
+				graphite.Register(fmt.Sprintf("%s.phases.%s.%s", pattern, name, bucket), v)
+			}
+		}
 
 		graphite.Register(fmt.Sprintf("%s.zipper.cache_hits", pattern), zipperMetrics.CacheHits)
 		graphite.Register(fmt.Sprintf("%s.zipper.cache_misses", pattern), zipperMetrics.CacheMisses)
@@ -615,6 +676,7 @@ func setUpViper(logger *zap.Logger, configPath *string, viperPrefix string) {
 	viper.SetDefault("graphite.interval", "60s")
 	viper.SetDefault("graphite.prefix", "carbon.api")
 	viper.SetDefault("graphite.pattern", "{prefix}.{fqdn}")
+	viper.SetDefault("instance", "")
 	viper.SetDefault("idleConnections", 10)
 	viper.SetDefault("pidFile", "")
 	viper.SetDefault("upstreams.internalRoutingCache", "600s")
@@ -675,7 +737,7 @@ func renderTimeBuckets() interface{} {
 }
 
 func bucketRequestTimes(req *http.Request, t time.Duration) {
-	logger := zapwriter.Logger("slow")
+	logger := instance.Logger("slow")
 
 	ms := t.Nanoseconds() / int64(time.Millisecond)
 
@@ -698,7 +760,7 @@ func main() {
 	if err != nil {
 		log.Fatal("Failed to initialize logger with default configuration")
 	}
-	logger := zapwriter.Logger("main")
+	logger := instance.Logger("main")
 
 	configPath := flag.String("config", "", "Path to the `config file`.")
 	envPrefix := flag.String("envprefix", "CARBONAPI_", "Preifx for environment variables override")
@@ -710,7 +772,7 @@ func main() {
 	setUpConfigUpstreams(logger)
 	setUpConfig(logger)
 
-	config.zipper = newZipper(zipperStats, &config.Upstreams, config.IgnoreClientTimeout, zapwriter.Logger("zipper"))
+	config.zipper = newZipper(zipperStats, &config.Upstreams, config.IgnoreClientTimeout, instance.Logger("zipper"))
 
 	r := initHandlers()
 	handler := handlers.CompressHandler(r)
@@ -136,6 +136,17 @@ func TestRenderHandler(t *testing.T) {
 	}
 }
 
+func TestRenderHandlerDuplicateTargets(t *testing.T) {
+	req, rr := setUpRequest(t, "/render/?target=foo.bar&target=alias(foo.bar,'x')&target=foo.bar&from=-10minutes&format=json")
+	renderHandler(rr, req)
+
+	series := `"datapoints":[[null,1510913280],[1510913759,1510913340],[1510913818,1510913400]]}`
+	expected := `[{"target":"foo.bar",` + series + `,{"target":"x",` + series + `,{"target":"foo.bar",` + series + `]`
+
+	assert.Equal(t, http.StatusOK, rr.Code, "HttpStatusCode should be 200 OK.")
+	assert.Equal(t, expected, rr.Body.String(), "Repeated target should be returned in its position.")
+}
+
 func TestFindHandler(t *testing.T) {
 	req, rr := setUpRequest(t, "/metrics/find/?query=foo.bar&format=json")
 	findHandler(rr, req)
@@ -152,6 +163,25 @@ func TestFindHandler(t *testing.T) {
 	}
 }
 
+func TestFindTreejsonLeafAndBranch(t *testing.T) {
+	globs := &pb.MultiGlobResponse{
+		Metrics: []pb.GlobResponse{{
+			Name: "foo.*",
+			Matches: []pb.GlobMatch{
+				{Path: "foo.bar", IsLeaf: false},
+				{Path: "foo.baz", IsLeaf: true},
+				{Path: "foo.bar", IsLeaf: true},
+			},
+		}},
+	}
+
+	b, err := findTreejson(globs)
+	assert.NoError(t, err)
+	expected := `[{"allowChildren":1,"expandable":1,"leaf":1,"id":"foo.bar","text":"bar","context":{}},` +
+		`{"allowChildren":0,"expandable":0,"leaf":1,"id":"foo.baz","text":"baz","context":{}}]` + "\n"
+	assert.Equal(t, expected, string(b), "Path that is both leaf and branch should be returned once with both flags.")
+}
+
 func TestInfoHandler(t *testing.T) {
 	req, rr := setUpRequest(t, "/info/?target=foo.bar&format=json")
 	infoHandler(rr, req)
@@ -173,3 +203,38 @@ func TestInfoHandler(t *testing.T) {
 		t.Error("Http response should be same.")
 	}
 }
+
+func TestExprDebugHandler(t *testing.T) {
+	url := "/debug/expr/?target=sumSeries(foo.bar)&from=1510913280&until=1510913880&eval=1"
+
+	req, rr := setUpRequest(t, url)
+	exprDebugHandler(rr, req)
+	assert.Equal(t, http.StatusNotFound, rr.Code, "Endpoint should be disabled by default.")
+
+	config.ExprDebug = exprDebugConfig{Enabled: true, Username: "debug", Password: "secret"}
+	defer func() { config.ExprDebug = exprDebugConfig{} }()
+
+	req, rr = setUpRequest(t, url)
+	req.SetBasicAuth("debug", "wrong")
+	exprDebugHandler(rr, req)
+	assert.Equal(t, http.StatusUnauthorized, rr.Code, "Wrong credentials should be rejected.")
+
+	req, rr = setUpRequest(t, url)
+	req.SetBasicAuth("debug", "secret")
+	exprDebugHandler(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code, "HttpStatusCode should be 200 OK.")
+
+	var res exprDebugResponse
+	err := json.Unmarshal(rr.Body.Bytes(), &res)
+	assert.Nil(t, err)
+	assert.Equal(t, "func", res.Tree.Type)
+	assert.Equal(t, "sumSeries", res.Tree.Target)
+	assert.Equal(t, []exprDebugFetch{{Metric: "foo.bar", From: 1510913280, Until: 1510913880}}, res.Fetches)
+	assert.Empty(t, res.Errors)
+	assert.Equal(t, `[{"target":"sumSeries(foo.bar)","datapoints":[[null,1510913280],[1510913759,1510913340],[1510913818,1510913400]]}]`, string(res.Result))
+
+	b, err := json.Marshal(config)
+	assert.Nil(t, err)
+	assert.NotContains(t, string(b), "secret", "Password should be hidden in the encoded config.")
+	assert.Equal(t, "secret", config.ExprDebug.Password)
+}
@@ -100,6 +100,7 @@ func (z zipper) Render(ctx context.Context, request pb.MultiFetchRequest) ([]*ty
 	if z.ignoreClientTimeout {
 		uuid := util.GetUUID(ctx)
 		newCtx = util.SetUUID(context.Background(), uuid)
+		newCtx = util.SetStrict(newCtx, util.GetStrict(ctx))
 	}
 
 	pbresp, stats, err := z.z.FetchProtoV3(newCtx, &request)
@@ -126,6 +127,7 @@ func (z zipper) RenderCompat(ctx context.Context, metrics []string, from, until
 	if z.ignoreClientTimeout {
 		uuid := util.GetUUID(ctx)
 		newCtx = util.SetUUID(context.Background(), uuid)
+		newCtx = util.SetStrict(newCtx, util.GetStrict(ctx))
 	}
 
 	req := pb.MultiFetchRequest{}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/go-graphite/carbonapi/util/instance"
+	"go.uber.org/zap"
+)
+
+var errInvalidServer = errors.New("server must be http(s)://host[:port]")
+
+// AdminConfig allows identities (see AuthorizationConfig.IdentityHeader) that present one of the Tokens as a bearer
+// token to use admin endpoints. Identities may be globs. Tokens may also be read from TokensFile, one per line.
+// Admin endpoints are disabled if identity header, identities or tokens are not set, as identity header alone is only
+// as trusted as the client that sends it.
+type AdminConfig struct {
+	Identities []string `mapstructure:"identities"`
+	Tokens     []string `mapstructure:"tokens"`
+	TokensFile string   `mapstructure:"tokensFile"`
+	// Servers are globs of host:port that may be added to the backend groups. Servers that groups don't have are
+	// refused if there are none.
+	Servers []string `mapstructure:"servers"`
+}
+
+// MarshalJSON hides tokens, as config is logged and exported via expvar
+func (c AdminConfig) MarshalJSON() ([]byte, error) {
+	type config AdminConfig
+	hidden := config(c)
+	if len(hidden.Tokens) > 0 {
+		hidden.Tokens = make([]string, len(c.Tokens))
+		for i := range hidden.Tokens {
+			hidden.Tokens[i] = "<hidden>"
+		}
+	}
+	return json.Marshal(hidden)
+}
+
+// adminTokens returns tokens of the config and of the tokens file
+func adminTokens(c AdminConfig) ([]string, error) {
+	if c.TokensFile == "" {
+		return c.Tokens, nil
+	}
+	b, err := ioutil.ReadFile(c.TokensFile)
+	if err != nil {
+		return nil, err
+	}
+	tokens := append([]string{}, c.Tokens...)
+	for _, t := range strings.Split(string(b), "\n") {
+		if t = strings.TrimSpace(t); t != "" {
+			tokens = append(tokens, t)
+		}
+	}
+	return tokens, nil
+}
+
+// adminAccess checks credentials of admin requests
+type adminAccess struct {
+	header     string
+	identities []string
+	tokens     []string
+}
+
+func newAdminAccess(identityHeader string, c AdminConfig) *adminAccess {
+	tokens, err := adminTokens(c)
+	if err != nil {
+		instance.Logger("admin").Error("failed to read admin tokens, admin API is disabled",
+			zap.String("file", c.TokensFile),
+			zap.Error(err),
+		)
+		return nil
+	}
+	if identityHeader == "" || len(c.Identities) == 0 || len(tokens) == 0 {
+		return nil
+	}
+	return &adminAccess{
+		header:     identityHeader,
+		identities: c.Identities,
+		tokens:     tokens,
+	}
+}
+
+// check returns identity of the request, or the reason it's refused
+func (a *adminAccess) check(req *http.Request) (string, string) {
+	if a == nil {
+		return "", "admin API is disabled"
+	}
+	auth := req.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return "", "admin API is not allowed"
+	}
+	token := []byte(strings.TrimPrefix(auth, "Bearer "))
+	valid := false
+	for _, t := range a.tokens {
+		if subtle.ConstantTimeCompare(token, []byte(t)) == 1 {
+			valid = true
+		}
+	}
+	identity := req.Header.Get(a.header)
+	if !valid || identity == "" || !matchesAny(a.identities, identity, identityMatch) {
+		return "", "admin API is not allowed"
+	}
+	return identity, ""
+}
+
+// wrap refuses requests that aren't made by admins
+func (a *adminAccess) wrap(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if _, reason := a.check(req); reason != "" {
+			http.Error(w, reason, http.StatusForbidden)
+			return
+		}
+		h(w, req)
+	}
+}
+
+// serverHost returns host:port of the server that is added with admin API, servers with path, credentials or
+// scheme other than http(s) are refused
+func serverHost(server string) (string, error) {
+	u, err := url.Parse(server)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.User != nil ||
+		(u.Path != "" && u.Path != "/") || u.RawQuery != "" || u.Fragment != "" {
+		return "", errInvalidServer
+	}
+	return u.Host, nil
+}
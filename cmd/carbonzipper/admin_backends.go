@@ -0,0 +1,354 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-graphite/carbonapi/util/instance"
+	"github.com/go-graphite/carbonapi/zipper/types"
+	"go.uber.org/zap"
+)
+
+var (
+	errUnknownGroup  = errors.New("unknown backend group")
+	errUnknownServer = errors.New("server is not in the group")
+	errServerDenied  = errors.New("server is not allowed")
+
+	errMethodNotAllowed = errors.New("method not allowed")
+)
+
+// legacyBackendsGroup is the name of the group that admin API uses for servers of the old backends list
+const legacyBackendsGroup = "backends"
+
+const (
+	backendActive  = "active"
+	backendDrained = "drained"
+)
+
+// backendChanges are servers that were added, drained or removed with admin API, by group. They are applied over
+// resolved servers of the config, so they are kept when config is reloaded or servers are resolved again, till
+// restart. Drained servers are kept in the group, but no new requests are sent to them.
+type backendChanges struct {
+	added   map[string][]string
+	drained map[string]map[string]bool
+	removed map[string]map[string]bool
+}
+
+// runtimeBackends are current changes, guarded by configUpdates
+var runtimeBackends backendChanges
+
+func (b backendChanges) clone() backendChanges {
+	res := backendChanges{
+		added:   make(map[string][]string, len(b.added)),
+		drained: make(map[string]map[string]bool, len(b.drained)),
+		removed: make(map[string]map[string]bool, len(b.removed)),
+	}
+	for group, servers := range b.added {
+		res.added[group] = append([]string(nil), servers...)
+	}
+	for _, m := range []struct{ dst, src map[string]map[string]bool }{{res.drained, b.drained}, {res.removed, b.removed}} {
+		for group, servers := range m.src {
+			m.dst[group] = make(map[string]bool, len(servers))
+			for server := range servers {
+				m.dst[group][server] = true
+			}
+		}
+	}
+	return res
+}
+
+func contains(servers []string, server string) bool {
+	for _, s := range servers {
+		if s == server {
+			return true
+		}
+	}
+	return false
+}
+
+// pool returns servers of the group including the drained ones
+func (b backendChanges) pool(group string, servers []string) []string {
+	res := make([]string, 0, len(servers)+len(b.added[group]))
+	for _, s := range servers {
+		if !b.removed[group][s] {
+			res = append(res, s)
+		}
+	}
+	for _, s := range b.added[group] {
+		if !contains(res, s) {
+			res = append(res, s)
+		}
+	}
+	return res
+}
+
+// active returns servers of the group that requests are sent to
+func (b backendChanges) active(group string, servers []string) []string {
+	pool := b.pool(group, servers)
+	res := make([]string, 0, len(pool))
+	for _, s := range pool {
+		if !b.drained[group][s] {
+			res = append(res, s)
+		}
+	}
+	return res
+}
+
+// groupServers returns resolved servers of the group
+func groupServers(c *carbonzipperConfig, group string) ([]string, bool) {
+	// old backends list is used instead of backendsv2 if it's set
+	if len(c.Backends) > 0 {
+		return c.Backends, group == legacyBackendsGroup
+	}
+	for _, backend := range c.Backendsv2.Backends {
+		if backend.GroupName == group {
+			return backend.Servers, true
+		}
+	}
+	return nil, false
+}
+
+// apply returns copy of the resolved config with active servers only
+func (b backendChanges) apply(c *carbonzipperConfig) *carbonzipperConfig {
+	res := *c
+	if len(c.Backends) > 0 {
+		res.Backends = b.active(legacyBackendsGroup, c.Backends)
+		return &res
+	}
+	groups := make([]types.BackendV2, len(c.Backendsv2.Backends))
+	for i, backend := range c.Backendsv2.Backends {
+		backend.Servers = b.active(backend.GroupName, backend.Servers)
+		groups[i] = backend
+	}
+	res.Backendsv2.Backends = groups
+	return &res
+}
+
+// add adds server to the group, drained server becomes active again
+func (b backendChanges) add(group, server string) {
+	delete(b.removed[group], server)
+	delete(b.drained[group], server)
+	if !contains(b.added[group], server) {
+		b.added[group] = append(b.added[group], server)
+	}
+}
+
+// drain stops sending new requests to the server of the group
+func (b backendChanges) drain(group, server string, servers []string) error {
+	if !contains(b.pool(group, servers), server) {
+		return errUnknownServer
+	}
+	if b.drained[group] == nil {
+		b.drained[group] = make(map[string]bool)
+	}
+	b.drained[group][server] = true
+	return nil
+}
+
+// remove removes server from the group
+func (b backendChanges) remove(group, server string, servers []string) error {
+	if !contains(b.pool(group, servers), server) {
+		return errUnknownServer
+	}
+	delete(b.drained[group], server)
+	var added []string
+	for _, s := range b.added[group] {
+		if s != server {
+			added = append(added, s)
+		}
+	}
+	b.added[group] = added
+	if contains(servers, server) {
+		if b.removed[group] == nil {
+			b.removed[group] = make(map[string]bool)
+		}
+		b.removed[group][server] = true
+	}
+	return nil
+}
+
+// adminBackend is the server of the group in /admin/backends response
+type adminBackend struct {
+	Server string `json:"server"`
+	State  string `json:"state"`
+}
+
+// adminGroup is the group in /admin/backends response
+type adminGroup struct {
+	Group   string         `json:"group"`
+	Servers []adminBackend `json:"servers"`
+}
+
+// status returns servers of every group of the resolved config and their states
+func (b backendChanges) status(c *carbonzipperConfig) []adminGroup {
+	var groups []string
+	if len(c.Backends) > 0 {
+		groups = append(groups, legacyBackendsGroup)
+	} else {
+		for _, backend := range c.Backendsv2.Backends {
+			groups = append(groups, backend.GroupName)
+		}
+	}
+
+	res := make([]adminGroup, 0, len(groups))
+	for _, group := range groups {
+		servers, _ := groupServers(c, group)
+		g := adminGroup{Group: group, Servers: make([]adminBackend, 0, len(servers))}
+		for _, s := range b.pool(group, servers) {
+			state := backendActive
+			if b.drained[group][s] {
+				state = backendDrained
+			}
+			g.Servers = append(g.Servers, adminBackend{Server: s, State: state})
+		}
+		res = append(res, g)
+	}
+	return res
+}
+
+// backendsAdmin serves /admin/backends:
+//
+//	GET    /admin/backends                            - servers of every group and their states
+//	POST   /admin/backends?group=...&server=...       - adds server to the group or makes drained server active
+//	POST   /admin/backends/drain?group=...&server=... - stops sending new requests to the server
+//	DELETE /admin/backends?group=...&server=...       - removes server from the group
+//
+// Zipper is recreated with the changed servers, requests that are in flight are finished by the old one.
+type backendsAdmin struct {
+	access  *adminAccess
+	servers []string
+}
+
+func newBackendsAdmin(access *adminAccess, c AdminConfig) *backendsAdmin {
+	if access == nil {
+		return nil
+	}
+	return &backendsAdmin{
+		access:  access,
+		servers: c.Servers,
+	}
+}
+
+// allowedServer checks that server may be added to the group, either it's known to the group or it matches the
+// allowed servers
+func (a *backendsAdmin) allowedServer(server string, known []string) error {
+	host, err := serverHost(server)
+	if err != nil {
+		return err
+	}
+	if !contains(known, server) && !matchesAny(a.servers, host, identityMatch) {
+		return errServerDenied
+	}
+	return nil
+}
+
+func (a *backendsAdmin) handler(w http.ResponseWriter, req *http.Request) {
+	t0 := time.Now()
+	accessLogger := instance.Logger("access").With(
+		zap.String("handler", "admin_backends"),
+		zap.String("method", req.Method),
+	)
+	fail := func(code int, reason string) {
+		http.Error(w, reason, code)
+		accessLogger.Error("request failed",
+			zap.String("reason", reason),
+			zap.Int("http_code", code),
+			zap.Duration("runtime_seconds", time.Since(t0)),
+		)
+	}
+
+	var access *adminAccess
+	if a != nil {
+		access = a.access
+	}
+	identity, reason := access.check(req)
+	if reason != "" {
+		fail(http.StatusForbidden, reason)
+		return
+	}
+	accessLogger = accessLogger.With(zap.String("identity", identity))
+
+	group, server := req.FormValue("group"), req.FormValue("server")
+	drain := strings.HasSuffix(strings.TrimSuffix(req.URL.Path, "/"), "/drain")
+	if req.Method != "GET" && (group == "" || server == "") {
+		fail(http.StatusBadRequest, "group and server must be set")
+		return
+	}
+
+	var status []adminGroup
+	if req.Method == "GET" {
+		configUpdates.Lock()
+		if discovered != nil {
+			status = runtimeBackends.status(discovered)
+		}
+		configUpdates.Unlock()
+		w.Header().Set("Content-Type", contentTypeJSON)
+		json.NewEncoder(w).Encode(status)
+		return
+	}
+
+	err := updateConfig(func(c *carbonzipperConfig) error {
+		if discovered == nil {
+			return errNoBackends
+		}
+		servers, ok := groupServers(discovered, group)
+		if !ok {
+			return errUnknownGroup
+		}
+		changes := runtimeBackends.clone()
+		var err error
+		switch {
+		case req.Method == "POST" && drain:
+			err = changes.drain(group, server, servers)
+		case req.Method == "POST":
+			if err := a.allowedServer(server, append(changes.pool(group, servers), servers...)); err != nil {
+				return err
+			}
+			changes.add(group, server)
+		case req.Method == "DELETE" && !drain:
+			err = changes.remove(group, server, servers)
+		default:
+			return errMethodNotAllowed
+		}
+		if err != nil {
+			return err
+		}
+
+		current := runtimeBackends
+		runtimeBackends = changes
+		if err := replaceZipper(discovered); err != nil {
+			runtimeBackends = current
+			return err
+		}
+		status = runtimeBackends.status(discovered)
+		return nil
+	})
+	switch err {
+	case nil:
+	case errUnknownGroup, errUnknownServer:
+		fail(http.StatusNotFound, err.Error())
+		return
+	case errMethodNotAllowed:
+		fail(http.StatusMethodNotAllowed, err.Error())
+		return
+	case errServerDenied:
+		fail(http.StatusForbidden, err.Error())
+		return
+	default:
+		fail(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", contentTypeJSON)
+	json.NewEncoder(w).Encode(status)
+	accessLogger.Info("backends changed",
+		zap.String("group", group),
+		zap.String("server", server),
+		zap.Bool("drain", drain),
+		zap.Int("http_code", http.StatusOK),
+		zap.Duration("runtime_seconds", time.Since(t0)),
+	)
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/go-graphite/carbonapi/zipper/types"
+)
+
+func TestBackendChanges(t *testing.T) {
+	c := &carbonzipperConfig{Backendsv2: types.BackendsV2{Backends: []types.BackendV2{
+		{GroupName: "group1", Servers: []string{"http://a", "http://b", "http://c"}},
+		{GroupName: "group2", Servers: []string{"http://d"}},
+	}}}
+	servers, _ := groupServers(c, "group1")
+
+	changes := backendChanges{}.clone()
+	changes.add("group1", "http://e")
+	if err := changes.drain("group1", "http://b", servers); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if err := changes.remove("group1", "http://c", servers); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if err := changes.drain("group1", "http://c", servers); err != errUnknownServer {
+		t.Fatalf("removed server can't be drained, got %v", err)
+	}
+
+	resolved := changes.apply(c)
+	if got := backendServers(resolved); !reflect.DeepEqual(got, [][]string{nil, {"http://a", "http://e"}, {"http://d"}}) {
+		t.Fatalf("unexpected active servers %v", got)
+	}
+	if c.Backendsv2.Backends[0].Servers[2] != "http://c" {
+		t.Fatalf("resolved config was modified")
+	}
+
+	expected := []adminGroup{
+		{Group: "group1", Servers: []adminBackend{{"http://a", backendActive}, {"http://b", backendDrained}, {"http://e", backendActive}}},
+		{Group: "group2", Servers: []adminBackend{{"http://d", backendActive}}},
+	}
+	if got := changes.status(c); !reflect.DeepEqual(got, expected) {
+		t.Fatalf("expected status %+v, got %+v", expected, got)
+	}
+
+	// changes of the clone don't affect the original
+	clone := changes.clone()
+	clone.add("group1", "http://b")
+	clone.add("group1", "http://c")
+	if err := clone.remove("group1", "http://e", servers); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if got := backendServers(clone.apply(c)); !reflect.DeepEqual(got, [][]string{nil, {"http://a", "http://b", "http://c"}, {"http://d"}}) {
+		t.Fatalf("unexpected active servers of the clone %v", got)
+	}
+	if got := backendServers(changes.apply(c)); !reflect.DeepEqual(got, [][]string{nil, {"http://a", "http://e"}, {"http://d"}}) {
+		t.Fatalf("original changes were modified: %v", got)
+	}
+
+	// servers of the old backends list are the "backends" group
+	legacy := &carbonzipperConfig{Backends: []string{"http://a", "http://b"}}
+	legacyServers, ok := groupServers(legacy, legacyBackendsGroup)
+	if !ok {
+		t.Fatalf("legacy group not found")
+	}
+	changes = backendChanges{}.clone()
+	changes.remove(legacyBackendsGroup, "http://a", legacyServers)
+	if got := changes.apply(legacy).Backends; !reflect.DeepEqual(got, []string{"http://b"}) {
+		t.Fatalf("unexpected legacy servers %v", got)
+	}
+}
+
+func TestBackendsAdminAccess(t *testing.T) {
+	defer func(c *carbonzipperConfig) { discovered = c }(discovered)
+	discovered = &carbonzipperConfig{Backendsv2: types.BackendsV2{Backends: []types.BackendV2{
+		{GroupName: "group1", Servers: []string{"http://a"}},
+	}}}
+	config := AdminConfig{Identities: []string{"admin-*"}, Tokens: []string{"secret"}, Servers: []string{"10.0.0.*:8080"}}
+	if newAdminAccess("", config) != nil {
+		t.Fatalf("admin API can't be enabled without identity header")
+	}
+	if newAdminAccess("X-Identity", AdminConfig{Identities: config.Identities}) != nil {
+		t.Fatalf("admin API can't be enabled without tokens")
+	}
+	admin := newBackendsAdmin(newAdminAccess("X-Identity", config), config)
+
+	tests := []struct {
+		name     string
+		admin    *backendsAdmin
+		method   string
+		url      string
+		identity string
+		token    string
+		code     int
+	}{
+		{"disabled", nil, "GET", "/admin/backends", "admin-joe", "secret", http.StatusForbidden},
+		{"not admin", admin, "GET", "/admin/backends", "joe", "secret", http.StatusForbidden},
+		{"no token", admin, "GET", "/admin/backends", "admin-joe", "", http.StatusForbidden},
+		{"wrong token", admin, "GET", "/admin/backends", "admin-joe", "guess", http.StatusForbidden},
+		{"list", admin, "GET", "/admin/backends", "admin-joe", "secret", http.StatusOK},
+		{"no server", admin, "POST", "/admin/backends?group=group1", "admin-joe", "secret", http.StatusBadRequest},
+		{"unknown group", admin, "POST", "/admin/backends?group=group2&server=http://b", "admin-joe", "secret", http.StatusNotFound},
+		{"invalid server", admin, "POST", "/admin/backends?group=group1&server=http://10.0.0.1:8080/x?y", "admin-joe", "secret", http.StatusBadRequest},
+		{"server not allowed", admin, "POST", "/admin/backends?group=group1&server=http://evil:8080", "admin-joe", "secret", http.StatusForbidden},
+		{"unknown server", admin, "DELETE", "/admin/backends?group=group1&server=http://b", "admin-joe", "secret", http.StatusNotFound},
+		{"drain with delete", admin, "DELETE", "/admin/backends/drain?group=group1&server=http://a", "admin-joe", "secret", http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.url, nil)
+			req.Header.Set("X-Identity", tt.identity)
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			w := httptest.NewRecorder()
+			tt.admin.handler(w, req)
+			if w.Code != tt.code {
+				t.Fatalf("expected code %v, got %v: %v", tt.code, w.Code, w.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestAdminConfigHidesTokens(t *testing.T) {
+	config := carbonzipperConfig{Admin: AdminConfig{Identities: []string{"admin-*"}, Tokens: []string{"secret1", "secret2"}}}
+	b, err := json.Marshal(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(b), "secret") {
+		t.Fatalf("tokens are visible in the encoded config: %s", b)
+	}
+	if !strings.Contains(string(b), "admin-*") {
+		t.Fatalf("identities are missing in the encoded config: %s", b)
+	}
+	if config.Admin.Tokens[0] != "secret1" {
+		t.Fatalf("tokens of the config were modified: %v", config.Admin.Tokens)
+	}
+}
+
+func TestAdminTokensFile(t *testing.T) {
+	f, err := ioutil.TempFile("", "admin-tokens")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.WriteString("secret2\n\n secret3 \n"); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	tokens, err := adminTokens(AdminConfig{Tokens: []string{"secret1"}, TokensFile: f.Name()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(tokens, []string{"secret1", "secret2", "secret3"}) {
+		t.Fatalf("unexpected tokens %v", tokens)
+	}
+
+	if newAdminAccess("X-Identity", AdminConfig{Identities: []string{"admin-*"}, TokensFile: f.Name() + ".missing"}) != nil {
+		t.Fatalf("admin API should be disabled if tokens can't be read")
+	}
+}
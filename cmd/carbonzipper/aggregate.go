@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/go-graphite/carbonapi/pkg/parser"
+	protov2 "github.com/go-graphite/protocol/carbonapi_v2_pb"
+)
+
+type aggregateFunc func([]float64) float64
+
+// aggregations contains functions that carbonzipper can evaluate by itself, so clients receive one series instead of
+// all of the matched ones.
+var aggregations = map[string]aggregateFunc{
+	"sumSeries":     aggSum,
+	"averageSeries": aggAvg,
+	"maxSeries":     aggMax,
+}
+
+func aggSum(values []float64) float64 {
+	sum := 0.0
+	for _, v := range values {
+		sum += v
+	}
+	return sum
+}
+
+func aggAvg(values []float64) float64 {
+	return aggSum(values) / float64(len(values))
+}
+
+func aggMax(values []float64) float64 {
+	max := values[0]
+	for _, v := range values[1:] {
+		if v > max {
+			max = v
+		}
+	}
+	return max
+}
+
+// aggregationTarget is a target like sumSeries(foo.*.bar) that carbonzipper can evaluate
+type aggregationTarget struct {
+	name    string
+	metrics []string
+	f       aggregateFunc
+}
+
+// parseAggregation checks if target is one of supported aggregations over plain metric names or globs
+func parseAggregation(target string) (aggregationTarget, bool) {
+	e, rest, err := parser.ParseExpr(target)
+	if err != nil || rest != "" {
+		return aggregationTarget{}, false
+	}
+	return parseAggregationExpr(e)
+}
+
+func parseAggregationExpr(e parser.Expr) (aggregationTarget, bool) {
+	if !e.IsFunc() {
+		return aggregationTarget{}, false
+	}
+
+	f, ok := aggregations[e.Target()]
+	if !ok || len(e.Args()) == 0 || len(e.NamedArgs()) != 0 {
+		return aggregationTarget{}, false
+	}
+
+	t := aggregationTarget{
+		name: fmt.Sprintf("%s(%s)", e.Target(), e.RawArgs()),
+		f:    f,
+	}
+	for _, arg := range e.Args() {
+		if !arg.IsName() {
+			return aggregationTarget{}, false
+		}
+		t.metrics = append(t.metrics, arg.Target())
+	}
+
+	return t, true
+}
+
+// aggregate combines series into one. Series are aligned to the coarsest step, finer series are averaged first.
+func (t aggregationTarget) aggregate(series []protov2.FetchResponse) protov2.FetchResponse {
+	start, stop, step := series[0].StartTime, series[0].StopTime, series[0].StepTime
+	for _, s := range series[1:] {
+		if s.StartTime < start {
+			start = s.StartTime
+		}
+		if s.StopTime > stop {
+			stop = s.StopTime
+		}
+		if s.StepTime > step {
+			step = s.StepTime
+		}
+	}
+
+	n := int((stop - start + step - 1) / step)
+	buckets := make([][]float64, n)
+	sum := make([]float64, n)
+	cnt := make([]int, n)
+	for _, s := range series {
+		for i := range sum {
+			sum[i] = 0
+			cnt[i] = 0
+		}
+		for i, v := range s.Values {
+			if s.IsAbsent[i] {
+				continue
+			}
+			idx := int((s.StartTime + int32(i)*s.StepTime - start) / step)
+			if idx >= n {
+				break
+			}
+			sum[idx] += v
+			cnt[idx]++
+		}
+		for i := range sum {
+			if cnt[i] > 0 {
+				buckets[i] = append(buckets[i], sum[i]/float64(cnt[i]))
+			}
+		}
+	}
+
+	r := protov2.FetchResponse{
+		Name:      t.name,
+		StartTime: start,
+		StopTime:  start + int32(n)*step,
+		StepTime:  step,
+		Values:    make([]float64, n),
+		IsAbsent:  make([]bool, n),
+	}
+	for i, b := range buckets {
+		if len(b) == 0 {
+			r.IsAbsent[i] = true
+			continue
+		}
+		r.Values[i] = t.f(b)
+	}
+
+	return r
+}
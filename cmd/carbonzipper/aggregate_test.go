@@ -0,0 +1,72 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	protov2 "github.com/go-graphite/protocol/carbonapi_v2_pb"
+)
+
+func TestParseAggregation(t *testing.T) {
+	tests := []struct {
+		target  string
+		ok      bool
+		name    string
+		metrics []string
+	}{
+		{"sumSeries(host.*.cpu)", true, "sumSeries(host.*.cpu)", []string{"host.*.cpu"}},
+		{"maxSeries(a.b, c.d)", true, "maxSeries(a.b, c.d)", []string{"a.b", "c.d"}},
+		{"host.*.cpu", false, "", nil},
+		{"sumSeries(scale(host.*.cpu,8))", false, "", nil},
+		{"alias(host.*.cpu,'cpu')", false, "", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			a, ok := parseAggregation(tt.target)
+			if ok != tt.ok {
+				t.Fatalf("unexpected result, got %v, expected %v", ok, tt.ok)
+			}
+			if !ok {
+				return
+			}
+			if a.name != tt.name || !reflect.DeepEqual(a.metrics, tt.metrics) {
+				t.Fatalf("unexpected target, got %v %v, expected %v %v", a.name, a.metrics, tt.name, tt.metrics)
+			}
+		})
+	}
+}
+
+func TestAggregate(t *testing.T) {
+	a, _ := parseAggregation("sumSeries(foo.*)")
+	r := a.aggregate([]protov2.FetchResponse{
+		{
+			Name:      "foo.a",
+			StartTime: 60,
+			StopTime:  240,
+			StepTime:  60,
+			Values:    []float64{1, 0, 3},
+			IsAbsent:  []bool{false, true, false},
+		},
+		{
+			Name:      "foo.b",
+			StartTime: 120,
+			StopTime:  300,
+			StepTime:  60,
+			Values:    []float64{10, 0, 30},
+			IsAbsent:  []bool{false, true, false},
+		},
+	})
+
+	expected := protov2.FetchResponse{
+		Name:      "sumSeries(foo.*)",
+		StartTime: 60,
+		StopTime:  300,
+		StepTime:  60,
+		Values:    []float64{1, 10, 3, 30},
+		IsAbsent:  []bool{false, false, false, false},
+	}
+	if !reflect.DeepEqual(r, expected) {
+		t.Fatalf("unexpected result\ngot:      %+v\nexpected: %+v", r, expected)
+	}
+}
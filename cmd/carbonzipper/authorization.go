@@ -0,0 +1,276 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	protov2 "github.com/go-graphite/protocol/carbonapi_v2_pb"
+	protov3 "github.com/go-graphite/protocol/carbonapi_v3_pb"
+)
+
+// errForbidden is returned when identity of the request may not read some of the metrics it resolved to
+var errForbidden = errors.New("access to the metrics is denied")
+
+// authorizer decides whether identity may read all the metrics of the request. Metrics are the ones request
+// resolved to, after backends expanded the globs.
+type authorizer interface {
+	authorize(ctx context.Context, identity string, metrics []string) (bool, error)
+}
+
+// AuthorizationRule allows or denies metrics to identities. Metrics are graphite paths that may contain *, ? and
+// [...] in nodes, rule also applies to children of the path. Identities may be globs as well, "*" matches anyone.
+type AuthorizationRule struct {
+	Identities []string `mapstructure:"identities"`
+	Metrics    []string `mapstructure:"metrics"`
+	Allow      bool     `mapstructure:"allow"`
+}
+
+// AuthorizationConfig enables authorization of render and find requests. Identity is the value of IdentityHeader,
+// requests without it have empty identity. Every metric is decided by the first rule that matches it and the
+// identity, metrics that no rule matches are allowed unless DefaultDeny is set. If PolicyURL is set, request is
+// also sent to OPA compatible policy endpoint, both have to allow it.
+type AuthorizationConfig struct {
+	IdentityHeader string              `mapstructure:"identityHeader"`
+	Rules          []AuthorizationRule `mapstructure:"rules"`
+	DefaultDeny    bool                `mapstructure:"defaultDeny"`
+	PolicyURL      string              `mapstructure:"policyURL"`
+	PolicyTimeout  time.Duration       `mapstructure:"policyTimeout"`
+}
+
+// globMatch returns true if metric is pattern or one of its children. Nodes of the pattern are matched as path.Match
+// patterns.
+func globMatch(pattern, metric string) bool {
+	patternNodes := strings.Split(pattern, ".")
+	metricNodes := strings.Split(metric, ".")
+	if len(metricNodes) < len(patternNodes) {
+		return false
+	}
+	for i, p := range patternNodes {
+		if ok, err := path.Match(p, metricNodes[i]); err != nil || !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func matchesAny(patterns []string, s string, match func(pattern, s string) bool) bool {
+	for _, p := range patterns {
+		if match(p, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// ruleAuthorizer decides by the rules of the config
+type ruleAuthorizer struct {
+	rules       []AuthorizationRule
+	defaultDeny bool
+}
+
+func identityMatch(pattern, identity string) bool {
+	ok, err := path.Match(pattern, identity)
+	return err == nil && ok
+}
+
+func (a ruleAuthorizer) allowed(identity, metric string) bool {
+	for _, rule := range a.rules {
+		if matchesAny(rule.Identities, identity, identityMatch) && matchesAny(rule.Metrics, metric, globMatch) {
+			return rule.Allow
+		}
+	}
+	return !a.defaultDeny
+}
+
+func (a ruleAuthorizer) authorize(ctx context.Context, identity string, metrics []string) (bool, error) {
+	for _, m := range metrics {
+		if !a.allowed(identity, m) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// policyAuthorizer asks OPA compatible endpoint, e.x. http://opa:8181/v1/data/carbonzipper/allow. It's sent
+// {"input": {"identity": ..., "metrics": [...]}} and must answer {"result": true} to allow the request. Undefined
+// result is a denial.
+type policyAuthorizer struct {
+	url    string
+	client *http.Client
+}
+
+type policyInput struct {
+	Identity string   `json:"identity"`
+	Metrics  []string `json:"metrics"`
+}
+
+func (a policyAuthorizer) authorize(ctx context.Context, identity string, metrics []string) (bool, error) {
+	body, err := json.Marshal(map[string]policyInput{"input": {Identity: identity, Metrics: metrics}})
+	if err != nil {
+		return false, err
+	}
+	req, err := http.NewRequest("POST", a.url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", contentTypeJSON)
+	resp, err := a.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	body, err = ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return false, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("policy endpoint returned %d: %s", resp.StatusCode, string(body))
+	}
+
+	var res struct {
+		Result bool `json:"result"`
+	}
+	if err := json.Unmarshal(body, &res); err != nil {
+		return false, fmt.Errorf("can't decode response of the policy endpoint: %v", err)
+	}
+	return res.Result, nil
+}
+
+// authorization identifies the requests and checks them with all of the authorizers
+type authorization struct {
+	header      string
+	authorizers []authorizer
+}
+
+func newAuthorization(c AuthorizationConfig) *authorization {
+	if c.IdentityHeader == "" {
+		return nil
+	}
+	a := &authorization{header: c.IdentityHeader}
+	if len(c.Rules) > 0 || c.DefaultDeny {
+		a.authorizers = append(a.authorizers, ruleAuthorizer{rules: c.Rules, defaultDeny: c.DefaultDeny})
+	}
+	if c.PolicyURL != "" {
+		a.authorizers = append(a.authorizers, policyAuthorizer{
+			url:    c.PolicyURL,
+			client: &http.Client{Timeout: c.PolicyTimeout},
+		})
+	}
+	if len(a.authorizers) == 0 {
+		return nil
+	}
+	return a
+}
+
+type authorizationKey int
+
+const identityKey authorizationKey = 0
+
+type requestIdentity struct {
+	identity      string
+	authorization *authorization
+}
+
+// wrap marks requests served by h, so the metrics they resolve to are authorized
+func (a *authorization) wrap(h http.HandlerFunc) http.HandlerFunc {
+	if a == nil {
+		return h
+	}
+	return func(w http.ResponseWriter, req *http.Request) {
+		id := requestIdentity{identity: req.Header.Get(a.header), authorization: a}
+		h(w, req.WithContext(context.WithValue(req.Context(), identityKey, id)))
+	}
+}
+
+// authorizationRequired returns true if metrics of the request are authorized. Such requests can't be served
+// without knowing the names of the metrics, e.x. from precomputed results or with protobuf passthrough.
+func authorizationRequired(ctx context.Context) bool {
+	_, ok := ctx.Value(identityKey).(requestIdentity)
+	return ok
+}
+
+// authorizeMetrics returns errForbidden if identity of the request may not read any of the metrics
+func authorizeMetrics(ctx context.Context, metrics []string) error {
+	id, ok := ctx.Value(identityKey).(requestIdentity)
+	if !ok || len(metrics) == 0 {
+		return nil
+	}
+	for _, a := range id.authorization.authorizers {
+		allowed, err := a.authorize(ctx, id.identity, metrics)
+		if err != nil {
+			return err
+		}
+		if !allowed {
+			Metrics.AuthorizationDenied.Add(1)
+			return errForbidden
+		}
+	}
+	return nil
+}
+
+// deny refuses requests that have to be authorized, it's used for handlers that share results between clients
+func (a *authorization) deny(h http.HandlerFunc) http.HandlerFunc {
+	if a == nil {
+		return h
+	}
+	return func(w http.ResponseWriter, req *http.Request) {
+		http.Error(w, "not allowed when authorization is enabled", http.StatusForbidden)
+	}
+}
+
+func seriesNames(series []protov2.FetchResponse) []string {
+	names := make([]string, 0, len(series))
+	for _, s := range series {
+		names = append(names, s.Name)
+	}
+	return names
+}
+
+// leafPaths returns paths of metrics among find matches, other nodes are not authorized
+func leafPaths(matches []protov2.GlobMatch) []string {
+	var paths []string
+	for _, m := range matches {
+		if m.IsLeaf {
+			paths = append(paths, m.Path)
+		}
+	}
+	return paths
+}
+
+// infoNamesV2 returns names of the metrics in info response
+func infoNamesV2(res *protov2.ZipperInfoResponse) []string {
+	var names []string
+	if res == nil {
+		return names
+	}
+	for _, r := range res.Responses {
+		if r.Info != nil && r.Info.Name != "" {
+			names = append(names, r.Info.Name)
+		}
+	}
+	return names
+}
+
+// infoNamesV3 returns names of the metrics in info response
+func infoNamesV3(res *protov3.ZipperInfoResponse) []string {
+	var names []string
+	if res == nil {
+		return names
+	}
+	for _, info := range res.Info {
+		for _, m := range info.Metrics {
+			if m.Name != "" {
+				names = append(names, m.Name)
+			}
+		}
+	}
+	return names
+}
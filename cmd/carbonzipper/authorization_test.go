@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	protov2 "github.com/go-graphite/protocol/carbonapi_v2_pb"
+	protov3 "github.com/go-graphite/protocol/carbonapi_v3_pb"
+)
+
+func TestRuleAuthorizer(t *testing.T) {
+	a := ruleAuthorizer{rules: []AuthorizationRule{
+		{Identities: []string{"team-a"}, Metrics: []string{"team_b.*.latency"}, Allow: true},
+		{Identities: []string{"*"}, Metrics: []string{"team_b"}, Allow: false},
+	}}
+
+	tests := []struct {
+		identity string
+		metrics  []string
+		allowed  bool
+	}{
+		{"team-a", []string{"team_b.api.latency", "team_b.api.latency.p99"}, true},
+		{"team-a", []string{"team_b.api.latency", "team_b.kpi.revenue"}, false},
+		{"team-c", []string{"team_b.api.latency"}, false},
+		{"", []string{"other.metric"}, true},
+	}
+	for _, tt := range tests {
+		allowed, err := a.authorize(context.Background(), tt.identity, tt.metrics)
+		if err != nil || allowed != tt.allowed {
+			t.Errorf("%s %v: got %v, %v, expected %v", tt.identity, tt.metrics, allowed, err, tt.allowed)
+		}
+	}
+
+	a.defaultDeny = true
+	if allowed, _ := a.authorize(context.Background(), "", []string{"other.metric"}); allowed {
+		t.Errorf("metrics without rules should be denied by default")
+	}
+}
+
+func TestPolicyAuthorizer(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Input policyInput `json:"input"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if req.Input.Identity == "admin" {
+			_, _ = w.Write([]byte(`{"result": true}`))
+			return
+		}
+		// undefined decision
+		_, _ = w.Write([]byte(`{}`))
+	}))
+	defer srv.Close()
+
+	a := newAuthorization(AuthorizationConfig{IdentityHeader: "X-User", PolicyURL: srv.URL, PolicyTimeout: time.Second})
+	check := func(identity string) error {
+		var err error
+		h := a.wrap(func(w http.ResponseWriter, req *http.Request) {
+			if !authorizationRequired(req.Context()) {
+				t.Fatalf("request should be marked for authorization")
+			}
+			err = authorizeMetrics(req.Context(), []string{"foo.bar"})
+		})
+		req := httptest.NewRequest("GET", "/render/?target=foo.bar", nil)
+		req.Header.Set("X-User", identity)
+		h(httptest.NewRecorder(), req)
+		return err
+	}
+
+	if err := check("admin"); err != nil {
+		t.Fatalf("admin should be allowed, got %v", err)
+	}
+	denied := Metrics.AuthorizationDenied.Value()
+	if err := check("guest"); err != errForbidden {
+		t.Fatalf("undefined result should deny, got %v", err)
+	}
+	if Metrics.AuthorizationDenied.Value() != denied+1 {
+		t.Fatalf("denial should be counted")
+	}
+}
+
+func TestInfoNames(t *testing.T) {
+	v2 := &protov2.ZipperInfoResponse{Responses: []protov2.ServerInfoResponse{
+		{Server: "a", Info: &protov2.InfoResponse{Name: "team_b.cpu"}},
+		{Server: "b"},
+	}}
+	if got := infoNamesV2(v2); !reflect.DeepEqual(got, []string{"team_b.cpu"}) {
+		t.Fatalf("unexpected names of v2 response %v", got)
+	}
+	v3 := &protov3.ZipperInfoResponse{Info: map[string]protov3.MultiMetricsInfoResponse{
+		"a": {Metrics: []protov3.MetricsInfoResponse{{Name: "team_b.cpu"}, {Name: "team_b.mem"}}},
+	}}
+	if got := infoNamesV3(v3); !reflect.DeepEqual(got, []string{"team_b.cpu", "team_b.mem"}) {
+		t.Fatalf("unexpected names of v3 response %v", got)
+	}
+
+	a := &authorization{authorizers: []authorizer{ruleAuthorizer{rules: []AuthorizationRule{
+		{Identities: []string{"*"}, Metrics: []string{"team_b"}, Allow: false},
+	}}}}
+	ctx := context.WithValue(context.Background(), identityKey, requestIdentity{identity: "team-a", authorization: a})
+	if err := authorizeMetrics(ctx, infoNamesV3(v3)); err != errForbidden {
+		t.Fatalf("info of denied metrics should be forbidden, got %v", err)
+	}
+}
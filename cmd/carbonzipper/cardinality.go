@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"expvar"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	util "github.com/go-graphite/carbonapi/util/ctx"
+	"github.com/go-graphite/carbonapi/util/instance"
+	"github.com/satori/go.uuid"
+	"go.uber.org/zap"
+)
+
+// namespaceStats keeps amount of metrics per top-level namespace
+type namespaceStats struct {
+	sync.RWMutex
+	counts map[string]int64
+
+	// register is called once for every new namespace, so it can be sent to graphite
+	register func(namespace string, v expvar.Var)
+}
+
+func newNamespaceStats() *namespaceStats {
+	return &namespaceStats{
+		counts: make(map[string]int64),
+	}
+}
+
+type namespaceCounter struct {
+	stats     *namespaceStats
+	namespace string
+}
+
+func (c namespaceCounter) String() string {
+	c.stats.RLock()
+	defer c.stats.RUnlock()
+	return strconv.FormatInt(c.stats.counts[c.namespace], 10)
+}
+
+// countNamespaces returns amount of metrics per top-level namespace
+func countNamespaces(metrics []string) map[string]int64 {
+	counts := make(map[string]int64)
+	for _, m := range metrics {
+		ns := m
+		if i := strings.IndexByte(m, '.'); i != -1 {
+			ns = m[:i]
+		}
+		counts[ns]++
+	}
+	return counts
+}
+
+func (s *namespaceStats) update(counts map[string]int64) {
+	var added []string
+	s.Lock()
+	for ns := range s.counts {
+		if _, ok := counts[ns]; !ok {
+			s.counts[ns] = 0
+		}
+	}
+	for ns, cnt := range counts {
+		if _, ok := s.counts[ns]; !ok {
+			added = append(added, ns)
+		}
+		s.counts[ns] = cnt
+	}
+	s.Unlock()
+
+	if s.register != nil {
+		for _, ns := range added {
+			s.register(ns, namespaceCounter{stats: s, namespace: ns})
+		}
+	}
+}
+
+// Counts returns copy of current statistics
+func (s *namespaceStats) Counts() map[string]int64 {
+	s.RLock()
+	defer s.RUnlock()
+	res := make(map[string]int64, len(s.counts))
+	for ns, cnt := range s.counts {
+		res[ns] = cnt
+	}
+	return res
+}
+
+func (s *namespaceStats) refresh(timeout time.Duration) {
+	logger := instance.Logger("cardinality")
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	ctx = util.SetUUID(ctx, uuid.NewV4().String())
+
+	t0 := time.Now()
+	res, stats, err := getZipper().ListProtoV2(ctx)
+	sendStats(stats)
+	if err != nil {
+		logger.Warn("failed to list metrics", zap.Error(err))
+		return
+	}
+
+	s.update(countNamespaces(res.Metrics))
+	logger.Info("namespace statistics updated",
+		zap.Int("metrics", len(res.Metrics)),
+		zap.Duration("runtime_seconds", time.Since(t0)),
+	)
+}
+
+// run periodically lists all metrics on the backends and updates statistics
+func (s *namespaceStats) run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		s.refresh(interval)
+		<-ticker.C
+	}
+}
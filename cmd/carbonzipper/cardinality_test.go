@@ -0,0 +1,34 @@
+package main
+
+import (
+	"expvar"
+	"reflect"
+	"testing"
+)
+
+func TestNamespaceStats(t *testing.T) {
+	counts := countNamespaces([]string{"team1.a.b", "team1.c", "team2.x", "plain"})
+	expected := map[string]int64{"team1": 2, "team2": 1, "plain": 1}
+	if !reflect.DeepEqual(counts, expected) {
+		t.Fatalf("unexpected counts, got %v, expected %v", counts, expected)
+	}
+
+	registered := make(map[string]expvar.Var)
+	s := newNamespaceStats()
+	s.register = func(ns string, v expvar.Var) { registered[ns] = v }
+
+	s.update(counts)
+	if len(registered) != 3 || registered["team1"].String() != "2" {
+		t.Fatalf("unexpected registrations %v", registered)
+	}
+
+	// namespace that disappeared is reported as empty, existing ones are not registered again
+	delete(registered, "team1")
+	s.update(map[string]int64{"team1": 5})
+	if len(registered) != 2 || registered["team2"].String() != "0" {
+		t.Fatalf("unexpected registrations after update %v", registered)
+	}
+	if c := s.Counts(); c["team1"] != 5 || c["team2"] != 0 {
+		t.Fatalf("unexpected counts after update %v", c)
+	}
+}
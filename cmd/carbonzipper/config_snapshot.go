@@ -0,0 +1,48 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/go-graphite/carbonapi/util/instance"
+)
+
+// configSnapshot holds current *carbonzipperConfig. Snapshot is never modified once it's stored, config is changed
+// by storing the new one. Request handlers take the snapshot once and use it till the end of the request, so they
+// never see a half-applied change.
+var configSnapshot atomic.Value
+
+// configUpdates serializes writers, so concurrent updates aren't lost. Readers don't take it.
+var configUpdates sync.Mutex
+
+func init() {
+	setConfig(defaultConfig)
+}
+
+// getConfig returns current config snapshot, it must not be modified
+func getConfig() *carbonzipperConfig {
+	return configSnapshot.Load().(*carbonzipperConfig)
+}
+
+// setConfig replaces current snapshot with the copy of c
+func setConfig(c carbonzipperConfig) {
+	configUpdates.Lock()
+	configSnapshot.Store(&c)
+	instance.Set(c.Instance)
+	configUpdates.Unlock()
+}
+
+// updateConfig stores the copy of current snapshot changed by f, nothing is stored if f fails. Copy is shallow: f
+// has to replace slices and maps it changes instead of modifying them, as they are shared with the current snapshot.
+func updateConfig(f func(c *carbonzipperConfig) error) error {
+	configUpdates.Lock()
+	defer configUpdates.Unlock()
+
+	c := *getConfig()
+	if err := f(&c); err != nil {
+		return err
+	}
+	configSnapshot.Store(&c)
+	instance.Set(c.Instance)
+	return nil
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"testing"
+)
+
+func TestUpdateConfig(t *testing.T) {
+	defer setConfig(defaultConfig)
+
+	setConfig(carbonzipperConfig{Instance: "a"})
+	old := getConfig()
+
+	if err := updateConfig(func(c *carbonzipperConfig) error {
+		c.Instance = "b"
+		return errors.New("failed")
+	}); err == nil || getConfig() != old {
+		t.Fatalf("failed update should not be stored")
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_ = updateConfig(func(c *carbonzipperConfig) error {
+				c.MaxProcs++
+				return nil
+			})
+		}()
+	}
+	wg.Wait()
+
+	if c := getConfig(); c.MaxProcs != 10 || c.Instance != "a" {
+		t.Fatalf("concurrent updates should not be lost, got %+v", c)
+	}
+	if old.MaxProcs != 0 {
+		t.Fatalf("stored snapshot should not be modified, got %+v", old)
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"reflect"
+	"time"
+
+	"github.com/go-graphite/carbonapi/util/instance"
+	"github.com/go-graphite/carbonapi/zipper"
+	"github.com/go-graphite/carbonapi/zipper/discovery"
+	"go.uber.org/zap"
+)
+
+// DiscoveryConfig configures resolution of servers that are DNS names or services: "dns+http://host:port" is
+// replaced by every address of the host, "dnssrv+http://_service._proto.name" by targets of the SRV records,
+// "consul+http://agent:8500/service?tag=..." by healthy instances of the Consul service, "etcd+http://host:2379/prefix"
+// by values of the keys under etcd prefix.
+type DiscoveryConfig struct {
+	// Interval is how often names and services are resolved again, servers that were added or removed are
+	// applied without restart. 0 means that they are resolved on start and config reload only.
+	Interval time.Duration `mapstructure:"interval"`
+	// Timeout of the lookups and registry requests
+	Timeout time.Duration `mapstructure:"timeout"`
+}
+
+var backendResolver = discovery.Resolver{
+	DNS:  net.DefaultResolver,
+	HTTP: &http.Client{},
+}
+
+// discovered is the resolved config that current zipper was created with, before changes made with admin API are
+// applied. Guarded by configUpdates.
+var discovered *carbonzipperConfig
+
+// backendServers returns servers of the config, one list per group and the legacy backends list first
+func backendServers(c *carbonzipperConfig) [][]string {
+	servers := [][]string{c.Backends}
+	for _, backend := range c.Backendsv2.Backends {
+		servers = append(servers, backend.Servers)
+	}
+	return servers
+}
+
+// resolveBackends returns copy of the config with DNS names and services of the servers resolved
+func resolveBackends(c *carbonzipperConfig) (carbonzipperConfig, error) {
+	resolved := *c
+	ctx, cancel := context.WithCancel(context.Background())
+	if c.Discovery.Timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, c.Discovery.Timeout)
+	}
+	defer cancel()
+
+	var err error
+	resolved.Backends, err = backendResolver.ResolveServers(ctx, c.Backends)
+	if err != nil {
+		return resolved, err
+	}
+	resolved.Backendsv2, err = backendResolver.ResolveBackends(ctx, c.Backendsv2)
+	return resolved, err
+}
+
+// newZipper creates zipper with backends of the resolved config, changed with admin API. It must be called with
+// configUpdates held, unless nothing else can update the config yet.
+func newZipper(resolved *carbonzipperConfig) (*zipper.Zipper, error) {
+	z, err := zipper.NewZipper(sendStats, newZipperConfig(runtimeBackends.apply(resolved)), instance.Logger("zipper"))
+	if err != nil {
+		return nil, err
+	}
+	discovered = resolved
+	return z, nil
+}
+
+// replaceZipper replaces current zipper by the one with backends of the resolved config. New zipper starts with
+// statistics that current one has learned.
+func replaceZipper(resolved *carbonzipperConfig) error {
+	old := getZipper()
+	if err := old.SaveState(); err != nil {
+		instance.Logger("zipper").Error("failed to save backends statistics", zap.Error(err))
+	}
+
+	z, err := newZipper(resolved)
+	if err != nil {
+		return err
+	}
+	zipperInstance.Store(z)
+	close(old.ProbeQuit)
+	old.CloseIdleConnections()
+	return nil
+}
+
+// watchDiscovery periodically resolves DNS names and services of the servers and recreates zipper if they've
+// changed. Servers that can't be resolved are kept.
+func watchDiscovery(interval time.Duration) {
+	logger := instance.Logger("discovery")
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		current := getConfig()
+		resolved, err := resolveBackends(current)
+		if err != nil {
+			logger.Warn("failed to resolve backends",
+				zap.Error(err),
+			)
+			continue
+		}
+
+		changed := false
+		err = updateConfig(func(c *carbonzipperConfig) error {
+			// config was reloaded while names were resolved, they'll be resolved again on the next tick
+			if getConfig() != current || reflect.DeepEqual(backendServers(&resolved), backendServers(discovered)) {
+				return nil
+			}
+			changed = true
+			return replaceZipper(&resolved)
+		})
+		if err != nil {
+			logger.Error("failed to apply resolved backends",
+				zap.Error(err),
+			)
+			continue
+		}
+		if changed {
+			logger.Info("backends changed",
+				zap.Any("servers", backendServers(discovered)),
+			)
+		}
+	}
+}
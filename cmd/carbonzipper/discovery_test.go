@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/go-graphite/carbonapi/zipper/discovery"
+	"github.com/go-graphite/carbonapi/zipper/types"
+)
+
+type staticResolver map[string][]string
+
+func (r staticResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	return r[host], nil
+}
+
+func (r staticResolver) LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+	return name, nil, nil
+}
+
+func TestResolveBackends(t *testing.T) {
+	defer func(r discovery.Resolver) { backendResolver = r }(backendResolver)
+	backendResolver.DNS = staticResolver{"graphite.local": {"10.0.0.1", "10.0.0.2"}}
+
+	c := defaultConfig
+	c.Backends = []string{"http://static:8080"}
+	c.Backendsv2 = types.BackendsV2{Backends: []types.BackendV2{{GroupName: "dns", Servers: []string{"dns+http://graphite.local:8080"}}}}
+	resolved, err := resolveBackends(&c)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	expected := [][]string{{"http://static:8080"}, {"http://10.0.0.1:8080", "http://10.0.0.2:8080"}}
+	if servers := backendServers(&resolved); !reflect.DeepEqual(servers, expected) {
+		t.Fatalf("expected %v, got %v", expected, servers)
+	}
+	// names are kept in the config, so they are resolved again
+	if c.Backendsv2.Backends[0].Servers[0] != "dns+http://graphite.local:8080" {
+		t.Fatalf("config was modified: %v", c.Backendsv2.Backends)
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	util "github.com/go-graphite/carbonapi/util/ctx"
+	"github.com/go-graphite/carbonapi/util/instance"
+	"github.com/go-graphite/carbonapi/zipper/types"
+	protov3 "github.com/go-graphite/protocol/carbonapi_v3_pb"
+	"github.com/satori/go.uuid"
+	"go.uber.org/zap"
+)
+
+// routeExplainer tells how request would be routed, it's implemented by zipper
+type routeExplainer interface {
+	Explain(ctx context.Context, request *protov3.MultiFetchRequest) ([]types.Explanation, error)
+}
+
+// targetExplanation is the part of /explain response for one target. Target is sent to backends as Rewritten if
+// renames apply to it, URI is the render request that carbonapi_v2_pb backends would get.
+type targetExplanation struct {
+	Target    string              `json:"target"`
+	Rewritten string              `json:"rewritten,omitempty"`
+	URI       string              `json:"uri"`
+	Metrics   []types.Explanation `json:"metrics"`
+}
+
+func explainTargets(ctx context.Context, z routeExplainer, renames renameRules, targets []string, from, until int32) ([]targetExplanation, error) {
+	rewritten, _ := renames.rewrite(targets)
+	res := make([]targetExplanation, 0, len(targets))
+	for i, target := range targets {
+		e := targetExplanation{Target: target}
+		if rewritten[i] != target {
+			e.Rewritten = rewritten[i]
+		}
+		v := url.Values{
+			"format": []string{"protobuf"},
+			"from":   []string{strconv.Itoa(int(from))},
+			"until":  []string{strconv.Itoa(int(until))},
+			"target": []string{rewritten[i]},
+		}
+		e.URI = "/render/?" + v.Encode()
+
+		var err error
+		e.Metrics, err = z.Explain(ctx, &protov3.MultiFetchRequest{
+			Metrics: []protov3.FetchRequest{{
+				Name:           rewritten[i],
+				PathExpression: rewritten[i],
+				StartTime:      int64(from),
+				StopTime:       int64(until),
+			}},
+		})
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, e)
+	}
+	return res, nil
+}
+
+// explainHandler tells which backends render request would be sent to and why, without fetching anything. It
+// accepts the same parameters as /render/.
+func explainHandler(w http.ResponseWriter, req *http.Request) {
+	t0 := time.Now()
+	uuid := uuid.NewV4()
+	ctx := util.SetUUID(req.Context(), uuid.String())
+	accessLogger := instance.Logger("access").With(
+		zap.String("handler", "explain"),
+		zap.String("carbonzipper_uuid", uuid.String()),
+	)
+
+	config := getConfig()
+	r, rerr := parseRenderRequest(req, config, t0)
+	if rerr != nil {
+		http.Error(w, rerr.message, http.StatusBadRequest)
+		accessLogger.Error("request failed",
+			zap.String("reason", rerr.reason),
+			zap.Int("http_code", http.StatusBadRequest),
+		)
+		return
+	}
+
+	res, err := explainTargets(ctx, getZipper(), config.Renames, r.targets, r.from, r.until)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		accessLogger.Error("request failed",
+			zap.Error(err),
+			zap.Int("http_code", http.StatusInternalServerError),
+		)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentTypeJSON)
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		accessLogger.Error("failed to encode response", zap.Error(err))
+		return
+	}
+	accessLogger.Info("request served",
+		zap.Strings("targets", r.targets),
+		zap.Int("http_code", http.StatusOK),
+		zap.Duration("runtime_seconds", time.Since(t0)),
+	)
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/go-graphite/carbonapi/zipper/types"
+	protov3 "github.com/go-graphite/protocol/carbonapi_v3_pb"
+)
+
+type testExplainer struct {
+	requests []protov3.FetchRequest
+}
+
+func (e *testExplainer) Explain(ctx context.Context, request *protov3.MultiFetchRequest) ([]types.Explanation, error) {
+	var res []types.Explanation
+	for _, m := range request.Metrics {
+		e.requests = append(e.requests, m)
+		res = append(res, types.Explanation{
+			Metric:   m.Name,
+			Backends: []types.ExplainedBackend{{Name: "group", Reason: types.ReasonBroadcast, From: m.StartTime, Until: m.StopTime}},
+		})
+	}
+	return res, nil
+}
+
+func TestExplainTargets(t *testing.T) {
+	z := &testExplainer{}
+	renames := renameRules{{From: "servers.old", To: "hosts.new"}}
+	res, err := explainTargets(context.Background(), z, renames, []string{"servers.old.*.cpu", "other.cpu"}, 100, 200)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(res) != 2 {
+		t.Fatalf("expected explanation of every target, got %+v", res)
+	}
+
+	if res[0].Target != "servers.old.*.cpu" || res[0].Rewritten != "hosts.new.*.cpu" {
+		t.Errorf("unexpected rewritten target %+v", res[0])
+	}
+	if res[0].URI != "/render/?format=protobuf&from=100&target=hosts.new.%2A.cpu&until=200" {
+		t.Errorf("unexpected uri %q", res[0].URI)
+	}
+	if res[1].Rewritten != "" || res[1].URI != "/render/?format=protobuf&from=100&target=other.cpu&until=200" {
+		t.Errorf("target shouldn't be rewritten, got %+v", res[1])
+	}
+
+	expected := []protov3.FetchRequest{
+		{Name: "hosts.new.*.cpu", PathExpression: "hosts.new.*.cpu", StartTime: 100, StopTime: 200},
+		{Name: "other.cpu", PathExpression: "other.cpu", StartTime: 100, StopTime: 200},
+	}
+	if !reflect.DeepEqual(z.requests, expected) {
+		t.Errorf("expected zipper to explain %+v, got %+v", expected, z.requests)
+	}
+	if res[0].Metrics[0].Backends[0].Name != "group" {
+		t.Errorf("unexpected explanation %+v", res[0].Metrics)
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"sort"
+
+	protov2 "github.com/go-graphite/protocol/carbonapi_v2_pb"
+)
+
+// metricDetails is metadata of the metric on a single server
+type metricDetails struct {
+	Server            string              `json:"server"`
+	AggregationMethod string              `json:"aggregationMethod"`
+	XFilesFactor      float32             `json:"xFilesFactor"`
+	MaxRetention      int32               `json:"maxRetention"`
+	Retentions        []protov2.Retention `json:"retentions"`
+	// Size is the size of whisper file with such retentions
+	Size int64 `json:"size"`
+}
+
+// findMatchDetails is a find match with metadata of the metric on every server that has it. JSON encoding of match
+// itself is the same as for plain find response.
+type findMatchDetails struct {
+	Path    string          `json:"path,omitempty"`
+	IsLeaf  bool            `json:"isLeaf,omitempty"`
+	Details []metricDetails `json:"details,omitempty"`
+}
+
+// whisperSize returns size of whisper file with retentions: metadata, archive headers and 12 bytes per point
+func whisperSize(retentions []protov2.Retention) int64 {
+	const metadataSize, archiveInfoSize, pointSize = 16, 12, 12
+	size := int64(metadataSize + archiveInfoSize*len(retentions))
+	for _, r := range retentions {
+		size += int64(r.NumberOfPoints) * pointSize
+	}
+	return size
+}
+
+// addFindDetails joins leaf matches with info responses. Names of info responses are renamed with renames first,
+// the same way as matches are.
+func addFindDetails(matches []protov2.GlobMatch, info *protov2.ZipperInfoResponse, renames *renames) []findMatchDetails {
+	details := make(map[string][]metricDetails)
+	if info != nil {
+		for _, r := range info.Responses {
+			if r.Info == nil {
+				continue
+			}
+			name := renames.restore(r.Info.Name)
+			details[name] = append(details[name], metricDetails{
+				Server:            r.Server,
+				AggregationMethod: r.Info.AggregationMethod,
+				XFilesFactor:      r.Info.XFilesFactor,
+				MaxRetention:      r.Info.MaxRetention,
+				Retentions:        r.Info.Retentions,
+				Size:              whisperSize(r.Info.Retentions),
+			})
+		}
+	}
+
+	res := make([]findMatchDetails, 0, len(matches))
+	for _, m := range matches {
+		d := findMatchDetails{Path: m.Path, IsLeaf: m.IsLeaf}
+		if m.IsLeaf {
+			d.Details = details[m.Path]
+			sort.Slice(d.Details, func(i, j int) bool { return d.Details[i].Server < d.Details[j].Server })
+		}
+		res = append(res, d)
+	}
+	return res
+}
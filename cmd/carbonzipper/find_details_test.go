@@ -0,0 +1,43 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	protov2 "github.com/go-graphite/protocol/carbonapi_v2_pb"
+)
+
+func TestAddFindDetails(t *testing.T) {
+	retentions := []protov2.Retention{{SecondsPerPoint: 60, NumberOfPoints: 1440}, {SecondsPerPoint: 3600, NumberOfPoints: 720}}
+	matches := []protov2.GlobMatch{
+		{Path: "old.cpu", IsLeaf: true},
+		{Path: "old.disk", IsLeaf: false},
+		{Path: "old.mem", IsLeaf: true},
+	}
+	info := &protov2.ZipperInfoResponse{
+		Responses: []protov2.ServerInfoResponse{
+			{Server: "b", Info: &protov2.InfoResponse{Name: "new.cpu", AggregationMethod: "average", MaxRetention: 2592000, Retentions: retentions}},
+			{Server: "a", Info: &protov2.InfoResponse{Name: "new.cpu", AggregationMethod: "sum", MaxRetention: 2592000, Retentions: retentions}},
+			{Server: "c"},
+		},
+	}
+
+	_, renames := renameRules{{From: "old", To: "new"}}.rewrite([]string{"old.*"})
+	res := addFindDetails(matches, info, renames)
+	if len(res) != 3 {
+		t.Fatalf("unexpected result: %+v", res)
+	}
+	cpu := res[0].Details
+	if len(cpu) != 2 || cpu[0].Server != "a" || cpu[1].Server != "b" || cpu[0].AggregationMethod != "sum" {
+		t.Fatalf("unexpected details: %+v", cpu)
+	}
+	if expected := int64(16 + 2*12 + (1440+720)*12); cpu[0].Size != expected {
+		t.Fatalf("unexpected size, got %v, expected %v", cpu[0].Size, expected)
+	}
+	if !reflect.DeepEqual(cpu[0].Retentions, retentions) {
+		t.Fatalf("unexpected retentions: %+v", cpu[0].Retentions)
+	}
+	if res[1].Details != nil || res[2].Details != nil {
+		t.Fatalf("branches and unknown metrics shouldn't have details: %+v", res)
+	}
+}
@@ -1,3 +1,5 @@
+// +build !nogrpc
+
 package main
 
 import (
@@ -10,9 +12,10 @@ import (
 	pb "github.com/go-graphite/protocol/carbonapi_v3_pb"
 	gpb "github.com/golang/protobuf/ptypes/empty"
 
-	"github.com/lomik/zapwriter"
 	"go.uber.org/zap"
 	"google.golang.org/grpc"
+
+	"github.com/go-graphite/carbonapi/util/instance"
 )
 
 var errNotImplementedYet = fmt.Errorf("feature not implemented yet")
@@ -36,7 +39,7 @@ func (srv GRPCServer) GetVersion(ctx context.Context, in *gpb.Empty) (*protov3gr
 func (srv GRPCServer) FetchMetrics(ctx context.Context, in *pb.MultiFetchRequest) (*pb.MultiFetchResponse, error) {
 	t0 := time.Now()
 	memoryUsage := 0
-	logger := zapwriter.Logger("grpc_find").With(
+	logger := instance.Logger("grpc_find").With(
 		zap.String("handler", "find"),
 	)
 	logger.Debug("got find request",
@@ -45,12 +48,12 @@ func (srv GRPCServer) FetchMetrics(ctx context.Context, in *pb.MultiFetchRequest
 
 	Metrics.FindRequests.Add(1)
 
-	grpcLogger := zapwriter.Logger("grpc_access").With(
+	grpcLogger := instance.Logger("grpc_access").With(
 		zap.String("handler", "render"),
 		zap.String("format", "grpc"),
 	)
 
-	ctx, cancel := context.WithTimeout(ctx, config.Timeouts.Render)
+	ctx, cancel := context.WithTimeout(ctx, getConfig().Timeouts.Render)
 	defer cancel()
 
 	grpcLogger.Debug("got render request",
@@ -59,7 +62,7 @@ func (srv GRPCServer) FetchMetrics(ctx context.Context, in *pb.MultiFetchRequest
 
 	Metrics.RenderRequests.Add(1)
 
-	response, stats, err := config.zipper.FetchProtoV3(ctx, in)
+	response, stats, err := getZipper().FetchProtoV3(ctx, in)
 	sendStats(stats)
 	if err != nil {
 		grpcLogger.Error("failed to fetch data",
@@ -85,7 +88,7 @@ func (srv GRPCServer) FetchMetrics(ctx context.Context, in *pb.MultiFetchRequest
 
 func (srv GRPCServer) FindMetrics(ctx context.Context, in *pb.MultiGlobRequest) (*pb.MultiGlobResponse, error) {
 	t0 := time.Now()
-	logger := zapwriter.Logger("grpc_find").With(
+	logger := instance.Logger("grpc_find").With(
 		zap.String("handler", "find"),
 	)
 	logger.Debug("got find request",
@@ -94,15 +97,15 @@ func (srv GRPCServer) FindMetrics(ctx context.Context, in *pb.MultiGlobRequest)
 
 	Metrics.FindRequests.Add(1)
 
-	grpcLogger := zapwriter.Logger("grpc_access").With(
+	grpcLogger := instance.Logger("grpc_access").With(
 		zap.String("handler", "find"),
 		zap.String("format", "grpc"),
 	)
 
-	ctx, cancel := context.WithTimeout(ctx, config.Timeouts.Find)
+	ctx, cancel := context.WithTimeout(ctx, getConfig().Timeouts.Find)
 	defer cancel()
 
-	response, stats, err := config.zipper.FindProtoV3(ctx, in)
+	response, stats, err := getZipper().FindProtoV3(ctx, in)
 	sendStats(stats)
 	if err != nil {
 		grpcLogger.Error("find error",
@@ -0,0 +1,16 @@
+// +build nogrpc
+
+package main
+
+import (
+	"fmt"
+)
+
+type GRPCServer struct{}
+
+func (srv *GRPCServer) serve() {}
+
+// NewGRPCServer always fails, as carbonzipper was built without gRPC support
+func NewGRPCServer(address string) (*GRPCServer, error) {
+	return nil, fmt.Errorf("carbonzipper was built with 'nogrpc' tag, gRPC listener is not available")
+}
@@ -2,15 +2,16 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"expvar"
 	"flag"
 	"fmt"
-	"io/ioutil"
 	"log"
 	"net/http"
 	_ "net/http/pprof"
 	"os"
+	"reflect"
 	"runtime"
 	"strconv"
 	"strings"
@@ -22,9 +23,14 @@ import (
 	"github.com/facebookgo/pidfile"
 	"github.com/go-graphite/carbonapi/intervalset"
 	"github.com/go-graphite/carbonapi/mstats"
+	"github.com/go-graphite/carbonapi/phases"
+	"github.com/go-graphite/carbonapi/pkg/parser"
 	util "github.com/go-graphite/carbonapi/util/ctx"
+	"github.com/go-graphite/carbonapi/util/instance"
 	"github.com/go-graphite/carbonapi/zipper"
+	"github.com/go-graphite/carbonapi/zipper/broadcast"
 	zipperConfig "github.com/go-graphite/carbonapi/zipper/config"
+	"github.com/go-graphite/carbonapi/zipper/helper"
 	"github.com/go-graphite/carbonapi/zipper/types"
 	protov2 "github.com/go-graphite/protocol/carbonapi_v2_pb"
 	protov3 "github.com/go-graphite/protocol/carbonapi_v3_pb"
@@ -55,31 +61,79 @@ type GraphiteConfig struct {
 	Prefix   string
 }
 
-// config contains necessary information for global
-var config = struct {
+type carbonzipperConfig struct {
 	Backends   []string         `mapstructure:"backends"`
 	Backendsv2 types.BackendsV2 `mapstructure:"backendsv2"`
 	MaxProcs   int              `mapstructure:"maxProcs"`
 	Graphite   GraphiteConfig   `mapstructure:"graphite"`
+	Instance   string           `mapstructure:"instance"`
 	GRPCListen string           `mapstructure:"grpcListen"`
 	Listen     string           `mapstructure:"listen"`
 	Buckets    int              `mapstructure:"buckets"`
 
-	Timeouts          types.Timeouts `mapstructure:"timeouts"`
-	KeepAliveInterval time.Duration  `mapstructure:"keepAliveInterval"`
+	Timeouts          types.Timeouts              `mapstructure:"timeouts"`
+	KeepAliveInterval time.Duration               `mapstructure:"keepAliveInterval"`
+	RetryBudget       types.RetryBudget           `mapstructure:"retryBudget"`
+	NotFoundCacheTTL  time.Duration               `mapstructure:"notFoundCacheTTL"`
+	DecodeQuarantine  types.DecodeErrorQuarantine `mapstructure:"decodeErrorQuarantine"`
+	StrictDecode      bool                        `mapstructure:"strictDecode"`
+	MaxResponseSize   int64                       `mapstructure:"maxResponseSize"`
+	StatePersistence  types.StatePersistence      `mapstructure:"statePersistence"`
+	Carbonlink        types.Carbonlink            `mapstructure:"carbonlink"`
+	Routes            []types.Route               `mapstructure:"routes"`
+	StorageTiers      types.StorageTiers          `mapstructure:"storageTiers"`
+	Failover          types.Failover              `mapstructure:"failover"`
+	ExpandGlobs       bool                        `mapstructure:"expandGlobs"`
+	MaxBackends       int                         `mapstructure:"maxBackends"`
+	ConsolidateBy     string                      `mapstructure:"consolidateBy"`
+	XFilesFactor      float32                     `mapstructure:"xFilesFactor"`
+	MergePolicy       string                      `mapstructure:"mergePolicy"`
+	Strict            bool                        `mapstructure:"strict"`
+	Quorum            int                         `mapstructure:"quorum"`
+
+	BackendAttribution bool `mapstructure:"backendAttribution"`
+
+	TenantHeader  string         `mapstructure:"tenantHeader"`
+	DefaultTenant string         `mapstructure:"defaultTenant"`
+	Tenants       []TenantConfig `mapstructure:"tenants"`
+
+	Authorization   AuthorizationConfig   `mapstructure:"authorization"`
+	BackendOverride BackendOverrideConfig `mapstructure:"backendOverride"`
+	Admin           AdminConfig           `mapstructure:"admin"`
+
+	Discovery DiscoveryConfig `mapstructure:"discovery"`
 
 	CarbonSearch   types.CarbonSearch   `mapstructure:"carbonsearch"`
 	CarbonSearchV2 types.CarbonSearchV2 `mapstructure:"carbonsearchv2"`
 
-	MaxIdleConnsPerHost int `mapstructure:"maxIdleConnsPerHost"`
+	MaxIdleConnsPerHost int             `mapstructure:"maxIdleConnsPerHost"`
+	Transport           types.Transport `mapstructure:"transport"`
 
 	ConcurrencyLimitPerServer  int                `mapstructure:"concurrencyLimit"`
 	ExpireDelaySec             int32              `mapstructure:"expireDelaySec"`
 	Logger                     []zapwriter.Config `mapstructure:"logger"`
 	GraphiteWeb09Compatibility bool               `mapstructure:"graphite09compat"`
+	RequestLogSize             int                `mapstructure:"requestLogSize"`
+	RequestLogSampling         RequestLogSampling `mapstructure:"requestLogSampling"`
+	StreamMaxDuration          time.Duration      `mapstructure:"streamMaxDuration"`
+	SubscriptionInterval       time.Duration      `mapstructure:"subscriptionInterval"`
+	SubscriptionOrigins        []string           `mapstructure:"subscriptionOrigins"`
+	SubscriptionMaxTargets     int                `mapstructure:"subscriptionMaxTargets"`
+	Precompute                 []PrecomputeConfig `mapstructure:"precompute"`
+	RemoveEmptySeries          bool               `mapstructure:"removeEmptySeries"`
+	CardinalityInterval        time.Duration      `mapstructure:"cardinalityInterval"`
+	Renames                    renameRules        `mapstructure:"renames"`
+	PostProcess                postProcessRules   `mapstructure:"postProcess"`
+	Retention                  retentionRules     `mapstructure:"retention"`
+	Tarpit                     TarpitConfig       `mapstructure:"tarpit"`
+	Typeahead                  TypeaheadConfig    `mapstructure:"typeahead"`
+	RenderStats                RenderStatsConfig  `mapstructure:"renderStats"`
+	Verify                     VerifyConfig       `mapstructure:"verify"`
+	ProtobufPassthrough        bool               `mapstructure:"protobufPassthrough"`
+}
 
-	zipper *zipper.Zipper
-}{
+// defaultConfig contains defaults of the options, config is parsed over its copy
+var defaultConfig = carbonzipperConfig{
 	MaxProcs: 1,
 	Graphite: GraphiteConfig{
 		Interval: 60 * time.Second,
@@ -96,10 +150,34 @@ var config = struct {
 		Connect: 200 * time.Millisecond,
 	},
 	KeepAliveInterval: 30 * time.Second,
+	ConsolidateBy:     "avg",
 
 	MaxIdleConnsPerHost: 100,
 
 	ExpireDelaySec: 10 * 60, // 10 minutes
+	RequestLogSize: 100,
+	RequestLogSampling: RequestLogSampling{
+		Rate:   1,
+		Errors: true,
+	},
+
+	StreamMaxDuration:      time.Hour,
+	SubscriptionInterval:   10 * time.Second,
+	SubscriptionMaxTargets: 100,
+
+	Authorization: AuthorizationConfig{
+		PolicyTimeout: time.Second,
+	},
+
+	Discovery: DiscoveryConfig{
+		Interval: 30 * time.Second,
+		Timeout:  5 * time.Second,
+	},
+
+	RenderStats: RenderStatsConfig{
+		Window:        time.Hour,
+		MaxNamespaces: 1000,
+	},
 
 	Logger: []zapwriter.Config{defaultLoggerConfig},
 }
@@ -114,10 +192,35 @@ var Metrics = struct {
 	RenderRequests *expvar.Int
 	RenderErrors   *expvar.Int
 
+	StreamRequests    *expvar.Int
+	SubscribeRequests *expvar.Int
+	SubscribedTargets expvar.Func
+
+	PrecomputeHits *expvar.Int
+	Passthrough    *expvar.Int
+	Clamped        *expvar.Int
+
+	AuthorizationDenied *expvar.Int
+
 	InfoRequests *expvar.Int
 	InfoErrors   *expvar.Int
 
 	Timeouts *expvar.Int
+	NotFound *expvar.Int
+
+	RetryBudgetExhausted expvar.Func
+	DecodeErrors         expvar.Func
+	DecodeAnomalies      expvar.Func
+	QuarantinedServers   expvar.Func
+	DivergentPoints      expvar.Func
+	MismatchedPoints     expvar.Func
+	ProtocolDowngrades   expvar.Func
+	CarbonlinkErrors     expvar.Func
+	Failovers            expvar.Func
+	ActiveCluster        expvar.Func
+	TruncatedResponses   expvar.Func
+	TarpitDelayed        expvar.Func
+	TarpitRejected       expvar.Func
 
 	CacheSize         expvar.Func
 	CacheItems        expvar.Func
@@ -136,10 +239,20 @@ var Metrics = struct {
 	RenderRequests: expvar.NewInt("render_requests"),
 	RenderErrors:   expvar.NewInt("render_errors"),
 
+	StreamRequests:    expvar.NewInt("stream_requests"),
+	SubscribeRequests: expvar.NewInt("subscribe_requests"),
+
+	PrecomputeHits: expvar.NewInt("precompute_hits"),
+	Passthrough:    expvar.NewInt("passthrough_responses"),
+	Clamped:        expvar.NewInt("clamped_requests"),
+
+	AuthorizationDenied: expvar.NewInt("authorization_denied"),
+
 	InfoRequests: expvar.NewInt("info_requests"),
 	InfoErrors:   expvar.NewInt("info_errors"),
 
 	Timeouts: expvar.NewInt("timeouts"),
+	NotFound: expvar.NewInt("not_found"),
 
 	CacheHits:         expvar.NewInt("cache_hits"),
 	CacheMisses:       expvar.NewInt("cache_misses"),
@@ -153,11 +266,16 @@ var BuildVersion = "(development version)"
 // set during startup, read-only after that
 var searchConfigured = false
 
+var typeahead *typeaheadIndex
+
+var renderStatistics *renderStats
+
 const (
 	contentTypeJSON          = "application/json"
 	contentTypeProtobuf      = "application/x-protobuf"
 	contentTypePickle        = "application/pickle"
 	contentTypeCarbonAPIv3PB = "application/x-carbonapi-v3-pb"
+	contentTypeJSONStream    = "application/x-ndjson"
 )
 
 const (
@@ -168,6 +286,8 @@ const (
 	formatTypeProtobuf3     = "protobuf3"
 	formatTypeV2            = "v2"
 	formatTypeCarbonAPIV2PB = "carbonapi_v2_pb"
+	formatTypeV3            = "v3"
+	formatTypeCarbonAPIV3PB = "carbonapi_v3_pb"
 )
 
 func findHandler(w http.ResponseWriter, req *http.Request) {
@@ -175,7 +295,7 @@ func findHandler(w http.ResponseWriter, req *http.Request) {
 	uuid := uuid.NewV4()
 	ctx := req.Context()
 	ctx = util.SetUUID(ctx, uuid.String())
-	logger := zapwriter.Logger("find").With(
+	logger := instance.Logger("find").With(
 		zap.String("handler", "find"),
 		zap.String("carbonzipper_uuid", uuid.String()),
 		zap.String("carbonapi_uuid", util.GetUUID(ctx)),
@@ -185,11 +305,12 @@ func findHandler(w http.ResponseWriter, req *http.Request) {
 	)
 
 	originalQuery := req.FormValue("query")
+	typeahead.record(originalQuery)
 	format := req.FormValue("format")
 
 	Metrics.FindRequests.Add(1)
 
-	accessLogger := zapwriter.Logger("access").With(
+	accessLogger := instance.Logger("access").With(
 		zap.String("handler", "find"),
 		zap.String("format", format),
 		zap.String("target", originalQuery),
@@ -197,8 +318,10 @@ func findHandler(w http.ResponseWriter, req *http.Request) {
 		zap.String("carbonapi_uuid", util.GetUUID(ctx)),
 	)
 
-	metrics, stats, err := config.zipper.FindProtoV2(ctx, []string{originalQuery})
+	queries, renames := getConfig().Renames.rewrite([]string{originalQuery})
+	metrics, stats, err := getZipper().FindProtoV2(ctx, queries)
 	sendStats(stats)
+	recordStats(ctx, stats)
 	if err != nil {
 		accessLogger.Error("find failed",
 			zap.Int("http_code", http.StatusInternalServerError),
@@ -210,7 +333,39 @@ func findHandler(w http.ResponseWriter, req *http.Request) {
 	}
 
 	// There should be exactly one match at this moment
-	err = EncodeFindResponse(format, originalQuery, w, metrics[0].Matches)
+	var matches []protov2.GlobMatch
+	if len(metrics) > 0 {
+		matches = metrics[0].Matches
+		renames.restoreMatches(matches)
+	}
+	if err := authorizeMetrics(ctx, leafPaths(matches)); err != nil {
+		code := http.StatusInternalServerError
+		if err == errForbidden {
+			code = http.StatusForbidden
+		}
+		accessLogger.Error("find failed",
+			zap.Int("http_code", code),
+			zap.String("reason", err.Error()),
+			zap.Duration("runtime_seconds", time.Since(t0)),
+		)
+		http.Error(w, err.Error(), code)
+		return
+	}
+	if format == formatTypeJSON && parser.TruthyBool(req.FormValue("details")) {
+		// details are only available from info requests, so backends are asked for them separately
+		info, stats, err := getZipper().InfoProtoV2(ctx, queries)
+		sendStats(stats)
+		recordStats(ctx, stats)
+		if err != nil {
+			logger.Warn("failed to get metric details",
+				zap.Error(err),
+			)
+		}
+		w.Header().Set("Content-Type", contentTypeJSON)
+		err = json.NewEncoder(w).Encode(addFindDetails(matches, info, renames))
+	} else {
+		err = EncodeFindResponse(format, originalQuery, w, matches)
+	}
 	if err != nil {
 		http.Error(w, "error marshaling data", http.StatusInternalServerError)
 		accessLogger.Error("find failed",
@@ -247,12 +402,13 @@ func EncodeFindResponse(format, query string, w http.ResponseWriter, metrics []p
 		w.Header().Set("Content-Type", contentTypePickle)
 
 		var result []map[string]interface{}
+		graphiteWeb09 := getConfig().GraphiteWeb09Compatibility
 
 		now := int32(time.Now().Unix() + 60)
 		for _, metric := range metrics {
 			// Tell graphite-web that we have everything
 			var mm map[string]interface{}
-			if config.GraphiteWeb09Compatibility {
+			if graphiteWeb09 {
 				// graphite-web 0.9.x
 				mm = map[string]interface{}{
 					// graphite-web 0.9.x
@@ -277,6 +433,110 @@ func EncodeFindResponse(format, query string, w http.ResponseWriter, metrics []p
 	return err
 }
 
+// fetchRenderTargets fetches targets from backends. Simple aggregations and transforms are evaluated here,
+// everything else is fetched as is
+func fetchRenderTargets(ctx context.Context, targets []string, from, until int32) (*protov2.MultiFetchResponse, error) {
+	config := getConfig()
+	fetch := func(targets []string, from, until int32) (*protov2.MultiFetchResponse, [][]string, error) {
+		targets, renames := config.Renames.rewrite(targets)
+		res, stats, err := getZipper().FetchProtoV2(ctx, targets, from, until)
+		sendStats(stats)
+		recordStats(ctx, stats)
+		recordFailedServers(ctx, stats)
+		if err != nil {
+			return res, nil, err
+		}
+		sources := fetchedSources(stats, res.Metrics)
+		renames.restoreSeries(res.Metrics)
+		if err := authorizeMetrics(ctx, seriesNames(res.Metrics)); err != nil {
+			return nil, nil, err
+		}
+		config.PostProcess.apply(res.Metrics)
+		return res, sources, nil
+	}
+
+	// raw series are returned once anyway, so repeated targets are only sent once. Repeated expressions are
+	// evaluated once and their results are copied.
+	var rawTargets []string
+	var evalTargets []renderTarget
+	seen := make(map[string]int, len(targets))
+	for _, target := range targets {
+		seen[target]++
+		if seen[target] > 1 {
+			continue
+		}
+		if t := parseRenderTarget(target); t.isRaw() {
+			rawTargets = append(rawTargets, target)
+		} else {
+			evalTargets = append(evalTargets, t)
+		}
+	}
+
+	var err error
+	metrics := &protov2.MultiFetchResponse{}
+	if len(rawTargets) > 0 {
+		var res *protov2.MultiFetchResponse
+		var sources [][]string
+		res, sources, err = fetch(rawTargets, from, until)
+		if err == nil {
+			metrics.Metrics = append(metrics.Metrics, res.Metrics...)
+			recordSources(ctx, res.Metrics, sources)
+		}
+	}
+	for i := 0; i < len(evalTargets) && (err == nil || err == types.ErrNotFound); i++ {
+		var res *protov2.MultiFetchResponse
+		var sources [][]string
+		t := evalTargets[i]
+		res, sources, err = fetch(t.metrics, from+t.shift, until+t.shift)
+		if err == nil && len(res.Metrics) > 0 {
+			sources = t.sources(sources)
+			series := t.apply(res.Metrics)
+			recordSources(ctx, series, sources)
+			for j := 0; j < seen[t.target]; j++ {
+				metrics.Metrics = append(metrics.Metrics, series...)
+			}
+		}
+	}
+	if err == types.ErrNotFound && len(metrics.Metrics) > 0 {
+		err = nil
+	}
+
+	return metrics, err
+}
+
+// passthroughTargets returns targets to fetch if response of the backend may be sent to the client as is, or nil
+// if the response has to be decoded: see renderRequest.canPassthrough, renames and postProcess are applied to the
+// decoded series only.
+func passthroughTargets(config *carbonzipperConfig, r *renderRequest) []string {
+	if !config.ProtobufPassthrough || len(config.PostProcess) > 0 || !r.canPassthrough() {
+		return nil
+	}
+	if _, renames := config.Renames.rewrite(r.targets); renames != nil {
+		return nil
+	}
+
+	// raw series are returned once anyway, same as in fetchRenderTargets
+	targets := make([]string, 0, len(r.targets))
+	seen := make(map[string]struct{}, len(r.targets))
+	for _, target := range r.targets {
+		if _, ok := seen[target]; !ok {
+			seen[target] = struct{}{}
+			targets = append(targets, target)
+		}
+	}
+	return targets
+}
+
+// fetchPassthrough fetches targets and returns carbonapi_v2_pb response of the backend as is if it's the only one
+// that has them. Otherwise response is decoded and returned as metrics.
+func fetchPassthrough(ctx context.Context, targets []string, from, until int32) ([]byte, *protov2.MultiFetchResponse, error) {
+	raw, res, stats, err := getZipper().FetchProtoV2Passthrough(ctx, targets, from, until)
+	sendStats(stats)
+	recordStats(ctx, stats)
+	recordFailedServers(ctx, stats)
+	return raw, res, err
+}
+
 func renderHandler(w http.ResponseWriter, req *http.Request) {
 	t0 := time.Now()
 	memoryUsage := 0
@@ -284,7 +544,7 @@ func renderHandler(w http.ResponseWriter, req *http.Request) {
 	ctx := req.Context()
 
 	ctx = util.SetUUID(ctx, uuid.String())
-	logger := zapwriter.Logger("render").With(
+	logger := instance.Logger("render").With(
 		zap.Int("memory_usage_bytes", memoryUsage),
 		zap.String("handler", "render"),
 		zap.String("carbonzipper_uuid", uuid.String()),
@@ -297,66 +557,128 @@ func renderHandler(w http.ResponseWriter, req *http.Request) {
 
 	Metrics.RenderRequests.Add(1)
 
-	accessLogger := zapwriter.Logger("access").With(
+	accessLogger := instance.Logger("access").With(
 		zap.String("handler", "render"),
 		zap.String("carbonzipper_uuid", uuid.String()),
 		zap.String("carbonapi_uuid", util.GetUUID(ctx)),
 	)
 
-	err := req.ParseForm()
-	if err != nil {
-		http.Error(w, "failed to parse arguments", http.StatusBadRequest)
-		accessLogger.Error("request failed",
+	config := getConfig()
+	r, perr := parseRenderRequest(req, config, time.Now())
+	if perr != nil {
+		http.Error(w, perr.message, http.StatusBadRequest)
+		accessLogger.Error("request failed", append(perr.fields,
 			zap.Int("memory_usage_bytes", memoryUsage),
-			zap.String("reason", "failed to parse arguments"),
+			zap.String("reason", perr.reason),
 			zap.Int("http_code", http.StatusBadRequest),
 			zap.Duration("runtime_seconds", time.Since(t0)),
-		)
+		)...)
 		return
 	}
-	targets := req.Form["target"]
-	format := req.FormValue("format")
 	accessLogger = accessLogger.With(
-		zap.String("format", format),
-		zap.Strings("targets", targets),
+		zap.String("format", r.format),
+		zap.Strings("targets", r.targets),
 	)
+	if clampedFrom := config.Retention.clamp(r, time.Now()); clampedFrom != 0 {
+		Metrics.Clamped.Add(1)
+		setClampedHeader(w, clampedFrom)
+		accessLogger = accessLogger.With(zap.Int32("clamped_from", clampedFrom))
+	}
+	ctx = r.context(ctx)
+	typeahead.recordTargets(r.targets)
+	ctx, failed := withFailedServers(ctx)
+	var sources seriesSources
+	if r.showBackends {
+		ctx, sources = withSeriesSources(ctx)
+	}
 
-	from, err := strconv.Atoi(req.FormValue("from"))
-	if err != nil {
-		http.Error(w, "from is not a integer", http.StatusBadRequest)
+	var err error
+	var metrics *protov2.MultiFetchResponse
+	var multiFetchMetrics *protov3.MultiFetchResponse
+	var precomputedHit bool
+	tFetch := time.Now()
+	// precomputed results are fetched from all the backends, tenants that are restricted can't see them
+	if r.canUsePrecomputed() && util.GetAllowedBackends(ctx) == nil && !authorizationRequired(ctx) {
+		metrics, precomputedHit = getPrecomputed().lookup(r.targets, r.from, r.until)
+	}
+	if r.multiFetch != nil {
+		multiFetchMetrics, err = fetchMultiFetch(ctx, r.multiFetch)
+	} else if precomputedHit {
+		Metrics.PrecomputeHits.Add(1)
+	} else if targets := passthroughTargets(config, r); targets != nil && !authorizationRequired(ctx) {
+		var raw []byte
+		raw, metrics, err = fetchPassthrough(ctx, targets, r.from, r.until)
+		if err == nil && raw != nil {
+			renderStatistics.record(renderNamespaces(r, metrics, nil), time.Since(tFetch))
+			Metrics.Passthrough.Add(1)
+			setPartialResultHeader(w, failed.list())
+			w.Header().Set("Content-Type", contentTypeProtobuf)
+			/* #nosec */
+			_, _ = w.Write(raw)
+			accessLogger.Info("request served",
+				zap.Int("memory_usage_bytes", len(raw)),
+				zap.Bool("passthrough", true),
+				zap.Int("http_code", http.StatusOK),
+				zap.Duration("runtime_seconds", time.Since(t0)),
+			)
+			return
+		}
+	} else {
+		metrics, err = fetchRenderTargets(ctx, r.targets, r.from, r.until)
+	}
+	renderStatistics.record(renderNamespaces(r, metrics, multiFetchMetrics), time.Since(tFetch))
+
+	if err == types.ErrNotFound {
+		http.Error(w, "metrics not found", http.StatusNotFound)
+		accessLogger.Info("request served",
+			zap.Int("memory_usage_bytes", memoryUsage),
+			zap.String("reason", "metrics not found"),
+			zap.Int("http_code", http.StatusNotFound),
+			zap.Duration("runtime_seconds", time.Since(t0)),
+		)
+		return
+	}
+	if err == errForbidden {
+		http.Error(w, err.Error(), http.StatusForbidden)
 		accessLogger.Error("request failed",
 			zap.Int("memory_usage_bytes", memoryUsage),
-			zap.String("reason", "from is not a integer"),
-			zap.Int("http_code", http.StatusBadRequest),
+			zap.String("reason", err.Error()),
+			zap.Int("http_code", http.StatusForbidden),
 			zap.Duration("runtime_seconds", time.Since(t0)),
 		)
 		return
 	}
-	until, err := strconv.Atoi(req.FormValue("until"))
-	if err != nil {
-		http.Error(w, "until is not a integer", http.StatusBadRequest)
+	if err == types.ErrTooManyBackends {
+		http.Error(w, "request would fetch data from more than "+strconv.Itoa(r.maxBackends)+" backends", http.StatusForbidden)
 		accessLogger.Error("request failed",
 			zap.Int("memory_usage_bytes", memoryUsage),
-			zap.String("reason", "until is not a integer"),
-			zap.Int("http_code", http.StatusBadRequest),
+			zap.String("reason", err.Error()),
+			zap.Int("max_backends", r.maxBackends),
+			zap.Int("http_code", http.StatusForbidden),
 			zap.Duration("runtime_seconds", time.Since(t0)),
 		)
 		return
 	}
-
-	if len(targets) == 0 {
-		http.Error(w, "empty target", http.StatusBadRequest)
+	if err == types.ErrPartialResponse {
+		http.Error(w, err.Error(), http.StatusBadGateway)
 		accessLogger.Error("request failed",
 			zap.Int("memory_usage_bytes", memoryUsage),
-			zap.String("reason", "empty target"),
-			zap.Int("http_code", http.StatusBadRequest),
+			zap.String("reason", err.Error()),
+			zap.Int("http_code", http.StatusBadGateway),
+			zap.Duration("runtime_seconds", time.Since(t0)),
+		)
+		return
+	}
+	if err == types.ErrQuorumNotReached {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		accessLogger.Error("request failed",
+			zap.Int("memory_usage_bytes", memoryUsage),
+			zap.String("reason", err.Error()),
+			zap.Int("http_code", http.StatusServiceUnavailable),
 			zap.Duration("runtime_seconds", time.Since(t0)),
 		)
 		return
 	}
-
-	metrics, stats, err := config.zipper.FetchProtoV2(ctx, targets, int32(from), int32(until))
-	sendStats(stats)
 	if err != nil {
 		http.Error(w, "error fetching the data", http.StatusInternalServerError)
 		accessLogger.Error("request failed",
@@ -368,26 +690,23 @@ func renderHandler(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	var b []byte
-	switch format {
-	case formatTypeProtobuf, formatTypeProtobuf3:
-		w.Header().Set("Content-Type", contentTypeProtobuf)
-		b, err = metrics.Marshal()
+	var size int
+	if r.multiFetch != nil {
+		if r.removeEmpty {
+			multiFetchMetrics = &protov3.MultiFetchResponse{Metrics: removeEmptySeriesV3(multiFetchMetrics.Metrics)}
+		}
 
-		memoryUsage += len(b)
-		/* #nosec */
-		_, _ = w.Write(b)
-	case formatTypeJSON:
-		presponse := createRenderResponse(metrics, nil)
-		w.Header().Set("Content-Type", contentTypeJSON)
-		e := json.NewEncoder(w)
-		err = e.Encode(presponse)
-	case formatTypeEmpty, formatTypePickle:
-		presponse := createRenderResponse(metrics, pickle.None{})
-		w.Header().Set("Content-Type", contentTypePickle)
-		e := pickle.NewEncoder(w)
-		err = e.Encode(presponse)
+		tEncode := time.Now()
+		size, err = encodeMultiFetchResponse(w, multiFetchMetrics, failed.list())
+		phases.Since(phases.Encode, tEncode)
+	} else {
+		metrics = r.postProcess(metrics)
+
+		tEncode := time.Now()
+		size, err = encodeRenderResponse(w, r.format, metrics, failed.list(), sources)
+		phases.Since(phases.Encode, tEncode)
 	}
+	memoryUsage += size
 
 	if err != nil {
 		http.Error(w, "error marshaling data", http.StatusInternalServerError)
@@ -408,6 +727,41 @@ func renderHandler(w http.ResponseWriter, req *http.Request) {
 	)
 }
 
+// encodeRenderResponse writes metrics in requested format and returns size of the marshaled protobuf response.
+// If some backends have failed, response is marked as partial. If sources are set, every series in JSON response
+// has the list of backends that returned its points.
+func encodeRenderResponse(w http.ResponseWriter, format string, metrics *protov2.MultiFetchResponse, failed []string, sources seriesSources) (int, error) {
+	var err error
+	var b []byte
+	setPartialResultHeader(w, failed)
+	switch format {
+	case formatTypeProtobuf, formatTypeProtobuf3:
+		w.Header().Set("Content-Type", contentTypeProtobuf)
+		b, err = metrics.Marshal()
+		/* #nosec */
+		_, _ = w.Write(b)
+	case formatTypeJSON:
+		presponse := createRenderResponse(metrics, nil)
+		for _, m := range presponse {
+			if len(failed) > 0 {
+				m["missing_backends"] = failed
+			}
+			if sources != nil {
+				m["backends"] = sources[m["name"].(string)]
+			}
+		}
+		w.Header().Set("Content-Type", contentTypeJSON)
+		e := json.NewEncoder(w)
+		err = e.Encode(presponse)
+	case formatTypeEmpty, formatTypePickle:
+		presponse := createRenderResponse(metrics, pickle.None{})
+		w.Header().Set("Content-Type", contentTypePickle)
+		e := pickle.NewEncoder(w)
+		err = e.Encode(presponse)
+	}
+	return len(b), err
+}
+
 func createRenderResponse(metrics *protov2.MultiFetchResponse, missing interface{}) []map[string]interface{} {
 
 	var response []map[string]interface{}
@@ -442,7 +796,7 @@ func infoHandler(w http.ResponseWriter, req *http.Request) {
 	uuid := uuid.NewV4()
 	ctx := req.Context()
 	ctx = util.SetUUID(ctx, uuid.String())
-	logger := zapwriter.Logger("info").With(
+	logger := instance.Logger("info").With(
 		zap.String("handler", "info"),
 		zap.String("carbonzipper_uuid", uuid.String()),
 		zap.String("carbonapi_uuid", util.GetUUID(ctx)),
@@ -454,7 +808,7 @@ func infoHandler(w http.ResponseWriter, req *http.Request) {
 
 	Metrics.InfoRequests.Add(1)
 
-	accessLogger := zapwriter.Logger("access").With(
+	accessLogger := instance.Logger("access").With(
 		zap.String("handler", "info"),
 		zap.String("carbonzipper_uuid", uuid.String()),
 		zap.String("carbonapi_uuid", util.GetUUID(ctx)),
@@ -487,13 +841,33 @@ func infoHandler(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	// info reveals metric names, so they are authorized the same way as find matches
+	authorized := func(names []string) bool {
+		err := authorizeMetrics(ctx, names)
+		if err == nil {
+			return true
+		}
+		code := http.StatusInternalServerError
+		if err == errForbidden {
+			code = http.StatusForbidden
+		}
+		accessLogger.Error("info failed",
+			zap.Int("http_code", code),
+			zap.String("reason", err.Error()),
+			zap.Duration("runtime_seconds", time.Since(t0)),
+		)
+		http.Error(w, "info: "+err.Error(), code)
+		return false
+	}
+
 	haveNonFatalErrors := false
 	var b []byte
 	if format == formatTypeV2 || format == formatTypeCarbonAPIV2PB || format == formatTypeProtobuf || format == formatTypeProtobuf3 {
 		var result *protov2.ZipperInfoResponse
 		var stats *types.Stats
-		result, stats, err = config.zipper.InfoProtoV2(ctx, targets)
+		result, stats, err = getZipper().InfoProtoV2(ctx, targets)
 		sendStats(stats)
+		recordStats(ctx, stats)
 		if err != nil && err != types.ErrNonFatalErrors {
 			accessLogger.Error("info failed",
 				zap.Int("http_code", http.StatusInternalServerError),
@@ -507,6 +881,9 @@ func infoHandler(w http.ResponseWriter, req *http.Request) {
 		if err == types.ErrNonFatalErrors {
 			haveNonFatalErrors = true
 		}
+		if !authorized(infoNamesV2(result)) {
+			return
+		}
 
 		w.Header().Set("Content-Type", contentTypeProtobuf)
 		b, err = result.Marshal()
@@ -514,8 +891,9 @@ func infoHandler(w http.ResponseWriter, req *http.Request) {
 	} else {
 		var result *protov3.ZipperInfoResponse
 		var stats *types.Stats
-		result, stats, err = config.zipper.InfoProtoV3(ctx, &protov3.MultiGlobRequest{Metrics: targets})
+		result, stats, err = getZipper().InfoProtoV3(ctx, &protov3.MultiGlobRequest{Metrics: targets})
 		sendStats(stats)
+		recordStats(ctx, stats)
 		if err != nil && err != types.ErrNonFatalErrors {
 			accessLogger.Error("info failed",
 				zap.Int("http_code", http.StatusInternalServerError),
@@ -529,6 +907,9 @@ func infoHandler(w http.ResponseWriter, req *http.Request) {
 		if err == types.ErrNonFatalErrors {
 			haveNonFatalErrors = true
 		}
+		if !authorized(infoNamesV3(result)) {
+			return
+		}
 
 		switch format {
 		case "v3", "carbonapi_v3_pb":
@@ -562,8 +943,8 @@ func infoHandler(w http.ResponseWriter, req *http.Request) {
 
 func lbCheckHandler(w http.ResponseWriter, req *http.Request) {
 	t0 := time.Now()
-	logger := zapwriter.Logger("loadbalancer").With(zap.String("handler", "loadbalancer"))
-	accessLogger := zapwriter.Logger("access").With(zap.String("handler", "loadbalancer"))
+	logger := instance.Logger("loadbalancer").With(zap.String("handler", "loadbalancer"))
+	accessLogger := instance.Logger("access").With(zap.String("handler", "loadbalancer"))
 	logger.Debug("loadbalacner",
 		zap.String("request", req.URL.RequestURI()),
 	)
@@ -582,11 +963,15 @@ func main() {
 		log.Fatal("Failed to initialize logger with default configuration")
 
 	}
-	logger := zapwriter.Logger("main")
+	logger := instance.Logger("main")
 
-	configFile := flag.String("config", "", "config file (yaml)")
+	configFile := flag.String("config", "", "config file (yaml) or remote location: http(s)://host/path, consul://host:port/key or etcd://host:port/key")
+	configSignatureKey := flag.String("config-signature-key", "", "file with the key, if set config signature (HMAC-SHA256 at location + \".sig\") is verified")
+	configRefresh := flag.Duration("config-refresh", 0, "how often config is reloaded from its location (default: 0, never)")
 	pidFile := flag.String("pid", "", "pidfile (default: empty, don't create pidfile)")
 	envPrefix := flag.String("envprefix", "CARBONZIPPER_", "Preifx for environment variables override")
+	verify := flag.Bool("verify", false, "run queries from verify section of the config, compare results with golden responses and exit")
+	verifyUpdate := flag.Bool("verify-update", false, "run queries from verify section of the config, store results as golden responses and exit")
 	if *envPrefix == "" {
 		logger.Fatal("empty prefix is not suppoerted due to possible collisions with OS environment variables")
 	}
@@ -600,39 +985,26 @@ func main() {
 		logger.Fatal("missing config file option")
 	}
 
-	cfg, err := ioutil.ReadFile(*configFile)
+	source, err := newConfigSource(*configFile, *configSignatureKey)
 	if err != nil {
-		logger.Fatal("unable to load config file:",
+		logger.Fatal("unable to load config signature key",
 			zap.Error(err),
 		)
 	}
 
-	if strings.HasSuffix(*configFile, ".toml") {
-		logger.Info("will parse config as toml",
-			zap.String("config_file", *configFile),
-		)
-		viper.SetConfigType("TOML")
-	} else {
-		logger.Info("will parse config as yaml",
-			zap.String("config_file", *configFile),
-		)
-		viper.SetConfigType("YAML")
-	}
-	err = viper.ReadConfig(bytes.NewBuffer(cfg))
+	cfg, err := source.load(context.Background())
 	if err != nil {
-		logger.Fatal("failed to parse config",
-			zap.String("config_path", *configFile),
+		logger.Fatal("unable to load config file:",
 			zap.Error(err),
 		)
 	}
 
-	if *envPrefix != "" {
-		viper.SetEnvPrefix(*envPrefix)
-	}
-	viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
-	viper.AutomaticEnv()
-
-	err = viper.Unmarshal(&config)
+	logger.Info("will parse config",
+		zap.String("config_file", *configFile),
+		zap.String("format", source.format()),
+	)
+	config := defaultConfig
+	err = parseConfig(cfg, source.format(), *envPrefix, &config)
 	if err != nil {
 		logger.Fatal("failed to parse config",
 			zap.String("config_path", *configFile),
@@ -643,6 +1015,7 @@ func main() {
 	if len(config.Backends) == 0 && len(config.Backendsv2.Backends) == 0 {
 		logger.Fatal("no Backends loaded -- exiting")
 	}
+	setConfig(config)
 
 	err = zapwriter.ApplyConfig(config.Logger)
 	if err != nil {
@@ -663,7 +1036,7 @@ func main() {
 
 	searchConfigured = (len(config.CarbonSearch.Prefix) > 0 && len(config.CarbonSearch.Backend) > 0) || (len(config.CarbonSearchV2.Prefix) > 0 && len(config.CarbonSearchV2.Backends) > 0)
 
-	logger = zapwriter.Logger("main")
+	logger = instance.Logger("main")
 	logger.Info("starting carbonzipper",
 		zap.String("build_version", BuildVersion),
 		zap.Bool("carbonsearch_configured", searchConfigured),
@@ -679,21 +1052,15 @@ func main() {
 	expvar.Publish("requestBuckets", expvar.Func(renderTimeBuckets))
 
 	// export config via expvars
-	expvar.Publish("config", expvar.Func(func() interface{} { return config }))
+	expvar.Publish("config", expvar.Func(func() interface{} { return getConfig() }))
 
 	/* Configure zipper */
 	// set up caches
-	zipperConfig := &zipperConfig.Config{
-		ConcurrencyLimitPerServer: config.ConcurrencyLimitPerServer,
-		MaxIdleConnsPerHost:       config.MaxIdleConnsPerHost,
-		Backends:                  config.Backends,
-		BackendsV2:                config.Backendsv2,
-		ExpireDelaySec:            config.ExpireDelaySec,
-
-		CarbonSearch:      config.CarbonSearch,
-		CarbonSearchV2:    config.CarbonSearchV2,
-		Timeouts:          config.Timeouts,
-		KeepAliveInterval: config.KeepAliveInterval,
+	resolved, err := resolveBackends(&config)
+	if err != nil {
+		logger.Fatal("failed to resolve backends",
+			zap.Error(err),
+		)
 	}
 
 	/*
@@ -711,17 +1078,91 @@ func main() {
 		expvar.Publish("searchCacheItems", Metrics.SearchCacheItems)
 	*/
 
-	config.zipper, err = zipper.NewZipper(sendStats, zipperConfig, zapwriter.Logger("zipper"))
+	z, err := newZipper(&resolved)
 	if err != nil {
 		logger.Fatal("failed to create zipper instance",
 			zap.Error(err),
 		)
 	}
+	zipperInstance.Store(z)
+
+	if *verify || *verifyUpdate {
+		verifyMain(config.Verify, *verifyUpdate)
+	}
+
+	if config.Discovery.Interval > 0 {
+		go watchDiscovery(config.Discovery.Interval)
+	}
+
+	if *configRefresh > 0 {
+		go source.watch(*configRefresh, cfg, func(data []byte) error {
+			return reloadZipper(data, source.format(), *envPrefix, defaultConfig)
+		})
+	}
 
-	http.HandleFunc("/metrics/find/", httputil.TrackConnections(httputil.TimeHandler(util.ParseCtx(findHandler, util.HeaderUUIDAPI), bucketRequestTimes)))
-	http.HandleFunc("/render/", httputil.TrackConnections(httputil.TimeHandler(util.ParseCtx(renderHandler, util.HeaderUUIDAPI), bucketRequestTimes)))
-	http.HandleFunc("/info/", httputil.TrackConnections(httputil.TimeHandler(util.ParseCtx(infoHandler, util.HeaderUUIDAPI), bucketRequestTimes)))
+	Metrics.RetryBudgetExhausted = expvar.Func(func() interface{} { return helper.GetRetryBudget().Exhausted() })
+	expvar.Publish("retry_budget_exhausted", Metrics.RetryBudgetExhausted)
+	Metrics.DecodeErrors = expvar.Func(func() interface{} { return helper.DecodeErrors() })
+	expvar.Publish("decode_errors", Metrics.DecodeErrors)
+	Metrics.DecodeAnomalies = expvar.Func(func() interface{} { return helper.DecodeAnomaliesByKind() })
+	expvar.Publish("decode_anomalies", Metrics.DecodeAnomalies)
+	Metrics.QuarantinedServers = expvar.Func(func() interface{} { return helper.QuarantinedServers() })
+	expvar.Publish("quarantined_servers", Metrics.QuarantinedServers)
+	Metrics.DivergentPoints = expvar.Func(func() interface{} { return types.DivergentPoints() })
+	expvar.Publish("divergent_points", Metrics.DivergentPoints)
+	Metrics.MismatchedPoints = expvar.Func(func() interface{} { return types.MismatchedPoints() })
+	expvar.Publish("replica_mismatches", Metrics.MismatchedPoints)
+	Metrics.ProtocolDowngrades = expvar.Func(func() interface{} { return zipper.ProtocolDowngrades() })
+	expvar.Publish("protocol_downgrades", Metrics.ProtocolDowngrades)
+	Metrics.CarbonlinkErrors = expvar.Func(func() interface{} { return zipper.CarbonlinkErrors() })
+	expvar.Publish("carbonlink_errors", Metrics.CarbonlinkErrors)
+	Metrics.Failovers = expvar.Func(func() interface{} { return broadcast.Failovers() })
+	expvar.Publish("failovers", Metrics.Failovers)
+	Metrics.ActiveCluster = expvar.Func(func() interface{} { return broadcast.ActiveCluster() })
+	expvar.Publish("failover_active_cluster", Metrics.ActiveCluster)
+	Metrics.TruncatedResponses = expvar.Func(func() interface{} { return helper.TruncatedResponses() })
+	expvar.Publish("truncated_responses", Metrics.TruncatedResponses)
+	phases.Publish("phase_")
+
+	replacePrecomputed(config.Precompute)
+
+	typeahead = newTypeaheadIndex(config.Typeahead)
+
+	renderStatistics = newRenderStats(config.RenderStats)
+
+	namespaces := newNamespaceStats()
+	expvar.Publish("namespace_metrics", expvar.Func(func() interface{} { return namespaces.Counts() }))
+
+	requests := newRequestLog(config.RequestLogSize, config.RequestLogSampling)
+
+	clientTenants := newTenants(config.TenantHeader, config.DefaultTenant, config.Tenants)
+	clientAuthorization := newAuthorization(config.Authorization)
+	admins := newAdminAccess(config.Authorization.IdentityHeader, config.Admin)
+	clientOverride := newBackendOverride(admins, config.BackendOverride)
+
+	clientTarpit := newTarpit(config.Tarpit)
+	Metrics.TarpitDelayed = expvar.Func(func() interface{} { return clientTarpit.Delayed() })
+	expvar.Publish("tarpit_delayed", Metrics.TarpitDelayed)
+	Metrics.TarpitRejected = expvar.Func(func() interface{} { return clientTarpit.Rejected() })
+	expvar.Publish("tarpit_rejected", Metrics.TarpitRejected)
+
+	http.HandleFunc("/metrics/find/", httputil.TrackConnections(clientTarpit.wrap(httputil.TimeHandler(util.ParseCtx(clientTenants.wrap(clientOverride.wrap(clientAuthorization.wrap(requests.wrap("find", "query", findHandler)))), util.HeaderUUIDAPI), bucketRequestTimes))))
+	http.HandleFunc("/render/", httputil.TrackConnections(clientTarpit.wrap(httputil.TimeHandler(util.ParseCtx(clientTenants.wrap(clientOverride.wrap(clientAuthorization.wrap(requests.wrap("render", "target", renderHandler)))), util.HeaderUUIDAPI), bucketRequestTimes))))
+	http.HandleFunc("/render/stream/", httputil.TrackConnections(clientTarpit.wrap(util.ParseCtx(clientTenants.wrap(clientOverride.wrap(clientAuthorization.wrap(requests.wrap("render_stream", "target", streamHandler)))), util.HeaderUUIDAPI))))
+	subscriptions := newSubscriptionHub(config.SubscriptionInterval, config.SubscriptionOrigins, config.SubscriptionMaxTargets)
+	Metrics.SubscribedTargets = expvar.Func(func() interface{} { return subscriptions.Targets() })
+	expvar.Publish("subscribed_targets", Metrics.SubscribedTargets)
+	http.HandleFunc("/subscribe", httputil.TrackConnections(clientTarpit.wrap(util.ParseCtx(clientTenants.deny(clientAuthorization.deny(subscriptions.subscribeHandler)), util.HeaderUUIDAPI))))
+	http.HandleFunc("/metrics/typeahead/", clientTenants.deny(clientAuthorization.deny(typeahead.handler)))
+	http.HandleFunc("/info/", httputil.TrackConnections(clientTarpit.wrap(httputil.TimeHandler(util.ParseCtx(clientTenants.wrap(clientOverride.wrap(clientAuthorization.wrap(requests.wrap("info", "target", infoHandler)))), util.HeaderUUIDAPI), bucketRequestTimes))))
+	http.HandleFunc("/explain", util.ParseCtx(clientTenants.wrap(clientOverride.wrap(clientAuthorization.wrap(explainHandler))), util.HeaderUUIDAPI))
 	http.HandleFunc("/lb_check", lbCheckHandler)
+	http.HandleFunc("/debug/requests", admins.wrap(requests.handler))
+	http.HandleFunc("/admin/stale", httputil.TrackConnections(clientTarpit.wrap(admins.wrap(staleHandler))))
+	http.HandleFunc("/debug/render_stats", admins.wrap(renderStatistics.handler))
+	backends := newBackendsAdmin(admins, config.Admin)
+	http.HandleFunc("/admin/backends", backends.handler)
+	http.HandleFunc("/admin/backends/drain", backends.handler)
 
 	// nothing in the config? check the environment
 	if config.Graphite.Host == "" {
@@ -745,13 +1186,7 @@ func main() {
 
 		/* #nosec */
 		hostname, _ := os.Hostname()
-		hostname = strings.Replace(hostname, ".", "_", -1)
-
-		prefix := config.Graphite.Prefix
-
-		pattern := config.Graphite.Pattern
-		pattern = strings.Replace(pattern, "{prefix}", prefix, -1)
-		pattern = strings.Replace(pattern, "{fqdn}", hostname, -1)
+		pattern := instance.GraphitePattern(config.Graphite.Pattern, config.Graphite.Prefix, config.Instance, hostname)
 
 		graphite.Register(fmt.Sprintf("%s.find_requests", pattern), Metrics.FindRequests)
 		graphite.Register(fmt.Sprintf("%s.find_errors", pattern), Metrics.FindErrors)
@@ -759,10 +1194,41 @@ func main() {
 		graphite.Register(fmt.Sprintf("%s.render_requests", pattern), Metrics.RenderRequests)
 		graphite.Register(fmt.Sprintf("%s.render_errors", pattern), Metrics.RenderErrors)
 
+		graphite.Register(fmt.Sprintf("%s.stream_requests", pattern), Metrics.StreamRequests)
+		graphite.Register(fmt.Sprintf("%s.subscribe_requests", pattern), Metrics.SubscribeRequests)
+		graphite.Register(fmt.Sprintf("%s.subscribed_targets", pattern), Metrics.SubscribedTargets)
+		graphite.Register(fmt.Sprintf("%s.precompute_hits", pattern), Metrics.PrecomputeHits)
+		graphite.Register(fmt.Sprintf("%s.passthrough_responses", pattern), Metrics.Passthrough)
+		graphite.Register(fmt.Sprintf("%s.clamped_requests", pattern), Metrics.Clamped)
+		graphite.Register(fmt.Sprintf("%s.authorization_denied", pattern), Metrics.AuthorizationDenied)
+
 		graphite.Register(fmt.Sprintf("%s.info_requests", pattern), Metrics.InfoRequests)
 		graphite.Register(fmt.Sprintf("%s.info_errors", pattern), Metrics.InfoErrors)
 
 		graphite.Register(fmt.Sprintf("%s.timeouts", pattern), Metrics.Timeouts)
+		graphite.Register(fmt.Sprintf("%s.not_found", pattern), Metrics.NotFound)
+		graphite.Register(fmt.Sprintf("%s.retry_budget_exhausted", pattern), Metrics.RetryBudgetExhausted)
+		graphite.Register(fmt.Sprintf("%s.decode_errors", pattern), Metrics.DecodeErrors)
+		for _, kind := range helper.AnomalyKinds {
+			kind := kind
+			graphite.Register(fmt.Sprintf("%s.decode_anomalies.%s", pattern, kind), expvar.Func(func() interface{} { return helper.DecodeAnomalies(kind) }))
+		}
+		graphite.Register(fmt.Sprintf("%s.quarantined_servers", pattern), Metrics.QuarantinedServers)
+		graphite.Register(fmt.Sprintf("%s.divergent_points", pattern), Metrics.DivergentPoints)
+		graphite.Register(fmt.Sprintf("%s.replica_mismatches", pattern), Metrics.MismatchedPoints)
+		graphite.Register(fmt.Sprintf("%s.protocol_downgrades", pattern), Metrics.ProtocolDowngrades)
+		graphite.Register(fmt.Sprintf("%s.carbonlink_errors", pattern), Metrics.CarbonlinkErrors)
+		graphite.Register(fmt.Sprintf("%s.failovers", pattern), Metrics.Failovers)
+		graphite.Register(fmt.Sprintf("%s.failover_active_cluster", pattern), Metrics.ActiveCluster)
+		graphite.Register(fmt.Sprintf("%s.truncated_responses", pattern), Metrics.TruncatedResponses)
+		graphite.Register(fmt.Sprintf("%s.tarpit_delayed", pattern), Metrics.TarpitDelayed)
+		graphite.Register(fmt.Sprintf("%s.tarpit_rejected", pattern), Metrics.TarpitRejected)
+
+		for name, h := range phases.All() {
+			for bucket, v := range h.Buckets() {
+				graphite.Register(fmt.Sprintf("%s.phases.%s.%s", pattern, name, bucket), v)
+			}
+		}
 
 		for i := 0; i <= config.Buckets; i++ {
 			graphite.Register(fmt.Sprintf("%s.requests_in_%dms_to_%dms", pattern, i*100, (i+1)*100), bucketEntry(i))
@@ -788,6 +1254,18 @@ func main() {
 		graphite.Register(fmt.Sprintf("%s.total_alloc", pattern), &mstats.TotalAlloc)
 		graphite.Register(fmt.Sprintf("%s.num_gc", pattern), &mstats.NumGC)
 		graphite.Register(fmt.Sprintf("%s.pause_ns", pattern), &mstats.PauseNS)
+
+		namespaces.register = func(namespace string, v expvar.Var) {
+			graphite.Register(fmt.Sprintf("%s.namespaces.%s.metrics", pattern, namespace), v)
+		}
+	}
+
+	if config.CardinalityInterval > 0 {
+		go namespaces.run(config.CardinalityInterval)
+	}
+
+	if typeahead != nil {
+		go typeahead.run(config.Typeahead.RefreshInterval)
 	}
 
 	if *pidFile != "" {
@@ -820,6 +1298,86 @@ func main() {
 	}
 }
 
+// parseConfig unmarshals config, options can be overridden by environment variables with envPrefix
+func parseConfig(data []byte, format, envPrefix string, c *carbonzipperConfig) error {
+	v := viper.New()
+	v.SetConfigType(format)
+	err := v.ReadConfig(bytes.NewBuffer(data))
+	if err != nil {
+		return err
+	}
+
+	if envPrefix != "" {
+		v.SetEnvPrefix(envPrefix)
+	}
+	v.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
+	v.AutomaticEnv()
+
+	if err := v.Unmarshal(c); err != nil {
+		return err
+	}
+	return c.Renames.compile()
+}
+
+func newZipperConfig(c *carbonzipperConfig) *zipperConfig.Config {
+	return &zipperConfig.Config{
+		ConcurrencyLimitPerServer: c.ConcurrencyLimitPerServer,
+		MaxIdleConnsPerHost:       c.MaxIdleConnsPerHost,
+		Transport:                 c.Transport,
+		Backends:                  c.Backends,
+		BackendsV2:                c.Backendsv2,
+		ExpireDelaySec:            c.ExpireDelaySec,
+
+		CarbonSearch:      c.CarbonSearch,
+		CarbonSearchV2:    c.CarbonSearchV2,
+		Timeouts:          c.Timeouts,
+		KeepAliveInterval: c.KeepAliveInterval,
+		RetryBudget:       c.RetryBudget,
+		NotFoundCacheTTL:  c.NotFoundCacheTTL,
+		DecodeQuarantine:  c.DecodeQuarantine,
+		StrictDecode:      c.StrictDecode,
+		MaxResponseSize:   c.MaxResponseSize,
+		StatePersistence:  c.StatePersistence,
+		Carbonlink:        c.Carbonlink,
+		Routes:            c.Routes,
+		StorageTiers:      c.StorageTiers,
+		Failover:          c.Failover,
+		ExpandGlobs:       c.ExpandGlobs,
+		ConsolidateBy:     c.ConsolidateBy,
+		XFilesFactor:      c.XFilesFactor,
+		MergePolicy:       c.MergePolicy,
+		Quorum:            c.Quorum,
+	}
+}
+
+// reloadZipper creates zipper with backends from the new config and replaces current one. New config snapshot is
+// stored as well, so options that are read while serving requests, e.x. renames, postProcess and retention, are
+// applied too. Other options require restart to be applied.
+func reloadZipper(data []byte, format, envPrefix string, c carbonzipperConfig) error {
+	err := parseConfig(data, format, envPrefix, &c)
+	if err != nil {
+		return err
+	}
+	if len(c.Backends) == 0 && len(c.Backendsv2.Backends) == 0 {
+		return errNoBackends
+	}
+	resolved, err := resolveBackends(&c)
+	if err != nil {
+		return err
+	}
+
+	return updateConfig(func(current *carbonzipperConfig) error {
+		if err := replaceZipper(&resolved); err != nil {
+			return err
+		}
+		if !reflect.DeepEqual(current.Precompute, c.Precompute) {
+			replacePrecomputed(c.Precompute)
+		}
+		*current = c
+		return nil
+	})
+}
+
 var timeBuckets []int64
 
 type bucketEntry int
@@ -833,17 +1391,19 @@ func renderTimeBuckets() interface{} {
 }
 
 func bucketRequestTimes(req *http.Request, t time.Duration) {
-	logger := zapwriter.Logger("slow")
+	logger := instance.Logger("slow")
 
 	ms := t.Nanoseconds() / int64(time.Millisecond)
 
 	bucket := int(ms / 100)
+	// buckets are allocated at start, the option may be different in the reloaded config
+	overflow := len(timeBuckets) - 1
 
-	if bucket < config.Buckets {
+	if bucket < overflow {
 		atomic.AddInt64(&timeBuckets[bucket], 1)
 	} else {
 		// Too big? Increment overflow bucket and log
-		atomic.AddInt64(&timeBuckets[config.Buckets], 1)
+		atomic.AddInt64(&timeBuckets[overflow], 1)
 		logger.Warn("Slow Request",
 			zap.Duration("time", t),
 			zap.String("url", req.URL.String()),
@@ -856,6 +1416,7 @@ func sendStats(stats *types.Stats) {
 		return
 	}
 	Metrics.Timeouts.Add(stats.Timeouts)
+	Metrics.NotFound.Add(stats.NotFound)
 	Metrics.FindErrors.Add(stats.FindErrors)
 	Metrics.RenderErrors.Add(stats.RenderErrors)
 	Metrics.InfoErrors.Add(stats.InfoErrors)
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"math"
+	"testing"
+
+	"github.com/go-graphite/carbonapi/zipper/types"
+	protov3 "github.com/go-graphite/protocol/carbonapi_v3_pb"
+)
+
+func TestDefaultConsolidateBy(t *testing.T) {
+	c := defaultConfig
+	if err := parseConfig([]byte("listen: \":8080\"\n"), "YAML", "", &c); err != nil {
+		t.Fatal(err)
+	}
+	consolidateBy := newZipperConfig(&c).ConsolidateBy
+	if consolidateBy != "avg" {
+		t.Fatalf("unexpected default consolidateBy %q", consolidateBy)
+	}
+
+	// series with different resolutions are consolidated instead of dropping the coarser one
+	types.SetDefaultConsolidateBy(consolidateBy)
+	defer types.SetDefaultConsolidateBy("")
+	fine := protov3.FetchResponse{StartTime: 120, StepTime: 60, StopTime: 480, Values: []float64{1, 2, 3, 4, 5, 6}}
+	coarse := protov3.FetchResponse{StartTime: 120, StepTime: 120, StopTime: 480, Values: []float64{math.NaN(), 100, math.NaN()}}
+	if err := types.MergeFetchResponsesWithOptions(&fine, &coarse, types.MergeOptionsFromContext(context.Background())); err != nil {
+		t.Fatal(err)
+	}
+	expected := []float64{1.5, 100, 5.5}
+	if fine.StepTime != 120 || len(fine.Values) != len(expected) {
+		t.Fatalf("unexpected merged series %+v", fine)
+	}
+	for i := range expected {
+		if fine.Values[i] != expected[i] {
+			t.Fatalf("unexpected merged values %v, expected %v", fine.Values, expected)
+		}
+	}
+}
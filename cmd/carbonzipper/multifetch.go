@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+
+	"github.com/go-graphite/carbonapi/zipper/types"
+	protov3 "github.com/go-graphite/protocol/carbonapi_v3_pb"
+)
+
+// maxMultiFetchRequestSize limits body of carbonapi_v3_pb render request
+const maxMultiFetchRequestSize = 16 << 20
+
+// parseMultiFetchRequest decodes body of carbonapi_v3_pb render request. Unlike URL targets, every metric of it
+// has its own time range.
+func parseMultiFetchRequest(req *http.Request) (*protov3.MultiFetchRequest, error) {
+	if req.Body == nil {
+		return &protov3.MultiFetchRequest{}, nil
+	}
+	b, err := ioutil.ReadAll(http.MaxBytesReader(nil, req.Body, maxMultiFetchRequestSize))
+	if err != nil {
+		return nil, err
+	}
+	var request protov3.MultiFetchRequest
+	if err := request.Unmarshal(b); err != nil {
+		return nil, err
+	}
+	for _, m := range request.Metrics {
+		if m.Name == "" {
+			return nil, fmt.Errorf("metric without name")
+		}
+		if m.StartTime > m.StopTime {
+			return nil, fmt.Errorf("%s: from is after until", m.Name)
+		}
+	}
+	return &request, nil
+}
+
+// fetchMultiFetch fetches all metrics of carbonapi_v3_pb request at once, so backends are asked once for the
+// whole request
+func fetchMultiFetch(ctx context.Context, request *protov3.MultiFetchRequest) (*protov3.MultiFetchResponse, error) {
+	names := make([]string, 0, len(request.Metrics))
+	for _, m := range request.Metrics {
+		names = append(names, m.Name)
+	}
+	rewritten, renames := getConfig().Renames.rewrite(names)
+	if renames != nil {
+		renamed := &protov3.MultiFetchRequest{Metrics: make([]protov3.FetchRequest, len(request.Metrics))}
+		copy(renamed.Metrics, request.Metrics)
+		for i := range renamed.Metrics {
+			renamed.Metrics[i].Name = rewritten[i]
+			renamed.Metrics[i].PathExpression = rewritten[i]
+		}
+		request = renamed
+	}
+
+	res, stats, err := getZipper().FetchProtoV3(ctx, request)
+	sendStats(stats)
+	recordStats(ctx, stats)
+	recordFailedServers(ctx, stats)
+	if err != nil {
+		return nil, err
+	}
+	if len(res.Metrics) == 0 {
+		return nil, types.ErrNotFound
+	}
+
+	for i := range res.Metrics {
+		res.Metrics[i].PathExpression, res.Metrics[i].Name = renames.restoreFetched(res.Metrics[i].PathExpression, res.Metrics[i].Name)
+	}
+
+	fetched := make([]string, 0, len(res.Metrics))
+	for _, m := range res.Metrics {
+		fetched = append(fetched, m.Name)
+	}
+	if err := authorizeMetrics(ctx, fetched); err != nil {
+		return nil, err
+	}
+	getConfig().PostProcess.applyV3(res.Metrics)
+	return res, nil
+}
+
+// removeEmptySeriesV3 drops carbonapi_v3_pb series that have no points
+func removeEmptySeriesV3(series []protov3.FetchResponse) []protov3.FetchResponse {
+	res := make([]protov3.FetchResponse, 0, len(series))
+	for _, s := range series {
+		for _, v := range s.Values {
+			if !math.IsNaN(v) {
+				res = append(res, s)
+				break
+			}
+		}
+	}
+	return res
+}
+
+// encodeMultiFetchResponse writes carbonapi_v3_pb response and returns its size
+func encodeMultiFetchResponse(w http.ResponseWriter, metrics *protov3.MultiFetchResponse, failed []string) (int, error) {
+	b, err := metrics.Marshal()
+	if err != nil {
+		return 0, err
+	}
+	setPartialResultHeader(w, failed)
+	w.Header().Set("Content-Type", contentTypeCarbonAPIv3PB)
+	/* #nosec */
+	_, _ = w.Write(b)
+	return len(b), nil
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	util "github.com/go-graphite/carbonapi/util/ctx"
+)
+
+// backendsOverrideHeader restricts the request to the listed backends, same as `backends` parameter
+const backendsOverrideHeader = "X-Zipper-Backends"
+
+// BackendOverrideConfig allows identities (see AuthorizationConfig.IdentityHeader) to restrict their requests to
+// some of the backends, e.x. to find out which storage node returns bad data. Identities may be globs, "*" allows
+// any admin. Requests must have admin credentials (see AdminConfig), override is disabled if admin API is disabled
+// or there are no identities.
+type BackendOverrideConfig struct {
+	Identities []string `mapstructure:"identities"`
+}
+
+// backendOverride restricts request to backend groups or servers that are listed in X-Zipper-Backends header or
+// `backends` parameter, comma separated
+type backendOverride struct {
+	admins     *adminAccess
+	identities []string
+}
+
+func newBackendOverride(admins *adminAccess, c BackendOverrideConfig) *backendOverride {
+	if admins == nil || len(c.Identities) == 0 {
+		return nil
+	}
+	return &backendOverride{
+		admins:     admins,
+		identities: c.Identities,
+	}
+}
+
+// requestedBackends returns backends listed in the request, nil if there are none
+func requestedBackends(req *http.Request) []string {
+	value := req.Header.Get(backendsOverrideHeader)
+	if value == "" {
+		value = req.FormValue("backends")
+	}
+	var backends []string
+	for _, b := range strings.Split(value, ",") {
+		if b = strings.TrimSpace(b); b != "" {
+			backends = append(backends, b)
+		}
+	}
+	return backends
+}
+
+// wrap restricts backends that h may query to the ones the request lists. Requests of identities that aren't allowed
+// to do that and requests that are already restricted, e.x. by tenant, are refused.
+func (o *backendOverride) wrap(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		backends := requestedBackends(req)
+		if backends == nil {
+			h(w, req)
+			return
+		}
+		if o == nil {
+			http.Error(w, "backend override is disabled", http.StatusForbidden)
+			return
+		}
+		identity, reason := o.admins.check(req)
+		if reason != "" || !matchesAny(o.identities, identity, identityMatch) {
+			http.Error(w, "backend override is not allowed", http.StatusForbidden)
+			return
+		}
+		if util.GetAllowedBackends(req.Context()) != nil {
+			http.Error(w, "backend override is not allowed for the tenant", http.StatusForbidden)
+			return
+		}
+		h(w, req.WithContext(util.SetAllowedBackends(req.Context(), backends)))
+	}
+}
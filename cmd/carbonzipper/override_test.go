@@ -0,0 +1,72 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	util "github.com/go-graphite/carbonapi/util/ctx"
+)
+
+func TestBackendOverride(t *testing.T) {
+	admins := newAdminAccess("X-Identity", AdminConfig{Identities: []string{"*"}, Tokens: []string{"secret"}})
+	override := newBackendOverride(admins, BackendOverrideConfig{Identities: []string{"admin-*"}})
+	if newBackendOverride(nil, BackendOverrideConfig{Identities: []string{"*"}}) != nil {
+		t.Fatalf("override can't be enabled without admin API")
+	}
+
+	var allowed []string
+	h := func(w http.ResponseWriter, req *http.Request) {
+		allowed = util.GetAllowedBackends(req.Context())
+	}
+
+	tests := []struct {
+		name     string
+		url      string
+		header   string
+		identity string
+		token    string
+		tenant   []string
+		code     int
+		allowed  []string
+	}{
+		{"not restricted", "/render/?target=foo", "", "", "", nil, http.StatusOK, nil},
+		{"header", "/render/?target=foo", "group1, http://10.0.0.1:8080", "admin-joe", "secret", nil, http.StatusOK, []string{"group1", "http://10.0.0.1:8080"}},
+		{"parameter", "/render/?target=foo&backends=group2", "", "admin-joe", "secret", nil, http.StatusOK, []string{"group2"}},
+		{"not admin", "/render/?target=foo&backends=group2", "", "joe", "secret", nil, http.StatusForbidden, nil},
+		{"spoofed identity", "/render/?target=foo&backends=group2", "", "admin-joe", "", nil, http.StatusForbidden, nil},
+		{"wrong token", "/render/?target=foo&backends=group2", "", "admin-joe", "guess", nil, http.StatusForbidden, nil},
+		{"tenant", "/render/?target=foo&backends=group2", "", "admin-joe", "secret", []string{"group1"}, http.StatusForbidden, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			allowed = nil
+			req := httptest.NewRequest("GET", tt.url, nil)
+			if tt.header != "" {
+				req.Header.Set(backendsOverrideHeader, tt.header)
+			}
+			req.Header.Set("X-Identity", tt.identity)
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			if tt.tenant != nil {
+				req = req.WithContext(util.SetAllowedBackends(req.Context(), tt.tenant))
+			}
+
+			w := httptest.NewRecorder()
+			override.wrap(h)(w, req)
+			if w.Code != tt.code || !reflect.DeepEqual(allowed, tt.allowed) {
+				t.Fatalf("unexpected result: code %v, allowed backends %v", w.Code, allowed)
+			}
+		})
+	}
+
+	// disabled override refuses requests that use it
+	var disabled *backendOverride
+	w := httptest.NewRecorder()
+	disabled.wrap(h)(w, httptest.NewRequest("GET", "/render/?target=foo&backends=group1", nil))
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("expected request to be refused, got %v", w.Code)
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strings"
+
+	util "github.com/go-graphite/carbonapi/util/ctx"
+	"github.com/go-graphite/carbonapi/zipper/types"
+)
+
+type renderResultKey int
+
+const failedServersKey renderResultKey = 0
+
+// failedServers collects backends that failed while serving the request
+type failedServers map[string]struct{}
+
+func withFailedServers(ctx context.Context) (context.Context, failedServers) {
+	f := make(failedServers)
+	return context.WithValue(ctx, failedServersKey, f), f
+}
+
+// recordFailedServers adds servers that failed according to stats to the request's list, if it's tracked
+func recordFailedServers(ctx context.Context, stats *types.Stats) {
+	if stats == nil {
+		return
+	}
+	if f, ok := ctx.Value(failedServersKey).(failedServers); ok {
+		for _, s := range stats.FailedServers {
+			f[s] = struct{}{}
+		}
+	}
+}
+
+func (f failedServers) list() []string {
+	res := make([]string, 0, len(f))
+	for s := range f {
+		res = append(res, s)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// setPartialResultHeader marks response as incomplete if any of the backends failed
+func setPartialResultHeader(w http.ResponseWriter, failed []string) {
+	if len(failed) > 0 {
+		w.Header().Set(util.HeaderPartialResult, strings.Join(failed, ","))
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/go-graphite/carbonapi/zipper/types"
+	protov2 "github.com/go-graphite/protocol/carbonapi_v2_pb"
+)
+
+func TestPartialResult(t *testing.T) {
+	ctx, failed := withFailedServers(context.Background())
+	recordFailedServers(ctx, &types.Stats{FailedServers: []string{"b", "a"}})
+	recordFailedServers(ctx, &types.Stats{FailedServers: []string{"a"}})
+	recordFailedServers(ctx, nil)
+	missing := failed.list()
+	if !reflect.DeepEqual(missing, []string{"a", "b"}) {
+		t.Fatalf("unexpected failed servers %v", missing)
+	}
+
+	metrics := &protov2.MultiFetchResponse{Metrics: []protov2.FetchResponse{testSeries("foo", []float64{1}, []bool{false})}}
+	w := httptest.NewRecorder()
+	if _, err := encodeRenderResponse(w, formatTypeJSON, metrics, missing, nil); err != nil {
+		t.Fatal(err)
+	}
+	if h := w.Header().Get("X-Partial-Result"); h != "a,b" {
+		t.Fatalf("unexpected header %q", h)
+	}
+	var res []struct {
+		MissingBackends []string `json:"missing_backends"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != 1 || !reflect.DeepEqual(res[0].MissingBackends, missing) {
+		t.Fatalf("unexpected response %s", w.Body.Bytes())
+	}
+
+	w = httptest.NewRecorder()
+	if _, err := encodeRenderResponse(w, formatTypeJSON, metrics, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := w.Header()["X-Partial-Result"]; ok {
+		t.Fatal("complete response is marked as partial")
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"math"
+
+	protov2 "github.com/go-graphite/protocol/carbonapi_v2_pb"
+	protov3 "github.com/go-graphite/protocol/carbonapi_v3_pb"
+)
+
+// PostProcessConfig describes linear transform (e.x. unit conversion) and clamping that is applied to every fetched
+// series under Prefix: value*Scale + Offset, limited to [Min, Max]
+type PostProcessConfig struct {
+	Prefix string   `mapstructure:"prefix"`
+	Scale  *float64 `mapstructure:"scale"`
+	Offset float64  `mapstructure:"offset"`
+	Min    *float64 `mapstructure:"min"`
+	Max    *float64 `mapstructure:"max"`
+}
+
+// postProcessRules are applied to series right after they were fetched, so aggregations and transforms
+// evaluated by carbonzipper see converted values. First matching rule wins.
+type postProcessRules []PostProcessConfig
+
+func (r postProcessRules) match(name string) *PostProcessConfig {
+	for i := range r {
+		if hasPathPrefix(name, r[i].Prefix) {
+			return &r[i]
+		}
+	}
+	return nil
+}
+
+func (c *PostProcessConfig) convert(v float64) float64 {
+	scale := 1.0
+	if c.Scale != nil {
+		scale = *c.Scale
+	}
+	v = v*scale + c.Offset
+	if c.Min != nil && v < *c.Min {
+		v = *c.Min
+	}
+	if c.Max != nil && v > *c.Max {
+		v = *c.Max
+	}
+	return v
+}
+
+// apply transforms series in place
+func (r postProcessRules) apply(series []protov2.FetchResponse) {
+	for i := range series {
+		rule := r.match(series[i].Name)
+		if rule == nil {
+			continue
+		}
+		for j, v := range series[i].Values {
+			if !series[i].IsAbsent[j] {
+				series[i].Values[j] = rule.convert(v)
+			}
+		}
+	}
+}
+
+// applyV3 transforms carbonapi_v3_pb series in place, absent points are NaN there
+func (r postProcessRules) applyV3(series []protov3.FetchResponse) {
+	for i := range series {
+		rule := r.match(series[i].Name)
+		if rule == nil {
+			continue
+		}
+		for j, v := range series[i].Values {
+			if !math.IsNaN(v) {
+				series[i].Values[j] = rule.convert(v)
+			}
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"math"
+	"reflect"
+	"testing"
+
+	protov2 "github.com/go-graphite/protocol/carbonapi_v2_pb"
+	protov3 "github.com/go-graphite/protocol/carbonapi_v3_pb"
+)
+
+func TestPostProcessRules(t *testing.T) {
+	scale, min, max := 1.8, 0.0, 100.0
+	r := postProcessRules{
+		{Prefix: "sensors.celsius", Scale: &scale, Offset: 32},
+		{Prefix: "sensors", Min: &min, Max: &max},
+	}
+
+	series := []protov2.FetchResponse{
+		testSeries("sensors.celsius.room", []float64{0, 100, 0}, []bool{false, false, true}),
+		testSeries("sensors.humidity", []float64{-5, 50, 120}, []bool{false, false, false}),
+		testSeries("other.metric", []float64{-5}, []bool{false}),
+	}
+	r.apply(series)
+
+	expected := [][]float64{{32, 212, 0}, {0, 50, 100}, {-5}}
+	for i, s := range series {
+		if !reflect.DeepEqual(s.Values, expected[i]) {
+			t.Errorf("%v: got %v, expected %v", s.Name, s.Values, expected[i])
+		}
+	}
+}
+
+func TestPostProcessRulesV3(t *testing.T) {
+	scale := 1.8
+	r := postProcessRules{{Prefix: "sensors.celsius", Scale: &scale, Offset: 32}}
+
+	series := []protov3.FetchResponse{
+		{Name: "sensors.celsius.room", Values: []float64{0, 100, math.NaN()}},
+		{Name: "other.metric", Values: []float64{math.NaN(), math.NaN()}},
+	}
+	r.applyV3(series)
+
+	if v := series[0].Values; v[0] != 32 || v[1] != 212 || !math.IsNaN(v[2]) {
+		t.Errorf("unexpected values %v", v)
+	}
+
+	series = removeEmptySeriesV3(series)
+	if len(series) != 1 || series[0].Name != "sensors.celsius.room" {
+		t.Errorf("unexpected series after removing empty ones %+v", series)
+	}
+}
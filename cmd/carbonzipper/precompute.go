@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	util "github.com/go-graphite/carbonapi/util/ctx"
+	"github.com/go-graphite/carbonapi/util/instance"
+	"github.com/go-graphite/carbonapi/zipper/types"
+	protov2 "github.com/go-graphite/protocol/carbonapi_v2_pb"
+	"github.com/satori/go.uuid"
+	"go.uber.org/zap"
+)
+
+// PrecomputeConfig describes recurring query that is fetched on schedule
+type PrecomputeConfig struct {
+	Targets  []string      `mapstructure:"targets"`
+	Range    time.Duration `mapstructure:"range"`
+	Interval time.Duration `mapstructure:"interval"`
+}
+
+type precomputedQuery struct {
+	PrecomputeConfig
+	key   string
+	fetch func(ctx context.Context, targets []string, from, until int32) (*protov2.MultiFetchResponse, error)
+
+	sync.RWMutex
+	result *protov2.MultiFetchResponse
+	until  int32
+}
+
+// precomputedQueries keeps results of recurring queries, so matching requests can be served without going to backends
+type precomputedQueries struct {
+	queries map[string]*precomputedQuery
+	cancel  context.CancelFunc
+	running sync.WaitGroup
+}
+
+// precomputedInstance holds current *precomputedQueries, they are replaced when config is reloaded
+var precomputedInstance atomic.Value
+
+func getPrecomputed() *precomputedQueries {
+	p, _ := precomputedInstance.Load().(*precomputedQueries)
+	return p
+}
+
+// replacePrecomputed starts refresh of the queries of cfg and stops refresh of the current ones
+func replacePrecomputed(cfg []PrecomputeConfig) {
+	p := newPrecomputedQueries(cfg)
+	p.start()
+	old := getPrecomputed()
+	precomputedInstance.Store(p)
+	old.stop()
+}
+
+func targetsKey(targets []string) string {
+	sorted := make([]string, len(targets))
+	copy(sorted, targets)
+	sort.Strings(sorted)
+	return strings.Join(sorted, "\x00")
+}
+
+func newPrecomputedQueries(cfg []PrecomputeConfig) *precomputedQueries {
+	if len(cfg) == 0 {
+		return nil
+	}
+	p := &precomputedQueries{
+		queries: make(map[string]*precomputedQuery),
+	}
+	for _, c := range cfg {
+		if len(c.Targets) == 0 || c.Range <= 0 || c.Interval <= 0 {
+			continue
+		}
+		q := &precomputedQuery{
+			PrecomputeConfig: c,
+			key:              targetsKey(c.Targets),
+			fetch:            fetchRenderTargets,
+		}
+		p.queries[q.key] = q
+	}
+	return p
+}
+
+// start runs background refresh of every query till stop is called
+func (p *precomputedQueries) start() {
+	if p == nil {
+		return
+	}
+	var ctx context.Context
+	ctx, p.cancel = context.WithCancel(context.Background())
+	for _, q := range p.queries {
+		p.running.Add(1)
+		go func(q *precomputedQuery) {
+			defer p.running.Done()
+			q.run(ctx)
+		}(q)
+	}
+}
+
+// stop cancels background refresh of the queries and waits for it to finish
+func (p *precomputedQueries) stop() {
+	if p == nil || p.cancel == nil {
+		return
+	}
+	p.cancel()
+	p.running.Wait()
+}
+
+func (q *precomputedQuery) refresh(ctx context.Context) {
+	logger := instance.Logger("precompute").With(zap.Strings("targets", q.Targets))
+	ctx, cancel := context.WithTimeout(ctx, q.Interval)
+	defer cancel()
+	ctx = util.SetUUID(ctx, uuid.NewV4().String())
+
+	until := int32(time.Now().Unix())
+	from := until - int32(q.Range.Seconds())
+	res, err := q.fetch(ctx, q.Targets, from, until)
+	if ctx.Err() == context.Canceled {
+		return
+	}
+	if err != nil && err != types.ErrNotFound {
+		logger.Warn("failed to precompute query", zap.Error(err))
+		return
+	}
+
+	q.Lock()
+	q.result = res
+	q.until = until
+	q.Unlock()
+}
+
+func (q *precomputedQuery) run(ctx context.Context) {
+	ticker := time.NewTicker(q.Interval)
+	defer ticker.Stop()
+	for {
+		q.refresh(ctx)
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// lookup returns precomputed result if request matches one of the queries: it has the same targets, the same range and
+// it's not older than refresh interval.
+func (p *precomputedQueries) lookup(targets []string, from, until int32) (*protov2.MultiFetchResponse, bool) {
+	if p == nil {
+		return nil, false
+	}
+	q, ok := p.queries[targetsKey(targets)]
+	if !ok {
+		return nil, false
+	}
+
+	q.RLock()
+	defer q.RUnlock()
+	if q.result == nil {
+		return nil, false
+	}
+
+	tolerance := int32(q.Interval.Seconds())
+	if abs32(until-q.until) > tolerance || abs32(until-from-int32(q.Range.Seconds())) > tolerance {
+		return nil, false
+	}
+	return q.result, true
+}
+
+func abs32(v int32) int32 {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	protov2 "github.com/go-graphite/protocol/carbonapi_v2_pb"
+)
+
+func TestPrecomputedLookup(t *testing.T) {
+	p := newPrecomputedQueries([]PrecomputeConfig{
+		{Targets: []string{"a.*", "sumSeries(b.*)"}, Range: time.Hour, Interval: 30 * time.Second},
+		{Targets: []string{"invalid"}},
+	})
+	if len(p.queries) != 1 {
+		t.Fatalf("unexpected amount of queries %v", len(p.queries))
+	}
+
+	targets := []string{"sumSeries(b.*)", "a.*"}
+	if _, ok := p.lookup(targets, 1000, 4600); ok {
+		t.Fatal("query that wasn't computed yet shouldn't match")
+	}
+
+	res := &protov2.MultiFetchResponse{}
+	q := p.queries[targetsKey(targets)]
+	q.result = res
+	q.until = 4600
+
+	tests := []struct {
+		targets     []string
+		from, until int32
+		ok          bool
+	}{
+		{targets, 1000, 4600, true},
+		{targets, 1020, 4620, true},
+		{targets, 1000, 4700, false},
+		{targets, 4000, 4600, false},
+		{[]string{"a.*"}, 1000, 4600, false},
+	}
+	for _, tt := range tests {
+		r, ok := p.lookup(tt.targets, tt.from, tt.until)
+		if ok != tt.ok || (ok && r != res) {
+			t.Errorf("lookup(%v, %v, %v): got %v, expected %v", tt.targets, tt.from, tt.until, ok, tt.ok)
+		}
+	}
+
+	var empty *precomputedQueries
+	if _, ok := empty.lookup(targets, 1000, 4600); ok {
+		t.Fatal("nil precomputed queries shouldn't match anything")
+	}
+}
+
+func TestPrecomputedStop(t *testing.T) {
+	p := newPrecomputedQueries([]PrecomputeConfig{
+		{Targets: []string{"a.*"}, Range: time.Hour, Interval: time.Hour},
+	})
+	var fetches int32
+	fetched := make(chan struct{})
+	for _, q := range p.queries {
+		q.fetch = func(ctx context.Context, targets []string, from, until int32) (*protov2.MultiFetchResponse, error) {
+			if atomic.AddInt32(&fetches, 1) == 1 {
+				close(fetched)
+			}
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}
+	}
+
+	p.start()
+	<-fetched
+	// stop cancels running refresh and waits for it
+	p.stop()
+	if n := atomic.LoadInt32(&fetches); n != 1 {
+		t.Fatalf("unexpected amount of fetches %v", n)
+	}
+	if _, ok := p.lookup([]string{"a.*"}, 1000, 4600); ok {
+		t.Fatal("cancelled refresh shouldn't store result")
+	}
+
+	var empty *precomputedQueries
+	empty.stop()
+}
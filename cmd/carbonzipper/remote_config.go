@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-graphite/carbonapi/util/instance"
+	"github.com/go-graphite/carbonapi/zipper"
+	"go.uber.org/zap"
+)
+
+var (
+	errConfigNotFound    = errors.New("config not found")
+	errConfigSignature   = errors.New("config signature verification failed")
+	errEmptySignatureKey = errors.New("config signature key is empty")
+	errUnsupportedScheme = errors.New("unsupported config source")
+	errNoBackends        = errors.New("no Backends loaded")
+)
+
+// zipperInstance holds current *zipper.Zipper. It's replaced when config is reloaded from the remote source.
+var zipperInstance atomic.Value
+
+func getZipper() *zipper.Zipper {
+	return zipperInstance.Load().(*zipper.Zipper)
+}
+
+// configSource loads config from the local file or from the remote location:
+//
+//	http(s)://host/path     - plain HTTP GET
+//	consul://host:port/key  - Consul KV
+//	etcd://host:port/key    - etcd v3 (JSON gateway)
+//
+// If signature key is set, signature is loaded from the same location with ".sig" suffix (hex-encoded HMAC-SHA256
+// of the config) and config is rejected unless it matches.
+type configSource struct {
+	location     string
+	signatureKey []byte
+	client       *http.Client
+}
+
+func newConfigSource(location, signatureKeyFile string) (*configSource, error) {
+	s := &configSource{
+		location: location,
+		client:   &http.Client{Timeout: 10 * time.Second},
+	}
+	if signatureKeyFile == "" {
+		return s, nil
+	}
+
+	key, err := ioutil.ReadFile(signatureKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	s.signatureKey = bytes.TrimSpace(key)
+	if len(s.signatureKey) == 0 {
+		return nil, errEmptySignatureKey
+	}
+	return s, nil
+}
+
+// isRemote returns true if config is not loaded from the local file
+func (s *configSource) isRemote() bool {
+	return strings.Contains(s.location, "://")
+}
+
+// format returns viper's config type based on location's extension
+func (s *configSource) format() string {
+	location := s.location
+	if u, err := url.Parse(location); err == nil && s.isRemote() {
+		location = u.Path
+	}
+	if strings.HasSuffix(location, ".toml") {
+		return "TOML"
+	}
+	return "YAML"
+}
+
+// load returns verified config
+func (s *configSource) load(ctx context.Context) ([]byte, error) {
+	data, err := s.fetch(ctx, s.location)
+	if err != nil {
+		return nil, err
+	}
+	if s.signatureKey == nil {
+		return data, nil
+	}
+
+	sig, err := s.fetch(ctx, s.signatureLocation())
+	if err != nil {
+		return nil, fmt.Errorf("failed to load config signature: %v", err)
+	}
+	return data, verifyConfig(s.signatureKey, data, sig)
+}
+
+// signatureLocation returns location of the config with ".sig" suffix added to the path
+func (s *configSource) signatureLocation() string {
+	u, err := url.Parse(s.location)
+	if err != nil || !s.isRemote() {
+		return s.location + ".sig"
+	}
+	u.Path += ".sig"
+	return u.String()
+}
+
+func verifyConfig(key, data, sig []byte) error {
+	signature, err := hex.DecodeString(string(bytes.TrimSpace(sig)))
+	if err != nil {
+		return errConfigSignature
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	if !hmac.Equal(signature, mac.Sum(nil)) {
+		return errConfigSignature
+	}
+	return nil
+}
+
+func (s *configSource) fetch(ctx context.Context, location string) ([]byte, error) {
+	if !s.isRemote() {
+		return ioutil.ReadFile(location)
+	}
+
+	u, err := url.Parse(location)
+	if err != nil {
+		return nil, err
+	}
+
+	var req *http.Request
+	switch u.Scheme {
+	case "http", "https":
+		req, err = http.NewRequest("GET", location, nil)
+	case "consul":
+		req, err = http.NewRequest("GET", "http://"+u.Host+"/v1/kv/"+strings.TrimPrefix(u.Path, "/")+"?raw", nil)
+	case "etcd":
+		body, _ := json.Marshal(map[string]string{
+			"key": base64.StdEncoding.EncodeToString([]byte(strings.TrimPrefix(u.Path, "/"))),
+		})
+		req, err = http.NewRequest("POST", "http://"+u.Host+"/v3/kv/range", bytes.NewReader(body))
+	default:
+		return nil, errUnsupportedScheme
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := s.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, errConfigNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+
+	if u.Scheme == "etcd" {
+		return decodeEtcdValue(data)
+	}
+	return data, nil
+}
+
+// decodeEtcdValue extracts value from etcd's range response
+func decodeEtcdValue(data []byte) ([]byte, error) {
+	var r struct {
+		Kvs []struct {
+			Value string `json:"value"`
+		} `json:"kvs"`
+	}
+	if err := json.Unmarshal(data, &r); err != nil {
+		return nil, err
+	}
+	if len(r.Kvs) == 0 {
+		return nil, errConfigNotFound
+	}
+	return base64.StdEncoding.DecodeString(r.Kvs[0].Value)
+}
+
+// watch periodically reloads config and calls apply when it changes. Config that can't be loaded or verified
+// is ignored, current one is kept.
+func (s *configSource) watch(interval time.Duration, current []byte, apply func([]byte) error) {
+	logger := instance.Logger("config")
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		ctx, cancel := context.WithTimeout(context.Background(), interval)
+		data, err := s.load(ctx)
+		cancel()
+		if err != nil {
+			logger.Warn("failed to reload config",
+				zap.String("config_path", s.location),
+				zap.Error(err),
+			)
+			continue
+		}
+		if bytes.Equal(data, current) {
+			continue
+		}
+		if err := apply(data); err != nil {
+			logger.Error("failed to apply new config",
+				zap.String("config_path", s.location),
+				zap.Error(err),
+			)
+			continue
+		}
+		current = data
+		logger.Info("config reloaded",
+			zap.String("config_path", s.location),
+		)
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestConfigSourceLoad(t *testing.T) {
+	const cfg = "backends:\n  - http://127.0.0.1:8080\n"
+	const key = "secret"
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(cfg))
+	sig := hex.EncodeToString(mac.Sum(nil)) + "\n"
+
+	values := map[string]string{
+		"zipper/config.yaml":     cfg,
+		"zipper/config.yaml.sig": sig,
+		"zipper/bad.yaml":        cfg,
+		"zipper/bad.yaml.sig":    hex.EncodeToString(make([]byte, sha256.Size)),
+		"zipper/nosig.yaml":      cfg,
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var key string
+		switch {
+		case strings.HasPrefix(r.URL.Path, "/v1/kv/"):
+			if _, ok := r.URL.Query()["raw"]; !ok {
+				t.Errorf("consul value must be requested as raw")
+			}
+			key = strings.TrimPrefix(r.URL.Path, "/v1/kv/")
+		case r.URL.Path == "/v3/kv/range":
+			var req struct{ Key string }
+			_ = json.NewDecoder(r.Body).Decode(&req)
+			k, _ := base64.StdEncoding.DecodeString(req.Key)
+			v, ok := values[string(k)]
+			if !ok {
+				w.Write([]byte(`{"header":{}}`))
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"kvs": []map[string]string{{"value": base64.StdEncoding.EncodeToString([]byte(v))}},
+			})
+			return
+		default:
+			key = strings.TrimPrefix(r.URL.Path, "/")
+		}
+		v, ok := values[key]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(v))
+	}))
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir("", "carbonzipper")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	keyFile := filepath.Join(dir, "config.key")
+	if err := ioutil.WriteFile(keyFile, []byte(key+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	host := strings.TrimPrefix(srv.URL, "http://")
+	tests := []struct {
+		location string
+		key      string
+		err      bool
+	}{
+		{srv.URL + "/zipper/config.yaml", "", false},
+		{srv.URL + "/zipper/config.yaml", keyFile, false},
+		{srv.URL + "/zipper/config.yaml?rev=2", keyFile, false},
+		{"consul://" + host + "/zipper/config.yaml", keyFile, false},
+		{"etcd://" + host + "/zipper/config.yaml", keyFile, false},
+		{"etcd://" + host + "/zipper/missing.yaml", "", true},
+		{srv.URL + "/zipper/bad.yaml", keyFile, true},
+		{srv.URL + "/zipper/nosig.yaml", "", false},
+		{srv.URL + "/zipper/nosig.yaml", keyFile, true},
+		{"ftp://" + host + "/zipper/config.yaml", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.location, func(t *testing.T) {
+			s, err := newConfigSource(tt.location, tt.key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, err := s.load(context.Background())
+			if (err != nil) != tt.err {
+				t.Fatalf("unexpected error %v", err)
+			}
+			if err == nil && string(data) != cfg {
+				t.Fatalf("unexpected config %q", data)
+			}
+		})
+	}
+}
+
+func TestConfigSourceFormat(t *testing.T) {
+	tests := []struct {
+		location string
+		format   string
+	}{
+		{"/etc/carbonzipper.yaml", "YAML"},
+		{"/etc/carbonzipper.toml", "TOML"},
+		{"http://config/carbonzipper.toml?rev=2", "TOML"},
+		{"consul://127.0.0.1:8500/carbonzipper", "YAML"},
+	}
+	for _, tt := range tests {
+		s := &configSource{location: tt.location}
+		if f := s.format(); f != tt.format {
+			t.Errorf("format(%v): got %v, expected %v", tt.location, f, tt.format)
+		}
+	}
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
+	protov2 "github.com/go-graphite/protocol/carbonapi_v2_pb"
+)
+
+// RenameConfig maps deprecated metric subtree to its replacement. Instead of From and To, rule can rewrite names that
+// match regular expression Match to Replace ($1 refers to the first group and so on). Results of such rule are
+// renamed back with RestoreMatch and RestoreReplace, queries without globs are restored without them.
+type RenameConfig struct {
+	From string `mapstructure:"from"`
+	To   string `mapstructure:"to"`
+
+	Match          string `mapstructure:"match"`
+	Replace        string `mapstructure:"replace"`
+	RestoreMatch   string `mapstructure:"restoreMatch"`
+	RestoreReplace string `mapstructure:"restoreReplace"`
+
+	match   *regexp.Regexp
+	restore *regexp.Regexp
+}
+
+// renameRules rewrites requests for deprecated metrics, so they are served from the new subtree, and renames
+// results back, so clients still see the names they've asked for
+type renameRules []RenameConfig
+
+// compile compiles regular expressions of the rules, it must be called once config is parsed
+func (r renameRules) compile() error {
+	for i := range r {
+		rule := &r[i]
+		if rule.Match == "" {
+			if rule.RestoreMatch != "" {
+				return fmt.Errorf("rename %q: restoreMatch is set without match", rule.RestoreMatch)
+			}
+			continue
+		}
+		if rule.From != "" || rule.To != "" {
+			return fmt.Errorf("rename %q: from and to can't be used with match", rule.Match)
+		}
+		re, err := regexp.Compile(rule.Match)
+		if err != nil {
+			return fmt.Errorf("rename %q: %v", rule.Match, err)
+		}
+		rule.match = re
+		if rule.RestoreMatch != "" {
+			re, err := regexp.Compile(rule.RestoreMatch)
+			if err != nil {
+				return fmt.Errorf("rename %q: %v", rule.RestoreMatch, err)
+			}
+			rule.restore = re
+		}
+	}
+	return nil
+}
+
+// hasPathPrefix returns true if path is prefix itself or is one of its children
+func hasPathPrefix(path, prefix string) bool {
+	return strings.HasPrefix(path, prefix) && (len(path) == len(prefix) || path[len(prefix)] == '.')
+}
+
+func replacePathPrefix(path, old, new string) string {
+	if !hasPathPrefix(path, old) {
+		return path
+	}
+	return new + path[len(old):]
+}
+
+// apply returns query rewritten by the rule and true if rule matches it
+func (rule RenameConfig) apply(query string) (string, bool) {
+	if rule.match != nil {
+		if !rule.match.MatchString(query) {
+			return query, false
+		}
+		return rule.match.ReplaceAllString(query, rule.Replace), true
+	}
+	if !hasPathPrefix(query, rule.From) {
+		return query, false
+	}
+	return replacePathPrefix(query, rule.From, rule.To), true
+}
+
+// renamedQuery is a query rewritten by the rule
+type renamedQuery struct {
+	query string
+	rule  RenameConfig
+}
+
+// renames are rules applied to the request by renameRules.rewrite, keyed by the rewritten query. Results are
+// restored by the rule of the query they match, results that match queries which weren't rewritten are left as is,
+// so clients that ask for the new names get them.
+type renames struct {
+	byName  map[string]renamedQuery
+	renamed []string
+	asIs    []string
+}
+
+// rewrite replaces deprecated prefix of every query. It returns rewritten queries and rules that were applied, or nil
+// if nothing was rewritten.
+func (r renameRules) rewrite(queries []string) ([]string, *renames) {
+	var applied *renames
+	var res []string
+	var asIs []string
+	for i, q := range queries {
+		rewritten := false
+		for _, rule := range r {
+			name, ok := rule.apply(q)
+			if !ok {
+				continue
+			}
+			if res == nil {
+				res = make([]string, len(queries))
+				copy(res, queries)
+				applied = &renames{byName: make(map[string]renamedQuery)}
+			}
+			res[i] = name
+			if _, ok := applied.byName[name]; !ok {
+				applied.byName[name] = renamedQuery{query: q, rule: rule}
+				applied.renamed = append(applied.renamed, name)
+			}
+			rewritten = true
+			break
+		}
+		if !rewritten {
+			asIs = append(asIs, q)
+		}
+	}
+	if res == nil {
+		return queries, nil
+	}
+	applied.asIs = asIs
+	return res, applied
+}
+
+// nodeMatch returns true if node of the metric matches node of the query. Besides path.Match patterns, query node
+// may have {a,b} lists.
+func nodeMatch(pattern, node string) bool {
+	if i := strings.IndexByte(pattern, '{'); i >= 0 {
+		if j := strings.IndexByte(pattern[i:], '}'); j >= 0 {
+			for _, alt := range strings.Split(pattern[i+1:i+j], ",") {
+				if nodeMatch(pattern[:i]+alt+pattern[i+j+1:], node) {
+					return true
+				}
+			}
+			return false
+		}
+	}
+	ok, err := path.Match(pattern, node)
+	return err == nil && ok
+}
+
+// queryMatch returns true if metric is one of the results of the query
+func queryMatch(query, metric string) bool {
+	queryNodes := strings.Split(query, ".")
+	metricNodes := strings.Split(metric, ".")
+	if len(queryNodes) != len(metricNodes) {
+		return false
+	}
+	for i := range queryNodes {
+		if !nodeMatch(queryNodes[i], metricNodes[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// restoreQuery renames path that is a result of the rewritten query back to the deprecated name
+func (r *renames) restoreQuery(query, path string) string {
+	q, ok := r.byName[query]
+	if !ok {
+		return path
+	}
+	if path == query {
+		return q.query
+	}
+	rule := q.rule
+	if rule.restore != nil {
+		if rule.restore.MatchString(path) {
+			return rule.restore.ReplaceAllString(path, rule.RestoreReplace)
+		}
+		return path
+	}
+	if rule.match != nil {
+		return replacePathPrefix(path, query, q.query)
+	}
+	return replacePathPrefix(path, rule.To, rule.From)
+}
+
+// restore renames path back to the deprecated name
+func (r *renames) restore(path string) string {
+	if r == nil {
+		return path
+	}
+	for _, q := range r.asIs {
+		if queryMatch(q, path) {
+			return path
+		}
+	}
+	if _, ok := r.byName[path]; ok {
+		return r.restoreQuery(path, path)
+	}
+	for _, q := range r.renamed {
+		if queryMatch(q, path) {
+			return r.restoreQuery(q, path)
+		}
+	}
+	return path
+}
+
+// restoreFetched renames series fetched for pathExpression back to the deprecated names
+func (r *renames) restoreFetched(pathExpression, name string) (string, string) {
+	if r == nil {
+		return pathExpression, name
+	}
+	for _, q := range r.asIs {
+		if q == pathExpression {
+			return pathExpression, name
+		}
+	}
+	return r.restoreQuery(pathExpression, pathExpression), r.restoreQuery(pathExpression, name)
+}
+
+func (r *renames) restoreSeries(series []protov2.FetchResponse) {
+	for i := range series {
+		series[i].Name = r.restore(series[i].Name)
+	}
+}
+
+func (r *renames) restoreMatches(matches []protov2.GlobMatch) {
+	for i := range matches {
+		matches[i].Path = r.restore(matches[i].Path)
+	}
+}
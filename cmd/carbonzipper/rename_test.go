@@ -0,0 +1,103 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	protov2 "github.com/go-graphite/protocol/carbonapi_v2_pb"
+)
+
+func TestRenameRules(t *testing.T) {
+	r := renameRules{
+		{From: "servers.old", To: "hosts.new"},
+		{From: "legacy", To: "modern.legacy"},
+	}
+
+	queries := []string{"servers.old.*.cpu", "servers.older.cpu", "legacy", "other.metric"}
+	res, applied := r.rewrite(queries)
+	if !reflect.DeepEqual(res, []string{"hosts.new.*.cpu", "servers.older.cpu", "modern.legacy", "other.metric"}) {
+		t.Fatalf("unexpected rewritten queries: %v", res)
+	}
+	if !reflect.DeepEqual(applied.renamed, []string{"hosts.new.*.cpu", "modern.legacy"}) || !reflect.DeepEqual(applied.asIs, []string{"servers.older.cpu", "other.metric"}) {
+		t.Fatalf("unexpected applied rules: %+v", applied)
+	}
+	if queries[0] != "servers.old.*.cpu" {
+		t.Fatal("original queries were modified")
+	}
+
+	series := []protov2.FetchResponse{{Name: "hosts.new.web01.cpu"}, {Name: "hosts.newer.cpu"}, {Name: "modern.legacy"}}
+	applied.restoreSeries(series)
+	if series[0].Name != "servers.old.web01.cpu" || series[1].Name != "hosts.newer.cpu" || series[2].Name != "legacy" {
+		t.Fatalf("unexpected restored series: %+v", series)
+	}
+
+	if res, applied := r.rewrite([]string{"a.b"}); res[0] != "a.b" || applied != nil {
+		t.Fatalf("nothing should be rewritten, got %v %v", res, applied)
+	}
+}
+
+func TestRenameRulesRegexp(t *testing.T) {
+	r := renameRules{
+		{Match: `^legacy\.([^.]+)\.cpu$`, Replace: "hosts.$1.cpu.total", RestoreMatch: `^hosts\.([^.]+)\.cpu\.total$`, RestoreReplace: "legacy.$1.cpu"},
+		{Match: `^old_(\w+)\.`, Replace: "new.$1."},
+		{From: "servers.old", To: "hosts.new"},
+	}
+	if err := r.compile(); err != nil {
+		t.Fatalf("failed to compile rules: %v", err)
+	}
+
+	queries := []string{"legacy.*.cpu", "old_dc1.web01.load", "servers.old.web01", "other.metric"}
+	res, applied := r.rewrite(queries)
+	if !reflect.DeepEqual(res, []string{"hosts.*.cpu.total", "new.dc1.web01.load", "hosts.new.web01", "other.metric"}) {
+		t.Fatalf("unexpected rewritten queries: %v", res)
+	}
+
+	series := []protov2.FetchResponse{{Name: "hosts.web01.cpu.total"}, {Name: "new.dc1.web01.load"}, {Name: "hosts.new.web01"}, {Name: "hosts.*.cpu.total"}}
+	applied.restoreSeries(series)
+	expected := []string{"legacy.web01.cpu", "old_dc1.web01.load", "servers.old.web01", "legacy.*.cpu"}
+	for i := range series {
+		if series[i].Name != expected[i] {
+			t.Errorf("expected %v to be restored to %v, got %v", i, expected[i], series[i].Name)
+		}
+	}
+
+	if _, name := applied.restoreFetched("hosts.*.cpu.total", "hosts.web02.cpu.total"); name != "legacy.web02.cpu" {
+		t.Errorf("unexpected restored fetched series %v", name)
+	}
+	if expr, name := applied.restoreFetched("other.metric", "other.metric"); expr != "other.metric" || name != "other.metric" {
+		t.Errorf("series of queries that weren't rewritten shouldn't be restored, got %v %v", expr, name)
+	}
+
+	for _, invalid := range []renameRules{
+		{{Match: "("}},
+		{{Match: "a", RestoreMatch: "("}},
+		{{Match: "a", From: "b"}},
+		{{RestoreMatch: "a"}},
+	} {
+		if err := invalid.compile(); err == nil {
+			t.Errorf("expected error for %+v", invalid)
+		}
+	}
+}
+
+func TestRenameRulesRequestedNewNames(t *testing.T) {
+	r := renameRules{{From: "servers.old", To: "hosts.new"}}
+
+	queries := []string{"servers.old.web01.cpu", "hosts.new.web02.cpu", "servers.old.web01.cpu", "hosts.new.{web03,web04}.load"}
+	res, applied := r.rewrite(queries)
+	if !reflect.DeepEqual(res, []string{"hosts.new.web01.cpu", "hosts.new.web02.cpu", "hosts.new.web01.cpu", "hosts.new.{web03,web04}.load"}) {
+		t.Fatalf("unexpected rewritten queries: %v", res)
+	}
+	if len(applied.byName) != 1 || !reflect.DeepEqual(applied.renamed, []string{"hosts.new.web01.cpu"}) {
+		t.Fatalf("repeated queries should be applied once, got %+v", applied)
+	}
+
+	series := []protov2.FetchResponse{{Name: "hosts.new.web01.cpu"}, {Name: "hosts.new.web02.cpu"}, {Name: "hosts.new.web04.load"}}
+	applied.restoreSeries(series)
+	expected := []string{"servers.old.web01.cpu", "hosts.new.web02.cpu", "hosts.new.web04.load"}
+	for i := range series {
+		if series[i].Name != expected[i] {
+			t.Errorf("expected %v to be restored to %v, got %v", i, expected[i], series[i].Name)
+		}
+	}
+}
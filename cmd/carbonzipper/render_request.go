@@ -0,0 +1,266 @@
+package main
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-graphite/carbonapi/date"
+	"github.com/go-graphite/carbonapi/pkg/parser"
+	util "github.com/go-graphite/carbonapi/util/ctx"
+	"github.com/go-graphite/carbonapi/zipper/types"
+	protov2 "github.com/go-graphite/protocol/carbonapi_v2_pb"
+	protov3 "github.com/go-graphite/protocol/carbonapi_v3_pb"
+	"go.uber.org/zap"
+)
+
+// renderRequest contains parameters of the render request. It's parsed once by parseRenderRequest and then passed
+// to everything that serves the request instead of looking at the URL again.
+type renderRequest struct {
+	targets     []string
+	format      string
+	from, until int32
+
+	// merge options, empty means that zipper's defaults are used
+	consolidateBy   string
+	xFilesFactor    float32
+	hasXFilesFactor bool
+
+	// limits and policies, config values lowered or overridden by the client
+	maxBackends int
+	strict      bool
+
+	// showBackends adds list of backends that returned points to every series, see backendAttribution
+	showBackends bool
+
+	// post processing
+	alignTo      int32
+	alignToFrom  bool
+	removeEmpty  bool
+	fillValue    float64
+	hasFillValue bool
+
+	// watermark is the last timestamp client already has, only newer points are returned
+	watermark    int32
+	hasWatermark bool
+
+	// multiFetch is set for carbonapi_v3_pb requests, it has all the metrics with their time ranges, including
+	// targets from URL
+	multiFetch *protov3.MultiFetchRequest
+}
+
+// renderRequestError describes parameter that can't be parsed. message is sent to the client, reason and fields
+// are logged.
+type renderRequestError struct {
+	message string
+	reason  string
+	fields  []zap.Field
+}
+
+func (e *renderRequestError) Error() string {
+	return e.message
+}
+
+func badRenderParam(message, reason string, fields ...zap.Field) *renderRequestError {
+	return &renderRequestError{message: message, reason: reason, fields: fields}
+}
+
+// parseRenderRequest parses and validates parameters of the render request, config provides defaults
+func parseRenderRequest(req *http.Request, c *carbonzipperConfig, now time.Time) (*renderRequest, *renderRequestError) {
+	if err := req.ParseForm(); err != nil {
+		return nil, badRenderParam("failed to parse arguments", "failed to parse arguments")
+	}
+
+	r := &renderRequest{
+		targets:     req.Form["target"],
+		format:      req.FormValue("format"),
+		maxBackends: c.MaxBackends,
+		strict:      c.Strict,
+		removeEmpty: c.RemoveEmptySeries,
+	}
+
+	tz := req.FormValue("tz")
+	from, err := date.ParseDateParam(req.FormValue("from"), tz, now.Add(-24*time.Hour).Unix(), time.Local)
+	if err != nil {
+		return nil, badRenderParam("from: "+err.Error(), "invalid from", zap.String("from", req.FormValue("from")))
+	}
+	until, err := date.ParseDateParam(req.FormValue("until"), tz, now.Unix(), time.Local)
+	if err != nil {
+		return nil, badRenderParam("until: "+err.Error(), "invalid until", zap.String("until", req.FormValue("until")))
+	}
+	// time ranges are sent to backends as int32
+	if from < math.MinInt32 || from > math.MaxInt32 {
+		return nil, badRenderParam("from is out of range", "from is out of range", zap.Int64("from", from))
+	}
+	if until < math.MinInt32 || until > math.MaxInt32 {
+		return nil, badRenderParam("until is out of range", "until is out of range", zap.Int64("until", until))
+	}
+	if from > until {
+		return nil, badRenderParam("from must not be after until", "from is after until",
+			zap.Int64("from", from),
+			zap.Int64("until", until),
+		)
+	}
+	r.from, r.until = int32(from), int32(until)
+
+	if r.format == formatTypeV3 || r.format == formatTypeCarbonAPIV3PB {
+		r.multiFetch, err = parseMultiFetchRequest(req)
+		if err != nil {
+			return nil, badRenderParam("failed to parse carbonapi_v3_pb request: "+err.Error(), "invalid carbonapi_v3_pb request")
+		}
+		for _, target := range r.targets {
+			r.multiFetch.Metrics = append(r.multiFetch.Metrics, protov3.FetchRequest{
+				Name:           target,
+				PathExpression: target,
+				StartTime:      from,
+				StopTime:       until,
+			})
+		}
+		r.targets = make([]string, 0, len(r.multiFetch.Metrics))
+		for _, m := range r.multiFetch.Metrics {
+			r.targets = append(r.targets, m.Name)
+		}
+	}
+
+	if len(r.targets) == 0 {
+		return nil, badRenderParam("empty target", "empty target")
+	}
+
+	r.consolidateBy = req.FormValue("consolidateBy")
+	if r.consolidateBy != "" && !types.IsValidConsolidation(r.consolidateBy) {
+		return nil, badRenderParam("consolidateBy must be one of avg, sum, min, max or last", "invalid consolidateBy")
+	}
+
+	if v := req.FormValue("xFilesFactor"); v != "" {
+		xFilesFactor, err := strconv.ParseFloat(v, 32)
+		if err != nil || !types.IsValidXFilesFactor(float32(xFilesFactor)) {
+			return nil, badRenderParam("xFilesFactor must be a number between 0 and 1", "invalid xFilesFactor")
+		}
+		r.xFilesFactor = float32(xFilesFactor)
+		r.hasXFilesFactor = true
+	}
+
+	// clients can only lower the limit that is set in config
+	if v := req.FormValue("maxBackends"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return nil, badRenderParam("maxBackends must be a positive integer", "invalid maxBackends")
+		}
+		if r.maxBackends == 0 || n < r.maxBackends {
+			r.maxBackends = n
+		}
+	}
+
+	if v := req.FormValue("strict"); v != "" {
+		r.strict = parser.TruthyBool(v)
+	}
+
+	r.showBackends = c.BackendAttribution && parser.TruthyBool(req.FormValue("showBackends"))
+
+	if v := req.FormValue("alignTo"); v != "" {
+		r.alignTo, err = parser.IntervalString(v, 1)
+		if err != nil || r.alignTo <= 0 {
+			return nil, badRenderParam("alignTo must be a positive interval", "invalid alignTo")
+		}
+	}
+	r.alignToFrom = parser.TruthyBool(req.FormValue("alignToFrom"))
+
+	if v := req.FormValue("removeEmptySeries"); v != "" {
+		r.removeEmpty = parser.TruthyBool(v)
+	}
+
+	if v := req.FormValue("fillValue"); v != "" {
+		r.fillValue, err = strconv.ParseFloat(v, 64)
+		if err != nil {
+			return nil, badRenderParam("fillValue is not a number", "fillValue is not a number")
+		}
+		r.hasFillValue = true
+	}
+
+	if v := req.FormValue("watermark"); v != "" {
+		watermark, err := strconv.ParseInt(v, 10, 32)
+		if err != nil || watermark <= 0 {
+			return nil, badRenderParam("watermark must be a unix timestamp", "invalid watermark")
+		}
+		r.watermark = int32(watermark)
+		r.hasWatermark = true
+	}
+
+	// series of carbonapi_v3_pb requests are sent as backends returned them, only removeEmptySeries and post
+	// processing rules of the config are applied to them
+	if r.multiFetch != nil {
+		if r.showBackends || r.alignToFrom || r.alignTo > 0 || r.hasFillValue || r.hasWatermark {
+			return nil, badRenderParam("showBackends, alignTo, alignToFrom, fillValue and watermark are not supported with carbonapi_v3_pb format", "unsupported carbonapi_v3_pb parameters")
+		}
+		for _, target := range r.targets {
+			if !parseRenderTarget(target).isRaw() {
+				return nil, badRenderParam("functions are not supported with carbonapi_v3_pb format", "functions in carbonapi_v3_pb request", zap.String("target", target))
+			}
+		}
+	}
+
+	return r, nil
+}
+
+// context returns ctx with options that are used by zipper while routing and merging the responses
+func (r *renderRequest) context(ctx context.Context) context.Context {
+	if r.consolidateBy != "" {
+		ctx = util.SetConsolidateBy(ctx, r.consolidateBy)
+	}
+	if r.hasXFilesFactor {
+		ctx = util.SetXFilesFactor(ctx, r.xFilesFactor)
+	}
+	if r.maxBackends > 0 {
+		ctx = util.SetMaxBackends(ctx, r.maxBackends)
+	}
+	if r.showBackends {
+		ctx = util.SetTrackSources(ctx, true)
+	}
+	return util.SetStrict(ctx, r.strict)
+}
+
+// canUsePrecomputed returns true if precomputed results are valid for the request: they are only computed with
+// default merge options, might be partial and don't know their sources
+func (r *renderRequest) canUsePrecomputed() bool {
+	return r.multiFetch == nil && r.consolidateBy == "" && !r.hasXFilesFactor && !r.strict && !r.showBackends
+}
+
+// canPassthrough returns true if backend's response can be sent to the client as is: it's requested in protobuf,
+// contains only metrics and globs and nothing has to be done with fetched series
+func (r *renderRequest) canPassthrough() bool {
+	if r.format != formatTypeProtobuf && r.format != formatTypeProtobuf3 {
+		return false
+	}
+	if r.showBackends || r.alignToFrom || r.alignTo > 0 || r.removeEmpty || r.hasFillValue || r.hasWatermark {
+		return false
+	}
+	for _, target := range r.targets {
+		if !parseRenderTarget(target).isRaw() {
+			return false
+		}
+	}
+	return true
+}
+
+// postProcess aligns, filters, fills and trims fetched series as requested
+func (r *renderRequest) postProcess(metrics *protov2.MultiFetchResponse) *protov2.MultiFetchResponse {
+	if r.alignToFrom || r.alignTo > 0 {
+		var base int32
+		if r.alignToFrom {
+			base = r.from
+		}
+		metrics = &protov2.MultiFetchResponse{Metrics: alignSeries(metrics.Metrics, base, r.alignTo)}
+	}
+	if r.removeEmpty {
+		metrics = &protov2.MultiFetchResponse{Metrics: removeEmptySeries(metrics.Metrics)}
+	}
+	if r.hasFillValue {
+		metrics = &protov2.MultiFetchResponse{Metrics: fillAbsent(metrics.Metrics, r.fillValue)}
+	}
+	if r.hasWatermark {
+		metrics = &protov2.MultiFetchResponse{Metrics: trimToWatermark(metrics.Metrics, r.watermark)}
+	}
+	return metrics
+}
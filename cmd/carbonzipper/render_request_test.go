@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	util "github.com/go-graphite/carbonapi/util/ctx"
+	protov3 "github.com/go-graphite/protocol/carbonapi_v3_pb"
+)
+
+func TestParseRenderRequest(t *testing.T) {
+	c := &carbonzipperConfig{MaxBackends: 10, RemoveEmptySeries: true}
+	now := time.Unix(1500000000, 0)
+
+	tests := []struct {
+		query  string
+		reason string
+		check  func(r *renderRequest) bool
+	}{
+		{"target=foo&from=1499996400", "", func(r *renderRequest) bool {
+			return r.from == 1500000000-3600 && r.until == 1500000000 && r.maxBackends == 10 && r.removeEmpty && r.canUsePrecomputed()
+		}},
+		{"target=foo&target=bar&format=json&maxBackends=3&removeEmptySeries=false", "", func(r *renderRequest) bool {
+			return len(r.targets) == 2 && r.format == "json" && r.maxBackends == 3 && !r.removeEmpty
+		}},
+		{"target=foo&maxBackends=20", "", func(r *renderRequest) bool { return r.maxBackends == 10 }},
+		{"target=foo&xFilesFactor=0.5&fillValue=0&alignTo=1m", "", func(r *renderRequest) bool {
+			return r.hasXFilesFactor && r.xFilesFactor == 0.5 && r.hasFillValue && r.alignTo == 60 && !r.canUsePrecomputed()
+		}},
+		{"target=foo&format=protobuf&watermark=1499999940", "", func(r *renderRequest) bool {
+			return r.hasWatermark && r.watermark == 1499999940 && !r.canPassthrough() && r.canUsePrecomputed()
+		}},
+		{"from=1499996400", "empty target", nil},
+		{"target=foo&from=1499996400&until=1499990000", "from is after until", nil},
+		{"target=foo&from=4294967296&until=4294967300", "from is out of range", nil},
+		{"target=foo&from=1499996400&until=4294967296", "until is out of range", nil},
+		{"target=foo&consolidateBy=median", "invalid consolidateBy", nil},
+		{"target=foo&xFilesFactor=2", "invalid xFilesFactor", nil},
+		{"target=foo&maxBackends=0", "invalid maxBackends", nil},
+		{"target=foo&alignTo=-1m", "invalid alignTo", nil},
+		{"target=foo&fillValue=x", "fillValue is not a number", nil},
+		{"target=foo&watermark=-1", "invalid watermark", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			r, err := parseRenderRequest(httptest.NewRequest("GET", "/render/?"+tt.query, nil), c, now)
+			if tt.reason != "" {
+				if err == nil || err.reason != tt.reason {
+					t.Fatalf("unexpected error %v, expected %v", err, tt.reason)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			if !tt.check(r) {
+				t.Fatalf("unexpected request %+v", r)
+			}
+		})
+	}
+}
+
+func TestRenderRequestContext(t *testing.T) {
+	r := &renderRequest{consolidateBy: "max", maxBackends: 2, strict: true}
+	ctx := r.context(context.Background())
+	if util.GetConsolidateBy(ctx) != "max" || util.GetMaxBackends(ctx) != 2 || !util.GetStrict(ctx) {
+		t.Fatal("request options are not set in context")
+	}
+	if _, ok := util.GetXFilesFactor(ctx); ok {
+		t.Fatal("xFilesFactor must not be set")
+	}
+}
+
+func TestParseMultiFetchRenderRequest(t *testing.T) {
+	c := &carbonzipperConfig{}
+	now := time.Unix(1500000000, 0)
+
+	request := protov3.MultiFetchRequest{Metrics: []protov3.FetchRequest{
+		{Name: "foo.*", PathExpression: "foo.*", StartTime: 1400000000, StopTime: 1400003600},
+		{Name: "bar", PathExpression: "bar", StartTime: 1499990000, StopTime: 1500000000},
+	}}
+	b, err := request.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, perr := parseRenderRequest(httptest.NewRequest("GET", "/render/?format=carbonapi_v3_pb&target=baz&from=1499996400", bytes.NewReader(b)), c, now)
+	if perr != nil {
+		t.Fatalf("unexpected error %v", perr)
+	}
+	expected := []string{"foo.*", "bar", "baz"}
+	if !reflect.DeepEqual(r.targets, expected) {
+		t.Fatalf("unexpected targets %v, expected %v", r.targets, expected)
+	}
+	if m := r.multiFetch.Metrics[2]; m.StartTime != 1499996400 || m.StopTime != 1500000000 {
+		t.Fatalf("URL target has unexpected time range %+v", m)
+	}
+	if m := r.multiFetch.Metrics[0]; m.StartTime != 1400000000 || m.StopTime != 1400003600 {
+		t.Fatalf("time range of request metric is changed %+v", m)
+	}
+	if r.canUsePrecomputed() || r.canPassthrough() {
+		t.Fatal("carbonapi_v3_pb request can't be served from precomputed or passthrough responses")
+	}
+
+	_, perr = parseRenderRequest(httptest.NewRequest("GET", "/render/?format=v3", bytes.NewReader([]byte{0xff})), c, now)
+	if perr == nil || perr.reason != "invalid carbonapi_v3_pb request" {
+		t.Fatalf("unexpected error %v for broken body", perr)
+	}
+	_, perr = parseRenderRequest(httptest.NewRequest("GET", "/render/?format=v3", nil), c, now)
+	if perr == nil || perr.reason != "empty target" {
+		t.Fatalf("unexpected error %v for empty request", perr)
+	}
+
+	for _, query := range []string{"fillValue=0", "watermark=10", "alignTo=1min", "alignToFrom=true"} {
+		_, perr = parseRenderRequest(httptest.NewRequest("GET", "/render/?format=v3&"+query, bytes.NewReader(b)), c, now)
+		if perr == nil || perr.reason != "unsupported carbonapi_v3_pb parameters" {
+			t.Errorf("unexpected error %v for %v", perr, query)
+		}
+	}
+	_, perr = parseRenderRequest(httptest.NewRequest("GET", "/render/?format=v3&target=sumSeries(foo.*)", bytes.NewReader(b)), c, now)
+	if perr == nil || perr.reason != "functions in carbonapi_v3_pb request" {
+		t.Errorf("unexpected error %v for function target", perr)
+	}
+}
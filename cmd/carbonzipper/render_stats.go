@@ -0,0 +1,224 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	protov2 "github.com/go-graphite/protocol/carbonapi_v2_pb"
+	protov3 "github.com/go-graphite/protocol/carbonapi_v3_pb"
+)
+
+const (
+	// renderStatsResolution is the size of a bucket of rolling statistics, window is rounded up to it
+	renderStatsResolution = time.Minute
+	// renderStatsOther collects namespaces that don't fit into MaxNamespaces
+	renderStatsOther = "_other"
+)
+
+// RenderStatsConfig controls rolling per-namespace statistics of render requests that are served as JSON by
+// /debug/render_stats. Statistics are disabled if Window is zero.
+type RenderStatsConfig struct {
+	Window        time.Duration `mapstructure:"window"`
+	MaxNamespaces int           `mapstructure:"maxNamespaces"`
+}
+
+// renderStatsEntry is what render requests cost for a namespace. Request that touches several namespaces is
+// counted for every one of them, its backend time is split evenly between them.
+type renderStatsEntry struct {
+	Queries     int64   `json:"queries"`
+	Datapoints  int64   `json:"datapoints"`
+	BackendTime float64 `json:"backend_seconds"`
+}
+
+// renderStatsNamespace is renderStatsEntry with averages per query
+type renderStatsNamespace struct {
+	renderStatsEntry
+	AvgDatapoints  float64 `json:"avg_datapoints"`
+	AvgBackendTime float64 `json:"avg_backend_seconds"`
+}
+
+type renderStatsReport struct {
+	Window     float64                         `json:"window_seconds"`
+	Namespaces map[string]renderStatsNamespace `json:"namespaces"`
+}
+
+type renderStatsBucket struct {
+	start      time.Time
+	namespaces map[string]*renderStatsEntry
+}
+
+// renderStats keeps statistics of render requests for the last window in per-minute buckets
+type renderStats struct {
+	sync.Mutex
+	window        time.Duration
+	maxNamespaces int
+	buckets       []renderStatsBucket
+	// known namespaces and when they were seen last, once MaxNamespaces is reached, namespaces that weren't seen
+	// for the window are forgotten, if there are none new ones go to renderStatsOther
+	namespaces map[string]time.Time
+	// evicted is when idle namespaces were looked for last time
+	evicted time.Time
+
+	now func() time.Time
+}
+
+func newRenderStats(c RenderStatsConfig) *renderStats {
+	if c.Window <= 0 {
+		return nil
+	}
+	n := int((c.Window + renderStatsResolution - 1) / renderStatsResolution)
+	return &renderStats{
+		window:        time.Duration(n) * renderStatsResolution,
+		maxNamespaces: c.MaxNamespaces,
+		buckets:       make([]renderStatsBucket, n),
+		namespaces:    make(map[string]time.Time),
+		now:           time.Now,
+	}
+}
+
+// namespaceOf returns top-level node of the target, for expressions it's the one of the first metric
+func namespaceOf(target string) string {
+	if t := parseRenderTarget(target); len(t.metrics) > 0 {
+		target = t.metrics[0]
+	}
+	if i := strings.IndexByte(target, '.'); i != -1 {
+		return target[:i]
+	}
+	return target
+}
+
+// renderNamespaces returns datapoints per namespace of the request, every namespace of the targets is present
+// even if nothing was fetched for it
+func renderNamespaces(r *renderRequest, metrics *protov2.MultiFetchResponse, multiFetchMetrics *protov3.MultiFetchResponse) map[string]int64 {
+	res := make(map[string]int64)
+	if r.multiFetch != nil {
+		for _, m := range r.multiFetch.Metrics {
+			res[namespaceOf(m.Name)] += 0
+		}
+	}
+	for _, target := range r.targets {
+		res[namespaceOf(target)] += 0
+	}
+	if metrics != nil {
+		for _, m := range metrics.Metrics {
+			res[namespaceOf(m.Name)] += int64(len(m.Values))
+		}
+	}
+	if multiFetchMetrics != nil {
+		for _, m := range multiFetchMetrics.Metrics {
+			res[namespaceOf(m.Name)] += int64(len(m.Values))
+		}
+	}
+	return res
+}
+
+// bucket returns the bucket of t, resetting it if it's left from the previous window. Must be called with lock held.
+func (s *renderStats) bucket(t time.Time) *renderStatsBucket {
+	start := t.Truncate(renderStatsResolution)
+	b := &s.buckets[int(start.Unix()/int64(renderStatsResolution/time.Second))%len(s.buckets)]
+	if !b.start.Equal(start) {
+		b.start = start
+		b.namespaces = make(map[string]*renderStatsEntry)
+	}
+	return b
+}
+
+// record accounts render request that fetched datapoints per namespace and spent backendTime waiting for backends
+func (s *renderStats) record(datapoints map[string]int64, backendTime time.Duration) {
+	if s == nil || len(datapoints) == 0 {
+		return
+	}
+	perNamespace := backendTime.Seconds() / float64(len(datapoints))
+
+	s.Lock()
+	defer s.Unlock()
+	now := s.now()
+	// namespaces that don't fit are merged, so request is counted once for renderStatsOther
+	merged := make(map[string]*renderStatsEntry, len(datapoints))
+	for ns, points := range datapoints {
+		if _, ok := s.namespaces[ns]; !ok && s.maxNamespaces > 0 && len(s.namespaces) >= s.maxNamespaces {
+			s.evictIdle(now)
+		}
+		if _, ok := s.namespaces[ns]; ok || s.maxNamespaces <= 0 || len(s.namespaces) < s.maxNamespaces {
+			s.namespaces[ns] = now
+		} else {
+			ns = renderStatsOther
+		}
+		e, ok := merged[ns]
+		if !ok {
+			e = &renderStatsEntry{Queries: 1}
+			merged[ns] = e
+		}
+		e.Datapoints += points
+		e.BackendTime += perNamespace
+	}
+
+	b := s.bucket(now)
+	for ns, m := range merged {
+		e, ok := b.namespaces[ns]
+		if !ok {
+			e = &renderStatsEntry{}
+			b.namespaces[ns] = e
+		}
+		e.Queries += m.Queries
+		e.Datapoints += m.Datapoints
+		e.BackendTime += m.BackendTime
+	}
+}
+
+// evictIdle forgets namespaces that have no statistics in the current window. It's done at most once per bucket, as
+// namespaces can't become idle more often. Must be called with lock held.
+func (s *renderStats) evictIdle(now time.Time) {
+	if now.Sub(s.evicted) < renderStatsResolution {
+		return
+	}
+	s.evicted = now
+	oldest := now.Add(-s.window)
+	for ns, seen := range s.namespaces {
+		if !seen.After(oldest) {
+			delete(s.namespaces, ns)
+		}
+	}
+}
+
+// Report aggregates buckets of the current window
+func (s *renderStats) Report() renderStatsReport {
+	res := renderStatsReport{Namespaces: make(map[string]renderStatsNamespace)}
+	if s == nil {
+		return res
+	}
+	res.Window = s.window.Seconds()
+
+	s.Lock()
+	defer s.Unlock()
+	oldest := s.now().Add(-s.window)
+	for _, b := range s.buckets {
+		if !b.start.After(oldest) {
+			continue
+		}
+		for ns, e := range b.namespaces {
+			n := res.Namespaces[ns]
+			n.Queries += e.Queries
+			n.Datapoints += e.Datapoints
+			n.BackendTime += e.BackendTime
+			res.Namespaces[ns] = n
+		}
+	}
+	for ns, n := range res.Namespaces {
+		if n.Queries > 0 {
+			n.AvgDatapoints = float64(n.Datapoints) / float64(n.Queries)
+			n.AvgBackendTime = n.BackendTime / float64(n.Queries)
+		}
+		res.Namespaces[ns] = n
+	}
+	return res
+}
+
+func (s *renderStats) handler(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", contentTypeJSON)
+	/* #nosec */
+	_ = json.NewEncoder(w).Encode(s.Report())
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	protov2 "github.com/go-graphite/protocol/carbonapi_v2_pb"
+)
+
+func TestRenderNamespaces(t *testing.T) {
+	r := &renderRequest{targets: []string{"sumSeries(foo.*)", "bar.baz", "empty.metric"}}
+	metrics := &protov2.MultiFetchResponse{Metrics: []protov2.FetchResponse{
+		{Name: "sumSeries(foo.*)", Values: make([]float64, 10)},
+		{Name: "bar.baz", Values: make([]float64, 5)},
+	}}
+
+	res := renderNamespaces(r, metrics, nil)
+	if len(res) != 3 || res["foo"] != 10 || res["bar"] != 5 || res["empty"] != 0 {
+		t.Fatalf("unexpected datapoints per namespace %v", res)
+	}
+}
+
+func TestRenderStats(t *testing.T) {
+	s := newRenderStats(RenderStatsConfig{Window: 90 * time.Second, MaxNamespaces: 2})
+	now := time.Unix(6000, 0)
+	s.now = func() time.Time { return now }
+
+	s.record(map[string]int64{"foo": 100, "bar": 10}, 2*time.Second)
+	now = now.Add(time.Minute)
+	s.record(map[string]int64{"foo": 300}, time.Second)
+	s.record(map[string]int64{"new1": 1, "new2": 2}, time.Second)
+
+	report := s.Report()
+	if report.Window != 120 {
+		t.Fatalf("window should be rounded up to minutes, got %v", report.Window)
+	}
+	foo := report.Namespaces["foo"]
+	if foo.Queries != 2 || foo.Datapoints != 400 || foo.AvgDatapoints != 200 || foo.BackendTime != 2 || foo.AvgBackendTime != 1 {
+		t.Fatalf("unexpected foo stats %+v", foo)
+	}
+	if other := report.Namespaces[renderStatsOther]; other.Queries != 1 || other.Datapoints != 3 || other.BackendTime != 1 {
+		t.Fatalf("namespaces over the limit should be merged, got %+v", report.Namespaces)
+	}
+
+	// the first minute leaves the window
+	now = now.Add(time.Minute)
+	if foo := s.Report().Namespaces["foo"]; foo.Queries != 1 || foo.Datapoints != 300 {
+		t.Fatalf("old bucket should expire, got %+v", foo)
+	}
+	now = now.Add(10 * time.Minute)
+	if n := s.Report().Namespaces; len(n) != 0 {
+		t.Fatalf("all buckets should expire, got %+v", n)
+	}
+
+	// idle namespaces are forgotten, so new ones get their place
+	s.record(map[string]int64{"new1": 1}, time.Second)
+	if n := s.Report().Namespaces; n["new1"].Queries != 1 || n[renderStatsOther].Queries != 0 {
+		t.Fatalf("new namespace should replace idle ones, got %+v", n)
+	}
+}
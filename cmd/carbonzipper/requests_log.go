@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-graphite/carbonapi/pkg/parser"
+	"github.com/go-graphite/carbonapi/zipper/types"
+)
+
+type requestLogKey int
+
+const entryKey requestLogKey = 0
+
+// requestLogEntry describes single completed request
+type requestLogEntry struct {
+	Time          time.Time `json:"time"`
+	Handler       string    `json:"handler"`
+	Targets       []string  `json:"targets"`
+	Runtime       float64   `json:"runtime_seconds"`
+	Servers       []string  `json:"servers,omitempty"`
+	FailedServers []string  `json:"failed_servers,omitempty"`
+	ResponseSize  int       `json:"response_size_bytes"`
+	HTTPCode      int       `json:"http_code"`
+	// Sampled is the reason why request was recorded: "error", "forced" or "random"
+	Sampled string `json:"sampled"`
+}
+
+// RequestLogSampling controls which requests are recorded. Failed requests (5xx or requests where some of the
+// servers failed) are always recorded if Errors is set, requests with ForceHeader set to a truthy value are always
+// recorded, other requests are recorded with probability Rate.
+type RequestLogSampling struct {
+	Rate        float64 `mapstructure:"rate"`
+	Errors      bool    `mapstructure:"errors"`
+	ForceHeader string  `mapstructure:"forceHeader"`
+}
+
+// sample returns the reason to record the request or empty string if it should be skipped
+func (s RequestLogSampling) sample(req *http.Request, e *requestLogEntry, random func() float64) string {
+	if s.Errors && (e.HTTPCode >= 500 || len(e.FailedServers) > 0) {
+		return "error"
+	}
+	if s.ForceHeader != "" && parser.TruthyBool(req.Header.Get(s.ForceHeader)) {
+		return "forced"
+	}
+	if s.Rate >= 1 || (s.Rate > 0 && random() < s.Rate) {
+		return "random"
+	}
+	return ""
+}
+
+// requestLog keeps last N completed requests in a ring buffer
+type requestLog struct {
+	sync.Mutex
+	entries  []requestLogEntry
+	pos      int
+	full     bool
+	sampling RequestLogSampling
+
+	random func() float64
+}
+
+func newRequestLog(size int, sampling RequestLogSampling) *requestLog {
+	if size <= 0 {
+		return nil
+	}
+	return &requestLog{
+		entries:  make([]requestLogEntry, size),
+		sampling: sampling,
+		random:   rand.Float64,
+	}
+}
+
+func (l *requestLog) add(e requestLogEntry) {
+	l.Lock()
+	l.entries[l.pos] = e
+	l.pos++
+	if l.pos == len(l.entries) {
+		l.pos = 0
+		l.full = true
+	}
+	l.Unlock()
+}
+
+// Entries returns copy of the log, newest requests first
+func (l *requestLog) Entries() []requestLogEntry {
+	if l == nil {
+		return []requestLogEntry{}
+	}
+	l.Lock()
+	defer l.Unlock()
+
+	n := l.pos
+	if l.full {
+		n = len(l.entries)
+	}
+	res := make([]requestLogEntry, 0, n)
+	for i := 1; i <= n; i++ {
+		idx := (l.pos - i + len(l.entries)) % len(l.entries)
+		res = append(res, l.entries[idx])
+	}
+	return res
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	code int
+	size int
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	r.code = code
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	n, err := r.ResponseWriter.Write(b)
+	r.size += n
+	return n, err
+}
+
+func (r *statusRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// wrap records every request served by h. targetParam is the form field that contains request targets
+func (l *requestLog) wrap(handler, targetParam string, h http.HandlerFunc) http.HandlerFunc {
+	if l == nil {
+		return h
+	}
+	return func(w http.ResponseWriter, req *http.Request) {
+		t0 := time.Now()
+		e := &requestLogEntry{
+			Time:    t0,
+			Handler: handler,
+		}
+		rec := &statusRecorder{ResponseWriter: w, code: http.StatusOK}
+
+		req = req.WithContext(context.WithValue(req.Context(), entryKey, e))
+		h(rec, req)
+
+		e.Targets = req.Form[targetParam]
+		e.Runtime = time.Since(t0).Seconds()
+		e.ResponseSize = rec.size
+		e.HTTPCode = rec.code
+		e.Sampled = l.sampling.sample(req, e, l.random)
+		if e.Sampled != "" {
+			l.add(*e)
+		}
+	}
+}
+
+// recordStats saves list of servers that were involved in request
+func recordStats(ctx context.Context, stats *types.Stats) {
+	if stats == nil {
+		return
+	}
+	if e, ok := ctx.Value(entryKey).(*requestLogEntry); ok {
+		e.Servers = append(e.Servers, stats.Servers...)
+		e.FailedServers = append(e.FailedServers, stats.FailedServers...)
+	}
+}
+
+func (l *requestLog) handler(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", contentTypeJSON)
+	/* #nosec */
+	_ = json.NewEncoder(w).Encode(l.Entries())
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRequestLogSampling(t *testing.T) {
+	l := newRequestLog(10, RequestLogSampling{Rate: 0.5, Errors: true, ForceHeader: "X-Debug-Capture"})
+	random := 0.7
+	l.random = func() float64 { return random }
+
+	code := http.StatusOK
+	h := l.wrap("render", "target", func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(code)
+	})
+	serve := func(header string) {
+		req := httptest.NewRequest("GET", "/render/?target=foo", nil)
+		if header != "" {
+			req.Header.Set("X-Debug-Capture", header)
+		}
+		h(httptest.NewRecorder(), req)
+	}
+
+	serve("")
+	serve("0")
+	if n := len(l.Entries()); n != 0 {
+		t.Fatalf("requests above sampling rate shouldn't be recorded, got %v entries", n)
+	}
+
+	serve("1")
+	code = http.StatusInternalServerError
+	serve("")
+	code = http.StatusOK
+	random = 0.2
+	serve("")
+
+	entries := l.Entries()
+	expected := []string{"random", "error", "forced"}
+	if len(entries) != len(expected) {
+		t.Fatalf("unexpected amount of entries, got %v, expected %v", len(entries), len(expected))
+	}
+	for i, e := range entries {
+		if e.Sampled != expected[i] {
+			t.Errorf("entry %v: got %q, expected %q", i, e.Sampled, expected[i])
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// headerClampedFrom is set on render responses which time range was clamped to the retention of the metrics
+const headerClampedFrom = "X-CarbonZipper-Clamped-From"
+
+// RetentionConfig declares that metrics under Prefix are only kept for Retention
+type RetentionConfig struct {
+	Prefix    string        `mapstructure:"prefix"`
+	Retention time.Duration `mapstructure:"retention"`
+}
+
+// retentionRules clamp time range of render requests, so backends are not asked for archives that are known to be
+// empty. First matching rule wins.
+type retentionRules []RetentionConfig
+
+// retention returns retention of the metric or glob, zero if it's unknown
+func (r retentionRules) retention(metric string) time.Duration {
+	for _, rule := range r {
+		if hasPathPrefix(metric, rule.Prefix) {
+			return rule.Retention
+		}
+	}
+	return 0
+}
+
+// earliest returns the first timestamp that any of the targets can have points for, or zero if at least one of
+// them has unknown retention. Shifted targets look into the past, so they are never clamped.
+func (r retentionRules) earliest(targets []string, now time.Time) int32 {
+	var longest time.Duration
+	for _, target := range targets {
+		t := parseRenderTarget(target)
+		if t.shift != 0 {
+			return 0
+		}
+		for _, m := range t.metrics {
+			retention := r.retention(m)
+			if retention <= 0 {
+				return 0
+			}
+			if retention > longest {
+				longest = retention
+			}
+		}
+	}
+	if longest == 0 {
+		return 0
+	}
+	return int32(now.Add(-longest).Unix())
+}
+
+// clampRange moves from to the earliest timestamp the targets can have points for. It returns new from and true
+// if range was clamped. Range that is completely out of retention is clamped to until.
+func (r retentionRules) clampRange(targets []string, from, until int32, now time.Time) (int32, bool) {
+	earliest := r.earliest(targets, now)
+	if earliest <= from {
+		return from, false
+	}
+	if earliest > until {
+		earliest = until
+	}
+	return earliest, true
+}
+
+// clamp clamps range of the render request, every metric of carbonapi_v3_pb request is clamped separately.
+// It returns the earliest clamped from, or zero if nothing was clamped.
+func (r retentionRules) clamp(req *renderRequest, now time.Time) int32 {
+	if len(r) == 0 {
+		return 0
+	}
+	if req.multiFetch == nil {
+		from, ok := r.clampRange(req.targets, req.from, req.until, now)
+		if !ok {
+			return 0
+		}
+		req.from = from
+		return from
+	}
+
+	var clampedFrom int32
+	for i := range req.multiFetch.Metrics {
+		m := &req.multiFetch.Metrics[i]
+		from, ok := r.clampRange([]string{m.Name}, int32(m.StartTime), int32(m.StopTime), now)
+		if !ok {
+			continue
+		}
+		m.StartTime = int64(from)
+		if clampedFrom == 0 || from < clampedFrom {
+			clampedFrom = from
+		}
+	}
+	return clampedFrom
+}
+
+// setClampedHeader tells the client that time range of the request was clamped
+func setClampedHeader(w http.ResponseWriter, clampedFrom int32) {
+	if clampedFrom != 0 {
+		w.Header().Set(headerClampedFrom, strconv.Itoa(int(clampedFrom)))
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	protov3 "github.com/go-graphite/protocol/carbonapi_v3_pb"
+)
+
+func TestRetentionClamp(t *testing.T) {
+	r := retentionRules{
+		{Prefix: "short", Retention: 24 * time.Hour},
+		{Prefix: "long", Retention: 7 * 24 * time.Hour},
+	}
+	now := time.Unix(1000000, 0)
+	day := int32(24 * 3600)
+	until := int32(now.Unix())
+
+	tests := []struct {
+		targets []string
+		from    int32
+		clamped int32
+	}{
+		{[]string{"short.foo"}, until - 2*day, until - day},
+		{[]string{"sumSeries(short.*)"}, until - 2*day, until - day},
+		{[]string{"short.foo", "long.bar"}, until - 30*day, until - 7*day},
+		// inside retention
+		{[]string{"short.foo"}, until - day/2, 0},
+		// unknown retention
+		{[]string{"short.foo", "other.bar"}, until - 30*day, 0},
+		{[]string{"timeShift(short.foo, '1d')"}, until - 30*day, 0},
+	}
+
+	for _, tt := range tests {
+		req := &renderRequest{targets: tt.targets, from: tt.from, until: until}
+		clamped := r.clamp(req, now)
+		if clamped != tt.clamped {
+			t.Fatalf("%v: got clamped from %v, expected %v", tt.targets, clamped, tt.clamped)
+		}
+		if clamped != 0 && req.from != clamped {
+			t.Fatalf("%v: from wasn't clamped, got %v", tt.targets, req.from)
+		}
+	}
+
+	// completely out of retention
+	req := &renderRequest{targets: []string{"short.foo"}, from: until - 10*day, until: until - 5*day}
+	if clamped := r.clamp(req, now); clamped != until-5*day || req.from != req.until {
+		t.Fatalf("range out of retention should be empty, got %v-%v", req.from, req.until)
+	}
+
+	req = &renderRequest{multiFetch: &protov3.MultiFetchRequest{Metrics: []protov3.FetchRequest{
+		{Name: "short.foo", StartTime: int64(until - 2*day), StopTime: int64(until)},
+		{Name: "other.bar", StartTime: int64(until - 2*day), StopTime: int64(until)},
+	}}}
+	if clamped := r.clamp(req, now); clamped != until-day {
+		t.Fatalf("got clamped from %v, expected %v", clamped, until-day)
+	}
+	if m := req.multiFetch.Metrics; m[0].StartTime != int64(until-day) || m[1].StartTime != int64(until-2*day) {
+		t.Fatalf("metrics should be clamped separately, got %+v", m)
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"sort"
+
+	"github.com/go-graphite/carbonapi/zipper/types"
+	protov2 "github.com/go-graphite/protocol/carbonapi_v2_pb"
+)
+
+const seriesSourcesKey renderResultKey = 1
+
+// seriesSources contains backends that returned points for every series of the response. It's only collected
+// when client asked for it with showBackends parameter.
+type seriesSources map[string][]string
+
+func withSeriesSources(ctx context.Context) (context.Context, seriesSources) {
+	s := make(seriesSources)
+	return context.WithValue(ctx, seriesSourcesKey, s), s
+}
+
+// fetchedSources returns backends of every fetched series, in the same order. Must be called before series are
+// renamed.
+func fetchedSources(stats *types.Stats, series []protov2.FetchResponse) [][]string {
+	if stats == nil || len(stats.Sources) == 0 {
+		return nil
+	}
+	res := make([][]string, len(series))
+	for i := range series {
+		res[i] = stats.Sources[series[i].Name]
+	}
+	return res
+}
+
+// recordSources saves backends of the series that will be returned to the client
+func recordSources(ctx context.Context, series []protov2.FetchResponse, sources [][]string) {
+	s, ok := ctx.Value(seriesSourcesKey).(seriesSources)
+	if !ok || sources == nil {
+		return
+	}
+	for i := range series {
+		if i < len(sources) {
+			s.add(series[i].Name, sources[i])
+		}
+	}
+}
+
+func (s seriesSources) add(name string, servers []string) {
+	for _, server := range servers {
+		found := false
+		for _, srv := range s[name] {
+			if srv == server {
+				found = true
+				break
+			}
+		}
+		if !found {
+			s[name] = append(s[name], server)
+		}
+	}
+	sort.Strings(s[name])
+}
+
+// mergeSources returns all the backends that are mentioned in sources
+func mergeSources(sources [][]string) []string {
+	s := make(seriesSources)
+	for _, servers := range sources {
+		s.add("", servers)
+	}
+	return s[""]
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/go-graphite/carbonapi/zipper/types"
+	protov2 "github.com/go-graphite/protocol/carbonapi_v2_pb"
+)
+
+func TestSeriesSources(t *testing.T) {
+	stats := &types.Stats{}
+	stats.AddSource("foo.a", "b2")
+	stats.AddSource("foo.a", "b1")
+	stats.AddSource("foo.b", "b3")
+
+	series := []protov2.FetchResponse{
+		testSeries("foo.a", []float64{1}, []bool{false}),
+		testSeries("foo.b", []float64{2}, []bool{false}),
+		testSeries("foo.c", []float64{0}, []bool{true}),
+	}
+	fetched := fetchedSources(stats, series)
+
+	ctx, sources := withSeriesSources(context.Background())
+	r := parseRenderTarget("sumSeries(foo.*)")
+	res := r.apply(series)
+	recordSources(ctx, res, r.sources(fetched))
+	if !reflect.DeepEqual(sources["sumSeries(foo.*)"], []string{"b1", "b2", "b3"}) {
+		t.Fatalf("unexpected sources of aggregated series: %v", sources)
+	}
+
+	r = parseRenderTarget("scale(foo.*,2)")
+	series = []protov2.FetchResponse{
+		testSeries("foo.a", []float64{1}, []bool{false}),
+		testSeries("foo.b", []float64{2}, []bool{false}),
+	}
+	fetched = fetchedSources(stats, series)
+	res = r.apply(series)
+	recordSources(ctx, res, r.sources(fetched))
+	if !reflect.DeepEqual(sources["scale(foo.a,2)"], []string{"b1", "b2"}) || !reflect.DeepEqual(sources["scale(foo.b,2)"], []string{"b3"}) {
+		t.Fatalf("unexpected sources of transformed series: %v", sources)
+	}
+
+	w := httptest.NewRecorder()
+	metrics := &protov2.MultiFetchResponse{Metrics: res}
+	if _, err := encodeRenderResponse(w, formatTypeJSON, metrics, nil, sources); err != nil {
+		t.Fatal(err)
+	}
+	var resp []struct {
+		Name     string   `json:"name"`
+		Backends []string `json:"backends"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp) != 2 || !reflect.DeepEqual(resp[1].Backends, []string{"b3"}) {
+		t.Fatalf("unexpected response %s", w.Body.Bytes())
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/go-graphite/carbonapi/pkg/parser"
+	util "github.com/go-graphite/carbonapi/util/ctx"
+	"github.com/go-graphite/carbonapi/util/instance"
+	"github.com/go-graphite/carbonapi/zipper/types"
+	protov2 "github.com/go-graphite/protocol/carbonapi_v2_pb"
+	"github.com/satori/go.uuid"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultStaleLimit = 100
+	maxStaleLimit     = 1000
+)
+
+// staleReport is the response of /admin/stale. Metrics of the namespace are checked page by page in alphabetical
+// order: page of `limit` metrics starting from `offset` was checked, next page starts from NextOffset.
+type staleReport struct {
+	Namespace  string   `json:"namespace"`
+	Threshold  int32    `json:"threshold"`
+	Offset     int      `json:"offset"`
+	Checked    int      `json:"checked"`
+	Total      int      `json:"total"`
+	NextOffset int      `json:"nextOffset,omitempty"`
+	Stale      []string `json:"stale"`
+}
+
+// namespaceMetrics returns sorted names of the metrics under namespace
+func namespaceMetrics(metrics []string, namespace string) []string {
+	var res []string
+	for _, m := range metrics {
+		if namespace == "" || hasPathPrefix(m, namespace) {
+			res = append(res, m)
+		}
+	}
+	sort.Strings(res)
+	return res
+}
+
+// staleMetrics returns names that don't have a single non-absent point in fetched series
+func staleMetrics(names []string, series []protov2.FetchResponse) []string {
+	fresh := make(map[string]bool, len(series))
+	for _, s := range series {
+		for _, absent := range s.IsAbsent {
+			if !absent {
+				fresh[s.Name] = true
+				break
+			}
+		}
+	}
+
+	res := make([]string, 0)
+	for _, name := range names {
+		if !fresh[name] {
+			res = append(res, name)
+		}
+	}
+	return res
+}
+
+// staleHandler lists metrics of the namespace that have no points newer than threshold. Metrics are listed on the
+// backends and then fetched for the last threshold seconds, so it's better to keep pages small.
+func staleHandler(w http.ResponseWriter, req *http.Request) {
+	t0 := time.Now()
+	uuid := uuid.NewV4()
+	ctx := util.SetUUID(req.Context(), uuid.String())
+	accessLogger := instance.Logger("access").With(
+		zap.String("handler", "stale"),
+		zap.String("carbonzipper_uuid", uuid.String()),
+	)
+	fail := func(code int, reason string) {
+		http.Error(w, reason, code)
+		accessLogger.Error("request failed",
+			zap.String("reason", reason),
+			zap.Int("http_code", code),
+			zap.Duration("runtime_seconds", time.Since(t0)),
+		)
+	}
+
+	report := staleReport{Namespace: req.FormValue("namespace")}
+
+	var err error
+	report.Threshold, err = parser.IntervalString(req.FormValue("threshold"), 1)
+	if err != nil || report.Threshold <= 0 {
+		fail(http.StatusBadRequest, "threshold must be a positive interval")
+		return
+	}
+
+	limit := defaultStaleLimit
+	if v := req.FormValue("limit"); v != "" {
+		limit, err = strconv.Atoi(v)
+		if err != nil || limit <= 0 || limit > maxStaleLimit {
+			fail(http.StatusBadRequest, "limit must be between 1 and "+strconv.Itoa(maxStaleLimit))
+			return
+		}
+	}
+	if v := req.FormValue("offset"); v != "" {
+		report.Offset, err = strconv.Atoi(v)
+		if err != nil || report.Offset < 0 {
+			fail(http.StatusBadRequest, "offset must be a non-negative integer")
+			return
+		}
+	}
+
+	list, stats, err := getZipper().ListProtoV2(ctx)
+	sendStats(stats)
+	if err != nil {
+		fail(http.StatusInternalServerError, "failed to list metrics")
+		return
+	}
+
+	metrics := namespaceMetrics(list.Metrics, report.Namespace)
+	report.Total = len(metrics)
+	page := []string{}
+	if report.Offset < len(metrics) {
+		page = metrics[report.Offset:]
+		if len(page) > limit {
+			page = page[:limit]
+			report.NextOffset = report.Offset + limit
+		}
+	}
+	report.Checked = len(page)
+
+	report.Stale = make([]string, 0)
+	if len(page) > 0 {
+		until := int32(time.Now().Unix())
+		res, stats, err := getZipper().FetchProtoV2(ctx, page, until-report.Threshold, until)
+		sendStats(stats)
+		if err != nil && err != types.ErrNotFound {
+			fail(http.StatusInternalServerError, "failed to fetch metrics")
+			return
+		}
+		var series []protov2.FetchResponse
+		if res != nil {
+			series = res.Metrics
+		}
+		report.Stale = staleMetrics(page, series)
+	}
+
+	w.Header().Set("Content-Type", contentTypeJSON)
+	/* #nosec */
+	_ = json.NewEncoder(w).Encode(report)
+	accessLogger.Info("request served",
+		zap.String("namespace", report.Namespace),
+		zap.Int("checked", report.Checked),
+		zap.Int("stale", len(report.Stale)),
+		zap.Int("http_code", http.StatusOK),
+		zap.Duration("runtime_seconds", time.Since(t0)),
+	)
+}
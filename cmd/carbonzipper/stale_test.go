@@ -0,0 +1,29 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	protov2 "github.com/go-graphite/protocol/carbonapi_v2_pb"
+)
+
+func TestNamespaceMetrics(t *testing.T) {
+	metrics := []string{"foo.b", "foobar.a", "foo.a", "bar.a", "foo"}
+	if res := namespaceMetrics(metrics, "foo"); !reflect.DeepEqual(res, []string{"foo", "foo.a", "foo.b"}) {
+		t.Fatalf("unexpected result: %v", res)
+	}
+	if res := namespaceMetrics(metrics, ""); len(res) != len(metrics) {
+		t.Fatalf("empty namespace should match everything, got %v", res)
+	}
+}
+
+func TestStaleMetrics(t *testing.T) {
+	series := []protov2.FetchResponse{
+		testSeries("foo.fresh", []float64{0, 1}, []bool{true, false}),
+		testSeries("foo.empty", []float64{0, 0}, []bool{true, true}),
+	}
+	res := staleMetrics([]string{"foo.empty", "foo.fresh", "foo.missing"}, series)
+	if !reflect.DeepEqual(res, []string{"foo.empty", "foo.missing"}) {
+		t.Fatalf("unexpected result: %v", res)
+	}
+}
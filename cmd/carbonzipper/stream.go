@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-graphite/carbonapi/date"
+	util "github.com/go-graphite/carbonapi/util/ctx"
+	"github.com/go-graphite/carbonapi/util/instance"
+	"github.com/go-graphite/carbonapi/zipper/types"
+	protov2 "github.com/go-graphite/protocol/carbonapi_v2_pb"
+	"github.com/satori/go.uuid"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultStreamInterval = 60 * time.Second
+	minStreamInterval     = time.Second
+)
+
+// streamUpdate contains datapoints of a single series that client haven't seen yet
+type streamUpdate struct {
+	Name       string       `json:"target"`
+	Step       int32        `json:"step"`
+	Datapoints [][2]float64 `json:"datapoints"`
+}
+
+// seriesTracker remembers last timestamp that was sent for every series, so only new points are sent next time
+type seriesTracker struct {
+	last map[string]int32
+}
+
+func newSeriesTracker() *seriesTracker {
+	return &seriesTracker{
+		last: make(map[string]int32),
+	}
+}
+
+// diff returns points that are newer than the ones that were already returned. Absent points are skipped, as they
+// might be filled later.
+func (t *seriesTracker) diff(metrics []protov2.FetchResponse) []streamUpdate {
+	var updates []streamUpdate
+	for _, m := range metrics {
+		last, seen := t.last[m.Name]
+		u := streamUpdate{
+			Name: m.Name,
+			Step: m.StepTime,
+		}
+		for i, v := range m.Values {
+			ts := m.StartTime + int32(i)*m.StepTime
+			if m.IsAbsent[i] || (seen && ts <= last) {
+				continue
+			}
+			u.Datapoints = append(u.Datapoints, [2]float64{v, float64(ts)})
+			last = ts
+		}
+		if len(u.Datapoints) > 0 {
+			t.last[m.Name] = last
+			updates = append(updates, u)
+		}
+	}
+	return updates
+}
+
+// from returns timestamp starting from which data should be fetched on next poll
+func (t *seriesTracker) from(defaultFrom int32) int32 {
+	from := int32(0)
+	for _, ts := range t.last {
+		if from == 0 || ts < from {
+			from = ts
+		}
+	}
+	if from == 0 {
+		return defaultFrom
+	}
+	return from
+}
+
+// pollTargets fetches targets every interval and passes new points to send, until ctx is done or send fails.
+func pollTargets(ctx context.Context, targets []string, from int32, interval time.Duration, send func([]streamUpdate) error) error {
+	tracker := newSeriesTracker()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		metrics, err := fetchRenderTargets(ctx, targets, tracker.from(from), int32(time.Now().Unix()))
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err != nil && err != types.ErrNotFound {
+			return err
+		}
+		if updates := tracker.diff(metrics.Metrics); len(updates) > 0 {
+			if err := send(updates); err != nil {
+				return err
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// streamHandler keeps connection open and sends new datapoints of the targets as newline-delimited JSON
+func streamHandler(w http.ResponseWriter, req *http.Request) {
+	t0 := time.Now()
+	uuid := uuid.NewV4()
+	ctx := req.Context()
+	ctx = util.SetUUID(ctx, uuid.String())
+
+	Metrics.StreamRequests.Add(1)
+
+	accessLogger := instance.Logger("access").With(
+		zap.String("handler", "render_stream"),
+		zap.String("carbonzipper_uuid", uuid.String()),
+		zap.String("carbonapi_uuid", util.GetUUID(ctx)),
+	)
+
+	err := req.ParseForm()
+	if err != nil {
+		http.Error(w, "failed to parse arguments", http.StatusBadRequest)
+		accessLogger.Error("request failed",
+			zap.String("reason", "failed to parse arguments"),
+			zap.Int("http_code", http.StatusBadRequest),
+			zap.Duration("runtime_seconds", time.Since(t0)),
+		)
+		return
+	}
+	targets := req.Form["target"]
+	accessLogger = accessLogger.With(
+		zap.Strings("targets", targets),
+	)
+
+	if len(targets) == 0 {
+		http.Error(w, "empty target", http.StatusBadRequest)
+		accessLogger.Error("request failed",
+			zap.String("reason", "empty target"),
+			zap.Int("http_code", http.StatusBadRequest),
+			zap.Duration("runtime_seconds", time.Since(t0)),
+		)
+		return
+	}
+
+	from, err := date.ParseDateParam(req.FormValue("from"), req.FormValue("tz"), time.Now().Add(-10*time.Minute).Unix(), time.Local)
+	if err != nil {
+		http.Error(w, "from: "+err.Error(), http.StatusBadRequest)
+		accessLogger.Error("request failed",
+			zap.String("reason", "invalid from"),
+			zap.Int("http_code", http.StatusBadRequest),
+			zap.Duration("runtime_seconds", time.Since(t0)),
+		)
+		return
+	}
+
+	interval := defaultStreamInterval
+	if v := req.FormValue("interval"); v != "" {
+		sec, err := strconv.Atoi(v)
+		if err != nil || time.Duration(sec)*time.Second < minStreamInterval {
+			http.Error(w, "interval must be a positive integer", http.StatusBadRequest)
+			accessLogger.Error("request failed",
+				zap.String("reason", "invalid interval"),
+				zap.Int("http_code", http.StatusBadRequest),
+				zap.Duration("runtime_seconds", time.Since(t0)),
+			)
+			return
+		}
+		interval = time.Duration(sec) * time.Second
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		accessLogger.Error("request failed",
+			zap.String("reason", "response writer doesn't support flushing"),
+			zap.Int("http_code", http.StatusInternalServerError),
+			zap.Duration("runtime_seconds", time.Since(t0)),
+		)
+		return
+	}
+
+	if maxDuration := getConfig().StreamMaxDuration; maxDuration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, maxDuration)
+		defer cancel()
+	}
+
+	w.Header().Set("Content-Type", contentTypeJSONStream)
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	enc := json.NewEncoder(w)
+	sent := 0
+	err = pollTargets(ctx, targets, int32(from), interval, func(updates []streamUpdate) error {
+		for _, u := range updates {
+			if err := enc.Encode(u); err != nil {
+				return err
+			}
+			sent += len(u.Datapoints)
+		}
+		flusher.Flush()
+		return nil
+	})
+
+	if err != nil {
+		accessLogger.Error("stream interrupted",
+			zap.Int("datapoints_sent", sent),
+			zap.Duration("runtime_seconds", time.Since(t0)),
+			zap.Error(err),
+		)
+		return
+	}
+
+	accessLogger.Info("stream finished",
+		zap.Int("datapoints_sent", sent),
+		zap.Int("http_code", http.StatusOK),
+		zap.Duration("runtime_seconds", time.Since(t0)),
+	)
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	protov2 "github.com/go-graphite/protocol/carbonapi_v2_pb"
+)
+
+func TestSeriesTrackerDiff(t *testing.T) {
+	tracker := newSeriesTracker()
+
+	updates := tracker.diff([]protov2.FetchResponse{
+		testSeries("foo", []float64{1, 2, 0}, []bool{false, false, true}),
+	})
+	expected := []streamUpdate{{Name: "foo", Step: 60, Datapoints: [][2]float64{{1, 60}, {2, 120}}}}
+	if !reflect.DeepEqual(updates, expected) {
+		t.Fatalf("unexpected first update, got %v, expected %v", updates, expected)
+	}
+
+	if from := tracker.from(0); from != 120 {
+		t.Fatalf("unexpected from, got %v, expected %v", from, 120)
+	}
+
+	// absent point was filled and new one have arrived
+	s := testSeries("foo", []float64{2, 3, 4}, []bool{false, false, false})
+	s.StartTime = 120
+	updates = tracker.diff([]protov2.FetchResponse{s})
+	expected = []streamUpdate{{Name: "foo", Step: 60, Datapoints: [][2]float64{{3, 180}, {4, 240}}}}
+	if !reflect.DeepEqual(updates, expected) {
+		t.Fatalf("unexpected second update, got %v, expected %v", updates, expected)
+	}
+
+	if updates = tracker.diff([]protov2.FetchResponse{s}); len(updates) != 0 {
+		t.Fatalf("same data shouldn't be sent twice, got %v", updates)
+	}
+}
+
+func TestSeriesTrackerFromDefault(t *testing.T) {
+	tracker := newSeriesTracker()
+	if from := tracker.from(1000); from != 1000 {
+		t.Fatalf("unexpected from, got %v, expected %v", from, 1000)
+	}
+}
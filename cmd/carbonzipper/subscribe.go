@@ -0,0 +1,254 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	util "github.com/go-graphite/carbonapi/util/ctx"
+	"github.com/go-graphite/carbonapi/util/instance"
+	"github.com/satori/go.uuid"
+	"go.uber.org/zap"
+)
+
+// subscriptionRequest is a message that client sends over websocket to change the set of targets it's interested in
+type subscriptionRequest struct {
+	Subscribe   []string `json:"subscribe"`
+	Unsubscribe []string `json:"unsubscribe"`
+}
+
+// subscriber is a single websocket client. Updates that can't be delivered in time are dropped
+type subscriber struct {
+	updates chan []streamUpdate
+}
+
+// targetPoller fetches single target for all of its subscribers
+type targetPoller struct {
+	subscribers map[*subscriber]struct{}
+	cancel      context.CancelFunc
+}
+
+// wsClosePolicyViolation is the status of close frame sent to clients that exceed the limits
+const wsClosePolicyViolation = 1008
+
+// subscriptionHub makes sure that each target is fetched only once per interval, no matter how many clients
+// have subscribed to it.
+type subscriptionHub struct {
+	sync.Mutex
+	interval time.Duration
+	pollers  map[string]*targetPoller
+
+	// origins are globs of cross-origin pages that may open subscriptions, see allowedOrigin
+	origins []string
+	// maxTargets limits targets of a single connection, 0 means no limit
+	maxTargets int
+}
+
+func newSubscriptionHub(interval time.Duration, origins []string, maxTargets int) *subscriptionHub {
+	if interval < minStreamInterval {
+		interval = defaultStreamInterval
+	}
+	return &subscriptionHub{
+		interval:   interval,
+		pollers:    make(map[string]*targetPoller),
+		origins:    origins,
+		maxTargets: maxTargets,
+	}
+}
+
+// allowedOrigin protects from cross-site websocket hijacking. Browsers always send Origin with websocket
+// handshake and don't apply same origin policy to it, so pages of other sites must be allowed explicitly.
+// Requests without Origin don't come from browsers.
+func (h *subscriptionHub) allowedOrigin(req *http.Request) bool {
+	origin := req.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	if u, err := url.Parse(origin); err == nil && u.Host == req.Host {
+		return true
+	}
+	return matchesAny(h.origins, origin, identityMatch)
+}
+
+func (h *subscriptionHub) subscribe(target string, s *subscriber) {
+	h.Lock()
+	defer h.Unlock()
+
+	p, ok := h.pollers[target]
+	if !ok {
+		ctx, cancel := context.WithCancel(context.Background())
+		p = &targetPoller{
+			subscribers: make(map[*subscriber]struct{}),
+			cancel:      cancel,
+		}
+		h.pollers[target] = p
+		go h.poll(ctx, target, p)
+	}
+	p.subscribers[s] = struct{}{}
+}
+
+func (h *subscriptionHub) unsubscribe(target string, s *subscriber) {
+	h.Lock()
+	defer h.Unlock()
+
+	p, ok := h.pollers[target]
+	if !ok {
+		return
+	}
+	delete(p.subscribers, s)
+	if len(p.subscribers) == 0 {
+		p.cancel()
+		delete(h.pollers, target)
+	}
+}
+
+func (h *subscriptionHub) poll(ctx context.Context, target string, p *targetPoller) {
+	logger := instance.Logger("subscribe").With(zap.String("target", target))
+	ctx = util.SetUUID(ctx, uuid.NewV4().String())
+	from := int32(time.Now().Add(-h.interval).Unix())
+
+	for ctx.Err() == nil {
+		err := pollTargets(ctx, []string{target}, from, h.interval, func(updates []streamUpdate) error {
+			h.Lock()
+			for s := range p.subscribers {
+				select {
+				case s.updates <- updates:
+				default:
+				}
+			}
+			h.Unlock()
+			return nil
+		})
+		if err != nil {
+			logger.Warn("failed to fetch subscribed target", zap.Error(err))
+			select {
+			case <-ctx.Done():
+			case <-time.After(h.interval):
+			}
+		}
+	}
+}
+
+// Targets returns amount of targets that are currently polled
+func (h *subscriptionHub) Targets() int {
+	h.Lock()
+	defer h.Unlock()
+	return len(h.pollers)
+}
+
+// subscribeHandler upgrades connection to websocket. Client sends subscriptionRequest messages and receives
+// JSON-encoded arrays of streamUpdate for the targets it's subscribed to.
+func (h *subscriptionHub) subscribeHandler(w http.ResponseWriter, req *http.Request) {
+	t0 := time.Now()
+	logger := instance.Logger("access").With(
+		zap.String("handler", "subscribe"),
+		zap.String("carbonapi_uuid", util.GetUUID(req.Context())),
+	)
+
+	if !h.allowedOrigin(req) {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		logger.Error("request failed",
+			zap.String("reason", "origin not allowed"),
+			zap.String("origin", req.Header.Get("Origin")),
+			zap.Duration("runtime_seconds", time.Since(t0)),
+		)
+		return
+	}
+
+	conn, err := upgradeWebsocket(w, req)
+	if err != nil {
+		logger.Error("request failed",
+			zap.String("reason", "websocket upgrade failed"),
+			zap.Duration("runtime_seconds", time.Since(t0)),
+			zap.Error(err),
+		)
+		return
+	}
+	defer conn.Close()
+
+	Metrics.SubscribeRequests.Add(1)
+
+	s := &subscriber{updates: make(chan []streamUpdate, 16)}
+	targets := make(map[string]struct{})
+	defer func() {
+		for t := range targets {
+			h.unsubscribe(t, s)
+		}
+	}()
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case updates := <-s.updates:
+				b, _ := json.Marshal(updates)
+				if err := conn.writeFrame(wsOpText, b); err != nil {
+					conn.Close()
+					return
+				}
+			}
+		}
+	}()
+
+	for {
+		opcode, payload, err := conn.readFrame()
+		if err != nil {
+			break
+		}
+
+		switch opcode {
+		case wsOpText:
+			var r subscriptionRequest
+			if err := json.Unmarshal(payload, &r); err != nil {
+				logger.Warn("invalid subscription request", zap.Error(err))
+				continue
+			}
+			for _, t := range r.Unsubscribe {
+				if _, ok := targets[t]; ok {
+					delete(targets, t)
+					h.unsubscribe(t, s)
+				}
+			}
+			for _, t := range r.Subscribe {
+				if _, ok := targets[t]; ok {
+					continue
+				}
+				if h.maxTargets > 0 && len(targets) >= h.maxTargets {
+					payload := make([]byte, 2, 32)
+					binary.BigEndian.PutUint16(payload, wsClosePolicyViolation)
+					_ = conn.writeFrame(wsOpClose, append(payload, "too many targets"...))
+					logger.Error("request failed",
+						zap.String("reason", "too many targets"),
+						zap.Int("targets", len(targets)),
+						zap.Duration("runtime_seconds", time.Since(t0)),
+					)
+					return
+				}
+				targets[t] = struct{}{}
+				h.subscribe(t, s)
+			}
+		case wsOpPing:
+			_ = conn.writeFrame(wsOpPong, payload)
+		case wsOpClose:
+			_ = conn.writeFrame(wsOpClose, nil)
+			logger.Info("request served",
+				zap.Int("targets", len(targets)),
+				zap.Duration("runtime_seconds", time.Since(t0)),
+			)
+			return
+		}
+	}
+
+	logger.Info("request served",
+		zap.String("reason", "connection closed"),
+		zap.Int("targets", len(targets)),
+		zap.Duration("runtime_seconds", time.Since(t0)),
+	)
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// defaultTarpitMaxDelay caps delays if maxDelay isn't set, otherwise clients without hard limit would be delayed
+// indefinitely
+const defaultTarpitMaxDelay = 10 * time.Second
+
+// TarpitConfig describes how clients that send too many requests are slowed down. Every client IP may send
+// softLimit requests per window without any delay, every request above that is delayed by delay more than
+// the previous one, up to maxDelay (defaultTarpitMaxDelay if it's not set). Requests above hardLimit are rejected.
+type TarpitConfig struct {
+	Window    time.Duration `mapstructure:"window"`
+	SoftLimit int           `mapstructure:"softLimit"`
+	HardLimit int           `mapstructure:"hardLimit"`
+	Delay     time.Duration `mapstructure:"delay"`
+	MaxDelay  time.Duration `mapstructure:"maxDelay"`
+}
+
+type tarpitClient struct {
+	start time.Time
+	count int
+}
+
+// tarpit counts requests per client IP in fixed windows
+type tarpit struct {
+	sync.Mutex
+	config  TarpitConfig
+	clients map[string]*tarpitClient
+	pruned  time.Time
+
+	delayed  int64
+	rejected int64
+
+	now func() time.Time
+}
+
+func newTarpit(config TarpitConfig) *tarpit {
+	if config.SoftLimit <= 0 && config.HardLimit <= 0 {
+		return nil
+	}
+	if config.Window <= 0 {
+		config.Window = time.Second
+	}
+	if config.MaxDelay <= 0 {
+		config.MaxDelay = defaultTarpitMaxDelay
+	}
+	return &tarpit{
+		config:  config,
+		clients: make(map[string]*tarpitClient),
+		now:     time.Now,
+	}
+}
+
+// tarpitDecision describes what should be done with the request
+type tarpitDecision struct {
+	// delay of the request, if it's accepted
+	delay time.Duration
+	ok    bool
+	// load is ratio of client's requests in current window to hard limit, or to soft limit if there is no hard one
+	load float64
+	// reset is time left till the end of the window, when limits of the client are reset
+	reset time.Duration
+}
+
+// check registers request of the client and returns how long it should be delayed or false if it must be rejected
+func (t *tarpit) check(client string) tarpitDecision {
+	t.Lock()
+	defer t.Unlock()
+
+	now := t.now()
+	if now.Sub(t.pruned) >= t.config.Window {
+		for ip, c := range t.clients {
+			if now.Sub(c.start) >= t.config.Window {
+				delete(t.clients, ip)
+			}
+		}
+		t.pruned = now
+	}
+
+	c, ok := t.clients[client]
+	if !ok || now.Sub(c.start) >= t.config.Window {
+		c = &tarpitClient{start: now}
+		t.clients[client] = c
+	}
+	c.count++
+
+	d := tarpitDecision{
+		ok:    true,
+		reset: c.start.Add(t.config.Window).Sub(now),
+	}
+	if t.config.HardLimit > 0 {
+		d.load = float64(c.count) / float64(t.config.HardLimit)
+	} else {
+		d.load = float64(c.count) / float64(t.config.SoftLimit)
+	}
+
+	if t.config.HardLimit > 0 && c.count > t.config.HardLimit {
+		d.ok = false
+		return d
+	}
+	if t.config.SoftLimit <= 0 || c.count <= t.config.SoftLimit {
+		return d
+	}
+	d.delay = time.Duration(c.count-t.config.SoftLimit) * t.config.Delay
+	if d.delay > t.config.MaxDelay {
+		d.delay = t.config.MaxDelay
+	}
+	return d
+}
+
+// retryAfter returns value of Retry-After header: whole seconds till the end of the window
+func (d tarpitDecision) retryAfter() string {
+	seconds := int((d.reset + time.Second - 1) / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+	return strconv.Itoa(seconds)
+}
+
+// Delayed returns amount of requests that were delayed
+func (t *tarpit) Delayed() int64 {
+	if t == nil {
+		return 0
+	}
+	return atomic.LoadInt64(&t.delayed)
+}
+
+// Rejected returns amount of requests that were rejected because of hard limit
+func (t *tarpit) Rejected() int64 {
+	if t == nil {
+		return 0
+	}
+	return atomic.LoadInt64(&t.rejected)
+}
+
+// clientIP returns IP part of the remote address
+func clientIP(remoteAddr string) string {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return remoteAddr
+	}
+	return host
+}
+
+// wrap delays or rejects requests of the clients that exceeded the limits. Every response has X-CarbonZipper-Load
+// header with load of the client, delayed and rejected ones also have Retry-After, so clients can slow down
+// before they are rejected.
+func (t *tarpit) wrap(h http.HandlerFunc) http.HandlerFunc {
+	if t == nil {
+		return h
+	}
+	return func(w http.ResponseWriter, req *http.Request) {
+		d := t.check(clientIP(req.RemoteAddr))
+		w.Header().Set("X-CarbonZipper-Load", strconv.FormatFloat(d.load, 'f', 2, 64))
+		if !d.ok {
+			atomic.AddInt64(&t.rejected, 1)
+			w.Header().Set("Retry-After", d.retryAfter())
+			http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+			return
+		}
+		if d.delay > 0 {
+			atomic.AddInt64(&t.delayed, 1)
+			w.Header().Set("Retry-After", d.retryAfter())
+			timer := time.NewTimer(d.delay)
+			select {
+			case <-timer.C:
+			case <-req.Context().Done():
+				// client gave up, no need to do anything for it
+				timer.Stop()
+				return
+			}
+		}
+		h(w, req)
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestTarpitCheck(t *testing.T) {
+	tp := newTarpit(TarpitConfig{Window: time.Second, SoftLimit: 2, HardLimit: 5, Delay: 100 * time.Millisecond, MaxDelay: 200 * time.Millisecond})
+	now := time.Unix(1000, 0)
+	tp.now = func() time.Time { return now }
+
+	tests := []struct {
+		delay time.Duration
+		ok    bool
+	}{
+		{0, true},
+		{0, true},
+		{100 * time.Millisecond, true},
+		{200 * time.Millisecond, true},
+		{200 * time.Millisecond, true},
+		{0, false},
+	}
+	for i, tt := range tests {
+		d := tp.check("10.0.0.1")
+		if d.delay != tt.delay || d.ok != tt.ok {
+			t.Fatalf("request %v: got %v %v, expected %v %v", i, d.delay, d.ok, tt.delay, tt.ok)
+		}
+		if expected := float64(i+1) / 5; d.load != expected {
+			t.Fatalf("request %v: got load %v, expected %v", i, d.load, expected)
+		}
+		now = now.Add(100 * time.Millisecond)
+	}
+
+	if d := tp.check("10.0.0.2"); d.delay != 0 || !d.ok {
+		t.Fatalf("other clients shouldn't be affected, got %v %v", d.delay, d.ok)
+	}
+
+	if d := tp.check("10.0.0.1"); d.reset != 400*time.Millisecond || d.retryAfter() != "1" {
+		t.Fatalf("unexpected time till the end of the window %v, Retry-After %v", d.reset, d.retryAfter())
+	}
+
+	now = now.Add(time.Second)
+	if d := tp.check("10.0.0.1"); d.delay != 0 || !d.ok {
+		t.Fatalf("limits should be reset in the next window, got %v %v", d.delay, d.ok)
+	}
+	if len(tp.clients) != 1 {
+		t.Fatalf("expired clients should be removed, got %v", len(tp.clients))
+	}
+
+	if newTarpit(TarpitConfig{}) != nil {
+		t.Fatal("tarpit should be disabled without limits")
+	}
+
+	// delay is capped even if neither maxDelay nor hardLimit is set
+	tp = newTarpit(TarpitConfig{Window: time.Minute, SoftLimit: 1, Delay: time.Second})
+	tp.now = func() time.Time { return now }
+	var d tarpitDecision
+	for i := 0; i < 100; i++ {
+		d = tp.check("10.0.0.1")
+	}
+	if !d.ok || d.delay != defaultTarpitMaxDelay {
+		t.Fatalf("unexpected delay without maxDelay %v, %v", d.delay, d.ok)
+	}
+}
+
+func TestTarpitWrap(t *testing.T) {
+	tp := newTarpit(TarpitConfig{Window: time.Minute, HardLimit: 1})
+	h := tp.wrap(func(w http.ResponseWriter, req *http.Request) {})
+
+	for _, code := range []int{http.StatusOK, http.StatusTooManyRequests} {
+		req := httptest.NewRequest("GET", "/render/?target=foo", nil)
+		rr := httptest.NewRecorder()
+		h(rr, req)
+		if rr.Code != code {
+			t.Fatalf("unexpected code, got %v, expected %v", rr.Code, code)
+		}
+		if code == http.StatusTooManyRequests && (rr.Header().Get("Retry-After") != "60" || rr.Header().Get("X-CarbonZipper-Load") != "2.00") {
+			t.Fatalf("unexpected back-pressure headers %v", rr.Header())
+		}
+	}
+	if tp.Rejected() != 1 {
+		t.Fatalf("unexpected amount of rejected requests %v", tp.Rejected())
+	}
+}
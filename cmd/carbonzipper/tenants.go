@@ -0,0 +1,92 @@
+package main
+
+import (
+	"net/http"
+
+	util "github.com/go-graphite/carbonapi/util/ctx"
+)
+
+// TenantConfig restricts backend groups that requests of the tenant may query. Backends are names of the groups
+// from backendsv2 ("backends" for the old style config). Tenant with AllBackends set is not restricted.
+type TenantConfig struct {
+	Name        string   `mapstructure:"name"`
+	Backends    []string `mapstructure:"backends"`
+	AllBackends bool     `mapstructure:"allBackends"`
+}
+
+// tenants identifies tenant of the request by header. Requests without the header are of the default tenant,
+// requests of unknown tenants, or without the header if there is no default one, are refused.
+type tenants struct {
+	header        string
+	defaultTenant string
+	backends      map[string][]string
+}
+
+func newTenants(header, defaultTenant string, config []TenantConfig) *tenants {
+	if header == "" || len(config) == 0 {
+		return nil
+	}
+	t := &tenants{
+		header:        header,
+		defaultTenant: defaultTenant,
+		backends:      make(map[string][]string, len(config)),
+	}
+	for _, c := range config {
+		if c.AllBackends {
+			t.backends[c.Name] = nil
+			continue
+		}
+		// empty list must still restrict the tenant
+		t.backends[c.Name] = append([]string{}, c.Backends...)
+	}
+	return t
+}
+
+// allowedBackends returns backends the request may query. ok is false if tenant is unknown, nil list means that
+// request is not restricted.
+func (t *tenants) allowedBackends(req *http.Request) (backends []string, ok bool) {
+	if t == nil {
+		return nil, true
+	}
+	tenant := req.Header.Get(t.header)
+	if tenant == "" {
+		tenant = t.defaultTenant
+	}
+	if tenant == "" {
+		return nil, false
+	}
+	backends, ok = t.backends[tenant]
+	return backends, ok
+}
+
+// wrap restricts backends that h may query to the ones allowed for the tenant
+func (t *tenants) wrap(h http.HandlerFunc) http.HandlerFunc {
+	if t == nil {
+		return h
+	}
+	return func(w http.ResponseWriter, req *http.Request) {
+		backends, ok := t.allowedBackends(req)
+		if !ok {
+			http.Error(w, "unknown tenant", http.StatusForbidden)
+			return
+		}
+		if backends != nil {
+			req = req.WithContext(util.SetAllowedBackends(req.Context(), backends))
+		}
+		h(w, req)
+	}
+}
+
+// deny refuses requests of restricted tenants, it's used for handlers that share results between clients
+func (t *tenants) deny(h http.HandlerFunc) http.HandlerFunc {
+	if t == nil {
+		return h
+	}
+	return func(w http.ResponseWriter, req *http.Request) {
+		if backends, ok := t.allowedBackends(req); !ok || backends != nil {
+			http.Error(w, "not allowed for the tenant", http.StatusForbidden)
+			return
+		}
+		h(w, req)
+	}
+}
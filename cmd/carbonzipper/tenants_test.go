@@ -0,0 +1,77 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	util "github.com/go-graphite/carbonapi/util/ctx"
+)
+
+func TestTenants(t *testing.T) {
+	tenants := newTenants("X-Tenant", "", []TenantConfig{
+		{Name: "external", Backends: []string{"external-cluster"}},
+		{Name: "nobody"},
+		{Name: "internal", AllBackends: true},
+	})
+
+	var allowed []string
+	h := func(w http.ResponseWriter, req *http.Request) {
+		allowed = util.GetAllowedBackends(req.Context())
+	}
+
+	tests := []struct {
+		tenant  string
+		code    int
+		allowed []string
+		shared  int
+	}{
+		{"", http.StatusForbidden, nil, http.StatusForbidden},
+		{"internal", http.StatusOK, nil, http.StatusOK},
+		{"external", http.StatusOK, []string{"external-cluster"}, http.StatusForbidden},
+		{"nobody", http.StatusOK, []string{}, http.StatusForbidden},
+		{"unknown", http.StatusForbidden, nil, http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.tenant, func(t *testing.T) {
+			allowed = nil
+			req := httptest.NewRequest("GET", "/render/?target=foo", nil)
+			if tt.tenant != "" {
+				req.Header.Set("X-Tenant", tt.tenant)
+			}
+
+			w := httptest.NewRecorder()
+			tenants.wrap(h)(w, req)
+			if w.Code != tt.code || !reflect.DeepEqual(allowed, tt.allowed) {
+				t.Fatalf("unexpected result: code %v, allowed backends %v", w.Code, allowed)
+			}
+
+			w = httptest.NewRecorder()
+			tenants.deny(h)(w, req)
+			if w.Code != tt.shared {
+				t.Fatalf("unexpected code of shared handler %v, expected %v", w.Code, tt.shared)
+			}
+		})
+	}
+}
+
+func TestTenantsDefault(t *testing.T) {
+	config := []TenantConfig{
+		{Name: "external", Backends: []string{"external-cluster"}},
+		{Name: "internal", Backends: []string{"internal-cluster"}},
+	}
+
+	// request without the header can't escape restriction of a tenant
+	for _, defaultTenant := range []string{"", "internal"} {
+		req := httptest.NewRequest("GET", "/render/?target=foo", nil)
+		backends, ok := newTenants("X-Tenant", defaultTenant, config).allowedBackends(req)
+		if ok && (backends == nil || reflect.DeepEqual(backends, []string{"external-cluster"})) {
+			t.Fatalf("default tenant %q: request without the header is allowed to query %v", defaultTenant, backends)
+		}
+		if defaultTenant != "" && !reflect.DeepEqual(backends, []string{"internal-cluster"}) {
+			t.Fatalf("default tenant %q: unexpected backends %v", defaultTenant, backends)
+		}
+	}
+}
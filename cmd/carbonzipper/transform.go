@@ -0,0 +1,353 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/go-graphite/carbonapi/date"
+	"github.com/go-graphite/carbonapi/pkg/parser"
+	protov2 "github.com/go-graphite/protocol/carbonapi_v2_pb"
+)
+
+// seriesTransform is applied to every series after it was fetched (and aggregated, if needed)
+type seriesTransform func(protov2.FetchResponse) protov2.FetchResponse
+
+// transforms contains functions that carbonzipper can apply to the series by itself. Every function gets parsed
+// expression and returns transform with arguments already bound.
+var transforms = map[string]func(e parser.Expr) (seriesTransform, error){
+	"alias": func(e parser.Expr) (seriesTransform, error) {
+		name, err := e.GetStringArg(1)
+		if err != nil {
+			return nil, err
+		}
+		return func(s protov2.FetchResponse) protov2.FetchResponse { return transformAlias(s, name) }, nil
+	},
+	"aliasByNode": func(e parser.Expr) (seriesTransform, error) {
+		nodes, err := e.GetIntArgs(1)
+		if err != nil {
+			return nil, err
+		}
+		return func(s protov2.FetchResponse) protov2.FetchResponse { return transformAliasByNode(s, nodes) }, nil
+	},
+	"scale": func(e parser.Expr) (seriesTransform, error) {
+		factor, err := e.GetFloatArg(1)
+		if err != nil {
+			return nil, err
+		}
+		return func(s protov2.FetchResponse) protov2.FetchResponse { return transformScale(s, factor) }, nil
+	},
+	"derivative": func(e parser.Expr) (seriesTransform, error) {
+		return transformDerivative, nil
+	},
+	"nonNegativeDerivative": func(e parser.Expr) (seriesTransform, error) {
+		return transformNonNegativeDerivative, nil
+	},
+	"perSecond": func(e parser.Expr) (seriesTransform, error) {
+		return transformPerSecond, nil
+	},
+	"timeSlice": func(e parser.Expr) (seriesTransform, error) {
+		startStr, err := e.GetStringArg(1)
+		if err != nil {
+			return nil, err
+		}
+		endStr, err := e.GetStringArgDefault(2, "now")
+		if err != nil {
+			return nil, err
+		}
+		now := time.Now().Unix()
+		start := int32(date.DateParamToEpoch(startStr, "", 0, time.Local))
+		end := int32(date.DateParamToEpoch(endStr, "", now, time.Local))
+		if start == 0 {
+			return nil, parser.ErrBadType
+		}
+		return func(s protov2.FetchResponse) protov2.FetchResponse { return transformTimeSlice(s, start, end) }, nil
+	},
+}
+
+// renderTarget describes how carbonzipper serves a single target: what it fetches, how fetched series are
+// aggregated and which transforms are applied afterwards.
+type renderTarget struct {
+	target     string
+	metrics    []string
+	agg        *aggregationTarget
+	transforms []seriesTransform
+	// shift is added to requested time range before fetching metrics, see timeShift
+	shift int32
+}
+
+// isRaw returns true if target should be passed to backends as is
+func (t renderTarget) isRaw() bool {
+	return t.agg == nil && len(t.transforms) == 0
+}
+
+// parseRenderTarget unwraps supported transforms and aggregations. Anything carbonzipper can't evaluate
+// is returned as a raw target.
+func parseRenderTarget(target string) renderTarget {
+	raw := renderTarget{target: target, metrics: []string{target}}
+
+	e, rest, err := parser.ParseExpr(target)
+	if err != nil || rest != "" {
+		return raw
+	}
+
+	var ts []seriesTransform
+	var shift int32
+	for e.IsFunc() {
+		if len(e.Args()) == 0 || len(e.NamedArgs()) != 0 {
+			break
+		}
+		if e.Target() == "timeShift" {
+			offs, err := e.GetIntervalArg(1, -1)
+			if err != nil {
+				return raw
+			}
+			shift += offs
+			ts = append(ts, func(s protov2.FetchResponse) protov2.FetchResponse { return transformTimeShift(s, offs) })
+			e = e.Args()[0]
+			continue
+		}
+		build, ok := transforms[e.Target()]
+		if !ok {
+			break
+		}
+		t, err := build(e)
+		if err != nil {
+			return raw
+		}
+		ts = append(ts, t)
+		e = e.Args()[0]
+	}
+
+	// transforms were collected from the outermost one, but must be applied starting from the innermost
+	for i, j := 0, len(ts)-1; i < j; i, j = i+1, j-1 {
+		ts[i], ts[j] = ts[j], ts[i]
+	}
+
+	if e.IsName() {
+		return renderTarget{target: target, metrics: []string{e.Target()}, transforms: ts, shift: shift}
+	}
+
+	if a, ok := parseAggregationExpr(e); ok {
+		return renderTarget{target: target, metrics: a.metrics, agg: &a, transforms: ts, shift: shift}
+	}
+
+	return raw
+}
+
+// apply aggregates and transforms fetched series
+func (t renderTarget) apply(series []protov2.FetchResponse) []protov2.FetchResponse {
+	if t.agg != nil {
+		series = []protov2.FetchResponse{t.agg.aggregate(series)}
+	}
+	for _, f := range t.transforms {
+		for i := range series {
+			series[i] = f(series[i])
+		}
+	}
+	return series
+}
+
+// sources returns backends of every series returned by apply, fetched contains backends of every fetched series
+func (t renderTarget) sources(fetched [][]string) [][]string {
+	if t.agg == nil || fetched == nil {
+		return fetched
+	}
+	return [][]string{mergeSources(fetched)}
+}
+
+func transformAlias(s protov2.FetchResponse, name string) protov2.FetchResponse {
+	s.Name = name
+	return s
+}
+
+// metricPath extracts metric name from series name, e.x. a.b.c from sumSeries(a.b.c)
+func metricPath(name string) string {
+	if i := strings.LastIndex(name, "("); i != -1 {
+		name = name[i+1:]
+	}
+	if i := strings.IndexAny(name, ",)"); i != -1 {
+		name = name[:i]
+	}
+	return name
+}
+
+func transformAliasByNode(s protov2.FetchResponse, nodes []int) protov2.FetchResponse {
+	parts := strings.Split(metricPath(s.Name), ".")
+	var name []string
+	for _, n := range nodes {
+		if n < 0 {
+			n += len(parts)
+		}
+		if n < 0 || n >= len(parts) {
+			continue
+		}
+		name = append(name, parts[n])
+	}
+	s.Name = strings.Join(name, ".")
+	return s
+}
+
+func transformScale(s protov2.FetchResponse, factor float64) protov2.FetchResponse {
+	values := make([]float64, len(s.Values))
+	for i, v := range s.Values {
+		values[i] = v * factor
+	}
+	s.Name = fmt.Sprintf("scale(%s,%g)", s.Name, factor)
+	s.Values = values
+	return s
+}
+
+// delta calculates difference between consecutive points. First point and points after the gap are absent.
+// If nonNegative is set, counter resets are reported as absent too.
+func delta(s protov2.FetchResponse, nonNegative bool, divisor float64) ([]float64, []bool) {
+	values := make([]float64, len(s.Values))
+	absent := make([]bool, len(s.Values))
+	prev := math.NaN()
+	for i, v := range s.Values {
+		if s.IsAbsent[i] {
+			absent[i] = true
+			prev = math.NaN()
+			continue
+		}
+		diff := v - prev
+		if math.IsNaN(diff) || (nonNegative && diff < 0) {
+			absent[i] = true
+		} else {
+			values[i] = diff / divisor
+		}
+		prev = v
+	}
+	return values, absent
+}
+
+func transformDerivative(s protov2.FetchResponse) protov2.FetchResponse {
+	s.Values, s.IsAbsent = delta(s, false, 1)
+	s.Name = fmt.Sprintf("derivative(%s)", s.Name)
+	return s
+}
+
+func transformNonNegativeDerivative(s protov2.FetchResponse) protov2.FetchResponse {
+	s.Values, s.IsAbsent = delta(s, true, 1)
+	s.Name = fmt.Sprintf("nonNegativeDerivative(%s)", s.Name)
+	return s
+}
+
+func transformPerSecond(s protov2.FetchResponse) protov2.FetchResponse {
+	s.Values, s.IsAbsent = delta(s, true, float64(s.StepTime))
+	s.Name = fmt.Sprintf("perSecond(%s)", s.Name)
+	return s
+}
+
+// removeEmptySeries returns series that have at least one non-absent point. Original slice is not modified.
+func removeEmptySeries(series []protov2.FetchResponse) []protov2.FetchResponse {
+	res := make([]protov2.FetchResponse, 0, len(series))
+	for _, s := range series {
+		for _, absent := range s.IsAbsent {
+			if !absent {
+				res = append(res, s)
+				break
+			}
+		}
+	}
+	return res
+}
+
+// fillAbsent replaces absent points with value. Original series are not modified.
+func fillAbsent(series []protov2.FetchResponse, value float64) []protov2.FetchResponse {
+	res := make([]protov2.FetchResponse, len(series))
+	for i, s := range series {
+		values := make([]float64, len(s.Values))
+		for j, v := range s.Values {
+			if s.IsAbsent[j] {
+				v = value
+			}
+			values[j] = v
+		}
+		s.Values = values
+		s.IsAbsent = make([]bool, len(values))
+		res[i] = s
+	}
+	return res
+}
+
+// trimToWatermark drops points at or before watermark. Series without newer points are returned empty, so clients
+// can tell them from the ones that don't exist. Original series are not modified.
+func trimToWatermark(series []protov2.FetchResponse, watermark int32) []protov2.FetchResponse {
+	res := make([]protov2.FetchResponse, len(series))
+	for i, s := range series {
+		if s.StepTime > 0 && s.StartTime <= watermark {
+			skip := int((watermark-s.StartTime)/s.StepTime) + 1
+			if skip > len(s.Values) {
+				skip = len(s.Values)
+			}
+			s.Values = s.Values[skip:]
+			s.IsAbsent = s.IsAbsent[skip:]
+			s.StartTime += int32(skip) * s.StepTime
+		}
+		res[i] = s
+	}
+	return res
+}
+
+// transformTimeShift moves series that was fetched offs seconds away back to the requested time range
+func transformTimeShift(s protov2.FetchResponse, offs int32) protov2.FetchResponse {
+	s.StartTime -= offs
+	s.StopTime -= offs
+	s.Name = fmt.Sprintf("timeShift(%s,'%d')", s.Name, offs)
+	return s
+}
+
+// transformTimeSlice makes points outside of [start, end] absent
+func transformTimeSlice(s protov2.FetchResponse, start, end int32) protov2.FetchResponse {
+	values := make([]float64, len(s.Values))
+	absent := make([]bool, len(s.Values))
+	for i, v := range s.Values {
+		ts := s.StartTime + int32(i)*s.StepTime
+		if ts < start || ts > end || s.IsAbsent[i] {
+			absent[i] = true
+			continue
+		}
+		values[i] = v
+	}
+	s.Name = fmt.Sprintf("timeSlice(%s,%d,%d)", s.Name, start, end)
+	s.Values = values
+	s.IsAbsent = absent
+	return s
+}
+
+// alignSeries moves every series to the grid that starts at base: StartTime is rounded down to the closest
+// base+k*StepTime, points keep their order. If interval is set and it's a multiple of StepTime, series are padded
+// with absent points in front, so they start at base+k*interval. Original series are not modified.
+func alignSeries(series []protov2.FetchResponse, base, interval int32) []protov2.FetchResponse {
+	res := make([]protov2.FetchResponse, len(series))
+	for i, s := range series {
+		if s.StepTime <= 0 {
+			res[i] = s
+			continue
+		}
+		shift := mod32(s.StartTime-base, s.StepTime)
+		var pad int32
+		if interval >= s.StepTime && interval%s.StepTime == 0 {
+			pad = mod32(s.StartTime-shift-base, interval) / s.StepTime
+		}
+
+		values := make([]float64, int(pad), int(pad)+len(s.Values))
+		absent := make([]bool, int(pad), int(pad)+len(s.IsAbsent))
+		for j := range absent {
+			absent[j] = true
+		}
+		s.Values = append(values, s.Values...)
+		s.IsAbsent = append(absent, s.IsAbsent...)
+		s.StartTime -= shift + pad*s.StepTime
+		s.StopTime -= shift
+		res[i] = s
+	}
+	return res
+}
+
+// mod32 returns non-negative remainder of a divided by b
+func mod32(a, b int32) int32 {
+	return ((a % b) + b) % b
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	protov2 "github.com/go-graphite/protocol/carbonapi_v2_pb"
+)
+
+func testSeries(name string, values []float64, absent []bool) protov2.FetchResponse {
+	return protov2.FetchResponse{
+		Name:      name,
+		StartTime: 60,
+		StopTime:  60 + 60*int32(len(values)),
+		StepTime:  60,
+		Values:    values,
+		IsAbsent:  absent,
+	}
+}
+
+func TestParseRenderTarget(t *testing.T) {
+	tests := []struct {
+		target     string
+		raw        bool
+		metrics    []string
+		agg        bool
+		transforms int
+	}{
+		{"host.*.cpu", true, []string{"host.*.cpu"}, false, 0},
+		{"sumSeries(host.*.cpu)", false, []string{"host.*.cpu"}, true, 0},
+		{`alias(scale(host.*.cpu,8),"bits")`, false, []string{"host.*.cpu"}, false, 2},
+		{"perSecond(sumSeries(a.b, c.d))", false, []string{"a.b", "c.d"}, true, 1},
+		{"aliasByNode(host.*.cpu,1,2)", false, []string{"host.*.cpu"}, false, 1},
+		{"scale(movingAverage(host.*.cpu,10),2)", true, []string{"scale(movingAverage(host.*.cpu,10),2)"}, false, 0},
+		{"scale(host.*.cpu)", true, []string{"scale(host.*.cpu)"}, false, 0},
+		{`timeShift(sumSeries(host.*.cpu),"1d")`, false, []string{"host.*.cpu"}, true, 1},
+		{`timeSlice(host.*.cpu,"-1h")`, false, []string{"host.*.cpu"}, false, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			r := parseRenderTarget(tt.target)
+			if r.isRaw() != tt.raw {
+				t.Fatalf("unexpected raw flag, got %v, expected %v", r.isRaw(), tt.raw)
+			}
+			if !reflect.DeepEqual(r.metrics, tt.metrics) {
+				t.Fatalf("unexpected metrics, got %v, expected %v", r.metrics, tt.metrics)
+			}
+			if (r.agg != nil) != tt.agg || len(r.transforms) != tt.transforms {
+				t.Fatalf("unexpected pipeline, got agg=%v transforms=%v, expected agg=%v transforms=%v", r.agg != nil, len(r.transforms), tt.agg, tt.transforms)
+			}
+		})
+	}
+}
+
+func TestRenderTargetApply(t *testing.T) {
+	r := parseRenderTarget(`alias(scale(sumSeries(foo.*),2),"total")`)
+	res := r.apply([]protov2.FetchResponse{
+		testSeries("foo.a", []float64{1, 2}, []bool{false, false}),
+		testSeries("foo.b", []float64{3, 4}, []bool{false, false}),
+	})
+
+	if len(res) != 1 || res[0].Name != "total" || !reflect.DeepEqual(res[0].Values, []float64{8, 12}) {
+		t.Fatalf("unexpected result: %+v", res)
+	}
+}
+
+func TestRenderTargetShift(t *testing.T) {
+	r := parseRenderTarget(`timeShift(timeShift(foo,"1h"),"+1d")`)
+	if r.shift != -3600+86400 {
+		t.Fatalf("unexpected shift, got %v, expected %v", r.shift, -3600+86400)
+	}
+
+	r = parseRenderTarget(`alias(timeShift(foo,"7d"),"last week")`)
+	res := r.apply([]protov2.FetchResponse{testSeries("foo", []float64{1, 2}, []bool{false, false})})
+	if res[0].StartTime != 60+7*86400 || res[0].StopTime != 180+7*86400 || res[0].Name != "last week" {
+		t.Fatalf("unexpected result: %+v", res[0])
+	}
+}
+
+func TestTransformTimeSlice(t *testing.T) {
+	s := testSeries("foo", []float64{1, 2, 3, 4}, []bool{false, true, false, false})
+	r := transformTimeSlice(s, 100, 200)
+	if r.Name != "timeSlice(foo,100,200)" ||
+		!reflect.DeepEqual(r.Values, []float64{0, 0, 3, 0}) ||
+		!reflect.DeepEqual(r.IsAbsent, []bool{true, true, false, true}) {
+		t.Fatalf("unexpected result: %+v", r)
+	}
+}
+
+func TestRemoveEmptySeries(t *testing.T) {
+	series := []protov2.FetchResponse{
+		testSeries("empty", []float64{0, 0}, []bool{true, true}),
+		testSeries("sparse", []float64{0, 1}, []bool{true, false}),
+		testSeries("nothing", nil, nil),
+	}
+	res := removeEmptySeries(series)
+	if len(res) != 1 || res[0].Name != "sparse" {
+		t.Fatalf("unexpected result: %+v", res)
+	}
+	if series[0].Name != "empty" {
+		t.Fatal("original slice was modified")
+	}
+}
+
+func TestFillAbsent(t *testing.T) {
+	series := []protov2.FetchResponse{testSeries("foo", []float64{1, 0, 3}, []bool{false, true, false})}
+	res := fillAbsent(series, -1)
+	if !reflect.DeepEqual(res[0].Values, []float64{1, -1, 3}) || !reflect.DeepEqual(res[0].IsAbsent, []bool{false, false, false}) {
+		t.Fatalf("unexpected result: %+v", res[0])
+	}
+	if !series[0].IsAbsent[1] || series[0].Values[1] != 0 {
+		t.Fatal("original series was modified")
+	}
+}
+
+func TestTrimToWatermark(t *testing.T) {
+	series := []protov2.FetchResponse{
+		testSeries("foo", []float64{1, 2, 3}, []bool{false, false, false}),
+		testSeries("bar", []float64{1}, []bool{false}),
+	}
+	res := trimToWatermark(series, 125)
+	if res[0].StartTime != 180 || !reflect.DeepEqual(res[0].Values, []float64{3}) || !reflect.DeepEqual(res[0].IsAbsent, []bool{false}) {
+		t.Fatalf("unexpected result: %+v", res[0])
+	}
+	if res[1].Name != "bar" || len(res[1].Values) != 0 || len(res[1].IsAbsent) != 0 {
+		t.Fatalf("series without new points should be empty: %+v", res[1])
+	}
+	if series[0].StartTime != 60 || len(series[0].Values) != 3 {
+		t.Fatal("original series was modified")
+	}
+
+	res = trimToWatermark(series, 59)
+	if !reflect.DeepEqual(res, series) {
+		t.Fatalf("series newer than watermark shouldn't change: %+v", res)
+	}
+}
+
+func TestTransformAliasByNode(t *testing.T) {
+	tests := []struct {
+		name     string
+		nodes    []int
+		expected string
+	}{
+		{"host.web01.cpu", []int{1}, "web01"},
+		{"host.web01.cpu", []int{0, -1}, "host.cpu"},
+		{"sumSeries(host.web01.cpu)", []int{1}, "web01"},
+		{"host.web01.cpu", []int{5}, ""},
+	}
+
+	for _, tt := range tests {
+		r := transformAliasByNode(testSeries(tt.name, nil, nil), tt.nodes)
+		if r.Name != tt.expected {
+			t.Errorf("aliasByNode(%v, %v): got %q, expected %q", tt.name, tt.nodes, r.Name, tt.expected)
+		}
+	}
+}
+
+func TestTransformScale(t *testing.T) {
+	s := testSeries("foo", []float64{1, 0, 3}, []bool{false, true, false})
+	r := transformScale(s, 2.5)
+	if r.Name != "scale(foo,2.5)" || !reflect.DeepEqual(r.Values, []float64{2.5, 0, 7.5}) || !reflect.DeepEqual(r.IsAbsent, s.IsAbsent) {
+		t.Fatalf("unexpected result: %+v", r)
+	}
+	if s.Values[0] != 1 {
+		t.Fatal("original series was modified")
+	}
+}
+
+func TestTransformDerivatives(t *testing.T) {
+	s := testSeries("foo", []float64{1, 3, 2, 0, 6, 12}, []bool{false, false, false, true, false, false})
+
+	tests := []struct {
+		name   string
+		f      seriesTransform
+		values []float64
+		absent []bool
+	}{
+		{"derivative(foo)", transformDerivative, []float64{0, 2, -1, 0, 0, 6}, []bool{true, false, false, true, true, false}},
+		{"nonNegativeDerivative(foo)", transformNonNegativeDerivative, []float64{0, 2, 0, 0, 0, 6}, []bool{true, false, true, true, true, false}},
+		{"perSecond(foo)", transformPerSecond, []float64{0, 2.0 / 60, 0, 0, 0, 6.0 / 60}, []bool{true, false, true, true, true, false}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := tt.f(s)
+			if r.Name != tt.name {
+				t.Fatalf("unexpected name, got %v, expected %v", r.Name, tt.name)
+			}
+			if !reflect.DeepEqual(r.Values, tt.values) || !reflect.DeepEqual(r.IsAbsent, tt.absent) {
+				t.Fatalf("unexpected values, got %v %v, expected %v %v", r.Values, r.IsAbsent, tt.values, tt.absent)
+			}
+		})
+	}
+}
+
+func TestAlignSeries(t *testing.T) {
+	series := []protov2.FetchResponse{testSeries("foo", []float64{1, 2}, []bool{false, false})}
+	series[0].StartTime, series[0].StopTime = 130, 250
+
+	tests := []struct {
+		name           string
+		base, interval int32
+		start, stop    int32
+		values         []float64
+		absent         []bool
+	}{
+		{"alignToFrom", 100, 0, 100, 220, []float64{1, 2}, []bool{false, false}},
+		{"alignTo", 0, 300, 0, 240, []float64{0, 0, 1, 2}, []bool{true, true, false, false}},
+		{"both", 10, 180, 10, 250, []float64{0, 0, 1, 2}, []bool{true, true, false, false}},
+		{"interval isn't multiple of step", 0, 90, 120, 240, []float64{1, 2}, []bool{false, false}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := alignSeries(series, tt.base, tt.interval)[0]
+			if r.StartTime != tt.start || r.StopTime != tt.stop || !reflect.DeepEqual(r.Values, tt.values) || !reflect.DeepEqual(r.IsAbsent, tt.absent) {
+				t.Fatalf("unexpected result: %+v", r)
+			}
+		})
+	}
+	if series[0].StartTime != 130 || len(series[0].Values) != 2 {
+		t.Fatal("original series was modified")
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-graphite/carbonapi/pkg/parser"
+	util "github.com/go-graphite/carbonapi/util/ctx"
+	"github.com/go-graphite/carbonapi/util/instance"
+	"github.com/satori/go.uuid"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultTypeaheadLimit = 10
+	maxTypeaheadLimit     = 100
+)
+
+// TypeaheadConfig enables /metrics/typeahead/ endpoint. Names of all the metrics are listed on the backends every
+// RefreshInterval and kept in memory.
+type TypeaheadConfig struct {
+	RefreshInterval time.Duration `mapstructure:"refreshInterval"`
+}
+
+// typeaheadSuggestion is the next node of the path that matches the query
+type typeaheadSuggestion struct {
+	Path   string `json:"path"`
+	IsLeaf bool   `json:"isLeaf"`
+	Score  int64  `json:"score"`
+}
+
+// typeaheadIndex suggests how partial metric path can be completed. Suggestions are ranked by how often the path
+// was queried: every render target and find query bumps the score of all its nodes before the first glob. Scores
+// are halved on every refresh, so recent queries matter more.
+type typeaheadIndex struct {
+	sync.RWMutex
+	names  []string
+	scores map[string]int64
+}
+
+func newTypeaheadIndex(config TypeaheadConfig) *typeaheadIndex {
+	if config.RefreshInterval <= 0 {
+		return nil
+	}
+	return &typeaheadIndex{scores: make(map[string]int64)}
+}
+
+// update replaces the list of known metrics
+func (t *typeaheadIndex) update(names []string) {
+	sorted := make([]string, len(names))
+	copy(sorted, names)
+	sort.Strings(sorted)
+
+	t.Lock()
+	t.names = sorted
+	for path, score := range t.scores {
+		if score /= 2; score == 0 {
+			delete(t.scores, path)
+		} else {
+			t.scores[path] = score
+		}
+	}
+	t.Unlock()
+}
+
+// record bumps scores of the queried paths, queries are either metric names or globs
+func (t *typeaheadIndex) record(queries ...string) {
+	if t == nil {
+		return
+	}
+	t.Lock()
+	defer t.Unlock()
+	for _, q := range queries {
+		nodes := strings.Split(q, ".")
+		for i, node := range nodes {
+			if node == "" || strings.ContainsAny(node, "*?[{") {
+				break
+			}
+			t.scores[strings.Join(nodes[:i+1], ".")]++
+		}
+	}
+}
+
+// recordTargets bumps scores of the metrics used by render targets
+func (t *typeaheadIndex) recordTargets(targets []string) {
+	if t == nil {
+		return
+	}
+	for _, target := range targets {
+		e, _, err := parser.ParseExpr(target)
+		if err != nil {
+			continue
+		}
+		for _, m := range e.Metrics() {
+			t.record(m.Metric)
+		}
+	}
+}
+
+// suggest returns at most limit next nodes of the known metrics that start with query, best ranked first
+func (t *typeaheadIndex) suggest(query string, limit int) []typeaheadSuggestion {
+	t.RLock()
+	defer t.RUnlock()
+
+	res := make([]typeaheadSuggestion, 0)
+	seen := make(map[string]int)
+	for i := sort.SearchStrings(t.names, query); i < len(t.names) && strings.HasPrefix(t.names[i], query); i++ {
+		name := t.names[i]
+		path, isLeaf := name, true
+		if j := strings.IndexByte(name[len(query):], '.'); j != -1 {
+			path, isLeaf = name[:len(query)+j], false
+		}
+		if k, ok := seen[path]; ok {
+			// path can be both a leaf and a branch
+			res[k].IsLeaf = res[k].IsLeaf || isLeaf
+			continue
+		}
+		seen[path] = len(res)
+		res = append(res, typeaheadSuggestion{Path: path, IsLeaf: isLeaf, Score: t.scores[path]})
+	}
+
+	sort.SliceStable(res, func(i, j int) bool { return res[i].Score > res[j].Score })
+	if len(res) > limit {
+		res = res[:limit]
+	}
+	return res
+}
+
+func (t *typeaheadIndex) refresh(timeout time.Duration) {
+	logger := instance.Logger("typeahead")
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	ctx = util.SetUUID(ctx, uuid.NewV4().String())
+
+	t0 := time.Now()
+	res, stats, err := getZipper().ListProtoV2(ctx)
+	sendStats(stats)
+	if err != nil {
+		logger.Warn("failed to list metrics", zap.Error(err))
+		return
+	}
+
+	t.update(res.Metrics)
+	logger.Info("typeahead index updated",
+		zap.Int("metrics", len(res.Metrics)),
+		zap.Duration("runtime_seconds", time.Since(t0)),
+	)
+}
+
+// run periodically lists all metrics on the backends and updates the index
+func (t *typeaheadIndex) run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		t.refresh(interval)
+		<-ticker.C
+	}
+}
+
+// handler returns ranked suggestions for the partial metric path in query parameter
+func (t *typeaheadIndex) handler(w http.ResponseWriter, req *http.Request) {
+	if t == nil {
+		http.Error(w, "typeahead is disabled", http.StatusNotFound)
+		return
+	}
+
+	limit := defaultTypeaheadLimit
+	if v := req.FormValue("limit"); v != "" {
+		var err error
+		limit, err = strconv.Atoi(v)
+		if err != nil || limit <= 0 || limit > maxTypeaheadLimit {
+			http.Error(w, "limit must be between 1 and "+strconv.Itoa(maxTypeaheadLimit), http.StatusBadRequest)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", contentTypeJSON)
+	/* #nosec */
+	_ = json.NewEncoder(w).Encode(t.suggest(req.FormValue("query"), limit))
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestTypeaheadSuggest(t *testing.T) {
+	index := newTypeaheadIndex(TypeaheadConfig{RefreshInterval: time.Minute})
+	index.update([]string{
+		"servers.web01.cpu",
+		"servers.web02.cpu",
+		"servers.web02.mem",
+		"servers.db01.cpu",
+		"servers.web",
+		"servers.web.count",
+		"services.api.requests",
+	})
+	index.record("servers.web02.*", "servers.db01.cpu")
+	index.recordTargets([]string{"sumSeries(servers.web02.cpu)", "movingAverage(servers.db01.*,5)"})
+
+	tests := []struct {
+		query    string
+		limit    int
+		expected []typeaheadSuggestion
+	}{
+		{"servers.", 10, []typeaheadSuggestion{
+			{Path: "servers.db01", Score: 2},
+			{Path: "servers.web02", Score: 2},
+			{Path: "servers.web", IsLeaf: true},
+			{Path: "servers.web01"},
+		}},
+		{"servers.web", 2, []typeaheadSuggestion{
+			{Path: "servers.web02", Score: 2},
+			{Path: "servers.web", IsLeaf: true},
+		}},
+		{"serv", 10, []typeaheadSuggestion{
+			{Path: "servers", Score: 4},
+			{Path: "services"},
+		}},
+		{"servers.web02.", 10, []typeaheadSuggestion{
+			{Path: "servers.web02.cpu", IsLeaf: true, Score: 1},
+			{Path: "servers.web02.mem", IsLeaf: true},
+		}},
+		{"nothing", 10, []typeaheadSuggestion{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			res := index.suggest(tt.query, tt.limit)
+			if !reflect.DeepEqual(res, tt.expected) {
+				t.Fatalf("unexpected suggestions %+v, expected %+v", res, tt.expected)
+			}
+		})
+	}
+
+	// scores decay when index is refreshed
+	index.update([]string{"servers.db01.cpu"})
+	res := index.suggest("servers.", 10)
+	if len(res) != 1 || res[0].Score != 1 {
+		t.Fatalf("unexpected suggestions after refresh %+v", res)
+	}
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/go-graphite/carbonapi/date"
+	util "github.com/go-graphite/carbonapi/util/ctx"
+	protov2 "github.com/go-graphite/protocol/carbonapi_v2_pb"
+	"github.com/satori/go.uuid"
+)
+
+// VerifyConfig describes queries that are checked by -verify. Results are compared with golden responses stored
+// in GoldenDir as <name>.json, values may differ by Tolerance (relative) unless query sets its own.
+type VerifyConfig struct {
+	GoldenDir string        `mapstructure:"goldenDir"`
+	Tolerance float64       `mapstructure:"tolerance"`
+	Timeout   time.Duration `mapstructure:"timeout"`
+	Queries   []VerifyQuery `mapstructure:"queries"`
+}
+
+// VerifyQuery is a render request with fixed time range, so its result doesn't change between runs
+type VerifyQuery struct {
+	Name      string   `mapstructure:"name"`
+	Targets   []string `mapstructure:"targets"`
+	From      string   `mapstructure:"from"`
+	Until     string   `mapstructure:"until"`
+	Tolerance *float64 `mapstructure:"tolerance"`
+}
+
+// goldenSeries is the stored series, absent points are nulls
+type goldenSeries struct {
+	Name   string     `json:"name"`
+	Start  int32      `json:"start"`
+	Step   int32      `json:"step"`
+	Values []*float64 `json:"values"`
+}
+
+func toGolden(series []protov2.FetchResponse) []goldenSeries {
+	res := make([]goldenSeries, 0, len(series))
+	for _, s := range series {
+		g := goldenSeries{Name: s.Name, Start: s.StartTime, Step: s.StepTime, Values: make([]*float64, len(s.Values))}
+		for i := range s.Values {
+			if !s.IsAbsent[i] {
+				v := s.Values[i]
+				g.Values[i] = &v
+			}
+		}
+		res = append(res, g)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Name < res[j].Name })
+	return res
+}
+
+// withinTolerance returns true if relative difference of a and b doesn't exceed tolerance
+func withinTolerance(a, b, tolerance float64) bool {
+	if a == b {
+		return true
+	}
+	return math.Abs(a-b) <= tolerance*math.Max(math.Abs(a), math.Abs(b))
+}
+
+// compareGolden returns human readable list of differences between expected and actual results
+func compareGolden(expected, actual []goldenSeries, tolerance float64) []string {
+	var diffs []string
+	actualByName := make(map[string]goldenSeries, len(actual))
+	for _, s := range actual {
+		actualByName[s.Name] = s
+	}
+
+	for _, e := range expected {
+		a, ok := actualByName[e.Name]
+		if !ok {
+			diffs = append(diffs, fmt.Sprintf("%s: series is missing", e.Name))
+			continue
+		}
+		delete(actualByName, e.Name)
+
+		if a.Start != e.Start || a.Step != e.Step || len(a.Values) != len(e.Values) {
+			diffs = append(diffs, fmt.Sprintf("%s: expected start=%d step=%d points=%d, got start=%d step=%d points=%d",
+				e.Name, e.Start, e.Step, len(e.Values), a.Start, a.Step, len(a.Values)))
+			continue
+		}
+
+		drift := 0
+		first := -1
+		for i := range e.Values {
+			ev, av := e.Values[i], a.Values[i]
+			if (ev == nil) != (av == nil) || (ev != nil && !withinTolerance(*ev, *av, tolerance)) {
+				drift++
+				if first == -1 {
+					first = i
+				}
+			}
+		}
+		if drift > 0 {
+			diffs = append(diffs, fmt.Sprintf("%s: %d points differ, first at %d: expected %s, got %s",
+				e.Name, drift, e.Start+int32(first)*e.Step, formatGoldenValue(e.Values[first]), formatGoldenValue(a.Values[first])))
+		}
+	}
+
+	for name := range actualByName {
+		diffs = append(diffs, fmt.Sprintf("%s: unexpected series", name))
+	}
+	sort.Strings(diffs)
+	return diffs
+}
+
+func formatGoldenValue(v *float64) string {
+	if v == nil {
+		return "null"
+	}
+	return fmt.Sprintf("%g", *v)
+}
+
+func (q VerifyQuery) fetch(timeout time.Duration) ([]goldenSeries, error) {
+	from, err := date.ParseDateParam(q.From, "", 0, time.Local)
+	if err != nil {
+		return nil, fmt.Errorf("from: %v", err)
+	}
+	until, err := date.ParseDateParam(q.Until, "", 0, time.Local)
+	if err != nil {
+		return nil, fmt.Errorf("until: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	ctx = util.SetUUID(ctx, uuid.NewV4().String())
+
+	res, err := fetchRenderTargets(ctx, q.Targets, int32(from), int32(until))
+	if err != nil {
+		return nil, err
+	}
+	return toGolden(res.Metrics), nil
+}
+
+// runVerify fetches every configured query and compares results with golden responses, or stores them if update
+// is set. Report is written to out, returned value is the exit code.
+func runVerify(c VerifyConfig, update bool, out io.Writer) int {
+	if c.Timeout <= 0 {
+		c.Timeout = time.Minute
+	}
+
+	failed := 0
+	for _, q := range c.Queries {
+		path := filepath.Join(c.GoldenDir, q.Name+".json")
+		actual, err := q.fetch(c.Timeout)
+		if err != nil {
+			fmt.Fprintf(out, "FAIL %s: %v\n", q.Name, err)
+			failed++
+			continue
+		}
+
+		if update {
+			b, _ := json.MarshalIndent(actual, "", "  ")
+			if err := ioutil.WriteFile(path, b, 0644); err != nil {
+				fmt.Fprintf(out, "FAIL %s: %v\n", q.Name, err)
+				failed++
+				continue
+			}
+			fmt.Fprintf(out, "UPDATED %s\n", q.Name)
+			continue
+		}
+
+		var expected []goldenSeries
+		b, err := ioutil.ReadFile(path)
+		if err == nil {
+			err = json.Unmarshal(b, &expected)
+		}
+		if err != nil {
+			fmt.Fprintf(out, "FAIL %s: can't read golden response: %v\n", q.Name, err)
+			failed++
+			continue
+		}
+
+		tolerance := c.Tolerance
+		if q.Tolerance != nil {
+			tolerance = *q.Tolerance
+		}
+		diffs := compareGolden(expected, actual, tolerance)
+		if len(diffs) == 0 {
+			fmt.Fprintf(out, "OK %s\n", q.Name)
+			continue
+		}
+		failed++
+		fmt.Fprintf(out, "DRIFT %s\n", q.Name)
+		for _, d := range diffs {
+			fmt.Fprintf(out, "    %s\n", d)
+		}
+	}
+
+	fmt.Fprintf(out, "%d of %d queries failed\n", failed, len(c.Queries))
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+// verifyMain runs -verify mode and exits
+func verifyMain(c VerifyConfig, update bool) {
+	os.Exit(runVerify(c, update, os.Stdout))
+}
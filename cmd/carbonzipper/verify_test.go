@@ -0,0 +1,54 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	protov2 "github.com/go-graphite/protocol/carbonapi_v2_pb"
+)
+
+func TestCompareGolden(t *testing.T) {
+	expected := toGolden([]protov2.FetchResponse{
+		testSeries("foo.b", []float64{100, 0, 3}, []bool{false, true, false}),
+		testSeries("foo.a", []float64{1, 2}, []bool{false, false}),
+		testSeries("foo.c", []float64{1}, []bool{false}),
+	})
+
+	// golden responses must survive the round trip through the file
+	b, err := json.Marshal(expected)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stored []goldenSeries
+	if err := json.Unmarshal(b, &stored); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(stored, expected) {
+		t.Fatalf("golden response changed after round trip: %s", b)
+	}
+
+	same := toGolden([]protov2.FetchResponse{
+		testSeries("foo.a", []float64{1, 2}, []bool{false, false}),
+		testSeries("foo.b", []float64{100.001, 0, 3}, []bool{false, true, false}),
+		testSeries("foo.c", []float64{1}, []bool{false}),
+	})
+	if diffs := compareGolden(stored, same, 0.0001); len(diffs) != 0 {
+		t.Fatalf("unexpected drift within tolerance: %v", diffs)
+	}
+
+	drifted := toGolden([]protov2.FetchResponse{
+		testSeries("foo.a", []float64{1, 2, 3}, []bool{false, false, false}),
+		testSeries("foo.b", []float64{101, 5, 3}, []bool{false, false, false}),
+		testSeries("foo.d", []float64{1}, []bool{false}),
+	})
+	want := []string{
+		"foo.a: expected start=60 step=60 points=2, got start=60 step=60 points=3",
+		"foo.b: 2 points differ, first at 60: expected 100, got 101",
+		"foo.c: series is missing",
+		"foo.d: unexpected series",
+	}
+	if diffs := compareGolden(stored, drifted, 0.0001); !reflect.DeepEqual(diffs, want) {
+		t.Fatalf("unexpected drift report:\n%v\nexpected:\n%v", diffs, want)
+	}
+}
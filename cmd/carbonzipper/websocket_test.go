@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestWebsocketAccept(t *testing.T) {
+	// Example from RFC 6455, section 1.3
+	if got := websocketAccept("dGhlIHNhbXBsZSBub25jZQ=="); got != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf("unexpected accept key %v", got)
+	}
+}
+
+func TestWebsocketFrames(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	c := &wsConn{conn: server, rw: bufio.NewReadWriter(bufio.NewReader(server), bufio.NewWriter(server))}
+	defer c.Close()
+
+	payload := []byte(`{"subscribe":["foo.bar"]}`)
+	mask := []byte{1, 2, 3, 4}
+	frame := []byte{0x80 | wsOpText, 0x80 | byte(len(payload))}
+	frame = append(frame, mask...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	go func() { _, _ = client.Write(frame) }()
+
+	opcode, got, err := c.readFrame()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opcode != wsOpText || !bytes.Equal(got, payload) {
+		t.Fatalf("unexpected frame, got %v %q, expected %v %q", opcode, got, wsOpText, payload)
+	}
+
+	long := bytes.Repeat([]byte("a"), 300)
+	go func() { _ = c.writeFrame(wsOpText, long) }()
+	header := make([]byte, 4)
+	if _, err := client.Read(header); err != nil {
+		t.Fatal(err)
+	}
+	if header[0] != 0x80|wsOpText || header[1] != 126 || int(header[2])<<8|int(header[3]) != len(long) {
+		t.Fatalf("unexpected frame header %v", header)
+	}
+}
+
+func TestWebsocketUnmaskedFrame(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	c := &wsConn{conn: server, rw: bufio.NewReadWriter(bufio.NewReader(server), bufio.NewWriter(server))}
+	defer c.Close()
+
+	go func() { _, _ = client.Write([]byte{0x80 | wsOpText, 1, 'a'}) }()
+	if _, _, err := c.readFrame(); err != errWebsocketNotMasked {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
+
+func TestSubscriptionOrigin(t *testing.T) {
+	h := newSubscriptionHub(time.Second, []string{"https://*.example.com"}, 0)
+
+	tests := []struct {
+		origin  string
+		allowed bool
+	}{
+		{"", true},
+		{"http://zipper.local", true},
+		{"https://grafana.example.com", true},
+		{"http://grafana.example.com", false},
+		{"https://evil.com", false},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "http://zipper.local/subscribe", nil)
+		if tt.origin != "" {
+			req.Header.Set("Origin", tt.origin)
+		}
+		if got := h.allowedOrigin(req); got != tt.allowed {
+			t.Errorf("origin %q: got %v, expected %v", tt.origin, got, tt.allowed)
+		}
+	}
+}
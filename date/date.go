@@ -40,43 +40,86 @@ func parseTime(s string) (hour, minute int, err error) {
 		return 0, 0, errBadTime
 	}
 
+	if hour < 0 || hour > 23 || minute < 0 || minute > 59 {
+		return 0, 0, errBadTime
+	}
+
 	return hour, minute, nil
 }
 
 var TimeFormats = []string{"20060102", "01/02/06"}
 
+// ErrBadDate is returned by ParseDateParam if date can't be parsed
+var ErrBadDate = errors.New("bad date")
+
+// ErrUnknownTimeZone is returned by ParseDateParam if timezone is not known
+var ErrUnknownTimeZone = errors.New("unknown timezone")
+
 // DateParamToEpoch turns a passed string parameter into a unix epoch
 func DateParamToEpoch(s string, qtz string, d int64, defaultTimeZone *time.Location) int64 {
+	t, err := parseDateParam(s, qtz, d, defaultTimeZone, false)
+	if err != nil {
+		return d
+	}
+	return t
+}
+
+// ParseDateParam is a strict version of DateParamToEpoch: it returns an error if s or qtz is malformed
+// instead of falling back to the default value.
+func ParseDateParam(s string, qtz string, d int64, defaultTimeZone *time.Location) (int64, error) {
+	return parseDateParam(s, qtz, d, defaultTimeZone, true)
+}
+
+func parseDateParam(s string, qtz string, d int64, defaultTimeZone *time.Location, strict bool) (int64, error) {
 
 	if s == "" {
 		// return the default if nothing was passed
-		return d
+		return d, nil
 	}
 
-	// relative timestamp
-	if s[0] == '-' {
+	// relative timestamp, now-1h is the same as -1h
+	relative := s[0] == '-'
+	if strings.HasPrefix(s, "now") && len(s) > 3 && (s[3] == '-' || s[3] == '+') {
+		s = s[3:]
+		relative = true
+	}
+	if relative {
+		if len(s) == 1 {
+			return d, ErrBadDate
+		}
 		offset, err := parser.IntervalString(s, -1)
 		if err != nil {
-			return d
+			return d, ErrBadDate
 		}
 
-		return timeNow().Add(time.Duration(offset) * time.Second).Unix()
+		return timeNow().Add(time.Duration(offset) * time.Second).Unix(), nil
+	}
+
+	// day boundaries depend on the timezone of the user
+	var tz = defaultTimeZone
+	if qtz != "" {
+		z, err := time.LoadLocation(qtz)
+		if err == nil {
+			tz = z
+		} else if strict {
+			return d, ErrUnknownTimeZone
+		}
 	}
 
 	switch s {
 	case "now":
-		return timeNow().Unix()
+		return timeNow().Unix(), nil
 	case "midnight", "noon", "teatime":
-		yy, mm, dd := timeNow().Date()
+		yy, mm, dd := timeNow().In(tz).Date()
 		hh, min, _ := parseTime(s) // error ignored, we know it's valid
-		dt := time.Date(yy, mm, dd, hh, min, 0, 0, defaultTimeZone)
-		return dt.Unix()
+		dt := time.Date(yy, mm, dd, hh, min, 0, 0, tz)
+		return dt.Unix(), nil
 	}
 
 	sint, err := strconv.Atoi(s)
 	// need to check that len(s) > 8 to avoid turning 20060102 into seconds
 	if err == nil && len(s) > 8 {
-		return int64(sint) // We got a timestamp so returning it
+		return int64(sint), nil // We got a timestamp so returning it
 	}
 
 	s = strings.Replace(s, "_", " ", 1) // Go can't parse _ in date strings
@@ -90,26 +133,19 @@ func DateParamToEpoch(s string, qtz string, d int64, defaultTimeZone *time.Locat
 	case len(split) == 2:
 		ts, ds = split[0], split[1]
 	case len(split) > 2:
-		return d
-	}
-
-	var tz = defaultTimeZone
-	if qtz != "" {
-		if z, err := time.LoadLocation(qtz); err != nil {
-			tz = z
-		}
+		return d, ErrBadDate
 	}
 
 	var t time.Time
 dateStringSwitch:
 	switch ds {
 	case "today":
-		t = timeNow()
+		t = timeNow().In(tz)
 		// nothing
 	case "yesterday":
-		t = timeNow().AddDate(0, 0, -1)
+		t = timeNow().In(tz).AddDate(0, 0, -1)
 	case "tomorrow":
-		t = timeNow().AddDate(0, 0, 1)
+		t = timeNow().In(tz).AddDate(0, 0, 1)
 	default:
 		for _, format := range TimeFormats {
 			t, err = time.ParseInLocation(format, ds, tz)
@@ -117,18 +153,20 @@ dateStringSwitch:
 				break dateStringSwitch
 			}
 		}
-
-		return d
+		return d, ErrBadDate
 	}
 
 	var hour, minute int
 	if ts != "" {
-		hour, minute, _ = parseTime(ts)
+		hour, minute, err = parseTime(ts)
 		// defaults to hour=0, minute=0 on error, which is midnight, which is fine for now
+		if err != nil && strict {
+			return d, ErrBadDate
+		}
 	}
 
 	yy, mm, dd := t.Date()
-	t = time.Date(yy, mm, dd, hour, minute, 0, 0, defaultTimeZone)
+	t = time.Date(yy, mm, dd, hour, minute, 0, 0, tz)
 
-	return t.Unix()
+	return t.Unix(), nil
 }
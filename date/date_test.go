@@ -47,3 +47,72 @@ func TestDateParamToEpoch(t *testing.T) {
 		}
 	}
 }
+
+func TestDateParamToEpochTimezone(t *testing.T) {
+	timeNow = func() time.Time {
+		// 16 Aug 1994 02:30 UTC, it's still 15 Aug in New York
+		return time.Date(1994, time.August, 16, 2, 30, 0, 0, time.UTC)
+	}
+	defer func() { timeNow = time.Now }()
+
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("timezone database is not available: %v", err)
+	}
+
+	var tests = []struct {
+		input string
+		tz    string
+		want  time.Time
+	}{
+		{"midnight", "", time.Date(1994, time.August, 16, 0, 0, 0, 0, time.UTC)},
+		{"midnight", "America/New_York", time.Date(1994, time.August, 15, 0, 0, 0, 0, ny)},
+		{"noon yesterday", "America/New_York", time.Date(1994, time.August, 14, 12, 0, 0, 0, ny)},
+		{"19940812", "America/New_York", time.Date(1994, time.August, 12, 0, 0, 0, 0, ny)},
+		{"midnight", "Invalid/Zone", time.Date(1994, time.August, 16, 0, 0, 0, 0, time.UTC)},
+		{"-1d", "America/New_York", time.Date(1994, time.August, 15, 2, 30, 0, 0, time.UTC)},
+	}
+
+	for _, tt := range tests {
+		got := DateParamToEpoch(tt.input, tt.tz, 0, time.UTC)
+		if got != tt.want.Unix() {
+			t.Errorf("DateParamToEpoch(%q, %q)=%v, want %v", tt.input, tt.tz, got, tt.want.Unix())
+		}
+	}
+}
+
+func TestParseDateParam(t *testing.T) {
+	timeNow = func() time.Time {
+		return time.Date(1994, time.August, 16, 15, 30, 0, 0, time.UTC)
+	}
+	defer func() { timeNow = time.Now }()
+
+	now := timeNow().Unix()
+	var tests = []struct {
+		input string
+		tz    string
+		want  int64
+		err   error
+	}{
+		{"", "", 42, nil},
+		{"now", "", now, nil},
+		{"now-1h", "", now - 3600, nil},
+		{"now+1d", "", now + 86400, nil},
+		{"-7d", "", now - 7*86400, nil},
+		{"1234567890", "", 1234567890, nil},
+		{"17:04_19940812", "", time.Date(1994, time.August, 12, 17, 4, 0, 0, time.UTC).Unix(), nil},
+		{"-1fortnight", "", 42, ErrBadDate},
+		{"-", "", 42, ErrBadDate},
+		{"now-", "", 42, ErrBadDate},
+		{"garbage", "", 42, ErrBadDate},
+		{"25:99_19940812", "", 42, ErrBadDate},
+		{"midnight", "Invalid/Zone", 42, ErrUnknownTimeZone},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseDateParam(tt.input, tt.tz, 42, time.UTC)
+		if got != tt.want || err != tt.err {
+			t.Errorf("ParseDateParam(%q, %q)=%v, %v, want %v, %v", tt.input, tt.tz, got, err, tt.want, tt.err)
+		}
+	}
+}
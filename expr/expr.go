@@ -4,6 +4,7 @@ import (
 	// Import all known functions
 	_ "github.com/go-graphite/carbonapi/expr/functions"
 	"github.com/go-graphite/carbonapi/expr/helper"
+	"github.com/go-graphite/carbonapi/expr/interfaces"
 	"github.com/go-graphite/carbonapi/expr/metadata"
 	"github.com/go-graphite/carbonapi/expr/types"
 	"github.com/go-graphite/carbonapi/pkg/parser"
@@ -14,18 +15,22 @@ type evaluator struct{}
 
 // EvalExpr evalualtes expressions
 func (eval evaluator) EvalExpr(e parser.Expr, from, until int64, values map[parser.MetricRequest][]*types.MetricData) ([]*types.MetricData, error) {
-	return EvalExpr(e, from, until, values)
+	return evalExpr(eval, e, from, until, values)
 }
 
 var _evaluator = evaluator{}
 
 func init() {
-	helper.SetEvaluator(_evaluator)
 	metadata.SetEvaluator(_evaluator)
 }
 
 // EvalExpr is the main expression evaluator
 func EvalExpr(e parser.Expr, from, until int64, values map[parser.MetricRequest][]*types.MetricData) ([]*types.MetricData, error) {
+	return _evaluator.EvalExpr(e, from, until, values)
+}
+
+// evalExpr evaluates e, functions evaluate their arguments with eval
+func evalExpr(eval interfaces.Evaluator, e parser.Expr, from, until int64, values map[parser.MetricRequest][]*types.MetricData) ([]*types.MetricData, error) {
 	if e.IsName() {
 		return values[parser.MetricRequest{Metric: e.Target(), From: from, Until: until}], nil
 	} else if e.IsConst() {
@@ -43,7 +48,7 @@ func EvalExpr(e parser.Expr, from, until int64, values map[parser.MetricRequest]
 	f, ok := metadata.FunctionMD.Functions[e.Target()]
 	metadata.FunctionMD.RUnlock()
 	if ok {
-		return f.Do(e, from, until, values)
+		return f.Do(eval, e, from, until, values)
 	}
 
 	return nil, helper.ErrUnknownFunction(e.Target())
@@ -60,7 +65,7 @@ func RewriteExpr(e parser.Expr, from, until int64, values map[parser.MetricReque
 		f, ok := metadata.FunctionMD.RewriteFunctions[e.Target()]
 		metadata.FunctionMD.RUnlock()
 		if ok {
-			return f.Do(e, from, until, values)
+			return f.Do(_evaluator, e, from, until, values)
 		}
 	}
 	return false, nil, nil
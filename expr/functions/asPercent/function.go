@@ -31,8 +31,8 @@ func New(configFile string) []interfaces.FunctionMetadata {
 }
 
 // asPercent(seriesList, total=None, *nodes)
-func (f *asPercent) Do(e parser.Expr, from, until int64, values map[parser.MetricRequest][]*types.MetricData) ([]*types.MetricData, error) {
-	arg, err := helper.GetSeriesArg(e.Args()[0], from, until, values)
+func (f *asPercent) Do(eval interfaces.Evaluator, e parser.Expr, from, until int64, values map[parser.MetricRequest][]*types.MetricData) ([]*types.MetricData, error) {
+	arg, err := helper.GetSeriesArg(eval, e.Args()[0], from, until, values)
 	if err != nil {
 		return nil, err
 	}
@@ -77,7 +77,7 @@ func (f *asPercent) Do(e parser.Expr, from, until int64, values map[parser.Metri
 			return fmt.Sprintf("asPercent(%s,%s)", a, b)
 		}
 	} else if len(e.Args()) == 2 && (e.Args()[1].IsName() || e.Args()[1].IsFunc()) {
-		total, err := helper.GetSeriesArg(e.Args()[1], from, until, values)
+		total, err := helper.GetSeriesArg(eval, e.Args()[1], from, until, values)
 		if err != nil {
 			return nil, err
 		}
@@ -105,7 +105,7 @@ func (f *asPercent) Do(e parser.Expr, from, until int64, values map[parser.Metri
 			return fmt.Sprintf("asPercent(%s,%s)", a, b)
 		}
 	} else if len(e.Args()) >= 3 {
-		total, err := helper.GetSeriesArg(e.Args()[1], from, until, values)
+		total, err := helper.GetSeriesArg(eval, e.Args()[1], from, until, values)
 		if err != nil {
 			return nil, err
 		}
@@ -130,7 +130,7 @@ func (f *asPercent) Do(e parser.Expr, from, until int64, values map[parser.Metri
 				seriesNameExprs[i] = parser.NewTargetExpr(seriesName)
 			}
 
-			result, err := f.Evaluator.EvalExpr(parser.NewExprTyped("sumSeries", seriesNameExprs), from, until, values)
+			result, err := eval.EvalExpr(parser.NewExprTyped("sumSeries", seriesNameExprs), from, until, values)
 
 			if err != nil {
 				return nil, err
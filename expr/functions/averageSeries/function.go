@@ -25,8 +25,8 @@ func New(configFile string) []interfaces.FunctionMetadata {
 }
 
 // averageSeries(*seriesLists)
-func (f *averageSeries) Do(e parser.Expr, from, until int64, values map[parser.MetricRequest][]*types.MetricData) ([]*types.MetricData, error) {
-	args, err := helper.GetSeriesArgsAndRemoveNonExisting(e, from, until, values)
+func (f *averageSeries) Do(eval interfaces.Evaluator, e parser.Expr, from, until int64, values map[parser.MetricRequest][]*types.MetricData) ([]*types.MetricData, error) {
+	args, err := helper.GetSeriesArgsAndRemoveNonExisting(eval, e, from, until, values)
 	if err != nil {
 		return nil, err
 	}
@@ -25,8 +25,8 @@ func New(configFile string) []interfaces.FunctionMetadata {
 	return res
 }
 
-func (f *cairo) Do(e parser.Expr, from, until int64, values map[parser.MetricRequest][]*types.MetricData) ([]*types.MetricData, error) {
-	return png.EvalExprGraph(e, from, until, values)
+func (f *cairo) Do(eval interfaces.Evaluator, e parser.Expr, from, until int64, values map[parser.MetricRequest][]*types.MetricData) ([]*types.MetricData, error) {
+	return png.EvalExprGraph(eval, e, from, until, values)
 }
 
 func (f *cairo) Description() map[string]types.FunctionDescription {
@@ -15,6 +15,7 @@ import (
 	"time"
 
 	"github.com/go-graphite/carbonapi/expr/helper"
+	"github.com/go-graphite/carbonapi/expr/interfaces"
 	"github.com/go-graphite/carbonapi/expr/types"
 	"github.com/go-graphite/carbonapi/pkg/parser"
 	pb "github.com/go-graphite/protocol/carbonapi_v3_pb"
@@ -670,12 +671,12 @@ func Description() map[string]types.FunctionDescription {
 }
 
 // TODO(civil): Split this into several separate functions.
-func EvalExprGraph(e parser.Expr, from, until int64, values map[parser.MetricRequest][]*types.MetricData) ([]*types.MetricData, error) {
+func EvalExprGraph(eval interfaces.Evaluator, e parser.Expr, from, until int64, values map[parser.MetricRequest][]*types.MetricData) ([]*types.MetricData, error) {
 
 	switch e.Target() {
 
 	case "color": // color(seriesList, theColor)
-		arg, err := helper.GetSeriesArg(e.Args()[0], from, until, values)
+		arg, err := helper.GetSeriesArg(eval, e.Args()[0], from, until, values)
 		if err != nil {
 			return nil, err
 		}
@@ -696,7 +697,7 @@ func EvalExprGraph(e parser.Expr, from, until int64, values map[parser.MetricReq
 		return results, nil
 
 	case "stacked": // stacked(seriesList, stackname="__DEFAULT__")
-		arg, err := helper.GetSeriesArg(e.Args()[0], from, until, values)
+		arg, err := helper.GetSeriesArg(eval, e.Args()[0], from, until, values)
 		if err != nil {
 			return nil, err
 		}
@@ -718,7 +719,7 @@ func EvalExprGraph(e parser.Expr, from, until int64, values map[parser.MetricReq
 		return results, nil
 
 	case "areaBetween":
-		arg, err := helper.GetSeriesArg(e.Args()[0], from, until, values)
+		arg, err := helper.GetSeriesArg(eval, e.Args()[0], from, until, values)
 		if err != nil {
 			return nil, err
 		}
@@ -751,7 +752,7 @@ func EvalExprGraph(e parser.Expr, from, until int64, values map[parser.MetricReq
 		return []*types.MetricData{&lower, &upper}, nil
 
 	case "alpha": // alpha(seriesList, theAlpha)
-		arg, err := helper.GetSeriesArg(e.Args()[0], from, until, values)
+		arg, err := helper.GetSeriesArg(eval, e.Args()[0], from, until, values)
 		if err != nil {
 			return nil, err
 		}
@@ -773,7 +774,7 @@ func EvalExprGraph(e parser.Expr, from, until int64, values map[parser.MetricReq
 		return results, nil
 
 	case "dashed", "drawAsInfinite", "secondYAxis":
-		arg, err := helper.GetSeriesArg(e.Args()[0], from, until, values)
+		arg, err := helper.GetSeriesArg(eval, e.Args()[0], from, until, values)
 		if err != nil {
 			return nil, err
 		}
@@ -802,7 +803,7 @@ func EvalExprGraph(e parser.Expr, from, until int64, values map[parser.MetricReq
 		return results, nil
 
 	case "lineWidth": // lineWidth(seriesList, width)
-		arg, err := helper.GetSeriesArg(e.Args()[0], from, until, values)
+		arg, err := helper.GetSeriesArg(eval, e.Args()[0], from, until, values)
 		if err != nil {
 			return nil, err
 		}
@@ -28,8 +28,8 @@ func New(configFile string) []interfaces.FunctionMetadata {
 }
 
 // delay(seriesList, steps)
-func (f *delay) Do(e parser.Expr, from, until int64, values map[parser.MetricRequest][]*types.MetricData) ([]*types.MetricData, error) {
-	seriesList, err := helper.GetSeriesArg(e.Args()[0], from, until, values)
+func (f *delay) Do(eval interfaces.Evaluator, e parser.Expr, from, until int64, values map[parser.MetricRequest][]*types.MetricData) ([]*types.MetricData, error) {
+	seriesList, err := helper.GetSeriesArg(eval, e.Args()[0], from, until, values)
 	if err != nil {
 		return nil, err
 	}
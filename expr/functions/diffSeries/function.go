@@ -29,13 +29,13 @@ func New(configFile string) []interfaces.FunctionMetadata {
 }
 
 // diffSeries(*seriesLists)
-func (f *diffSeries) Do(e parser.Expr, from, until int64, values map[parser.MetricRequest][]*types.MetricData) ([]*types.MetricData, error) {
-	minuends, err := helper.GetSeriesArg(e.Args()[0], from, until, values)
+func (f *diffSeries) Do(eval interfaces.Evaluator, e parser.Expr, from, until int64, values map[parser.MetricRequest][]*types.MetricData) ([]*types.MetricData, error) {
+	minuends, err := helper.GetSeriesArg(eval, e.Args()[0], from, until, values)
 	if err != nil {
 		return nil, err
 	}
 
-	subtrahends, err := helper.GetSeriesArgs(e.Args()[1:], from, until, values)
+	subtrahends, err := helper.GetSeriesArgs(eval, e.Args()[1:], from, until, values)
 	if err != nil {
 		if len(minuends) < 2 {
 			return nil, err
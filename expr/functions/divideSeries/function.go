@@ -30,12 +30,12 @@ func New(configFile string) []interfaces.FunctionMetadata {
 }
 
 // divideSeries(dividendSeriesList, divisorSeriesList)
-func (f *divideSeries) Do(e parser.Expr, from, until int64, values map[parser.MetricRequest][]*types.MetricData) ([]*types.MetricData, error) {
+func (f *divideSeries) Do(eval interfaces.Evaluator, e parser.Expr, from, until int64, values map[parser.MetricRequest][]*types.MetricData) ([]*types.MetricData, error) {
 	if len(e.Args()) < 1 {
 		return nil, parser.ErrMissingTimeseries
 	}
 
-	firstArg, err := helper.GetSeriesArg(e.Args()[0], from, until, values)
+	firstArg, err := helper.GetSeriesArg(eval, e.Args()[0], from, until, values)
 	if err != nil {
 		return nil, err
 	}
@@ -47,7 +47,7 @@ func (f *divideSeries) Do(e parser.Expr, from, until int64, values map[parser.Me
 	if len(e.Args()) == 2 {
 		useMetricNames = true
 		numerators = firstArg
-		denominators, err := helper.GetSeriesArg(e.Args()[1], from, until, values)
+		denominators, err := helper.GetSeriesArg(eval, e.Args()[1], from, until, values)
 		if err != nil {
 			return nil, err
 		}
@@ -26,13 +26,13 @@ func New(configFile string) []interfaces.FunctionMetadata {
 }
 
 // fallbackSeries( seriesList, fallback )
-func (f *fallbackSeries) Do(e parser.Expr, from, until int64, values map[parser.MetricRequest][]*types.MetricData) ([]*types.MetricData, error) {
+func (f *fallbackSeries) Do(eval interfaces.Evaluator, e parser.Expr, from, until int64, values map[parser.MetricRequest][]*types.MetricData) ([]*types.MetricData, error) {
 	/*
 		Takes a wildcard seriesList, and a second fallback metric.
 		If the wildcard does not match any series, draws the fallback metric.
 	*/
-	seriesList, err := helper.GetSeriesArg(e.Args()[0], from, until, values)
-	fallback, errFallback := helper.GetSeriesArg(e.Args()[1], from, until, values)
+	seriesList, err := helper.GetSeriesArg(eval, e.Args()[0], from, until, values)
+	fallback, errFallback := helper.GetSeriesArg(eval, e.Args()[1], from, until, values)
 	if errFallback != nil && err != nil {
 		return nil, errFallback
 	}
@@ -26,8 +26,8 @@ func New(configFile string) []interfaces.FunctionMetadata {
 }
 
 // group(*seriesLists)
-func (f *group) Do(e parser.Expr, from, until int64, values map[parser.MetricRequest][]*types.MetricData) ([]*types.MetricData, error) {
-	args, err := helper.GetSeriesArgsAndRemoveNonExisting(e, from, until, values)
+func (f *group) Do(eval interfaces.Evaluator, e parser.Expr, from, until int64, values map[parser.MetricRequest][]*types.MetricData) ([]*types.MetricData, error) {
+	args, err := helper.GetSeriesArgsAndRemoveNonExisting(eval, e, from, until, values)
 	if err != nil {
 		return nil, err
 	}
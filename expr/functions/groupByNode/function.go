@@ -29,8 +29,8 @@ func New(configFile string) []interfaces.FunctionMetadata {
 
 // groupByNode(seriesList, nodeNum, callback)
 // groupByNodes(seriesList, callback, *nodes)
-func (f *groupByNode) Do(e parser.Expr, from, until int64, values map[parser.MetricRequest][]*types.MetricData) ([]*types.MetricData, error) {
-	args, err := helper.GetSeriesArg(e.Args()[0], from, until, values)
+func (f *groupByNode) Do(eval interfaces.Evaluator, e parser.Expr, from, until int64, values map[parser.MetricRequest][]*types.MetricData) ([]*types.MetricData, error) {
+	args, err := helper.GetSeriesArg(eval, e.Args()[0], from, until, values)
 	if err != nil {
 		return nil, err
 	}
@@ -106,7 +106,7 @@ func (f *groupByNode) Do(e parser.Expr, from, until int64, values map[parser.Met
 			}
 		}
 
-		r, _ := f.Evaluator.EvalExpr(nexpr, from, until, nvalues)
+		r, _ := eval.EvalExpr(nexpr, from, until, nvalues)
 		if r != nil {
 			r[0].Name = k
 			results = append(results, r...)
@@ -5,7 +5,6 @@ import (
 	"time"
 
 	"github.com/go-graphite/carbonapi/expr/functions/sum"
-	"github.com/go-graphite/carbonapi/expr/helper"
 	"github.com/go-graphite/carbonapi/expr/metadata"
 	"github.com/go-graphite/carbonapi/expr/types"
 	"github.com/go-graphite/carbonapi/pkg/parser"
@@ -24,7 +23,6 @@ func init() {
 
 	evaluator := th.EvaluatorFromFuncWithMetadata(metadata.FunctionMD.Functions)
 	metadata.SetEvaluator(evaluator)
-	helper.SetEvaluator(evaluator)
 }
 
 func TestGroupByNode(t *testing.T) {
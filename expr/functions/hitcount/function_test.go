@@ -1,7 +1,6 @@
 package hitcount
 
 import (
-	"github.com/go-graphite/carbonapi/expr/helper"
 	"github.com/go-graphite/carbonapi/expr/metadata"
 	"github.com/go-graphite/carbonapi/expr/types"
 	"github.com/go-graphite/carbonapi/pkg/parser"
@@ -14,7 +13,6 @@ func init() {
 	md := New("")
 	evaluator := th.EvaluatorFromFunc(md[0].F)
 	metadata.SetEvaluator(evaluator)
-	helper.SetEvaluator(evaluator)
 	for _, m := range md {
 		metadata.RegisterFunction(m.Name, m.F)
 	}
@@ -28,9 +28,9 @@ func New(configFile string) []interfaces.FunctionMetadata {
 	return res
 }
 
-func (f *holtWintersForecast) Do(e parser.Expr, from, until int64, values map[parser.MetricRequest][]*types.MetricData) ([]*types.MetricData, error) {
+func (f *holtWintersForecast) Do(eval interfaces.Evaluator, e parser.Expr, from, until int64, values map[parser.MetricRequest][]*types.MetricData) ([]*types.MetricData, error) {
 	var results []*types.MetricData
-	args, err := helper.GetSeriesArgsAndRemoveNonExisting(e, from-7*86400, until, values)
+	args, err := helper.GetSeriesArgsAndRemoveNonExisting(eval, e, from-7*86400, until, values)
 	if err != nil {
 		return nil, err
 	}
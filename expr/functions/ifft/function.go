@@ -31,15 +31,15 @@ func New(configFile string) []interfaces.FunctionMetadata {
 }
 
 // ifft(absSeriesList, phaseSeriesList)
-func (f *ifft) Do(e parser.Expr, from, until int64, values map[parser.MetricRequest][]*types.MetricData) ([]*types.MetricData, error) {
-	absSeriesList, err := helper.GetSeriesArg(e.Args()[0], from, until, values)
+func (f *ifft) Do(eval interfaces.Evaluator, e parser.Expr, from, until int64, values map[parser.MetricRequest][]*types.MetricData) ([]*types.MetricData, error) {
+	absSeriesList, err := helper.GetSeriesArg(eval, e.Args()[0], from, until, values)
 	if err != nil {
 		return nil, err
 	}
 
 	var phaseSeriesList []*types.MetricData
 	if len(e.Args()) > 1 {
-		phaseSeriesList, err = helper.GetSeriesArg(e.Args()[1], from, until, values)
+		phaseSeriesList, err = helper.GetSeriesArg(eval, e.Args()[1], from, until, values)
 		if err != nil {
 			return nil, err
 		}
@@ -28,7 +28,7 @@ func New(configFile string) []interfaces.FunctionMetadata {
 }
 
 // mostDeviant(seriesList, n) -or- mostDeviant(n, seriesList)
-func (f *mostDeviant) Do(e parser.Expr, from, until int64, values map[parser.MetricRequest][]*types.MetricData) ([]*types.MetricData, error) {
+func (f *mostDeviant) Do(eval interfaces.Evaluator, e parser.Expr, from, until int64, values map[parser.MetricRequest][]*types.MetricData) ([]*types.MetricData, error) {
 	var nArg int
 	if !e.Args()[0].IsConst() {
 		// mostDeviant(seriesList, n)
@@ -41,7 +41,7 @@ func (f *mostDeviant) Do(e parser.Expr, from, until int64, values map[parser.Met
 		return nil, err
 	}
 
-	args, err := helper.GetSeriesArg(e.Args()[seriesArg], from, until, values)
+	args, err := helper.GetSeriesArg(eval, e.Args()[seriesArg], from, until, values)
 	if err != nil {
 		return nil, err
 	}
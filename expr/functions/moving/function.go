@@ -29,7 +29,7 @@ func New(configFile string) []interfaces.FunctionMetadata {
 }
 
 // movingXyz(seriesList, windowSize)
-func (f *moving) Do(e parser.Expr, from, until int64, values map[parser.MetricRequest][]*types.MetricData) ([]*types.MetricData, error) {
+func (f *moving) Do(eval interfaces.Evaluator, e parser.Expr, from, until int64, values map[parser.MetricRequest][]*types.MetricData) ([]*types.MetricData, error) {
 	var n int
 	var err error
 
@@ -61,7 +61,7 @@ func (f *moving) Do(e parser.Expr, from, until int64, values map[parser.MetricRe
 		start -= int64(n)
 	}
 
-	arg, err := helper.GetSeriesArg(e.Args()[0], start, until, values)
+	arg, err := helper.GetSeriesArg(eval, e.Args()[0], start, until, values)
 	if err != nil {
 		return nil, err
 	}
@@ -30,7 +30,7 @@ func New(configFile string) []interfaces.FunctionMetadata {
 }
 
 // movingMedian(seriesList, windowSize)
-func (f *movingMedian) Do(e parser.Expr, from, until int64, values map[parser.MetricRequest][]*types.MetricData) ([]*types.MetricData, error) {
+func (f *movingMedian) Do(eval interfaces.Evaluator, e parser.Expr, from, until int64, values map[parser.MetricRequest][]*types.MetricData) ([]*types.MetricData, error) {
 	var n int
 	var err error
 
@@ -62,7 +62,7 @@ func (f *movingMedian) Do(e parser.Expr, from, until int64, values map[parser.Me
 		start -= int64(n)
 	}
 
-	arg, err := helper.GetSeriesArg(e.Args()[0], start, until, values)
+	arg, err := helper.GetSeriesArg(eval, e.Args()[0], start, until, values)
 	if err != nil {
 		return nil, err
 	}
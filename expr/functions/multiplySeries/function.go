@@ -28,7 +28,7 @@ func New(configFile string) []interfaces.FunctionMetadata {
 }
 
 // multiplySeries(factorsSeriesList)
-func (f *multiplySeries) Do(e parser.Expr, from, until int64, values map[parser.MetricRequest][]*types.MetricData) ([]*types.MetricData, error) {
+func (f *multiplySeries) Do(eval interfaces.Evaluator, e parser.Expr, from, until int64, values map[parser.MetricRequest][]*types.MetricData) ([]*types.MetricData, error) {
 	r := types.MetricData{
 		FetchResponse: pb.FetchResponse{
 			Name:      fmt.Sprintf("multiplySeries(%s)", e.RawArgs()),
@@ -37,7 +37,7 @@ func (f *multiplySeries) Do(e parser.Expr, from, until int64, values map[parser.
 		},
 	}
 	for _, arg := range e.Args() {
-		series, err := helper.GetSeriesArg(arg, from, until, values)
+		series, err := helper.GetSeriesArg(eval, arg, from, until, values)
 		if err != nil {
 			return nil, err
 		}
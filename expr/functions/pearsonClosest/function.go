@@ -30,12 +30,12 @@ func New(configFile string) []interfaces.FunctionMetadata {
 }
 
 // pearsonClosest(series, seriesList, n, direction=abs)
-func (f *pearsonClosest) Do(e parser.Expr, from, until int64, values map[parser.MetricRequest][]*types.MetricData) ([]*types.MetricData, error) {
+func (f *pearsonClosest) Do(eval interfaces.Evaluator, e parser.Expr, from, until int64, values map[parser.MetricRequest][]*types.MetricData) ([]*types.MetricData, error) {
 	if len(e.Args()) > 3 {
 		return nil, types.ErrTooManyArguments
 	}
 
-	ref, err := helper.GetSeriesArg(e.Args()[0], from, until, values)
+	ref, err := helper.GetSeriesArg(eval, e.Args()[0], from, until, values)
 	if err != nil {
 		return nil, err
 	}
@@ -44,7 +44,7 @@ func (f *pearsonClosest) Do(e parser.Expr, from, until int64, values map[parser.
 		return nil, types.ErrWildcardNotAllowed
 	}
 
-	compare, err := helper.GetSeriesArg(e.Args()[1], from, until, values)
+	compare, err := helper.GetSeriesArg(eval, e.Args()[1], from, until, values)
 	if err != nil {
 		return nil, err
 	}
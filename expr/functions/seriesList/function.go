@@ -27,12 +27,12 @@ func New(configFile string) []interfaces.FunctionMetadata {
 	return res
 }
 
-func (f *seriesList) Do(e parser.Expr, from, until int64, values map[parser.MetricRequest][]*types.MetricData) ([]*types.MetricData, error) {
-	numerators, err := helper.GetSeriesArg(e.Args()[0], from, until, values)
+func (f *seriesList) Do(eval interfaces.Evaluator, e parser.Expr, from, until int64, values map[parser.MetricRequest][]*types.MetricData) ([]*types.MetricData, error) {
+	numerators, err := helper.GetSeriesArg(eval, e.Args()[0], from, until, values)
 	if err != nil {
 		return nil, err
 	}
-	denominators, err := helper.GetSeriesArg(e.Args()[1], from, until, values)
+	denominators, err := helper.GetSeriesArg(eval, e.Args()[1], from, until, values)
 	if err != nil {
 		return nil, err
 	}
@@ -4,7 +4,6 @@ import (
 	"testing"
 	"time"
 
-	"github.com/go-graphite/carbonapi/expr/helper"
 	"github.com/go-graphite/carbonapi/expr/metadata"
 			th "github.com/go-graphite/carbonapi/tests"
 	"github.com/go-graphite/carbonapi/pkg/parser"
@@ -15,7 +14,6 @@ func init() {
 	md := New("")
 	evaluator := th.EvaluatorFromFunc(md[0].F)
 	metadata.SetEvaluator(evaluator)
-	helper.SetEvaluator(evaluator)
 	for _, m := range md {
 		metadata.RegisterFunction(m.Name, m.F)
 	}
@@ -27,7 +27,7 @@ func New(configFile string) []interfaces.FunctionMetadata {
 }
 
 // timeStack(seriesList, timeShiftUnit, timeShiftStart, timeShiftEnd)
-func (f *timeStack) Do(e parser.Expr, from, until int64, values map[parser.MetricRequest][]*types.MetricData) ([]*types.MetricData, error) {
+func (f *timeStack) Do(eval interfaces.Evaluator, e parser.Expr, from, until int64, values map[parser.MetricRequest][]*types.MetricData) ([]*types.MetricData, error) {
 	unit, err := e.GetIntervalArg(1, -1)
 	if err != nil {
 		return nil, err
@@ -48,7 +48,7 @@ func (f *timeStack) Do(e parser.Expr, from, until int64, values map[parser.Metri
 		offs := i * int64(unit)
 		fromNew := from + offs
 		untilNew := until + offs
-		arg, err := helper.GetSeriesArg(e.Args()[0], fromNew, untilNew, values)
+		arg, err := helper.GetSeriesArg(eval, e.Args()[0], fromNew, untilNew, values)
 		if err != nil {
 			return nil, err
 		}
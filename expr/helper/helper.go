@@ -17,8 +17,6 @@ import (
 	"regexp"
 )
 
-var evaluator interfaces.Evaluator
-
 // Backref is a pre-compiled expression for backref
 var Backref = regexp.MustCompile(`\\(\d+)`)
 
@@ -29,18 +27,13 @@ func (e ErrUnknownFunction) Error() string {
 	return fmt.Sprintf("unknown function in evalExpr: %q", string(e))
 }
 
-// SetEvaluator sets evaluator for all helper functions
-func SetEvaluator(e interfaces.Evaluator) {
-	evaluator = e
-}
-
 // GetSeriesArg returns argument from series.
-func GetSeriesArg(arg parser.Expr, from, until int64, values map[parser.MetricRequest][]*types.MetricData) ([]*types.MetricData, error) {
+func GetSeriesArg(eval interfaces.Evaluator, arg parser.Expr, from, until int64, values map[parser.MetricRequest][]*types.MetricData) ([]*types.MetricData, error) {
 	if !arg.IsName() && !arg.IsFunc() {
 		return nil, parser.ErrMissingTimeseries
 	}
 
-	a, err := evaluator.EvalExpr(arg, from, until, values)
+	a, err := eval.EvalExpr(arg, from, until, values)
 	if err != nil {
 		return nil, err
 	}
@@ -59,11 +52,11 @@ func RemoveEmptySeriesFromName(args []*types.MetricData) string {
 }
 
 // GetSeriesArgs returns arguments of series
-func GetSeriesArgs(e []parser.Expr, from, until int64, values map[parser.MetricRequest][]*types.MetricData) ([]*types.MetricData, error) {
+func GetSeriesArgs(eval interfaces.Evaluator, e []parser.Expr, from, until int64, values map[parser.MetricRequest][]*types.MetricData) ([]*types.MetricData, error) {
 	var args []*types.MetricData
 
 	for _, arg := range e {
-		a, err := GetSeriesArg(arg, from, until, values)
+		a, err := GetSeriesArg(eval, arg, from, until, values)
 		if err != nil && err != parser.ErrSeriesDoesNotExist {
 			return nil, err
 		}
@@ -79,8 +72,8 @@ func GetSeriesArgs(e []parser.Expr, from, until int64, values map[parser.MetricR
 
 // GetSeriesArgsAndRemoveNonExisting will fetch all required arguments, but will also filter out non existing Series
 // This is needed to be graphite-web compatible in cases when you pass non-existing Series to, for example, sumSeries
-func GetSeriesArgsAndRemoveNonExisting(e parser.Expr, from, until int64, values map[parser.MetricRequest][]*types.MetricData) ([]*types.MetricData, error) {
-	args, err := GetSeriesArgs(e.Args(), from, until, values)
+func GetSeriesArgsAndRemoveNonExisting(eval interfaces.Evaluator, e parser.Expr, from, until int64, values map[parser.MetricRequest][]*types.MetricData) ([]*types.MetricData, error) {
+	args, err := GetSeriesArgs(eval, e.Args(), from, until, values)
 	if err != nil {
 		return nil, err
 	}
@@ -96,8 +89,8 @@ func GetSeriesArgsAndRemoveNonExisting(e parser.Expr, from, until int64, values
 type seriesFunc func(*types.MetricData, *types.MetricData) *types.MetricData
 
 // ForEachSeriesDo do action for each serie in list.
-func ForEachSeriesDo(e parser.Expr, from, until int64, values map[parser.MetricRequest][]*types.MetricData, function seriesFunc) ([]*types.MetricData, error) {
-	arg, err := GetSeriesArg(e.Args()[0], from, until, values)
+func ForEachSeriesDo(eval interfaces.Evaluator, e parser.Expr, from, until int64, values map[parser.MetricRequest][]*types.MetricData, function seriesFunc) ([]*types.MetricData, error) {
+	arg, err := GetSeriesArg(eval, e.Args()[0], from, until, values)
 	if err != nil {
 		return nil, parser.ErrMissingTimeseries
 	}
@@ -0,0 +1,104 @@
+package expr
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/go-graphite/carbonapi/expr/types"
+	"github.com/go-graphite/carbonapi/pkg/parser"
+)
+
+// Limits bound evaluation of a single expression. Zero value of any field means no limit.
+type Limits struct {
+	// MaxSeries is the maximum amount of series any function or metric of the expression can return
+	MaxSeries int `mapstructure:"maxSeries"`
+	// MaxPoints is the maximum amount of points returned by all functions and metrics of the expression together
+	MaxPoints int64 `mapstructure:"maxPoints"`
+	// Timeout is the maximum wall time of evaluation. It's checked between function calls, so a single function
+	// is never interrupted
+	Timeout time.Duration `mapstructure:"timeout"`
+}
+
+// LimitError is returned when evaluation exceeds one of the Limits
+type LimitError struct {
+	Expr   string
+	Reason string
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("evaluation limit exceeded: %s in %s", e.Reason, e.Expr)
+}
+
+// IsLimitError checks if err is a LimitError
+func IsLimitError(err error) bool {
+	_, ok := err.(*LimitError)
+	return ok
+}
+
+// evalState accumulates usage of a single evaluation. Functions evaluate their arguments with the evaluator they
+// are called with, so every function call of the expression is accounted.
+type evalState struct {
+	limits   Limits
+	deadline time.Time
+	points   int64
+}
+
+// EvalExpr evaluates e and accounts its result
+func (s *evalState) EvalExpr(e parser.Expr, from, until int64, values map[parser.MetricRequest][]*types.MetricData) ([]*types.MetricData, error) {
+	if err := s.checkTime(e); err != nil {
+		return nil, err
+	}
+	res, err := evalExpr(s, e, from, until, values)
+	if err != nil {
+		return res, err
+	}
+	if err := s.account(e, res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// EvalExprWithLimits evaluates expression the same way as EvalExpr, but fails with LimitError as soon as
+// evaluation exceeds limits
+func EvalExprWithLimits(e parser.Expr, from, until int64, values map[parser.MetricRequest][]*types.MetricData, limits Limits) ([]*types.MetricData, error) {
+	if limits == (Limits{}) {
+		return EvalExpr(e, from, until, values)
+	}
+
+	s := &evalState{limits: limits}
+	if limits.Timeout > 0 {
+		s.deadline = time.Now().Add(limits.Timeout)
+	}
+	return s.EvalExpr(e, from, until, values)
+}
+
+// checkTime fails if evaluation is running longer than allowed
+func (s *evalState) checkTime(e parser.Expr) error {
+	if !s.deadline.IsZero() && time.Now().After(s.deadline) {
+		return &LimitError{
+			Expr:   e.ToString(),
+			Reason: fmt.Sprintf("evaluation took more than %v", s.limits.Timeout),
+		}
+	}
+	return nil
+}
+
+// account adds result of e to usage and checks it against limits
+func (s *evalState) account(e parser.Expr, res []*types.MetricData) error {
+	if s.limits.MaxSeries > 0 && len(res) > s.limits.MaxSeries {
+		return &LimitError{
+			Expr:   e.ToString(),
+			Reason: fmt.Sprintf("%d series returned, limit is %d", len(res), s.limits.MaxSeries),
+		}
+	}
+	for _, r := range res {
+		s.points += int64(len(r.Values))
+	}
+	if s.limits.MaxPoints > 0 && s.points > s.limits.MaxPoints {
+		return &LimitError{
+			Expr:   e.ToString(),
+			Reason: fmt.Sprintf("more than %d points processed", s.limits.MaxPoints),
+		}
+	}
+	return s.checkTime(e)
+}
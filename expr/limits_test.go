@@ -0,0 +1,56 @@
+package expr
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-graphite/carbonapi/expr/types"
+	"github.com/go-graphite/carbonapi/pkg/parser"
+)
+
+func TestEvalExprWithLimits(t *testing.T) {
+	values := map[parser.MetricRequest][]*types.MetricData{
+		{Metric: "foo.*", From: 0, Until: 1}: {
+			types.MakeMetricData("foo.a", []float64{1, 2, 3}, 1, 0),
+			types.MakeMetricData("foo.b", []float64{4, 5, 6}, 1, 0),
+			types.MakeMetricData("foo.c", []float64{7, 8, 9}, 1, 0),
+		},
+	}
+
+	tests := []struct {
+		target  string
+		limits  Limits
+		limited bool
+	}{
+		{"sumSeries(foo.*)", Limits{}, false},
+		{"sumSeries(foo.*)", Limits{MaxSeries: 3, MaxPoints: 12}, false},
+		{"sumSeries(foo.*)", Limits{MaxSeries: 2}, true},
+		{"sumSeries(foo.*)", Limits{MaxPoints: 11}, true},
+		{"scale(scale(foo.*,2),2)", Limits{MaxPoints: 20}, true},
+		{"sumSeries(foo.*)", Limits{Timeout: time.Nanosecond}, true},
+		// groupByNode evaluates aggregation of every group with its own values, they are limited as well
+		{"groupByNode(foo.*,0,\"sum\")", Limits{MaxPoints: 13}, true},
+		{"groupByNode(foo.*,0,\"sum\")", Limits{MaxPoints: 30}, false},
+	}
+
+	for _, tt := range tests {
+		exp, _, err := parser.ParseExpr(tt.target)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := EvalExprWithLimits(exp, 0, 1, values, tt.limits)
+		if tt.limited {
+			if !IsLimitError(err) {
+				t.Errorf("%s with %+v: expected limit error, got %v", tt.target, tt.limits, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s with %+v: unexpected error %v", tt.target, tt.limits, err)
+			continue
+		}
+		if len(res) != 1 {
+			t.Errorf("%s with %+v: expected 1 series, got %d", tt.target, tt.limits, len(res))
+		}
+	}
+}
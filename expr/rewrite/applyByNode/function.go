@@ -26,8 +26,8 @@ func New(configFile string) []interfaces.RewriteFunctionMetadata {
 	return res
 }
 
-func (f *applyByNode) Do(e parser.Expr, from, until int64, values map[parser.MetricRequest][]*types.MetricData) (bool, []string, error) {
-	args, err := helper.GetSeriesArg(e.Args()[0], from, until, values)
+func (f *applyByNode) Do(eval interfaces.Evaluator, e parser.Expr, from, until int64, values map[parser.MetricRequest][]*types.MetricData) (bool, []string, error) {
+	args, err := helper.GetSeriesArg(eval, e.Args()[0], from, until, values)
 	if err != nil {
 		return false, nil, err
 	}
@@ -10,20 +10,30 @@ import (
 func TestJSONResponse(t *testing.T) {
 
 	tests := []struct {
-		results []*MetricData
-		out     []byte
+		results      []*MetricData
+		noNullPoints bool
+		out          []byte
 	}{
 		{
 			[]*MetricData{
 				MakeMetricData("metric1", []float64{1, 1.5, 2.25, math.NaN()}, 100, 100),
 				MakeMetricData("metric2", []float64{2, 2.5, 3.25, 4, 5}, 100, 100),
 			},
+			false,
 			[]byte(`[{"target":"metric1","datapoints":[[1,100],[1.5,200],[2.25,300],[null,400]]},{"target":"metric2","datapoints":[[2,100],[2.5,200],[3.25,300],[4,400],[5,500]]}]`),
 		},
+		{
+			[]*MetricData{
+				MakeMetricData("metric1", []float64{math.NaN(), 1.5, math.NaN(), 3}, 100, 100),
+				MakeMetricData("metric2", []float64{math.NaN(), math.NaN()}, 100, 100),
+			},
+			true,
+			[]byte(`[{"target":"metric1","datapoints":[[1.5,200],[3,400]]},{"target":"metric2","datapoints":[]}]`),
+		},
 	}
 
 	for _, tt := range tests {
-		b := MarshalJSON(tt.results)
+		b := MarshalJSON(tt.results, tt.noNullPoints)
 		if !bytes.Equal(b, tt.out) {
 			t.Errorf("marshalJSON(%+v)=%+v, want %+v", tt.results, string(b), string(tt.out))
 		}
@@ -71,6 +81,6 @@ func BenchmarkMarshalJSON(b *testing.B) {
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		_ = MarshalJSON(data)
+		_ = MarshalJSON(data, false)
 	}
 }
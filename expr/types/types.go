@@ -101,8 +101,8 @@ func ConsolidateJSON(maxDataPoints int, results []*MetricData) {
 	}
 }
 
-// MarshalJSON marshals metric data to JSON
-func MarshalJSON(results []*MetricData) []byte {
+// MarshalJSON marshals metric data to graphite-web compatible JSON. If noNullPoints is set, points without values are omitted
+func MarshalJSON(results []*MetricData, noNullPoints bool) []byte {
 	var b []byte
 	b = append(b, '[')
 
@@ -124,6 +124,11 @@ func MarshalJSON(results []*MetricData) []byte {
 		var innerComma bool
 		t := r.StartTime
 		for _, v := range r.AggregatedValues() {
+			if noNullPoints && (math.IsInf(v, 0) || math.IsNaN(v)) {
+				t += r.AggregatedTimeStep()
+				continue
+			}
+
 			if innerComma {
 				b = append(b, ',')
 			}
@@ -0,0 +1,114 @@
+package limiter
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	retryBudgetBuckets = 10
+
+	// DefaultRetryBudgetWindow is used when window is not set
+	DefaultRetryBudgetWindow = 10 * time.Second
+	// MinRetryBudgetWindow is the shortest allowed window, anything shorter won't see enough requests to be useful
+	MinRetryBudgetWindow = time.Second
+)
+
+// RetryBudget limits amount of retries to a fraction of requests seen during sliding window.
+// That prevents retries from amplifying load when backends are already in trouble.
+//
+// nil RetryBudget allows everything.
+type RetryBudget struct {
+	sync.Mutex
+
+	ratio      float64
+	minRetries int64
+	bucketLen  time.Duration
+
+	requests [retryBudgetBuckets]int64
+	retries  [retryBudgetBuckets]int64
+	epoch    int64
+
+	exhausted int64
+	now       func() time.Time
+}
+
+// NewRetryBudget creates budget that allows up to ratio*requests retries during window, but at least minRetries.
+// Window of 0 means DefaultRetryBudgetWindow, shorter than MinRetryBudgetWindow is raised to it.
+func NewRetryBudget(ratio float64, minRetries int, window time.Duration) *RetryBudget {
+	if window <= 0 {
+		window = DefaultRetryBudgetWindow
+	} else if window < MinRetryBudgetWindow {
+		window = MinRetryBudgetWindow
+	}
+	return &RetryBudget{
+		ratio:      ratio,
+		minRetries: int64(minRetries),
+		bucketLen:  window / retryBudgetBuckets,
+		now:        time.Now,
+	}
+}
+
+// rotate drops buckets that are now out of the window. Must be called with lock held.
+func (b *RetryBudget) rotate() int {
+	epoch := b.now().UnixNano() / int64(b.bucketLen)
+	if epoch-b.epoch >= retryBudgetBuckets {
+		b.requests = [retryBudgetBuckets]int64{}
+		b.retries = [retryBudgetBuckets]int64{}
+	} else {
+		for e := b.epoch + 1; e <= epoch; e++ {
+			b.requests[e%retryBudgetBuckets] = 0
+			b.retries[e%retryBudgetBuckets] = 0
+		}
+	}
+	b.epoch = epoch
+	return int(epoch % retryBudgetBuckets)
+}
+
+// Request records that new (non-retry) request have been made
+func (b *RetryBudget) Request() {
+	if b == nil {
+		return
+	}
+	b.Lock()
+	idx := b.rotate()
+	b.requests[idx]++
+	b.Unlock()
+}
+
+// TryRetry checks if there is still some budget left for retries. If there is, it's accounted as spent
+func (b *RetryBudget) TryRetry() bool {
+	if b == nil {
+		return true
+	}
+	b.Lock()
+	defer b.Unlock()
+
+	idx := b.rotate()
+	var requests, retries int64
+	for i := range b.requests {
+		requests += b.requests[i]
+		retries += b.retries[i]
+	}
+
+	allowed := int64(b.ratio * float64(requests))
+	if allowed < b.minRetries {
+		allowed = b.minRetries
+	}
+
+	if retries >= allowed {
+		atomic.AddInt64(&b.exhausted, 1)
+		return false
+	}
+	b.retries[idx]++
+	return true
+}
+
+// Exhausted returns amount of retries that were denied because budget was exhausted
+func (b *RetryBudget) Exhausted() int64 {
+	if b == nil {
+		return 0
+	}
+	return atomic.LoadInt64(&b.exhausted)
+}
@@ -0,0 +1,71 @@
+package limiter
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRetryBudget(t *testing.T) {
+	now := time.Unix(1000, 0)
+	b := NewRetryBudget(0.1, 1, 10*time.Second)
+	b.now = func() time.Time { return now }
+
+	if !b.TryRetry() {
+		t.Fatal("minRetries should allow at least one retry")
+	}
+	if b.TryRetry() {
+		t.Fatal("retry allowed above minRetries without any requests")
+	}
+
+	for i := 0; i < 30; i++ {
+		b.Request()
+	}
+	allowed := 0
+	for i := 0; i < 10; i++ {
+		if b.TryRetry() {
+			allowed++
+		}
+	}
+	// 10% of 30 requests = 3 retries, one of them is already spent
+	if allowed != 2 {
+		t.Fatalf("unexpected amount of allowed retries, got %v, expected %v", allowed, 2)
+	}
+
+	if b.Exhausted() != 9 {
+		t.Fatalf("unexpected amount of exhausted retries, got %v, expected %v", b.Exhausted(), 9)
+	}
+
+	// window passed, everything is forgotten
+	now = now.Add(11 * time.Second)
+	if !b.TryRetry() {
+		t.Fatal("budget should be restored after window have passed")
+	}
+}
+
+func TestRetryBudgetNil(t *testing.T) {
+	var b *RetryBudget
+	b.Request()
+	if !b.TryRetry() {
+		t.Fatal("nil budget should always allow retries")
+	}
+}
+
+func TestRetryBudgetWindow(t *testing.T) {
+	tests := []struct {
+		window time.Duration
+		bucket time.Duration
+	}{
+		{0, DefaultRetryBudgetWindow / retryBudgetBuckets},
+		{-time.Second, DefaultRetryBudgetWindow / retryBudgetBuckets},
+		{50 * time.Nanosecond, MinRetryBudgetWindow / retryBudgetBuckets},
+		{500 * time.Millisecond, MinRetryBudgetWindow / retryBudgetBuckets},
+		{time.Minute, 6 * time.Second},
+	}
+
+	for _, tt := range tests {
+		b := NewRetryBudget(0.1, 1, tt.window)
+		if b.bucketLen != tt.bucket {
+			t.Errorf("window %v: unexpected bucket length, got %v, expected %v", tt.window, b.bucketLen, tt.bucket)
+		}
+	}
+}
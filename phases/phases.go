@@ -0,0 +1,145 @@
+package phases
+
+import (
+	"expvar"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// Phase is a stage of request processing
+type Phase int
+
+const (
+	// Routing is time spent on choosing backends and expanding globs
+	Routing Phase = iota
+	// FanOutWait is time spent waiting for backend responses
+	FanOutWait
+	// Decode is time spent on unmarshaling backend responses
+	Decode
+	// Merge is time spent on merging responses from different backends
+	Merge
+	// Evaluate is time spent on applying graphite functions
+	Evaluate
+	// Encode is time spent on marshaling response to the client
+	Encode
+
+	phasesCount
+)
+
+var phaseNames = [phasesCount]string{
+	"routing",
+	"fanout_wait",
+	"decode",
+	"merge",
+	"evaluate",
+	"encode",
+}
+
+func (p Phase) String() string {
+	return phaseNames[p]
+}
+
+// bucketBounds are upper bounds of histogram buckets. Everything slower falls into overflow bucket
+var bucketBounds = [...]time.Duration{
+	time.Millisecond,
+	2 * time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	2500 * time.Millisecond,
+	5 * time.Second,
+}
+
+// Histogram counts durations in exponential buckets. It satisfies expvar.Var
+type Histogram struct {
+	buckets [len(bucketBounds) + 1]int64
+	count   int64
+	sumNS   int64
+}
+
+// Observe records single duration
+func (h *Histogram) Observe(d time.Duration) {
+	i := 0
+	for i < len(bucketBounds) && d > bucketBounds[i] {
+		i++
+	}
+	atomic.AddInt64(&h.buckets[i], 1)
+	atomic.AddInt64(&h.count, 1)
+	atomic.AddInt64(&h.sumNS, int64(d))
+}
+
+func bucketName(i int) string {
+	if i == len(bucketBounds) {
+		return "le_inf"
+	}
+	return "le_" + strconv.FormatInt(int64(bucketBounds[i]/time.Millisecond), 10) + "ms"
+}
+
+// String returns JSON representation of histogram
+func (h *Histogram) String() string {
+	b := []byte(`{"count":`)
+	b = strconv.AppendInt(b, atomic.LoadInt64(&h.count), 10)
+	b = append(b, `,"sum_ns":`...)
+	b = strconv.AppendInt(b, atomic.LoadInt64(&h.sumNS), 10)
+	for i := range h.buckets {
+		b = append(b, `,"`...)
+		b = append(b, bucketName(i)...)
+		b = append(b, `":`...)
+		b = strconv.AppendInt(b, atomic.LoadInt64(&h.buckets[i]), 10)
+	}
+	b = append(b, '}')
+	return string(b)
+}
+
+// Buckets returns expvar.Var for every bucket, keyed by bucket name. That's useful for sending them to graphite
+func (h *Histogram) Buckets() map[string]expvar.Var {
+	res := make(map[string]expvar.Var, len(h.buckets)+2)
+	res["count"] = counter{&h.count}
+	res["sum_ns"] = counter{&h.sumNS}
+	for i := range h.buckets {
+		res[bucketName(i)] = counter{&h.buckets[i]}
+	}
+	return res
+}
+
+type counter struct {
+	v *int64
+}
+
+func (c counter) String() string {
+	return strconv.FormatInt(atomic.LoadInt64(c.v), 10)
+}
+
+var histograms [phasesCount]Histogram
+
+// Observe records that request spent d in phase p
+func Observe(p Phase, d time.Duration) {
+	histograms[p].Observe(d)
+}
+
+// Since records time passed since t0 for phase p
+func Since(p Phase, t0 time.Time) {
+	histograms[p].Observe(time.Since(t0))
+}
+
+// All returns histograms for all phases, keyed by phase name
+func All() map[string]*Histogram {
+	res := make(map[string]*Histogram, phasesCount)
+	for p := Phase(0); p < phasesCount; p++ {
+		res[p.String()] = &histograms[p]
+	}
+	return res
+}
+
+// Publish exports all histograms as expvars with given prefix
+func Publish(prefix string) {
+	for name, h := range All() {
+		expvar.Publish(prefix+name, h)
+	}
+}
@@ -0,0 +1,37 @@
+package phases
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestHistogram(t *testing.T) {
+	var h Histogram
+	h.Observe(500 * time.Microsecond)
+	h.Observe(time.Millisecond)
+	h.Observe(3 * time.Millisecond)
+	h.Observe(time.Minute)
+
+	var res map[string]int64
+	if err := json.Unmarshal([]byte(h.String()), &res); err != nil {
+		t.Fatalf("histogram is not a valid json: %v", err)
+	}
+
+	expected := map[string]int64{
+		"count":  4,
+		"le_1ms": 2,
+		"le_2ms": 0,
+		"le_5ms": 1,
+		"le_inf": 1,
+	}
+	for k, v := range expected {
+		if res[k] != v {
+			t.Errorf("unexpected value of %v, got %v, expected %v", k, res[k], v)
+		}
+	}
+
+	if h.Buckets()["le_5ms"].String() != "1" {
+		t.Errorf("unexpected bucket value %v", h.Buckets()["le_5ms"].String())
+	}
+}
@@ -360,6 +360,25 @@ func TestParseExpr(t *testing.T) {
 		{"hello&world",
 			&expr{target: "hello&world"},
 		},
+		{
+			`alias(scale(host.*.cpu,8),"bits, (total)")`,
+			&expr{
+				target: "alias",
+				etype:  EtFunc,
+				args: []*expr{
+					{target: "scale",
+						etype: EtFunc,
+						args: []*expr{
+							{target: "host.*.cpu"},
+							{val: 8, etype: EtConst},
+						},
+						argString: "host.*.cpu,8",
+					},
+					{etype: EtString, valStr: "bits, (total)"},
+				},
+				argString: `scale(host.*.cpu,8),"bits, (total)"`,
+			},
+		},
 	}
 
 	for _, tt := range tests {
@@ -373,3 +392,58 @@ func TestParseExpr(t *testing.T) {
 		}
 	}
 }
+
+func TestExpandTemplates(t *testing.T) {
+	tests := []struct {
+		target   string
+		params   map[string]string
+		expected string
+	}{
+		{"sumSeries(foo.*)", nil, "sumSeries(foo.*)"},
+		{`template(hosts.$hostname.cpu, hostname="worker1")`, nil, "hosts.worker1.cpu"},
+		{`template(hosts.$hostname.cpu, hostname="worker1")`, map[string]string{"hostname": "worker2"}, "hosts.worker2.cpu"},
+		{`template(hosts.$1.$10.cpu, "a", "b", "c", "d", "e", "f", "g", "h", "i", "j")`, nil, "hosts.a.j.cpu"},
+		{`alias(template(scale(hosts.$host.cpu, $factor), host="web01", factor=2), "$host")`, nil, "alias(scale(hosts.web01.cpu,2),'$host')"},
+		{`template(aliasByNode(hosts.$host.cpu, 1), host="web01")`, nil, "aliasByNode(hosts.web01.cpu,1)"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			e, _, err := ParseExpr(tt.target)
+			if err != nil {
+				t.Fatalf("failed to parse %v: %v", tt.target, err)
+			}
+			res, err := ExpandTemplates(e, tt.params)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if res.ToString() != tt.expected {
+				t.Fatalf("got %v, expected %v", res.ToString(), tt.expected)
+			}
+		})
+	}
+
+	e, _, _ := ParseExpr("template()")
+	if _, err := ExpandTemplates(e, nil); err != ErrMissingArgument {
+		t.Fatalf("expected %v, got %v", ErrMissingArgument, err)
+	}
+
+	// template in named argument
+	named := &expr{
+		etype:  EtFunc,
+		target: "asPercent",
+		args:   []*expr{{etype: EtName, target: "hosts.web01.cpu"}},
+		namedArgs: map[string]*expr{
+			"total": {
+				etype:     EtFunc,
+				target:    "template",
+				args:      []*expr{{etype: EtName, target: "hosts.$host.total"}},
+				namedArgs: map[string]*expr{"host": {etype: EtString, valStr: "web01"}},
+			},
+		},
+	}
+	s, found, err := expandTemplates(named, nil, nil)
+	if err != nil || !found || s != "asPercent(hosts.web01.cpu,total=hosts.web01.total)" {
+		t.Fatalf("unexpected expansion of named argument %v, %v, error %v", s, found, err)
+	}
+}
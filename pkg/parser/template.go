@@ -0,0 +1,115 @@
+package parser
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ExpandTemplates replaces every template() call in e with its first argument, where $name variables are substituted
+// the same way graphite-web does. Variables default to the rest of template() arguments: named ones are used as is,
+// positional ones are available as $1, $2 and so on. params (template[name]=value render parameters) override them.
+func ExpandTemplates(e Expr, params map[string]string) (Expr, error) {
+	s, found, err := expandTemplates(e.toExpr().(*expr), nil, params)
+	if err != nil || !found {
+		return e, err
+	}
+
+	res, rest, err := ParseExpr(s)
+	if err != nil {
+		return nil, err
+	}
+	if rest != "" {
+		return nil, ErrUnexpectedCharacter
+	}
+	return res, nil
+}
+
+// templateValue returns value of template() argument as a string
+func templateValue(e *expr) (string, error) {
+	switch e.etype {
+	case EtString:
+		return e.valStr, nil
+	case EtConst:
+		return strconv.FormatFloat(e.val, 'f', -1, 64), nil
+	case EtName:
+		return e.target, nil
+	}
+	return "", ErrBadType
+}
+
+// substituteVars replaces $name with values of vars, longer names first so $10 is not replaced with value of $1
+func substituteVars(s string, vars map[string]string) string {
+	if len(vars) == 0 || !strings.Contains(s, "$") {
+		return s
+	}
+	names := make([]string, 0, len(vars))
+	for name := range vars {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool { return len(names[i]) > len(names[j]) })
+	for _, name := range names {
+		s = strings.Replace(s, "$"+name, vars[name], -1)
+	}
+	return s
+}
+
+// expandTemplates returns string representation of e with templates expanded. found is true if e contains any template() call.
+func expandTemplates(e *expr, vars map[string]string, params map[string]string) (s string, found bool, err error) {
+	switch e.etype {
+	case EtName:
+		return substituteVars(e.target, vars), false, nil
+	case EtConst:
+		return strconv.FormatFloat(e.val, 'f', -1, 64), false, nil
+	case EtString:
+		str := &expr{etype: EtString, valStr: substituteVars(e.valStr, vars)}
+		return str.ToString(), false, nil
+	}
+
+	if e.target == "template" {
+		if len(e.args) == 0 {
+			return "", false, ErrMissingArgument
+		}
+		templateVars := make(map[string]string)
+		for i, arg := range e.args[1:] {
+			if templateVars[strconv.Itoa(i+1)], err = templateValue(arg); err != nil {
+				return "", false, err
+			}
+		}
+		for name, arg := range e.namedArgs {
+			if templateVars[name], err = templateValue(arg); err != nil {
+				return "", false, err
+			}
+		}
+		for name, v := range params {
+			templateVars[name] = v
+		}
+		s, _, err = expandTemplates(e.args[0], templateVars, params)
+		return s, true, err
+	}
+
+	args := make([]string, 0, len(e.args)+len(e.namedArgs))
+	for _, arg := range e.args {
+		a, f, err := expandTemplates(arg, vars, params)
+		if err != nil {
+			return "", false, err
+		}
+		found = found || f
+		args = append(args, a)
+	}
+	names := make([]string, 0, len(e.namedArgs))
+	for name := range e.namedArgs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		a, f, err := expandTemplates(e.namedArgs[name], vars, params)
+		if err != nil {
+			return "", false, err
+		}
+		found = found || f
+		args = append(args, name+"="+a)
+	}
+
+	return e.target + "(" + strings.Join(args, ",") + ")", found, nil
+}
@@ -15,7 +15,7 @@ import (
 )
 
 type FuncEvaluator struct {
-	eval func(e parser.Expr, from, until int64, values map[parser.MetricRequest][]*types.MetricData) ([]*types.MetricData, error)
+	eval func(eval interfaces.Evaluator, e parser.Expr, from, until int64, values map[parser.MetricRequest][]*types.MetricData) ([]*types.MetricData, error)
 }
 
 func (evaluator *FuncEvaluator) EvalExpr(e parser.Expr, from, until int64, values map[parser.MetricRequest][]*types.MetricData) ([]*types.MetricData, error) {
@@ -32,7 +32,7 @@ func (evaluator *FuncEvaluator) EvalExpr(e parser.Expr, from, until int64, value
 		return nil, parser.ErrMissingArgument
 	}
 
-	return evaluator.eval(e, from, until, values)
+	return evaluator.eval(evaluator, e, from, until, values)
 }
 
 func EvaluatorFromFunc(function interfaces.Function) interfaces.Evaluator {
@@ -45,9 +45,9 @@ func EvaluatorFromFunc(function interfaces.Function) interfaces.Evaluator {
 
 func EvaluatorFromFuncWithMetadata(metadata map[string]interfaces.Function) interfaces.Evaluator {
 	e := &FuncEvaluator{
-		eval: func(e parser.Expr, from, until int64, values map[parser.MetricRequest][]*types.MetricData) ([]*types.MetricData, error) {
+		eval: func(eval interfaces.Evaluator, e parser.Expr, from, until int64, values map[parser.MetricRequest][]*types.MetricData) ([]*types.MetricData, error) {
 			if f, ok := metadata[e.Target()]; ok {
-				return f.Do(e, from, until, values)
+				return f.Do(eval, e, from, until, values)
 			}
 			return nil, fmt.Errorf("unknown function: %v", e.Target())
 		},
@@ -10,8 +10,16 @@ type key int
 const (
 	HeaderUUIDAPI    = "X-CTX-CarbonAPI-UUID"
 	HeaderUUIDZipper = "X-CTX-CarbonZipper-UUID"
+	// HeaderPartialResult is set on responses that miss data of some backends, it lists them comma-separated
+	HeaderPartialResult = "X-Partial-Result"
 
-	uuidKey key = 0
+	uuidKey          key = 0
+	consolidateByKey key = 1
+	xFilesFactorKey  key = 2
+	maxBackendsKey   key = 3
+	strictKey        key = 4
+	sourcesKey       key = 5
+	backendsKey      key = 6
 )
 
 func ifaceToString(v interface{}) string {
@@ -33,6 +41,65 @@ func SetUUID(ctx context.Context, v string) context.Context {
 	return context.WithValue(ctx, uuidKey, v)
 }
 
+func GetConsolidateBy(ctx context.Context) string {
+	return getCtxString(ctx, consolidateByKey)
+}
+
+func SetConsolidateBy(ctx context.Context, v string) context.Context {
+	return context.WithValue(ctx, consolidateByKey, v)
+}
+
+// GetXFilesFactor returns xFilesFactor for the request. ok is false if it wasn't set
+func GetXFilesFactor(ctx context.Context) (xff float32, ok bool) {
+	xff, ok = ctx.Value(xFilesFactorKey).(float32)
+	return xff, ok
+}
+
+func SetXFilesFactor(ctx context.Context, v float32) context.Context {
+	return context.WithValue(ctx, xFilesFactorKey, v)
+}
+
+// GetMaxBackends returns maximum amount of backends request is allowed to fetch data from, 0 means unlimited
+func GetMaxBackends(ctx context.Context) int {
+	v, _ := ctx.Value(maxBackendsKey).(int)
+	return v
+}
+
+func SetMaxBackends(ctx context.Context, v int) context.Context {
+	return context.WithValue(ctx, maxBackendsKey, v)
+}
+
+// GetStrict returns true if request must fail instead of returning partial data when some of the backends failed
+func GetStrict(ctx context.Context) bool {
+	v, _ := ctx.Value(strictKey).(bool)
+	return v
+}
+
+func SetStrict(ctx context.Context, v bool) context.Context {
+	return context.WithValue(ctx, strictKey, v)
+}
+
+// GetTrackSources returns true if zipper should report which backends returned points for every series
+func GetTrackSources(ctx context.Context) bool {
+	v, _ := ctx.Value(sourcesKey).(bool)
+	return v
+}
+
+func SetTrackSources(ctx context.Context, v bool) context.Context {
+	return context.WithValue(ctx, sourcesKey, v)
+}
+
+// GetAllowedBackends returns names of the top-level backend groups (or of their servers) request may query, nil means
+// any
+func GetAllowedBackends(ctx context.Context) []string {
+	v, _ := ctx.Value(backendsKey).([]string)
+	return v
+}
+
+func SetAllowedBackends(ctx context.Context, v []string) context.Context {
+	return context.WithValue(ctx, backendsKey, v)
+}
+
 func ParseCtx(h http.HandlerFunc, uuidKey string) http.HandlerFunc {
 	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
 		uuid := req.Header.Get(uuidKey)
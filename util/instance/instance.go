@@ -0,0 +1,55 @@
+// Package instance keeps name of the instance, so loggers and self-metrics of several processes that run on the same
+// host can be told apart.
+package instance
+
+import (
+	"strings"
+	"sync/atomic"
+
+	"github.com/lomik/zapwriter"
+	"go.uber.org/zap"
+)
+
+var name atomic.Value
+
+func init() {
+	name.Store("")
+}
+
+// Set sets name of the instance, empty name means that instance is not named
+func Set(instance string) {
+	name.Store(instance)
+}
+
+// Name returns name of the instance
+func Name() string {
+	return name.Load().(string)
+}
+
+// Logger returns named logger that marks every message with instance name, if it's set
+func Logger(logger string) *zap.Logger {
+	l := zapwriter.Logger(logger)
+	if instance := Name(); instance != "" {
+		l = l.With(zap.String("instance", instance))
+	}
+	return l
+}
+
+// GraphitePattern returns prefix of self-metrics. {fqdn} is replaced with hostname, {host} with its first label and
+// {instance} with instance name. If instance is set, but pattern doesn't use it, it's appended to the pattern, so
+// processes running on the same host don't overwrite each other's metrics.
+func GraphitePattern(pattern, prefix, instance, hostname string) string {
+	if instance != "" && !strings.Contains(pattern, "{instance}") {
+		pattern += ".{instance}"
+	}
+	host := hostname
+	if i := strings.IndexByte(host, '.'); i >= 0 {
+		host = host[:i]
+	}
+	return strings.NewReplacer(
+		"{prefix}", prefix,
+		"{fqdn}", strings.Replace(hostname, ".", "_", -1),
+		"{host}", host,
+		"{instance}", strings.Replace(instance, ".", "_", -1),
+	).Replace(pattern)
+}
@@ -0,0 +1,43 @@
+package instance
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/lomik/zapwriter"
+)
+
+func TestGraphitePattern(t *testing.T) {
+	tests := []struct {
+		pattern  string
+		instance string
+		expected string
+	}{
+		{"{prefix}.{fqdn}", "", "carbon.zipper.web01_dc1_example_com"},
+		{"{prefix}.{fqdn}", "cluster.a", "carbon.zipper.web01_dc1_example_com.cluster_a"},
+		{"{prefix}.{host}.{instance}.zipper", "cluster-a", "carbon.zipper.web01.cluster-a.zipper"},
+		{"{prefix}.{host}", "", "carbon.zipper.web01"},
+	}
+	for _, tt := range tests {
+		if got := GraphitePattern(tt.pattern, "carbon.zipper", tt.instance, "web01.dc1.example.com"); got != tt.expected {
+			t.Errorf("%s with instance %q: got %s, expected %s", tt.pattern, tt.instance, got, tt.expected)
+		}
+	}
+}
+
+func TestLogger(t *testing.T) {
+	defer zapwriter.Test()()
+	defer Set(Name())
+
+	Set("")
+	Logger("test").Info("unnamed")
+	if out := zapwriter.TestCapture(); strings.Contains(out, "instance") {
+		t.Errorf("unnamed instance shouldn't be logged: %s", out)
+	}
+
+	Set("cluster-a")
+	Logger("test").Info("named")
+	if out := zapwriter.TestCapture(); !strings.Contains(out, `"instance": "cluster-a"`) {
+		t.Errorf("instance name is missing: %s", out)
+	}
+}
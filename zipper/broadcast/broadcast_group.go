@@ -2,10 +2,13 @@ package broadcast
 
 import (
 	"context"
+	"math"
 	"strings"
+	"time"
 
 	"github.com/go-graphite/carbonapi/limiter"
 	"github.com/go-graphite/carbonapi/pathcache"
+	"github.com/go-graphite/carbonapi/phases"
 	util "github.com/go-graphite/carbonapi/util/ctx"
 	"github.com/go-graphite/carbonapi/zipper/errors"
 	"github.com/go-graphite/carbonapi/zipper/types"
@@ -21,9 +24,23 @@ type BroadcastGroup struct {
 	clients              []types.ServerClient
 	servers              []string
 	maxMetricsPerRequest int
+	spread               *latencySpread
+	expandGlobs          bool
+	quorum               int
+	health               *healthScores
+	routes               routingTable
+	tiers                *storageTiers
+	failover             *failover
+	// rings are hash rings of the hashed children, including children of nested groups
+	rings map[types.ServerClient]*hashRing
+	// nested are broadcast groups that children belong to, by child name. Children of nested groups are asked
+	// directly, so quorums of nested groups are evaluated here.
+	nested map[string]*BroadcastGroup
 
 	pathCache pathcache.PathCache
-	logger    *zap.Logger
+	// paths is what the last probe has put to pathCache, as it can't be listed
+	paths  *learnedPaths
+	logger *zap.Logger
 }
 
 func (bg *BroadcastGroup) Children() []types.ServerClient {
@@ -58,11 +75,28 @@ func NewBroadcastGroupWithLimiter(logger *zap.Logger, groupName string, servers
 		limiter:              limiter,
 		servers:              serverNames,
 		maxMetricsPerRequest: 100, //TODO remove this hardcoded value
+		spread:               newLatencySpread(timeout.AfterFirstResponse),
+		health:               newHealthScores(),
+		rings:                make(map[types.ServerClient]*hashRing),
+		nested:               make(map[string]*BroadcastGroup),
 
 		pathCache: pathCache,
+		paths:     &learnedPaths{},
 		logger:    logger.With(zap.String("type", "broadcastGroup"), zap.String("groupName", groupName)),
 	}
 
+	// requests are sent to children directly, so hashing of nested groups has to be applied here
+	for _, s := range servers {
+		if group, ok := s.(*BroadcastGroup); ok {
+			for client, ring := range group.rings {
+				b.rings[client] = ring
+			}
+			for _, child := range group.Children() {
+				b.nested[child.Name()] = group
+			}
+		}
+	}
+
 	b.logger.Debug("created broadcast group",
 		zap.String("group_name", b.groupName),
 		zap.Strings("clients", b.servers),
@@ -79,6 +113,36 @@ func (bg BroadcastGroup) Backends() []string {
 	return bg.servers
 }
 
+// allowedChildren returns children that request may query, see ctx.SetAllowedBackends. Names are the names of
+// the groups or of the children themselves, e.x. servers of broadcast groups. Restriction is applied by the top-level
+// group only, so it's removed from returned context.
+func (bg *BroadcastGroup) allowedChildren(ctx context.Context) (context.Context, []types.ServerClient) {
+	allowed := util.GetAllowedBackends(ctx)
+	if allowed == nil {
+		return ctx, bg.Children()
+	}
+
+	names := make(map[string]struct{}, len(allowed))
+	for _, name := range allowed {
+		names[name] = struct{}{}
+	}
+	clients := make([]types.ServerClient, 0, len(allowed))
+	seen := make(map[types.ServerClient]struct{})
+	for _, group := range bg.clients {
+		_, groupAllowed := names[group.Name()]
+		for _, client := range group.Children() {
+			if _, ok := seen[client]; ok {
+				continue
+			}
+			if _, ok := names[client.Name()]; ok || groupAllowed {
+				seen[client] = struct{}{}
+				clients = append(clients, client)
+			}
+		}
+	}
+	return util.SetAllowedBackends(ctx, nil), clients
+}
+
 func (bg *BroadcastGroup) filterServersByTLD(requests []string, clients []types.ServerClient) []types.ServerClient {
 	tldClients := make(map[types.ServerClient]bool)
 	for _, request := range requests {
@@ -128,66 +192,264 @@ func (bg *BroadcastGroup) doSingleFetch(ctx context.Context, logger *zap.Logger,
 	logger.Debug("got slot")
 	defer bg.limiter.Leave(ctx, client.Name())
 
-	uuid := util.GetUUID(ctx)
+	opts := types.MergeOptionsFromContext(ctx)
 	for _, req := range requests {
 		logger.Debug("sending request",
 			zap.String("client_name", client.Name()),
 		)
 		r := types.NewServerFetchResponse()
 		r.Response, r.Stats, r.Err = client.Fetch(ctx, req)
-		response.Merge(r, uuid)
+		response.Merge(r, opts)
 	}
 
 	resCh <- response
 }
 
-func (bg *BroadcastGroup) SplitRequest(ctx context.Context, request *protov3.MultiFetchRequest) []*protov3.MultiFetchRequest {
+// SetExpandGlobs makes globs of fetch requests expanded with find request first, so every backend is only asked for
+// metrics it has. By default fetch requests are sent to every backend as they are.
+func (bg *BroadcastGroup) SetExpandGlobs(expandGlobs bool) {
+	bg.expandGlobs = expandGlobs
+}
+
+// SetQuorum makes fetch requests fail unless at least quorum of the clients have answered successfully
+func (bg *BroadcastGroup) SetQuorum(quorum int) {
+	bg.quorum = quorum
+}
+
+// SetHashing makes fetch requests for metrics without globs go only to the clients that own them according to
+// carbon consistent hashing, instead of resolving them with find request on every client. It must be set before
+// the group is added to another one.
+func (bg *BroadcastGroup) SetHashing(config types.Hashing) error {
+	ring, err := newHashRing(config, bg.servers)
+	if err != nil {
+		return err
+	}
+	ring.clients = bg.clients
+	for _, client := range bg.clients {
+		for _, child := range client.Children() {
+			bg.rings[child] = ring
+		}
+	}
+	return nil
+}
+
+// SetRoutes makes requests for metrics under prefix of the route go only to the groups of the route, see
+// types.Route. Routes must refer to the groups of this broadcast group.
+func (bg *BroadcastGroup) SetRoutes(routes []types.Route) error {
+	t, err := newRoutingTable(routes, bg.clients)
+	if err != nil {
+		return err
+	}
+	bg.routes = t
+	return nil
+}
+
+// SetStorageTiers makes render requests go only to the groups of the tiers that have requested time range, see
+// types.StorageTiers. Tiers must refer to the groups of this broadcast group.
+func (bg *BroadcastGroup) SetStorageTiers(config types.StorageTiers) error {
+	t, err := newStorageTiers(config, bg.clients)
+	if err != nil {
+		return err
+	}
+	bg.tiers = t
+	return nil
+}
+
+// SetFailover makes requests go to the standby cluster of the groups when the primary one is unavailable, see
+// types.Failover. Clusters must refer to the groups of this broadcast group.
+func (bg *BroadcastGroup) SetFailover(config types.Failover) error {
+	f, err := newFailover(config, bg.clients, bg.health, bg.logger)
+	if err != nil {
+		return err
+	}
+	bg.failover = f
+	return nil
+}
+
+// splitRequest splits metrics into requests that contain at most MaxMetricsPerRequest metrics
+func (bg *BroadcastGroup) splitRequest(metrics []protov3.FetchRequest) []*protov3.MultiFetchRequest {
 	if bg.MaxMetricsPerRequest() == 0 {
-		return []*protov3.MultiFetchRequest{request}
+		return []*protov3.MultiFetchRequest{{Metrics: metrics}}
 	}
 
 	var requests []*protov3.MultiFetchRequest
-	for _, metric := range request.Metrics {
-		newRequest := &protov3.MultiFetchRequest{}
-
-		f, _, e := bg.Find(ctx, &protov3.MultiGlobRequest{Metrics: []string{metric.Name}})
-		if (e != nil && e.HaveFatalErrors && len(e.Errors) > 0) || f == nil || len(f.Metrics) == 0 {
-			bg.logger.Warn("Find request failed when resolving globs",
-				zap.String("metric_name", metric.Name),
-				zap.Any("errors", e.Errors),
-			)
+	for len(metrics) > 0 {
+		n := bg.MaxMetricsPerRequest()
+		if n > len(metrics) {
+			n = len(metrics)
+		}
+		requests = append(requests, &protov3.MultiFetchRequest{Metrics: metrics[:n]})
+		metrics = metrics[n:]
+	}
+	return requests
+}
 
-			if f == nil {
-				continue
+type routeFindResult struct {
+	client   types.ServerClient
+	metric   int
+	response *protov3.MultiGlobResponse
+	err      *errors.Errors
+}
+
+// routeRequest returns fetch requests for every client. If expandGlobs is set, globs are expanded with find request
+// to every client first and each client only gets metrics it has. Clients that failed to answer find request get
+// original metric. Metrics without globs are sent to hashed clients only if they own them.
+func (bg *BroadcastGroup) routeRequest(ctx context.Context, request *protov3.MultiFetchRequest, clients []types.ServerClient) (map[types.ServerClient][]*protov3.MultiFetchRequest, int) {
+	routes := make(map[types.ServerClient][]*protov3.MultiFetchRequest, len(clients))
+	if !bg.expandGlobs && len(bg.rings) == 0 {
+		for _, client := range clients {
+			routes[client] = []*protov3.MultiFetchRequest{request}
+		}
+		return routes, 0
+	}
+
+	metrics := make(map[types.ServerClient][]protov3.FetchRequest, len(clients))
+	// metrics that clients should resolve
+	resolve := bg.hashMetrics(request, clients, metrics)
+
+	findRequests := 0
+	if !bg.expandGlobs {
+		for client, idx := range resolve {
+			for _, i := range idx {
+				metrics[client] = append(metrics[client], request.Metrics[i])
 			}
 		}
+	} else {
+		findRequests = bg.resolveGlobs(ctx, request, resolve, metrics)
+	}
 
-		for _, m := range f.Metrics {
-			for _, match := range m.Matches {
-				newRequest.Metrics = append(newRequest.Metrics, protov3.FetchRequest{
-					Name:            match.Path,
-					StartTime:       metric.StartTime,
-					StopTime:        metric.StopTime,
-					PathExpression:  metric.PathExpression,
-					FilterFunctions: metric.FilterFunctions,
-				})
+	for _, client := range clients {
+		if len(metrics[client]) > 0 {
+			routes[client] = bg.splitRequest(metrics[client])
+		}
+	}
+	return routes, findRequests
+}
 
-				if len(newRequest.Metrics) == bg.MaxMetricsPerRequest() {
-					requests = append(requests, newRequest)
-					newRequest = &protov3.MultiFetchRequest{}
+// hashMetrics puts metrics without globs to the clients that own them according to the hash ring of their group,
+// other metrics should be resolved by the clients
+func (bg *BroadcastGroup) hashMetrics(request *protov3.MultiFetchRequest, clients []types.ServerClient, metrics map[types.ServerClient][]protov3.FetchRequest) map[types.ServerClient][]int {
+	resolve := make(map[types.ServerClient][]int, len(clients))
+	for i, metric := range request.Metrics {
+		literal := len(bg.rings) > 0 && !strings.ContainsAny(metric.Name, globChars)
+		owners := make(map[*hashRing]map[types.ServerClient]struct{})
+		for _, client := range clients {
+			ring, ok := bg.rings[client]
+			if !ok || !literal {
+				resolve[client] = append(resolve[client], i)
+				continue
+			}
+			if _, ok := owners[ring]; !ok {
+				owners[ring] = make(map[types.ServerClient]struct{})
+				for _, owner := range ring.owners(metric.Name) {
+					owners[ring][ring.clients[owner]] = struct{}{}
 				}
 			}
+			if _, ok := owners[ring][client]; ok {
+				metrics[client] = append(metrics[client], metric)
+			}
 		}
+	}
+	return resolve
+}
+
+// resolveGlobs sends find request for every glob to the clients that should resolve it and puts found metrics to
+// metrics of the client. Metrics without globs are put as they are. Returns amount of find requests.
+func (bg *BroadcastGroup) resolveGlobs(ctx context.Context, request *protov3.MultiFetchRequest, resolve map[types.ServerClient][]int, metrics map[types.ServerClient][]protov3.FetchRequest) int {
+	ctx, cancel := context.WithTimeout(ctx, bg.timeout.Find)
+	defer cancel()
 
-		if len(newRequest.Metrics) > 0 {
-			requests = append(requests, newRequest)
+	globs := make(map[types.ServerClient][]int, len(resolve))
+	findRequests := 0
+	for client, idx := range resolve {
+		for _, i := range idx {
+			if !strings.ContainsAny(request.Metrics[i].Name, globChars) {
+				metrics[client] = append(metrics[client], request.Metrics[i])
+				continue
+			}
+			globs[client] = append(globs[client], i)
+			findRequests++
 		}
 	}
 
-	return requests
+	resCh := make(chan routeFindResult, findRequests)
+	for client, idx := range globs {
+		for _, i := range idx {
+			go func(i int, client types.ServerClient) {
+				r := routeFindResult{client: client, metric: i}
+				if err := bg.limiter.Enter(ctx, client.Name()); err != nil {
+					r.err = errors.FromErrNonFatal(err)
+					resCh <- r
+					return
+				}
+				r.response, _, r.err = client.Find(ctx, &protov3.MultiGlobRequest{Metrics: []string{request.Metrics[i].Name}})
+				bg.limiter.Leave(ctx, client.Name())
+				resCh <- r
+			}(i, client)
+		}
+	}
+
+	answered := make(map[types.ServerClient]map[int]struct{}, len(globs))
+	for client := range globs {
+		answered[client] = make(map[int]struct{})
+	}
+
+GATHER:
+	for responses := 0; responses < findRequests; responses++ {
+		select {
+		case r := <-resCh:
+			if r.err != nil && len(r.err.Errors) > 0 && !types.IsNotFound(r.err) && (r.response == nil || len(r.response.Metrics) == 0) {
+				// let this client resolve glob by itself
+				continue
+			}
+			answered[r.client][r.metric] = struct{}{}
+			if r.response == nil {
+				continue
+			}
+			metric := request.Metrics[r.metric]
+			for _, m := range r.response.Metrics {
+				for _, match := range m.Matches {
+					if !match.IsLeaf {
+						continue
+					}
+					metrics[r.client] = append(metrics[r.client], protov3.FetchRequest{
+						Name:            match.Path,
+						StartTime:       metric.StartTime,
+						StopTime:        metric.StopTime,
+						PathExpression:  metric.PathExpression,
+						FilterFunctions: metric.FilterFunctions,
+					})
+				}
+			}
+		case <-ctx.Done():
+			bg.logger.Warn("timeout while resolving globs, sending them as is to clients that haven't answered")
+			break GATHER
+		}
+	}
+
+	for client, idx := range globs {
+		for _, i := range idx {
+			if _, ok := answered[client][i]; !ok {
+				metrics[client] = append(metrics[client], request.Metrics[i])
+			}
+		}
+	}
+	return findRequests
 }
 
 func (bg *BroadcastGroup) Fetch(ctx context.Context, request *protov3.MultiFetchRequest) (*protov3.MultiFetchResponse, *types.Stats, *errors.Errors) {
+	_, res, stats, err := bg.fetch(ctx, request, false)
+	return res, stats, err
+}
+
+// FetchWithPassthrough is Fetch that returns carbonapi_v2_pb response of the backend without decoding it, if it's
+// the only backend that has requested metrics and it supports that (see types.PassthroughFetcher). Otherwise
+// responses are decoded and merged as usual.
+func (bg *BroadcastGroup) FetchWithPassthrough(ctx context.Context, request *protov3.MultiFetchRequest) ([]byte, *protov3.MultiFetchResponse, *types.Stats, *errors.Errors) {
+	return bg.fetch(ctx, request, true)
+}
+
+func (bg *BroadcastGroup) fetch(ctx context.Context, request *protov3.MultiFetchRequest, passthrough bool) ([]byte, *protov3.MultiFetchResponse, *types.Stats, *errors.Errors) {
 	requestNames := make([]string, 0, len(request.Metrics))
 	for i := range request.Metrics {
 		requestNames = append(requestNames, request.Metrics[i].Name)
@@ -195,16 +457,113 @@ func (bg *BroadcastGroup) Fetch(ctx context.Context, request *protov3.MultiFetch
 	logger := bg.logger.With(zap.String("type", "fetch"), zap.Strings("request", requestNames))
 	logger.Debug("will try to fetch data")
 
-	clients := bg.filterServersByTLD(requestNames, bg.Children())
-	requests := bg.SplitRequest(ctx, request)
-	zipperRequests, totalMetricsCount := getFetchRequestMetricStats(requests, bg, clients)
+	t0 := time.Now()
+	ctx, clients := bg.allowedChildren(ctx)
+	clients = bg.failover.filter(clients)
+	clients = bg.routes.filter(requestNames, clients)
+	parts := bg.tiers.parts(request, clients, time.Now().Unix())
+	if len(parts) > 1 {
+		res, stats, err := bg.fetchParts(ctx, logger, request, parts)
+		return nil, res, stats, err
+	}
+	return bg.fetchFromClients(ctx, logger, parts[0].request, parts[0].clients, passthrough, t0)
+}
+
+// fetchParts fetches parts of the request from their storage tiers and stitches the responses together, see
+// stitchParts. Parts that failed are reported as non-fatal errors if some of the others have returned data.
+func (bg *BroadcastGroup) fetchParts(ctx context.Context, logger *zap.Logger, request *protov3.MultiFetchRequest, parts []tierPart) (*protov3.MultiFetchResponse, *types.Stats, *errors.Errors) {
+	resCh := make(chan *types.ServerFetchResponse, len(parts))
+	for _, part := range parts {
+		go func(part tierPart) {
+			r := types.NewServerFetchResponse()
+			_, r.Response, r.Stats, r.Err = bg.fetchFromClients(ctx, logger, part.request, part.clients, false, time.Now())
+			resCh <- r
+		}(part)
+	}
+
+	result := types.NewServerFetchResponse()
+	opts := types.MergeOptionsFromContext(ctx)
+	var firstErr *errors.Errors
+	responses := make([]*types.ServerFetchResponse, 0, len(parts))
+	for range parts {
+		r := <-resCh
+		if r.Stats != nil {
+			result.Stats.Merge(r.Stats)
+			result.Stats.ZipperRequests += r.Stats.ZipperRequests
+			result.Stats.TotalMetricsCount += r.Stats.TotalMetricsCount
+		}
+		if r.Response == nil {
+			if firstErr == nil && r.Err != nil {
+				firstErr = r.Err
+			}
+			if r.Err != nil && !types.IsNotFound(r.Err) {
+				result.Err.Errors = append(result.Err.Errors, r.Err.Errors...)
+			}
+			continue
+		}
+		r.Err = nil
+		responses = append(responses, r)
+	}
+	stitchParts(request, responses, opts)
+	for _, r := range responses {
+		result.Merge(r, opts)
+	}
+
+	if len(result.Response.Metrics) == 0 {
+		if firstErr == nil {
+			firstErr = errors.FromErr(types.ErrNotFound)
+		}
+		return nil, result.Stats, firstErr
+	}
+	logger.Debug("stitched responses of storage tiers",
+		zap.Int("parts", len(parts)),
+		zap.Int("response_count", len(result.Response.Metrics)),
+	)
+	return result.Response, result.Stats, result.Err
+}
+
+// fetchFromClients fetches the request from the clients and merges their responses
+func (bg *BroadcastGroup) fetchFromClients(ctx context.Context, logger *zap.Logger, request *protov3.MultiFetchRequest, clients []types.ServerClient, passthrough bool, t0 time.Time) ([]byte, *protov3.MultiFetchResponse, *types.Stats, *errors.Errors) {
+	requestNames := make([]string, 0, len(request.Metrics))
+	for i := range request.Metrics {
+		requestNames = append(requestNames, request.Metrics[i].Name)
+	}
+	clients = bg.filterServersByTLD(requestNames, clients)
+	routes, findRequests := bg.routeRequest(ctx, request, clients)
+	phases.Since(phases.Routing, t0)
 
 	result := types.NewServerFetchResponse()
-	result.Stats.ZipperRequests = int64(zipperRequests)
-	result.Stats.TotalMetricsCount = int64(totalMetricsCount)
+	result.Stats.ZipperRequests, result.Stats.TotalMetricsCount = getFetchRequestMetricStats(routes, findRequests)
+
+	if len(routes) == 0 {
+		logger.Debug("metrics not found")
+		return nil, nil, result.Stats, errors.FromErr(types.ErrNotFound)
+	}
+	if maxBackends := util.GetMaxBackends(ctx); maxBackends > 0 && len(routes) > maxBackends {
+		logger.Warn("request refused, it would touch too many backends",
+			zap.Int("backends", len(routes)),
+			zap.Int("max_backends", maxBackends),
+		)
+		return nil, nil, result.Stats, errors.FromErr(types.ErrTooManyBackends)
+	}
 
-	if len(requests) == 0 {
-		return result.Response, result.Stats, result.Err
+	if passthrough && len(routes) == 1 {
+		for client, requests := range routes {
+			fetcher, ok := client.(types.PassthroughFetcher)
+			if !ok || len(requests) != 1 {
+				break
+			}
+			raw, err := bg.fetchPassthrough(ctx, client, fetcher, requests[0], result.Stats)
+			if raw != nil || err != nil {
+				return raw, nil, result.Stats, err
+			}
+			// backend can't pass this request through, it's fetched as usual
+		}
+	}
+
+	clients = clients[:0:0]
+	for client := range routes {
+		clients = append(clients, client)
 	}
 	resCh := make(chan *types.ServerFetchResponse, len(clients))
 
@@ -212,20 +571,57 @@ func (bg *BroadcastGroup) Fetch(ctx context.Context, request *protov3.MultiFetch
 	defer cancel()
 
 	for _, client := range clients {
-		go bg.doSingleFetch(ctx, logger, client, requests, resCh)
+		go bg.doSingleFetch(ctx, logger, client, routes[client], resCh)
 	}
 
 	answeredServers := make(map[string]struct{})
 	responseCount := 0
-	uuid := util.GetUUID(ctx)
+	opts := types.MergeOptionsFromContext(ctx)
+
+	succeeded := make(map[string]bool, len(clients))
+	quorum := bg.newQuorum(clients)
+
+	var firstResponse time.Time
+	var afterFirstResponse <-chan time.Time
+	responses := make([]*types.ServerFetchResponse, 0, len(clients))
+	gatherStart := time.Now()
 
 GATHER:
 	for responseCount < len(clients) {
 		select {
 		case res := <-resCh:
 			answeredServers[res.Server] = struct{}{}
-			result.Merge(res, uuid)
+			responses = append(responses, res)
 			responseCount++
+			if !types.HaveFailures(res.Err) {
+				succeeded[res.Server] = true
+			}
+
+			if firstResponse.IsZero() {
+				firstResponse = time.Now()
+				// strict requests can't skip slow backends, they'd get partial data
+				if wait := bg.spread.Wait(); wait > 0 && !util.GetStrict(ctx) {
+					timer := time.NewTimer(wait)
+					defer timer.Stop()
+					afterFirstResponse = timer.C
+				}
+			} else {
+				bg.spread.Add(time.Since(firstResponse))
+			}
+
+		case <-afterFirstResponse:
+			if quorum.waiting(succeeded, answeredServers) {
+				// slow backends are still needed to reach the quorum
+				afterFirstResponse = nil
+				continue
+			}
+			logger.Debug("stopped waiting for slow backends",
+				zap.Duration("since_first_response", time.Since(firstResponse)),
+				zap.Strings("no_answers_from", noAnswerClients(clients, answeredServers)),
+			)
+			bg.spread.Censored(len(clients) - responseCount)
+
+			break GATHER
 
 		case <-ctx.Done():
 			logger.Warn("timeout waiting for more responses",
@@ -236,14 +632,74 @@ GATHER:
 			break GATHER
 		}
 	}
+	phases.Observe(phases.FanOutWait, time.Since(gatherStart))
+
+	tMerge := time.Now()
+	for _, name := range noAnswerClients(clients, answeredServers) {
+		bg.health.Observe(name, false)
+		result.Stats.FailedServers = append(result.Stats.FailedServers, name)
+	}
+	trackSources := util.GetTrackSources(ctx)
+	for _, res := range responses {
+		// nested groups report their own failed servers and sources, they are merged with the stats below
+		if types.HaveFailures(res.Err) && len(res.Stats.FailedServers) == 0 {
+			result.Stats.FailedServers = append(result.Stats.FailedServers, res.Server)
+		}
+		if trackSources && len(res.Stats.Sources) == 0 {
+			addSources(result.Stats, res)
+		}
+	}
+	groups := quorum.succeeded(succeeded)
+	if !quorum.reached(groups) {
+		logger.Warn("quorum not reached",
+			zap.Int("quorum", quorum.quorum),
+			zap.Int("succeeded", len(groups)),
+			zap.Strings("no_answers_from", noAnswerClients(clients, answeredServers)),
+		)
+		return nil, nil, result.Stats, errors.FromErr(types.ErrQuorumNotReached)
+	}
+	// nested group that hasn't reached its own quorum fails as a whole, as if it was asked by itself
+	if failed := quorum.failedNested(groups); len(failed) > 0 {
+		logger.Warn("quorum of nested groups not reached",
+			zap.Strings("nested_groups", failed),
+		)
+		result.Err.Add(types.ErrQuorumNotReached)
+		kept := responses[:0]
+		for _, res := range responses {
+			if groups[quorum.groups[res.Server]] || quorum.quorums[quorum.groups[res.Server]] == 0 {
+				kept = append(kept, res)
+			}
+		}
+		responses = kept
+	}
+	bg.health.observe(responses)
+	if opts.Policy != types.MergePolicyFirst {
+		bg.health.sortByHealth(responses)
+	}
+	if opts.Policy == types.MergePolicyMajority {
+		if divergent := types.VoteFetchResponses(responses); divergent > 0 {
+			logger.Debug("replicas returned conflicting points",
+				zap.Int("divergent_points", divergent),
+			)
+		}
+	}
+	for _, res := range responses {
+		result.Merge(res, opts)
+	}
+	phases.Since(phases.Merge, tMerge)
 
 	if len(result.Response.Metrics) == 0 {
+		if types.IsNotFound(result.Err) || (len(result.Err.Errors) == 0 && result.Stats.NotFound > 0) {
+			logger.Debug("metrics not found")
+			return nil, nil, result.Stats, errors.FromErr(types.ErrNotFound)
+		}
+
 		logger.Debug("failed to get any response")
 
 		// TODO(gmagnusson): We'll only see this on the root bg group now.
 		// Let's make this message more useful by logging the request, what
 		// hosts we hit, etc.
-		return nil, nil, errors.Fatalf("failed to get any response from backend group: %v", bg.groupName)
+		return nil, nil, nil, errors.Fatalf("failed to get any response from backend group: %v", bg.groupName)
 	}
 
 	logger.Debug("got some fetch responses",
@@ -254,19 +710,56 @@ GATHER:
 		zap.Int("response_count", len(result.Response.Metrics)),
 	)
 
-	return result.Response, result.Stats, result.Err
+	return nil, result.Response, result.Stats, result.Err
 }
 
-func getFetchRequestMetricStats(requests []*protov3.MultiFetchRequest, bg *BroadcastGroup, clients []types.ServerClient) (int, int) {
-	totalMetricsCount := (len(requests)-1)*bg.MaxMetricsPerRequest() + len(requests[len(requests)-1].Metrics)
-	var zipperRequests int
-	if bg.MaxMetricsPerRequest() > 0 {
-		zipperRequests = 1
+// fetchPassthrough fetches the only request of the only client that has requested metrics. Nil response without
+// errors means that client can't pass it through. Failures are reported the same way as in Fetch.
+func (bg *BroadcastGroup) fetchPassthrough(ctx context.Context, client types.ServerClient, fetcher types.PassthroughFetcher, request *protov3.MultiFetchRequest, stats *types.Stats) ([]byte, *errors.Errors) {
+	ctx, cancel := context.WithTimeout(ctx, bg.timeout.Render)
+	defer cancel()
+
+	if err := bg.limiter.Enter(ctx, client.Name()); err != nil {
+		stats.FailedServers = append(stats.FailedServers, client.Name())
+		return nil, errors.FromErr(err)
+	}
+	raw, s, err := fetcher.FetchPassthrough(ctx, request)
+	bg.limiter.Leave(ctx, client.Name())
+	if s != nil {
+		stats.Merge(s)
+	}
+
+	if types.IsNotFound(err) {
+		bg.health.Observe(client.Name(), true)
+		return nil, errors.FromErr(types.ErrNotFound)
 	}
-	if len(requests) > 0 {
-		zipperRequests += len(requests) * len(clients)
+	if types.HaveFailures(err) {
+		bg.health.Observe(client.Name(), false)
+		stats.FailedServers = append(stats.FailedServers, client.Name())
+		if bg.quorum > 0 {
+			return nil, errors.FromErr(types.ErrQuorumNotReached)
+		}
+		return nil, errors.Fatalf("failed to get any response from backend group: %v", bg.groupName)
 	}
-	return zipperRequests, totalMetricsCount
+	if raw != nil {
+		bg.health.Observe(client.Name(), true)
+	}
+	return raw, nil
+}
+
+// getFetchRequestMetricStats returns amount of requests sent to the backends and amount of distinct metrics requested
+func getFetchRequestMetricStats(routes map[types.ServerClient][]*protov3.MultiFetchRequest, findRequests int) (int64, int64) {
+	zipperRequests := int64(findRequests)
+	metrics := make(map[string]struct{})
+	for _, requests := range routes {
+		zipperRequests += int64(len(requests))
+		for _, r := range requests {
+			for _, m := range r.Metrics {
+				metrics[m.Name] = struct{}{}
+			}
+		}
+	}
+	return zipperRequests, int64(len(metrics))
 }
 
 // Find request handling
@@ -301,7 +794,13 @@ func (bg *BroadcastGroup) doFind(ctx context.Context, logger *zap.Logger, client
 func (bg *BroadcastGroup) Find(ctx context.Context, request *protov3.MultiGlobRequest) (*protov3.MultiGlobResponse, *types.Stats, *errors.Errors) {
 	logger := bg.logger.With(zap.String("type", "find"), zap.Strings("request", request.Metrics))
 
-	clients := bg.Children()
+	ctx, clients := bg.allowedChildren(ctx)
+	clients = bg.failover.filter(clients)
+	clients = bg.routes.filter(request.Metrics, clients)
+	if len(clients) == 0 {
+		logger.Debug("request isn't allowed to query any backends")
+		return &protov3.MultiGlobResponse{}, &types.Stats{}, nil
+	}
 	resCh := make(chan *types.ServerFindResponse, len(clients))
 
 	logger.Debug("will do query with timeout",
@@ -344,6 +843,9 @@ GATHER:
 	}
 
 	if len(result.Response.Metrics) == 0 {
+		if types.IsNotFound(result.Err) {
+			return &protov3.MultiGlobResponse{}, result.Stats, nil
+		}
 		return &protov3.MultiGlobResponse{}, result.Stats, result.Err.Addf("failed to fetch response from the server %v", bg.groupName)
 	}
 	result.Stats.TotalMetricsCount = 0
@@ -392,7 +894,13 @@ func (bg *BroadcastGroup) Info(ctx context.Context, request *protov3.MultiMetric
 	ctx, cancel := context.WithTimeout(ctx, bg.timeout.Find)
 	defer cancel()
 
-	clients := bg.Children()
+	ctx, clients := bg.allowedChildren(ctx)
+	clients = bg.failover.filter(clients)
+	clients = bg.routes.filter(request.Names, clients)
+	if len(clients) == 0 {
+		logger.Debug("request isn't allowed to query any backends")
+		return &protov3.ZipperInfoResponse{}, &types.Stats{}, nil
+	}
 	resCh := make(chan *types.ServerInfoResponse, len(clients))
 	for _, client := range clients {
 		go bg.doInfoRequest(ctx, logger, request, client, resCh)
@@ -432,8 +940,80 @@ GATHER:
 	return result.Response, result.Stats, result.Err
 }
 
+type listResponse struct {
+	server   string
+	response *protov3.ListMetricsResponse
+	stats    *types.Stats
+	err      *errors.Errors
+}
+
+func (bg *BroadcastGroup) doList(ctx context.Context, client types.ServerClient, resCh chan<- listResponse) {
+	r := listResponse{server: client.Name()}
+	if err := bg.limiter.Enter(ctx, client.Name()); err != nil {
+		r.err = errors.FromErrNonFatal(types.ErrTimeoutExceeded)
+		resCh <- r
+		return
+	}
+	defer bg.limiter.Leave(ctx, client.Name())
+
+	r.response, r.stats, r.err = client.List(ctx)
+	resCh <- r
+}
+
+// List returns deduplicated list of all metrics known to any of the children
 func (bg *BroadcastGroup) List(ctx context.Context) (*protov3.ListMetricsResponse, *types.Stats, *errors.Errors) {
-	return nil, nil, errors.FromErr(types.ErrNotImplementedYet)
+	logger := bg.logger.With(zap.String("type", "list"))
+
+	ctx, cancel := context.WithTimeout(ctx, bg.timeout.Render)
+	defer cancel()
+
+	clients := bg.Children()
+	resCh := make(chan listResponse, len(clients))
+	for _, client := range clients {
+		go bg.doList(ctx, client, resCh)
+	}
+
+	result := &protov3.ListMetricsResponse{}
+	stats := &types.Stats{ZipperRequests: int64(len(clients))}
+	e := &errors.Errors{}
+	seen := make(map[string]struct{})
+	answeredServers := make(map[string]struct{})
+GATHER:
+	for len(answeredServers) < len(clients) {
+		select {
+		case res := <-resCh:
+			answeredServers[res.server] = struct{}{}
+			if res.stats != nil {
+				stats.Merge(res.stats)
+			}
+			if res.err != nil {
+				e.Merge(res.err)
+			}
+			if res.response == nil {
+				continue
+			}
+			for _, m := range res.response.Metrics {
+				if _, ok := seen[m]; !ok {
+					seen[m] = struct{}{}
+					result.Metrics = append(result.Metrics, m)
+				}
+			}
+		case <-ctx.Done():
+			logger.Warn("timeout waiting for more responses",
+				zap.Strings("no_answers_from", noAnswerClients(clients, answeredServers)),
+			)
+			e.Add(types.ErrTimeoutExceeded)
+			break GATHER
+		}
+	}
+
+	if len(result.Metrics) == 0 && len(e.Errors) > 0 {
+		e.HaveFatalErrors = true
+		return nil, stats, e.Addf("failed to list metrics in the group %v", bg.groupName)
+	}
+	// some of the backends have answered, so errors of the others are not fatal anymore
+	e.HaveFatalErrors = false
+	return result, stats, e
 }
 func (bg *BroadcastGroup) Stats(ctx context.Context) (*protov3.MetricDetailsResponse, *types.Stats, *errors.Errors) {
 	return nil, nil, errors.FromErr(types.ErrNotImplementedYet)
@@ -483,7 +1063,10 @@ GATHER:
 		case r := <-resCh:
 			answeredServers[r.server.Name()] = struct{}{}
 			responses++
-			if r.err != nil && len(r.err.Errors) > 0 {
+			// servers that don't get requests because of failover are only checked by the probe
+			failed := r.err != nil && len(r.err.Errors) > 0
+			bg.health.Observe(r.server.Name(), !failed)
+			if failed {
 				err.Merge(r.err)
 				continue
 			}
@@ -497,6 +1080,9 @@ GATHER:
 				zap.Strings("no_answers_from", noAnswerClients(clients, answeredServers)),
 			)
 			err.Add(types.ErrTimeoutExceeded)
+			for _, name := range noAnswerClients(clients, answeredServers) {
+				bg.health.Observe(name, false)
+			}
 			break GATHER
 		}
 	}
@@ -510,6 +1096,7 @@ GATHER:
 		for k, v := range cache {
 			bg.pathCache.Set(k, v)
 		}
+		bg.paths.set(cache)
 	} else {
 		logger.Error("Setting path cache in non root bg group. somethings off!")
 	}
@@ -517,6 +1104,21 @@ GATHER:
 	return tlds, &err
 }
 
+// addSources records server as a source of every series it returned at least one point for
+func addSources(stats *types.Stats, res *types.ServerFetchResponse) {
+	if res.Response == nil {
+		return
+	}
+	for i := range res.Response.Metrics {
+		for _, v := range res.Response.Metrics[i].Values {
+			if !math.IsNaN(v) {
+				stats.AddSource(res.Response.Metrics[i].Name, res.Server)
+				break
+			}
+		}
+	}
+}
+
 func noAnswerClients(clients []types.ServerClient, answered map[string]struct{}) []string {
 	noAnswer := make([]string, 0)
 	for _, s := range clients {
@@ -9,6 +9,7 @@ import (
 	"testing"
 	"time"
 
+	util "github.com/go-graphite/carbonapi/util/ctx"
 	"github.com/go-graphite/carbonapi/zipper/dummy"
 	"github.com/go-graphite/carbonapi/zipper/errors"
 	"github.com/go-graphite/carbonapi/zipper/types"
@@ -35,9 +36,9 @@ func init() {
 
 	logger = zapwriter.Logger("test")
 	timeouts = types.Timeouts{
-		1000 * time.Second,
-		1000 * time.Second,
-		1000 * time.Second,
+		Find:    1000 * time.Second,
+		Render:  1000 * time.Second,
+		Connect: 1000 * time.Second,
 	}
 }
 
@@ -173,6 +174,24 @@ func TestProbeTLDs(t *testing.T) {
 	}
 }
 
+func TestProbeTLDsPaths(t *testing.T) {
+	c1 := dummy.NewDummyClient("client1", []string{"backend1"}, 1)
+	c1.SetTLDResponse(dummy.ProbeResponse{Response: []string{"a", "b"}})
+	c2 := dummy.NewDummyClient("client2", []string{"backend2"}, 1)
+	c2.SetTLDResponse(dummy.ProbeResponse{Response: []string{"a"}})
+	b, err := NewBroadcastGroup(logger, "root", []types.ServerClient{c1, c2}, 60, 500, timeouts)
+	if err != nil && err.HaveFatalErrors {
+		t.Fatalf("error while initializing group: %v", err)
+	}
+
+	// paths that probe has found are saved with the state
+	b.ProbeTLDs(context.Background())
+	expected := map[string][]string{"a": {"client1", "client2"}, "b": {"client1"}}
+	if got := b.State().Paths; !reflect.DeepEqual(got, expected) {
+		t.Fatalf("unexpected paths %v, expected %v", got, expected)
+	}
+}
+
 type testCaseFetch struct {
 	name           string
 	servers        []types.ServerClient
@@ -1192,3 +1211,379 @@ func TestFetchRequests(t *testing.T) {
 		})
 	}
 }
+
+func TestFetchRouting(t *testing.T) {
+	series := func(name string) protov3.FetchResponse {
+		return protov3.FetchResponse{
+			Name:           name,
+			PathExpression: "foo.*",
+			StartTime:      0,
+			StopTime:       120,
+			StepTime:       60,
+			Values:         []float64{0, 1, 2},
+		}
+	}
+	request := &protov3.MultiFetchRequest{
+		Metrics: []protov3.FetchRequest{{Name: "foo.*", StartTime: 0, StopTime: 120, PathExpression: "foo.*"}},
+	}
+	expanded := &protov3.MultiFetchRequest{
+		Metrics: []protov3.FetchRequest{
+			{Name: "foo.a", StartTime: 0, StopTime: 120, PathExpression: "foo.*"},
+			{Name: "foo.b", StartTime: 0, StopTime: 120, PathExpression: "foo.*"},
+		},
+	}
+
+	tests := []struct {
+		expandGlobs bool
+		expected    []string
+	}{
+		{true, []string{"foo.a", "foo.b"}},
+		{false, []string{"foo.a", "foo.c"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("expandGlobs=%v", tt.expandGlobs), func(t *testing.T) {
+			client1 := dummy.NewDummyClient("client1", []string{"backend1"}, 1)
+			client2 := dummy.NewDummyClient("client2", []string{"backend2"}, 1)
+
+			client1.AddFetchResponse(expanded, &protov3.MultiFetchResponse{Metrics: []protov3.FetchResponse{series("foo.a"), series("foo.b")}}, &types.Stats{}, nil)
+			client1.AddFetchResponse(request, &protov3.MultiFetchResponse{Metrics: []protov3.FetchResponse{series("foo.a")}}, &types.Stats{}, nil)
+			// client2 doesn't have anything for that glob, it must not be asked for data if globs are expanded
+			client2.AddFetchResponse(request, &protov3.MultiFetchResponse{Metrics: []protov3.FetchResponse{series("foo.c")}}, &types.Stats{}, nil)
+			// fetch responses register find responses on their own, so those must be overridden afterwards
+			client1.AddFindResponse(&protov3.MultiGlobRequest{Metrics: []string{"foo.*"}}, &protov3.MultiGlobResponse{
+				Metrics: []protov3.GlobResponse{{
+					Name:    "foo.*",
+					Matches: []protov3.GlobMatch{{Path: "foo.a", IsLeaf: true}, {Path: "foo.b", IsLeaf: true}, {Path: "foo.dir"}},
+				}},
+			}, &types.Stats{}, nil)
+			client2.AddFindResponse(&protov3.MultiGlobRequest{Metrics: []string{"foo.*"}}, nil, &types.Stats{}, errors.FromErrNonFatal(types.ErrNotFound))
+
+			b, err := NewBroadcastGroup(logger, "test", []types.ServerClient{client1, client2}, 60, 500, timeouts)
+			if err != nil && err.HaveFatalErrors {
+				t.Fatalf("error while initializing group: %v", err)
+			}
+			b.SetExpandGlobs(tt.expandGlobs)
+
+			res, stats, err := b.Fetch(context.Background(), request)
+			if err != nil && err.HaveFatalErrors {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			var names []string
+			for _, m := range res.Metrics {
+				names = append(names, m.Name)
+			}
+			sort.Strings(names)
+			if !reflect.DeepEqual(names, tt.expected) {
+				t.Fatalf("got %v, expected %v", names, tt.expected)
+			}
+			if tt.expandGlobs && stats.ZipperRequests != 3 {
+				t.Fatalf("expected 2 find and 1 fetch requests, got %v", stats.ZipperRequests)
+			}
+		})
+	}
+}
+
+func TestFetchRoutingLiteral(t *testing.T) {
+	request := &protov3.MultiFetchRequest{
+		Metrics: []protov3.FetchRequest{{Name: "foo.a", StartTime: 0, StopTime: 120, PathExpression: "foo.a"}},
+	}
+	response := &protov3.MultiFetchResponse{
+		Metrics: []protov3.FetchResponse{{Name: "foo.a", PathExpression: "foo.a", StopTime: 120, StepTime: 60, Values: []float64{0, 1, 2}}},
+	}
+
+	client1 := dummy.NewDummyClient("client1", []string{"backend1"}, 1)
+	client2 := dummy.NewDummyClient("client2", []string{"backend2"}, 1)
+	client1.AddFetchResponse(request, response, &types.Stats{}, nil)
+	client2.AddFetchResponse(request, response, &types.Stats{}, nil)
+
+	b, err := NewBroadcastGroup(logger, "test", []types.ServerClient{client1, client2}, 60, 500, timeouts)
+	if err != nil && err.HaveFatalErrors {
+		t.Fatalf("error while initializing group: %v", err)
+	}
+	b.SetExpandGlobs(true)
+
+	res, stats, err := b.Fetch(context.Background(), request)
+	if err != nil && err.HaveFatalErrors {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(res.Metrics) != 1 || res.Metrics[0].Name != "foo.a" {
+		t.Fatalf("unexpected response %v", res.Metrics)
+	}
+	// metric without globs doesn't need find request
+	if stats.ZipperRequests != 2 {
+		t.Fatalf("expected 2 fetch requests, got %v", stats.ZipperRequests)
+	}
+}
+
+func TestFetchMaxBackends(t *testing.T) {
+	request := &protov3.MultiFetchRequest{
+		Metrics: []protov3.FetchRequest{{Name: "foo", StartTime: 0, StopTime: 120, PathExpression: "foo"}},
+	}
+	response := &protov3.MultiFetchResponse{
+		Metrics: []protov3.FetchResponse{{Name: "foo", PathExpression: "foo", StopTime: 120, StepTime: 60, Values: []float64{0, 1, 2}}},
+	}
+
+	var servers []types.ServerClient
+	for i := 1; i <= 3; i++ {
+		c := dummy.NewDummyClient(fmt.Sprintf("client%v", i), []string{fmt.Sprintf("backend%v", i)}, 1)
+		c.AddFetchResponse(request, response, &types.Stats{}, nil)
+		servers = append(servers, c)
+	}
+	b, err := NewBroadcastGroup(logger, "test", servers, 60, 500, timeouts)
+	if err != nil && err.HaveFatalErrors {
+		t.Fatalf("error while initializing group: %v", err)
+	}
+
+	_, _, err = b.Fetch(util.SetMaxBackends(context.Background(), 2), request)
+	if err == nil || !err.HaveFatalErrors || err.Errors[0] != types.ErrTooManyBackends {
+		t.Fatalf("expected too many backends error, got %v", err)
+	}
+
+	res, _, err := b.Fetch(util.SetMaxBackends(context.Background(), 3), request)
+	if (err != nil && err.HaveFatalErrors) || res == nil || len(res.Metrics) != 1 {
+		t.Fatalf("unexpected result %v, error %v", res, err)
+	}
+}
+
+func TestFetchQuorum(t *testing.T) {
+	request := &protov3.MultiFetchRequest{
+		Metrics: []protov3.FetchRequest{{Name: "foo", StartTime: 0, StopTime: 120, PathExpression: "foo"}},
+	}
+	response := &protov3.MultiFetchResponse{
+		Metrics: []protov3.FetchResponse{{Name: "foo", PathExpression: "foo", StopTime: 120, StepTime: 60, Values: []float64{0, 1, 2}}},
+	}
+
+	var servers []types.ServerClient
+	for i := 1; i <= 3; i++ {
+		c := dummy.NewDummyClient(fmt.Sprintf("client%v", i), []string{fmt.Sprintf("backend%v", i)}, 1)
+		if i == 3 {
+			c.AddFetchResponse(request, nil, &types.Stats{}, errors.FromErrNonFatal(types.ErrTimeoutExceeded))
+		} else {
+			c.AddFetchResponse(request, response, &types.Stats{}, nil)
+		}
+		servers = append(servers, c)
+	}
+	b, err := NewBroadcastGroup(logger, "test", servers, 60, 500, timeouts)
+	if err != nil && err.HaveFatalErrors {
+		t.Fatalf("error while initializing group: %v", err)
+	}
+
+	b.SetQuorum(3)
+	_, _, err = b.Fetch(context.Background(), request)
+	if err == nil || !err.HaveFatalErrors || err.Errors[0] != types.ErrQuorumNotReached {
+		t.Fatalf("expected quorum error, got %v", err)
+	}
+
+	b.SetQuorum(2)
+	res, stats, err := b.Fetch(context.Background(), request)
+	if (err != nil && err.HaveFatalErrors) || res == nil || len(res.Metrics) != 1 {
+		t.Fatalf("unexpected result %v, error %v", res, err)
+	}
+	if len(stats.FailedServers) != 1 || stats.FailedServers[0] != "client3" {
+		t.Fatalf("unexpected failed servers %v", stats.FailedServers)
+	}
+}
+
+func TestFetchQuorumNested(t *testing.T) {
+	request := &protov3.MultiFetchRequest{
+		Metrics: []protov3.FetchRequest{{Name: "foo", StartTime: 0, StopTime: 120, PathExpression: "foo"}},
+	}
+	response := &protov3.MultiFetchResponse{
+		Metrics: []protov3.FetchResponse{{Name: "foo", PathExpression: "foo", StopTime: 120, StepTime: 60, Values: []float64{0, 1, 2}}},
+	}
+
+	// shard1 has 2 of 3 replicas alive, shard2 has 1 of 2
+	newShard := func(name string, failed ...bool) *BroadcastGroup {
+		var servers []types.ServerClient
+		for i, f := range failed {
+			c := dummy.NewDummyClient(fmt.Sprintf("%v_client%v", name, i), []string{fmt.Sprintf("%v_backend%v", name, i)}, 1)
+			if f {
+				c.AddFetchResponse(request, nil, &types.Stats{}, errors.FromErrNonFatal(types.ErrTimeoutExceeded))
+			} else {
+				c.AddFetchResponse(request, response, &types.Stats{}, nil)
+			}
+			servers = append(servers, c)
+		}
+		g, err := NewBroadcastGroup(logger, name, servers, 60, 0, timeouts)
+		if err != nil && err.HaveFatalErrors {
+			t.Fatalf("error while initializing group: %v", err)
+		}
+		return g
+	}
+	shard1 := newShard("shard1", false, false, true)
+	shard2 := newShard("shard2", true, false)
+	root, err := NewBroadcastGroup(logger, "root", []types.ServerClient{shard1, shard2}, 60, 0, timeouts)
+	if err != nil && err.HaveFatalErrors {
+		t.Fatalf("error while initializing group: %v", err)
+	}
+
+	tests := []struct {
+		name                    string
+		root, shard1, shard2    int
+		fatal, quorumNotReached bool
+	}{
+		// quorum of the root counts groups, not their servers
+		{name: "groups answered", root: 2},
+		{name: "replicas answered", root: 2, shard1: 2, shard2: 1},
+		// shard that doesn't reach its quorum fails as a whole
+		{name: "shard failed", root: 1, shard1: 3, quorumNotReached: true},
+		{name: "root failed", root: 2, shard1: 3, fatal: true, quorumNotReached: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root.SetQuorum(tt.root)
+			shard1.SetQuorum(tt.shard1)
+			shard2.SetQuorum(tt.shard2)
+			res, _, err := root.Fetch(context.Background(), request)
+			fatal := err != nil && err.HaveFatalErrors
+			if fatal != tt.fatal || (!fatal && (res == nil || len(res.Metrics) != 1)) {
+				t.Fatalf("unexpected result %v, error %v", res, err)
+			}
+			quorumNotReached := false
+			if err != nil {
+				for _, e := range err.Errors {
+					quorumNotReached = quorumNotReached || e == types.ErrQuorumNotReached
+				}
+			}
+			if quorumNotReached != tt.quorumNotReached {
+				t.Fatalf("unexpected errors %v", err)
+			}
+		})
+	}
+}
+
+func TestFetchSources(t *testing.T) {
+	request := &protov3.MultiFetchRequest{
+		Metrics: []protov3.FetchRequest{{Name: "foo", StartTime: 0, StopTime: 120, PathExpression: "foo"}},
+	}
+	values := [][]float64{{0, 1, 2}, {math.NaN(), math.NaN(), math.NaN()}, {math.NaN(), 1, math.NaN()}}
+
+	var servers []types.ServerClient
+	for i := range values {
+		c := dummy.NewDummyClient(fmt.Sprintf("client%v", i), []string{fmt.Sprintf("backend%v", i)}, 1)
+		c.AddFetchResponse(request, &protov3.MultiFetchResponse{
+			Metrics: []protov3.FetchResponse{{Name: "foo", PathExpression: "foo", StopTime: 120, StepTime: 60, Values: values[i]}},
+		}, &types.Stats{}, nil)
+		servers = append(servers, c)
+	}
+	b, err := NewBroadcastGroup(logger, "test", servers, 60, 500, timeouts)
+	if err != nil && err.HaveFatalErrors {
+		t.Fatalf("error while initializing group: %v", err)
+	}
+
+	_, stats, _ := b.Fetch(context.Background(), request)
+	if stats.Sources != nil {
+		t.Fatalf("sources must not be tracked by default, got %v", stats.Sources)
+	}
+
+	_, stats, _ = b.Fetch(util.SetTrackSources(context.Background(), true), request)
+	sources := stats.Sources["foo"]
+	sort.Strings(sources)
+	if !reflect.DeepEqual(sources, []string{"client0", "client2"}) {
+		t.Fatalf("unexpected sources %v", stats.Sources)
+	}
+}
+
+func TestFetchAllowedBackends(t *testing.T) {
+	request := &protov3.MultiFetchRequest{
+		Metrics: []protov3.FetchRequest{{Name: "foo", StartTime: 0, StopTime: 120, PathExpression: "foo"}},
+	}
+
+	var servers []types.ServerClient
+	for i := 0; i < 2; i++ {
+		c := dummy.NewDummyClient(fmt.Sprintf("client%v", i), []string{fmt.Sprintf("backend%v", i)}, 1)
+		c.AddFetchResponse(request, &protov3.MultiFetchResponse{
+			Metrics: []protov3.FetchResponse{{Name: "foo", PathExpression: "foo", StopTime: 120, StepTime: 60, Values: []float64{float64(i), float64(i)}}},
+		}, &types.Stats{}, nil)
+		servers = append(servers, c)
+	}
+	b, err := NewBroadcastGroup(logger, "test", servers, 60, 500, timeouts)
+	if err != nil && err.HaveFatalErrors {
+		t.Fatalf("error while initializing group: %v", err)
+	}
+
+	ctx := util.SetAllowedBackends(context.Background(), []string{"client1"})
+	res, _, err := b.Fetch(ctx, request)
+	if (err != nil && err.HaveFatalErrors) || len(res.Metrics) != 1 || res.Metrics[0].Values[0] != 1 {
+		t.Fatalf("unexpected result %v, error %v", res, err)
+	}
+
+	ctx = util.SetAllowedBackends(context.Background(), []string{})
+	_, _, err = b.Fetch(ctx, request)
+	if err == nil || err.Errors[0] != types.ErrNotFound {
+		t.Fatalf("expected not found, got %v", err)
+	}
+
+	find, _, err := b.Find(ctx, &protov3.MultiGlobRequest{Metrics: []string{"foo"}})
+	if (err != nil && len(err.Errors) > 0) || len(find.Metrics) != 0 {
+		t.Fatalf("unexpected find result %v, error %v", find, err)
+	}
+
+	// servers of nested group are allowed by the name of the group
+	root, err := NewBroadcastGroup(logger, "root", []types.ServerClient{b}, 60, 0, timeouts)
+	if err != nil && err.HaveFatalErrors {
+		t.Fatalf("error while initializing group: %v", err)
+	}
+	ctx = util.SetAllowedBackends(context.Background(), []string{"test"})
+	res, stats, err := root.Fetch(ctx, request)
+	if (err != nil && err.HaveFatalErrors) || len(res.Metrics) != 1 || stats.ZipperRequests != 2 {
+		t.Fatalf("unexpected result %v, %v requests, error %v", res, stats.ZipperRequests, err)
+	}
+}
+
+type passthroughClient struct {
+	*dummy.DummyClient
+	raw []byte
+}
+
+func (c *passthroughClient) Children() []types.ServerClient {
+	return []types.ServerClient{c}
+}
+
+func (c *passthroughClient) FetchPassthrough(ctx context.Context, request *protov3.MultiFetchRequest) ([]byte, *types.Stats, *errors.Errors) {
+	return c.raw, &types.Stats{}, nil
+}
+
+func TestFetchWithPassthrough(t *testing.T) {
+	var servers []types.ServerClient
+	for i, name := range []string{"foo", "bar"} {
+		request := &protov3.MultiFetchRequest{
+			Metrics: []protov3.FetchRequest{{Name: name, StartTime: 0, StopTime: 120, PathExpression: name}},
+		}
+		c := dummy.NewDummyClient(fmt.Sprintf("client%v", i), []string{fmt.Sprintf("backend%v", i)}, 1)
+		c.AddFetchResponse(request, &protov3.MultiFetchResponse{
+			Metrics: []protov3.FetchResponse{{Name: name, PathExpression: name, StopTime: 120, StepTime: 60, Values: []float64{1, 2}}},
+		}, &types.Stats{}, nil)
+		// only the backend that has the metric finds it
+		glob := name[:2] + "*"
+		c.AddFindResponse(&protov3.MultiGlobRequest{Metrics: []string{glob}}, &protov3.MultiGlobResponse{
+			Metrics: []protov3.GlobResponse{{Name: glob, Matches: []protov3.GlobMatch{{Path: name, IsLeaf: true}}}},
+		}, &types.Stats{}, nil)
+		servers = append(servers, &passthroughClient{DummyClient: c, raw: []byte(name)})
+	}
+	b, err := NewBroadcastGroup(logger, "test", servers, 60, 500, timeouts)
+	if err != nil && err.HaveFatalErrors {
+		t.Fatalf("error while initializing group: %v", err)
+	}
+	b.SetExpandGlobs(true)
+
+	raw, res, _, err := b.FetchWithPassthrough(context.Background(), &protov3.MultiFetchRequest{
+		Metrics: []protov3.FetchRequest{{Name: "fo*", StartTime: 0, StopTime: 120, PathExpression: "fo*"}},
+	})
+	if (err != nil && len(err.Errors) > 0) || string(raw) != "foo" || res != nil {
+		t.Fatalf("expected response of the only backend to be passed through, got %q, %v, error %v", raw, res, err)
+	}
+
+	// metrics are on different backends, responses have to be merged
+	raw, res, _, err = b.FetchWithPassthrough(context.Background(), &protov3.MultiFetchRequest{
+		Metrics: []protov3.FetchRequest{
+			{Name: "fo*", StartTime: 0, StopTime: 120, PathExpression: "fo*"},
+			{Name: "ba*", StartTime: 0, StopTime: 120, PathExpression: "ba*"},
+		},
+	})
+	if (err != nil && err.HaveFatalErrors) || raw != nil || res == nil || len(res.Metrics) != 2 {
+		t.Fatalf("expected merged response, got %q, %v, error %v", raw, res, err)
+	}
+}
@@ -0,0 +1,70 @@
+package broadcast
+
+import (
+	"context"
+	"time"
+
+	util "github.com/go-graphite/carbonapi/util/ctx"
+	"github.com/go-graphite/carbonapi/zipper/types"
+	protov3 "github.com/go-graphite/protocol/carbonapi_v3_pb"
+)
+
+// Explain tells which children every metric of the request would be fetched from and why, the same way Fetch
+// chooses them. Nothing is sent to the children, globs are not resolved.
+func (bg *BroadcastGroup) Explain(ctx context.Context, request *protov3.MultiFetchRequest) []types.Explanation {
+	reason := types.ReasonBroadcast
+	if util.GetAllowedBackends(ctx) != nil {
+		reason = types.ReasonAllowedBackends
+	}
+	_, clients := bg.allowedChildren(ctx)
+	if active := bg.failover.filter(clients); len(active) < len(clients) {
+		if bg.failover.failedOver() {
+			reason = types.ReasonFailover
+		}
+		clients = active
+	}
+	now := time.Now().Unix()
+
+	explanations := make([]types.Explanation, 0, len(request.Metrics))
+	for _, metric := range request.Metrics {
+		e := types.Explanation{Metric: metric.Name}
+		metricReason := reason
+		routed := bg.routes.filter([]string{metric.Name}, clients)
+		if len(routed) < len(clients) {
+			metricReason = types.ReasonRoute
+		}
+
+		single := &protov3.MultiFetchRequest{Metrics: []protov3.FetchRequest{metric}}
+		for _, part := range bg.tiers.parts(single, routed, now) {
+			partReason := metricReason
+			cached := bg.filterServersByTLD([]string{metric.Name}, part.clients)
+			if len(cached) < len(part.clients) {
+				partReason = types.ReasonCacheHit
+			}
+			hashed := make(map[types.ServerClient][]protov3.FetchRequest)
+			resolve := bg.hashMetrics(part.request, cached, hashed)
+
+			for _, client := range cached {
+				clientReason := partReason
+				if len(hashed[client]) > 0 {
+					clientReason = types.ReasonHashing
+				} else if len(resolve[client]) == 0 {
+					// client doesn't own the metric
+					continue
+				}
+				if clientReason == metricReason && bg.tiers != nil && bg.tiers.tiered(client) {
+					clientReason = types.ReasonStorageTier
+				}
+				e.Backends = append(e.Backends, types.ExplainedBackend{
+					Name:    client.Name(),
+					Servers: client.Backends(),
+					Reason:  clientReason,
+					From:    part.request.Metrics[0].StartTime,
+					Until:   part.request.Metrics[0].StopTime,
+				})
+			}
+		}
+		explanations = append(explanations, e)
+	}
+	return explanations
+}
@@ -0,0 +1,59 @@
+package broadcast
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	util "github.com/go-graphite/carbonapi/util/ctx"
+	"github.com/go-graphite/carbonapi/zipper/dummy"
+	"github.com/go-graphite/carbonapi/zipper/types"
+
+	protov3 "github.com/go-graphite/protocol/carbonapi_v3_pb"
+)
+
+func TestExplain(t *testing.T) {
+	var servers []types.ServerClient
+	for _, name := range []string{"dc1", "dc2"} {
+		servers = append(servers, dummy.NewDummyClient(name, []string{"http://" + name}, 1))
+	}
+	b, e := NewBroadcastGroup(logger, "test", servers, 60, 0, timeouts)
+	if e != nil && e.HaveFatalErrors {
+		t.Fatalf("error while initializing group: %v", e)
+	}
+	if err := b.SetRoutes([]types.Route{{Prefix: "prod.dc1", Backends: []string{"dc1"}}}); err != nil {
+		t.Fatalf("failed to set routes: %v", err)
+	}
+
+	request := &protov3.MultiFetchRequest{Metrics: []protov3.FetchRequest{
+		{Name: "prod.dc1.cpu", StartTime: 100, StopTime: 200},
+		{Name: "prod.dc2.cpu", StartTime: 100, StopTime: 200},
+	}}
+	tests := []struct {
+		ctx      context.Context
+		expected [][]string
+	}{
+		{context.Background(), [][]string{
+			{"prod.dc1.cpu", "dc1", types.ReasonRoute},
+			{"prod.dc2.cpu", "dc1", types.ReasonBroadcast},
+			{"prod.dc2.cpu", "dc2", types.ReasonBroadcast},
+		}},
+		{util.SetAllowedBackends(context.Background(), []string{"dc2"}), [][]string{
+			{"prod.dc2.cpu", "dc2", types.ReasonAllowedBackends},
+		}},
+	}
+	for i, tt := range tests {
+		var got [][]string
+		for _, explanation := range b.Explain(tt.ctx, request) {
+			for _, backend := range explanation.Backends {
+				if backend.From != 100 || backend.Until != 200 || len(backend.Servers) != 1 || backend.Servers[0] != "http://"+backend.Name {
+					t.Errorf("%v: unexpected backend %+v", i, backend)
+				}
+				got = append(got, []string{explanation.Metric, backend.Name, backend.Reason})
+			}
+		}
+		if !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf("%v: expected %v, got %v", i, tt.expected, got)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package broadcast
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/go-graphite/carbonapi/zipper/types"
+	"go.uber.org/zap"
+)
+
+// defaultFailoverThreshold is availability of the cluster below which requests go to the standby one
+const defaultFailoverThreshold = 0.5
+
+var (
+	failovers int64
+	// activeCluster is index of the cluster that root group sends requests to, 0 is the primary one
+	activeCluster int64
+)
+
+// Failovers returns how many times requests were switched from one cluster to another
+func Failovers() int64 {
+	return atomic.LoadInt64(&failovers)
+}
+
+// ActiveCluster returns index of the cluster that requests are sent to: 0 is the primary cluster, 1 is the first
+// standby one and so on
+func ActiveCluster() int64 {
+	return atomic.LoadInt64(&activeCluster)
+}
+
+type failoverCluster struct {
+	name    string
+	clients map[types.ServerClient]struct{}
+}
+
+// failover chooses the cluster that requests are sent to by availability of the clusters, see types.Failover.
+// Availability is taken from health scores of the group. Servers of the clusters that aren't used are still probed,
+// so the primary one is used again once it recovers.
+type failover struct {
+	sync.Mutex
+	clusters  []failoverCluster
+	threshold float64
+	health    *healthScores
+	active    int
+	logger    *zap.Logger
+}
+
+func newFailover(config types.Failover, clients []types.ServerClient, health *healthScores, logger *zap.Logger) (*failover, error) {
+	if len(config.Primary.Backends) == 0 {
+		if len(config.Standby) > 0 {
+			return nil, fmt.Errorf("standby clusters are set without primary one")
+		}
+		return nil, nil
+	}
+	if len(config.Standby) == 0 {
+		return nil, fmt.Errorf("no standby clusters")
+	}
+	f := &failover{
+		threshold: config.Threshold,
+		health:    health,
+		logger:    logger,
+	}
+	if f.threshold == 0 {
+		f.threshold = defaultFailoverThreshold
+	}
+	if f.threshold < 0 || f.threshold > 1 {
+		return nil, fmt.Errorf("threshold must be between 0 and 1")
+	}
+
+	groups := make(map[string][]types.ServerClient, len(clients))
+	for _, client := range clients {
+		groups[client.Name()] = client.Children()
+	}
+	seen := make(map[string]string)
+	for i, c := range append([]types.FailoverCluster{config.Primary}, config.Standby...) {
+		cluster := failoverCluster{name: c.Name, clients: make(map[types.ServerClient]struct{})}
+		if cluster.name == "" {
+			cluster.name = "primary"
+			if i > 0 {
+				cluster.name = "standby" + strconv.Itoa(i)
+			}
+		}
+		if len(c.Backends) == 0 {
+			return nil, fmt.Errorf("cluster %v: no backends", cluster.name)
+		}
+		for _, name := range c.Backends {
+			children, ok := groups[name]
+			if !ok {
+				return nil, fmt.Errorf("cluster %v: unknown backend group %q", cluster.name, name)
+			}
+			if other, ok := seen[name]; ok {
+				return nil, fmt.Errorf("cluster %v: backend group %q is in cluster %v as well", cluster.name, name, other)
+			}
+			seen[name] = cluster.name
+			for _, child := range children {
+				cluster.clients[child] = struct{}{}
+			}
+		}
+		f.clusters = append(f.clusters, cluster)
+	}
+	atomic.StoreInt64(&activeCluster, 0)
+	return f, nil
+}
+
+// availability returns average health score of the servers of the cluster
+func (f *failover) availability(cluster failoverCluster) float64 {
+	sum := 0.0
+	for client := range cluster.clients {
+		sum += f.health.Score(client.Name())
+	}
+	return sum / float64(len(cluster.clients))
+}
+
+// choose returns index of the first cluster that is available, or the most available one if none is
+func (f *failover) choose() int {
+	best, bestAvailability := 0, -1.0
+	for i, cluster := range f.clusters {
+		availability := f.availability(cluster)
+		if availability >= f.threshold {
+			return i
+		}
+		if availability > bestAvailability {
+			best, bestAvailability = i, availability
+		}
+	}
+	return best
+}
+
+// filter returns clients of the cluster that is used now and clients that aren't in any cluster
+func (f *failover) filter(clients []types.ServerClient) []types.ServerClient {
+	if f == nil {
+		return clients
+	}
+
+	active := f.choose()
+	f.Lock()
+	if active != f.active {
+		f.logger.Warn("switching to another cluster",
+			zap.String("from", f.clusters[f.active].name),
+			zap.String("to", f.clusters[active].name),
+			zap.Float64("availability", f.availability(f.clusters[f.active])),
+			zap.Float64("threshold", f.threshold),
+		)
+		f.active = active
+		atomic.AddInt64(&failovers, 1)
+		atomic.StoreInt64(&activeCluster, int64(active))
+	}
+	f.Unlock()
+
+	filtered := make([]types.ServerClient, 0, len(clients))
+	for _, c := range clients {
+		if _, ok := f.clusters[active].clients[c]; ok || !f.clustered(c) {
+			filtered = append(filtered, c)
+		}
+	}
+	return filtered
+}
+
+func (f *failover) clustered(client types.ServerClient) bool {
+	for _, cluster := range f.clusters {
+		if _, ok := cluster.clients[client]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// failedOver returns true if requests are sent to one of the standby clusters
+func (f *failover) failedOver() bool {
+	f.Lock()
+	defer f.Unlock()
+	return f.active != 0
+}
@@ -0,0 +1,75 @@
+package broadcast
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/go-graphite/carbonapi/zipper/dummy"
+	"github.com/go-graphite/carbonapi/zipper/types"
+)
+
+func TestFailover(t *testing.T) {
+	var clients []types.ServerClient
+	for _, name := range []string{"dc1", "dc2", "dc3", "other"} {
+		clients = append(clients, dummy.NewDummyClient(name, []string{name}, 1))
+	}
+	b, e := NewBroadcastGroup(logger, "test", clients, 60, 0, timeouts)
+	if e != nil && e.HaveFatalErrors {
+		t.Fatalf("error while initializing group: %v", e)
+	}
+	err := b.SetFailover(types.Failover{
+		Primary: types.FailoverCluster{Backends: []string{"dc1"}},
+		Standby: []types.FailoverCluster{{Name: "dc2", Backends: []string{"dc2"}}, {Backends: []string{"dc3"}}},
+	})
+	if err != nil {
+		t.Fatalf("failed to set failover: %v", err)
+	}
+
+	names := func() []string {
+		var res []string
+		for _, c := range b.failover.filter(clients) {
+			res = append(res, c.Name())
+		}
+		return res
+	}
+	observe := func(client string, good bool) {
+		for i := 0; i < 20; i++ {
+			b.health.Observe(client, good)
+		}
+	}
+	failoversBefore := Failovers()
+
+	if got := names(); !reflect.DeepEqual(got, []string{"dc1", "other"}) {
+		t.Fatalf("expected primary cluster, got %v", got)
+	}
+	observe("dc1", false)
+	if got := names(); !reflect.DeepEqual(got, []string{"dc2", "other"}) || ActiveCluster() != 1 {
+		t.Fatalf("expected the first standby cluster, got %v, active cluster %v", got, ActiveCluster())
+	}
+	observe("dc2", false)
+	if got := names(); !reflect.DeepEqual(got, []string{"dc3", "other"}) || ActiveCluster() != 2 {
+		t.Fatalf("expected the second standby cluster, got %v, active cluster %v", got, ActiveCluster())
+	}
+	observe("dc1", true)
+	if got := names(); !reflect.DeepEqual(got, []string{"dc1", "other"}) || ActiveCluster() != 0 {
+		t.Fatalf("expected recovered primary cluster, got %v, active cluster %v", got, ActiveCluster())
+	}
+	if n := Failovers() - failoversBefore; n != 3 {
+		t.Fatalf("expected 3 failovers, got %v", n)
+	}
+
+	for _, config := range []types.Failover{
+		{Primary: types.FailoverCluster{Backends: []string{"dc1"}}},
+		{Standby: []types.FailoverCluster{{Backends: []string{"dc2"}}}},
+		{Primary: types.FailoverCluster{Backends: []string{"unknown"}}, Standby: []types.FailoverCluster{{Backends: []string{"dc2"}}}},
+		{Primary: types.FailoverCluster{Backends: []string{"dc1"}}, Standby: []types.FailoverCluster{{Backends: []string{"dc1"}}}},
+		{Primary: types.FailoverCluster{Backends: []string{"dc1"}}, Standby: []types.FailoverCluster{{Backends: []string{"dc2"}}}, Threshold: 2},
+	} {
+		if err := b.SetFailover(config); err == nil {
+			t.Errorf("%+v: expected error", config)
+		}
+	}
+	if err := b.SetFailover(types.Failover{}); err != nil || b.failover != nil {
+		t.Errorf("failover should be disabled without clusters, got %v", err)
+	}
+}
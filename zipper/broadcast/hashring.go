@@ -0,0 +1,245 @@
+package broadcast
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-graphite/carbonapi/zipper/types"
+)
+
+// Hashing types, they are named as in carbon and carbon-c-relay
+const (
+	HashCarbon    = "carbon_ch"
+	HashFNV1a     = "fnv1a_ch"
+	HashJumpFNV1a = "jump_fnv1a_ch"
+)
+
+// carbonReplicas is amount of positions of every node on the ring, carbon uses 100
+const carbonReplicas = 100
+
+type ringEntry struct {
+	position int
+	client   int
+}
+
+// hashRing computes servers that own the metric the same way carbon-relay (or carbon-c-relay) does
+type hashRing struct {
+	hashType          string
+	replicationFactor int
+	nodes             int
+	// clients of the group the ring belongs to, in the order of the servers
+	clients []types.ServerClient
+	// ring is sorted by position, jump hash doesn't use it
+	ring []ringEntry
+}
+
+// destination is the relay destination: host:port:instance, port and instance are optional
+type destination struct {
+	host     string
+	port     string
+	instance string
+}
+
+func parseDestination(s string) destination {
+	var d destination
+	// [ipv6]:port:instance
+	if strings.HasPrefix(s, "[") {
+		if idx := strings.Index(s, "]"); idx > 0 {
+			d.host = s[1:idx]
+			s = strings.TrimPrefix(s[idx+1:], ":")
+			parts := strings.SplitN(s, ":", 2)
+			d.port = parts[0]
+			if len(parts) > 1 {
+				d.instance = parts[1]
+			}
+			return d
+		}
+	}
+	parts := strings.SplitN(s, ":", 3)
+	d.host = parts[0]
+	if len(parts) > 1 {
+		d.port = parts[1]
+	}
+	if len(parts) > 2 {
+		d.instance = parts[2]
+	}
+	return d
+}
+
+// destinationOfServer is used when destinations aren't configured: host of the server without instance
+func destinationOfServer(server string) destination {
+	if u, err := url.Parse(server); err == nil && u.Host != "" {
+		server = u.Host
+	}
+	if host, _, err := net.SplitHostPort(server); err == nil {
+		return destination{host: host}
+	}
+	return destination{host: server}
+}
+
+// key of the node on the ring
+func (d destination) key(hashType string) string {
+	if hashType == HashFNV1a {
+		// carbon-c-relay uses instance, or host:port if it's not set
+		if d.instance != "" {
+			return d.instance
+		}
+		return d.host + ":" + d.port
+	}
+	// python repr of (host, instance) tuple
+	instance := "None"
+	if d.instance != "" {
+		instance = "'" + d.instance + "'"
+	}
+	return "('" + d.host + "', " + instance + ")"
+}
+
+func fnv1a32(s string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(s))
+	return h.Sum32()
+}
+
+func fnv1a64(s string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	return h.Sum64()
+}
+
+// position of the key on the ring, 16 bit hash
+func (r *hashRing) position(key string) int {
+	if r.hashType == HashFNV1a {
+		h := fnv1a32(key)
+		return int((h >> 16) ^ (h & 0xffff))
+	}
+	sum := md5.Sum([]byte(key))
+	return int(binary.BigEndian.Uint16(sum[:2]))
+}
+
+// jumpHash is consistent hash of Lamping and Veach
+func jumpHash(key uint64, buckets int) int {
+	var b, j int64 = -1, 0
+	for j < int64(buckets) {
+		b = j
+		key = key*2862933555777941757 + 1
+		j = int64(float64(b+1) * (float64(int64(1)<<31) / float64((key>>33)+1)))
+	}
+	return int(b)
+}
+
+// newHashRing creates ring of the servers, destinations are relay destinations of the servers in the same order.
+// If they are empty, host of every server is used.
+func newHashRing(config types.Hashing, servers []string) (*hashRing, error) {
+	r := &hashRing{
+		hashType:          config.Type,
+		replicationFactor: config.ReplicationFactor,
+		nodes:             len(servers),
+	}
+	switch r.hashType {
+	case HashCarbon, HashFNV1a, HashJumpFNV1a:
+	default:
+		return nil, fmt.Errorf("unknown hashing type %q, supported: %v, %v, %v", r.hashType, HashCarbon, HashFNV1a, HashJumpFNV1a)
+	}
+	if r.replicationFactor <= 0 {
+		r.replicationFactor = 1
+	}
+	if len(config.Destinations) > 0 && len(config.Destinations) != len(servers) {
+		return nil, fmt.Errorf("hashing has %v destinations, but group has %v servers", len(config.Destinations), len(servers))
+	}
+	if r.hashType == HashJumpFNV1a {
+		return r, nil
+	}
+
+	taken := make(map[int]struct{}, len(servers)*carbonReplicas)
+	for i := range servers {
+		var d destination
+		if len(config.Destinations) > 0 {
+			d = parseDestination(config.Destinations[i])
+		} else {
+			d = destinationOfServer(servers[i])
+		}
+		key := d.key(r.hashType)
+		for replica := 0; replica < carbonReplicas; replica++ {
+			var replicaKey string
+			if r.hashType == HashFNV1a {
+				replicaKey = strconv.Itoa(replica) + "-" + key
+			} else {
+				replicaKey = key + ":" + strconv.Itoa(replica)
+			}
+			position := r.position(replicaKey)
+			// carbon moves colliding positions forward
+			for {
+				if _, ok := taken[position]; !ok {
+					break
+				}
+				position++
+			}
+			taken[position] = struct{}{}
+			r.ring = append(r.ring, ringEntry{position: position, client: i})
+		}
+	}
+	sort.Slice(r.ring, func(i, j int) bool {
+		return r.ring[i].position < r.ring[j].position
+	})
+	return r, nil
+}
+
+// owners returns indexes of the servers that own the metric, replicationFactor of them
+func (r *hashRing) owners(metric string) []int {
+	n := r.replicationFactor
+	if n > r.nodes {
+		n = r.nodes
+	}
+	owners := make([]int, 0, n)
+
+	if r.hashType == HashJumpFNV1a {
+		// replicas are the next servers
+		first := jumpHash(fnv1a64(metric), r.nodes)
+		for i := 0; i < n; i++ {
+			owners = append(owners, (first+i)%r.nodes)
+		}
+		return owners
+	}
+
+	position := r.position(metric)
+	idx := sort.Search(len(r.ring), func(i int) bool {
+		return r.ring[i].position >= position
+	})
+	seen := make(map[int]struct{}, n)
+	for i := 0; i < len(r.ring) && len(owners) < n; i++ {
+		e := r.ring[(idx+i)%len(r.ring)]
+		if _, ok := seen[e.client]; ok {
+			continue
+		}
+		seen[e.client] = struct{}{}
+		owners = append(owners, e.client)
+	}
+	return owners
+}
+
+// HashRing tells which servers own the metric the same way as carbon-relay does, for the code that isn't a group
+// of clients, e.g. queries of carbon caches.
+type HashRing struct {
+	ring *hashRing
+}
+
+// NewHashRing creates ring of the servers, see SetHashing
+func NewHashRing(config types.Hashing, servers []string) (*HashRing, error) {
+	ring, err := newHashRing(config, servers)
+	if err != nil {
+		return nil, err
+	}
+	return &HashRing{ring: ring}, nil
+}
+
+// Owners returns indexes of the servers that own the metric
+func (r *HashRing) Owners(metric string) []int {
+	return r.ring.owners(metric)
+}
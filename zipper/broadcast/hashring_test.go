@@ -0,0 +1,148 @@
+package broadcast
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/go-graphite/carbonapi/zipper/dummy"
+	"github.com/go-graphite/carbonapi/zipper/types"
+
+	protov3 "github.com/go-graphite/protocol/carbonapi_v3_pb"
+)
+
+func TestHashRingOwners(t *testing.T) {
+	var servers []string
+	for i := 0; i < 3; i++ {
+		servers = append(servers, fmt.Sprintf("http://10.0.0.%v:8080", i+1))
+	}
+
+	// owners are computed by ConsistentHashRing of carbon
+	tests := []struct {
+		hashing  types.Hashing
+		expected map[string][]int
+	}{
+		{
+			types.Hashing{Type: HashCarbon, ReplicationFactor: 2, Destinations: []string{"10.0.0.1:2004:a", "10.0.0.2:2004:b", "10.0.0.3:2004"}},
+			map[string][]int{
+				"carbon.agents.a.cpu":          {0, 2},
+				"prod.dc1.host1.cpu.user":      {1, 0},
+				"foo":                          {2, 0},
+				"foo.bar.baz":                  {2, 1},
+				"collectd.host.load.shortterm": {2, 1},
+			},
+		},
+		{
+			types.Hashing{Type: HashFNV1a, ReplicationFactor: 2, Destinations: []string{"10.0.0.1:2004:a", "10.0.0.2:2004:b", "10.0.0.3:2004:c"}},
+			map[string][]int{
+				"carbon.agents.a.cpu":          {2, 1},
+				"prod.dc1.host1.cpu.user":      {1, 0},
+				"foo":                          {2, 1},
+				"foo.bar.baz":                  {0, 2},
+				"collectd.host.load.shortterm": {0, 1},
+			},
+		},
+		{
+			// host of the server is used without instance
+			types.Hashing{Type: HashCarbon},
+			map[string][]int{
+				"carbon.agents.a.cpu": nil,
+			},
+		},
+	}
+	for _, tt := range tests {
+		ring, err := newHashRing(tt.hashing, servers)
+		if err != nil {
+			t.Fatalf("failed to create ring: %v", err)
+		}
+		for metric, expected := range tt.expected {
+			got := ring.owners(metric)
+			if expected == nil {
+				if len(got) != 1 {
+					t.Errorf("%v: expected single owner, got %v", metric, got)
+				}
+				continue
+			}
+			if !reflect.DeepEqual(got, expected) {
+				t.Errorf("%v %v: expected owners %v, got %v", tt.hashing.Type, metric, expected, got)
+			}
+		}
+	}
+
+	ring, err := newHashRing(types.Hashing{Type: HashJumpFNV1a, ReplicationFactor: 5}, servers)
+	if err != nil {
+		t.Fatalf("failed to create ring: %v", err)
+	}
+	if owners := ring.owners("foo"); len(owners) != 3 || owners[0] == owners[1] || owners[1] == owners[2] {
+		t.Errorf("replicas should be distinct servers, got %v", owners)
+	}
+
+	if _, err := newHashRing(types.Hashing{Type: "md5"}, servers); err == nil {
+		t.Errorf("unknown hashing should be an error")
+	}
+	if _, err := newHashRing(types.Hashing{Type: HashCarbon, Destinations: []string{"10.0.0.1"}}, servers); err == nil {
+		t.Errorf("destinations that don't match servers should be an error")
+	}
+}
+
+func TestJumpHash(t *testing.T) {
+	tests := []struct {
+		key      uint64
+		buckets  int
+		expected int
+	}{
+		{1, 1, 0},
+		{42, 57, 43},
+		{0xDEAD10CC, 1, 0},
+		{0xDEAD10CC, 666, 361},
+		{256, 1024, 520},
+	}
+	for _, tt := range tests {
+		if got := jumpHash(tt.key, tt.buckets); got != tt.expected {
+			t.Errorf("jumpHash(%v, %v): expected %v, got %v", tt.key, tt.buckets, tt.expected, got)
+		}
+	}
+}
+
+func TestFetchHashing(t *testing.T) {
+	var servers []types.ServerClient
+	var names []string
+	for i := 0; i < 3; i++ {
+		name := fmt.Sprintf("10.0.0.%v", i+1)
+		c := dummy.NewDummyClient(name, []string{name}, 1)
+		for _, metric := range []string{"foo", "bar"} {
+			c.AddFetchResponse(&protov3.MultiFetchRequest{Metrics: []protov3.FetchRequest{{Name: metric, PathExpression: metric}}}, &protov3.MultiFetchResponse{
+				Metrics: []protov3.FetchResponse{{Name: metric, PathExpression: metric, StopTime: 120, StepTime: 60, Values: []float64{float64(i), float64(i)}}},
+			}, &types.Stats{}, nil)
+		}
+		servers = append(servers, c)
+		names = append(names, name)
+	}
+	group, e := NewBroadcastGroup(logger, "hashed", servers, 60, 0, timeouts)
+	if e != nil && e.HaveFatalErrors {
+		t.Fatalf("error while initializing group: %v", e)
+	}
+	if err := group.SetHashing(types.Hashing{Type: HashCarbon}); err != nil {
+		t.Fatalf("failed to set hashing: %v", err)
+	}
+	root, e := NewBroadcastGroup(logger, "root", []types.ServerClient{group}, 60, 0, timeouts)
+	if e != nil && e.HaveFatalErrors {
+		t.Fatalf("error while initializing group: %v", e)
+	}
+
+	for _, metric := range []string{"foo", "bar"} {
+		owner := group.rings[servers[0]].owners(metric)[0]
+		request := &protov3.MultiFetchRequest{Metrics: []protov3.FetchRequest{{Name: metric, PathExpression: metric}}}
+		res, stats, e := root.Fetch(context.Background(), request)
+		if e != nil && len(e.Errors) > 0 {
+			t.Fatalf("unexpected error %v", e)
+		}
+		if stats.ZipperRequests != 1 || len(res.Metrics) != 1 {
+			t.Fatalf("metric should only be fetched from its owner, got %v requests, %+v", stats.ZipperRequests, res)
+		}
+		if res.Metrics[0].Values[0] != float64(owner) {
+			t.Fatalf("metric should be fetched from %v, got %+v", names[owner], res.Metrics[0])
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package broadcast
+
+import (
+	"math"
+	"sort"
+	"sync"
+
+	"github.com/go-graphite/carbonapi/zipper/types"
+	protov3 "github.com/go-graphite/protocol/carbonapi_v3_pb"
+)
+
+// healthDecay is the weight of the latest observation in client's health score
+const healthDecay = 0.1
+
+// healthScores keeps exponentially weighted ratio of good responses per client. Response is good if it has no errors
+// and its data is not stale compared to other clients. Clients that were never seen are considered healthy.
+type healthScores struct {
+	sync.Mutex
+	scores map[string]float64
+}
+
+func newHealthScores() *healthScores {
+	return &healthScores{
+		scores: make(map[string]float64),
+	}
+}
+
+func (h *healthScores) Observe(client string, good bool) {
+	v := 0.0
+	if good {
+		v = 1
+	}
+	h.Lock()
+	score, ok := h.scores[client]
+	if !ok {
+		score = 1
+	}
+	h.scores[client] = score*(1-healthDecay) + v*healthDecay
+	h.Unlock()
+}
+
+func (h *healthScores) Score(client string) float64 {
+	h.Lock()
+	defer h.Unlock()
+	score, ok := h.scores[client]
+	if !ok {
+		return 1
+	}
+	return score
+}
+
+// lastPointTimes returns timestamp of the latest non-null point of every metric in the response
+func lastPointTimes(r *protov3.MultiFetchResponse) map[string]int64 {
+	last := make(map[string]int64, len(r.Metrics))
+	for i := range r.Metrics {
+		m := &r.Metrics[i]
+		for j := len(m.Values) - 1; j >= 0; j-- {
+			if !math.IsNaN(m.Values[j]) {
+				if ts := m.StartTime + int64(j)*m.StepTime; ts > last[m.Name] {
+					last[m.Name] = ts
+				}
+				break
+			}
+		}
+	}
+	return last
+}
+
+// observe updates health scores with responses of current request. Response is stale if any of its metrics has
+// older last point than the same metric in other responses.
+func (h *healthScores) observe(responses []*types.ServerFetchResponse) {
+	freshest := make(map[string]int64)
+	lastPoints := make([]map[string]int64, len(responses))
+	for i, r := range responses {
+		if r.Response == nil {
+			continue
+		}
+		lastPoints[i] = lastPointTimes(r.Response)
+		for name, ts := range lastPoints[i] {
+			if ts > freshest[name] {
+				freshest[name] = ts
+			}
+		}
+	}
+
+	for i, r := range responses {
+		ok := r.Err == nil || len(r.Err.Errors) == 0 || types.IsNotFound(r.Err)
+		stale := false
+		for name, ts := range lastPoints[i] {
+			if ts < freshest[name] {
+				stale = true
+				break
+			}
+		}
+		h.Observe(r.Server, ok && !stale)
+	}
+}
+
+// sortByHealth orders responses from the healthiest client to the least healthy one, so data of better replicas is
+// used as a base when responses are merged.
+func (h *healthScores) sortByHealth(responses []*types.ServerFetchResponse) {
+	scores := make(map[string]float64, len(responses))
+	for _, r := range responses {
+		scores[r.Server] = h.Score(r.Server)
+	}
+	sort.SliceStable(responses, func(i, j int) bool {
+		return scores[responses[i].Server] > scores[responses[j].Server]
+	})
+}
@@ -0,0 +1,86 @@
+package broadcast
+
+import (
+	"math"
+	"testing"
+
+	"github.com/go-graphite/carbonapi/zipper/errors"
+	"github.com/go-graphite/carbonapi/zipper/types"
+	protov3 "github.com/go-graphite/protocol/carbonapi_v3_pb"
+)
+
+func TestHealthScores(t *testing.T) {
+	h := newHealthScores()
+	if h.Score("unknown") != 1 {
+		t.Fatalf("unknown client should be healthy, got %v", h.Score("unknown"))
+	}
+
+	h.Observe("a", false)
+	h.Observe("a", true)
+	if s := h.Score("a"); math.Abs(s-0.91) > 1e-9 {
+		t.Fatalf("unexpected score %v, expected 0.91", s)
+	}
+}
+
+func TestSortByHealth(t *testing.T) {
+	response := func(server string, values []float64, err *errors.Errors) *types.ServerFetchResponse {
+		return &types.ServerFetchResponse{
+			Server: server,
+			Response: &protov3.MultiFetchResponse{
+				Metrics: []protov3.FetchResponse{{Name: "foo", StartTime: 60, StepTime: 60, Values: values}},
+			},
+			Err: err,
+		}
+	}
+	nan := math.NaN()
+
+	h := newHealthScores()
+	h.Observe("flaky", false)
+
+	responses := []*types.ServerFetchResponse{
+		response("stale", []float64{1, 2, nan}, nil),
+		response("flaky", []float64{1, 2, 3}, nil),
+		response("healthy", []float64{1, 2, 3}, nil),
+		response("failed", nil, errors.FromErrNonFatal(types.ErrTimeoutExceeded)),
+	}
+	h.observe(responses)
+	h.sortByHealth(responses)
+
+	var order []string
+	for _, r := range responses {
+		order = append(order, r.Server)
+	}
+	expected := []string{"healthy", "flaky", "stale", "failed"}
+	for i := range expected {
+		if order[i] != expected[i] {
+			t.Fatalf("unexpected order %v, expected %v", order, expected)
+		}
+	}
+}
+
+func TestObserveStalenessPerMetric(t *testing.T) {
+	response := func(server string, metrics ...protov3.FetchResponse) *types.ServerFetchResponse {
+		return &types.ServerFetchResponse{
+			Server:   server,
+			Response: &protov3.MultiFetchResponse{Metrics: metrics},
+		}
+	}
+	nan := math.NaN()
+
+	h := newHealthScores()
+	// "old" metric stopped being written long ago, it doesn't make backend that has only it stale
+	h.observe([]*types.ServerFetchResponse{
+		response("a",
+			protov3.FetchResponse{Name: "new", StartTime: 60, StepTime: 60, Values: []float64{1, 2, 3}},
+			protov3.FetchResponse{Name: "old", StartTime: 60, StepTime: 60, Values: []float64{1, nan, nan}},
+		),
+		response("b", protov3.FetchResponse{Name: "old", StartTime: 60, StepTime: 60, Values: []float64{1, nan, nan}}),
+		response("c", protov3.FetchResponse{Name: "new", StartTime: 60, StepTime: 60, Values: []float64{1, 2, nan}}),
+	})
+
+	for server, healthy := range map[string]bool{"a": true, "b": true, "c": false} {
+		if (h.Score(server) == 1) != healthy {
+			t.Errorf("%v: unexpected score %v", server, h.Score(server))
+		}
+	}
+}
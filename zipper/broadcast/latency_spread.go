@@ -0,0 +1,106 @@
+package broadcast
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-graphite/carbonapi/zipper/types"
+)
+
+const (
+	latencySpreadSamples    = 256
+	latencySpreadMinSamples = 16
+)
+
+// latencySpread tracks how late other backends answer compared to the first one and estimates how long
+// it's worth to wait for them.
+//
+// nil latencySpread disables waiting policy.
+type latencySpread struct {
+	sync.Mutex
+
+	percentile float64
+	min        time.Duration
+	max        time.Duration
+
+	samples []time.Duration
+	pos     int
+	full    bool
+}
+
+func newLatencySpread(cfg types.AfterFirstResponse) *latencySpread {
+	if cfg.Max <= 0 {
+		return nil
+	}
+	if cfg.Percentile <= 0 || cfg.Percentile > 100 {
+		cfg.Percentile = 95
+	}
+	if cfg.Min > cfg.Max {
+		cfg.Min = cfg.Max
+	}
+
+	return &latencySpread{
+		percentile: cfg.Percentile,
+		min:        cfg.Min,
+		max:        cfg.Max,
+		samples:    make([]time.Duration, latencySpreadSamples),
+	}
+}
+
+// Add records that backend answered d after the first one
+func (s *latencySpread) Add(d time.Duration) {
+	if s == nil {
+		return
+	}
+	s.Lock()
+	s.samples[s.pos] = d
+	s.pos++
+	if s.pos == len(s.samples) {
+		s.pos = 0
+		s.full = true
+	}
+	s.Unlock()
+}
+
+// Censored records that n backends didn't answer in time. Their real latency is unknown, so it's accounted as max
+// to avoid shrinking the estimate because of our own cut off.
+func (s *latencySpread) Censored(n int) {
+	if s == nil {
+		return
+	}
+	for i := 0; i < n; i++ {
+		s.Add(s.max)
+	}
+}
+
+// Wait returns how long we should wait for other backends after the first one answered.
+// 0 means that we should wait for all of them.
+func (s *latencySpread) Wait() time.Duration {
+	if s == nil {
+		return 0
+	}
+	s.Lock()
+	n := s.pos
+	if s.full {
+		n = len(s.samples)
+	}
+	if n < latencySpreadMinSamples {
+		s.Unlock()
+		return s.max
+	}
+	samples := make([]time.Duration, n)
+	copy(samples, s.samples[:n])
+	s.Unlock()
+
+	sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+	idx := int(float64(n-1) * s.percentile / 100)
+	wait := samples[idx]
+	if wait < s.min {
+		wait = s.min
+	}
+	if wait > s.max {
+		wait = s.max
+	}
+	return wait
+}
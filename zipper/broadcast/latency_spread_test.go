@@ -0,0 +1,73 @@
+package broadcast
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-graphite/carbonapi/zipper/types"
+)
+
+func TestLatencySpread(t *testing.T) {
+	s := newLatencySpread(types.AfterFirstResponse{
+		Percentile: 90,
+		Min:        10 * time.Millisecond,
+		Max:        time.Second,
+	})
+
+	if w := s.Wait(); w != time.Second {
+		t.Fatalf("without enough samples max should be used, got %v", w)
+	}
+
+	for i := 1; i <= 100; i++ {
+		s.Add(time.Duration(i) * time.Millisecond)
+	}
+	if w := s.Wait(); w != 90*time.Millisecond {
+		t.Fatalf("unexpected wait time, got %v, expected %v", w, 90*time.Millisecond)
+	}
+
+	for i := 0; i < 100; i++ {
+		s.Add(time.Microsecond)
+	}
+	if w := s.Wait(); w != 80*time.Millisecond {
+		t.Fatalf("unexpected wait time, got %v, expected %v", w, 80*time.Millisecond)
+	}
+
+	for i := 0; i < latencySpreadSamples; i++ {
+		s.Add(time.Microsecond)
+	}
+	if w := s.Wait(); w != 10*time.Millisecond {
+		t.Fatalf("wait time should be clamped to min, got %v", w)
+	}
+
+	s.Censored(latencySpreadSamples)
+	if w := s.Wait(); w != time.Second {
+		t.Fatalf("wait time should be clamped to max, got %v", w)
+	}
+}
+
+func TestLatencySpreadDisabled(t *testing.T) {
+	s := newLatencySpread(types.AfterFirstResponse{})
+	s.Add(time.Second)
+	s.Censored(1)
+	if w := s.Wait(); w != 0 {
+		t.Fatalf("disabled policy should wait for all backends, got %v", w)
+	}
+}
+
+func TestLatencySpreadSnapshot(t *testing.T) {
+	s := newLatencySpread(types.AfterFirstResponse{Max: time.Second})
+	for i := 0; i < latencySpreadSamples+2; i++ {
+		s.Add(time.Duration(i))
+	}
+
+	samples := s.snapshot()
+	if len(samples) != latencySpreadSamples || samples[0] != 2 || samples[len(samples)-1] != latencySpreadSamples+1 {
+		t.Fatalf("samples are not ordered from the oldest one: %v", samples)
+	}
+
+	restored := newLatencySpread(types.AfterFirstResponse{Max: time.Second})
+	restored.restore(append(samples, latencySpreadSamples+2))
+	if got := restored.snapshot(); len(got) != latencySpreadSamples || got[0] != 3 {
+		t.Fatalf("only the latest samples should be restored, got %v", got)
+	}
+}
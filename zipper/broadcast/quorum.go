@@ -0,0 +1,100 @@
+package broadcast
+
+import (
+	"sort"
+
+	"github.com/go-graphite/carbonapi/zipper/types"
+)
+
+// fetchQuorum counts answers of the clients by group. Children of nested broadcast groups are asked by the root
+// directly, so nested group is successful if its own quorum of the asked children have answered, or any of them if
+// it has no quorum. Clients that aren't in nested groups are groups of their own. Group's quorum is the amount of
+// successful groups it needs.
+type fetchQuorum struct {
+	quorum int
+	// groups are names of the groups of the asked clients, by client name
+	groups  map[string]string
+	quorums map[string]int
+	asked   map[string]int
+}
+
+func (bg *BroadcastGroup) newQuorum(clients []types.ServerClient) *fetchQuorum {
+	q := &fetchQuorum{
+		groups:  make(map[string]string, len(clients)),
+		quorums: make(map[string]int),
+		asked:   make(map[string]int),
+	}
+	for _, c := range clients {
+		group, quorum := c.Name(), 0
+		if nested, ok := bg.nested[c.Name()]; ok {
+			group, quorum = nested.groupName, nested.quorum
+		}
+		q.groups[c.Name()] = group
+		q.quorums[group] = quorum
+		q.asked[group]++
+	}
+	// clients that don't have requested metrics are not asked, so they can't be counted
+	q.quorum = bg.quorum
+	if q.quorum > len(q.asked) {
+		q.quorum = len(q.asked)
+	}
+	for group, quorum := range q.quorums {
+		if quorum > q.asked[group] {
+			q.quorums[group] = q.asked[group]
+		}
+	}
+	return q
+}
+
+// succeeded returns groups that have enough successful answers
+func (q *fetchQuorum) succeeded(clients map[string]bool) map[string]bool {
+	answered := make(map[string]int)
+	for client, ok := range clients {
+		if ok {
+			answered[q.groups[client]]++
+		}
+	}
+	res := make(map[string]bool)
+	for group, n := range answered {
+		if n >= q.quorums[group] {
+			res[group] = true
+		}
+	}
+	return res
+}
+
+func (q *fetchQuorum) reached(succeeded map[string]bool) bool {
+	return len(succeeded) >= q.quorum
+}
+
+// failedNested returns nested groups that haven't reached their quorum
+func (q *fetchQuorum) failedNested(succeeded map[string]bool) []string {
+	var res []string
+	for group, quorum := range q.quorums {
+		if quorum > 0 && !succeeded[group] {
+			res = append(res, group)
+		}
+	}
+	sort.Strings(res)
+	return res
+}
+
+// waiting tells whether slow clients are still needed to reach quorum of the group or of nested groups
+func (q *fetchQuorum) waiting(clients map[string]bool, answered map[string]struct{}) bool {
+	succeeded := q.succeeded(clients)
+	if !q.reached(succeeded) {
+		return true
+	}
+	pending := make(map[string]bool)
+	for client, group := range q.groups {
+		if _, ok := answered[client]; !ok {
+			pending[group] = true
+		}
+	}
+	for _, group := range q.failedNested(succeeded) {
+		if pending[group] {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,120 @@
+package broadcast
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/go-graphite/carbonapi/zipper/types"
+)
+
+const globChars = "*?[{"
+
+type route struct {
+	prefix  []string
+	clients map[types.ServerClient]struct{}
+}
+
+// routingTable sends requests only to the groups that can hold requested metrics. Routes are sorted by length of
+// the prefix, so the most specific one goes first.
+type routingTable []route
+
+func newRoutingTable(routes []types.Route, clients []types.ServerClient) (routingTable, error) {
+	groups := make(map[string][]types.ServerClient, len(clients))
+	for _, client := range clients {
+		groups[client.Name()] = client.Children()
+	}
+
+	t := make(routingTable, 0, len(routes))
+	for _, r := range routes {
+		prefix := strings.Split(r.Prefix, ".")
+		// "prod.dc1.*" is the same as "prod.dc1"
+		for len(prefix) > 0 && prefix[len(prefix)-1] == "*" {
+			prefix = prefix[:len(prefix)-1]
+		}
+		if len(prefix) == 0 || prefix[0] == "" {
+			return nil, fmt.Errorf("route %q: prefix is empty", r.Prefix)
+		}
+
+		rt := route{prefix: prefix, clients: make(map[types.ServerClient]struct{})}
+		for _, name := range r.Backends {
+			children, ok := groups[name]
+			if !ok {
+				return nil, fmt.Errorf("route %q: unknown backend group %q", r.Prefix, name)
+			}
+			for _, c := range children {
+				rt.clients[c] = struct{}{}
+			}
+		}
+		t = append(t, rt)
+	}
+	sort.SliceStable(t, func(i, j int) bool {
+		return len(t[i].prefix) > len(t[j].prefix)
+	})
+	return t, nil
+}
+
+// match tells if metric is under the prefix. Match is definite if metric has literal nodes for the whole prefix,
+// and possible if the nodes have globs or metric is shorter than the prefix.
+func (r route) match(nodes []string) (definite, possible bool) {
+	definite = len(nodes) >= len(r.prefix)
+	for i, p := range r.prefix {
+		if i >= len(nodes) {
+			return false, true
+		}
+		node := nodes[i]
+		if strings.ContainsAny(node, globChars) {
+			definite = false
+			if !strings.ContainsAny(p, globChars) && !strings.Contains(node, "{") {
+				if ok, _ := path.Match(node, p); !ok {
+					return false, false
+				}
+			}
+			continue
+		}
+		if ok, _ := path.Match(p, node); !ok {
+			return false, false
+		}
+	}
+	return definite, true
+}
+
+// filter returns clients that may hold the metrics: ones of the most specific route that definitely matches the
+// metric and ones of more specific routes that possibly match it. All clients are returned if any of the metrics
+// isn't definitely under one of the routes.
+func (t routingTable) filter(metrics []string, clients []types.ServerClient) []types.ServerClient {
+	if len(t) == 0 || len(metrics) == 0 {
+		return clients
+	}
+
+	routed := make(map[types.ServerClient]struct{})
+	for _, metric := range metrics {
+		nodes := strings.Split(metric, ".")
+		found := false
+		for _, r := range t {
+			definite, possible := r.match(nodes)
+			if !possible {
+				continue
+			}
+			for c := range r.clients {
+				routed[c] = struct{}{}
+			}
+			if definite {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return clients
+		}
+	}
+
+	filtered := make([]types.ServerClient, 0, len(routed))
+	for _, c := range clients {
+		if _, ok := routed[c]; ok {
+			filtered = append(filtered, c)
+		}
+	}
+	return filtered
+}
@@ -0,0 +1,89 @@
+package broadcast
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/go-graphite/carbonapi/zipper/dummy"
+	"github.com/go-graphite/carbonapi/zipper/types"
+
+	protov3 "github.com/go-graphite/protocol/carbonapi_v3_pb"
+)
+
+func TestRoutingTable(t *testing.T) {
+	var clients []types.ServerClient
+	for _, name := range []string{"dc1", "dc2", "dc1-cpu", "other"} {
+		clients = append(clients, dummy.NewDummyClient(name, []string{name}, 1))
+	}
+	table, err := newRoutingTable([]types.Route{
+		{Prefix: "prod.dc1.*", Backends: []string{"dc1"}},
+		{Prefix: "prod.dc2", Backends: []string{"dc2"}},
+		{Prefix: "prod.dc1.cpu", Backends: []string{"dc1-cpu"}},
+		{Prefix: "stage.dc[12]", Backends: []string{"dc1", "dc2"}},
+	}, clients)
+	if err != nil {
+		t.Fatalf("failed to create routing table: %v", err)
+	}
+
+	tests := []struct {
+		metrics  []string
+		expected []string
+	}{
+		{[]string{"prod.dc1.mem.used"}, []string{"dc1"}},
+		{[]string{"prod.dc1.cpu.user"}, []string{"dc1-cpu"}},
+		{[]string{"prod.dc1.*.user"}, []string{"dc1", "dc1-cpu"}},
+		{[]string{"prod.dc2.cpu", "prod.dc1.mem"}, []string{"dc1", "dc2"}},
+		{[]string{"prod.dc1.m*"}, []string{"dc1"}},
+		{[]string{"stage.dc2.cpu"}, []string{"dc1", "dc2"}},
+		// may match metrics that aren't routed
+		{[]string{"prod.*.cpu"}, []string{"dc1", "dc2", "dc1-cpu", "other"}},
+		{[]string{"prod.*"}, []string{"dc1", "dc2", "dc1-cpu", "other"}},
+		{[]string{"prod.dc[23].cpu"}, []string{"dc1", "dc2", "dc1-cpu", "other"}},
+		{[]string{"prod.dc3.cpu"}, []string{"dc1", "dc2", "dc1-cpu", "other"}},
+		{[]string{"prod.dc2.cpu", "test.cpu"}, []string{"dc1", "dc2", "dc1-cpu", "other"}},
+	}
+	for _, tt := range tests {
+		var names []string
+		for _, c := range table.filter(tt.metrics, clients) {
+			names = append(names, c.Name())
+		}
+		if !reflect.DeepEqual(names, tt.expected) {
+			t.Errorf("%v: expected %v, got %v", tt.metrics, tt.expected, names)
+		}
+	}
+
+	if _, err := newRoutingTable([]types.Route{{Prefix: "prod", Backends: []string{"unknown"}}}, clients); err == nil {
+		t.Errorf("route to unknown group should be an error")
+	}
+	if _, err := newRoutingTable([]types.Route{{Prefix: "*", Backends: []string{"dc1"}}}, clients); err == nil {
+		t.Errorf("route without prefix should be an error")
+	}
+}
+
+func TestFindRoutes(t *testing.T) {
+	request := &protov3.MultiGlobRequest{Metrics: []string{"dc1.*"}}
+	var servers []types.ServerClient
+	for _, name := range []string{"dc1", "dc2"} {
+		c := dummy.NewDummyClient(name, []string{name}, 1)
+		c.AddFindResponse(request, &protov3.MultiGlobResponse{
+			Metrics: []protov3.GlobResponse{{Name: "dc1.*", Matches: []protov3.GlobMatch{{Path: "dc1." + name, IsLeaf: true}}}},
+		}, &types.Stats{}, nil)
+		servers = append(servers, c)
+	}
+	b, e := NewBroadcastGroup(logger, "test", servers, 60, 500, timeouts)
+	if e != nil && e.HaveFatalErrors {
+		t.Fatalf("error while initializing group: %v", e)
+	}
+	if err := b.SetRoutes([]types.Route{{Prefix: "dc1", Backends: []string{"dc1"}}}); err != nil {
+		t.Fatalf("failed to set routes: %v", err)
+	}
+
+	res, stats, e := b.Find(context.Background(), request)
+	if (e != nil && len(e.Errors) > 0) || len(res.Metrics) != 1 || len(res.Metrics[0].Matches) != 1 || res.Metrics[0].Matches[0].Path != "dc1.dc1" {
+		t.Fatalf("find should only be sent to routed group, got %+v, %v", res, e)
+	}
+	if stats.ZipperRequests != 1 {
+		t.Fatalf("expected 1 request, got %v", stats.ZipperRequests)
+	}
+}
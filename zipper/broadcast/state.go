@@ -0,0 +1,130 @@
+package broadcast
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-graphite/carbonapi/zipper/types"
+)
+
+// GroupState is a snapshot of statistics that group has learned from responses of its clients
+type GroupState struct {
+	Health        map[string]float64 `json:"health,omitempty"`
+	LatencySpread []time.Duration    `json:"latency_spread,omitempty"`
+	// Paths are servers that have metrics of top level domain, as the last probe has found
+	Paths map[string][]string `json:"paths,omitempty"`
+}
+
+// State returns snapshot of group's statistics
+func (bg *BroadcastGroup) State() GroupState {
+	return GroupState{
+		Health:        bg.health.snapshot(),
+		LatencySpread: bg.spread.snapshot(),
+		Paths:         bg.paths.snapshot(),
+	}
+}
+
+// RestoreState replaces group's statistics with the saved ones. Paths are put to the path cache, so requests are
+// sent only to the servers that have the metrics before the first probe answers, servers that group doesn't have
+// anymore are skipped.
+func (bg *BroadcastGroup) RestoreState(s GroupState) {
+	bg.health.restore(s.Health)
+	bg.spread.restore(s.LatencySpread)
+
+	children := make(map[string]types.ServerClient)
+	for _, c := range bg.Children() {
+		children[c.Name()] = c
+	}
+	paths := make(map[string][]types.ServerClient, len(s.Paths))
+	for tld, servers := range s.Paths {
+		for _, server := range servers {
+			if c, ok := children[server]; ok {
+				paths[tld] = append(paths[tld], c)
+			}
+		}
+	}
+	for tld, clients := range paths {
+		bg.pathCache.Set(tld, clients)
+	}
+	bg.paths.set(paths)
+}
+
+// learnedPaths keeps servers of top level domains by name
+type learnedPaths struct {
+	sync.Mutex
+	servers map[string][]string
+}
+
+func (p *learnedPaths) set(paths map[string][]types.ServerClient) {
+	servers := make(map[string][]string, len(paths))
+	for tld, clients := range paths {
+		for _, c := range clients {
+			servers[tld] = append(servers[tld], c.Name())
+		}
+		sort.Strings(servers[tld])
+	}
+	p.Lock()
+	p.servers = servers
+	p.Unlock()
+}
+
+func (p *learnedPaths) snapshot() map[string][]string {
+	p.Lock()
+	defer p.Unlock()
+	res := make(map[string][]string, len(p.servers))
+	for tld, servers := range p.servers {
+		res[tld] = append([]string(nil), servers...)
+	}
+	return res
+}
+
+func (h *healthScores) snapshot() map[string]float64 {
+	h.Lock()
+	defer h.Unlock()
+	res := make(map[string]float64, len(h.scores))
+	for client, score := range h.scores {
+		res[client] = score
+	}
+	return res
+}
+
+func (h *healthScores) restore(scores map[string]float64) {
+	h.Lock()
+	defer h.Unlock()
+	h.scores = make(map[string]float64, len(scores))
+	for client, score := range scores {
+		if score >= 0 && score <= 1 {
+			h.scores[client] = score
+		}
+	}
+}
+
+// snapshot returns samples from the oldest one to the latest
+func (s *latencySpread) snapshot() []time.Duration {
+	if s == nil {
+		return nil
+	}
+	s.Lock()
+	defer s.Unlock()
+	if !s.full {
+		return append([]time.Duration(nil), s.samples[:s.pos]...)
+	}
+	return append(append([]time.Duration(nil), s.samples[s.pos:]...), s.samples[:s.pos]...)
+}
+
+func (s *latencySpread) restore(samples []time.Duration) {
+	if s == nil {
+		return
+	}
+	s.Lock()
+	s.pos = 0
+	s.full = false
+	s.Unlock()
+	if len(samples) > len(s.samples) {
+		samples = samples[len(samples)-len(s.samples):]
+	}
+	for _, d := range samples {
+		s.Add(d)
+	}
+}
@@ -0,0 +1,213 @@
+package broadcast
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/go-graphite/carbonapi/zipper/types"
+	protov3 "github.com/go-graphite/protocol/carbonapi_v3_pb"
+)
+
+type tier struct {
+	// retention in seconds, 0 means unlimited
+	retention int64
+	clients   map[types.ServerClient]struct{}
+}
+
+// storageTiers choose clients by time range of the render request. Tiers are sorted by retention, the hottest first.
+// Clients that aren't in any tier are always used.
+type storageTiers struct {
+	tiers []tier
+	split bool
+}
+
+func newStorageTiers(config types.StorageTiers, clients []types.ServerClient) (*storageTiers, error) {
+	groups := make(map[string][]types.ServerClient, len(clients))
+	for _, client := range clients {
+		groups[client.Name()] = client.Children()
+	}
+
+	t := &storageTiers{split: config.Split}
+	retentions := make(map[int64]struct{}, len(config.Tiers))
+	for _, c := range config.Tiers {
+		retention := int64(c.Retention.Seconds())
+		if retention < 0 {
+			return nil, fmt.Errorf("tier %v: negative retention", c.Backends)
+		}
+		if _, ok := retentions[retention]; ok {
+			return nil, fmt.Errorf("tier %v: there is another tier with retention %v", c.Backends, c.Retention)
+		}
+		retentions[retention] = struct{}{}
+
+		tr := tier{retention: retention, clients: make(map[types.ServerClient]struct{})}
+		for _, name := range c.Backends {
+			children, ok := groups[name]
+			if !ok {
+				return nil, fmt.Errorf("tier %v: unknown backend group %q", c.Backends, name)
+			}
+			for _, child := range children {
+				tr.clients[child] = struct{}{}
+			}
+		}
+		t.tiers = append(t.tiers, tr)
+	}
+	sort.Slice(t.tiers, func(i, j int) bool {
+		if t.tiers[j].retention == 0 {
+			return t.tiers[i].retention != 0
+		}
+		return t.tiers[i].retention != 0 && t.tiers[i].retention < t.tiers[j].retention
+	})
+	return t, nil
+}
+
+// since returns the oldest timestamp that tier has
+func (tr tier) since(now int64) int64 {
+	if tr.retention == 0 {
+		return 0
+	}
+	return now - tr.retention
+}
+
+// tierPart is the part of the request that is fetched from clients of one tier
+type tierPart struct {
+	request *protov3.MultiFetchRequest
+	clients []types.ServerClient
+}
+
+// parts returns requests for the tiers that have requested time range. Without split, the whole request is sent to
+// the hottest tier that has all of it (or the coldest one). With split, every tier gets its own part of the range:
+// the hottest one gets the most recent data, the next one data before the start of the hottest, and so on.
+func (t *storageTiers) parts(request *protov3.MultiFetchRequest, clients []types.ServerClient, now int64) []tierPart {
+	if t == nil || len(t.tiers) == 0 || len(request.Metrics) == 0 {
+		return []tierPart{{request: request, clients: clients}}
+	}
+
+	from := request.Metrics[0].StartTime
+	for _, m := range request.Metrics {
+		if m.StartTime < from {
+			from = m.StartTime
+		}
+	}
+	last := len(t.tiers) - 1
+	for i, tr := range t.tiers {
+		if from >= tr.since(now) {
+			last = i
+			break
+		}
+	}
+
+	var untiered []types.ServerClient
+	for _, c := range clients {
+		if !t.tiered(c) {
+			untiered = append(untiered, c)
+		}
+	}
+
+	if !t.split || last == 0 {
+		return []tierPart{{request: request, clients: append(t.tiers[last].filter(clients), untiered...)}}
+	}
+
+	var parts []tierPart
+	if len(untiered) > 0 {
+		parts = append(parts, tierPart{request: request, clients: untiered})
+	}
+	// part of the tier ends at the oldest timestamp of the hotter one
+	until := int64(-1)
+	for i := 0; i <= last; i++ {
+		since := t.tiers[i].since(now)
+		if i == last {
+			since = 0
+		}
+		part := &protov3.MultiFetchRequest{}
+		for _, m := range request.Metrics {
+			if m.StartTime < since {
+				m.StartTime = since
+			}
+			if until >= 0 && m.StopTime > until {
+				m.StopTime = until
+			}
+			if m.StartTime > m.StopTime {
+				continue
+			}
+			part.Metrics = append(part.Metrics, m)
+		}
+		until = since
+
+		filtered := t.tiers[i].filter(clients)
+		if len(part.Metrics) == 0 || len(filtered) == 0 {
+			continue
+		}
+		parts = append(parts, tierPart{request: part, clients: filtered})
+	}
+	return parts
+}
+
+func (t *storageTiers) tiered(client types.ServerClient) bool {
+	for _, tr := range t.tiers {
+		if _, ok := tr.clients[client]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (tr tier) filter(clients []types.ServerClient) []types.ServerClient {
+	filtered := make([]types.ServerClient, 0, len(tr.clients))
+	for _, c := range clients {
+		if _, ok := tr.clients[c]; ok {
+			filtered = append(filtered, c)
+		}
+	}
+	return filtered
+}
+
+// stitchParts prepares responses of the parts to be merged: series of the hotter tiers are consolidated to the step
+// of the colder ones, so resolution doesn't change at the boundary, and time range of the original request is set to
+// all of them. Coarse bucket at the start of the hotter part is dropped if it's only partially covered by it, colder
+// part has the whole bucket.
+func stitchParts(request *protov3.MultiFetchRequest, responses []*types.ServerFetchResponse, opts types.MergeOptions) {
+	ranges := make(map[string]protov3.FetchRequest, len(request.Metrics))
+	for _, m := range request.Metrics {
+		ranges[m.Name] = m
+	}
+	key := func(m *protov3.FetchResponse) string {
+		if m.PathExpression != "" {
+			return m.PathExpression
+		}
+		return m.Name
+	}
+
+	steps := make(map[string]int64)
+	for _, r := range responses {
+		for i := range r.Response.Metrics {
+			m := &r.Response.Metrics[i]
+			if m.StepTime > steps[m.Name] {
+				steps[m.Name] = m.StepTime
+			}
+		}
+	}
+
+	for _, r := range responses {
+		for i := range r.Response.Metrics {
+			m := &r.Response.Metrics[i]
+			original, ok := ranges[key(m)]
+			if step := steps[m.Name]; m.StepTime < step {
+				consolidateBy := opts.ConsolidateBy
+				if consolidateBy == "" {
+					consolidateBy = m.ConsolidationFunc
+				}
+				partial := m.StartTime%step != 0
+				later := ok && m.RequestStartTime > original.StartTime
+				types.ResampleFetchResponse(m, step, types.SeriesConsolidation(consolidateBy), opts.XFilesFactor)
+				if partial && later && len(m.Values) > 0 {
+					m.Values[0] = math.NaN()
+				}
+			}
+			if ok {
+				m.RequestStartTime = original.StartTime
+				m.RequestStopTime = original.StopTime
+			}
+		}
+	}
+}
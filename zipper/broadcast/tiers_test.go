@@ -0,0 +1,166 @@
+package broadcast
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/go-graphite/carbonapi/zipper/dummy"
+	"github.com/go-graphite/carbonapi/zipper/types"
+
+	protov3 "github.com/go-graphite/protocol/carbonapi_v3_pb"
+)
+
+func newTierClients() []types.ServerClient {
+	var clients []types.ServerClient
+	for _, name := range []string{"hot", "warm", "cold", "other"} {
+		clients = append(clients, dummy.NewDummyClient(name, []string{name}, 1))
+	}
+	return clients
+}
+
+func TestStorageTiersParts(t *testing.T) {
+	clients := newTierClients()
+	config := types.StorageTiers{
+		Tiers: []types.Tier{
+			{Retention: 0, Backends: []string{"cold"}},
+			{Retention: time.Hour, Backends: []string{"hot"}},
+			{Retention: 24 * time.Hour, Backends: []string{"warm"}},
+		},
+	}
+	now := int64(100000)
+
+	tests := []struct {
+		from     int64
+		split    bool
+		expected [][]interface{}
+	}{
+		{now - 600, false, [][]interface{}{{"hot", "other", now - 600, now}}},
+		{now - 7200, false, [][]interface{}{{"warm", "other", now - 7200, now}}},
+		{now - 100000, false, [][]interface{}{{"cold", "other", now - 100000, now}}},
+		{now - 600, true, [][]interface{}{{"hot", "other", now - 600, now}}},
+		{now - 7200, true, [][]interface{}{
+			{"other", "", now - 7200, now},
+			{"hot", "", now - 3600, now},
+			{"warm", "", now - 7200, now - 3600},
+		}},
+		{now - 100000, true, [][]interface{}{
+			{"other", "", now - 100000, now},
+			{"hot", "", now - 3600, now},
+			{"warm", "", now - 86400, now - 3600},
+			{"cold", "", now - 100000, now - 86400},
+		}},
+	}
+	for _, tt := range tests {
+		config.Split = tt.split
+		tiers, err := newStorageTiers(config, clients)
+		if err != nil {
+			t.Fatalf("failed to create tiers: %v", err)
+		}
+		request := &protov3.MultiFetchRequest{Metrics: []protov3.FetchRequest{{Name: "foo", StartTime: tt.from, StopTime: now}}}
+		var got [][]interface{}
+		for _, part := range tiers.parts(request, clients, now) {
+			names := []string{"", ""}
+			for i, c := range part.clients {
+				names[i] = c.Name()
+			}
+			m := part.request.Metrics[0]
+			got = append(got, []interface{}{names[0], names[1], m.StartTime, m.StopTime})
+		}
+		if !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf("from %v, split %v: expected %v, got %v", now-tt.from, tt.split, tt.expected, got)
+		}
+	}
+
+	if _, err := newStorageTiers(types.StorageTiers{Tiers: []types.Tier{{Backends: []string{"unknown"}}}}, clients); err == nil {
+		t.Errorf("tier of unknown group should be an error")
+	}
+	if _, err := newStorageTiers(types.StorageTiers{Tiers: []types.Tier{{Backends: []string{"hot"}}, {Backends: []string{"cold"}}}}, clients); err == nil {
+		t.Errorf("tiers with the same retention should be an error")
+	}
+}
+
+func TestFetchStorageTiers(t *testing.T) {
+	now := int64(10000)
+	hot := dummy.NewDummyClient("hot", []string{"hot"}, 1)
+	hot.AddFetchResponse(&protov3.MultiFetchRequest{Metrics: []protov3.FetchRequest{{Name: "foo", StartTime: 6400, StopTime: now}}}, &protov3.MultiFetchResponse{
+		Metrics: []protov3.FetchResponse{{Name: "foo", PathExpression: "foo", StartTime: 6400, StopTime: now, StepTime: 1200, RequestStartTime: 6400, RequestStopTime: now, Values: []float64{3, 4, 5}}},
+	}, &types.Stats{}, nil)
+	cold := dummy.NewDummyClient("cold", []string{"cold"}, 1)
+	cold.AddFetchResponse(&protov3.MultiFetchRequest{Metrics: []protov3.FetchRequest{{Name: "foo", StartTime: 4000, StopTime: 6400}}}, &protov3.MultiFetchResponse{
+		Metrics: []protov3.FetchResponse{{Name: "foo", PathExpression: "foo", StartTime: 4000, StopTime: 6400, StepTime: 1200, RequestStartTime: 4000, RequestStopTime: 6400, Values: []float64{1, 2}}},
+	}, &types.Stats{}, nil)
+
+	b, e := NewBroadcastGroup(logger, "test", []types.ServerClient{hot, cold}, 60, 0, timeouts)
+	if e != nil && e.HaveFatalErrors {
+		t.Fatalf("error while initializing group: %v", e)
+	}
+	err := b.SetStorageTiers(types.StorageTiers{
+		Split: true,
+		Tiers: []types.Tier{
+			{Retention: time.Hour, Backends: []string{"hot"}},
+			{Backends: []string{"cold"}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to set storage tiers: %v", err)
+	}
+
+	request := &protov3.MultiFetchRequest{Metrics: []protov3.FetchRequest{{Name: "foo", StartTime: 4000, StopTime: now}}}
+	parts := b.tiers.parts(request, b.clients, now)
+	res, stats, e := b.fetchParts(context.Background(), logger, request, parts)
+	if e != nil && len(e.Errors) > 0 {
+		t.Fatalf("unexpected error %v", e)
+	}
+	// fetch request to every tier
+	if stats.ZipperRequests != 2 || len(res.Metrics) != 1 {
+		t.Fatalf("expected stitched response of 2 tiers, got %v requests, %+v", stats.ZipperRequests, res)
+	}
+	m := res.Metrics[0]
+	if m.StartTime != 4000 || m.RequestStartTime != 4000 || m.RequestStopTime != now || !reflect.DeepEqual(m.Values, []float64{1, 2, 3, 4, 5}) {
+		t.Fatalf("unexpected stitched response %+v", m)
+	}
+}
+
+func TestFetchStorageTiersResolution(t *testing.T) {
+	now := int64(10000)
+	// hot tier keeps 10 minute points, cold one 20 minute points
+	hot := dummy.NewDummyClient("hot", []string{"hot"}, 1)
+	hot.AddFetchResponse(&protov3.MultiFetchRequest{Metrics: []protov3.FetchRequest{{Name: "foo", StartTime: 6400, StopTime: now}}}, &protov3.MultiFetchResponse{
+		Metrics: []protov3.FetchResponse{{Name: "foo", PathExpression: "foo", ConsolidationFunc: "average", StartTime: 6600, StopTime: now, StepTime: 600, RequestStartTime: 6400, RequestStopTime: now, Values: []float64{9, 3, 5, 6, 8, 7}}},
+	}, &types.Stats{}, nil)
+	cold := dummy.NewDummyClient("cold", []string{"cold"}, 1)
+	cold.AddFetchResponse(&protov3.MultiFetchRequest{Metrics: []protov3.FetchRequest{{Name: "foo", StartTime: 4000, StopTime: 6400}}}, &protov3.MultiFetchResponse{
+		Metrics: []protov3.FetchResponse{{Name: "foo", PathExpression: "foo", ConsolidationFunc: "average", StartTime: 4800, StopTime: 6400, StepTime: 1200, RequestStartTime: 4000, RequestStopTime: 6400, Values: []float64{1, 2}}},
+	}, &types.Stats{}, nil)
+
+	b, e := NewBroadcastGroup(logger, "test", []types.ServerClient{hot, cold}, 60, 0, timeouts)
+	if e != nil && e.HaveFatalErrors {
+		t.Fatalf("error while initializing group: %v", e)
+	}
+	err := b.SetStorageTiers(types.StorageTiers{
+		Split: true,
+		Tiers: []types.Tier{
+			{Retention: time.Hour, Backends: []string{"hot"}},
+			{Backends: []string{"cold"}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to set storage tiers: %v", err)
+	}
+
+	request := &protov3.MultiFetchRequest{Metrics: []protov3.FetchRequest{{Name: "foo", StartTime: 4000, StopTime: now}}}
+	res, _, e := b.fetchParts(context.Background(), logger, request, b.tiers.parts(request, b.clients, now))
+	if e != nil && len(e.Errors) > 0 {
+		t.Fatalf("unexpected error %v", e)
+	}
+	if len(res.Metrics) != 1 {
+		t.Fatalf("expected stitched response, got %+v", res)
+	}
+	// hot points are averaged into 20 minute buckets, partially covered bucket at the boundary is taken from cold tier
+	m := res.Metrics[0]
+	if m.StartTime != 4800 || m.StepTime != 1200 || !reflect.DeepEqual(m.Values, []float64{1, 2, 4, 7, 7}) {
+		t.Fatalf("unexpected stitched response %+v", m)
+	}
+}
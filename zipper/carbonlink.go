@@ -0,0 +1,432 @@
+package zipper
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-graphite/carbonapi/zipper/broadcast"
+	"github.com/go-graphite/carbonapi/zipper/types"
+	protov3 "github.com/go-graphite/protocol/carbonapi_v3_pb"
+	pickle "github.com/lomik/og-rek"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultCarbonlinkTimeout      = 100 * time.Millisecond
+	defaultCarbonlinkLookback     = 10 * time.Minute
+	defaultCarbonlinkMaxIdleConns = 4
+	// carbonlinkMaxResponse limits size of the single response, caches don't keep that many points of the metric
+	carbonlinkMaxResponse = 16 << 20
+)
+
+// carbonlinkErrors is total amount of carbonlink servers that failed to answer
+var carbonlinkErrors int64
+
+// CarbonlinkErrors returns amount of times carbonlink servers failed to answer, points that weren't written yet are
+// missing from the responses then
+func CarbonlinkErrors() int64 {
+	return atomic.LoadInt64(&carbonlinkErrors)
+}
+
+type carbonlinkPoint struct {
+	timestamp int64
+	value     float64
+}
+
+// carbonlinkConn is the connection to the cache, it's kept open between requests
+type carbonlinkConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+// carbonlinkServer keeps idle connections to the cache
+type carbonlinkServer struct {
+	address string
+	idle    chan *carbonlinkConn
+	// noBulk is set once the cache rejects bulk query, it's asked for metrics one by one then
+	noBulk int32
+
+	// closed is set once idle connections are closed, connections that are still in use are closed when they are
+	// returned then
+	mu     sync.RWMutex
+	closed bool
+}
+
+// get returns idle connection or opens a new one, reused is true for the idle one
+func (s *carbonlinkServer) get(ctx context.Context) (conn *carbonlinkConn, reused bool, err error) {
+	select {
+	case conn := <-s.idle:
+		return conn, true, nil
+	default:
+	}
+	var d net.Dialer
+	c, err := d.DialContext(ctx, "tcp", s.address)
+	if err != nil {
+		return nil, false, err
+	}
+	return &carbonlinkConn{Conn: c, r: bufio.NewReader(c)}, false, nil
+}
+
+// put returns connection to the idle ones or closes it if there are enough of them
+func (s *carbonlinkServer) put(conn *carbonlinkConn) {
+	_ = conn.SetDeadline(time.Time{})
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closed {
+		conn.Close()
+		return
+	}
+	select {
+	case s.idle <- conn:
+	default:
+		conn.Close()
+	}
+}
+
+// close closes idle connections, connections that are returned later are closed as well
+func (s *carbonlinkServer) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	for {
+		select {
+		case conn := <-s.idle:
+			conn.Close()
+		default:
+			return
+		}
+	}
+}
+
+// carbonlink queries caches of carbon (the same protocol as graphite-web uses) for points that backends don't have
+// yet and puts them onto the fetched series
+type carbonlink struct {
+	servers  []*carbonlinkServer
+	ring     *broadcast.HashRing
+	timeout  time.Duration
+	lookback time.Duration
+	logger   *zap.Logger
+
+	now func() time.Time
+}
+
+func newCarbonlink(logger *zap.Logger, config types.Carbonlink) (*carbonlink, error) {
+	if len(config.Servers) == 0 {
+		return nil, nil
+	}
+	if config.Hashing.Type == "" {
+		config.Hashing.Type = broadcast.HashCarbon
+	}
+	ring, err := broadcast.NewHashRing(config.Hashing, config.Servers)
+	if err != nil {
+		return nil, err
+	}
+	if config.MaxIdleConns <= 0 {
+		config.MaxIdleConns = defaultCarbonlinkMaxIdleConns
+	}
+
+	c := &carbonlink{
+		ring:     ring,
+		timeout:  config.Timeout,
+		lookback: config.Lookback,
+		logger:   logger.With(zap.String("type", "carbonlink")),
+		now:      time.Now,
+	}
+	for _, address := range config.Servers {
+		c.servers = append(c.servers, &carbonlinkServer{
+			address: address,
+			idle:    make(chan *carbonlinkConn, config.MaxIdleConns),
+		})
+	}
+	if c.timeout <= 0 {
+		c.timeout = defaultCarbonlinkTimeout
+	}
+	if c.lookback <= 0 {
+		c.lookback = defaultCarbonlinkLookback
+	}
+	return c, nil
+}
+
+// close closes idle connections to the caches, it's called once carbonlink is replaced
+func (c *carbonlink) close() {
+	if c == nil {
+		return
+	}
+	for _, s := range c.servers {
+		s.close()
+	}
+}
+
+// carbonlinkRequest returns cache query: pickled dict prefixed by its length
+func carbonlinkRequest(query map[string]interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.Write([]byte{0, 0, 0, 0})
+	err := pickle.NewEncoder(&buf).Encode(query)
+	if err != nil {
+		return nil, err
+	}
+	b := buf.Bytes()
+	binary.BigEndian.PutUint32(b, uint32(len(b)-4))
+	return b, nil
+}
+
+func carbonlinkNumber(v interface{}) (float64, bool) {
+	switch v := v.(type) {
+	case float64:
+		return v, true
+	case int64:
+		return float64(v), true
+	case *big.Int:
+		f, _ := new(big.Float).SetInt(v).Float64()
+		return f, true
+	}
+	return 0, false
+}
+
+// carbonlinkRejected is the error that cache has answered with, connection is still usable after it
+type carbonlinkRejected struct {
+	reason interface{}
+}
+
+func (e carbonlinkRejected) Error() string {
+	return fmt.Sprintf("carbonlink: %v", e.reason)
+}
+
+// readCarbonlinkResponse reads response to the cache query, it's a pickled dict
+func readCarbonlinkResponse(r io.Reader) (map[interface{}]interface{}, error) {
+	var size [4]byte
+	if _, err := io.ReadFull(r, size[:]); err != nil {
+		return nil, err
+	}
+	n := binary.BigEndian.Uint32(size[:])
+	if n > carbonlinkMaxResponse {
+		return nil, fmt.Errorf("carbonlink: response is too large: %v bytes", n)
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, err
+	}
+
+	decoded, err := pickle.NewDecoder(bytes.NewReader(b)).Decode()
+	if err != nil {
+		return nil, err
+	}
+	d, ok := decoded.(map[interface{}]interface{})
+	if !ok {
+		return nil, fmt.Errorf("carbonlink: unexpected response type %T", decoded)
+	}
+	if e, ok := d["error"]; ok {
+		return nil, carbonlinkRejected{reason: e}
+	}
+	return d, nil
+}
+
+// carbonlinkDatapoints decodes [(timestamp, value), ...] list of the response
+func carbonlinkDatapoints(v interface{}) ([]carbonlinkPoint, error) {
+	list, ok := v.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("carbonlink: response has no datapoints")
+	}
+
+	points := make([]carbonlinkPoint, 0, len(list))
+	for _, item := range list {
+		t, ok := item.(pickle.Tuple)
+		if !ok || len(t) != 2 {
+			return nil, fmt.Errorf("carbonlink: unexpected datapoint %v", item)
+		}
+		ts, ok := carbonlinkNumber(t[0])
+		if !ok {
+			return nil, fmt.Errorf("carbonlink: unexpected timestamp %v", t[0])
+		}
+		v, ok := carbonlinkNumber(t[1])
+		if !ok {
+			// None
+			continue
+		}
+		points = append(points, carbonlinkPoint{timestamp: int64(ts), value: v})
+	}
+	return points, nil
+}
+
+// roundTrip sends the query and reads response to it
+func (conn *carbonlinkConn) roundTrip(query map[string]interface{}) (map[interface{}]interface{}, error) {
+	req, err := carbonlinkRequest(query)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := conn.Write(req); err != nil {
+		return nil, err
+	}
+	return readCarbonlinkResponse(conn.r)
+}
+
+// queryBulk asks for all the metrics with single cache-query-bulk, carbon-cache answers it with
+// {"datapointsByMetric": {metric: [(timestamp, value), ...]}}
+func (conn *carbonlinkConn) queryBulk(names []string) (map[string][]carbonlinkPoint, error) {
+	metrics := make([]interface{}, 0, len(names))
+	for _, name := range names {
+		metrics = append(metrics, name)
+	}
+	d, err := conn.roundTrip(map[string]interface{}{"type": "cache-query-bulk", "metrics": metrics})
+	if err != nil {
+		return nil, err
+	}
+	byMetric, ok := d["datapointsByMetric"].(map[interface{}]interface{})
+	if !ok {
+		return nil, fmt.Errorf("carbonlink: response has no datapointsByMetric")
+	}
+
+	res := make(map[string][]carbonlinkPoint)
+	for name, v := range byMetric {
+		name, ok := name.(string)
+		if !ok {
+			return nil, fmt.Errorf("carbonlink: unexpected metric name %v", name)
+		}
+		points, err := carbonlinkDatapoints(v)
+		if err != nil {
+			return nil, err
+		}
+		if len(points) > 0 {
+			res[name] = points
+		}
+	}
+	return res, nil
+}
+
+// queryEach asks for metrics one by one, for the caches that don't support bulk queries (e.g. go-carbon)
+func (conn *carbonlinkConn) queryEach(names []string) (map[string][]carbonlinkPoint, error) {
+	res := make(map[string][]carbonlinkPoint)
+	for _, name := range names {
+		d, err := conn.roundTrip(map[string]interface{}{"type": "cache-query", "metric": name})
+		if err != nil {
+			return nil, err
+		}
+		points, err := carbonlinkDatapoints(d["datapoints"])
+		if err != nil {
+			return nil, err
+		}
+		if len(points) > 0 {
+			res[name] = points
+		}
+	}
+	return res, nil
+}
+
+// query asks the server for cached points of the metrics. Idle connection that was closed by the server is
+// replaced by a new one.
+func (c *carbonlink) query(ctx context.Context, server *carbonlinkServer, names []string) (map[string][]carbonlinkPoint, error) {
+	for {
+		conn, reused, err := server.get(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if deadline, ok := ctx.Deadline(); ok {
+			_ = conn.SetDeadline(deadline)
+		}
+
+		var res map[string][]carbonlinkPoint
+		if atomic.LoadInt32(&server.noBulk) == 0 {
+			res, err = conn.queryBulk(names)
+			if _, ok := err.(carbonlinkRejected); ok {
+				c.logger.Info("cache doesn't support bulk queries, metrics will be queried one by one",
+					zap.String("server", server.address),
+					zap.Error(err),
+				)
+				atomic.StoreInt32(&server.noBulk, 1)
+				res, err = conn.queryEach(names)
+			}
+		} else {
+			res, err = conn.queryEach(names)
+		}
+
+		if err == nil {
+			server.put(conn)
+			return res, nil
+		}
+		conn.Close()
+		if !reused || ctx.Err() != nil {
+			return nil, err
+		}
+	}
+}
+
+// overlay puts cached points onto the series that end within lookback. Points are consolidated to the step of the
+// series by its consolidation function. Cached value replaces the one of the backend, as it's newer: points of the
+// current interval are updated in cache until they are written. Every series is asked from the caches that own it,
+// caches that don't answer in time are skipped.
+func (c *carbonlink) overlay(ctx context.Context, metrics []protov3.FetchResponse) {
+	since := c.now().Add(-c.lookback).Unix()
+	names := make([][]string, len(c.servers))
+	seen := make(map[string]struct{})
+	for _, m := range metrics {
+		if _, ok := seen[m.Name]; ok || m.StopTime < since || m.StepTime <= 0 {
+			continue
+		}
+		seen[m.Name] = struct{}{}
+		for _, owner := range c.ring.Owners(m.Name) {
+			names[owner] = append(names[owner], m.Name)
+		}
+	}
+	if len(seen) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	results := make([]map[string][]carbonlinkPoint, len(c.servers))
+	var wg sync.WaitGroup
+	for i, server := range c.servers {
+		if len(names[i]) == 0 {
+			continue
+		}
+		wg.Add(1)
+		go func(i int, server *carbonlinkServer) {
+			defer wg.Done()
+			res, err := c.query(ctx, server, names[i])
+			if err != nil {
+				atomic.AddInt64(&carbonlinkErrors, 1)
+				c.logger.Warn("failed to query cache",
+					zap.String("server", server.address),
+					zap.Error(err),
+				)
+				return
+			}
+			results[i] = res
+		}(i, server)
+	}
+	wg.Wait()
+
+	for i := range metrics {
+		m := &metrics[i]
+		buckets := make(map[int64][]float64)
+		for _, res := range results {
+			for _, p := range res[m.Name] {
+				if p.timestamp < m.StartTime {
+					continue
+				}
+				idx := (p.timestamp - m.StartTime) / m.StepTime
+				if idx >= int64(len(m.Values)) {
+					continue
+				}
+				buckets[idx] = append(buckets[idx], p.value)
+			}
+		}
+		if len(buckets) == 0 {
+			continue
+		}
+		consolidate := types.SeriesConsolidation(m.ConsolidationFunc)
+		for idx, values := range buckets {
+			m.Values[idx] = consolidate(values)
+		}
+	}
+}
@@ -0,0 +1,242 @@
+package zipper
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"math"
+	"net"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-graphite/carbonapi/zipper/types"
+	protov3 "github.com/go-graphite/protocol/carbonapi_v3_pb"
+	pickle "github.com/lomik/og-rek"
+	"go.uber.org/zap"
+)
+
+// carbonlinkCache answers cache queries with points, metrics without them get empty list
+type carbonlinkCache struct {
+	points map[string][]pickle.Tuple
+	// noBulk makes cache reject bulk queries as go-carbon does
+	noBulk bool
+
+	mu          sync.Mutex
+	connections int
+	queried     []string
+}
+
+func (c *carbonlinkCache) datapoints(metric string) []pickle.Tuple {
+	c.mu.Lock()
+	c.queried = append(c.queried, metric)
+	c.mu.Unlock()
+	if datapoints := c.points[metric]; datapoints != nil {
+		return datapoints
+	}
+	return []pickle.Tuple{}
+}
+
+func (c *carbonlinkCache) answer(req map[interface{}]interface{}) map[string]interface{} {
+	if req["type"] == "cache-query" {
+		return map[string]interface{}{"datapoints": c.datapoints(req["metric"].(string))}
+	}
+	if req["type"] == "cache-query-bulk" && !c.noBulk {
+		byMetric := make(map[string]interface{})
+		for _, metric := range req["metrics"].([]interface{}) {
+			byMetric[metric.(string)] = c.datapoints(metric.(string))
+		}
+		return map[string]interface{}{"datapointsByMetric": byMetric}
+	}
+	return map[string]interface{}{"error": "Invalid request type"}
+}
+
+func (c *carbonlinkCache) serve(t *testing.T) (string, func()) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			c.mu.Lock()
+			c.connections++
+			c.mu.Unlock()
+			go func(conn net.Conn) {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				for {
+					var size [4]byte
+					if _, err := io.ReadFull(r, size[:]); err != nil {
+						return
+					}
+					b := make([]byte, binary.BigEndian.Uint32(size[:]))
+					if _, err := io.ReadFull(r, b); err != nil {
+						return
+					}
+					req, err := pickle.NewDecoder(bytes.NewReader(b)).Decode()
+					if err != nil {
+						return
+					}
+
+					var buf bytes.Buffer
+					buf.Write([]byte{0, 0, 0, 0})
+					_ = pickle.NewEncoder(&buf).Encode(c.answer(req.(map[interface{}]interface{})))
+					res := buf.Bytes()
+					binary.BigEndian.PutUint32(res, uint32(len(res)-4))
+					if _, err := conn.Write(res); err != nil {
+						return
+					}
+				}
+			}(conn)
+		}
+	}()
+	return l.Addr().String(), func() { l.Close() }
+}
+
+func TestCarbonlinkOverlay(t *testing.T) {
+	cache1 := &carbonlinkCache{points: map[string][]pickle.Tuple{
+		"foo": {{int64(125), 3.0}, {int64(135), 5.0}, {int64(1000), 1.0}},
+		"bar": {{int64(130), 10.0}},
+	}}
+	server1, stop1 := cache1.serve(t)
+	defer stop1()
+	// go-carbon
+	cache2 := &carbonlinkCache{points: map[string][]pickle.Tuple{
+		"bar": {{int64(150), 20.0}},
+	}, noBulk: true}
+	server2, stop2 := cache2.serve(t)
+	defer stop2()
+
+	c, err := newCarbonlink(zap.NewNop(), types.Carbonlink{
+		Servers: []string{server1, server2},
+		Hashing: types.Hashing{ReplicationFactor: 2},
+		Timeout: time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.now = func() time.Time { return time.Unix(200, 0) }
+
+	for i := 0; i < 2; i++ {
+		nan := math.NaN()
+		metrics := []protov3.FetchResponse{
+			{Name: "foo", StartTime: 60, StopTime: 180, StepTime: 60, ConsolidationFunc: "sum", Values: []float64{1, 2}},
+			{Name: "bar", StartTime: 60, StopTime: 180, StepTime: 60, ConsolidationFunc: "average", Values: []float64{1, nan}},
+			{Name: "old", StartTime: 0, StopTime: 60, StepTime: 60, Values: []float64{nan}},
+		}
+		c.overlay(context.Background(), metrics)
+
+		if v := metrics[0].Values; v[0] != 1 || v[1] != 8 {
+			t.Fatalf("cached points should be consolidated onto the series, got %v", v)
+		}
+		if v := metrics[1].Values; v[0] != 1 || v[1] != 15 {
+			t.Fatalf("points of all the caches should be merged, got %v", v)
+		}
+		if !math.IsNaN(metrics[2].Values[0]) {
+			t.Fatalf("old series should not be changed")
+		}
+	}
+
+	if cache1.connections != 1 || cache2.connections != 1 {
+		t.Fatalf("connections should be reused, got %v and %v", cache1.connections, cache2.connections)
+	}
+	if atomic.LoadInt32(&c.servers[0].noBulk) != 0 || atomic.LoadInt32(&c.servers[1].noBulk) != 1 {
+		t.Fatalf("only the cache that rejects bulk queries should be queried by metric")
+	}
+}
+
+func TestCarbonlinkHashing(t *testing.T) {
+	var caches []*carbonlinkCache
+	var servers []string
+	for i := 0; i < 3; i++ {
+		cache := &carbonlinkCache{}
+		server, stop := cache.serve(t)
+		defer stop()
+		caches = append(caches, cache)
+		servers = append(servers, server)
+	}
+
+	c, err := newCarbonlink(zap.NewNop(), types.Carbonlink{
+		Servers: servers,
+		Hashing: types.Hashing{Destinations: []string{"10.0.0.1:2004:a", "10.0.0.2:2004:b", "10.0.0.3:2004"}},
+		Timeout: time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.now = func() time.Time { return time.Unix(200, 0) }
+
+	// owners are the same as in TestHashRingOwners of broadcast groups
+	metrics := []protov3.FetchResponse{
+		{Name: "carbon.agents.a.cpu", StartTime: 60, StopTime: 180, StepTime: 60, Values: []float64{1, 2}},
+		{Name: "prod.dc1.host1.cpu.user", StartTime: 60, StopTime: 180, StepTime: 60, Values: []float64{1, 2}},
+		{Name: "foo", StartTime: 60, StopTime: 180, StepTime: 60, Values: []float64{1, 2}},
+	}
+	c.overlay(context.Background(), metrics)
+
+	expected := [][]string{{"carbon.agents.a.cpu"}, {"prod.dc1.host1.cpu.user"}, {"foo"}}
+	for i, cache := range caches {
+		if !reflect.DeepEqual(cache.queried, expected[i]) {
+			t.Errorf("cache %v: expected queries of %v, got %v", i, expected[i], cache.queried)
+		}
+	}
+}
+
+func TestCarbonlinkUnavailable(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := l.Addr().String()
+	l.Close()
+
+	c, err := newCarbonlink(zap.NewNop(), types.Carbonlink{Servers: []string{server}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	failed := CarbonlinkErrors()
+	metrics := []protov3.FetchResponse{{Name: "foo", StartTime: 60, StopTime: time.Now().Unix(), StepTime: 60, Values: []float64{1}}}
+	c.overlay(context.Background(), metrics)
+	if metrics[0].Values[0] != 1 || CarbonlinkErrors() != failed+1 {
+		t.Fatalf("unavailable cache should be skipped and counted")
+	}
+}
+
+func TestCarbonlinkClose(t *testing.T) {
+	cache := &carbonlinkCache{points: map[string][]pickle.Tuple{}}
+	server, stop := cache.serve(t)
+	defer stop()
+
+	c, err := newCarbonlink(zap.NewNop(), types.Carbonlink{Servers: []string{server}, Timeout: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	overlay := func() {
+		metrics := []protov3.FetchResponse{{Name: "foo", StartTime: 60, StopTime: time.Now().Unix(), StepTime: 60, Values: []float64{1}}}
+		c.overlay(context.Background(), metrics)
+	}
+
+	overlay()
+	if len(c.servers[0].idle) != 1 {
+		t.Fatalf("connection should be kept idle, got %v", len(c.servers[0].idle))
+	}
+	c.close()
+	if len(c.servers[0].idle) != 0 {
+		t.Fatalf("idle connections should be closed, got %v", len(c.servers[0].idle))
+	}
+	overlay()
+	if len(c.servers[0].idle) != 0 {
+		t.Fatalf("connections of closed carbonlink should not be kept, got %v", len(c.servers[0].idle))
+	}
+
+	var disabled *carbonlink
+	disabled.close()
+}
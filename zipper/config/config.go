@@ -10,6 +10,7 @@ import (
 type Config struct {
 	ConcurrencyLimitPerServer int              `mapstructure:"concurrencyLimitPerServer"`
 	MaxIdleConnsPerHost       int              `mapstructure:"maxIdleConnsPerHost"`
+	Transport                 types.Transport  `mapstructure:"transport"`
 	Backends                  []string         `mapstructure:"backends"`
 	BackendsV2                types.BackendsV2 `mapstructure:"backendsv2"`
 	MaxBatchSize              int              `mapstructure:"maxBatchSize"`
@@ -21,5 +22,21 @@ type Config struct {
 	ExpireDelaySec       int32
 	InternalRoutingCache time.Duration
 	Timeouts             types.Timeouts
-	KeepAliveInterval    time.Duration `yaml:"keepAliveInterval"`
+	RetryBudget          types.RetryBudget           `mapstructure:"retryBudget"`
+	NotFoundCacheTTL     time.Duration               `mapstructure:"notFoundCacheTTL"`
+	DecodeQuarantine     types.DecodeErrorQuarantine `mapstructure:"decodeErrorQuarantine"`
+	StrictDecode         bool                        `mapstructure:"strictDecode"`
+	MaxResponseSize      int64                       `mapstructure:"maxResponseSize"`
+	ConsolidateBy        string                      `mapstructure:"consolidateBy"`
+	XFilesFactor         float32                     `mapstructure:"xFilesFactor"`
+	MergePolicy          string                      `mapstructure:"mergePolicy"`
+	KeepAliveInterval    time.Duration               `yaml:"keepAliveInterval"`
+	ExpandGlobs          bool                        `mapstructure:"expandGlobs"`
+	StatePersistence     types.StatePersistence      `mapstructure:"statePersistence"`
+	Carbonlink           types.Carbonlink            `mapstructure:"carbonlink"`
+	Routes               []types.Route               `mapstructure:"routes"`
+	StorageTiers         types.StorageTiers          `mapstructure:"storageTiers"`
+	Failover             types.Failover              `mapstructure:"failover"`
+	// Quorum is minimal amount of backend groups that must answer successfully, otherwise request fails
+	Quorum int `mapstructure:"quorum"`
 }
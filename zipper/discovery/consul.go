@@ -0,0 +1,109 @@
+// +build !nodiscovery
+
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// ConsulPrefix marks server that is a service registered in Consul, e.x.
+// "consul+http://127.0.0.1:8500/graphite-store?tag=prod&tag=dc1". It's resolved to every instance of the service
+// that passes health checks and has all of the tags. Datacenter may be set with "dc" parameter, scheme of the
+// instances with "scheme" (http by default).
+const ConsulPrefix = "consul+"
+
+// consulServiceEntry is the part of Consul's /v1/health/service response that is used
+type consulServiceEntry struct {
+	Node struct {
+		Address string
+	}
+	Service struct {
+		Address string
+		Port    int
+		Tags    []string
+	}
+}
+
+// consulService returns addresses of healthy instances of the service and URL that they are served with
+func (r Resolver) consulService(ctx context.Context, u *url.URL) ([]string, *url.URL, error) {
+	if u.Path == "" || u.Path == "/" {
+		return nil, nil, errors.New("no service")
+	}
+	params := u.Query()
+	scheme := params.Get("scheme")
+	if scheme == "" {
+		scheme = "http"
+	}
+	tags := params["tag"]
+
+	query := url.Values{"passing": []string{"1"}}
+	if dc := params.Get("dc"); dc != "" {
+		query.Set("dc", dc)
+	}
+	for _, tag := range tags {
+		query.Add("tag", tag)
+	}
+	api := url.URL{
+		Scheme:   u.Scheme,
+		Host:     u.Host,
+		Path:     "/v1/health/service" + u.Path,
+		RawQuery: query.Encode(),
+	}
+	req, err := http.NewRequest("GET", api.String(), nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	client := r.HTTP
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+
+	var entries []consulServiceEntry
+	if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+		return nil, nil, err
+	}
+	hosts := make([]string, 0, len(entries))
+	for _, e := range entries {
+		// older Consul filters by the first tag only
+		if !hasTags(e.Service.Tags, tags) {
+			continue
+		}
+		address := e.Service.Address
+		if address == "" {
+			address = e.Node.Address
+		}
+		hosts = append(hosts, net.JoinHostPort(address, strconv.Itoa(e.Service.Port)))
+	}
+	return hosts, &url.URL{Scheme: scheme}, nil
+}
+
+func hasTags(tags, required []string) bool {
+	for _, r := range required {
+		found := false
+		for _, tag := range tags {
+			if tag == r {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
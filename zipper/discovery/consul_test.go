@@ -0,0 +1,52 @@
+// +build !nodiscovery
+
+package discovery
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestConsulService(t *testing.T) {
+	var query string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/v1/health/service/graphite-store" {
+			http.NotFound(w, req)
+			return
+		}
+		query = req.URL.RawQuery
+		w.Write([]byte(`[
+			{"Node": {"Address": "10.0.0.1"}, "Service": {"Address": "", "Port": 8080, "Tags": ["prod", "dc1"]}},
+			{"Node": {"Address": "10.0.0.2"}, "Service": {"Address": "10.1.0.2", "Port": 8081, "Tags": ["dc1", "prod"]}},
+			{"Node": {"Address": "10.0.0.3"}, "Service": {"Address": "", "Port": 8080, "Tags": ["prod"]}}
+		]`))
+	}))
+	defer srv.Close()
+	agent := strings.TrimPrefix(srv.URL, "http://")
+	r := Resolver{HTTP: srv.Client()}
+
+	servers, err := r.ResolveServers(context.Background(), []string{
+		"carbonapi_v3_pb+consul+http://" + agent + "/graphite-store?tag=prod&tag=dc1&dc=east&scheme=https",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	// instance without dc1 tag is skipped, as older Consul filters by the first tag only
+	expected := []string{"carbonapi_v3_pb+https://10.0.0.1:8080", "carbonapi_v3_pb+https://10.1.0.2:8081"}
+	if !reflect.DeepEqual(servers, expected) {
+		t.Errorf("expected %v, got %v", expected, servers)
+	}
+	if query != "dc=east&passing=1&tag=prod&tag=dc1" {
+		t.Errorf("unexpected query %q", query)
+	}
+
+	for _, server := range []string{"consul+http://" + agent + "/unknown", "consul+http://" + agent} {
+		if _, err := r.ResolveServers(context.Background(), []string{server}); err == nil {
+			t.Errorf("%v: expected error", server)
+		}
+	}
+}
@@ -0,0 +1,194 @@
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-graphite/carbonapi/zipper/types"
+)
+
+const (
+	// DNSPrefix marks server which host is resolved to A and AAAA records, e.x. "dns+http://graphite.local:8080"
+	DNSPrefix = "dns+"
+	// SRVPrefix marks server which host is a name of SRV records, port of the server is taken from them,
+	// e.x. "dnssrv+http://_graphite._tcp.example.com"
+	SRVPrefix = "dnssrv+"
+)
+
+// DNSResolver looks up DNS records, it's implemented by net.Resolver
+type DNSResolver interface {
+	LookupHost(ctx context.Context, host string) ([]string, error)
+	LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+}
+
+// Resolver resolves servers that are DNS names or services of the registries
+type Resolver struct {
+	DNS DNSResolver
+	// HTTP is used to query registries, e.x. Consul
+	HTTP *http.Client
+}
+
+// kinds are prefixes of the servers that are resolved
+var kinds = []string{DNSPrefix, SRVPrefix, ConsulPrefix, EtcdPrefix}
+
+func isKind(prefix string) bool {
+	for _, kind := range kinds {
+		if prefix == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// splitServer returns protocol prefix of the backend (e.x. "carbonapi_v3_pb+"), kind of the lookup and the rest of
+// the server. Kind is empty if server is static.
+func splitServer(server string) (string, string, string) {
+	protocol := ""
+	if i := strings.Index(server, "+"); i > 0 && !strings.ContainsAny(server[:i], ":/") && !isKind(server[:i+1]) {
+		protocol, server = server[:i+1], server[i+1:]
+	}
+	for _, kind := range kinds {
+		if strings.HasPrefix(server, kind) {
+			return protocol, kind, server[len(kind):]
+		}
+	}
+	return protocol, "", server
+}
+
+// IsDynamic returns true if server is a DNS name or a service that should be resolved
+func IsDynamic(server string) bool {
+	_, kind, _ := splitServer(server)
+	return kind != ""
+}
+
+// resolve returns servers that DNS name or service resolves to, sorted
+func (r Resolver) resolve(ctx context.Context, server string) ([]string, error) {
+	protocol, kind, rest := splitServer(server)
+	u, err := url.Parse(rest)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", server, err)
+	}
+	if u.Hostname() == "" {
+		return nil, fmt.Errorf("%v: no host", server)
+	}
+
+	var hosts, servers []string
+	switch kind {
+	case DNSPrefix:
+		hosts, err = r.lookupHost(ctx, u)
+	case SRVPrefix:
+		hosts, err = r.lookupSRV(ctx, u)
+	case ConsulPrefix:
+		hosts, u, err = r.consulService(ctx, u)
+	case EtcdPrefix:
+		servers, err = r.etcdServers(ctx, u)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", server, err)
+	}
+	for _, host := range hosts {
+		resolved := *u
+		resolved.Host = host
+		servers = append(servers, resolved.String())
+	}
+	if len(servers) == 0 {
+		return nil, fmt.Errorf("%v: resolved to no addresses", server)
+	}
+
+	sort.Strings(servers)
+	res := make([]string, 0, len(servers))
+	for i, s := range servers {
+		if i > 0 && s == servers[i-1] {
+			continue
+		}
+		res = append(res, protocol+s)
+	}
+	return res, nil
+}
+
+// lookupHost returns addresses of the host with port of the server
+func (r Resolver) lookupHost(ctx context.Context, u *url.URL) ([]string, error) {
+	addrs, err := r.DNS.LookupHost(ctx, u.Hostname())
+	if err != nil {
+		return nil, err
+	}
+	hosts := make([]string, 0, len(addrs))
+	for _, addr := range addrs {
+		if u.Port() != "" {
+			hosts = append(hosts, net.JoinHostPort(addr, u.Port()))
+		} else if strings.Contains(addr, ":") {
+			hosts = append(hosts, "["+addr+"]")
+		} else {
+			hosts = append(hosts, addr)
+		}
+	}
+	return hosts, nil
+}
+
+// lookupSRV returns targets and ports of the SRV records
+func (r Resolver) lookupSRV(ctx context.Context, u *url.URL) ([]string, error) {
+	_, records, err := r.DNS.LookupSRV(ctx, "", "", u.Hostname())
+	if err != nil {
+		return nil, err
+	}
+	hosts := make([]string, 0, len(records))
+	for _, srv := range records {
+		hosts = append(hosts, net.JoinHostPort(strings.TrimSuffix(srv.Target, "."), strconv.Itoa(int(srv.Port))))
+	}
+	return hosts, nil
+}
+
+// ResolveServers replaces DNS names and services by the servers they resolve to. Other servers are kept as is.
+// Servers are returned as is if there is nothing to resolve.
+func (r Resolver) ResolveServers(ctx context.Context, servers []string) ([]string, error) {
+	var res []string
+	for i, server := range servers {
+		if !IsDynamic(server) {
+			if res != nil {
+				res = append(res, server)
+			}
+			continue
+		}
+		if res == nil {
+			res = append(make([]string, 0, len(servers)), servers[:i]...)
+		}
+		resolved, err := r.resolve(ctx, server)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, resolved...)
+	}
+	if res == nil {
+		return servers, nil
+	}
+	return res, nil
+}
+
+// ResolveBackends returns copy of backends with DNS names and services of the servers resolved. Groups with hashing
+// must list their servers, as hashing depends on their order.
+func (r Resolver) ResolveBackends(ctx context.Context, backends types.BackendsV2) (types.BackendsV2, error) {
+	groups := make([]types.BackendV2, len(backends.Backends))
+	for i, backend := range backends.Backends {
+		if backend.Hashing.Type != "" {
+			for _, server := range backend.Servers {
+				if IsDynamic(server) {
+					return backends, fmt.Errorf("group %v: hashing can't be used with DNS names and services", backend.GroupName)
+				}
+			}
+		}
+		servers, err := r.ResolveServers(ctx, backend.Servers)
+		if err != nil {
+			return backends, fmt.Errorf("group %v: %v", backend.GroupName, err)
+		}
+		backend.Servers = servers
+		groups[i] = backend
+	}
+	backends.Backends = groups
+	return backends, nil
+}
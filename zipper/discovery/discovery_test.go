@@ -0,0 +1,111 @@
+package discovery
+
+import (
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/go-graphite/carbonapi/zipper/types"
+)
+
+type testResolver struct {
+	hosts map[string][]string
+	srv   map[string][]*net.SRV
+}
+
+func (r testResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	addrs, ok := r.hosts[host]
+	if !ok {
+		return nil, errors.New("no such host " + host)
+	}
+	return addrs, nil
+}
+
+func (r testResolver) LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+	records, ok := r.srv[name]
+	if !ok {
+		return "", nil, errors.New("no such host " + name)
+	}
+	return name, records, nil
+}
+
+var dnsResolver = testResolver{
+	hosts: map[string][]string{
+		"graphite.local": {"10.0.0.2", "10.0.0.1", "fd00::1"},
+		"empty.local":    {},
+	},
+	srv: map[string][]*net.SRV{
+		"_graphite._tcp.example.com": {
+			{Target: "store2.example.com.", Port: 8081},
+			{Target: "store1.example.com.", Port: 8080},
+		},
+	},
+}
+
+var resolver = Resolver{DNS: dnsResolver}
+
+func TestResolveServers(t *testing.T) {
+	tests := []struct {
+		servers  []string
+		expected []string
+	}{
+		{[]string{"http://static:8080"}, []string{"http://static:8080"}},
+		{
+			[]string{"http://static:8080", "dns+http://graphite.local:8080/prefix"},
+			[]string{"http://static:8080", "http://10.0.0.1:8080/prefix", "http://10.0.0.2:8080/prefix", "http://[fd00::1]:8080/prefix"},
+		},
+		{
+			[]string{"dns+http://graphite.local"},
+			[]string{"http://10.0.0.1", "http://10.0.0.2", "http://[fd00::1]"},
+		},
+		{
+			[]string{"dnssrv+https://_graphite._tcp.example.com", "http://static:8080"},
+			[]string{"https://store1.example.com:8080", "https://store2.example.com:8081", "http://static:8080"},
+		},
+		// protocol prefix of the legacy backends list is kept
+		{
+			[]string{"carbonapi_v3_pb+dnssrv+http://_graphite._tcp.example.com"},
+			[]string{"carbonapi_v3_pb+http://store1.example.com:8080", "carbonapi_v3_pb+http://store2.example.com:8081"},
+		},
+	}
+	for _, tt := range tests {
+		got, err := resolver.ResolveServers(context.Background(), tt.servers)
+		if err != nil {
+			t.Errorf("%v: unexpected error %v", tt.servers, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf("%v: expected %v, got %v", tt.servers, tt.expected, got)
+		}
+	}
+
+	for _, servers := range [][]string{{"dns+http://unknown.local"}, {"dns+http://empty.local"}, {"dnssrv+http://_unknown._tcp"}} {
+		if _, err := resolver.ResolveServers(context.Background(), servers); err == nil {
+			t.Errorf("%v: expected error", servers)
+		}
+	}
+}
+
+func TestResolveBackends(t *testing.T) {
+	backends := types.BackendsV2{Backends: []types.BackendV2{
+		{GroupName: "static", Servers: []string{"http://static:8080"}},
+		{GroupName: "dns", Servers: []string{"dns+http://graphite.local:8080"}},
+	}}
+	resolved, err := resolver.ResolveBackends(context.Background(), backends)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if !reflect.DeepEqual(resolved.Backends[0].Servers, []string{"http://static:8080"}) || len(resolved.Backends[1].Servers) != 3 {
+		t.Fatalf("unexpected resolved backends %+v", resolved.Backends)
+	}
+	if backends.Backends[1].Servers[0] != "dns+http://graphite.local:8080" {
+		t.Fatalf("original backends were modified")
+	}
+
+	backends.Backends[1].Hashing.Type = "carbon_ch"
+	if _, err := resolver.ResolveBackends(context.Background(), backends); err == nil {
+		t.Fatalf("hashing with DNS names should be an error")
+	}
+}
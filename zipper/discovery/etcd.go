@@ -0,0 +1,83 @@
+// +build !nodiscovery
+
+package discovery
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// EtcdPrefix marks server that is a key prefix in etcd v3, e.x. "etcd+http://127.0.0.1:2379/graphite/backends/".
+// It's resolved to values of every key under the prefix, one server per key, e.x. "http://10.0.0.1:8080". Keys are
+// read in one request, so servers that were changed in one transaction are applied together.
+const EtcdPrefix = "etcd+"
+
+// etcdServers returns values of the keys under the prefix, using JSON gateway of etcd
+func (r Resolver) etcdServers(ctx context.Context, u *url.URL) ([]string, error) {
+	prefix := strings.TrimPrefix(u.Path, "/")
+	if prefix == "" {
+		return nil, errors.New("no prefix")
+	}
+	body, _ := json.Marshal(map[string]string{
+		"key":       base64.StdEncoding.EncodeToString([]byte(prefix)),
+		"range_end": base64.StdEncoding.EncodeToString(prefixEnd([]byte(prefix))),
+	})
+	api := url.URL{Scheme: u.Scheme, Host: u.Host, Path: "/v3/kv/range"}
+	req, err := http.NewRequest("POST", api.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	client := r.HTTP
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+
+	var res struct {
+		Kvs []struct {
+			Key   string `json:"key"`
+			Value string `json:"value"`
+		} `json:"kvs"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return nil, err
+	}
+	servers := make([]string, 0, len(res.Kvs))
+	for _, kv := range res.Kvs {
+		value, err := base64.StdEncoding.DecodeString(kv.Value)
+		if err != nil {
+			return nil, err
+		}
+		if server := strings.TrimSpace(string(value)); server != "" {
+			servers = append(servers, server)
+		}
+	}
+	return servers, nil
+}
+
+// prefixEnd returns the end of the range of keys that start with prefix, as etcd clientv3.GetPrefixRangeEnd does
+func prefixEnd(prefix []byte) []byte {
+	end := append([]byte(nil), prefix...)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	// every byte is 0xff, range is up to the end of keyspace
+	return []byte{0}
+}
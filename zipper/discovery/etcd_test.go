@@ -0,0 +1,79 @@
+// +build !nodiscovery
+
+package discovery
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestEtcdServers(t *testing.T) {
+	kvs := map[string]string{
+		"graphite/backends/a": "http://10.0.0.2:8080",
+		"graphite/backends/b": " http://10.0.0.1:8080\n",
+		"graphite/backends/c": "",
+		"graphite/backendsx":  "http://10.0.0.3:8080",
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var r struct {
+			Key      []byte `json:"key"`
+			RangeEnd []byte `json:"range_end"`
+		}
+		if req.URL.Path != "/v3/kv/range" || json.NewDecoder(req.Body).Decode(&r) != nil {
+			http.NotFound(w, req)
+			return
+		}
+		type kv struct {
+			Value string `json:"value"`
+		}
+		var res struct {
+			Kvs []kv `json:"kvs"`
+		}
+		for k, v := range kvs {
+			if k >= string(r.Key) && k < string(r.RangeEnd) {
+				res.Kvs = append(res.Kvs, kv{base64.StdEncoding.EncodeToString([]byte(v))})
+			}
+		}
+		json.NewEncoder(w).Encode(res)
+	}))
+	defer srv.Close()
+	endpoint := strings.TrimPrefix(srv.URL, "http://")
+	r := Resolver{HTTP: srv.Client()}
+
+	servers, err := r.ResolveServers(context.Background(), []string{"etcd+http://" + endpoint + "/graphite/backends/"})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	expected := []string{"http://10.0.0.1:8080", "http://10.0.0.2:8080"}
+	if !reflect.DeepEqual(servers, expected) {
+		t.Errorf("expected %v, got %v", expected, servers)
+	}
+
+	for _, server := range []string{"etcd+http://" + endpoint + "/unknown/", "etcd+http://" + endpoint} {
+		if _, err := r.ResolveServers(context.Background(), []string{server}); err == nil {
+			t.Errorf("%v: expected error", server)
+		}
+	}
+}
+
+func TestPrefixEnd(t *testing.T) {
+	tests := []struct {
+		prefix   string
+		expected string
+	}{
+		{"a/", "a0"},
+		{"a\xff", "b"},
+		{"\xff\xff", "\x00"},
+	}
+	for _, tt := range tests {
+		if got := string(prefixEnd([]byte(tt.prefix))); got != tt.expected {
+			t.Errorf("%q: expected %q, got %q", tt.prefix, tt.expected, got)
+		}
+	}
+}
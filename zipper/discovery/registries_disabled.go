@@ -0,0 +1,25 @@
+// +build nodiscovery
+
+package discovery
+
+import (
+	"context"
+	"errors"
+	"net/url"
+)
+
+// Services of Consul and etcd are still recognized, so they are reported instead of being used as static servers
+const (
+	ConsulPrefix = "consul+"
+	EtcdPrefix   = "etcd+"
+)
+
+var errNoRegistries = errors.New("carbonzipper was built with 'nodiscovery' tag, Consul and etcd services are not available")
+
+func (r Resolver) consulService(ctx context.Context, u *url.URL) ([]string, *url.URL, error) {
+	return nil, nil, errNoRegistries
+}
+
+func (r Resolver) etcdServers(ctx context.Context, u *url.URL) ([]string, error) {
+	return nil, errNoRegistries
+}
@@ -0,0 +1,201 @@
+package zipper
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-graphite/carbonapi/zipper/errors"
+	"github.com/go-graphite/carbonapi/zipper/helper"
+	"github.com/go-graphite/carbonapi/zipper/types"
+	protov3 "github.com/go-graphite/protocol/carbonapi_v3_pb"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultFallbackAfter    = 3
+	defaultFallbackDuration = 10 * time.Minute
+)
+
+// protocolDowngrades is total amount of times backends were switched to the fallback protocol
+var protocolDowngrades int64
+
+// ProtocolDowngrades returns amount of times backends were switched to the fallback protocol because of decode errors
+func ProtocolDowngrades() int64 {
+	return atomic.LoadInt64(&protocolDowngrades)
+}
+
+// downgradeClient sends requests using primary protocol until its responses keep failing to decode, e.x. after
+// backend was downgraded to the version that doesn't support it. Then fallback client is used for a while, after
+// that primary protocol is tried again. If the server rejects request in primary protocol with 400 or 406, request
+// is retried with fallback one right away and fallback protocol is remembered if it works.
+type downgradeClient struct {
+	primary  types.ServerClient
+	fallback types.ServerClient
+	after    int
+	duration time.Duration
+	// rejectedOnly disables switching because of decode errors, only rejected requests are retried
+	rejectedOnly bool
+
+	sync.Mutex
+	until time.Time
+
+	logger *zap.Logger
+	now    func() time.Time
+}
+
+func newDowngradeClient(logger *zap.Logger, primary, fallback types.ServerClient, after int, duration time.Duration) *downgradeClient {
+	if after <= 0 {
+		after = defaultFallbackAfter
+	}
+	if duration <= 0 {
+		duration = defaultFallbackDuration
+	}
+	return &downgradeClient{
+		primary:  primary,
+		fallback: fallback,
+		after:    after,
+		duration: duration,
+		logger:   logger.With(zap.String("type", "downgrade"), zap.String("name", primary.Name())),
+		now:      time.Now,
+	}
+}
+
+// current returns client that should be used for the next request
+func (c *downgradeClient) current() types.ServerClient {
+	c.Lock()
+	defer c.Unlock()
+	if c.now().Before(c.until) {
+		return c.fallback
+	}
+	return c.primary
+}
+
+// switchToFallback makes fallback client the current one for duration
+func (c *downgradeClient) switchToFallback() {
+	c.Lock()
+	c.until = c.now().Add(c.duration)
+	c.Unlock()
+	atomic.AddInt64(&protocolDowngrades, 1)
+}
+
+// rejected returns true if request sent by client should be retried with fallback protocol
+func (c *downgradeClient) rejected(client types.ServerClient, e *errors.Errors) bool {
+	return client == c.primary && types.IsFormatRejected(e)
+}
+
+// retried remembers fallback protocol if request rejected in primary one succeeded with it
+func (c *downgradeClient) retried(e *errors.Errors) {
+	if types.HaveFailures(e) {
+		return
+	}
+	c.switchToFallback()
+	c.logger.Warn("request was rejected, switching to fallback protocol",
+		zap.String("fallback", c.fallback.Name()),
+		zap.Duration("duration", c.duration),
+	)
+}
+
+// check switches to the fallback client if any of the servers failed to send decodable response too many times
+func (c *downgradeClient) check(client types.ServerClient) {
+	if client != c.primary || c.rejectedOnly {
+		return
+	}
+	for _, server := range c.primary.Backends() {
+		errs := helper.ConsecutiveDecodeErrors(server)
+		if errs < c.after {
+			continue
+		}
+		c.switchToFallback()
+		c.logger.Error("switching to fallback protocol because of decode errors",
+			zap.String("server", server),
+			zap.Int("decode_errors", errs),
+			zap.String("fallback", c.fallback.Name()),
+			zap.Duration("duration", c.duration),
+		)
+		return
+	}
+}
+
+func (c *downgradeClient) Name() string {
+	return c.primary.Name()
+}
+
+func (c *downgradeClient) Backends() []string {
+	return c.primary.Backends()
+}
+
+func (c *downgradeClient) MaxMetricsPerRequest() int {
+	return c.current().MaxMetricsPerRequest()
+}
+
+func (c *downgradeClient) Children() []types.ServerClient {
+	return []types.ServerClient{c}
+}
+
+func (c *downgradeClient) Fetch(ctx context.Context, request *protov3.MultiFetchRequest) (*protov3.MultiFetchResponse, *types.Stats, *errors.Errors) {
+	client := c.current()
+	res, stats, e := client.Fetch(ctx, request)
+	if c.rejected(client, e) {
+		res, stats, e = c.fallback.Fetch(ctx, request)
+		c.retried(e)
+	}
+	c.check(client)
+	return res, stats, e
+}
+
+func (c *downgradeClient) Find(ctx context.Context, request *protov3.MultiGlobRequest) (*protov3.MultiGlobResponse, *types.Stats, *errors.Errors) {
+	client := c.current()
+	res, stats, e := client.Find(ctx, request)
+	if c.rejected(client, e) {
+		res, stats, e = c.fallback.Find(ctx, request)
+		c.retried(e)
+	}
+	c.check(client)
+	return res, stats, e
+}
+
+func (c *downgradeClient) Info(ctx context.Context, request *protov3.MultiMetricsInfoRequest) (*protov3.ZipperInfoResponse, *types.Stats, *errors.Errors) {
+	client := c.current()
+	res, stats, e := client.Info(ctx, request)
+	if c.rejected(client, e) {
+		res, stats, e = c.fallback.Info(ctx, request)
+		c.retried(e)
+	}
+	c.check(client)
+	return res, stats, e
+}
+
+func (c *downgradeClient) List(ctx context.Context) (*protov3.ListMetricsResponse, *types.Stats, *errors.Errors) {
+	client := c.current()
+	res, stats, e := client.List(ctx)
+	if c.rejected(client, e) {
+		res, stats, e = c.fallback.List(ctx)
+		c.retried(e)
+	}
+	c.check(client)
+	return res, stats, e
+}
+
+func (c *downgradeClient) Stats(ctx context.Context) (*protov3.MetricDetailsResponse, *types.Stats, *errors.Errors) {
+	client := c.current()
+	res, stats, e := client.Stats(ctx)
+	if c.rejected(client, e) {
+		res, stats, e = c.fallback.Stats(ctx)
+		c.retried(e)
+	}
+	c.check(client)
+	return res, stats, e
+}
+
+func (c *downgradeClient) ProbeTLDs(ctx context.Context) ([]string, *errors.Errors) {
+	client := c.current()
+	res, e := client.ProbeTLDs(ctx)
+	if c.rejected(client, e) {
+		res, e = c.fallback.ProbeTLDs(ctx)
+		c.retried(e)
+	}
+	c.check(client)
+	return res, e
+}
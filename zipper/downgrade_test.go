@@ -0,0 +1,90 @@
+package zipper
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-graphite/carbonapi/limiter"
+	"github.com/go-graphite/carbonapi/zipper/dummy"
+	"github.com/go-graphite/carbonapi/zipper/helper"
+	"github.com/go-graphite/carbonapi/zipper/types"
+	protov3 "github.com/go-graphite/protocol/carbonapi_v3_pb"
+	"go.uber.org/zap"
+)
+
+func TestDowngradeClient(t *testing.T) {
+	server := "downgrade-test:8080"
+	primary := dummy.NewDummyClient("primary", []string{server}, 1)
+	fallback := dummy.NewDummyClient("fallback", []string{server}, 1)
+	c := newDowngradeClient(zap.NewNop(), primary, fallback, 2, time.Minute)
+	now := time.Unix(1000, 0)
+	c.now = func() time.Time { return now }
+
+	query := helper.NewHttpQuery(zap.NewNop(), "test", []string{server}, 1, nil, nil, "")
+	request := &protov3.MultiGlobRequest{Metrics: []string{"foo"}}
+	before := ProtocolDowngrades()
+
+	query.Decoded(server, 10, fmt.Errorf("broken"))
+	c.Find(context.Background(), request)
+	if c.current() != primary {
+		t.Fatal("single decode error shouldn't switch protocol")
+	}
+
+	query.Decoded(server, 10, fmt.Errorf("broken"))
+	c.Find(context.Background(), request)
+	if c.current() != fallback || ProtocolDowngrades()-before != 1 {
+		t.Fatal("client should switch to fallback protocol")
+	}
+
+	now = now.Add(time.Minute)
+	if c.current() != primary {
+		t.Fatal("primary protocol should be tried again after fallback duration")
+	}
+	query.Decoded(server, 10, nil)
+	c.Find(context.Background(), request)
+	if c.current() != primary {
+		t.Fatal("client should keep primary protocol after successful decode")
+	}
+}
+
+func TestDowngradeClientRejected(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unsupported format", http.StatusNotAcceptable)
+	}))
+	defer srv.Close()
+
+	query := helper.NewHttpQuery(zap.NewNop(), "test", []string{srv.URL}, 1, limiter.NewServerLimiter([]string{srv.URL}, 0), http.DefaultClient, "")
+	_, rejection := query.DoQuery(context.Background(), "/metrics/find/", nil)
+	if !types.IsFormatRejected(rejection) {
+		t.Fatalf("406 response should be reported as rejected format, got %v", rejection)
+	}
+
+	primary := dummy.NewDummyClient("primary", []string{srv.URL}, 1)
+	fallback := dummy.NewDummyClient("fallback", []string{srv.URL}, 1)
+	request := &protov3.MultiGlobRequest{Metrics: []string{"foo"}}
+	other := &protov3.MultiGlobRequest{Metrics: []string{"bar"}}
+	primary.AddFindResponse(request, nil, &types.Stats{}, rejection)
+	primary.AddFindResponse(other, &protov3.MultiGlobResponse{}, &types.Stats{}, nil)
+	fallback.AddFindResponse(request, &protov3.MultiGlobResponse{}, &types.Stats{}, nil)
+	c := newDowngradeClient(zap.NewNop(), primary, fallback, 2, time.Minute)
+	c.rejectedOnly = true
+	now := time.Unix(1000, 0)
+	c.now = func() time.Time { return now }
+
+	// rejection of another request doesn't affect this one
+	before := ProtocolDowngrades()
+	if _, _, e := c.Find(context.Background(), other); e != nil || c.current() != primary {
+		t.Fatalf("request that wasn't rejected should be served by primary protocol, got %v", e)
+	}
+
+	if _, _, e := c.Find(context.Background(), request); e != nil {
+		t.Fatalf("rejected request should be retried with fallback protocol, got %v", e)
+	}
+	if c.current() != fallback || ProtocolDowngrades()-before != 1 {
+		t.Fatal("client should remember fallback protocol")
+	}
+}
@@ -0,0 +1,143 @@
+package helper
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/go-graphite/carbonapi/zipper/types"
+)
+
+// NewHTTPClient returns client for the servers of the backend group, credentials of the group are attached to every
+// request. Compressed responses are requested and decoded unless compression is disabled.
+func NewHTTPClient(config types.BackendV2) (*http.Client, error) {
+	tlsConfig, err := TLSConfig(config.TLS)
+	if err != nil {
+		return nil, err
+	}
+	var tuning types.Transport
+	if config.Transport != nil {
+		tuning = *config.Transport
+	}
+	var transport http.RoundTripper = &http.Transport{
+		TLSClientConfig:       tlsConfig,
+		MaxIdleConnsPerHost:   *config.MaxIdleConnsPerHost,
+		MaxConnsPerHost:       tuning.MaxConnsPerHost,
+		IdleConnTimeout:       tuning.IdleConnTimeout,
+		ResponseHeaderTimeout: tuning.ResponseHeaderTimeout,
+		// compression is handled by WithCompression, it supports deflate as well
+		DisableCompression: true,
+		ForceAttemptHTTP2:  tuning.ForceAttemptHTTP2,
+		DialContext: (&net.Dialer{
+			Timeout:   config.Timeouts.Connect,
+			KeepAlive: *config.KeepAliveInterval,
+			DualStack: true,
+		}).DialContext,
+	}
+	if !tuning.DisableCompression {
+		transport = WithCompression(transport)
+	}
+	transport, err = WithAuth(config.Auth, transport)
+	if err != nil {
+		return nil, err
+	}
+	return &http.Client{Transport: transport}, nil
+}
+
+// TLSConfig returns client TLS config of the group, it's nil if the defaults should be used
+func TLSConfig(config types.BackendTLS) (*tls.Config, error) {
+	if config == (types.BackendTLS{}) {
+		return nil, nil
+	}
+	if (config.CertFile == "") != (config.KeyFile == "") {
+		return nil, fmt.Errorf("both certFile and keyFile must be set for client certificate")
+	}
+
+	tlsConfig := &tls.Config{
+		ServerName:         config.ServerName,
+		InsecureSkipVerify: config.InsecureSkipVerify,
+	}
+	if config.CAFile != "" {
+		pem, err := ioutil.ReadFile(config.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA bundle: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %v", config.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if config.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(config.CertFile, config.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
+func readSecret(value, file string) (string, error) {
+	if file == "" {
+		return value, nil
+	}
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+// AuthorizationHeader returns value of Authorization header for the credentials, it's empty if there are none
+func AuthorizationHeader(auth types.BackendAuth) (string, error) {
+	if auth.User != "" {
+		password, err := readSecret(auth.Password, auth.PasswordFile)
+		if err != nil {
+			return "", fmt.Errorf("failed to read password: %v", err)
+		}
+		return "Basic " + base64.StdEncoding.EncodeToString([]byte(auth.User+":"+password)), nil
+	}
+
+	token, err := readSecret(auth.BearerToken, auth.BearerTokenFile)
+	if err != nil {
+		return "", fmt.Errorf("failed to read bearer token: %v", err)
+	}
+	if token == "" {
+		return "", nil
+	}
+	return "Bearer " + token, nil
+}
+
+// authTransport sets Authorization header of the requests
+type authTransport struct {
+	header    string
+	transport http.RoundTripper
+}
+
+func (t *authTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// request must not be modified by RoundTripper
+	r := new(http.Request)
+	*r = *req
+	r.Header = make(http.Header, len(req.Header)+1)
+	for k, v := range req.Header {
+		r.Header[k] = v
+	}
+	r.Header.Set("Authorization", t.header)
+	return t.transport.RoundTrip(r)
+}
+
+// WithAuth wraps transport, so credentials are attached to every request. Transport is returned as is if there are
+// no credentials.
+func WithAuth(auth types.BackendAuth, transport http.RoundTripper) (http.RoundTripper, error) {
+	header, err := AuthorizationHeader(auth)
+	if err != nil || header == "" {
+		return transport, err
+	}
+	return &authTransport{header: header, transport: transport}, nil
+}
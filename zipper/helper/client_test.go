@@ -0,0 +1,283 @@
+package helper
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-graphite/carbonapi/limiter"
+	"github.com/go-graphite/carbonapi/zipper/types"
+	"go.uber.org/zap"
+)
+
+func TestHTTPClientAuth(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, password, ok := r.BasicAuth()
+		if r.Header.Get("Authorization") == "Bearer secret" || (ok && user == "zipper" && password == "secret") {
+			w.Write([]byte("ok"))
+			return
+		}
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+	}))
+	defer srv.Close()
+
+	f, err := ioutil.TempFile("", "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("secret\n")
+	f.Close()
+
+	idleConns := 1
+	keepAlive := time.Second
+	tests := []struct {
+		auth types.BackendAuth
+		ok   bool
+	}{
+		{types.BackendAuth{User: "zipper", Password: "secret"}, true},
+		{types.BackendAuth{User: "zipper", PasswordFile: f.Name()}, true},
+		{types.BackendAuth{BearerTokenFile: f.Name()}, true},
+		{types.BackendAuth{BearerToken: "wrong"}, false},
+		{types.BackendAuth{}, false},
+	}
+	for _, tt := range tests {
+		config := types.BackendV2{
+			MaxIdleConnsPerHost: &idleConns,
+			KeepAliveInterval:   &keepAlive,
+			Auth:                tt.auth,
+		}
+		config.FillDefaults()
+		client, err := NewHTTPClient(config)
+		if err != nil {
+			t.Fatalf("failed to create client: %v", err)
+		}
+
+		q := NewHttpQuery(zap.NewNop(), "test", []string{srv.URL}, 1, limiter.NewServerLimiter([]string{srv.URL}, 0), client, "")
+		res, e := q.DoQuery(context.Background(), "/render/?target=foo", nil)
+		if ok := e == nil && res != nil; ok != tt.ok {
+			t.Errorf("%+v: got %+v, %v, expected success: %v", tt.auth, res, e, tt.ok)
+		}
+	}
+
+	config := types.BackendV2{
+		MaxIdleConnsPerHost: &idleConns,
+		KeepAliveInterval:   &keepAlive,
+		Auth:                types.BackendAuth{BearerTokenFile: f.Name() + ".missing"},
+	}
+	config.FillDefaults()
+	if _, err := NewHTTPClient(config); err == nil {
+		t.Errorf("missing token file should be an error")
+	}
+}
+
+func TestBackendAuthHidden(t *testing.T) {
+	b, err := json.Marshal(types.BackendV2{Auth: types.BackendAuth{User: "zipper", Password: "secret", BearerToken: "token"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(b), "secret") || strings.Contains(string(b), `"token"`) || !strings.Contains(string(b), "zipper") {
+		t.Fatalf("secrets should be hidden, got %s", b)
+	}
+}
+
+func writePEM(t *testing.T, blockType string, b []byte) string {
+	f, err := ioutil.TempFile("", "pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := pem.Encode(f, &pem.Block{Type: blockType, Bytes: b}); err != nil {
+		t.Fatal(err)
+	}
+	return f.Name()
+}
+
+func TestHTTPClientTLS(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "zipper"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientCert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile := writePEM(t, "CERTIFICATE", der)
+	defer os.Remove(certFile)
+	keyFile := writePEM(t, "EC PRIVATE KEY", keyDer)
+	defer os.Remove(keyFile)
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(clientCert)
+	srv.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+	srv.StartTLS()
+	defer srv.Close()
+	caFile := writePEM(t, "CERTIFICATE", srv.Certificate().Raw)
+	defer os.Remove(caFile)
+
+	idleConns := 1
+	keepAlive := time.Second
+	tests := []struct {
+		tls types.BackendTLS
+		ok  bool
+	}{
+		{types.BackendTLS{CAFile: caFile, CertFile: certFile, KeyFile: keyFile}, true},
+		{types.BackendTLS{InsecureSkipVerify: true, CertFile: certFile, KeyFile: keyFile}, true},
+		// server certificate is not trusted
+		{types.BackendTLS{CertFile: certFile, KeyFile: keyFile}, false},
+		// no client certificate
+		{types.BackendTLS{CAFile: caFile}, false},
+	}
+	for _, tt := range tests {
+		config := types.BackendV2{
+			MaxIdleConnsPerHost: &idleConns,
+			KeepAliveInterval:   &keepAlive,
+			TLS:                 tt.tls,
+		}
+		config.FillDefaults()
+		client, err := NewHTTPClient(config)
+		if err != nil {
+			t.Fatalf("failed to create client: %v", err)
+		}
+
+		q := NewHttpQuery(zap.NewNop(), "test", []string{srv.URL}, 1, limiter.NewServerLimiter([]string{srv.URL}, 0), client, "")
+		res, e := q.DoQuery(context.Background(), "/render/?target=foo", nil)
+		if ok := e == nil && res != nil; ok != tt.ok {
+			t.Errorf("%+v: got %+v, %v, expected success: %v", tt.tls, res, e, tt.ok)
+		}
+	}
+
+	for _, c := range []types.BackendTLS{{CertFile: certFile}, {CAFile: keyFile}} {
+		if _, err := TLSConfig(c); err == nil {
+			t.Errorf("%+v: invalid config should be an error", c)
+		}
+	}
+}
+
+func TestHTTPClientTransport(t *testing.T) {
+	idleConns := 10
+	keepAlive := time.Second
+	config := types.BackendV2{
+		MaxIdleConnsPerHost: &idleConns,
+		KeepAliveInterval:   &keepAlive,
+		Transport: &types.Transport{
+			IdleConnTimeout:    time.Minute,
+			MaxConnsPerHost:    20,
+			DisableCompression: true,
+			ForceAttemptHTTP2:  true,
+		},
+	}
+	config.FillDefaults()
+	client, err := NewHTTPClient(config)
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+
+	transport, ok := client.Transport.(*http.Transport)
+	if !ok {
+		t.Fatalf("transport without credentials should not be wrapped, got %T", client.Transport)
+	}
+	if transport.MaxIdleConnsPerHost != 10 || transport.MaxConnsPerHost != 20 || transport.IdleConnTimeout != time.Minute ||
+		!transport.DisableCompression || !transport.ForceAttemptHTTP2 || transport.ResponseHeaderTimeout != 0 {
+		t.Fatalf("transport settings are not applied: %+v", transport)
+	}
+}
+
+func TestHTTPClientCompression(t *testing.T) {
+	payload := strings.Repeat("compressed response ", 100)
+	var encoding string
+	var disabled bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if accepted := r.Header.Get("Accept-Encoding"); (accepted == "") != disabled {
+			t.Errorf("unexpected Accept-Encoding %q, compression disabled: %v", accepted, disabled)
+		}
+		if encoding == "" || disabled {
+			w.Write([]byte(payload))
+			return
+		}
+		var buf bytes.Buffer
+		var wr io.WriteCloser
+		switch encoding {
+		case "gzip":
+			wr = gzip.NewWriter(&buf)
+		case "deflate":
+			wr = zlib.NewWriter(&buf)
+		case "raw deflate":
+			wr, _ = flate.NewWriter(&buf, flate.DefaultCompression)
+		}
+		wr.Write([]byte(payload))
+		wr.Close()
+		w.Header().Set("Content-Encoding", strings.Fields(encoding)[len(strings.Fields(encoding))-1])
+		w.Write(buf.Bytes())
+	}))
+	defer srv.Close()
+
+	idleConns := 1
+	keepAlive := time.Second
+	tests := []struct {
+		encoding string
+		disable  bool
+	}{
+		{"gzip", false},
+		{"deflate", false},
+		{"raw deflate", false},
+		{"", false},
+		{"", true},
+	}
+	for _, tt := range tests {
+		encoding, disabled = tt.encoding, tt.disable
+		config := types.BackendV2{
+			MaxIdleConnsPerHost: &idleConns,
+			KeepAliveInterval:   &keepAlive,
+			Transport:           &types.Transport{DisableCompression: tt.disable},
+		}
+		config.FillDefaults()
+		client, err := NewHTTPClient(config)
+		if err != nil {
+			t.Fatalf("failed to create client: %v", err)
+		}
+
+		q := NewHttpQuery(zap.NewNop(), "test", []string{srv.URL}, 1, limiter.NewServerLimiter([]string{srv.URL}, 0), client, "")
+		res, e := q.DoQuery(context.Background(), "/render/?target=foo", nil)
+		if e != nil || res == nil || string(res.Response) != payload {
+			t.Errorf("%q: response should be decoded, got %+v, %v", tt.encoding, res, e)
+		}
+	}
+}
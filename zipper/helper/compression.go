@@ -0,0 +1,87 @@
+package helper
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// acceptEncoding are encodings that backends may compress responses with
+const acceptEncoding = "gzip, deflate"
+
+// compressionTransport asks backends for compressed responses and decodes them, so callers always get plain body.
+// Body is decoded while it's read, so max response size limits decoded size.
+type compressionTransport struct {
+	transport http.RoundTripper
+}
+
+// WithCompression wraps transport, so it requests and transparently decodes gzip and deflate responses. Transport
+// mustn't decode gzip itself (http.Transport.DisableCompression), as Accept-Encoding is set explicitly.
+func WithCompression(transport http.RoundTripper) http.RoundTripper {
+	return &compressionTransport{transport: transport}
+}
+
+func (t *compressionTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// request must not be modified by RoundTripper
+	r := new(http.Request)
+	*r = *req
+	r.Header = make(http.Header, len(req.Header)+1)
+	for k, v := range req.Header {
+		r.Header[k] = v
+	}
+	r.Header.Set("Accept-Encoding", acceptEncoding)
+
+	resp, err := t.transport.RoundTrip(r)
+	if err != nil || resp.ContentLength == 0 || req.Method == "HEAD" {
+		return resp, err
+	}
+
+	var decoded io.Reader
+	switch strings.ToLower(strings.TrimSpace(resp.Header.Get("Content-Encoding"))) {
+	case "gzip":
+		decoded, err = gzip.NewReader(resp.Body)
+	case "deflate":
+		decoded, err = newDeflateReader(resp.Body)
+	default:
+		return resp, nil
+	}
+	if err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+
+	resp.Body = &decodedBody{Reader: decoded, body: resp.Body}
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = -1
+	resp.Uncompressed = true
+	return resp, nil
+}
+
+// newDeflateReader decodes "deflate" encoding. It's zlib stream according to RFC, but some servers send raw deflate,
+// so zlib header is checked first.
+func newDeflateReader(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	header, err := br.Peek(2)
+	if err == nil && header[0]&0x0f == 8 && (uint16(header[0])<<8|uint16(header[1]))%31 == 0 {
+		return zlib.NewReader(br)
+	}
+	return flate.NewReader(br), nil
+}
+
+// decodedBody reads decoded response and closes the original body
+type decodedBody struct {
+	io.Reader
+	body io.ReadCloser
+}
+
+func (b *decodedBody) Close() error {
+	if c, ok := b.Reader.(io.Closer); ok {
+		c.Close()
+	}
+	return b.body.Close()
+}
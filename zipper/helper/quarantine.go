@@ -0,0 +1,172 @@
+package helper
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// decodeErrors is total amount of responses that protocol implementations failed to decode
+var decodeErrors int64
+
+// consecutiveDecodeErrors keeps amount of decode errors in a row per server, regardless of quarantine settings
+var consecutiveDecodeErrors = struct {
+	sync.Mutex
+	servers map[string]int
+}{servers: make(map[string]int)}
+
+// quarantine is shared between all the backends. It's configured once by zipper during startup. nil means that
+// servers are never quarantined.
+var quarantine *decodeQuarantine
+
+type decodeState struct {
+	failures int
+	until    time.Time
+}
+
+// decodeQuarantine tracks servers that keep sending responses that can't be decoded (schema mismatch, corruption).
+// After threshold consecutive decode errors server is not queried for duration. When it expires, next request
+// serves as a probe: successful decode puts server back, another error extends the quarantine.
+type decodeQuarantine struct {
+	sync.Mutex
+	threshold int
+	duration  time.Duration
+	servers   map[string]*decodeState
+
+	now func() time.Time
+}
+
+// SetDecodeErrorQuarantine enables quarantine of the servers that failed to send decodable response threshold times in a row
+func SetDecodeErrorQuarantine(threshold int, duration time.Duration) {
+	if threshold <= 0 || duration <= 0 {
+		quarantine = nil
+		return
+	}
+	quarantine = &decodeQuarantine{
+		threshold: threshold,
+		duration:  duration,
+		servers:   make(map[string]*decodeState),
+		now:       time.Now,
+	}
+}
+
+// DecodeErrors returns amount of responses that failed to decode
+func DecodeErrors() int64 {
+	return atomic.LoadInt64(&decodeErrors)
+}
+
+// ConsecutiveDecodeErrors returns amount of responses of the server in a row that failed to decode
+func ConsecutiveDecodeErrors(server string) int {
+	consecutiveDecodeErrors.Lock()
+	defer consecutiveDecodeErrors.Unlock()
+	return consecutiveDecodeErrors.servers[server]
+}
+
+func decodeResult(server string, ok bool) {
+	consecutiveDecodeErrors.Lock()
+	if ok {
+		delete(consecutiveDecodeErrors.servers, server)
+	} else {
+		consecutiveDecodeErrors.servers[server]++
+	}
+	consecutiveDecodeErrors.Unlock()
+}
+
+// QuarantinedServers returns amount of servers that are currently quarantined because of decode errors
+func QuarantinedServers() int64 {
+	q := quarantine
+	if q == nil {
+		return 0
+	}
+	q.Lock()
+	defer q.Unlock()
+	now := q.now()
+	var cnt int64
+	for _, s := range q.servers {
+		if now.Before(s.until) {
+			cnt++
+		}
+	}
+	return cnt
+}
+
+func (q *decodeQuarantine) isQuarantined(server string) bool {
+	if q == nil {
+		return false
+	}
+	q.Lock()
+	defer q.Unlock()
+	s, ok := q.servers[server]
+	return ok && q.now().Before(s.until)
+}
+
+// failed records decode error and returns true if server was put into quarantine because of it
+func (q *decodeQuarantine) failed(server string) bool {
+	if q == nil {
+		return false
+	}
+	q.Lock()
+	defer q.Unlock()
+	s, ok := q.servers[server]
+	if !ok {
+		s = &decodeState{}
+		q.servers[server] = s
+	}
+	s.failures++
+	if s.failures < q.threshold {
+		return false
+	}
+	s.until = q.now().Add(q.duration)
+	return true
+}
+
+func (q *decodeQuarantine) succeeded(server string) {
+	if q == nil {
+		return
+	}
+	q.Lock()
+	delete(q.servers, server)
+	q.Unlock()
+}
+
+// Decoded must be called by protocol implementations after they've tried to decode the response of the server.
+// Separate decode errors are logged at debug level only, server being quarantined is reported as an error.
+func (c *HttpQuery) Decoded(server string, size int, err error) {
+	decodeResult(server, err == nil)
+	if err == nil {
+		quarantine.succeeded(server)
+		return
+	}
+
+	atomic.AddInt64(&decodeErrors, 1)
+	q := quarantine
+	logger := c.logger.With(
+		zap.String("server", server),
+		zap.String("name", c.groupName),
+		zap.Int("response_size", size),
+		zap.Error(err),
+	)
+	if q.failed(server) {
+		logger.Error("server is quarantined because of repeated decode errors",
+			zap.Duration("duration", q.duration),
+		)
+		return
+	}
+	logger.Debug("failed to decode response")
+}
+
+// pickHealthyServer returns next server that is neither quarantined nor excluded, or empty string if there is none
+func (c *HttpQuery) pickHealthyServer(exclude map[string]struct{}) string {
+	for i := 0; i < len(c.servers); i++ {
+		srv := c.pickServer()
+		if _, ok := exclude[srv]; ok {
+			continue
+		}
+		if !quarantine.isQuarantined(srv) {
+			return srv
+		}
+	}
+	return ""
+}
@@ -6,20 +6,77 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"mime"
 	"net/http"
 	"net/url"
+	"sort"
+	"strings"
+	"sync"
 	"sync/atomic"
+	"time"
 
+	"github.com/dgryski/go-expirecache"
 	"github.com/go-graphite/carbonapi/limiter"
 	util "github.com/go-graphite/carbonapi/util/ctx"
 	"github.com/go-graphite/carbonapi/zipper/errors"
+	"github.com/go-graphite/carbonapi/zipper/httpHeaders"
 	"github.com/go-graphite/carbonapi/zipper/types"
 	"go.uber.org/zap"
 )
 
+// retryBudget is shared between all the backends. It's set every time zipper is created, nil means no budget
+var retryBudget *limiter.RetryBudget
+
+// SetRetryBudget sets global retry budget that limits amount of retries for all HttpQuery instances
+func SetRetryBudget(b *limiter.RetryBudget) {
+	retryBudget = b
+}
+
+// GetRetryBudget returns global retry budget
+func GetRetryBudget() *limiter.RetryBudget {
+	return retryBudget
+}
+
+// notFoundCache contains requests that backends answered with 404 recently, so we won't ask them again until it expires.
+// Cache and its cleaner are created once, zipper only changes notFoundCacheTTL on reloads, 0 means that negative
+// caching is disabled.
+var notFoundCache = expirecache.New(0)
+var notFoundCacheCleaner sync.Once
+var notFoundCacheTTL int32
+
+// SetNotFoundCacheTTL enables negative caching of 404 responses for ttl
+func SetNotFoundCacheTTL(ttl time.Duration) {
+	if ttl < time.Second {
+		atomic.StoreInt32(&notFoundCacheTTL, 0)
+		return
+	}
+	notFoundCacheCleaner.Do(func() {
+		go notFoundCache.ApproximateCleaner(10 * time.Second)
+	})
+	atomic.StoreInt32(&notFoundCacheTTL, int32(ttl.Seconds()))
+}
+
+// maxResponseSize limits size of the response body, larger responses are dropped. It's configured once by zipper
+// during startup, 0 means no limit.
+var maxResponseSize int64
+
+// truncatedResponses is total amount of responses that exceeded maxResponseSize
+var truncatedResponses int64
+
+// SetMaxResponseSize limits size of the response body of all HttpQuery instances, 0 disables the limit
+func SetMaxResponseSize(size int64) {
+	atomic.StoreInt64(&maxResponseSize, size)
+}
+
+// TruncatedResponses returns amount of responses that were dropped because they exceeded max response size
+func TruncatedResponses() int64 {
+	return atomic.LoadInt64(&truncatedResponses)
+}
+
 type ServerResponse struct {
-	Server   string
-	Response []byte
+	Server      string
+	ContentType string
+	Response    []byte
 }
 
 type HttpQuery struct {
@@ -64,8 +121,11 @@ func (c *HttpQuery) pickServer() string {
 	return srv
 }
 
-func (c *HttpQuery) doRequest(ctx context.Context, uri string, r types.Request) (*ServerResponse, error) {
-	server := c.pickServer()
+func (c *HttpQuery) doRequest(ctx context.Context, uri string, r types.Request, exclude map[string]struct{}) (*ServerResponse, error) {
+	server := c.pickHealthyServer(exclude)
+	if server == "" {
+		return nil, types.ErrServerQuarantined
+	}
 	c.logger.Debug("picked server",
 		zap.String("server", server),
 	)
@@ -92,11 +152,21 @@ func (c *HttpQuery) doRequest(ctx context.Context, uri string, r types.Request)
 		zap.String("uri", u.String()),
 	)
 
-	req, err := http.NewRequest("GET", u.String(), reader)
-	req.Header.Set("Accept", c.encoding)
+	method := "GET"
+	httpRequest, hasHeaders := r.(types.HTTPRequest)
+	if hasHeaders {
+		method = httpRequest.Method()
+	}
+	req, err := http.NewRequest(method, u.String(), reader)
 	if err != nil {
 		return nil, err
 	}
+	req.Header.Set("Accept", c.encoding)
+	if hasHeaders {
+		for k, v := range httpRequest.Header() {
+			req.Header[k] = v
+		}
+	}
 	req = util.MarshalCtx(ctx, util.MarshalCtx(ctx, req, util.HeaderUUIDZipper), util.HeaderUUIDAPI)
 
 	logger.Debug("trying to get slot")
@@ -120,13 +190,39 @@ func (c *HttpQuery) doRequest(ctx context.Context, uri string, r types.Request)
 	}
 	defer resp.Body.Close()
 
-	body, err = ioutil.ReadAll(resp.Body)
+	var bodyReader io.Reader = resp.Body
+	limit := atomic.LoadInt64(&maxResponseSize)
+	if limit > 0 {
+		// one more byte is read to tell response that fits exactly from the larger one
+		bodyReader = io.LimitReader(resp.Body, limit+1)
+	}
+	body, err = ioutil.ReadAll(bodyReader)
 	if err != nil {
 		logger.Error("error reading body",
 			zap.Error(err),
 		)
 		return nil, err
 	}
+	if limit > 0 && int64(len(body)) > limit {
+		atomic.AddInt64(&truncatedResponses, 1)
+		logger.Error("response is too large, dropped",
+			zap.Int64("max_response_size", limit),
+			zap.Int("status_code", resp.StatusCode),
+		)
+		return nil, types.ErrResponseTooLarge
+	}
+
+	if resp.StatusCode == http.StatusNotFound {
+		logger.Debug("metric not found")
+		return nil, types.ErrNotFound
+	}
+
+	if resp.StatusCode == http.StatusBadRequest || resp.StatusCode == http.StatusNotAcceptable {
+		logger.Error("request was rejected",
+			zap.Int("status_code", resp.StatusCode),
+		)
+		return nil, &types.ErrFormatRejected{Group: c.groupName, Server: server, Code: resp.StatusCode, Body: string(body)}
+	}
 
 	if resp.StatusCode != http.StatusOK {
 		logger.Error("status not ok",
@@ -135,18 +231,69 @@ func (c *HttpQuery) doRequest(ctx context.Context, uri string, r types.Request)
 		return nil, fmt.Errorf(types.ErrFailedToFetchFmt, c.groupName, resp.StatusCode, string(body))
 	}
 
-	return &ServerResponse{Server: server, Response: body}, nil
+	return &ServerResponse{Server: server, ContentType: resp.Header.Get("Content-Type"), Response: body}, nil
 }
 
 func (c *HttpQuery) DoQuery(ctx context.Context, uri string, r types.Request) (*ServerResponse, *errors.Errors) {
+	return c.DoQueryDecoded(ctx, uri, r, nil)
+}
+
+// DoQueryDecoded is DoQuery that also decodes the response. If decode fails, request is sent to another server of
+// the group, as all of them should have the same data, and it fails only when none of them sent a decodable
+// response. Decoded is called for every attempt, so decode has to be the only thing that parses the response.
+// Only roundrobin groups have several servers in HttpQuery, servers of broadcast groups have different data and
+// each of them gets its own HttpQuery, so their responses that can't be decoded are not retried.
+func (c *HttpQuery) DoQueryDecoded(ctx context.Context, uri string, r types.Request, decode func(response []byte) error) (*ServerResponse, *errors.Errors) {
+	if decode == nil {
+		return c.DoQueryDecodedByType(ctx, uri, r, "", nil)
+	}
+	return c.DoQueryDecodedByType(ctx, uri, r, c.encoding, map[string]func(response []byte) error{
+		c.encoding: decode,
+	})
+}
+
+// DoQueryDecodedByType is DoQueryDecoded that picks decoder by Content-Type of the response. Responses without
+// Content-Type or with unknown one are decoded by decoder of defaultType, responses in known format that can't be
+// decoded are treated as decode errors.
+func (c *HttpQuery) DoQueryDecodedByType(ctx context.Context, uri string, r types.Request, defaultType string, decoders map[string]func(response []byte) error) (*ServerResponse, *errors.Errors) {
 	maxTries := c.maxTries
 	if len(c.servers) > maxTries {
 		maxTries = len(c.servers)
 	}
 
+	var notFoundKey string
+	notFoundTTL := atomic.LoadInt32(&notFoundCacheTTL)
+	if notFoundTTL > 0 {
+		notFoundKey = c.notFoundKey(uri, r)
+		if _, ok := notFoundCache.Get(notFoundKey); ok {
+			return nil, errors.FromErrNonFatal(types.ErrNotFound)
+		}
+	}
+
 	var e errors.Errors
+	// servers that sent responses that can't be decoded
+	var undecodable map[string]struct{}
+	retryBudget.Request()
 	for try := 0; try < maxTries; try++ {
-		res, err := c.doRequest(ctx, uri, r)
+		if try > 0 && !retryBudget.TryRetry() {
+			c.logger.Warn("retry budget exhausted, won't retry",
+				zap.Int("try", try),
+			)
+			e.Add(types.ErrRetryBudgetExhausted)
+			return nil, &e
+		}
+		res, err := c.doRequest(ctx, uri, r, undecodable)
+		if err == types.ErrNotFound {
+			// That's a valid answer, there is no point to retry it
+			if notFoundTTL > 0 {
+				notFoundCache.Set(notFoundKey, struct{}{}, uint64(len(notFoundKey)), notFoundTTL)
+			}
+			return nil, errors.FromErrNonFatal(err)
+		}
+		if err == types.ErrServerQuarantined || err == types.ErrResponseTooLarge {
+			// Retries will hit the same quarantined servers or get the same data from another replica
+			return nil, e.Add(err)
+		}
 		if err != nil {
 			c.logger.Error("have errors",
 				zap.Error(err),
@@ -159,9 +306,86 @@ func (c *HttpQuery) DoQuery(ctx context.Context, uri string, r types.Request) (*
 			continue
 		}
 
+		if decoders != nil {
+			decodeErr := c.decode(res, defaultType, decoders)
+			c.Decoded(res.Server, len(res.Response), decodeErr)
+			if decodeErr != nil {
+				e.Add(decodeErr)
+				if undecodable == nil {
+					undecodable = make(map[string]struct{})
+				}
+				undecodable[res.Server] = struct{}{}
+				if len(undecodable) >= len(c.servers) {
+					return nil, &e
+				}
+				c.logger.Warn("failed to decode response, trying another server",
+					zap.String("server", res.Server),
+					zap.Error(decodeErr),
+				)
+				continue
+			}
+		}
+
 		return res, nil
 	}
 
 	e.Add(types.ErrMaxTriesExceeded)
 	return nil, &e
 }
+
+// knownContentTypes are formats that backends answer with. Response in one of them can only be decoded by decoder
+// of that format, so misconfigured protocol is reported as such instead of a cryptic decode error.
+var knownContentTypes = map[string]string{
+	httpHeaders.ContentTypeCarbonAPIv2PB: "carbonapi_v2_pb",
+	httpHeaders.ContentTypeCarbonAPIv3PB: "carbonapi_v3_pb",
+	httpHeaders.ContentTypeMsgpack:       "msgpack",
+	httpHeaders.ContentTypePickle:        "pickle",
+	httpHeaders.ContentTypeJSON:          "json",
+	httpHeaders.ContentTypeHTML:          "html page",
+}
+
+// decode decodes response with decoder of its Content-Type
+func (c *HttpQuery) decode(res *ServerResponse, defaultType string, decoders map[string]func(response []byte) error) error {
+	contentType, _, err := mime.ParseMediaType(res.ContentType)
+	if err != nil {
+		contentType = ""
+	}
+	if decode, ok := decoders[contentType]; ok {
+		return decode(res.Response)
+	}
+	if format, ok := knownContentTypes[contentType]; ok {
+		return fmt.Errorf("%s answered in %s format (Content-Type %s), but group %s expects %s, check its protocol",
+			res.Server, format, contentType, c.groupName, expectedFormats(defaultType, decoders))
+	}
+	return decoders[defaultType](res.Response)
+}
+
+// expectedFormats lists formats of decoders for error message, default one goes first
+func expectedFormats(defaultType string, decoders map[string]func(response []byte) error) string {
+	formats := []string{formatOf(defaultType)}
+	var other []string
+	for contentType := range decoders {
+		if contentType != defaultType {
+			other = append(other, formatOf(contentType))
+		}
+	}
+	sort.Strings(other)
+	return strings.Join(append(formats, other...), " or ")
+}
+
+func formatOf(contentType string) string {
+	if format, ok := knownContentTypes[contentType]; ok {
+		return format
+	}
+	return contentType
+}
+
+func (c *HttpQuery) notFoundKey(uri string, r types.Request) string {
+	key := c.groupName + uri
+	if r != nil {
+		if body, err := r.Marshal(); err == nil {
+			key += string(body)
+		}
+	}
+	return key
+}
@@ -0,0 +1,233 @@
+package helper
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-graphite/carbonapi/limiter"
+	"github.com/go-graphite/carbonapi/zipper/httpHeaders"
+	"github.com/go-graphite/carbonapi/zipper/types"
+	"go.uber.org/zap"
+)
+
+func TestDoQueryNotFound(t *testing.T) {
+	var requests int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&requests, 1)
+		http.Error(w, "not found", http.StatusNotFound)
+	}))
+	defer srv.Close()
+
+	SetNotFoundCacheTTL(time.Minute)
+	defer SetNotFoundCacheTTL(0)
+
+	q := NewHttpQuery(zap.NewNop(), "test", []string{srv.URL}, 3, limiter.NewServerLimiter([]string{srv.URL}, 0), srv.Client(), "")
+
+	for i := 0; i < 2; i++ {
+		res, err := q.DoQuery(context.Background(), "/render/?target=foo", nil)
+		if res != nil {
+			t.Fatalf("unexpected response: %+v", res)
+		}
+		if !types.IsNotFound(err) {
+			t.Fatalf("expected not found, got %+v", err)
+		}
+		if err.HaveFatalErrors {
+			t.Fatal("not found shouldn't be a fatal error")
+		}
+	}
+
+	if requests != 1 {
+		t.Fatalf("404 should be neither retried nor requested again while cached, got %v requests", requests)
+	}
+}
+
+func TestSetNotFoundCacheTTL(t *testing.T) {
+	defer SetNotFoundCacheTTL(0)
+	SetNotFoundCacheTTL(time.Minute)
+
+	goroutines := runtime.NumGoroutine()
+	cache := notFoundCache
+	for i := 0; i < 10; i++ {
+		SetNotFoundCacheTTL(time.Duration(i+1) * time.Minute)
+	}
+	if n := runtime.NumGoroutine(); n > goroutines {
+		t.Fatalf("cleaners are leaked on reconfiguration, %v goroutines, expected %v", n, goroutines)
+	}
+	if notFoundCache != cache || notFoundCacheTTL != 600 {
+		t.Fatalf("only TTL should change on reconfiguration, got TTL %v", notFoundCacheTTL)
+	}
+
+	SetNotFoundCacheTTL(0)
+	if notFoundCacheTTL != 0 {
+		t.Fatalf("negative caching should be disabled, got TTL %v", notFoundCacheTTL)
+	}
+}
+
+func TestDecodeErrorQuarantine(t *testing.T) {
+	var requests int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&requests, 1)
+		w.Write([]byte("garbage"))
+	}))
+	defer srv.Close()
+
+	SetDecodeErrorQuarantine(2, time.Minute)
+	defer SetDecodeErrorQuarantine(0, 0)
+	now := time.Now()
+	quarantine.now = func() time.Time { return now }
+
+	q := NewHttpQuery(zap.NewNop(), "test", []string{srv.URL}, 1, limiter.NewServerLimiter([]string{srv.URL}, 0), srv.Client(), "")
+	errs := DecodeErrors()
+	for i := 0; i < 2; i++ {
+		res, err := q.DoQuery(context.Background(), "/render/?target=foo", nil)
+		if err != nil {
+			t.Fatalf("unexpected error: %+v", err)
+		}
+		q.Decoded(res.Server, len(res.Response), types.ErrResponseLengthMismatch)
+	}
+	if DecodeErrors()-errs != 2 || QuarantinedServers() != 1 {
+		t.Fatalf("server should be quarantined, got %v decode errors, %v quarantined", DecodeErrors()-errs, QuarantinedServers())
+	}
+
+	_, err := q.DoQuery(context.Background(), "/render/?target=foo", nil)
+	if err == nil || err.HaveFatalErrors || len(err.Errors) != 1 || err.Errors[0] != types.ErrServerQuarantined {
+		t.Fatalf("expected quarantined error, got %+v", err)
+	}
+	if requests != 2 {
+		t.Fatalf("quarantined server shouldn't be queried, got %v requests", requests)
+	}
+
+	// quarantine expired, next request probes the server
+	now = now.Add(2 * time.Minute)
+	res, err := q.DoQuery(context.Background(), "/render/?target=foo", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	q.Decoded(res.Server, len(res.Response), nil)
+	if QuarantinedServers() != 0 || quarantine.isQuarantined(srv.URL) {
+		t.Fatal("server should be back after successful decode")
+	}
+}
+
+func TestDoQueryDecodedRetriesAnotherServer(t *testing.T) {
+	var badRequests, goodRequests int64
+	bad := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&badRequests, 1)
+		w.Write([]byte("garbage"))
+	}))
+	defer bad.Close()
+	good := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&goodRequests, 1)
+		w.Write([]byte("ok"))
+	}))
+	defer good.Close()
+
+	decode := func(response []byte) error {
+		if string(response) != "ok" {
+			return types.ErrResponseLengthMismatch
+		}
+		return nil
+	}
+
+	servers := []string{bad.URL, good.URL}
+	q := NewHttpQuery(zap.NewNop(), "test", servers, 1, limiter.NewServerLimiter(servers, 0), http.DefaultClient, "")
+	for i := 0; i < 2; i++ {
+		res, err := q.DoQueryDecoded(context.Background(), "/render/?target=foo", nil, decode)
+		if err != nil {
+			t.Fatalf("unexpected error: %+v", err)
+		}
+		if res.Server != good.URL {
+			t.Fatalf("expected response of %v, got %v", good.URL, res.Server)
+		}
+	}
+	if badRequests != 1 || goodRequests != 2 {
+		t.Fatalf("undecodable response should be requested from another server, got %v bad and %v good requests", badRequests, goodRequests)
+	}
+
+	// no other server to ask
+	q = NewHttpQuery(zap.NewNop(), "test", []string{bad.URL}, 3, limiter.NewServerLimiter([]string{bad.URL}, 0), http.DefaultClient, "")
+	res, err := q.DoQueryDecoded(context.Background(), "/render/?target=foo", nil, decode)
+	if res != nil || err == nil || len(err.Errors) != 1 || err.Errors[0] != types.ErrResponseLengthMismatch {
+		t.Fatalf("expected decode error, got %+v, %+v", res, err)
+	}
+	if badRequests != 2 {
+		t.Fatalf("server shouldn't be asked again for the same request, got %v requests", badRequests)
+	}
+}
+
+func TestDoQueryDecodedByType(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", r.FormValue("type"))
+		w.Write([]byte("body"))
+	}))
+	defer srv.Close()
+
+	q := NewHttpQuery(zap.NewNop(), "test", []string{srv.URL}, 1, limiter.NewServerLimiter([]string{srv.URL}, 0), srv.Client(), "")
+	var decodedBy string
+	decoders := map[string]func(response []byte) error{
+		httpHeaders.ContentTypeMsgpack: func([]byte) error { decodedBy = "msgpack"; return nil },
+		httpHeaders.ContentTypePickle:  func([]byte) error { decodedBy = "pickle"; return nil },
+	}
+
+	tests := []struct {
+		contentType string
+		decodedBy   string
+	}{
+		{"application/pickle", "pickle"},
+		{"application/x-msgpack; charset=utf-8", "msgpack"},
+		// unknown types are decoded in the configured format
+		{"", "msgpack"},
+		{"application/octet-stream", "msgpack"},
+		{"text/html; charset=utf-8", ""},
+		{"application/x-protobuf", ""},
+	}
+	for _, tt := range tests {
+		decodedBy = ""
+		_, err := q.DoQueryDecodedByType(context.Background(), "/render/?type="+url.QueryEscape(tt.contentType), nil, httpHeaders.ContentTypeMsgpack, decoders)
+		if decodedBy != tt.decodedBy {
+			t.Errorf("%q: decoded by %q, expected %q", tt.contentType, decodedBy, tt.decodedBy)
+		}
+		if tt.decodedBy == "" {
+			if err == nil || !strings.Contains(err.Errors[0].Error(), "expects msgpack or pickle") {
+				t.Errorf("%q: expected format mismatch error, got %+v", tt.contentType, err)
+			}
+		} else if err != nil {
+			t.Errorf("%q: unexpected error %+v", tt.contentType, err)
+		}
+	}
+}
+
+func TestDoQueryMaxResponseSize(t *testing.T) {
+	var requests int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&requests, 1)
+		w.Write([]byte(strings.Repeat("x", 10)))
+	}))
+	defer srv.Close()
+
+	q := NewHttpQuery(zap.NewNop(), "test", []string{srv.URL}, 3, limiter.NewServerLimiter([]string{srv.URL}, 0), srv.Client(), "")
+
+	SetMaxResponseSize(10)
+	defer SetMaxResponseSize(0)
+	res, err := q.DoQuery(context.Background(), "/render/?target=foo", nil)
+	if err != nil || res == nil || len(res.Response) != 10 {
+		t.Fatalf("response that fits the limit should be returned, got %+v, %+v", res, err)
+	}
+
+	SetMaxResponseSize(9)
+	truncated := TruncatedResponses()
+	res, err = q.DoQuery(context.Background(), "/render/?target=foo", nil)
+	if res != nil || err == nil || err.Errors[0] != types.ErrResponseTooLarge {
+		t.Fatalf("expected too large response error, got %+v, %+v", res, err)
+	}
+	if TruncatedResponses() != truncated+1 || requests != 2 {
+		t.Fatalf("too large response should be counted and not retried, got %v requests", requests)
+	}
+}
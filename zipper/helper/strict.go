@@ -0,0 +1,217 @@
+package helper
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/gogo/protobuf/proto"
+)
+
+// Kinds of anomalies that are found in responses of the backends. They are tolerated unless strict decode is enabled.
+const (
+	// AnomalyUnknownField is a protobuf field that is not in the schema, e.x. backend has newer or different schema
+	AnomalyUnknownField = "unknown_field"
+	// AnomalyUnknownKey is a key of pickle series or match that graphite-web doesn't send
+	AnomalyUnknownKey = "unknown_key"
+	// AnomalyAbsentMismatch is a carbonapi_v2_pb series which IsAbsent has different length than values
+	AnomalyAbsentMismatch = "absent_mismatch"
+	// AnomalyInvalidStep is a series with points but without positive step
+	AnomalyInvalidStep = "invalid_step"
+	// AnomalyInvalidRange is a series that ends before it starts
+	AnomalyInvalidRange = "invalid_range"
+	// AnomalyLengthMismatch is a series which amount of points doesn't match its time range and step
+	AnomalyLengthMismatch = "length_mismatch"
+)
+
+// AnomalyKinds lists all the kinds of anomalies, so they can be reported even if they never happened
+var AnomalyKinds = []string{
+	AnomalyUnknownField,
+	AnomalyUnknownKey,
+	AnomalyAbsentMismatch,
+	AnomalyInvalidStep,
+	AnomalyInvalidRange,
+	AnomalyLengthMismatch,
+}
+
+// strictDecode is configured once by zipper during startup
+var strictDecode int32
+
+var decodeAnomalies = func() map[string]*int64 {
+	m := make(map[string]*int64, len(AnomalyKinds))
+	for _, kind := range AnomalyKinds {
+		m[kind] = new(int64)
+	}
+	return m
+}()
+
+// SetStrictDecode makes responses with anomalies fail to decode instead of being tolerated
+func SetStrictDecode(strict bool) {
+	var v int32
+	if strict {
+		v = 1
+	}
+	atomic.StoreInt32(&strictDecode, v)
+}
+
+// StrictDecode returns true if responses with anomalies must be rejected
+func StrictDecode() bool {
+	return atomic.LoadInt32(&strictDecode) == 1
+}
+
+// DecodeAnomalies returns amount of anomalies of the kind found in responses, including rejected ones
+func DecodeAnomalies(kind string) int64 {
+	cnt, ok := decodeAnomalies[kind]
+	if !ok {
+		return 0
+	}
+	return atomic.LoadInt64(cnt)
+}
+
+// DecodeAnomaliesByKind returns DecodeAnomalies of every kind
+func DecodeAnomaliesByKind() map[string]int64 {
+	res := make(map[string]int64, len(decodeAnomalies))
+	for kind, cnt := range decodeAnomalies {
+		res[kind] = atomic.LoadInt64(cnt)
+	}
+	return res
+}
+
+// Anomaly counts anomaly of the response. It returns error describing it if strict decode is enabled, so decoder
+// can fail, and nil otherwise.
+func Anomaly(kind string, format string, args ...interface{}) error {
+	if cnt, ok := decodeAnomalies[kind]; ok {
+		atomic.AddInt64(cnt, 1)
+	}
+	if !StrictDecode() {
+		return nil
+	}
+	return fmt.Errorf("strict decode: %s: %s", kind, fmt.Sprintf(format, args...))
+}
+
+// protoField is a field of protobuf message as described by struct tags of generated code. nested is set for
+// fields that are messages themselves.
+type protoField struct {
+	name     string
+	wireType uint64
+	packed   bool
+	nested   protoSchema
+}
+
+type protoSchema map[uint64]*protoField
+
+var protoSchemas sync.Map
+
+var wireTypes = map[string]uint64{
+	"varint":   proto.WireVarint,
+	"zigzag32": proto.WireVarint,
+	"zigzag64": proto.WireVarint,
+	"fixed64":  proto.WireFixed64,
+	"bytes":    proto.WireBytes,
+	"group":    proto.WireStartGroup,
+	"fixed32":  proto.WireFixed32,
+}
+
+// schemaOf builds schema of generated message type from its struct tags
+func schemaOf(t reflect.Type) protoSchema {
+	if s, ok := protoSchemas.Load(t); ok {
+		return s.(protoSchema)
+	}
+
+	s := make(protoSchema)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		parts := strings.Split(f.Tag.Get("protobuf"), ",")
+		if len(parts) < 2 {
+			continue
+		}
+		num, err := strconv.ParseUint(parts[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		field := &protoField{name: f.Name, wireType: wireTypes[parts[0]]}
+		for _, p := range parts[2:] {
+			if p == "packed" {
+				field.packed = true
+			}
+		}
+		ft := f.Type
+		for ft.Kind() == reflect.Slice || ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		if field.wireType == proto.WireBytes && ft.Kind() == reflect.Struct {
+			field.nested = schemaOf(ft)
+		}
+		s[num] = field
+	}
+
+	protoSchemas.Store(t, s)
+	return s
+}
+
+// CheckProtoFields looks for fields of the encoded message that are not in the schema of msg, which must be
+// a pointer to generated message. Unknown fields are skipped by Unmarshal silently, so schema mismatch would go
+// unnoticed otherwise. Malformed message is always an error.
+func CheckProtoFields(b []byte, msg interface{}) error {
+	t := reflect.TypeOf(msg)
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return checkProtoFields(b, schemaOf(t), t.Name())
+}
+
+func checkProtoFields(b []byte, s protoSchema, path string) error {
+	for len(b) > 0 {
+		key, n := proto.DecodeVarint(b)
+		if n == 0 {
+			return fmt.Errorf("%s: malformed field key", path)
+		}
+		b = b[n:]
+		num, wireType := key>>3, key&7
+
+		var value []byte
+		switch wireType {
+		case proto.WireVarint:
+			if _, n = proto.DecodeVarint(b); n == 0 {
+				return fmt.Errorf("%s: malformed varint", path)
+			}
+		case proto.WireFixed64:
+			n = 8
+		case proto.WireFixed32:
+			n = 4
+		case proto.WireBytes:
+			length, l := proto.DecodeVarint(b)
+			if l == 0 || length > uint64(len(b)-l) {
+				return fmt.Errorf("%s: malformed length of field %d", path, num)
+			}
+			value = b[l : l+int(length)]
+			n = l + int(length)
+		default:
+			return fmt.Errorf("%s: unsupported wire type %d of field %d", path, wireType, num)
+		}
+		if n > len(b) {
+			return fmt.Errorf("%s: truncated field %d", path, num)
+		}
+		b = b[n:]
+
+		field, ok := s[num]
+		if !ok {
+			if err := Anomaly(AnomalyUnknownField, "%s: field %d", path, num); err != nil {
+				return err
+			}
+			continue
+		}
+		if field.wireType != wireType && !(field.packed && wireType == proto.WireBytes) {
+			return fmt.Errorf("%s.%s: unexpected wire type %d", path, field.name, wireType)
+		}
+		if field.nested != nil {
+			if err := checkProtoFields(value, field.nested, path+"."+field.name); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
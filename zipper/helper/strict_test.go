@@ -0,0 +1,50 @@
+package helper
+
+import (
+	"testing"
+
+	protov2 "github.com/go-graphite/protocol/carbonapi_v2_pb"
+	"github.com/gogo/protobuf/proto"
+)
+
+func TestCheckProtoFields(t *testing.T) {
+	series := protov2.FetchResponse{Name: "foo", StartTime: 60, StopTime: 180, StepTime: 60, Values: []float64{1, 2}, IsAbsent: []bool{false, false}}
+	b, err := (&protov2.MultiFetchResponse{Metrics: []protov2.FetchResponse{series}}).Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckProtoFields(b, &protov2.MultiFetchResponse{}); err != nil {
+		t.Fatalf("valid response shouldn't have anomalies: %v", err)
+	}
+
+	// series from the future schema with extra varint field 15
+	s, err := series.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s = append(s, proto.EncodeVarint(15<<3|proto.WireVarint)...)
+	s = append(s, proto.EncodeVarint(1)...)
+	unknown := append(proto.EncodeVarint(1<<3|proto.WireBytes), proto.EncodeVarint(uint64(len(s)))...)
+	unknown = append(unknown, s...)
+
+	before := DecodeAnomalies(AnomalyUnknownField)
+	if err := CheckProtoFields(unknown, &protov2.MultiFetchResponse{}); err != nil {
+		t.Fatalf("unknown fields should be tolerated by default: %v", err)
+	}
+	if DecodeAnomalies(AnomalyUnknownField)-before != 1 {
+		t.Fatal("unknown field should be counted")
+	}
+	var metrics protov2.MultiFetchResponse
+	if err := metrics.Unmarshal(unknown); err != nil || metrics.Metrics[0].Name != "foo" {
+		t.Fatalf("response with unknown field should still be decodable: %v", err)
+	}
+
+	SetStrictDecode(true)
+	defer SetStrictDecode(false)
+	if err := CheckProtoFields(unknown, &protov2.MultiFetchResponse{}); err == nil {
+		t.Fatal("unknown field should be rejected in strict mode")
+	}
+	if err := CheckProtoFields(b[:len(b)-3], &protov2.MultiFetchResponse{}); err == nil {
+		t.Fatal("truncated response should be rejected")
+	}
+}
@@ -6,4 +6,6 @@ const (
 	ContentTypePickle        = "application/pickle"
 	ContentTypeCarbonAPIv3PB = "application/x-carbonapi-v3-pb"
 	ContentTypeCarbonAPIv2PB = "application/x-protobuf"
+	ContentTypeMsgpack       = "application/x-msgpack"
+	ContentTypeHTML          = "text/html"
 )
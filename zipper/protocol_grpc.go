@@ -0,0 +1,7 @@
+// +build !nogrpc
+
+package zipper
+
+import (
+	_ "github.com/go-graphite/carbonapi/zipper/protocols/grpc"
+)
@@ -74,23 +74,36 @@ type CapabilityResponse struct {
 	ProtoToServers map[string][]string
 }
 
-func getBestSupportedProtocol(logger *zap.Logger, servers []string, concurencyLimit int) *CapabilityResponse {
+func getBestSupportedProtocol(logger *zap.Logger, servers []string, concurencyLimit int, auth types.BackendAuth, tlsConf types.BackendTLS) *CapabilityResponse {
 	response := &CapabilityResponse{
 		ProtoToServers: make(map[string][]string),
 	}
 	groupName := "capability query"
 	limiter := limiter.NewServerLimiter([]string{groupName}, concurencyLimit)
 
-	httpClient := &http.Client{
-		Transport: &http.Transport{
-			DialContext: (&net.Dialer{
-				// TODO: Make that configurable
-				Timeout:   200 * time.Millisecond,
-				KeepAlive: 30 * time.Second,
-				DualStack: true,
-			}).DialContext,
-		},
+	tlsConfig, err := helper.TLSConfig(tlsConf)
+	if err != nil {
+		logger.Error("failed to create tls config",
+			zap.Error(err),
+		)
+		return nil
+	}
+	transport, err := helper.WithAuth(auth, &http.Transport{
+		TLSClientConfig: tlsConfig,
+		DialContext: (&net.Dialer{
+			// TODO: Make that configurable
+			Timeout:   200 * time.Millisecond,
+			KeepAlive: 30 * time.Second,
+			DualStack: true,
+		}).DialContext,
+	})
+	if err != nil {
+		logger.Error("failed to read credentials",
+			zap.Error(err),
+		)
+		return nil
 	}
+	httpClient := &http.Client{Transport: transport}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
@@ -151,7 +164,7 @@ func New(logger *zap.Logger, config types.BackendV2) (types.ServerClient, *error
 	if config.ConcurrencyLimit != nil {
 		limit = *config.ConcurrencyLimit
 	}
-	res := getBestSupportedProtocol(logger, config.Servers, limit)
+	res := getBestSupportedProtocol(logger, config.Servers, limit, config.Auth, config.TLS)
 	if res == nil {
 		return nil, errors.Fatalf("can't query all backend")
 	}
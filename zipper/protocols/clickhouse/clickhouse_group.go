@@ -0,0 +1,325 @@
+package clickhouse
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/go-graphite/carbonapi/limiter"
+	"github.com/go-graphite/carbonapi/phases"
+	"github.com/go-graphite/carbonapi/zipper/errors"
+	"github.com/go-graphite/carbonapi/zipper/helper"
+	"github.com/go-graphite/carbonapi/zipper/httpHeaders"
+	"github.com/go-graphite/carbonapi/zipper/metadata"
+	"github.com/go-graphite/carbonapi/zipper/types"
+	protov2 "github.com/go-graphite/protocol/carbonapi_v2_pb"
+	protov3 "github.com/go-graphite/protocol/carbonapi_v3_pb"
+
+	"go.uber.org/zap"
+)
+
+const (
+	format = "protobuf"
+)
+
+func init() {
+	aliases := []string{"graphite-clickhouse", "graphite_clickhouse", "clickhouse"}
+	metadata.Metadata.Lock()
+	for _, name := range aliases {
+		metadata.Metadata.SupportedProtocols[name] = struct{}{}
+		metadata.Metadata.ProtocolInits[name] = New
+		metadata.Metadata.ProtocolInitsWithLimiter[name] = NewWithLimiter
+	}
+	defer metadata.Metadata.Unlock()
+}
+
+// ClickhouseGroup talks to graphite-clickhouse. It uses graphite-web compatible API with carbonapi_v2_pb format,
+// but only has render and find handlers, and its responses differ from go-carbon ones in details that are
+// normalized here.
+type ClickhouseGroup struct {
+	groupName string
+	servers   []string
+
+	client *http.Client
+
+	limiter              *limiter.ServerLimiter
+	logger               *zap.Logger
+	timeout              types.Timeouts
+	maxTries             int
+	maxMetricsPerRequest int
+
+	httpQuery *helper.HttpQuery
+}
+
+func (c *ClickhouseGroup) Children() []types.ServerClient {
+	return []types.ServerClient{c}
+}
+
+func NewWithLimiter(logger *zap.Logger, config types.BackendV2, limiter *limiter.ServerLimiter) (types.ServerClient, *errors.Errors) {
+	logger = logger.With(zap.String("type", "clickhouseGroup"), zap.String("name", config.GroupName))
+
+	httpClient, err := helper.NewHTTPClient(config)
+	if err != nil {
+		return nil, errors.Fatalf("failed to create http client: %v", err)
+	}
+
+	httpQuery := helper.NewHttpQuery(logger, config.GroupName, config.Servers, *config.MaxTries, limiter, httpClient, httpHeaders.ContentTypeCarbonAPIv2PB)
+
+	c := &ClickhouseGroup{
+		groupName:            config.GroupName,
+		servers:              config.Servers,
+		timeout:              *config.Timeouts,
+		maxTries:             *config.MaxTries,
+		maxMetricsPerRequest: config.MaxBatchSize,
+
+		client:  httpClient,
+		limiter: limiter,
+		logger:  logger,
+
+		httpQuery: httpQuery,
+	}
+	return c, nil
+}
+
+func New(logger *zap.Logger, config types.BackendV2) (types.ServerClient, *errors.Errors) {
+	if config.ConcurrencyLimit == nil {
+		return nil, errors.Fatal("concurency limit is not set")
+	}
+	if len(config.Servers) == 0 {
+		return nil, errors.Fatal("no servers specified")
+	}
+	limiter := limiter.NewServerLimiter([]string{config.GroupName}, *config.ConcurrencyLimit)
+
+	return NewWithLimiter(logger, config, limiter)
+}
+
+func (c ClickhouseGroup) MaxMetricsPerRequest() int {
+	return c.maxMetricsPerRequest
+}
+
+func (c ClickhouseGroup) Name() string {
+	return c.groupName
+}
+
+func (c ClickhouseGroup) Backends() []string {
+	return c.servers
+}
+
+type queryBatch struct {
+	pathExpression string
+	from           int64
+	until          int64
+}
+
+// normalize converts series returned by graphite-clickhouse to the common format. Absent points are NaN, IsAbsent
+// of graphite-clickhouse may be shorter than values or missing at all. Series without step can't be merged with
+// anything, so they are dropped, as well as series that were already returned for another target of the batch.
+// StopTime is never before the last point.
+func normalize(metrics []protov2.FetchResponse, batch queryBatch) []protov3.FetchResponse {
+	res := make([]protov3.FetchResponse, 0, len(metrics))
+	seen := make(map[string]struct{}, len(metrics))
+	for _, m := range metrics {
+		if m.StepTime <= 0 {
+			continue
+		}
+		if _, ok := seen[m.Name]; ok {
+			continue
+		}
+		seen[m.Name] = struct{}{}
+
+		for i, absent := range m.IsAbsent {
+			if absent && i < len(m.Values) {
+				m.Values[i] = math.NaN()
+			}
+		}
+		stop := int64(m.StopTime)
+		if last := int64(m.StartTime) + int64(len(m.Values)-1)*int64(m.StepTime); stop < last {
+			stop = last
+		}
+
+		res = append(res, protov3.FetchResponse{
+			Name:              m.Name,
+			PathExpression:    batch.pathExpression,
+			ConsolidationFunc: "Average",
+			StartTime:         int64(m.StartTime),
+			StopTime:          stop,
+			StepTime:          int64(m.StepTime),
+			Values:            m.Values,
+			RequestStartTime:  batch.from,
+			RequestStopTime:   batch.until,
+		})
+	}
+	return res
+}
+
+func (c *ClickhouseGroup) Fetch(ctx context.Context, request *protov3.MultiFetchRequest) (*protov3.MultiFetchResponse, *types.Stats, *errors.Errors) {
+	stats := &types.Stats{}
+	rewrite, _ := url.Parse("http://127.0.0.1/render/")
+
+	batches := make(map[queryBatch][]string)
+	for _, m := range request.Metrics {
+		b := queryBatch{
+			pathExpression: m.PathExpression,
+			from:           m.StartTime,
+			until:          m.StopTime,
+		}
+
+		batches[b] = append(batches[b], m.Name)
+	}
+
+	var r protov3.MultiFetchResponse
+	for batch, targets := range batches {
+		v := url.Values{
+			"target": targets,
+			"format": []string{format},
+			"from":   []string{strconv.Itoa(int(batch.from))},
+			"until":  []string{strconv.Itoa(int(batch.until))},
+		}
+		rewrite.RawQuery = v.Encode()
+		var metrics protov2.MultiFetchResponse
+		res, err := c.httpQuery.DoQueryDecoded(ctx, rewrite.RequestURI(), nil, func(response []byte) error {
+			metrics = protov2.MultiFetchResponse{}
+			t0 := time.Now()
+			defer phases.Since(phases.Decode, t0)
+			if err := helper.CheckProtoFields(response, &metrics); err != nil {
+				return err
+			}
+			return metrics.Unmarshal(response)
+		})
+		if types.IsNotFound(err) {
+			stats.NotFound++
+			continue
+		}
+		if err != nil {
+			err.HaveFatalErrors = false
+			return nil, stats, err
+		}
+		stats.Servers = append(stats.Servers, res.Server)
+
+		series := normalize(metrics.Metrics, batch)
+		if len(series) == 0 {
+			// graphite-clickhouse answers 200 with empty response if nothing matched
+			stats.NotFound++
+			continue
+		}
+		r.Metrics = append(r.Metrics, series...)
+	}
+
+	if len(r.Metrics) == 0 {
+		return nil, stats, errors.FromErrNonFatal(types.ErrNotFound)
+	}
+	return &r, stats, nil
+}
+
+func (c *ClickhouseGroup) Find(ctx context.Context, request *protov3.MultiGlobRequest) (*protov3.MultiGlobResponse, *types.Stats, *errors.Errors) {
+	logger := c.logger.With(zap.String("type", "find"), zap.Strings("request", request.Metrics))
+	stats := &types.Stats{}
+	rewrite, _ := url.Parse("http://127.0.0.1/metrics/find/")
+
+	var r protov3.MultiGlobResponse
+	r.Metrics = make([]protov3.GlobResponse, 0)
+	var e errors.Errors
+	for _, query := range request.Metrics {
+		v := url.Values{
+			"query":  []string{query},
+			"format": []string{format},
+		}
+		rewrite.RawQuery = v.Encode()
+		var globs protov2.GlobResponse
+		res, err := c.httpQuery.DoQueryDecoded(ctx, rewrite.RequestURI(), nil, func(response []byte) error {
+			globs = protov2.GlobResponse{}
+			t0 := time.Now()
+			defer phases.Since(phases.Decode, t0)
+			if err := helper.CheckProtoFields(response, &globs); err != nil {
+				return err
+			}
+			return globs.Unmarshal(response)
+		})
+		if types.IsNotFound(err) {
+			stats.NotFound++
+			continue
+		}
+		if err != nil {
+			e.Merge(err)
+			continue
+		}
+		stats.Servers = append(stats.Servers, res.Server)
+		if len(globs.Matches) == 0 {
+			stats.NotFound++
+			continue
+		}
+
+		matches := make([]protov3.GlobMatch, 0, len(globs.Matches))
+		for _, m := range globs.Matches {
+			matches = append(matches, protov3.GlobMatch{
+				Path:   m.Path,
+				IsLeaf: m.IsLeaf,
+			})
+		}
+		// graphite-clickhouse doesn't always fill the name of the response
+		r.Metrics = append(r.Metrics, protov3.GlobResponse{
+			Name:    query,
+			Matches: matches,
+		})
+	}
+
+	if len(e.Errors) != 0 {
+		logger.Error("errors occurred while getting results",
+			zap.Any("errors", e.Errors),
+		)
+	}
+
+	if len(r.Metrics) == 0 {
+		if len(e.Errors) == 0 {
+			return nil, stats, errors.FromErrNonFatal(types.ErrNotFound)
+		}
+		return nil, stats, errors.FromErr(types.ErrNoResponseFetched)
+	}
+	return &r, stats, nil
+}
+
+// Info is not supported by graphite-clickhouse, retention is configured in ClickHouse
+func (c *ClickhouseGroup) Info(ctx context.Context, request *protov3.MultiMetricsInfoRequest) (*protov3.ZipperInfoResponse, *types.Stats, *errors.Errors) {
+	return nil, nil, errors.FromErrNonFatal(types.ErrNotImplementedYet)
+}
+
+// List is not supported by graphite-clickhouse, index might be too big to be sent at once
+func (c *ClickhouseGroup) List(ctx context.Context) (*protov3.ListMetricsResponse, *types.Stats, *errors.Errors) {
+	return nil, nil, errors.FromErrNonFatal(types.ErrNotImplementedYet)
+}
+
+func (c *ClickhouseGroup) Stats(ctx context.Context) (*protov3.MetricDetailsResponse, *types.Stats, *errors.Errors) {
+	return nil, nil, errors.FromErrNonFatal(types.ErrNotImplementedYet)
+}
+
+func (c *ClickhouseGroup) ProbeTLDs(ctx context.Context) ([]string, *errors.Errors) {
+	logger := c.logger.With(zap.String("function", "prober"))
+	req := &protov3.MultiGlobRequest{
+		Metrics: []string{"*"},
+	}
+
+	logger.Debug("doing request",
+		zap.Strings("request", req.Metrics),
+	)
+
+	res, _, err := c.Find(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	var tlds []string
+	for _, m := range res.Metrics {
+		for _, v := range m.Matches {
+			tlds = append(tlds, v.Path)
+		}
+	}
+
+	logger.Debug("will return data",
+		zap.Strings("tlds", tlds),
+	)
+
+	return tlds, nil
+}
@@ -0,0 +1,32 @@
+package clickhouse
+
+import (
+	"math"
+	"testing"
+
+	protov2 "github.com/go-graphite/protocol/carbonapi_v2_pb"
+)
+
+func TestNormalize(t *testing.T) {
+	batch := queryBatch{pathExpression: "foo.*", from: 100, until: 200}
+	metrics := []protov2.FetchResponse{
+		{Name: "foo.bar", StartTime: 120, StopTime: 120, StepTime: 10, Values: []float64{1, 0, 3}, IsAbsent: []bool{false, true}},
+		{Name: "foo.bar", StartTime: 120, StopTime: 150, StepTime: 10, Values: []float64{1, 2, 3}},
+		{Name: "foo.empty", StartTime: 100, StopTime: 200},
+	}
+
+	res := normalize(metrics, batch)
+	if len(res) != 1 {
+		t.Fatalf("duplicated and empty series should be dropped, got %+v", res)
+	}
+	m := res[0]
+	if m.Name != "foo.bar" || m.PathExpression != "foo.*" || m.RequestStartTime != 100 || m.RequestStopTime != 200 {
+		t.Fatalf("unexpected series %+v", m)
+	}
+	if m.Values[0] != 1 || !math.IsNaN(m.Values[1]) || m.Values[2] != 3 {
+		t.Fatalf("absent points should be NaN, got %v", m.Values)
+	}
+	if m.StopTime != 140 {
+		t.Fatalf("stop time should cover the last point, got %v", m.StopTime)
+	}
+}
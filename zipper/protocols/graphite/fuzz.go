@@ -0,0 +1,28 @@
+// +build gofuzz
+
+package v2
+
+import (
+	"github.com/go-graphite/carbonapi/zipper/helper"
+	"github.com/go-graphite/carbonapi/zipper/protocols/graphite/msgpack"
+)
+
+// Fuzz decodes data with every render and find decoder, as backend can answer in any graphite-web format. Strict
+// decode is enabled, so all the checks are run.
+func Fuzz(data []byte) int {
+	helper.SetStrictDecode(true)
+	res := 0
+	var metrics msgpack.MultiGraphiteFetchResponse
+	for _, decode := range fetchDecoders(&metrics) {
+		if decode(data) == nil {
+			res = 1
+		}
+	}
+	var globs msgpack.MultiGraphiteGlobResponse
+	for _, decode := range findDecoders(&globs) {
+		if decode(data) == nil {
+			res = 1
+		}
+	}
+	return res
+}
@@ -3,12 +3,13 @@ package v2
 import (
 	"context"
 	"math"
-	"net"
 	"net/http"
 	"net/url"
 	"strconv"
+	"time"
 
 	"github.com/go-graphite/carbonapi/limiter"
+	"github.com/go-graphite/carbonapi/phases"
 	"github.com/go-graphite/carbonapi/zipper/errors"
 	"github.com/go-graphite/carbonapi/zipper/helper"
 	"github.com/go-graphite/carbonapi/zipper/httpHeaders"
@@ -22,7 +23,7 @@ import (
 )
 
 func init() {
-	aliases := []string{"msgpack"}
+	aliases := []string{"msgpack", "pickle"}
 	metadata.Metadata.Lock()
 	for _, name := range aliases {
 		metadata.Metadata.SupportedProtocols[name] = struct{}{}
@@ -56,15 +57,9 @@ func (g *GraphiteGroup) Children() []types.ServerClient {
 func NewWithLimiter(logger *zap.Logger, config types.BackendV2, limiter *limiter.ServerLimiter) (types.ServerClient, *errors.Errors) {
 	logger = logger.With(zap.String("type", "graphite"), zap.String("protocol", config.Protocol), zap.String("name", config.GroupName))
 
-	httpClient := &http.Client{
-		Transport: &http.Transport{
-			MaxIdleConnsPerHost: *config.MaxIdleConnsPerHost,
-			DialContext: (&net.Dialer{
-				Timeout:   config.Timeouts.Connect,
-				KeepAlive: *config.KeepAliveInterval,
-				DualStack: true,
-			}).DialContext,
-		},
+	httpClient, err := helper.NewHTTPClient(config)
+	if err != nil {
+		return nil, errors.Fatalf("failed to create http client: %v", err)
 	}
 
 	httpQuery := helper.NewHttpQuery(logger, config.GroupName, config.Servers, *config.MaxTries, limiter, httpClient, httpHeaders.ContentTypeCarbonAPIv2PB)
@@ -129,7 +124,12 @@ func (c *GraphiteGroup) Fetch(ctx context.Context, request *protov3.MultiFetchRe
 			"until":  []string{strconv.Itoa(int(request.Metrics[0].StopTime))},
 		}
 		rewrite.RawQuery = v.Encode()
-		res, err := c.httpQuery.DoQuery(ctx, rewrite.RequestURI(), nil)
+		var metrics msgpack.MultiGraphiteFetchResponse
+		res, err := c.httpQuery.DoQueryDecodedByType(ctx, rewrite.RequestURI(), nil, c.contentType(), fetchDecoders(&metrics))
+		if types.IsNotFound(err) {
+			stats.NotFound++
+			continue
+		}
 		if err == nil {
 			err = &errors.Errors{}
 		}
@@ -137,15 +137,11 @@ func (c *GraphiteGroup) Fetch(ctx context.Context, request *protov3.MultiFetchRe
 			err.HaveFatalErrors = false
 			return nil, stats, err
 		}
-
-		var metrics msgpack.MultiGraphiteFetchResponse
-		_, e := metrics.UnmarshalMsg(res.Response)
-		err.AddFatal(e)
-		if err.HaveFatalErrors {
+		if res == nil {
 			return nil, stats, err
 		}
 
-		for _, m := range metrics {
+		for _, m := range mergeByName(metrics) {
 			vals := make([]float64, len(m.Values))
 			for i, vIface := range m.Values {
 				if v, ok := vIface.(float64); ok {
@@ -167,9 +163,81 @@ func (c *GraphiteGroup) Fetch(ctx context.Context, request *protov3.MultiFetchRe
 		}
 	}
 
+	if len(r.Metrics) == 0 && stats.NotFound > 0 {
+		return nil, stats, errors.FromErrNonFatal(types.ErrNotFound)
+	}
+
 	return &r, stats, nil
 }
 
+// contentType returns Content-Type of the configured format, responses without one are decoded in that format
+func (c *GraphiteGroup) contentType() string {
+	if c.protocol == "pickle" {
+		return httpHeaders.ContentTypePickle
+	}
+	return httpHeaders.ContentTypeMsgpack
+}
+
+// fetchDecoders decode render response in format of its Content-Type, so backends that answer in the other
+// graphite-web format are still understood
+func fetchDecoders(metrics *msgpack.MultiGraphiteFetchResponse) map[string]func(response []byte) error {
+	return map[string]func(response []byte) error{
+		httpHeaders.ContentTypeMsgpack: func(response []byte) error {
+			defer phases.Since(phases.Decode, time.Now())
+			*metrics = nil
+			if _, err := metrics.UnmarshalMsg(response); err != nil {
+				return err
+			}
+			return checkSeries(*metrics)
+		},
+		httpHeaders.ContentTypePickle: func(response []byte) error {
+			defer phases.Since(phases.Decode, time.Now())
+			var err error
+			if *metrics, err = unmarshalPickleFetch(response); err != nil {
+				return err
+			}
+			return checkSeries(*metrics)
+		},
+	}
+}
+
+// checkSeries reports anomalies of decoded series, see helper.Anomaly. graphite-web returns (end-start)/step points.
+func checkSeries(metrics msgpack.MultiGraphiteFetchResponse) error {
+	for _, m := range metrics {
+		var err error
+		switch {
+		case m.Step == 0 && len(m.Values) > 0:
+			err = helper.Anomaly(helper.AnomalyInvalidStep, "%s: step 0", m.Name)
+		case m.End < m.Start:
+			err = helper.Anomaly(helper.AnomalyInvalidRange, "%s: end %d is before start %d", m.Name, m.End, m.Start)
+		case m.Step > 0 && uint32(len(m.Values)) != (m.End-m.Start)/m.Step:
+			err = helper.Anomaly(helper.AnomalyLengthMismatch, "%s: %d values for %d-%d with step %d", m.Name, len(m.Values), m.Start, m.End, m.Step)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// findDecoders are fetchDecoders for find response
+func findDecoders(globs *msgpack.MultiGraphiteGlobResponse) map[string]func(response []byte) error {
+	return map[string]func(response []byte) error{
+		httpHeaders.ContentTypeMsgpack: func(response []byte) error {
+			defer phases.Since(phases.Decode, time.Now())
+			*globs = nil
+			_, err := globs.UnmarshalMsg(response)
+			return err
+		},
+		httpHeaders.ContentTypePickle: func(response []byte) error {
+			defer phases.Since(phases.Decode, time.Now())
+			var err error
+			*globs, err = unmarshalPickleFind(response)
+			return err
+		},
+	}
+}
+
 func (c *GraphiteGroup) Find(ctx context.Context, request *protov3.MultiGlobRequest) (*protov3.MultiGlobResponse, *types.Stats, *errors.Errors) {
 	logger := c.logger.With(zap.String("type", "find"), zap.Strings("request", request.Metrics))
 	stats := &types.Stats{}
@@ -184,15 +252,14 @@ func (c *GraphiteGroup) Find(ctx context.Context, request *protov3.MultiGlobRequ
 			"format": []string{c.protocol},
 		}
 		rewrite.RawQuery = v.Encode()
-		res, err := c.httpQuery.DoQuery(ctx, rewrite.RequestURI(), nil)
-		if err != nil {
-			e.Merge(err)
+		var globs msgpack.MultiGraphiteGlobResponse
+		res, err := c.httpQuery.DoQueryDecodedByType(ctx, rewrite.RequestURI(), nil, c.contentType(), findDecoders(&globs))
+		if types.IsNotFound(err) {
+			stats.NotFound++
 			continue
 		}
-		var globs msgpack.MultiGraphiteGlobResponse
-		_, marshalErr := globs.UnmarshalMsg(res.Response)
-		if marshalErr != nil {
-			e.Add(marshalErr)
+		if err != nil {
+			e.Merge(err)
 			continue
 		}
 
@@ -217,6 +284,9 @@ func (c *GraphiteGroup) Find(ctx context.Context, request *protov3.MultiGlobRequ
 	}
 
 	if len(r.Metrics) == 0 {
+		if stats.NotFound > 0 && len(e.Errors) == 0 {
+			return nil, stats, errors.FromErrNonFatal(types.ErrNotFound)
+		}
 		return nil, stats, errors.FromErr(types.ErrNoResponseFetched)
 	}
 	return &r, stats, nil
@@ -242,16 +312,17 @@ func (c *GraphiteGroup) Info(ctx context.Context, request *protov3.MultiMetricsI
 			"format": []string{c.protocol},
 		}
 		rewrite.RawQuery = v.Encode()
-		res, e2 := c.httpQuery.DoQuery(ctx, rewrite.RequestURI(), nil)
-		if e2 != nil {
-			e.Merge(e2)
+		var info protov2.InfoResponse
+		res, e2 := c.httpQuery.DoQueryDecoded(ctx, rewrite.RequestURI(), nil, func(response []byte) error {
+			info = protov2.InfoResponse{}
+			return info.Unmarshal(response)
+		})
+		if types.IsNotFound(e2) {
+			stats.NotFound++
 			continue
 		}
-
-		var info protov2.InfoResponse
-		err := info.Unmarshal(res.Response)
-		if err != nil {
-			e.Add(err)
+		if e2 != nil {
+			e.Merge(e2)
 			continue
 		}
 		stats.Servers = append(stats.Servers, res.Server)
@@ -285,6 +356,9 @@ func (c *GraphiteGroup) Info(ctx context.Context, request *protov3.MultiMetricsI
 	}
 
 	if len(r.Info[server].Metrics) == 0 {
+		if stats.NotFound > 0 && len(e.Errors) == 0 {
+			return nil, stats, errors.FromErrNonFatal(types.ErrNotFound)
+		}
 		return nil, stats, errors.FromErr(types.ErrNoResponseFetched)
 	}
 
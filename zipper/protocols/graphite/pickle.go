@@ -0,0 +1,169 @@
+package v2
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+
+	"github.com/go-graphite/carbonapi/zipper/helper"
+	"github.com/go-graphite/carbonapi/zipper/protocols/graphite/msgpack"
+	pickle "github.com/lomik/og-rek"
+)
+
+// pickleFetchKeys and pickleFindKeys are keys of series and matches that graphite-web sends, anything else is
+// reported as an anomaly
+var pickleFetchKeys = map[string]bool{
+	"name": true, "pathExpression": true, "start": true, "end": true, "step": true, "values": true,
+	"consolidationFunc": true, "xFilesFactor": true,
+}
+
+var pickleFindKeys = map[string]bool{
+	"metric_path": true, "isLeaf": true, "path": true, "is_leaf": true, "intervals": true,
+}
+
+// checkPickleKeys reports keys of d that are not in known
+func checkPickleKeys(d map[interface{}]interface{}, known map[string]bool, what string) error {
+	for k := range d {
+		if key, ok := k.(string); !ok || !known[key] {
+			if err := helper.Anomaly(helper.AnomalyUnknownKey, "pickle: %s: %v", what, k); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// unmarshalPickleFetch decodes graphite-web render response in pickle format. Response is a list of series and
+// glob targets usually return several of them.
+func unmarshalPickleFetch(b []byte) (msgpack.MultiGraphiteFetchResponse, error) {
+	decoded, err := pickle.NewDecoder(bytes.NewReader(b)).Decode()
+	if err != nil {
+		return nil, err
+	}
+	list, ok := decoded.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("pickle: unexpected response type %T", decoded)
+	}
+
+	res := make(msgpack.MultiGraphiteFetchResponse, 0, len(list))
+	for i, item := range list {
+		d, ok := item.(map[interface{}]interface{})
+		if !ok {
+			return nil, fmt.Errorf("pickle: unexpected type of series %d: %T", i, item)
+		}
+		var m msgpack.GraphiteFetchResponse
+		m.Name, ok = d["name"].(string)
+		if !ok {
+			return nil, fmt.Errorf("pickle: series %d has no name", i)
+		}
+		if err := checkPickleKeys(d, pickleFetchKeys, m.Name); err != nil {
+			return nil, err
+		}
+		m.PathExpression, _ = d["pathExpression"].(string)
+		if m.Start, err = pickleUint32(d["start"]); err != nil {
+			return nil, fmt.Errorf("pickle: %s: start: %v", m.Name, err)
+		}
+		if m.End, err = pickleUint32(d["end"]); err != nil {
+			return nil, fmt.Errorf("pickle: %s: end: %v", m.Name, err)
+		}
+		if m.Step, err = pickleUint32(d["step"]); err != nil {
+			return nil, fmt.Errorf("pickle: %s: step: %v", m.Name, err)
+		}
+
+		values, ok := d["values"].([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("pickle: %s: unexpected type of values: %T", m.Name, d["values"])
+		}
+		m.Values = make([]interface{}, len(values))
+		for j, v := range values {
+			switch v := v.(type) {
+			case float64:
+				m.Values[j] = v
+			case int64:
+				m.Values[j] = float64(v)
+			case *big.Int:
+				m.Values[j], _ = new(big.Float).SetInt(v).Float64()
+			case pickle.None:
+				m.Values[j] = nil
+			default:
+				return nil, fmt.Errorf("pickle: %s: unexpected type of value: %T", m.Name, v)
+			}
+		}
+		res = append(res, m)
+	}
+
+	return res, nil
+}
+
+// unmarshalPickleFind decodes graphite-web find response in pickle format
+func unmarshalPickleFind(b []byte) (msgpack.MultiGraphiteGlobResponse, error) {
+	decoded, err := pickle.NewDecoder(bytes.NewReader(b)).Decode()
+	if err != nil {
+		return nil, err
+	}
+	list, ok := decoded.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("pickle: unexpected response type %T", decoded)
+	}
+
+	res := make(msgpack.MultiGraphiteGlobResponse, 0, len(list))
+	for i, item := range list {
+		d, ok := item.(map[interface{}]interface{})
+		if !ok {
+			return nil, fmt.Errorf("pickle: unexpected type of match %d: %T", i, item)
+		}
+		var m msgpack.GraphiteGlobResponse
+		if m.Path, ok = d["metric_path"].(string); !ok {
+			return nil, fmt.Errorf("pickle: match %d has no metric_path", i)
+		}
+		if err := checkPickleKeys(d, pickleFindKeys, m.Path); err != nil {
+			return nil, err
+		}
+		m.IsLeaf, _ = d["isLeaf"].(bool)
+		res = append(res, m)
+	}
+	return res, nil
+}
+
+func pickleUint32(v interface{}) (uint32, error) {
+	switch v := v.(type) {
+	case int64:
+		if v >= 0 && v <= 1<<32-1 {
+			return uint32(v), nil
+		}
+	case *big.Int:
+		if v.IsUint64() && v.Uint64() <= 1<<32-1 {
+			return uint32(v.Uint64()), nil
+		}
+	case nil:
+		return 0, fmt.Errorf("missing")
+	}
+	return 0, fmt.Errorf("unexpected value %v", v)
+}
+
+// mergeByName merges series with the same name. graphite-web returns one series per server that has the metric
+// when it's in cluster mode, absent points of the first one are filled from the others if they have the same
+// time range and step.
+func mergeByName(metrics msgpack.MultiGraphiteFetchResponse) msgpack.MultiGraphiteFetchResponse {
+	res := metrics[:0]
+	seen := make(map[string]int, len(metrics))
+	for _, m := range metrics {
+		i, ok := seen[m.Name]
+		if !ok {
+			seen[m.Name] = len(res)
+			res = append(res, m)
+			continue
+		}
+
+		first := &res[i]
+		if first.Start != m.Start || first.Step != m.Step || len(first.Values) != len(m.Values) {
+			continue
+		}
+		for j, v := range first.Values {
+			if _, ok := v.(float64); !ok {
+				first.Values[j] = m.Values[j]
+			}
+		}
+	}
+	return res
+}
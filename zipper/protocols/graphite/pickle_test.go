@@ -0,0 +1,104 @@
+package v2
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/go-graphite/carbonapi/zipper/helper"
+	"github.com/go-graphite/carbonapi/zipper/protocols/graphite/msgpack"
+	pickle "github.com/lomik/og-rek"
+)
+
+func marshalPickle(t *testing.T, v interface{}) []byte {
+	var buf bytes.Buffer
+	if err := pickle.NewEncoder(&buf).Encode(v); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func pickleSeries(name string, values ...interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"name":           name,
+		"pathExpression": "foo.*",
+		"start":          60,
+		"end":            60 + 60*len(values),
+		"step":           60,
+		"values":         values,
+	}
+}
+
+func TestUnmarshalPickleFetch(t *testing.T) {
+	b := marshalPickle(t, []interface{}{
+		pickleSeries("foo.a", 1.5, pickle.None{}, 3),
+		pickleSeries("foo.b", pickle.None{}, 2.0, pickle.None{}),
+		pickleSeries("foo.a", 7.0, 2.5, 8.0),
+		pickleSeries("foo.b", 1.0, 1.0),
+	})
+
+	metrics, err := unmarshalPickleFetch(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(metrics) != 4 {
+		t.Fatalf("expected all 4 series to be decoded, got %d", len(metrics))
+	}
+
+	expected := msgpack.MultiGraphiteFetchResponse{
+		{Name: "foo.a", PathExpression: "foo.*", Start: 60, End: 240, Step: 60, Values: []interface{}{1.5, 2.5, 3.0}},
+		// second foo.b has different length and can't be merged
+		{Name: "foo.b", PathExpression: "foo.*", Start: 60, End: 240, Step: 60, Values: []interface{}{nil, 2.0, nil}},
+	}
+	if merged := mergeByName(metrics); !reflect.DeepEqual(merged, expected) {
+		t.Fatalf("unexpected merge result:\n%+v\nexpected:\n%+v", merged, expected)
+	}
+
+	if _, err := unmarshalPickleFetch(marshalPickle(t, []interface{}{map[string]interface{}{"name": "foo.a"}})); err == nil {
+		t.Fatal("expected error for series without time range")
+	}
+}
+
+func TestUnmarshalPickleFind(t *testing.T) {
+	b := marshalPickle(t, []interface{}{
+		map[string]interface{}{"metric_path": "foo.a", "isLeaf": true},
+		map[string]interface{}{"metric_path": "foo.b", "isLeaf": false},
+	})
+
+	globs, err := unmarshalPickleFind(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := msgpack.MultiGraphiteGlobResponse{
+		{Path: "foo.a", IsLeaf: true},
+		{Path: "foo.b", IsLeaf: false},
+	}
+	if !reflect.DeepEqual(globs, expected) {
+		t.Fatalf("unexpected find result: %+v", globs)
+	}
+}
+
+func TestUnmarshalPickleFetchStrict(t *testing.T) {
+	series := pickleSeries("foo.a", 1.0, 2.0)
+	series["unexpected"] = 1
+	b := marshalPickle(t, []interface{}{series})
+
+	if _, err := unmarshalPickleFetch(b); err != nil {
+		t.Fatalf("unknown keys should be tolerated by default: %v", err)
+	}
+
+	helper.SetStrictDecode(true)
+	defer helper.SetStrictDecode(false)
+	if _, err := unmarshalPickleFetch(b); err == nil {
+		t.Fatal("unknown key should be rejected in strict mode")
+	}
+
+	metrics, err := unmarshalPickleFetch(marshalPickle(t, []interface{}{pickleSeries("foo.a", 1.0, 2.0)}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	metrics[0].Values = metrics[0].Values[:1]
+	if err := checkSeries(metrics); err == nil {
+		t.Fatal("series with wrong amount of points should be rejected in strict mode")
+	}
+}
@@ -6,12 +6,14 @@ import (
 
 	"github.com/go-graphite/carbonapi/limiter"
 	"github.com/go-graphite/carbonapi/zipper/errors"
+	"github.com/go-graphite/carbonapi/zipper/helper"
 	"github.com/go-graphite/carbonapi/zipper/metadata"
 	"github.com/go-graphite/carbonapi/zipper/types"
 	protov3grpc "github.com/go-graphite/protocol/carbonapi_v3_grpc"
 	protov3 "github.com/go-graphite/protocol/carbonapi_v3_pb"
 	"google.golang.org/grpc"
 	_ "google.golang.org/grpc/balancer/roundrobin"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/resolver"
 	"google.golang.org/grpc/resolver/manual"
 
@@ -42,6 +44,9 @@ type ClientGRPCGroup struct {
 
 	client protov3grpc.CarbonV1Client
 	logger *zap.Logger
+
+	// streamUnsupported is set once backend answered that it doesn't implement streaming fetch
+	streamUnsupported int32
 }
 
 func (c *ClientGRPCGroup) Children() []types.ServerClient {
@@ -52,6 +57,17 @@ func NewClientGRPCGroupWithLimiter(logger *zap.Logger, config types.BackendV2, l
 	return NewClientGRPCGroup(logger, config)
 }
 
+// authCredentials is Authorization header that is sent with every call
+type authCredentials string
+
+func (c authCredentials) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	return map[string]string{"authorization": string(c)}, nil
+}
+
+func (c authCredentials) RequireTransportSecurity() bool {
+	return false
+}
+
 func NewClientGRPCGroup(logger *zap.Logger, config types.BackendV2) (types.ServerClient, *errors.Errors) {
 	logger = logger.With(zap.String("type", "grpcGroup"), zap.String("name", config.GroupName))
 	// TODO: Implement normal resolver
@@ -64,7 +80,8 @@ func NewClientGRPCGroup(logger *zap.Logger, config types.BackendV2) (types.Serve
 		resolvedAddrs = append(resolvedAddrs, resolver.Address{Addr: addr})
 	}
 
-	r.NewAddress(resolvedAddrs)
+	// resolver is built by Dial, addresses can't be set before that
+	r.InitialAddrs(resolvedAddrs)
 
 	opts := []grpc.DialOption{
 		grpc.WithUserAgent("carbonzipper"),
@@ -72,7 +89,24 @@ func NewClientGRPCGroup(logger *zap.Logger, config types.BackendV2) (types.Serve
 		grpc.WithDecompressor(grpc.NewGZIPDecompressor()),
 		grpc.WithBalancerName("round_robin"), // TODO: Make that configurable
 		grpc.WithMaxMsgSize(math.MaxUint32),  // TODO: make that configurable
-		grpc.WithInsecure(),                  // TODO: Make configurable
+	}
+	tlsConfig, err := helper.TLSConfig(config.TLS)
+	if err != nil {
+		cleanup()
+		return nil, errors.Fatalf("failed to create tls config: %v", err)
+	}
+	if tlsConfig != nil {
+		opts = append(opts, grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)))
+	} else {
+		opts = append(opts, grpc.WithInsecure())
+	}
+	header, err := helper.AuthorizationHeader(config.Auth)
+	if err != nil {
+		cleanup()
+		return nil, errors.Fatalf("failed to read credentials: %v", err)
+	}
+	if header != "" {
+		opts = append(opts, grpc.WithPerRPCCredentials(authCredentials(header)))
 	}
 
 	conn, err := grpc.Dial(r.Scheme()+":///server", opts...)
@@ -116,11 +150,12 @@ func (c *ClientGRPCGroup) Fetch(ctx context.Context, request *protov3.MultiFetch
 	ctx, cancel := context.WithTimeout(ctx, c.timeout.Render)
 	defer cancel()
 
-	res, err := c.client.FetchMetrics(ctx, request)
+	res, err := c.fetch(ctx, request)
 	if err != nil {
 		stats.RenderErrors++
 		stats.FailedServers = stats.Servers
 		stats.Servers = []string{}
+		return nil, stats, errors.FromErrNonFatal(err)
 	}
 	stats.MemoryUsage = int64(res.Size())
 
@@ -139,6 +174,7 @@ func (c *ClientGRPCGroup) Find(ctx context.Context, request *protov3.MultiGlobRe
 		stats.RenderErrors++
 		stats.FailedServers = stats.Servers
 		stats.Servers = []string{}
+		return nil, stats, errors.FromErrNonFatal(err)
 	}
 	stats.MemoryUsage = int64(res.Size())
 
@@ -156,6 +192,7 @@ func (c *ClientGRPCGroup) Info(ctx context.Context, request *protov3.MultiMetric
 		stats.RenderErrors++
 		stats.FailedServers = stats.Servers
 		stats.Servers = []string{}
+		return nil, stats, errors.FromErrNonFatal(err)
 	}
 	stats.MemoryUsage = int64(res.Size())
 
@@ -180,6 +217,7 @@ func (c *ClientGRPCGroup) List(ctx context.Context) (*protov3.ListMetricsRespons
 		stats.RenderErrors++
 		stats.FailedServers = stats.Servers
 		stats.Servers = []string{}
+		return nil, stats, errors.FromErrNonFatal(err)
 	}
 	stats.MemoryUsage = int64(res.Size())
 
@@ -197,6 +235,7 @@ func (c *ClientGRPCGroup) Stats(ctx context.Context) (*protov3.MetricDetailsResp
 		stats.RenderErrors++
 		stats.FailedServers = stats.Servers
 		stats.Servers = []string{}
+		return nil, stats, errors.FromErrNonFatal(err)
 	}
 	stats.MemoryUsage = int64(res.Size())
 
@@ -0,0 +1,64 @@
+package grpc
+
+import (
+	"context"
+	"io"
+	"sync/atomic"
+
+	protov3 "github.com/go-graphite/protocol/carbonapi_v3_pb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// renderMethod is server-streaming fetch of go-carbon's gRPC API. It takes the same request as FetchMetrics, but
+// every series is sent as a separate message, so backend doesn't have to build the whole response in memory.
+const renderMethod = "/carbonapi_v3_grpc.CarbonV1/Render"
+
+var renderStreamDesc = grpc.StreamDesc{
+	StreamName:    "Render",
+	ServerStreams: true,
+}
+
+// fetch uses streaming fetch unless backend doesn't implement it, in that case FetchMetrics is used from now on
+func (c *ClientGRPCGroup) fetch(ctx context.Context, request *protov3.MultiFetchRequest) (*protov3.MultiFetchResponse, error) {
+	if atomic.LoadInt32(&c.streamUnsupported) == 0 {
+		res, err := c.fetchStream(ctx, request)
+		if status.Code(err) != codes.Unimplemented {
+			return res, err
+		}
+		c.logger.Info("backend doesn't support streaming fetch, falling back to FetchMetrics")
+		atomic.StoreInt32(&c.streamUnsupported, 1)
+	}
+	return c.client.FetchMetrics(ctx, request)
+}
+
+func (c *ClientGRPCGroup) fetchStream(ctx context.Context, request *protov3.MultiFetchRequest) (*protov3.MultiFetchResponse, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	// stream is released on return even if it wasn't read till the end
+	defer cancel()
+
+	stream, err := c.conn.NewStream(ctx, &renderStreamDesc, renderMethod)
+	if err != nil {
+		return nil, err
+	}
+	if err := stream.SendMsg(request); err != nil {
+		return nil, err
+	}
+	if err := stream.CloseSend(); err != nil {
+		return nil, err
+	}
+
+	res := &protov3.MultiFetchResponse{}
+	for {
+		var m protov3.FetchResponse
+		err := stream.RecvMsg(&m)
+		if err == io.EOF {
+			return res, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		res.Metrics = append(res.Metrics, m)
+	}
+}
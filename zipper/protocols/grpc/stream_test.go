@@ -0,0 +1,111 @@
+package grpc
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-graphite/carbonapi/zipper/types"
+	protov3 "github.com/go-graphite/protocol/carbonapi_v3_pb"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+)
+
+// testBackend answers fetch requests with one series per requested metric
+type testBackend struct {
+	streamed, unary int64
+}
+
+func (b *testBackend) response(request *protov3.MultiFetchRequest) []protov3.FetchResponse {
+	res := make([]protov3.FetchResponse, 0, len(request.Metrics))
+	for _, m := range request.Metrics {
+		res = append(res, protov3.FetchResponse{Name: m.Name, StartTime: m.StartTime, StopTime: m.StopTime, StepTime: 60, Values: []float64{1}})
+	}
+	return res
+}
+
+func startTestBackend(t *testing.T, b *testBackend, streaming bool) (string, func()) {
+	desc := grpc.ServiceDesc{
+		ServiceName: "carbonapi_v3_grpc.CarbonV1",
+		HandlerType: (*interface{})(nil),
+		Methods: []grpc.MethodDesc{{
+			MethodName: "FetchMetrics",
+			Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+				var request protov3.MultiFetchRequest
+				if err := dec(&request); err != nil {
+					return nil, err
+				}
+				atomic.AddInt64(&b.unary, 1)
+				return &protov3.MultiFetchResponse{Metrics: b.response(&request)}, nil
+			},
+		}},
+	}
+	if streaming {
+		desc.Streams = []grpc.StreamDesc{{
+			StreamName:    "Render",
+			ServerStreams: true,
+			Handler: func(srv interface{}, stream grpc.ServerStream) error {
+				var request protov3.MultiFetchRequest
+				if err := stream.RecvMsg(&request); err != nil {
+					return err
+				}
+				atomic.AddInt64(&b.streamed, 1)
+				for _, m := range b.response(&request) {
+					m := m
+					if err := stream.SendMsg(&m); err != nil {
+						return err
+					}
+				}
+				return nil
+			},
+		}}
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := grpc.NewServer(grpc.RPCCompressor(grpc.NewGZIPCompressor()), grpc.RPCDecompressor(grpc.NewGZIPDecompressor()))
+	srv.RegisterService(&desc, b)
+	go srv.Serve(l)
+	return l.Addr().String(), srv.Stop
+}
+
+func TestFetchStream(t *testing.T) {
+	request := &protov3.MultiFetchRequest{
+		Metrics: []protov3.FetchRequest{
+			{Name: "foo", StartTime: 60, StopTime: 120},
+			{Name: "bar", StartTime: 60, StopTime: 120},
+		},
+	}
+
+	for _, streaming := range []bool{true, false} {
+		b := &testBackend{}
+		addr, stop := startTestBackend(t, b, streaming)
+		defer stop()
+		c, e := NewClientGRPCGroup(zap.NewNop(), types.BackendV2{
+			GroupName: "test",
+			Servers:   []string{addr},
+			Timeouts:  &types.Timeouts{Find: time.Second, Render: time.Second, Connect: time.Second},
+		})
+		if e != nil {
+			t.Fatalf("failed to create client: %v", e)
+		}
+
+		for i := 0; i < 2; i++ {
+			res, _, err := c.Fetch(context.Background(), request)
+			if err != nil || len(res.Metrics) != 2 || res.Metrics[0].Name != "foo" || res.Metrics[1].Name != "bar" {
+				t.Fatalf("streaming=%v: unexpected response %+v, error %+v", streaming, res, err)
+			}
+		}
+
+		if streaming && (b.streamed != 2 || b.unary != 0) {
+			t.Fatalf("expected streaming fetch, got %v streamed and %v unary requests", b.streamed, b.unary)
+		}
+		if !streaming && b.unary != 2 {
+			t.Fatalf("expected fallback to FetchMetrics, got %v unary requests", b.unary)
+		}
+	}
+}
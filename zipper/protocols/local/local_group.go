@@ -0,0 +1,343 @@
+package local
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/go-graphite/carbonapi/limiter"
+	"github.com/go-graphite/carbonapi/zipper/errors"
+	"github.com/go-graphite/carbonapi/zipper/metadata"
+	"github.com/go-graphite/carbonapi/zipper/types"
+	protov3 "github.com/go-graphite/protocol/carbonapi_v3_pb"
+
+	"go.uber.org/zap"
+)
+
+const whisperExt = ".wsp"
+
+func init() {
+	aliases := []string{"local"}
+	metadata.Metadata.Lock()
+	for _, name := range aliases {
+		metadata.Metadata.SupportedProtocols[name] = struct{}{}
+		metadata.Metadata.ProtocolInits[name] = New
+		metadata.Metadata.ProtocolInitsWithLimiter[name] = NewWithLimiter
+	}
+	defer metadata.Metadata.Unlock()
+}
+
+// LocalGroup reads whisper files from the data directory of carbon, the same way carbonserver does it. It's meant
+// for single node installations, where zipper runs on the host that stores the metrics.
+type LocalGroup struct {
+	groupName string
+	dataDir   string
+
+	limiter              *limiter.ServerLimiter
+	logger               *zap.Logger
+	maxMetricsPerRequest int
+
+	now func() time.Time
+}
+
+func (c *LocalGroup) Children() []types.ServerClient {
+	return []types.ServerClient{c}
+}
+
+func NewWithLimiter(logger *zap.Logger, config types.BackendV2, limiter *limiter.ServerLimiter) (types.ServerClient, *errors.Errors) {
+	logger = logger.With(zap.String("type", "localGroup"), zap.String("name", config.GroupName))
+
+	if config.DataDir == "" {
+		return nil, errors.Fatal("dataDir is not set")
+	}
+	dataDir, err := filepath.Abs(config.DataDir)
+	if err != nil {
+		return nil, errors.Fatalf("invalid dataDir: %v", err)
+	}
+	if fi, err := os.Stat(dataDir); err != nil || !fi.IsDir() {
+		return nil, errors.Fatalf("dataDir '%v' is not a directory", config.DataDir)
+	}
+
+	c := &LocalGroup{
+		groupName:            config.GroupName,
+		dataDir:              dataDir,
+		maxMetricsPerRequest: config.MaxBatchSize,
+
+		limiter: limiter,
+		logger:  logger,
+
+		now: time.Now,
+	}
+	return c, nil
+}
+
+func New(logger *zap.Logger, config types.BackendV2) (types.ServerClient, *errors.Errors) {
+	if config.ConcurrencyLimit == nil {
+		return nil, errors.Fatal("concurency limit is not set")
+	}
+	limiter := limiter.NewServerLimiter([]string{config.GroupName}, *config.ConcurrencyLimit)
+
+	return NewWithLimiter(logger, config, limiter)
+}
+
+func (c LocalGroup) MaxMetricsPerRequest() int {
+	return c.maxMetricsPerRequest
+}
+
+func (c LocalGroup) Name() string {
+	return c.groupName
+}
+
+func (c LocalGroup) Backends() []string {
+	return []string{c.dataDir}
+}
+
+// expandBraces expands {a,b} lists of the glob, as filepath.Match doesn't support them
+func expandBraces(glob string) []string {
+	start := strings.IndexByte(glob, '{')
+	if start < 0 {
+		return []string{glob}
+	}
+	end := strings.IndexByte(glob[start:], '}')
+	if end < 0 {
+		return []string{glob}
+	}
+	end += start
+
+	var res []string
+	for _, alt := range strings.Split(glob[start+1:end], ",") {
+		res = append(res, expandBraces(glob[:start]+alt+glob[end+1:])...)
+	}
+	return res
+}
+
+// filePattern converts glob to the pattern of paths of the data directory. Dots are replaced, so metric names can't
+// escape the directory.
+func (c *LocalGroup) filePattern(glob string) string {
+	return filepath.Join(c.dataDir, strings.Replace(glob, ".", string(filepath.Separator), -1))
+}
+
+// metricPath converts path of the file or the directory back to metric name
+func (c *LocalGroup) metricPath(file string) string {
+	rel := strings.TrimPrefix(file, c.dataDir+string(filepath.Separator))
+	return strings.Replace(strings.TrimSuffix(rel, whisperExt), string(filepath.Separator), ".", -1)
+}
+
+// glob returns metrics and directories that match the graphite glob
+func (c *LocalGroup) glob(glob string) ([]protov3.GlobMatch, error) {
+	var matches []protov3.GlobMatch
+	seen := make(map[protov3.GlobMatch]struct{})
+	add := func(m protov3.GlobMatch) {
+		if _, ok := seen[m]; !ok {
+			seen[m] = struct{}{}
+			matches = append(matches, m)
+		}
+	}
+	for _, g := range expandBraces(glob) {
+		pattern := c.filePattern(g)
+		dirs, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, err
+		}
+		for _, d := range dirs {
+			if fi, err := os.Stat(d); err == nil && fi.IsDir() {
+				add(protov3.GlobMatch{Path: c.metricPath(d)})
+			}
+		}
+		files, err := filepath.Glob(pattern + whisperExt)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range files {
+			add(protov3.GlobMatch{Path: c.metricPath(f), IsLeaf: true})
+		}
+	}
+	return matches, nil
+}
+
+func (c *LocalGroup) fetch(name string, m protov3.FetchRequest, now int64) (*protov3.FetchResponse, error) {
+	w, err := openWhisper(c.filePattern(name) + whisperExt)
+	if err != nil {
+		return nil, err
+	}
+	defer w.Close()
+
+	start, stop, step, values, err := w.fetch(m.StartTime, m.StopTime, now)
+	if err != nil || values == nil {
+		return nil, err
+	}
+	return &protov3.FetchResponse{
+		Name:              name,
+		PathExpression:    m.PathExpression,
+		ConsolidationFunc: w.consolidationFunc(),
+		XFilesFactor:      w.xFilesFactor,
+		StartTime:         start,
+		StopTime:          stop,
+		StepTime:          step,
+		Values:            values,
+		RequestStartTime:  m.StartTime,
+		RequestStopTime:   m.StopTime,
+	}, nil
+}
+
+func (c *LocalGroup) Fetch(ctx context.Context, request *protov3.MultiFetchRequest) (*protov3.MultiFetchResponse, *types.Stats, *errors.Errors) {
+	stats := &types.Stats{}
+	if err := c.limiter.Enter(ctx, c.groupName); err != nil {
+		return nil, stats, errors.FromErr(types.ErrTimeoutExceeded)
+	}
+	defer c.limiter.Leave(ctx, c.groupName)
+
+	now := c.now().Unix()
+	var r protov3.MultiFetchResponse
+	var e errors.Errors
+	for _, m := range request.Metrics {
+		matches, err := c.glob(m.Name)
+		if err != nil {
+			e.Add(err)
+			continue
+		}
+		for _, match := range matches {
+			if !match.IsLeaf {
+				continue
+			}
+			res, err := c.fetch(match.Path, m, now)
+			if err != nil {
+				c.logger.Warn("failed to read whisper file",
+					zap.String("metric", match.Path),
+					zap.Error(err),
+				)
+				e.Add(err)
+				continue
+			}
+			if res != nil {
+				r.Metrics = append(r.Metrics, *res)
+			}
+		}
+	}
+	stats.Servers = append(stats.Servers, c.dataDir)
+
+	if len(r.Metrics) == 0 {
+		if len(e.Errors) != 0 {
+			return nil, stats, &e
+		}
+		stats.NotFound++
+		return nil, stats, errors.FromErrNonFatal(types.ErrNotFound)
+	}
+	return &r, stats, nil
+}
+
+func (c *LocalGroup) Find(ctx context.Context, request *protov3.MultiGlobRequest) (*protov3.MultiGlobResponse, *types.Stats, *errors.Errors) {
+	logger := c.logger.With(zap.String("type", "find"), zap.Strings("request", request.Metrics))
+	stats := &types.Stats{}
+
+	var r protov3.MultiGlobResponse
+	r.Metrics = make([]protov3.GlobResponse, 0)
+	var e errors.Errors
+	for _, query := range request.Metrics {
+		matches, err := c.glob(query)
+		if err != nil {
+			e.Add(err)
+			continue
+		}
+		if len(matches) == 0 {
+			stats.NotFound++
+			continue
+		}
+		r.Metrics = append(r.Metrics, protov3.GlobResponse{
+			Name:    query,
+			Matches: matches,
+		})
+	}
+	stats.Servers = append(stats.Servers, c.dataDir)
+
+	if len(e.Errors) != 0 {
+		logger.Error("errors occurred while getting results",
+			zap.Any("errors", e.Errors),
+		)
+	}
+
+	if len(r.Metrics) == 0 {
+		if len(e.Errors) == 0 {
+			return nil, stats, errors.FromErrNonFatal(types.ErrNotFound)
+		}
+		return nil, stats, errors.FromErr(types.ErrNoResponseFetched)
+	}
+	return &r, stats, nil
+}
+
+func (c *LocalGroup) Info(ctx context.Context, request *protov3.MultiMetricsInfoRequest) (*protov3.ZipperInfoResponse, *types.Stats, *errors.Errors) {
+	stats := &types.Stats{}
+
+	var infos protov3.MultiMetricsInfoResponse
+	for _, name := range request.Names {
+		w, err := openWhisper(c.filePattern(name) + whisperExt)
+		if err != nil {
+			continue
+		}
+		info := protov3.MetricsInfoResponse{
+			Name:              name,
+			ConsolidationFunc: w.consolidationFunc(),
+			XFilesFactor:      w.xFilesFactor,
+			MaxRetention:      w.maxRetention,
+		}
+		for _, a := range w.archives {
+			info.Retentions = append(info.Retentions, protov3.Retention{
+				SecondsPerPoint: a.secondsPerPoint,
+				NumberOfPoints:  a.points,
+			})
+		}
+		w.Close()
+		infos.Metrics = append(infos.Metrics, info)
+	}
+
+	if len(infos.Metrics) == 0 {
+		stats.NotFound++
+		return nil, stats, errors.FromErrNonFatal(types.ErrNotFound)
+	}
+	r := &protov3.ZipperInfoResponse{
+		Info: map[string]protov3.MultiMetricsInfoResponse{
+			c.Name(): infos,
+		},
+	}
+	return r, stats, nil
+}
+
+func (c *LocalGroup) List(ctx context.Context) (*protov3.ListMetricsResponse, *types.Stats, *errors.Errors) {
+	stats := &types.Stats{}
+
+	var r protov3.ListMetricsResponse
+	err := filepath.Walk(c.dataDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() && strings.HasSuffix(path, whisperExt) {
+			r.Metrics = append(r.Metrics, c.metricPath(path))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, stats, errors.FromErr(err)
+	}
+	return &r, stats, nil
+}
+
+func (c *LocalGroup) Stats(ctx context.Context) (*protov3.MetricDetailsResponse, *types.Stats, *errors.Errors) {
+	return nil, nil, errors.FromErrNonFatal(types.ErrNotImplementedYet)
+}
+
+func (c *LocalGroup) ProbeTLDs(ctx context.Context) ([]string, *errors.Errors) {
+	res, _, err := c.Find(ctx, &protov3.MultiGlobRequest{Metrics: []string{"*"}})
+	if err != nil {
+		return nil, err
+	}
+
+	var tlds []string
+	for _, m := range res.Metrics {
+		for _, v := range m.Matches {
+			tlds = append(tlds, v.Path)
+		}
+	}
+	return tlds, nil
+}
@@ -0,0 +1,160 @@
+package local
+
+import (
+	"context"
+	"encoding/binary"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-graphite/carbonapi/zipper/types"
+	protov3 "github.com/go-graphite/protocol/carbonapi_v3_pb"
+
+	"go.uber.org/zap"
+)
+
+// writeWhisper creates whisper file with archives of 10 points of 10s and 60s, points are written to the first one
+func writeWhisper(t *testing.T, path string, base int64, points map[int64]float64) {
+	b := make([]byte, whisperMetadataSize+2*whisperArchiveInfoSize+2*10*whisperPointSize)
+	binary.BigEndian.PutUint32(b[0:], 1)
+	binary.BigEndian.PutUint32(b[4:], 600)
+	binary.BigEndian.PutUint32(b[8:], math.Float32bits(0.5))
+	binary.BigEndian.PutUint32(b[12:], 2)
+	offset := uint32(whisperMetadataSize + 2*whisperArchiveInfoSize)
+	for i, step := range []uint32{10, 60} {
+		info := b[whisperMetadataSize+i*whisperArchiveInfoSize:]
+		binary.BigEndian.PutUint32(info[0:], offset+uint32(i)*10*whisperPointSize)
+		binary.BigEndian.PutUint32(info[4:], step)
+		binary.BigEndian.PutUint32(info[8:], 10)
+	}
+	for ts, v := range points {
+		p := b[int64(offset)+((ts-base)/10%10+10)%10*whisperPointSize:]
+		binary.BigEndian.PutUint32(p[0:], uint32(ts))
+		binary.BigEndian.PutUint64(p[4:], math.Float64bits(v))
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, b, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func newTestGroup(t *testing.T) (*LocalGroup, func()) {
+	dir, err := ioutil.TempDir("", "local")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 870 is left from the previous round of the ring
+	writeWhisper(t, filepath.Join(dir, "foo", "bar.wsp"), 900, map[int64]float64{900: 1, 950: 5, 960: 6, 870: 7})
+	writeWhisper(t, filepath.Join(dir, "foo", "baz", "qux.wsp"), 900, nil)
+
+	// no concurrency limit
+	limit := 0
+	config := types.BackendV2{
+		GroupName:        "local",
+		Protocol:         "local",
+		DataDir:          dir,
+		ConcurrencyLimit: &limit,
+	}
+	c, e := New(zap.NewNop(), config)
+	if e != nil {
+		os.RemoveAll(dir)
+		t.Fatalf("failed to create group: %v", e)
+	}
+	g := c.(*LocalGroup)
+	g.now = func() time.Time { return time.Unix(1000, 0) }
+	return g, func() { os.RemoveAll(dir) }
+}
+
+func TestFetch(t *testing.T) {
+	c, stop := newTestGroup(t)
+	defer stop()
+
+	res, _, err := c.Fetch(context.Background(), &protov3.MultiFetchRequest{Metrics: []protov3.FetchRequest{
+		{Name: "foo.b*", PathExpression: "foo.b*", StartTime: 945, StopTime: 975},
+		{Name: "foo.baz.qux", PathExpression: "foo.baz.qux", StartTime: 500, StopTime: 975},
+	}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(res.Metrics) != 2 {
+		t.Fatalf("expected 2 series, got %+v", res.Metrics)
+	}
+
+	m := res.Metrics[0]
+	if m.Name != "foo.bar" || m.PathExpression != "foo.b*" || m.StartTime != 950 || m.StopTime != 980 || m.StepTime != 10 ||
+		m.ConsolidationFunc != "average" || m.XFilesFactor != 0.5 {
+		t.Fatalf("unexpected series %+v", m)
+	}
+	if len(m.Values) != 3 || m.Values[0] != 5 || m.Values[1] != 6 || !math.IsNaN(m.Values[2]) {
+		t.Fatalf("unexpected values %v", m.Values)
+	}
+
+	// the range is only covered by the second archive, which is empty
+	m = res.Metrics[1]
+	if m.Name != "foo.baz.qux" || m.StartTime != 540 || m.StepTime != 60 || len(m.Values) != 8 || !math.IsNaN(m.Values[0]) {
+		t.Fatalf("unexpected series %+v", m)
+	}
+
+	_, _, err = c.Fetch(context.Background(), &protov3.MultiFetchRequest{Metrics: []protov3.FetchRequest{
+		{Name: "foo.unknown", PathExpression: "foo.unknown", StartTime: 945, StopTime: 975},
+	}})
+	if !types.IsNotFound(err) {
+		t.Fatalf("unknown metric should not be found, got %v", err)
+	}
+}
+
+func TestFind(t *testing.T) {
+	c, stop := newTestGroup(t)
+	defer stop()
+
+	res, _, err := c.Find(context.Background(), &protov3.MultiGlobRequest{Metrics: []string{"foo.*", "foo.{bar,none}", "*"}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(res.Metrics) != 3 {
+		t.Fatalf("unexpected response %+v", res.Metrics)
+	}
+	if m := res.Metrics[0].Matches; len(m) != 2 || m[0] != (protov3.GlobMatch{Path: "foo.baz"}) || m[1] != (protov3.GlobMatch{Path: "foo.bar", IsLeaf: true}) {
+		t.Fatalf("unexpected matches %+v", m)
+	}
+	if m := res.Metrics[1].Matches; len(m) != 1 || m[0] != (protov3.GlobMatch{Path: "foo.bar", IsLeaf: true}) {
+		t.Fatalf("unexpected matches %+v", m)
+	}
+	if m := res.Metrics[2].Matches; len(m) != 1 || m[0] != (protov3.GlobMatch{Path: "foo"}) {
+		t.Fatalf("unexpected matches %+v", m)
+	}
+}
+
+func TestInfo(t *testing.T) {
+	c, stop := newTestGroup(t)
+	defer stop()
+
+	res, _, err := c.Info(context.Background(), &protov3.MultiMetricsInfoRequest{Names: []string{"foo.bar", "foo.unknown"}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	infos := res.Info["local"].Metrics
+	if len(infos) != 1 || infos[0].Name != "foo.bar" || infos[0].MaxRetention != 600 || len(infos[0].Retentions) != 2 ||
+		infos[0].Retentions[1] != (protov3.Retention{SecondsPerPoint: 60, NumberOfPoints: 10}) {
+		t.Fatalf("unexpected info %+v", infos)
+	}
+}
+
+func TestExpandBraces(t *testing.T) {
+	res := expandBraces("a.{b,c}.{d,e}")
+	expected := []string{"a.b.d", "a.b.e", "a.c.d", "a.c.e"}
+	if len(res) != len(expected) {
+		t.Fatalf("unexpected expansion %v", res)
+	}
+	for i := range expected {
+		if res[i] != expected[i] {
+			t.Fatalf("unexpected expansion %v", res)
+		}
+	}
+}
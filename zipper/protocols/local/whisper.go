@@ -0,0 +1,159 @@
+package local
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+)
+
+const (
+	whisperMetadataSize    = 16
+	whisperArchiveInfoSize = 12
+	whisperPointSize       = 12
+)
+
+var errCorruptedWhisper = errors.New("corrupted whisper file")
+
+// whisperAggregations are consolidation functions of whisper aggregation methods, 0 is not used by whisper
+var whisperAggregations = []string{"average", "average", "sum", "last", "max", "min", "average", "max", "min"}
+
+type whisperArchive struct {
+	offset          int64
+	secondsPerPoint int64
+	points          int64
+}
+
+func (a whisperArchive) retention() int64 {
+	return a.secondsPerPoint * a.points
+}
+
+func (a whisperArchive) size() int64 {
+	return a.points * whisperPointSize
+}
+
+// whisperFile reads whisper database, it never writes to it, so files can be read while carbon updates them
+type whisperFile struct {
+	f            *os.File
+	aggregation  uint32
+	maxRetention int64
+	xFilesFactor float32
+	archives     []whisperArchive
+}
+
+func openWhisper(path string) (*whisperFile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	w, err := readWhisperHeader(f)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return w, nil
+}
+
+func readWhisperHeader(f *os.File) (*whisperFile, error) {
+	b := make([]byte, whisperMetadataSize)
+	if _, err := f.ReadAt(b, 0); err != nil {
+		return nil, errCorruptedWhisper
+	}
+	w := &whisperFile{
+		f:            f,
+		aggregation:  binary.BigEndian.Uint32(b[0:4]),
+		maxRetention: int64(binary.BigEndian.Uint32(b[4:8])),
+		xFilesFactor: math.Float32frombits(binary.BigEndian.Uint32(b[8:12])),
+	}
+	count := binary.BigEndian.Uint32(b[12:16])
+	if count == 0 || count > 64 {
+		return nil, errCorruptedWhisper
+	}
+
+	b = make([]byte, int(count)*whisperArchiveInfoSize)
+	if _, err := f.ReadAt(b, whisperMetadataSize); err != nil {
+		return nil, errCorruptedWhisper
+	}
+	for i := 0; i < int(count); i++ {
+		info := b[i*whisperArchiveInfoSize:]
+		a := whisperArchive{
+			offset:          int64(binary.BigEndian.Uint32(info[0:4])),
+			secondsPerPoint: int64(binary.BigEndian.Uint32(info[4:8])),
+			points:          int64(binary.BigEndian.Uint32(info[8:12])),
+		}
+		if a.secondsPerPoint == 0 || a.points == 0 {
+			return nil, errCorruptedWhisper
+		}
+		w.archives = append(w.archives, a)
+	}
+	return w, nil
+}
+
+func (w *whisperFile) Close() error {
+	return w.f.Close()
+}
+
+func (w *whisperFile) consolidationFunc() string {
+	if int(w.aggregation) < len(whisperAggregations) {
+		return whisperAggregations[w.aggregation]
+	}
+	return "average"
+}
+
+// fetch returns points of the archive with the best precision that still covers from. Timestamps are aligned to
+// the step of the archive the same way as whisper does it, points that were never written are NaN. Series is nil if
+// the range is out of the retention.
+func (w *whisperFile) fetch(from, until, now int64) (start, stop, step int64, values []float64, err error) {
+	oldest := now - w.maxRetention
+	if from > now || until < oldest || from >= until {
+		return 0, 0, 0, nil, nil
+	}
+	if from < oldest {
+		from = oldest
+	}
+	if until > now {
+		until = now
+	}
+
+	archive := w.archives[len(w.archives)-1]
+	for _, a := range w.archives {
+		if a.retention() >= now-from {
+			archive = a
+			break
+		}
+	}
+
+	step = archive.secondsPerPoint
+	start = from - from%step + step
+	stop = until - until%step + step
+	if start == stop {
+		stop += step
+	}
+	values = make([]float64, (stop-start)/step)
+	for i := range values {
+		values[i] = math.NaN()
+	}
+
+	b := make([]byte, archive.size())
+	if _, err := w.f.ReadAt(b, archive.offset); err != nil && err != io.EOF {
+		return 0, 0, 0, nil, err
+	}
+	base := int64(binary.BigEndian.Uint32(b[0:4]))
+	if base == 0 {
+		// nothing was written to the archive yet
+		return start, stop, step, values, nil
+	}
+
+	first := ((start-base)/step%archive.points + archive.points) % archive.points
+	for i := range values {
+		p := b[((first+int64(i))%archive.points)*whisperPointSize:]
+		// points of the ring that weren't updated keep timestamps of older intervals
+		if int64(binary.BigEndian.Uint32(p[0:4])) != start+int64(i)*step {
+			continue
+		}
+		values[i] = math.Float64frombits(binary.BigEndian.Uint64(p[4:12]))
+	}
+	return start, stop, step, values, nil
+}
@@ -0,0 +1,485 @@
+package opentsdb
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-graphite/carbonapi/limiter"
+	"github.com/go-graphite/carbonapi/phases"
+	"github.com/go-graphite/carbonapi/zipper/errors"
+	"github.com/go-graphite/carbonapi/zipper/helper"
+	"github.com/go-graphite/carbonapi/zipper/httpHeaders"
+	"github.com/go-graphite/carbonapi/zipper/metadata"
+	"github.com/go-graphite/carbonapi/zipper/types"
+	protov3 "github.com/go-graphite/protocol/carbonapi_v3_pb"
+
+	"go.uber.org/zap"
+)
+
+const (
+	queryURI          = "/api/query"
+	suggestURI        = "/api/suggest"
+	defaultStep       = time.Minute
+	defaultAggregator = "sum"
+	// suggestLimit is the maximum amount of metric names that are resolved for a single glob
+	suggestLimit = 10000
+)
+
+func init() {
+	aliases := []string{"opentsdb"}
+	metadata.Metadata.Lock()
+	for _, name := range aliases {
+		metadata.Metadata.SupportedProtocols[name] = struct{}{}
+		metadata.Metadata.ProtocolInits[name] = New
+		metadata.Metadata.ProtocolInitsWithLimiter[name] = NewWithLimiter
+	}
+	defer metadata.Metadata.Unlock()
+}
+
+// OpenTSDBGroup reads OpenTSDB through its HTTP API. Metric names of OpenTSDB are graphite paths, series with
+// different tags are merged by aggregator. OpenTSDB can't expand globs, so they are resolved by /api/suggest, which
+// also keeps unknown metrics out of /api/query, as OpenTSDB fails the whole query because of them.
+type OpenTSDBGroup struct {
+	groupName  string
+	servers    []string
+	step       int64
+	aggregator string
+
+	client *http.Client
+
+	limiter              *limiter.ServerLimiter
+	logger               *zap.Logger
+	timeout              types.Timeouts
+	maxTries             int
+	maxMetricsPerRequest int
+
+	httpQuery *helper.HttpQuery
+}
+
+func (c *OpenTSDBGroup) Children() []types.ServerClient {
+	return []types.ServerClient{c}
+}
+
+func NewWithLimiter(logger *zap.Logger, config types.BackendV2, limiter *limiter.ServerLimiter) (types.ServerClient, *errors.Errors) {
+	logger = logger.With(zap.String("type", "opentsdbGroup"), zap.String("name", config.GroupName))
+
+	step := config.Step
+	if step == 0 {
+		step = defaultStep
+	}
+	if step < time.Second {
+		return nil, errors.Fatal("step must be at least 1s")
+	}
+	aggregator := config.Aggregator
+	if aggregator == "" {
+		aggregator = defaultAggregator
+	}
+
+	httpClient, err := helper.NewHTTPClient(config)
+	if err != nil {
+		return nil, errors.Fatalf("failed to create http client: %v", err)
+	}
+
+	httpQuery := helper.NewHttpQuery(logger, config.GroupName, config.Servers, *config.MaxTries, limiter, httpClient, httpHeaders.ContentTypeJSON)
+
+	c := &OpenTSDBGroup{
+		groupName:            config.GroupName,
+		servers:              config.Servers,
+		step:                 int64(step / time.Second),
+		aggregator:           aggregator,
+		timeout:              *config.Timeouts,
+		maxTries:             *config.MaxTries,
+		maxMetricsPerRequest: config.MaxBatchSize,
+
+		client:  httpClient,
+		limiter: limiter,
+		logger:  logger,
+
+		httpQuery: httpQuery,
+	}
+	return c, nil
+}
+
+func New(logger *zap.Logger, config types.BackendV2) (types.ServerClient, *errors.Errors) {
+	if config.ConcurrencyLimit == nil {
+		return nil, errors.Fatal("concurency limit is not set")
+	}
+	if len(config.Servers) == 0 {
+		return nil, errors.Fatal("no servers specified")
+	}
+	limiter := limiter.NewServerLimiter([]string{config.GroupName}, *config.ConcurrencyLimit)
+
+	return NewWithLimiter(logger, config, limiter)
+}
+
+func (c OpenTSDBGroup) MaxMetricsPerRequest() int {
+	return c.maxMetricsPerRequest
+}
+
+func (c OpenTSDBGroup) Name() string {
+	return c.groupName
+}
+
+func (c OpenTSDBGroup) Backends() []string {
+	return c.servers
+}
+
+type subQuery struct {
+	Metric     string `json:"metric"`
+	Aggregator string `json:"aggregator"`
+	Downsample string `json:"downsample,omitempty"`
+}
+
+// queryRequest is the body of /api/query, it implements types.HTTPRequest as it has to be POSTed
+type queryRequest struct {
+	Start   int64      `json:"start"`
+	End     int64      `json:"end"`
+	Queries []subQuery `json:"queries"`
+}
+
+func (r queryRequest) Method() string {
+	return "POST"
+}
+
+func (r queryRequest) Header() http.Header {
+	return http.Header{
+		"Content-Type": []string{httpHeaders.ContentTypeJSON},
+	}
+}
+
+func (r queryRequest) LogInfo() interface{} {
+	return r.Queries
+}
+
+func (r queryRequest) Marshal() ([]byte, error) {
+	return json.Marshal(r)
+}
+
+type queryResult struct {
+	Metric string             `json:"metric"`
+	DPS    map[string]float64 `json:"dps"`
+}
+
+// globPrefix returns the part of the glob before the first special character, it's used as suggest query
+func globPrefix(glob string) string {
+	if i := strings.IndexAny(glob, "*?[{"); i != -1 {
+		return glob[:i]
+	}
+	return glob
+}
+
+// globToRegexp converts graphite glob to regexp that matches whole paths
+func globToRegexp(glob string) (*regexp.Regexp, error) {
+	var b bytes.Buffer
+	b.WriteString("^")
+	inList := false
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch {
+		case c == '*':
+			b.WriteString(`[^.]*`)
+		case c == '?':
+			b.WriteString(`[^.]`)
+		case c == '[':
+			end := strings.IndexByte(glob[i:], ']')
+			if end < 0 {
+				b.WriteString(`\[`)
+				continue
+			}
+			b.WriteString(glob[i : i+end+1])
+			i += end
+		case c == '{' && !inList:
+			inList = true
+			b.WriteString("(")
+		case c == '}' && inList:
+			inList = false
+			b.WriteString(")")
+		case c == ',' && inList:
+			b.WriteString("|")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString("$")
+	return regexp.Compile(b.String())
+}
+
+// suggest returns metric names that start with prefix
+func (c *OpenTSDBGroup) suggest(ctx context.Context, prefix string) ([]string, *helper.ServerResponse, *errors.Errors) {
+	v := url.Values{
+		"type": []string{"metrics"},
+		"q":    []string{prefix},
+		"max":  []string{strconv.Itoa(suggestLimit)},
+	}
+	var names []string
+	res, err := c.httpQuery.DoQueryDecoded(ctx, suggestURI+"?"+v.Encode(), nil, func(response []byte) error {
+		names = nil
+		t0 := time.Now()
+		defer phases.Since(phases.Decode, t0)
+		return json.Unmarshal(response, &names)
+	})
+	return names, res, err
+}
+
+// cachedSuggest is suggest that is done once per prefix for the single request
+func (c *OpenTSDBGroup) cachedSuggest(ctx context.Context, prefix string, cache map[string][]string, stats *types.Stats) ([]string, *errors.Errors) {
+	if suggestions, ok := cache[prefix]; ok {
+		return suggestions, nil
+	}
+	suggestions, res, err := c.suggest(ctx, prefix)
+	if err != nil && !types.IsNotFound(err) {
+		return nil, err
+	}
+	if res != nil {
+		stats.Servers = append(stats.Servers, res.Server)
+	}
+	cache[prefix] = suggestions
+	return suggestions, nil
+}
+
+// resolve returns metric names that match the glob
+func (c *OpenTSDBGroup) resolve(ctx context.Context, glob string, cache map[string][]string, stats *types.Stats) ([]string, *errors.Errors) {
+	re, reErr := globToRegexp(glob)
+	if reErr != nil {
+		return nil, nil
+	}
+	suggestions, err := c.cachedSuggest(ctx, globPrefix(glob), cache, stats)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, name := range suggestions {
+		if re.MatchString(name) {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+// align puts datapoints to the points of step aligned series
+func (c *OpenTSDBGroup) align(dps map[string]float64, from, until int64) (int64, int64, []float64) {
+	start := from - from%c.step
+	stop := until - until%c.step
+	values := make([]float64, (stop-start)/c.step+1)
+	for i := range values {
+		values[i] = math.NaN()
+	}
+	for k, v := range dps {
+		ts, err := strconv.ParseInt(k, 10, 64)
+		if err != nil || ts < start || ts > until {
+			continue
+		}
+		idx := (ts - start) / c.step
+		if idx >= int64(len(values)) {
+			continue
+		}
+		values[idx] = v
+	}
+	return start, stop, values
+}
+
+type queryBatch struct {
+	from  int64
+	until int64
+}
+
+func (c *OpenTSDBGroup) Fetch(ctx context.Context, request *protov3.MultiFetchRequest) (*protov3.MultiFetchResponse, *types.Stats, *errors.Errors) {
+	stats := &types.Stats{}
+
+	// metric names of every time range and the requests they were resolved for
+	batches := make(map[queryBatch]map[string][]protov3.FetchRequest)
+	cache := make(map[string][]string)
+	for _, m := range request.Metrics {
+		names, err := c.resolve(ctx, m.Name, cache, stats)
+		if err != nil {
+			err.HaveFatalErrors = false
+			return nil, stats, err
+		}
+		b := queryBatch{from: m.StartTime, until: m.StopTime}
+		if batches[b] == nil {
+			batches[b] = make(map[string][]protov3.FetchRequest)
+		}
+		for _, name := range names {
+			batches[b][name] = append(batches[b][name], m)
+		}
+	}
+
+	var r protov3.MultiFetchResponse
+	for batch, metrics := range batches {
+		if len(metrics) == 0 {
+			continue
+		}
+		names := make([]string, 0, len(metrics))
+		for name := range metrics {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		q := queryRequest{Start: batch.from, End: batch.until}
+		for _, name := range names {
+			q.Queries = append(q.Queries, subQuery{
+				Metric:     name,
+				Aggregator: c.aggregator,
+				Downsample: strconv.FormatInt(c.step, 10) + "s-avg",
+			})
+		}
+
+		var results []queryResult
+		res, err := c.httpQuery.DoQueryDecoded(ctx, queryURI, q, func(response []byte) error {
+			results = nil
+			t0 := time.Now()
+			defer phases.Since(phases.Decode, t0)
+			return json.Unmarshal(response, &results)
+		})
+		if types.IsNotFound(err) {
+			stats.NotFound++
+			continue
+		}
+		if err != nil {
+			err.HaveFatalErrors = false
+			return nil, stats, err
+		}
+		stats.Servers = append(stats.Servers, res.Server)
+
+		for _, result := range results {
+			for _, m := range metrics[result.Metric] {
+				start, stop, values := c.align(result.DPS, batch.from, batch.until)
+				r.Metrics = append(r.Metrics, protov3.FetchResponse{
+					Name:              result.Metric,
+					PathExpression:    m.PathExpression,
+					ConsolidationFunc: "Average",
+					StartTime:         start,
+					StopTime:          stop,
+					StepTime:          c.step,
+					Values:            values,
+					RequestStartTime:  batch.from,
+					RequestStopTime:   batch.until,
+				})
+			}
+		}
+	}
+
+	if len(r.Metrics) == 0 {
+		stats.NotFound++
+		return nil, stats, errors.FromErrNonFatal(types.ErrNotFound)
+	}
+	return &r, stats, nil
+}
+
+func (c *OpenTSDBGroup) Find(ctx context.Context, request *protov3.MultiGlobRequest) (*protov3.MultiGlobResponse, *types.Stats, *errors.Errors) {
+	logger := c.logger.With(zap.String("type", "find"), zap.Strings("request", request.Metrics))
+	stats := &types.Stats{}
+
+	var r protov3.MultiGlobResponse
+	r.Metrics = make([]protov3.GlobResponse, 0)
+	var e errors.Errors
+	cache := make(map[string][]string)
+	for _, query := range request.Metrics {
+		re, reErr := globToRegexp(query)
+		if reErr != nil {
+			stats.NotFound++
+			continue
+		}
+		suggestions, err := c.cachedSuggest(ctx, globPrefix(query), cache, stats)
+		if err != nil {
+			e.Merge(err)
+			continue
+		}
+
+		// node can be both a metric and a parent of other metrics
+		depth := strings.Count(query, ".") + 1
+		seen := make(map[protov3.GlobMatch]struct{})
+		var matches []protov3.GlobMatch
+		for _, name := range suggestions {
+			nodes := strings.SplitN(name, ".", depth+1)
+			if len(nodes) < depth {
+				continue
+			}
+			m := protov3.GlobMatch{
+				Path:   strings.Join(nodes[:depth], "."),
+				IsLeaf: len(nodes) == depth,
+			}
+			if _, ok := seen[m]; ok || !re.MatchString(m.Path) {
+				continue
+			}
+			seen[m] = struct{}{}
+			matches = append(matches, m)
+		}
+
+		if len(matches) == 0 {
+			stats.NotFound++
+			continue
+		}
+		r.Metrics = append(r.Metrics, protov3.GlobResponse{
+			Name:    query,
+			Matches: matches,
+		})
+	}
+
+	if len(e.Errors) != 0 {
+		logger.Error("errors occurred while getting results",
+			zap.Any("errors", e.Errors),
+		)
+	}
+
+	if len(r.Metrics) == 0 {
+		if len(e.Errors) == 0 {
+			return nil, stats, errors.FromErrNonFatal(types.ErrNotFound)
+		}
+		return nil, stats, errors.FromErr(types.ErrNoResponseFetched)
+	}
+	return &r, stats, nil
+}
+
+// Info is not supported, OpenTSDB keeps raw datapoints without retention schema
+func (c *OpenTSDBGroup) Info(ctx context.Context, request *protov3.MultiMetricsInfoRequest) (*protov3.ZipperInfoResponse, *types.Stats, *errors.Errors) {
+	return nil, nil, errors.FromErrNonFatal(types.ErrNotImplementedYet)
+}
+
+// List is not supported, suggest is limited and can't list all the metrics
+func (c *OpenTSDBGroup) List(ctx context.Context) (*protov3.ListMetricsResponse, *types.Stats, *errors.Errors) {
+	return nil, nil, errors.FromErrNonFatal(types.ErrNotImplementedYet)
+}
+
+func (c *OpenTSDBGroup) Stats(ctx context.Context) (*protov3.MetricDetailsResponse, *types.Stats, *errors.Errors) {
+	return nil, nil, errors.FromErrNonFatal(types.ErrNotImplementedYet)
+}
+
+func (c *OpenTSDBGroup) ProbeTLDs(ctx context.Context) ([]string, *errors.Errors) {
+	logger := c.logger.With(zap.String("function", "prober"))
+	req := &protov3.MultiGlobRequest{
+		Metrics: []string{"*"},
+	}
+
+	logger.Debug("doing request",
+		zap.Strings("request", req.Metrics),
+	)
+
+	res, _, err := c.Find(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	var tlds []string
+	for _, m := range res.Metrics {
+		for _, v := range m.Matches {
+			tlds = append(tlds, v.Path)
+		}
+	}
+
+	logger.Debug("will return data",
+		zap.Strings("tlds", tlds),
+	)
+
+	return tlds, nil
+}
@@ -0,0 +1,139 @@
+package opentsdb
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-graphite/carbonapi/zipper/types"
+	protov3 "github.com/go-graphite/protocol/carbonapi_v3_pb"
+
+	"go.uber.org/zap"
+)
+
+var testMetrics = []string{"sys.cpu.user", "sys.cpu.system", "sys.mem", "sys.mem.free", "app.requests"}
+
+func newTestGroup(t *testing.T) (*OpenTSDBGroup, func()) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case suggestURI:
+			var names []string
+			for _, m := range testMetrics {
+				if strings.HasPrefix(m, r.FormValue("q")) {
+					names = append(names, m)
+				}
+			}
+			_ = json.NewEncoder(w).Encode(names)
+		case queryURI:
+			var q queryRequest
+			if r.Method != "POST" || json.NewDecoder(r.Body).Decode(&q) != nil {
+				http.Error(w, "bad request", http.StatusBadRequest)
+				return
+			}
+			var res []queryResult
+			for _, sq := range q.Queries {
+				if sq.Aggregator != "sum" || sq.Downsample != "10s-avg" {
+					http.Error(w, "unexpected query", http.StatusBadRequest)
+					return
+				}
+				res = append(res, queryResult{
+					Metric: sq.Metric,
+					DPS:    map[string]float64{"100": 1, "120": 3, "200": 5},
+				})
+			}
+			_ = json.NewEncoder(w).Encode(res)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+
+	maxTries := 1
+	// no concurrency limit
+	limit := 0
+	idleConns := 1
+	keepAlive := time.Second
+	config := types.BackendV2{
+		GroupName:           "opentsdb",
+		Protocol:            "opentsdb",
+		Servers:             []string{srv.URL},
+		Step:                10 * time.Second,
+		MaxTries:            &maxTries,
+		ConcurrencyLimit:    &limit,
+		MaxIdleConnsPerHost: &idleConns,
+		KeepAliveInterval:   &keepAlive,
+	}
+	config.FillDefaults()
+
+	c, err := New(zap.NewNop(), config)
+	if err != nil {
+		srv.Close()
+		t.Fatalf("failed to create group: %v", err)
+	}
+	return c.(*OpenTSDBGroup), srv.Close
+}
+
+func TestFetch(t *testing.T) {
+	c, stop := newTestGroup(t)
+	defer stop()
+
+	res, _, err := c.Fetch(context.Background(), &protov3.MultiFetchRequest{Metrics: []protov3.FetchRequest{
+		{Name: "sys.cpu.*", PathExpression: "sys.cpu.*", StartTime: 105, StopTime: 130},
+		{Name: "sys.unknown", PathExpression: "sys.unknown", StartTime: 105, StopTime: 130},
+	}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(res.Metrics) != 2 {
+		t.Fatalf("expected 2 series, got %+v", res.Metrics)
+	}
+	names := map[string]bool{}
+	for _, m := range res.Metrics {
+		names[m.Name] = true
+		if m.PathExpression != "sys.cpu.*" || m.StartTime != 100 || m.StopTime != 130 || m.StepTime != 10 {
+			t.Fatalf("unexpected series %+v", m)
+		}
+		if len(m.Values) != 4 || m.Values[0] != 1 || !math.IsNaN(m.Values[1]) || m.Values[2] != 3 || !math.IsNaN(m.Values[3]) {
+			t.Fatalf("unexpected values %v", m.Values)
+		}
+	}
+	if !names["sys.cpu.user"] || !names["sys.cpu.system"] {
+		t.Fatalf("unexpected series %v", names)
+	}
+
+	_, _, err = c.Fetch(context.Background(), &protov3.MultiFetchRequest{Metrics: []protov3.FetchRequest{
+		{Name: "sys.unknown", PathExpression: "sys.unknown", StartTime: 105, StopTime: 130},
+	}})
+	if !types.IsNotFound(err) {
+		t.Fatalf("unknown metric should not be found, got %v", err)
+	}
+}
+
+func TestFind(t *testing.T) {
+	c, stop := newTestGroup(t)
+	defer stop()
+
+	res, _, err := c.Find(context.Background(), &protov3.MultiGlobRequest{Metrics: []string{"sys.*", "*"}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(res.Metrics) != 2 {
+		t.Fatalf("unexpected response %+v", res.Metrics)
+	}
+	expected := []protov3.GlobMatch{
+		{Path: "sys.cpu"},
+		{Path: "sys.mem", IsLeaf: true},
+		{Path: "sys.mem"},
+	}
+	if m := res.Metrics[0].Matches; len(m) != len(expected) || m[0] != expected[0] || m[1] != expected[1] || m[2] != expected[2] {
+		t.Fatalf("unexpected matches %+v", m)
+	}
+	if m := res.Metrics[1].Matches; len(m) != 2 || m[0].Path != "sys" || m[1].Path != "app" {
+		t.Fatalf("unexpected top-level matches %+v", m)
+	}
+}
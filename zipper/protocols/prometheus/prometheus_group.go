@@ -0,0 +1,408 @@
+package prometheus
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-graphite/carbonapi/limiter"
+	"github.com/go-graphite/carbonapi/phases"
+	"github.com/go-graphite/carbonapi/zipper/errors"
+	"github.com/go-graphite/carbonapi/zipper/helper"
+	"github.com/go-graphite/carbonapi/zipper/httpHeaders"
+	"github.com/go-graphite/carbonapi/zipper/metadata"
+	"github.com/go-graphite/carbonapi/zipper/types"
+	protov3 "github.com/go-graphite/protocol/carbonapi_v3_pb"
+
+	"github.com/prometheus/prometheus/prompb"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultStep = time.Minute
+	// findLookback is how far in the past series are looked for by find, only series that have samples in it can
+	// be found
+	findLookback = time.Hour
+)
+
+func init() {
+	aliases := []string{"prometheus", "prometheus_remote_read"}
+	metadata.Metadata.Lock()
+	for _, name := range aliases {
+		metadata.Metadata.SupportedProtocols[name] = struct{}{}
+		metadata.Metadata.ProtocolInits[name] = New
+		metadata.Metadata.ProtocolInitsWithLimiter[name] = NewWithLimiter
+	}
+	defer metadata.Metadata.Unlock()
+}
+
+// PrometheusGroup fetches series from Prometheus compatible remote read endpoint. Graphite paths are mapped to
+// label matchers by templates, series are aligned to the step, as Prometheus samples have arbitrary timestamps.
+type PrometheusGroup struct {
+	groupName string
+	servers   []string
+	templates []*template
+	step      int64
+
+	client *http.Client
+
+	limiter              *limiter.ServerLimiter
+	logger               *zap.Logger
+	timeout              types.Timeouts
+	maxTries             int
+	maxMetricsPerRequest int
+
+	httpQuery *helper.HttpQuery
+}
+
+func (c *PrometheusGroup) Children() []types.ServerClient {
+	return []types.ServerClient{c}
+}
+
+func NewWithLimiter(logger *zap.Logger, config types.BackendV2, limiter *limiter.ServerLimiter) (types.ServerClient, *errors.Errors) {
+	logger = logger.With(zap.String("type", "prometheusGroup"), zap.String("name", config.GroupName))
+
+	if len(config.Templates) == 0 {
+		return nil, errors.Fatal("no templates specified")
+	}
+	templates := make([]*template, 0, len(config.Templates))
+	for _, s := range config.Templates {
+		t, err := parseTemplate(s)
+		if err != nil {
+			return nil, errors.Fatal(err.Error())
+		}
+		templates = append(templates, t)
+	}
+	step := config.Step
+	if step == 0 {
+		step = defaultStep
+	}
+	if step < time.Second {
+		return nil, errors.Fatal("step must be at least 1s")
+	}
+
+	httpClient, err := helper.NewHTTPClient(config)
+	if err != nil {
+		return nil, errors.Fatalf("failed to create http client: %v", err)
+	}
+
+	httpQuery := helper.NewHttpQuery(logger, config.GroupName, config.Servers, *config.MaxTries, limiter, httpClient, httpHeaders.ContentTypeProtobuf)
+
+	c := &PrometheusGroup{
+		groupName:            config.GroupName,
+		servers:              config.Servers,
+		templates:            templates,
+		step:                 int64(step / time.Second),
+		timeout:              *config.Timeouts,
+		maxTries:             *config.MaxTries,
+		maxMetricsPerRequest: config.MaxBatchSize,
+
+		client:  httpClient,
+		limiter: limiter,
+		logger:  logger,
+
+		httpQuery: httpQuery,
+	}
+	return c, nil
+}
+
+func New(logger *zap.Logger, config types.BackendV2) (types.ServerClient, *errors.Errors) {
+	if config.ConcurrencyLimit == nil {
+		return nil, errors.Fatal("concurency limit is not set")
+	}
+	if len(config.Servers) == 0 {
+		return nil, errors.Fatal("no servers specified")
+	}
+	limiter := limiter.NewServerLimiter([]string{config.GroupName}, *config.ConcurrencyLimit)
+
+	return NewWithLimiter(logger, config, limiter)
+}
+
+func (c PrometheusGroup) MaxMetricsPerRequest() int {
+	return c.maxMetricsPerRequest
+}
+
+func (c PrometheusGroup) Name() string {
+	return c.groupName
+}
+
+func (c PrometheusGroup) Backends() []string {
+	return c.servers
+}
+
+// readQuery remembers what query of remote read request was made for, results come in the same order
+type readQuery struct {
+	template *template
+	depth    int
+	request  protov3.FetchRequest
+}
+
+func (c *PrometheusGroup) read(ctx context.Context, queries []readQuery, rr readRequest) ([]*prompb.QueryResult, *helper.ServerResponse, *errors.Errors) {
+	var results []*prompb.QueryResult
+	res, err := c.httpQuery.DoQueryDecoded(ctx, remoteReadURI, rr, func(response []byte) error {
+		t0 := time.Now()
+		defer phases.Since(phases.Decode, t0)
+		var err error
+		results, err = unmarshalReadResponse(response)
+		if err != nil || len(results) == len(queries) {
+			return err
+		}
+		if err = helper.Anomaly(helper.AnomalyLengthMismatch, "%d results for %d queries", len(results), len(queries)); err != nil {
+			return err
+		}
+		// extra results can't be matched to anything
+		if len(results) > len(queries) {
+			results = results[:len(queries)]
+		}
+		return nil
+	})
+	return results, res, err
+}
+
+// seriesQueryTemplate remembers which template series request was made for, series of all the templates are
+// requested at once
+type seriesQueryTemplate struct {
+	template *template
+	matchers []*prompb.LabelMatcher
+}
+
+// series lists label sets of the series that match the query, it's much cheaper than reading their samples
+func (c *PrometheusGroup) series(ctx context.Context, uri string) ([]map[string]string, *helper.ServerResponse, *errors.Errors) {
+	var series []map[string]string
+	res, err := c.httpQuery.DoQueryDecodedByType(ctx, uri, nil, httpHeaders.ContentTypeJSON, map[string]func(response []byte) error{
+		httpHeaders.ContentTypeJSON: func(response []byte) error {
+			t0 := time.Now()
+			defer phases.Since(phases.Decode, t0)
+			var err error
+			series, err = unmarshalSeriesResponse(response)
+			return err
+		},
+	})
+	return series, res, err
+}
+
+// align puts samples to the points of step aligned series, the last sample of the step wins
+func (c *PrometheusGroup) align(samples []prompb.Sample, from, until int64) (int64, int64, []float64) {
+	start := from - from%c.step
+	stop := until - until%c.step
+	values := make([]float64, (stop-start)/c.step+1)
+	for i := range values {
+		values[i] = math.NaN()
+	}
+	for _, s := range samples {
+		ts := s.Timestamp / 1000
+		if ts < start || ts > until {
+			continue
+		}
+		idx := (ts - start) / c.step
+		if idx >= int64(len(values)) {
+			continue
+		}
+		values[idx] = s.Value
+	}
+	return start, stop, values
+}
+
+func (c *PrometheusGroup) Fetch(ctx context.Context, request *protov3.MultiFetchRequest) (*protov3.MultiFetchResponse, *types.Stats, *errors.Errors) {
+	stats := &types.Stats{}
+
+	var queries []readQuery
+	var rr readRequest
+	for _, m := range request.Metrics {
+		nodes := strings.Split(m.Name, ".")
+		for _, t := range c.templates {
+			if len(nodes) != len(t.nodes) {
+				continue
+			}
+			matchers, static, ok := t.matchers(nodes)
+			if !ok || static {
+				continue
+			}
+			queries = append(queries, readQuery{template: t, depth: len(nodes), request: m})
+			rr.Queries = append(rr.Queries, &prompb.Query{
+				StartTimestampMs: m.StartTime * 1000,
+				EndTimestampMs:   m.StopTime * 1000,
+				Matchers:         matchers,
+			})
+		}
+	}
+	if len(queries) == 0 {
+		stats.NotFound++
+		return nil, stats, errors.FromErrNonFatal(types.ErrNotFound)
+	}
+
+	results, res, err := c.read(ctx, queries, rr)
+	if types.IsNotFound(err) {
+		stats.NotFound++
+		return nil, stats, err
+	}
+	if err != nil {
+		err.HaveFatalErrors = false
+		return nil, stats, err
+	}
+	stats.Servers = append(stats.Servers, res.Server)
+
+	var r protov3.MultiFetchResponse
+	seen := make(map[string]struct{})
+	for i, result := range results {
+		q := queries[i]
+		for _, s := range result.Timeseries {
+			name, ok := q.template.path(labelsOf(s.Labels), q.depth)
+			if !ok {
+				continue
+			}
+			// the same series can match several templates or targets
+			key := q.request.PathExpression + "\x00" + name
+			if _, ok := seen[key]; ok {
+				continue
+			}
+			seen[key] = struct{}{}
+
+			start, stop, values := c.align(s.Samples, q.request.StartTime, q.request.StopTime)
+			r.Metrics = append(r.Metrics, protov3.FetchResponse{
+				Name:              name,
+				PathExpression:    q.request.PathExpression,
+				ConsolidationFunc: "Average",
+				StartTime:         start,
+				StopTime:          stop,
+				StepTime:          c.step,
+				Values:            values,
+				RequestStartTime:  q.request.StartTime,
+				RequestStopTime:   q.request.StopTime,
+			})
+		}
+	}
+
+	if len(r.Metrics) == 0 {
+		stats.NotFound++
+		return nil, stats, errors.FromErrNonFatal(types.ErrNotFound)
+	}
+	return &r, stats, nil
+}
+
+func (c *PrometheusGroup) Find(ctx context.Context, request *protov3.MultiGlobRequest) (*protov3.MultiGlobResponse, *types.Stats, *errors.Errors) {
+	logger := c.logger.With(zap.String("type", "find"), zap.Strings("request", request.Metrics))
+	stats := &types.Stats{}
+
+	var r protov3.MultiGlobResponse
+	r.Metrics = make([]protov3.GlobResponse, 0)
+	var e errors.Errors
+	now := time.Now()
+	for _, glob := range request.Metrics {
+		nodes := strings.Split(glob, ".")
+		var matches []protov3.GlobMatch
+		seen := make(map[string]struct{})
+		addMatch := func(path string, isLeaf bool) {
+			if _, ok := seen[path]; ok {
+				return
+			}
+			seen[path] = struct{}{}
+			matches = append(matches, protov3.GlobMatch{Path: path, IsLeaf: isLeaf})
+		}
+
+		var queries []seriesQueryTemplate
+		var selectors []string
+		for _, t := range c.templates {
+			matchers, static, ok := t.matchers(nodes)
+			if !ok {
+				continue
+			}
+			if static {
+				addMatch(t.literalPath(len(nodes)), len(nodes) == len(t.nodes))
+				continue
+			}
+			queries = append(queries, seriesQueryTemplate{template: t, matchers: matchers})
+			selectors = append(selectors, selector(matchers))
+		}
+
+		if len(queries) != 0 {
+			series, res, err := c.series(ctx, seriesQuery(selectors, now.Add(-findLookback).Unix(), now.Unix()))
+			switch {
+			case types.IsNotFound(err):
+				stats.NotFound++
+			case err != nil:
+				e.Merge(err)
+			default:
+				stats.Servers = append(stats.Servers, res.Server)
+				for _, labels := range series {
+					for _, q := range queries {
+						if !matchLabels(q.matchers, labels) {
+							continue
+						}
+						if path, ok := q.template.path(labels, len(nodes)); ok {
+							addMatch(path, len(nodes) == len(q.template.nodes))
+						}
+					}
+				}
+			}
+		}
+
+		if len(matches) == 0 {
+			stats.NotFound++
+			continue
+		}
+		r.Metrics = append(r.Metrics, protov3.GlobResponse{
+			Name:    glob,
+			Matches: matches,
+		})
+	}
+
+	if len(e.Errors) != 0 {
+		logger.Error("errors occurred while getting results",
+			zap.Any("errors", e.Errors),
+		)
+	}
+
+	if len(r.Metrics) == 0 {
+		if len(e.Errors) == 0 {
+			return nil, stats, errors.FromErrNonFatal(types.ErrNotFound)
+		}
+		return nil, stats, errors.FromErr(types.ErrNoResponseFetched)
+	}
+	return &r, stats, nil
+}
+
+// Info is not supported, Prometheus has no per-series retention
+func (c *PrometheusGroup) Info(ctx context.Context, request *protov3.MultiMetricsInfoRequest) (*protov3.ZipperInfoResponse, *types.Stats, *errors.Errors) {
+	return nil, nil, errors.FromErrNonFatal(types.ErrNotImplementedYet)
+}
+
+// List is not supported, remote read has no way to list all the series
+func (c *PrometheusGroup) List(ctx context.Context) (*protov3.ListMetricsResponse, *types.Stats, *errors.Errors) {
+	return nil, nil, errors.FromErrNonFatal(types.ErrNotImplementedYet)
+}
+
+func (c *PrometheusGroup) Stats(ctx context.Context) (*protov3.MetricDetailsResponse, *types.Stats, *errors.Errors) {
+	return nil, nil, errors.FromErrNonFatal(types.ErrNotImplementedYet)
+}
+
+func (c *PrometheusGroup) ProbeTLDs(ctx context.Context) ([]string, *errors.Errors) {
+	logger := c.logger.With(zap.String("function", "prober"))
+	req := &protov3.MultiGlobRequest{
+		Metrics: []string{"*"},
+	}
+
+	logger.Debug("doing request",
+		zap.Strings("request", req.Metrics),
+	)
+
+	res, _, err := c.Find(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	var tlds []string
+	for _, m := range res.Metrics {
+		for _, v := range m.Matches {
+			tlds = append(tlds, v.Path)
+		}
+	}
+
+	logger.Debug("will return data",
+		zap.Strings("tlds", tlds),
+	)
+
+	return tlds, nil
+}
@@ -0,0 +1,177 @@
+package prometheus
+
+import (
+	"context"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-graphite/carbonapi/zipper/types"
+	protov3 "github.com/go-graphite/protocol/carbonapi_v3_pb"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/golang/snappy"
+	"github.com/prometheus/prometheus/prompb"
+	"go.uber.org/zap"
+)
+
+// encodeReadResponse encodes response like Prometheus does, single result per query
+func encodeReadResponse(t *testing.T, results ...[]*prompb.TimeSeries) []byte {
+	var res prompb.ReadResponse
+	for _, series := range results {
+		res.Results = append(res.Results, &prompb.QueryResult{Timeseries: series})
+	}
+	b, err := proto.Marshal(&res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return snappy.Encode(nil, b)
+}
+
+// decodeReadRequest returns queries of the request
+func decodeReadRequest(t *testing.T, body []byte) []*prompb.Query {
+	b, err := snappy.Decode(nil, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var req prompb.ReadRequest
+	if err := proto.Unmarshal(b, &req); err != nil {
+		t.Fatal(err)
+	}
+	return req.Queries
+}
+
+func newTestGroup(t *testing.T, handler http.HandlerFunc) (*PrometheusGroup, func()) {
+	srv := httptest.NewServer(handler)
+	maxTries := 1
+	// no concurrency limit
+	limit := 0
+	idleConns := 1
+	keepAlive := time.Second
+	config := types.BackendV2{
+		GroupName:           "prometheus",
+		Protocol:            "prometheus",
+		Servers:             []string{srv.URL},
+		Templates:           []string{"prom.{job}.{instance}.{__name__}"},
+		Step:                10 * time.Second,
+		MaxTries:            &maxTries,
+		ConcurrencyLimit:    &limit,
+		MaxIdleConnsPerHost: &idleConns,
+		KeepAliveInterval:   &keepAlive,
+	}
+	config.FillDefaults()
+
+	c, err := New(zap.NewNop(), config)
+	if err != nil {
+		srv.Close()
+		t.Fatalf("failed to create group: %v", err)
+	}
+	return c.(*PrometheusGroup), srv.Close
+}
+
+func TestFetch(t *testing.T) {
+	c, stop := newTestGroup(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || r.URL.Path != remoteReadURI || r.Header.Get("Content-Encoding") != "snappy" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		queries := decodeReadRequest(t, body)
+		if len(queries) != 1 || *queries[0].Matchers[0] != (prompb.LabelMatcher{Type: prompb.LabelMatcher_EQ, Name: "job", Value: "node"}) {
+			t.Errorf("unexpected queries %+v", queries)
+		}
+
+		w.Header().Set("Content-Type", "application/x-protobuf")
+		_, _ = w.Write(encodeReadResponse(t, []*prompb.TimeSeries{
+			{
+				Labels:  []*prompb.Label{{Name: "__name__", Value: "up"}, {Name: "instance", Value: "host.example.com"}, {Name: "job", Value: "node"}},
+				Samples: []prompb.Sample{{Value: 1, Timestamp: 101000}, {Value: 2, Timestamp: 105000}, {Value: 3, Timestamp: 125000}},
+			},
+			{
+				// can't be mapped to path
+				Labels:  []*prompb.Label{{Name: "__name__", Value: "up"}, {Name: "job", Value: "node"}},
+				Samples: []prompb.Sample{{Value: 1, Timestamp: 101000}},
+			},
+		}))
+	})
+	defer stop()
+
+	res, _, err := c.Fetch(context.Background(), &protov3.MultiFetchRequest{Metrics: []protov3.FetchRequest{
+		{Name: "prom.node.*.up", PathExpression: "prom.node.*.up", StartTime: 105, StopTime: 130},
+		{Name: "other.metric", PathExpression: "other.metric", StartTime: 105, StopTime: 130},
+	}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(res.Metrics) != 1 {
+		t.Fatalf("expected single series, got %+v", res.Metrics)
+	}
+	m := res.Metrics[0]
+	if m.Name != "prom.node.host_example_com.up" || m.StartTime != 100 || m.StopTime != 130 || m.StepTime != 10 {
+		t.Fatalf("unexpected series %+v", m)
+	}
+	if len(m.Values) != 4 || m.Values[0] != 2 || !math.IsNaN(m.Values[1]) || m.Values[2] != 3 || !math.IsNaN(m.Values[3]) {
+		t.Fatalf("unexpected values %v", m.Values)
+	}
+}
+
+func TestFind(t *testing.T) {
+	c, stop := newTestGroup(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != seriesURI || r.URL.Query().Get("match[]") != `{job=~".+"}` {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"status": "success", "data": [
+			{"__name__": "up", "instance": "a", "job": "node"},
+			{"__name__": "load", "instance": "a", "job": "node"},
+			{"__name__": "up", "instance": "b", "job": "api"},
+			{"__name__": "up", "instance": "c"}
+		]}`))
+	})
+	defer stop()
+
+	res, _, err := c.Find(context.Background(), &protov3.MultiGlobRequest{Metrics: []string{"*", "prom.*"}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(res.Metrics) != 2 {
+		t.Fatalf("unexpected response %+v", res.Metrics)
+	}
+	if m := res.Metrics[0].Matches; len(m) != 1 || m[0].Path != "prom" || m[0].IsLeaf {
+		t.Fatalf("static node should be found without request, got %+v", m)
+	}
+	if m := res.Metrics[1].Matches; len(m) != 2 || m[0].Path != "prom.node" || m[1].Path != "prom.api" || m[0].IsLeaf {
+		t.Fatalf("unexpected matches %+v", m)
+	}
+}
+
+func TestMatchLabels(t *testing.T) {
+	matchers := []*prompb.LabelMatcher{
+		{Type: prompb.LabelMatcher_EQ, Name: "job", Value: "node"},
+		{Type: prompb.LabelMatcher_RE, Name: "instance", Value: "a|b"},
+		{Type: prompb.LabelMatcher_NRE, Name: "env", Value: "dev.*"},
+	}
+	if s := selector(matchers); s != `{job="node",instance=~"a|b",env!~"dev.*"}` {
+		t.Errorf("unexpected selector %v", s)
+	}
+
+	tests := []struct {
+		labels   map[string]string
+		expected bool
+	}{
+		{map[string]string{"job": "node", "instance": "a"}, true},
+		{map[string]string{"job": "node", "instance": "a", "env": "prod"}, true},
+		{map[string]string{"job": "node", "instance": "a", "env": "devel"}, false},
+		{map[string]string{"job": "node", "instance": "ab"}, false},
+		{map[string]string{"job": "api", "instance": "b"}, false},
+	}
+	for _, tt := range tests {
+		if got := matchLabels(matchers, tt.labels); got != tt.expected {
+			t.Errorf("%v: expected %v, got %v", tt.labels, tt.expected, got)
+		}
+	}
+}
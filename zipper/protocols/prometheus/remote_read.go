@@ -0,0 +1,147 @@
+package prometheus
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/golang/snappy"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+const (
+	remoteReadURI = "/api/v1/read"
+	seriesURI     = "/api/v1/series"
+	// maxSnappyRatio limits decoded size of the response, snappy can't compress better than that
+	maxSnappyRatio = 32
+)
+
+// readRequest implements types.HTTPRequest, so it's sent as remote read expects it
+type readRequest struct {
+	prompb.ReadRequest
+}
+
+func (r readRequest) Method() string {
+	return "POST"
+}
+
+func (r readRequest) Header() http.Header {
+	return http.Header{
+		"Content-Type":                     []string{"application/x-protobuf"},
+		"Content-Encoding":                 []string{"snappy"},
+		"X-Prometheus-Remote-Read-Version": []string{"0.1.0"},
+	}
+}
+
+func (r readRequest) LogInfo() interface{} {
+	return r.Queries
+}
+
+func (r readRequest) Marshal() ([]byte, error) {
+	b, err := proto.Marshal(&r.ReadRequest)
+	if err != nil {
+		return nil, err
+	}
+	return snappy.Encode(nil, b), nil
+}
+
+// unmarshalReadResponse decodes snappy compressed ReadResponse. Results are in order of queries of the request.
+func unmarshalReadResponse(body []byte) ([]*prompb.QueryResult, error) {
+	n, err := snappy.DecodedLen(body)
+	if err != nil {
+		return nil, err
+	}
+	if n > len(body)*maxSnappyRatio {
+		return nil, fmt.Errorf("snappy: invalid length")
+	}
+	b, err := snappy.Decode(nil, body)
+	if err != nil {
+		return nil, err
+	}
+
+	var res prompb.ReadResponse
+	if err := proto.Unmarshal(b, &res); err != nil {
+		return nil, err
+	}
+	return res.Results, nil
+}
+
+// labelsOf returns labels of the series by name
+func labelsOf(labels []*prompb.Label) map[string]string {
+	res := make(map[string]string, len(labels))
+	for _, l := range labels {
+		res[l.Name] = l.Value
+	}
+	return res
+}
+
+// seriesQuery returns URI of series API request, series that match any of the selectors are returned
+func seriesQuery(selectors []string, start, end int64) string {
+	v := url.Values{
+		"match[]": selectors,
+		"start":   []string{strconv.FormatInt(start, 10)},
+		"end":     []string{strconv.FormatInt(end, 10)},
+	}
+	return seriesURI + "?" + v.Encode()
+}
+
+// selector is PromQL series selector of the matchers
+func selector(matchers []*prompb.LabelMatcher) string {
+	parts := make([]string, 0, len(matchers))
+	for _, m := range matchers {
+		var op string
+		switch m.Type {
+		case prompb.LabelMatcher_EQ:
+			op = "="
+		case prompb.LabelMatcher_NEQ:
+			op = "!="
+		case prompb.LabelMatcher_RE:
+			op = "=~"
+		case prompb.LabelMatcher_NRE:
+			op = "!~"
+		}
+		parts = append(parts, m.Name+op+strconv.Quote(m.Value))
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+// matchLabels checks that labels satisfy the matchers, as series of a single series request can be selected by
+// different templates
+func matchLabels(matchers []*prompb.LabelMatcher, labels map[string]string) bool {
+	for _, m := range matchers {
+		v := labels[m.Name]
+		var ok bool
+		switch m.Type {
+		case prompb.LabelMatcher_EQ, prompb.LabelMatcher_NEQ:
+			ok = v == m.Value
+		case prompb.LabelMatcher_RE, prompb.LabelMatcher_NRE:
+			re, err := regexp.Compile("^(?:" + m.Value + ")$")
+			ok = err == nil && re.MatchString(v)
+		}
+		if negative := m.Type == prompb.LabelMatcher_NEQ || m.Type == prompb.LabelMatcher_NRE; ok == negative {
+			return false
+		}
+	}
+	return true
+}
+
+// unmarshalSeriesResponse decodes response of series API: {"status": "success", "data": [{label: value}, ...]}
+func unmarshalSeriesResponse(body []byte) ([]map[string]string, error) {
+	var res struct {
+		Status string              `json:"status"`
+		Error  string              `json:"error"`
+		Data   []map[string]string `json:"data"`
+	}
+	if err := json.Unmarshal(body, &res); err != nil {
+		return nil, err
+	}
+	if res.Status != "success" {
+		return nil, fmt.Errorf("series request failed: %v", res.Error)
+	}
+	return res.Data, nil
+}
@@ -0,0 +1,152 @@
+package prometheus
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// templateNode is a node of graphite path. It's either a literal or a value of label.
+type templateNode struct {
+	literal string
+	label   string
+}
+
+// template maps graphite paths to Prometheus series, e.x. "prometheus.{job}.{instance}.{__name__}" maps
+// prometheus.node.host1.up to up{job="node",instance="host1"}. Dots in label values are replaced with underscores,
+// as they would create extra nodes otherwise.
+type template struct {
+	source string
+	nodes  []templateNode
+}
+
+func parseTemplate(s string) (*template, error) {
+	t := &template{source: s}
+	labels := make(map[string]struct{})
+	for _, node := range strings.Split(s, ".") {
+		if node == "" {
+			return nil, fmt.Errorf("template %q: empty node", s)
+		}
+		if !strings.HasPrefix(node, "{") && !strings.HasSuffix(node, "}") {
+			if strings.ContainsAny(node, "{}*?[],") {
+				return nil, fmt.Errorf("template %q: unexpected special character in %q", s, node)
+			}
+			t.nodes = append(t.nodes, templateNode{literal: node})
+			continue
+		}
+		label := strings.TrimSuffix(strings.TrimPrefix(node, "{"), "}")
+		if len(label) != len(node)-2 || !labelNameRe.MatchString(label) {
+			return nil, fmt.Errorf("template %q: invalid label %q", s, node)
+		}
+		if _, ok := labels[label]; ok {
+			return nil, fmt.Errorf("template %q: label %q is used twice", s, label)
+		}
+		labels[label] = struct{}{}
+		t.nodes = append(t.nodes, templateNode{label: label})
+	}
+	if len(labels) == 0 {
+		return nil, fmt.Errorf("template %q: no labels", s)
+	}
+	return t, nil
+}
+
+var labelNameRe = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// matchers converts first nodes of graphite glob to label matchers. It returns false if glob can't match any path
+// of the template. static is true if all the nodes are literals, so there is nothing to ask Prometheus about.
+func (t *template) matchers(nodes []string) (matchers []*prompb.LabelMatcher, static bool, ok bool) {
+	if len(nodes) > len(t.nodes) {
+		return nil, false, false
+	}
+	static = true
+	for i, glob := range nodes {
+		node := t.nodes[i]
+		if node.label == "" {
+			re, err := regexp.Compile("^" + globToRegexp(glob) + "$")
+			if err != nil || !re.MatchString(node.literal) {
+				return nil, false, false
+			}
+			continue
+		}
+		static = false
+		switch {
+		case glob == "*":
+			matchers = append(matchers, &prompb.LabelMatcher{Type: prompb.LabelMatcher_RE, Name: node.label, Value: ".+"})
+		case !strings.ContainsAny(glob, "*?[{_"):
+			matchers = append(matchers, &prompb.LabelMatcher{Type: prompb.LabelMatcher_EQ, Name: node.label, Value: glob})
+		default:
+			re := globToRegexp(glob)
+			if _, err := regexp.Compile(re); err != nil {
+				return nil, false, false
+			}
+			matchers = append(matchers, &prompb.LabelMatcher{Type: prompb.LabelMatcher_RE, Name: node.label, Value: re})
+		}
+	}
+	return matchers, static, true
+}
+
+// path builds graphite path of the series, cut to depth nodes. It returns false if series lacks some of the labels.
+func (t *template) path(labels map[string]string, depth int) (string, bool) {
+	nodes := make([]string, 0, depth)
+	for _, node := range t.nodes[:depth] {
+		if node.label == "" {
+			nodes = append(nodes, node.literal)
+			continue
+		}
+		value := labels[node.label]
+		if value == "" {
+			return "", false
+		}
+		nodes = append(nodes, strings.Replace(value, ".", "_", -1))
+	}
+	return strings.Join(nodes, "."), true
+}
+
+// literalPath is path of the template that consists of literals only
+func (t *template) literalPath(depth int) string {
+	nodes := make([]string, 0, depth)
+	for _, node := range t.nodes[:depth] {
+		nodes = append(nodes, node.literal)
+	}
+	return strings.Join(nodes, ".")
+}
+
+// globToRegexp converts graphite glob of a single node to RE2 regexp. Underscore matches dot as well, as dots of
+// label values are replaced with underscores in paths.
+func globToRegexp(glob string) string {
+	var b bytes.Buffer
+	inList := false
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch {
+		case c == '*':
+			b.WriteString(".*")
+		case c == '?':
+			b.WriteString(".")
+		case c == '[':
+			end := strings.IndexByte(glob[i:], ']')
+			if end < 0 {
+				b.WriteString(`\[`)
+				continue
+			}
+			b.WriteString(glob[i : i+end+1])
+			i += end
+		case c == '{' && !inList:
+			inList = true
+			b.WriteString("(")
+		case c == '}' && inList:
+			inList = false
+			b.WriteString(")")
+		case c == ',' && inList:
+			b.WriteString("|")
+		case c == '_':
+			b.WriteString("[._]")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,71 @@
+package prometheus
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/prometheus/prompb"
+)
+
+func TestParseTemplate(t *testing.T) {
+	for _, s := range []string{"", "prom..{job}", "prom.static", "prom.{job}.{job}", "prom.{bad-label}", "prom.*.{job}"} {
+		if _, err := parseTemplate(s); err == nil {
+			t.Errorf("template %q should be invalid", s)
+		}
+	}
+}
+
+func TestTemplateMatchers(t *testing.T) {
+	tmpl, err := parseTemplate("prom.{job}.{instance}.{__name__}")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		glob     string
+		matchers []*prompb.LabelMatcher
+		static   bool
+		ok       bool
+	}{
+		{"prom.node.*.up", []*prompb.LabelMatcher{
+			{Type: prompb.LabelMatcher_EQ, Name: "job", Value: "node"},
+			{Type: prompb.LabelMatcher_RE, Name: "instance", Value: ".+"},
+			{Type: prompb.LabelMatcher_EQ, Name: "__name__", Value: "up"},
+		}, false, true},
+		{"p*.{node,api}.host_1.up", []*prompb.LabelMatcher{
+			{Type: prompb.LabelMatcher_RE, Name: "job", Value: "(node|api)"},
+			{Type: prompb.LabelMatcher_RE, Name: "instance", Value: "host[._]1"},
+			{Type: prompb.LabelMatcher_EQ, Name: "__name__", Value: "up"},
+		}, false, true},
+		{"prom", nil, true, true},
+		{"other.node", nil, false, false},
+		{"prom.node.host.up.extra", nil, false, false},
+	}
+
+	for _, tt := range tests {
+		matchers, static, ok := tmpl.matchers(strings.Split(tt.glob, "."))
+		if ok != tt.ok || static != tt.static || !reflect.DeepEqual(matchers, tt.matchers) {
+			t.Errorf("%s: got %+v, %v, %v, expected %+v, %v, %v", tt.glob, matchers, static, ok, tt.matchers, tt.static, tt.ok)
+		}
+	}
+}
+
+func TestTemplatePath(t *testing.T) {
+	tmpl, err := parseTemplate("prom.{job}.{instance}.{__name__}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	labels := map[string]string{"__name__": "up", "instance": "host.example.com:9100", "job": "node"}
+
+	if path, ok := tmpl.path(labels, 4); !ok || path != "prom.node.host_example_com:9100.up" {
+		t.Errorf("unexpected path %q", path)
+	}
+	if path, ok := tmpl.path(labels, 2); !ok || path != "prom.node" {
+		t.Errorf("unexpected truncated path %q", path)
+	}
+	delete(labels, "job")
+	if _, ok := tmpl.path(labels, 4); ok {
+		t.Errorf("series without label should have no path")
+	}
+}
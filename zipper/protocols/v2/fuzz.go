@@ -0,0 +1,24 @@
+// +build gofuzz
+
+package v2
+
+import (
+	"github.com/go-graphite/carbonapi/zipper/helper"
+	protov2 "github.com/go-graphite/protocol/carbonapi_v2_pb"
+)
+
+// Fuzz decodes data as render response the same way Fetch does, strict decode is enabled, so all the checks are run
+func Fuzz(data []byte) int {
+	helper.SetStrictDecode(true)
+	var metrics protov2.MultiFetchResponse
+	if err := helper.CheckProtoFields(data, &metrics); err != nil {
+		return 0
+	}
+	if err := metrics.Unmarshal(data); err != nil {
+		return 0
+	}
+	if err := checkSeries(metrics.Metrics); err != nil {
+		return 0
+	}
+	return 1
+}
@@ -0,0 +1,77 @@
+package v2
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strconv"
+
+	"github.com/go-graphite/carbonapi/zipper/errors"
+	"github.com/go-graphite/carbonapi/zipper/types"
+	protov3 "github.com/go-graphite/protocol/carbonapi_v3_pb"
+	"github.com/gogo/protobuf/proto"
+)
+
+// metricsField is the key of repeated FetchResponse metrics = 1 in MultiFetchResponse
+const metricsField = 1<<3 | proto.WireBytes
+
+// validMultiFetchResponse checks that b looks like MultiFetchResponse: it consists of length-delimited metrics
+// only and they don't exceed the buffer. Series themselves are not decoded, so it's cheap.
+func validMultiFetchResponse(b []byte) error {
+	for len(b) > 0 {
+		key, n := proto.DecodeVarint(b)
+		if n == 0 || key != metricsField {
+			return fmt.Errorf("unexpected field in MultiFetchResponse")
+		}
+		b = b[n:]
+		length, n := proto.DecodeVarint(b)
+		if n == 0 || length > uint64(len(b)-n) {
+			return types.ErrResponseLengthMismatch
+		}
+		b = b[n+int(length):]
+	}
+	return nil
+}
+
+// FetchPassthrough returns response of the backend as is. It's only possible if all metrics are requested for the
+// same time range, so the backend is asked once.
+func (c *ClientProtoV2Group) FetchPassthrough(ctx context.Context, request *protov3.MultiFetchRequest) ([]byte, *types.Stats, *errors.Errors) {
+	stats := &types.Stats{}
+	if len(request.Metrics) == 0 {
+		return nil, stats, nil
+	}
+
+	from, until := request.Metrics[0].StartTime, request.Metrics[0].StopTime
+	targets := make([]string, 0, len(request.Metrics))
+	for _, m := range request.Metrics {
+		if m.StartTime != from || m.StopTime != until {
+			return nil, stats, nil
+		}
+		targets = append(targets, m.Name)
+	}
+
+	rewrite, _ := url.Parse("http://127.0.0.1/render/")
+	v := url.Values{
+		"target": targets,
+		"format": []string{format},
+		"from":   []string{strconv.Itoa(int(from))},
+		"until":  []string{strconv.Itoa(int(until))},
+	}
+	rewrite.RawQuery = v.Encode()
+	res, err := c.httpQuery.DoQueryDecoded(ctx, rewrite.RequestURI(), nil, validMultiFetchResponse)
+	if types.IsNotFound(err) {
+		stats.NotFound++
+		return nil, stats, err
+	}
+	if err != nil {
+		err.HaveFatalErrors = false
+		return nil, stats, err
+	}
+	if len(res.Response) == 0 {
+		// response without metrics
+		stats.NotFound++
+		return nil, stats, errors.FromErrNonFatal(types.ErrNotFound)
+	}
+
+	return res.Response, stats, nil
+}
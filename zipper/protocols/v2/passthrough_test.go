@@ -0,0 +1,30 @@
+package v2
+
+import (
+	"testing"
+
+	protov2 "github.com/go-graphite/protocol/carbonapi_v2_pb"
+)
+
+func TestValidMultiFetchResponse(t *testing.T) {
+	res := protov2.MultiFetchResponse{
+		Metrics: []protov2.FetchResponse{
+			{Name: "foo", StartTime: 60, StopTime: 180, StepTime: 60, Values: []float64{1, 2}, IsAbsent: []bool{false, false}},
+			{Name: "bar", StartTime: 60, StopTime: 180, StepTime: 60, Values: []float64{0, 2}, IsAbsent: []bool{true, false}},
+		},
+	}
+	b, err := res.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := validMultiFetchResponse(b); err != nil {
+		t.Fatalf("valid response is rejected: %v", err)
+	}
+	if err := validMultiFetchResponse(b[:len(b)-1]); err == nil {
+		t.Fatal("truncated response is accepted")
+	}
+	if err := validMultiFetchResponse([]byte("garbage")); err == nil {
+		t.Fatal("garbage is accepted")
+	}
+}
@@ -3,12 +3,13 @@ package v2
 import (
 	"context"
 	"math"
-	"net"
 	"net/http"
 	"net/url"
 	"strconv"
+	"time"
 
 	"github.com/go-graphite/carbonapi/limiter"
+	"github.com/go-graphite/carbonapi/phases"
 	"github.com/go-graphite/carbonapi/zipper/errors"
 	"github.com/go-graphite/carbonapi/zipper/helper"
 	"github.com/go-graphite/carbonapi/zipper/httpHeaders"
@@ -58,15 +59,9 @@ func (c *ClientProtoV2Group) Children() []types.ServerClient {
 func NewWithLimiter(logger *zap.Logger, config types.BackendV2, limiter *limiter.ServerLimiter) (types.ServerClient, *errors.Errors) {
 	logger = logger.With(zap.String("type", "protoV2Group"), zap.String("name", config.GroupName))
 
-	httpClient := &http.Client{
-		Transport: &http.Transport{
-			MaxIdleConnsPerHost: *config.MaxIdleConnsPerHost,
-			DialContext: (&net.Dialer{
-				Timeout:   config.Timeouts.Connect,
-				KeepAlive: *config.KeepAliveInterval,
-				DualStack: true,
-			}).DialContext,
-		},
+	httpClient, err := helper.NewHTTPClient(config)
+	if err != nil {
+		return nil, errors.Fatalf("failed to create http client: %v", err)
 	}
 
 	httpQuery := helper.NewHttpQuery(logger, config.GroupName, config.Servers, *config.MaxTries, limiter, httpClient, httpHeaders.ContentTypeCarbonAPIv2PB)
@@ -141,7 +136,23 @@ func (c *ClientProtoV2Group) Fetch(ctx context.Context, request *protov3.MultiFe
 			"until":  []string{strconv.Itoa(int(batch.until))},
 		}
 		rewrite.RawQuery = v.Encode()
-		res, err := c.httpQuery.DoQuery(ctx, rewrite.RequestURI(), nil)
+		var metrics protov2.MultiFetchResponse
+		res, err := c.httpQuery.DoQueryDecoded(ctx, rewrite.RequestURI(), nil, func(response []byte) error {
+			metrics = protov2.MultiFetchResponse{}
+			t0 := time.Now()
+			defer phases.Since(phases.Decode, t0)
+			if err := helper.CheckProtoFields(response, &metrics); err != nil {
+				return err
+			}
+			if err := metrics.Unmarshal(response); err != nil {
+				return err
+			}
+			return checkSeries(metrics.Metrics)
+		})
+		if types.IsNotFound(err) {
+			stats.NotFound++
+			continue
+		}
 		if err == nil {
 			err = &errors.Errors{}
 		}
@@ -149,10 +160,7 @@ func (c *ClientProtoV2Group) Fetch(ctx context.Context, request *protov3.MultiFe
 			err.HaveFatalErrors = false
 			return nil, stats, err
 		}
-
-		var metrics protov2.MultiFetchResponse
-		err.AddFatal(metrics.Unmarshal(res.Response))
-		if err.HaveFatalErrors {
+		if res == nil {
 			return nil, stats, err
 		}
 
@@ -177,9 +185,32 @@ func (c *ClientProtoV2Group) Fetch(ctx context.Context, request *protov3.MultiFe
 		}
 	}
 
+	if len(r.Metrics) == 0 && stats.NotFound > 0 {
+		return nil, stats, errors.FromErrNonFatal(types.ErrNotFound)
+	}
+
 	return &r, stats, nil
 }
 
+// checkSeries reports anomalies of decoded series, see helper.Anomaly
+func checkSeries(metrics []protov2.FetchResponse) error {
+	for _, m := range metrics {
+		var err error
+		switch {
+		case len(m.IsAbsent) != len(m.Values):
+			err = helper.Anomaly(helper.AnomalyAbsentMismatch, "%s: %d values, %d absent flags", m.Name, len(m.Values), len(m.IsAbsent))
+		case m.StepTime <= 0 && len(m.Values) > 0:
+			err = helper.Anomaly(helper.AnomalyInvalidStep, "%s: step %d", m.Name, m.StepTime)
+		case m.StopTime < m.StartTime:
+			err = helper.Anomaly(helper.AnomalyInvalidRange, "%s: stop %d is before start %d", m.Name, m.StopTime, m.StartTime)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (c *ClientProtoV2Group) Find(ctx context.Context, request *protov3.MultiGlobRequest) (*protov3.MultiGlobResponse, *types.Stats, *errors.Errors) {
 	logger := c.logger.With(zap.String("type", "find"), zap.Strings("request", request.Metrics))
 	stats := &types.Stats{}
@@ -194,15 +225,22 @@ func (c *ClientProtoV2Group) Find(ctx context.Context, request *protov3.MultiGlo
 			"format": []string{format},
 		}
 		rewrite.RawQuery = v.Encode()
-		res, err := c.httpQuery.DoQuery(ctx, rewrite.RequestURI(), nil)
-		if err != nil {
-			e.Merge(err)
+		var globs protov2.GlobResponse
+		res, err := c.httpQuery.DoQueryDecoded(ctx, rewrite.RequestURI(), nil, func(response []byte) error {
+			globs = protov2.GlobResponse{}
+			t0 := time.Now()
+			defer phases.Since(phases.Decode, t0)
+			if err := helper.CheckProtoFields(response, &globs); err != nil {
+				return err
+			}
+			return globs.Unmarshal(response)
+		})
+		if types.IsNotFound(err) {
+			stats.NotFound++
 			continue
 		}
-		var globs protov2.GlobResponse
-		marshalErr := globs.Unmarshal(res.Response)
-		if marshalErr != nil {
-			e.Add(marshalErr)
+		if err != nil {
+			e.Merge(err)
 			continue
 		}
 		stats.Servers = append(stats.Servers, res.Server)
@@ -226,6 +264,9 @@ func (c *ClientProtoV2Group) Find(ctx context.Context, request *protov3.MultiGlo
 	}
 
 	if len(r.Metrics) == 0 {
+		if stats.NotFound > 0 && len(e.Errors) == 0 {
+			return nil, stats, errors.FromErrNonFatal(types.ErrNotFound)
+		}
 		return nil, stats, errors.FromErr(types.ErrNoResponseFetched)
 	}
 	return &r, stats, nil
@@ -251,16 +292,17 @@ func (c *ClientProtoV2Group) Info(ctx context.Context, request *protov3.MultiMet
 			"format": []string{format},
 		}
 		rewrite.RawQuery = v.Encode()
-		res, e2 := c.httpQuery.DoQuery(ctx, rewrite.RequestURI(), nil)
-		if e2 != nil {
-			e.Merge(e2)
+		var info protov2.InfoResponse
+		res, e2 := c.httpQuery.DoQueryDecoded(ctx, rewrite.RequestURI(), nil, func(response []byte) error {
+			info = protov2.InfoResponse{}
+			return info.Unmarshal(response)
+		})
+		if types.IsNotFound(e2) {
+			stats.NotFound++
 			continue
 		}
-
-		var info protov2.InfoResponse
-		err := info.Unmarshal(res.Response)
-		if err != nil {
-			e.Add(err)
+		if e2 != nil {
+			e.Merge(e2)
 			continue
 		}
 		stats.Servers = append(stats.Servers, res.Server)
@@ -294,6 +336,9 @@ func (c *ClientProtoV2Group) Info(ctx context.Context, request *protov3.MultiMet
 	}
 
 	if len(r.Info[server].Metrics) == 0 {
+		if stats.NotFound > 0 && len(e.Errors) == 0 {
+			return nil, stats, errors.FromErrNonFatal(types.ErrNotFound)
+		}
 		return nil, stats, errors.FromErr(types.ErrNoResponseFetched)
 	}
 
@@ -305,7 +350,25 @@ func (c *ClientProtoV2Group) Info(ctx context.Context, request *protov3.MultiMet
 }
 
 func (c *ClientProtoV2Group) List(ctx context.Context) (*protov3.ListMetricsResponse, *types.Stats, *errors.Errors) {
-	return nil, nil, errors.FromErr(types.ErrNotImplementedYet)
+	stats := &types.Stats{}
+	rewrite, _ := url.Parse("http://127.0.0.1/metrics/list/")
+	v := url.Values{
+		"format": []string{format},
+	}
+	rewrite.RawQuery = v.Encode()
+	var list protov2.ListMetricsResponse
+	res, err := c.httpQuery.DoQueryDecoded(ctx, rewrite.RequestURI(), nil, func(response []byte) error {
+		list = protov2.ListMetricsResponse{}
+		t0 := time.Now()
+		defer phases.Since(phases.Decode, t0)
+		return list.Unmarshal(response)
+	})
+	if err != nil {
+		return nil, stats, err
+	}
+	stats.Servers = append(stats.Servers, res.Server)
+
+	return &protov3.ListMetricsResponse{Metrics: list.Metrics}, stats, nil
 }
 func (c *ClientProtoV2Group) Stats(ctx context.Context) (*protov3.MetricDetailsResponse, *types.Stats, *errors.Errors) {
 	return nil, nil, errors.FromErr(types.ErrNotImplementedYet)
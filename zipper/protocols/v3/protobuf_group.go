@@ -2,11 +2,12 @@ package v3
 
 import (
 	"context"
-	"net"
 	"net/http"
 	"net/url"
+	"time"
 
 	"github.com/go-graphite/carbonapi/limiter"
+	"github.com/go-graphite/carbonapi/phases"
 	"github.com/go-graphite/carbonapi/zipper/errors"
 	"github.com/go-graphite/carbonapi/zipper/helper"
 	"github.com/go-graphite/carbonapi/zipper/httpHeaders"
@@ -65,15 +66,9 @@ func New(logger *zap.Logger, config types.BackendV2) (types.ServerClient, *error
 }
 
 func NewWithLimiter(logger *zap.Logger, config types.BackendV2, limiter *limiter.ServerLimiter) (types.ServerClient, *errors.Errors) {
-	httpClient := &http.Client{
-		Transport: &http.Transport{
-			MaxIdleConnsPerHost: *config.MaxIdleConnsPerHost,
-			DialContext: (&net.Dialer{
-				Timeout:   config.Timeouts.Connect,
-				KeepAlive: *config.KeepAliveInterval,
-				DualStack: true,
-			}).DialContext,
-		},
+	httpClient, err := helper.NewHTTPClient(config)
+	if err != nil {
+		return nil, errors.Fatalf("failed to create http client: %v", err)
 	}
 
 	logger = logger.With(zap.String("type", "protoV3Group"), zap.String("name", config.GroupName))
@@ -117,7 +112,23 @@ func (c *ClientProtoV3Group) Fetch(ctx context.Context, request *protov3.MultiFe
 	}
 	rewrite.RawQuery = v.Encode()
 
-	res, e := c.httpQuery.DoQuery(ctx, rewrite.RequestURI(), types.MultiFetchRequestV3{*request})
+	var metrics protov3.MultiFetchResponse
+	res, e := c.doQuery(ctx, rewrite.RequestURI(), types.MultiFetchRequestV3{*request}, func(response []byte) error {
+		metrics = protov3.MultiFetchResponse{}
+		t0 := time.Now()
+		defer phases.Since(phases.Decode, t0)
+		if err := helper.CheckProtoFields(response, &metrics); err != nil {
+			return err
+		}
+		if err := metrics.Unmarshal(response); err != nil {
+			return err
+		}
+		return checkSeries(metrics.Metrics)
+	})
+	if types.IsNotFound(e) {
+		stats.NotFound++
+		return nil, stats, e
+	}
 	if e == nil {
 		e = &errors.Errors{}
 	}
@@ -127,22 +138,32 @@ func (c *ClientProtoV3Group) Fetch(ctx context.Context, request *protov3.MultiFe
 	}
 
 	if res == nil {
+		if len(e.Errors) > 0 {
+			return nil, stats, e
+		}
 		return nil, stats, errors.FromErrNonFatal(types.ErrNoResponseFetched)
 	}
-	var metrics protov3.MultiFetchResponse
-	e.AddFatal(metrics.Unmarshal(res.Response))
-	if e == nil {
-		e = &errors.Errors{}
-	}
-
-	if e.HaveFatalErrors {
-		e.HaveFatalErrors = false
-		return nil, stats, e
-	}
 
 	return &metrics, stats, nil
 }
 
+// checkSeries reports anomalies of decoded series, see helper.Anomaly
+func checkSeries(metrics []protov3.FetchResponse) error {
+	for _, m := range metrics {
+		var err error
+		switch {
+		case m.StepTime <= 0 && len(m.Values) > 0:
+			err = helper.Anomaly(helper.AnomalyInvalidStep, "%s: step %d", m.Name, m.StepTime)
+		case m.StopTime < m.StartTime:
+			err = helper.Anomaly(helper.AnomalyInvalidRange, "%s: stop %d is before start %d", m.Name, m.StopTime, m.StartTime)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (c *ClientProtoV3Group) Find(ctx context.Context, request *protov3.MultiGlobRequest) (*protov3.MultiGlobResponse, *types.Stats, *errors.Errors) {
 	stats := &types.Stats{}
 	rewrite, _ := url.Parse("http://127.0.0.1/metrics/find/")
@@ -152,7 +173,20 @@ func (c *ClientProtoV3Group) Find(ctx context.Context, request *protov3.MultiGlo
 	}
 	rewrite.RawQuery = v.Encode()
 
-	res, e := c.httpQuery.DoQuery(ctx, rewrite.RequestURI(), types.MultiGlobRequestV3{*request})
+	var globs protov3.MultiGlobResponse
+	res, e := c.doQuery(ctx, rewrite.RequestURI(), types.MultiGlobRequestV3{*request}, func(response []byte) error {
+		globs = protov3.MultiGlobResponse{}
+		t0 := time.Now()
+		defer phases.Since(phases.Decode, t0)
+		if err := helper.CheckProtoFields(response, &globs); err != nil {
+			return err
+		}
+		return globs.Unmarshal(response)
+	})
+	if types.IsNotFound(e) {
+		stats.NotFound++
+		return nil, stats, e
+	}
 	if e == nil {
 		e = &errors.Errors{}
 	}
@@ -162,13 +196,11 @@ func (c *ClientProtoV3Group) Find(ctx context.Context, request *protov3.MultiGlo
 	}
 
 	if res == nil {
+		if len(e.Errors) > 0 {
+			return nil, stats, e
+		}
 		return nil, stats, errors.FromErrNonFatal(types.ErrNotFound)
 	}
-	var globs protov3.MultiGlobResponse
-	err := globs.Unmarshal(res.Response)
-	if err != nil {
-		return nil, nil, errors.FromErrNonFatal(err)
-	}
 
 	return &globs, stats, nil
 }
@@ -182,7 +214,15 @@ func (c *ClientProtoV3Group) Info(ctx context.Context, request *protov3.MultiMet
 	}
 	rewrite.RawQuery = v.Encode()
 
-	res, e := c.httpQuery.DoQuery(ctx, rewrite.RequestURI(), types.MultiMetricsInfoV3{*request})
+	var infos protov3.MultiMetricsInfoResponse
+	res, e := c.doQuery(ctx, rewrite.RequestURI(), types.MultiMetricsInfoV3{*request}, func(response []byte) error {
+		infos = protov3.MultiMetricsInfoResponse{}
+		return infos.Unmarshal(response)
+	})
+	if types.IsNotFound(e) {
+		stats.NotFound++
+		return nil, stats, e
+	}
 	if e == nil {
 		e = &errors.Errors{}
 	}
@@ -192,13 +232,11 @@ func (c *ClientProtoV3Group) Info(ctx context.Context, request *protov3.MultiMet
 	}
 
 	if res == nil {
+		if len(e.Errors) > 0 {
+			return nil, stats, e
+		}
 		return nil, stats, errors.FromErrNonFatal(types.ErrNoResponseFetched)
 	}
-	var infos protov3.MultiMetricsInfoResponse
-	err := infos.Unmarshal(res.Response)
-	if err != nil {
-		return nil, nil, errors.FromErrNonFatal(err)
-	}
 
 	stats.MemoryUsage = int64(infos.Size())
 
@@ -249,3 +287,12 @@ func (c *ClientProtoV3Group) ProbeTLDs(ctx context.Context) ([]string, *errors.E
 
 	return tlds, nil
 }
+
+// doQuery sends request and decodes carbonapi_v3_pb response. Some backends answer with generic protobuf
+// Content-Type, it's decoded the same way.
+func (c *ClientProtoV3Group) doQuery(ctx context.Context, uri string, r types.Request, decode func(response []byte) error) (*helper.ServerResponse, *errors.Errors) {
+	return c.httpQuery.DoQueryDecodedByType(ctx, uri, r, httpHeaders.ContentTypeCarbonAPIv3PB, map[string]func(response []byte) error{
+		httpHeaders.ContentTypeCarbonAPIv3PB: decode,
+		httpHeaders.ContentTypeProtobuf:      decode,
+	})
+}
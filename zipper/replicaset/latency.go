@@ -0,0 +1,73 @@
+package replicaset
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/go-graphite/carbonapi/zipper/types"
+)
+
+const (
+	// latencyDecay is the weight of the latest response time in member's latency estimate
+	latencyDecay = 0.3
+	// latencyHalfLife is how fast estimate of the member that doesn't get requests goes down, so slow member is
+	// tried again after a while
+	latencyHalfLife = 30 * time.Second
+)
+
+type latencyEstimate struct {
+	value   float64
+	updated time.Time
+}
+
+// latencies keeps exponentially weighted response time of the members. Failed request doubles the estimate, so
+// members that fail fast aren't preferred. Members that were never seen have zero latency, so they are tried first.
+type latencies struct {
+	sync.Mutex
+	estimates map[types.ServerClient]latencyEstimate
+	now       func() time.Time
+}
+
+func newLatencies() *latencies {
+	return &latencies{
+		estimates: make(map[types.ServerClient]latencyEstimate),
+		now:       time.Now,
+	}
+}
+
+// decayed returns estimate reduced by the time it wasn't updated
+func (l *latencies) decayed(e latencyEstimate, now time.Time) float64 {
+	age := now.Sub(e.updated)
+	if age <= 0 {
+		return e.value
+	}
+	return e.value * math.Pow(0.5, float64(age)/float64(latencyHalfLife))
+}
+
+func (l *latencies) observe(member types.ServerClient, d time.Duration, failed bool) {
+	l.Lock()
+	defer l.Unlock()
+	now := l.now()
+	e, ok := l.estimates[member]
+	current := l.decayed(e, now)
+	switch {
+	case failed:
+		current = math.Max(2*current, float64(d))
+	case !ok:
+		current = float64(d)
+	default:
+		current = current*(1-latencyDecay) + float64(d)*latencyDecay
+	}
+	l.estimates[member] = latencyEstimate{value: current, updated: now}
+}
+
+func (l *latencies) estimate(member types.ServerClient) time.Duration {
+	l.Lock()
+	defer l.Unlock()
+	e, ok := l.estimates[member]
+	if !ok {
+		return 0
+	}
+	return time.Duration(l.decayed(e, l.now()))
+}
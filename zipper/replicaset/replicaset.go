@@ -0,0 +1,406 @@
+package replicaset
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-graphite/carbonapi/zipper/errors"
+	"github.com/go-graphite/carbonapi/zipper/types"
+	protov3 "github.com/go-graphite/protocol/carbonapi_v3_pb"
+
+	"go.uber.org/zap"
+)
+
+// ReplicaSet is a group of servers that have the same data. Request is sent to `replicas` of them at once and the
+// server that fails is replaced by the next one, so the group answers as long as enough of its servers are alive.
+// First server is rotated between requests to spread the load, servers with lower priority are tried first.
+type ReplicaSet struct {
+	groupName string
+	members   []types.ServerClient
+	servers   []string
+	replicas  int
+	timeout   types.Timeouts
+	// tiers are members grouped by priority, lowest first
+	tiers           [][]weightedMember
+	failoverTimeout time.Duration
+	// preferFastest orders members of the same priority by their latency
+	preferFastest bool
+	latency       *latencies
+
+	counter uint64
+	logger  *zap.Logger
+}
+
+// New creates replica set of the members, replicas is amount of members that must answer every request
+func New(logger *zap.Logger, groupName string, members []types.ServerClient, replicas int, timeout types.Timeouts) (*ReplicaSet, *errors.Errors) {
+	if len(members) == 0 {
+		return nil, errors.Fatal("no servers specified")
+	}
+	if replicas <= 0 {
+		replicas = 1
+	}
+	if replicas > len(members) {
+		replicas = len(members)
+	}
+	servers := make([]string, 0, len(members))
+	for _, m := range members {
+		servers = append(servers, m.Backends()...)
+	}
+
+	rs := &ReplicaSet{
+		groupName: groupName,
+		members:   members,
+		servers:   servers,
+		replicas:  replicas,
+		timeout:   timeout,
+		latency:   newLatencies(),
+		logger:    logger.With(zap.String("type", "replicaSet"), zap.String("groupName", groupName)),
+	}
+	// all members have the same priority and weight by default
+	_ = rs.SetWeights(nil)
+	rs.logger.Debug("created replica set",
+		zap.Strings("servers", servers),
+		zap.Int("replicas", replicas),
+	)
+	return rs, nil
+}
+
+func (rs *ReplicaSet) Name() string {
+	return rs.groupName
+}
+
+func (rs *ReplicaSet) Backends() []string {
+	return rs.servers
+}
+
+// Children returns the set itself, requests must not be broadcasted to its members
+func (rs *ReplicaSet) Children() []types.ServerClient {
+	return []types.ServerClient{rs}
+}
+
+func (rs *ReplicaSet) MaxMetricsPerRequest() int {
+	max := 0
+	for _, m := range rs.members {
+		if n := m.MaxMetricsPerRequest(); n > 0 && (max == 0 || n < max) {
+			max = n
+		}
+	}
+	return max
+}
+
+type weightedMember struct {
+	member   types.ServerClient
+	priority int
+	weight   int
+}
+
+// SetWeights sets priorities and weights of the servers, servers that aren't listed have priority 0 and weight 1
+func (rs *ReplicaSet) SetWeights(weights []types.ServerWeight) error {
+	members := make([]weightedMember, 0, len(rs.members))
+	for _, m := range rs.members {
+		members = append(members, weightedMember{member: m, weight: 1})
+	}
+	for _, w := range weights {
+		found := false
+		for i := range members {
+			for _, server := range members[i].member.Backends() {
+				if server == w.Server {
+					found = true
+					members[i].priority = w.Priority
+					if w.Weight > 0 {
+						members[i].weight = w.Weight
+					}
+				}
+			}
+		}
+		if !found {
+			return fmt.Errorf("server %v is not in the group %v", w.Server, rs.groupName)
+		}
+	}
+
+	sort.SliceStable(members, func(i, j int) bool {
+		return members[i].priority < members[j].priority
+	})
+	var tiers [][]weightedMember
+	for i, m := range members {
+		if i == 0 || m.priority != members[i-1].priority {
+			tiers = append(tiers, nil)
+		}
+		tiers[len(tiers)-1] = append(tiers[len(tiers)-1], m)
+	}
+	rs.tiers = tiers
+	return nil
+}
+
+// SetFailoverTimeout makes the set try the next server as well if the one it waits for haven't answered in time
+func (rs *ReplicaSet) SetFailoverTimeout(timeout time.Duration) {
+	rs.failoverTimeout = timeout
+}
+
+// SetPreferFastest makes the set try members of the same priority from the fastest one, by their recent response
+// times, instead of rotating them by weights. Members that get overloaded become slower, so the load moves away
+// from them.
+func (rs *ReplicaSet) SetPreferFastest(preferFastest bool) {
+	rs.preferFastest = preferFastest
+}
+
+// order returns members in the order they are tried. Tiers are tried by priority, first member of the tier is rotated
+// between requests according to the weights, or members are sorted by latency if the set prefers the fastest ones.
+func (rs *ReplicaSet) order() []types.ServerClient {
+	counter := atomic.AddUint64(&rs.counter, 1)
+	order := make([]types.ServerClient, 0, len(rs.members))
+	for _, tier := range rs.tiers {
+		total := 0
+		for _, m := range tier {
+			total += m.weight
+		}
+		n := int(counter % uint64(total))
+		start := 0
+		for i, m := range tier {
+			if n < m.weight {
+				start = i
+				break
+			}
+			n -= m.weight
+		}
+		first := len(order)
+		for i := range tier {
+			order = append(order, tier[(start+i)%len(tier)].member)
+		}
+		if rs.preferFastest {
+			members := order[first:]
+			latency := make(map[types.ServerClient]time.Duration, len(members))
+			for _, m := range members {
+				latency[m] = rs.latency.estimate(m)
+			}
+			sort.SliceStable(members, func(i, j int) bool {
+				return latency[members[i]] < latency[members[j]]
+			})
+		}
+	}
+	return order
+}
+
+type memberResult struct {
+	member types.ServerClient
+	merge  func()
+	err    *errors.Errors
+}
+
+// run sends request to replicas members, member that fails is replaced by the next one. The next one is also started if
+// none of the members have answered in failoverTimeout. do returns function that merges response of the member, it's
+// only called for successful members and never concurrently. Not found is a successful answer. Errors are fatal if less
+// than replicas members have answered.
+func (rs *ReplicaSet) run(ctx context.Context, logger *zap.Logger, do func(ctx context.Context, member types.ServerClient) (func(), *errors.Errors)) *errors.Errors {
+	order := rs.order()
+	resCh := make(chan memberResult, len(order))
+	next := 0
+	start := func() {
+		member := order[next]
+		next++
+		go func() {
+			t0 := time.Now()
+			merge, err := do(ctx, member)
+			// member that was cancelled, as others have answered, is at least that slow
+			failed := err != nil && err.HaveFatalErrors && ctx.Err() == nil
+			rs.latency.observe(member, time.Since(t0), failed)
+			resCh <- memberResult{member: member, merge: merge, err: err}
+		}()
+	}
+	for next < rs.replicas {
+		start()
+	}
+
+	// failover is nil channel if there is no timeout, so it never fires
+	var failover <-chan time.Time
+	var failoverTimer *time.Timer
+	if rs.failoverTimeout > 0 {
+		failoverTimer = time.NewTimer(rs.failoverTimeout)
+		defer failoverTimer.Stop()
+		failover = failoverTimer.C
+	}
+
+	e := &errors.Errors{}
+	running := next
+	answered := 0
+GATHER:
+	for running > 0 && answered < rs.replicas {
+		select {
+		case <-failover:
+			if next < len(order) {
+				logger.Debug("servers are slow, trying another one",
+					zap.String("server", order[next].Name()),
+					zap.Duration("failover_timeout", rs.failoverTimeout),
+				)
+				start()
+				running++
+				failoverTimer.Reset(rs.failoverTimeout)
+			}
+		case r := <-resCh:
+			running--
+			if r.err != nil {
+				e.Errors = append(e.Errors, r.err.Errors...)
+			}
+			if r.err != nil && r.err.HaveFatalErrors {
+				if next < len(order) {
+					logger.Warn("server failed, trying another one",
+						zap.String("server", r.member.Name()),
+						zap.Any("errors", r.err.Errors),
+					)
+					start()
+					running++
+				}
+				continue
+			}
+			answered++
+			if r.merge != nil {
+				r.merge()
+			}
+		case <-ctx.Done():
+			e.Add(types.ErrTimeoutExceeded)
+			break GATHER
+		}
+	}
+
+	if answered < rs.replicas {
+		if answered > 0 {
+			e.Add(types.ErrQuorumNotReached)
+		}
+		e.HaveFatalErrors = true
+		return e.Addf("failed to get response from %v servers of the group %v", rs.replicas, rs.groupName)
+	}
+	if len(e.Errors) == 0 {
+		return nil
+	}
+	return e
+}
+
+func (rs *ReplicaSet) Fetch(ctx context.Context, request *protov3.MultiFetchRequest) (*protov3.MultiFetchResponse, *types.Stats, *errors.Errors) {
+	logger := rs.logger.With(zap.String("type", "fetch"))
+	ctx, cancel := context.WithTimeout(ctx, rs.timeout.Render)
+	defer cancel()
+
+	opts := types.MergeOptionsFromContext(ctx)
+	result := types.NewServerFetchResponse()
+	result.Server = rs.groupName
+	e := rs.run(ctx, logger, func(ctx context.Context, member types.ServerClient) (func(), *errors.Errors) {
+		r := types.NewServerFetchResponse()
+		r.Server = member.Name()
+		r.Response, r.Stats, r.Err = member.Fetch(ctx, request)
+		return func() {
+			// errors are reported by run
+			r.Err = nil
+			result.Merge(r, opts)
+		}, r.Err
+	})
+	if e != nil && e.HaveFatalErrors {
+		return nil, result.Stats, e
+	}
+	if len(result.Response.Metrics) == 0 {
+		return nil, result.Stats, errors.FromErrNonFatal(types.ErrNotFound)
+	}
+	return result.Response, result.Stats, e
+}
+
+func (rs *ReplicaSet) Find(ctx context.Context, request *protov3.MultiGlobRequest) (*protov3.MultiGlobResponse, *types.Stats, *errors.Errors) {
+	logger := rs.logger.With(zap.String("type", "find"))
+	ctx, cancel := context.WithTimeout(ctx, rs.timeout.Find)
+	defer cancel()
+
+	result := types.NewServerFindResponse()
+	result.Server = rs.groupName
+	e := rs.run(ctx, logger, func(ctx context.Context, member types.ServerClient) (func(), *errors.Errors) {
+		r := types.NewServerFindResponse()
+		r.Server = member.Name()
+		r.Response, r.Stats, r.Err = member.Find(ctx, request)
+		return func() {
+			r.Err = nil
+			result.Merge(r)
+		}, r.Err
+	})
+	if e != nil && e.HaveFatalErrors {
+		return nil, result.Stats, e
+	}
+	return result.Response, result.Stats, e
+}
+
+func (rs *ReplicaSet) Info(ctx context.Context, request *protov3.MultiMetricsInfoRequest) (*protov3.ZipperInfoResponse, *types.Stats, *errors.Errors) {
+	logger := rs.logger.With(zap.String("type", "info"))
+	ctx, cancel := context.WithTimeout(ctx, rs.timeout.Find)
+	defer cancel()
+
+	result := types.NewServerInfoResponse()
+	result.Server = rs.groupName
+	e := rs.run(ctx, logger, func(ctx context.Context, member types.ServerClient) (func(), *errors.Errors) {
+		r := types.NewServerInfoResponse()
+		r.Server = member.Name()
+		r.Response, r.Stats, r.Err = member.Info(ctx, request)
+		return func() {
+			r.Err = nil
+			result.Merge(r)
+		}, r.Err
+	})
+	if e != nil && e.HaveFatalErrors {
+		return nil, result.Stats, e
+	}
+	return result.Response, result.Stats, e
+}
+
+func (rs *ReplicaSet) List(ctx context.Context) (*protov3.ListMetricsResponse, *types.Stats, *errors.Errors) {
+	logger := rs.logger.With(zap.String("type", "list"))
+	ctx, cancel := context.WithTimeout(ctx, rs.timeout.Render)
+	defer cancel()
+
+	result := &protov3.ListMetricsResponse{}
+	stats := &types.Stats{}
+	seen := make(map[string]struct{})
+	e := rs.run(ctx, logger, func(ctx context.Context, member types.ServerClient) (func(), *errors.Errors) {
+		res, s, err := member.List(ctx)
+		return func() {
+			if s != nil {
+				stats.Merge(s)
+			}
+			if res == nil {
+				return
+			}
+			for _, m := range res.Metrics {
+				if _, ok := seen[m]; !ok {
+					seen[m] = struct{}{}
+					result.Metrics = append(result.Metrics, m)
+				}
+			}
+		}, err
+	})
+	if e != nil && e.HaveFatalErrors {
+		return nil, stats, e
+	}
+	return result, stats, e
+}
+
+func (rs *ReplicaSet) Stats(ctx context.Context) (*protov3.MetricDetailsResponse, *types.Stats, *errors.Errors) {
+	return nil, nil, errors.FromErr(types.ErrNotImplementedYet)
+}
+
+func (rs *ReplicaSet) ProbeTLDs(ctx context.Context) ([]string, *errors.Errors) {
+	logger := rs.logger.With(zap.String("function", "prober"))
+	ctx, cancel := context.WithTimeout(ctx, rs.timeout.Find)
+	defer cancel()
+
+	var tlds []string
+	seen := make(map[string]struct{})
+	e := rs.run(ctx, logger, func(ctx context.Context, member types.ServerClient) (func(), *errors.Errors) {
+		res, err := member.ProbeTLDs(ctx)
+		return func() {
+			for _, tld := range res {
+				if _, ok := seen[tld]; !ok {
+					seen[tld] = struct{}{}
+					tlds = append(tlds, tld)
+				}
+			}
+		}, err
+	})
+	return tlds, e
+}
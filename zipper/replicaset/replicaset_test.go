@@ -0,0 +1,192 @@
+package replicaset
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-graphite/carbonapi/zipper/dummy"
+	"github.com/go-graphite/carbonapi/zipper/errors"
+	"github.com/go-graphite/carbonapi/zipper/types"
+	protov3 "github.com/go-graphite/protocol/carbonapi_v3_pb"
+
+	"go.uber.org/zap"
+)
+
+var timeouts = types.Timeouts{
+	Find:   time.Second,
+	Render: time.Second,
+}
+
+func newMembers(request *protov3.MultiFetchRequest, failed ...bool) []types.ServerClient {
+	var members []types.ServerClient
+	for i, f := range failed {
+		name := string(rune('a' + i))
+		c := dummy.NewDummyClient(name, []string{name}, 0)
+		if f {
+			c.AddFetchResponse(request, nil, &types.Stats{}, errors.Fatal("failed"))
+		} else {
+			c.AddFetchResponse(request, &protov3.MultiFetchResponse{
+				Metrics: []protov3.FetchResponse{{Name: "foo", PathExpression: "foo", StopTime: 120, StepTime: 60, Values: []float64{float64(i), 1}}},
+			}, &types.Stats{}, nil)
+		}
+		members = append(members, c)
+	}
+	return members
+}
+
+func TestReplicaSetFailover(t *testing.T) {
+	request := &protov3.MultiFetchRequest{Metrics: []protov3.FetchRequest{{Name: "foo", PathExpression: "foo", StopTime: 120}}}
+
+	// every member is tried first in turn, failed ones are replaced by the next one
+	rs, _ := New(zap.NewNop(), "test", newMembers(request, true, false, true), 1, timeouts)
+	for i := 0; i < 3; i++ {
+		res, _, err := rs.Fetch(context.Background(), request)
+		if (err != nil && err.HaveFatalErrors) || len(res.Metrics) != 1 || res.Metrics[0].Values[0] != 1 {
+			t.Fatalf("request should fail over to the alive member, got %+v, %v", res, err)
+		}
+	}
+
+	rs, _ = New(zap.NewNop(), "test", newMembers(request, false, true, false), 2, timeouts)
+	res, _, err := rs.Fetch(context.Background(), request)
+	if (err != nil && err.HaveFatalErrors) || len(res.Metrics) != 1 {
+		t.Fatalf("expected response of 2 members, got %+v, %v", res, err)
+	}
+
+	rs, _ = New(zap.NewNop(), "test", newMembers(request, false, true, true), 2, timeouts)
+	_, _, err = rs.Fetch(context.Background(), request)
+	if err == nil || !err.HaveFatalErrors {
+		t.Fatalf("fetch should fail if less than replicas members answered, got %v", err)
+	}
+
+	if c := rs.Children(); len(c) != 1 || c[0] != rs {
+		t.Fatalf("members must not be exposed as children")
+	}
+}
+
+func TestReplicaSetNotFound(t *testing.T) {
+	request := &protov3.MultiFetchRequest{Metrics: []protov3.FetchRequest{{Name: "foo", PathExpression: "foo", StopTime: 120}}}
+	c := dummy.NewDummyClient("a", []string{"a"}, 0)
+	c.AddFetchResponse(request, nil, &types.Stats{}, errors.FromErrNonFatal(types.ErrNotFound))
+	d := dummy.NewDummyClient("b", []string{"b"}, 0)
+
+	rs, _ := New(zap.NewNop(), "test", []types.ServerClient{c, d}, 1, timeouts)
+	for i := 0; i < 2; i++ {
+		_, _, err := rs.Fetch(context.Background(), request)
+		if !types.IsNotFound(err) {
+			t.Fatalf("expected not found, got %v", err)
+		}
+	}
+}
+
+func TestReplicaSetWeights(t *testing.T) {
+	request := &protov3.MultiFetchRequest{Metrics: []protov3.FetchRequest{{Name: "foo", PathExpression: "foo", StopTime: 120}}}
+
+	// local server c is always tried first, remote ones only if it fails
+	rs, _ := New(zap.NewNop(), "test", newMembers(request, false, false, false), 1, timeouts)
+	err := rs.SetWeights([]types.ServerWeight{
+		{Server: "a", Priority: 1},
+		{Server: "b", Priority: 1, Weight: 3},
+	})
+	if err != nil {
+		t.Fatalf("failed to set weights: %v", err)
+	}
+	firsts := make(map[string]int)
+	for i := 0; i < 8; i++ {
+		order := rs.order()
+		if order[0].Name() != "c" {
+			t.Fatalf("server with lowest priority should be tried first, got %v", order[0].Name())
+		}
+		firsts[order[1].Name()]++
+	}
+	if firsts["a"] != 2 || firsts["b"] != 6 {
+		t.Fatalf("servers should be tried first according to weights, got %v", firsts)
+	}
+
+	if err := rs.SetWeights([]types.ServerWeight{{Server: "d"}}); err == nil {
+		t.Fatalf("weight of unknown server should be an error")
+	}
+}
+
+func TestReplicaSetFailoverTimeout(t *testing.T) {
+	request := &protov3.MultiFetchRequest{Metrics: []protov3.FetchRequest{{Name: "foo", PathExpression: "foo", StopTime: 120}}}
+	members := newMembers(request, false)
+	slow := dummy.NewDummyClientWithTimeout("slow", []string{"slow"}, 0, time.Second)
+
+	rs, _ := New(zap.NewNop(), "test", []types.ServerClient{slow, members[0]}, 1, timeouts)
+	if err := rs.SetWeights([]types.ServerWeight{{Server: "a", Priority: 1}}); err != nil {
+		t.Fatalf("failed to set weights: %v", err)
+	}
+	rs.SetFailoverTimeout(50 * time.Millisecond)
+
+	start := time.Now()
+	res, _, err := rs.Fetch(context.Background(), request)
+	if (err != nil && err.HaveFatalErrors) || len(res.Metrics) != 1 {
+		t.Fatalf("request should fail over to the next server, got %+v, %v", res, err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Fatalf("next server should be tried after failover timeout, request took %v", elapsed)
+	}
+}
+
+func TestReplicaSetPreferFastest(t *testing.T) {
+	request := &protov3.MultiFetchRequest{Metrics: []protov3.FetchRequest{{Name: "foo", PathExpression: "foo", StopTime: 120}}}
+
+	rs, _ := New(zap.NewNop(), "test", newMembers(request, false, false, true), 1, timeouts)
+	if err := rs.SetWeights([]types.ServerWeight{{Server: "c", Priority: 1}}); err != nil {
+		t.Fatalf("failed to set weights: %v", err)
+	}
+	rs.SetPreferFastest(true)
+	now := time.Now()
+	rs.latency.now = func() time.Time { return now }
+
+	members := rs.order()
+	byName := make(map[string]types.ServerClient)
+	for _, m := range members {
+		byName[m.Name()] = m
+	}
+	rs.latency.observe(byName["a"], 150*time.Millisecond, false)
+	rs.latency.observe(byName["b"], 100*time.Millisecond, false)
+	rs.latency.observe(byName["c"], time.Millisecond, true)
+	for i := 0; i < 4; i++ {
+		if names := memberNames(rs.order()); names != "b,a,c" {
+			t.Fatalf("fastest server of the highest priority should be tried first, got %v", names)
+		}
+	}
+
+	// failure makes the server slower than the others
+	rs.latency.observe(byName["b"], 50*time.Millisecond, true)
+	if names := memberNames(rs.order()); names != "a,b,c" {
+		t.Fatalf("failed server should be tried after the faster one, got %v", names)
+	}
+	if e := rs.latency.estimate(byName["b"]); e != 200*time.Millisecond {
+		t.Fatalf("failure should double the estimate, got %v", e)
+	}
+
+	// estimate of the server that isn't used goes down, so it's tried again
+	now = now.Add(latencyHalfLife)
+	if e := rs.latency.estimate(byName["b"]); e != 100*time.Millisecond {
+		t.Fatalf("estimate should be halved after half-life, got %v", e)
+	}
+	rs.latency.observe(byName["a"], 400*time.Millisecond, false)
+	if names := memberNames(rs.order()); names != "b,a,c" {
+		t.Fatalf("slower server should be tried after the faster one, got %v", names)
+	}
+
+	// real requests are measured
+	if _, _, err := rs.Fetch(context.Background(), request); err != nil && err.HaveFatalErrors {
+		t.Fatalf("request should succeed, got %v", err)
+	}
+	if e := rs.latency.estimate(byName["b"]); e >= 100*time.Millisecond {
+		t.Fatalf("fast response should lower the estimate, got %v", e)
+	}
+}
+
+func memberNames(members []types.ServerClient) string {
+	names := make([]string, 0, len(members))
+	for _, m := range members {
+		names = append(names, m.Name())
+	}
+	return strings.Join(names, ",")
+}
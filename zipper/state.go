@@ -0,0 +1,126 @@
+package zipper
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/go-graphite/carbonapi/zipper/broadcast"
+	"go.uber.org/zap"
+)
+
+// stateVersion is the version of statePersistence.file format. Files of other versions are ignored, it has to be
+// changed when saved statistics can't be read the same way anymore.
+const stateVersion = 1
+
+// savedState is the content of statePersistence.file
+type savedState struct {
+	Version int       `json:"version"`
+	SavedAt time.Time `json:"saved_at"`
+	// Checksum is CRC32 of Groups, so file that was damaged isn't restored
+	Checksum uint32          `json:"checksum"`
+	Groups   json.RawMessage `json:"groups"`
+}
+
+// stateGroups returns groups that learn statistics about backends by their role
+func (z *Zipper) stateGroups() map[string]*broadcast.BroadcastGroup {
+	groups := make(map[string]*broadcast.BroadcastGroup)
+	if bg, ok := z.storeBackends.(*broadcast.BroadcastGroup); ok {
+		groups["store"] = bg
+	}
+	if bg, ok := z.searchBackends.(*broadcast.BroadcastGroup); ok {
+		groups["search"] = bg
+	}
+	return groups
+}
+
+// SaveState writes statistics of backends to the file, so they can be restored after restart
+func (z *Zipper) SaveState() error {
+	if z.statePersistence.File == "" {
+		return nil
+	}
+	groups := make(map[string]broadcast.GroupState)
+	for name, bg := range z.stateGroups() {
+		groups[name] = bg.State()
+	}
+	g, err := json.Marshal(groups)
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(savedState{
+		Version:  stateVersion,
+		SavedAt:  time.Now(),
+		Checksum: crc32.ChecksumIEEE(g),
+		Groups:   g,
+	})
+	if err != nil {
+		return err
+	}
+
+	// file is replaced at once, so it's never read half-written
+	f, err := ioutil.TempFile(filepath.Dir(z.statePersistence.File), filepath.Base(z.statePersistence.File)+".")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(b)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), z.statePersistence.File)
+	}
+	if err != nil {
+		_ = os.Remove(f.Name())
+	}
+	return err
+}
+
+// loadState restores statistics of backends saved by previous instance, unless they are too old or were saved in
+// other format. File that is damaged is an error, nothing is restored from it.
+func (z *Zipper) loadState() error {
+	b, err := ioutil.ReadFile(z.statePersistence.File)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var state savedState
+	if err := json.Unmarshal(b, &state); err != nil {
+		return fmt.Errorf("corrupted file: %v", err)
+	}
+	if state.Version != stateVersion {
+		z.logger.Info("saved backends statistics are of other version, ignoring them",
+			zap.Int("version", state.Version),
+			zap.Int("supported_version", stateVersion),
+		)
+		return nil
+	}
+	if crc32.ChecksumIEEE(state.Groups) != state.Checksum {
+		return fmt.Errorf("corrupted file: checksum mismatch")
+	}
+	if age := time.Since(state.SavedAt); age > z.statePersistence.MaxAge {
+		z.logger.Info("saved backends statistics are too old, ignoring them",
+			zap.Duration("age", age),
+		)
+		return nil
+	}
+	var groups map[string]broadcast.GroupState
+	if err := json.Unmarshal(state.Groups, &groups); err != nil {
+		return fmt.Errorf("corrupted file: %v", err)
+	}
+
+	for name, bg := range z.stateGroups() {
+		if s, ok := groups[name]; ok {
+			bg.RestoreState(s)
+		}
+	}
+	return nil
+}
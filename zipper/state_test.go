@@ -0,0 +1,136 @@
+package zipper
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/go-graphite/carbonapi/zipper/broadcast"
+	"github.com/go-graphite/carbonapi/zipper/dummy"
+	"github.com/go-graphite/carbonapi/zipper/types"
+	"go.uber.org/zap"
+)
+
+func newStateTestZipper(t *testing.T, file string, maxAge time.Duration) (*Zipper, *broadcast.BroadcastGroup) {
+	timeouts := types.Timeouts{
+		Find:               time.Second,
+		Render:             time.Second,
+		Connect:            time.Second,
+		AfterFirstResponse: types.AfterFirstResponse{Max: time.Second},
+	}
+	c := dummy.NewDummyClient("client1", []string{"backend1"}, 1)
+	group, e := broadcast.NewBroadcastGroup(zap.NewNop(), "root", []types.ServerClient{c}, 60, 10, timeouts)
+	if e != nil && e.HaveFatalErrors {
+		t.Fatalf("error while initializing group: %v", e)
+	}
+	return &Zipper{
+		storeBackends:    group,
+		statePersistence: types.StatePersistence{File: file, MaxAge: maxAge},
+		logger:           zap.NewNop(),
+	}, group
+}
+
+func TestSaveState(t *testing.T) {
+	dir, err := ioutil.TempDir("", "zipper-state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "state.json")
+
+	z, group := newStateTestZipper(t, file, time.Hour)
+	// missing file is not an error, there is just nothing to restore
+	if err := z.loadState(); err != nil {
+		t.Fatal(err)
+	}
+
+	state := broadcast.GroupState{
+		Health:        map[string]float64{"client1": 0.25},
+		LatencySpread: []time.Duration{3 * time.Millisecond, time.Millisecond, 2 * time.Millisecond},
+		Paths:         map[string][]string{"foo": {"client1"}},
+	}
+	group.RestoreState(state)
+	if err := z.SaveState(); err != nil {
+		t.Fatal(err)
+	}
+
+	restarted, restartedGroup := newStateTestZipper(t, file, time.Hour)
+	if err := restarted.loadState(); err != nil {
+		t.Fatal(err)
+	}
+	if got := restartedGroup.State(); !reflect.DeepEqual(got, state) {
+		t.Fatalf("unexpected restored state %+v, expected %+v", got, state)
+	}
+
+	// long downtime, saved statistics don't describe backends anymore
+	time.Sleep(10 * time.Millisecond)
+	stale, staleGroup := newStateTestZipper(t, file, time.Millisecond)
+	if err := stale.loadState(); err != nil {
+		t.Fatal(err)
+	}
+	if got := staleGroup.State(); len(got.Health) != 0 || len(got.LatencySpread) != 0 || len(got.Paths) != 0 {
+		t.Fatalf("stale state is restored: %+v", got)
+	}
+}
+
+func TestLoadStatePaths(t *testing.T) {
+	dir, err := ioutil.TempDir("", "zipper-state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "state.json")
+
+	// servers that were removed from config are skipped
+	z, group := newStateTestZipper(t, file, time.Hour)
+	group.RestoreState(broadcast.GroupState{
+		Paths: map[string][]string{"foo": {"client1", "client2"}, "bar": {"client2"}},
+	})
+	if err := z.SaveState(); err != nil {
+		t.Fatal(err)
+	}
+	restarted, restartedGroup := newStateTestZipper(t, file, time.Hour)
+	if err := restarted.loadState(); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string][]string{"foo": {"client1"}}
+	if got := restartedGroup.State().Paths; !reflect.DeepEqual(got, expected) {
+		t.Fatalf("unexpected restored paths %+v, expected %+v", got, expected)
+	}
+
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range map[string][]byte{
+		"truncated": b[:len(b)/2],
+		"damaged":   bytes.Replace(b, []byte("client1"), []byte("client9"), 1),
+	} {
+		if err := ioutil.WriteFile(file, content, 0644); err != nil {
+			t.Fatal(err)
+		}
+		z, group := newStateTestZipper(t, file, time.Hour)
+		if err := z.loadState(); err == nil {
+			t.Fatalf("%v file should be an error", name)
+		}
+		if got := group.State(); len(got.Paths) != 0 {
+			t.Fatalf("%v file is restored: %+v", name, got)
+		}
+	}
+
+	// file of other version is ignored
+	if err := ioutil.WriteFile(file, bytes.Replace(b, []byte(`"version":1`), []byte(`"version":2`), 1), 0644); err != nil {
+		t.Fatal(err)
+	}
+	other, otherGroup := newStateTestZipper(t, file, time.Hour)
+	if err := other.loadState(); err != nil {
+		t.Fatal(err)
+	}
+	if got := otherGroup.State(); len(got.Paths) != 0 {
+		t.Fatalf("file of other version is restored: %+v", got)
+	}
+}
@@ -1,6 +1,7 @@
 package types
 
 import (
+	"encoding/json"
 	"time"
 )
 
@@ -12,6 +13,21 @@ type BackendsV2 struct {
 	KeepAliveInterval         time.Duration `mapstructure:"keepAliveInterval"`
 	MaxTries                  int           `mapstructure:"maxTries"`
 	MaxBatchSize              int           `mapstructure:"maxBatchSize"`
+	Transport                 Transport     `mapstructure:"transport"`
+}
+
+// Transport tunes http connections to the servers of the group
+type Transport struct {
+	// IdleConnTimeout closes keep-alive connections that weren't used for that long, 0 means no limit
+	IdleConnTimeout time.Duration `mapstructure:"idleConnTimeout"`
+	// MaxConnsPerHost limits amount of connections to the server including active ones, 0 means no limit
+	MaxConnsPerHost int `mapstructure:"maxConnsPerHost"`
+	// ResponseHeaderTimeout limits time to wait for response headers after request was sent, 0 means no limit
+	ResponseHeaderTimeout time.Duration `mapstructure:"responseHeaderTimeout"`
+	// DisableCompression stops asking backends for gzip or deflate responses, e.x. if they are in the same network
+	DisableCompression bool `mapstructure:"disableCompression"`
+	// ForceAttemptHTTP2 enables HTTP/2 for https servers, so requests are multiplexed over the single connection
+	ForceAttemptHTTP2 bool `mapstructure:"forceAttemptHTTP2"`
 }
 
 type BackendV2 struct {
@@ -23,8 +39,91 @@ type BackendV2 struct {
 	ConcurrencyLimit    *int           `mapstructure:"concurrencyLimit"`
 	KeepAliveInterval   *time.Duration `mapstructure:"keepAliveInterval"`
 	MaxIdleConnsPerHost *int           `mapstructure:"maxIdleConnsPerHost"`
+	Transport           *Transport     `mapstructure:"transport"`
 	MaxTries            *int           `mapstructure:"maxTries"`
 	MaxBatchSize        int            `mapstructure:"maxBatchSize"`
+	// FallbackProtocol is used for the server instead of Protocol for FallbackDuration, after FallbackAfter
+	// responses in a row failed to decode
+	FallbackProtocol string        `mapstructure:"fallbackProtocol"`
+	FallbackAfter    int           `mapstructure:"fallbackAfter"`
+	FallbackDuration time.Duration `mapstructure:"fallbackDuration"`
+	// Quorum is minimal amount of servers of the broadcast group that must answer successfully
+	Quorum int `mapstructure:"quorum"`
+	// Templates map graphite paths to Prometheus labels, they are used by prometheus protocol only
+	Templates []string `mapstructure:"templates"`
+	// Step is the resolution of returned series for backends that don't store series with fixed step, prometheus
+	// and opentsdb protocols
+	Step time.Duration `mapstructure:"step"`
+	// Aggregator merges series of OpenTSDB metric with different tags, opentsdb protocol only
+	Aggregator string `mapstructure:"aggregator"`
+	// DataDir is the directory with whisper files, local protocol only
+	DataDir string `mapstructure:"dataDir"`
+	// Auth are credentials that are sent to the servers of the group
+	Auth BackendAuth `mapstructure:"auth"`
+	// TLS configures https (and gRPC) connections to the servers of the group
+	TLS BackendTLS `mapstructure:"tls"`
+	// Hashing makes broadcast group fetch metrics without globs only from the servers that own them
+	Hashing Hashing `mapstructure:"hashing"`
+	// Weights set the order in which servers of replicaset group are tried, servers that aren't listed have
+	// priority 0 and weight 1
+	Weights []ServerWeight `mapstructure:"weights"`
+	// FailoverTimeout is how long replicaset group waits for the server before it tries the next one as well,
+	// 0 means it's tried only if the server fails
+	FailoverTimeout time.Duration `mapstructure:"failoverTimeout"`
+	// PreferFastest makes replicaset group try servers of the same priority from the fastest one, by their recent
+	// response times, instead of rotating them by weights
+	PreferFastest bool `mapstructure:"preferFastest"`
+}
+
+// ServerWeight is the preference of the server. Servers with lower priority are tried first, e.x. the ones in the
+// local DC. Weight is the share of requests that start with the server among the ones with the same priority.
+type ServerWeight struct {
+	Server   string `mapstructure:"server"`
+	Priority int    `mapstructure:"priority"`
+	Weight   int    `mapstructure:"weight"`
+}
+
+// Hashing is consistent hashing that carbon-relay uses to distribute metrics between servers of the group
+type Hashing struct {
+	// Type is carbon_ch, fnv1a_ch or jump_fnv1a_ch. Empty disables hashing
+	Type string `mapstructure:"type"`
+	// ReplicationFactor is amount of servers that get every metric
+	ReplicationFactor int `mapstructure:"replicationFactor"`
+	// Destinations are relay destinations (host:port:instance) of the servers in the same order
+	Destinations []string `mapstructure:"destinations"`
+}
+
+// BackendTLS is TLS config of the group. CAFile replaces system CA bundle, client certificate is presented to the
+// servers if CertFile and KeyFile are set (mutual TLS).
+type BackendTLS struct {
+	CAFile             string `mapstructure:"caFile"`
+	CertFile           string `mapstructure:"certFile"`
+	KeyFile            string `mapstructure:"keyFile"`
+	ServerName         string `mapstructure:"serverName"`
+	InsecureSkipVerify bool   `mapstructure:"insecureSkipVerify"`
+}
+
+// BackendAuth are credentials of the group, e.x. when its servers are behind auth proxy. Basic auth is used if User
+// is set, bearer token otherwise. Secrets can be read from files on start, so they aren't kept in the config.
+type BackendAuth struct {
+	User            string `mapstructure:"user"`
+	Password        string `mapstructure:"password"`
+	PasswordFile    string `mapstructure:"passwordFile"`
+	BearerToken     string `mapstructure:"bearerToken"`
+	BearerTokenFile string `mapstructure:"bearerTokenFile"`
+}
+
+// MarshalJSON hides secrets, as config is logged and exported via expvar
+func (a BackendAuth) MarshalJSON() ([]byte, error) {
+	type auth BackendAuth
+	hidden := auth(a)
+	if hidden.Password != "" {
+		hidden.Password = "<hidden>"
+	}
+	if hidden.BearerToken != "" {
+		hidden.BearerToken = "<hidden>"
+	}
+	return json.Marshal(hidden)
 }
 
 func (b *BackendV2) FillDefaults() {
@@ -0,0 +1,19 @@
+package types
+
+import "time"
+
+// Carbonlink configures queries of the points that carbon-cache or go-carbon haven't written to disk yet
+type Carbonlink struct {
+	// Servers are carbonlink addresses (host:port, usually port 7002). Every series is asked from the servers that
+	// own it according to Hashing and their points are merged. Empty disables the queries
+	Servers []string `mapstructure:"servers"`
+	// Hashing is the one carbon-relay uses to distribute metrics between the caches, carbon_ch by default as in
+	// graphite-web. Destinations, if set, are host:port:instance of the caches in the same order as Servers
+	Hashing Hashing `mapstructure:"hashing"`
+	// MaxIdleConns is amount of idle connections kept open to every server
+	MaxIdleConns int `mapstructure:"maxIdleConns"`
+	// Timeout of the queries of the single request
+	Timeout time.Duration `mapstructure:"timeout"`
+	// Lookback limits queries to series that end within this time, caches don't have older points
+	Lookback time.Duration `mapstructure:"lookback"`
+}
@@ -2,7 +2,9 @@ package types
 
 import (
 	"errors"
+	"fmt"
 
+	zerrors "github.com/go-graphite/carbonapi/zipper/errors"
 	"github.com/golang/protobuf/ptypes/empty"
 )
 
@@ -16,7 +18,65 @@ var ErrNotFound = errors.New("metric not found")
 var ErrNoResponseFetched = errors.New("no responses fetched from upstream")
 var ErrNoMetricsFetched = errors.New("no metrics in the Response")
 var ErrMaxTriesExceeded = errors.New("max tries exceeded")
+var ErrRetryBudgetExhausted = errors.New("retry budget exhausted")
+var ErrTooManyBackends = errors.New("request exceeds maximum amount of backends")
+var ErrServerQuarantined = errors.New("all servers are quarantined because of decode errors")
+var ErrResponseTooLarge = errors.New("response exceeds maximum response size")
+var ErrPartialResponse = errors.New("some of the backends failed, partial response refused")
+var ErrQuorumNotReached = errors.New("not enough backends answered")
 
 var ErrFailedToFetchFmt = "failed to fetch data from server group %v, code %v, body %v"
 
 var EmptyMsg = &empty.Empty{}
+
+// ErrFormatRejected is the error of the request that server answered with 400 or 406, e.x. because it doesn't
+// support requested format yet. Such request can be retried with another protocol.
+type ErrFormatRejected struct {
+	Group  string
+	Server string
+	Code   int
+	Body   string
+}
+
+func (e *ErrFormatRejected) Error() string {
+	return fmt.Sprintf(ErrFailedToFetchFmt, e.Group, e.Code, e.Body)
+}
+
+// IsFormatRejected returns true if any of the servers has rejected the request
+func IsFormatRejected(e *zerrors.Errors) bool {
+	if e == nil {
+		return false
+	}
+	for _, err := range e.Errors {
+		if _, ok := err.(*ErrFormatRejected); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// HaveFailures returns true if some of the backends have failed, metrics that were not found are not failures
+func HaveFailures(e *zerrors.Errors) bool {
+	if e == nil {
+		return false
+	}
+	for _, err := range e.Errors {
+		if err != ErrNotFound {
+			return true
+		}
+	}
+	return false
+}
+
+// IsNotFound returns true if backend have reported that metric doesn't exist and there were no other errors
+func IsNotFound(e *zerrors.Errors) bool {
+	if e == nil || len(e.Errors) == 0 {
+		return false
+	}
+	for _, err := range e.Errors {
+		if err != ErrNotFound {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,47 @@
+package types
+
+import (
+	"context"
+
+	protov3 "github.com/go-graphite/protocol/carbonapi_v3_pb"
+)
+
+// Reasons why request is sent to the backend, the last step that narrowed the backends down wins
+const (
+	// ReasonBroadcast means that every backend is asked, globs are resolved with find request first
+	ReasonBroadcast = "broadcast"
+	// ReasonAllowedBackends means that request is restricted to some of the backends, e.x. by tenant
+	ReasonAllowedBackends = "allowed backends"
+	// ReasonFailover means that primary cluster is unavailable and backend is in the standby one
+	ReasonFailover = "failover"
+	// ReasonRoute means that metric is under prefix of the routing table
+	ReasonRoute = "routing table"
+	// ReasonStorageTier means that backend has requested time range
+	ReasonStorageTier = "storage tier"
+	// ReasonCacheHit means that backend is known to have top-level node of the metric
+	ReasonCacheHit = "cache hit"
+	// ReasonHashing means that backend owns the metric according to consistent hashing
+	ReasonHashing = "hashing"
+	// ReasonSearch means that metric is resolved by carbonsearch
+	ReasonSearch = "carbonsearch"
+)
+
+// Explanation tells which backends request for the metric would be sent to and why
+type Explanation struct {
+	Metric   string             `json:"metric"`
+	Backends []ExplainedBackend `json:"backends"`
+}
+
+// ExplainedBackend is the backend that request would be sent to, for the time range
+type ExplainedBackend struct {
+	Name    string   `json:"name"`
+	Servers []string `json:"servers"`
+	Reason  string   `json:"reason"`
+	From    int64    `json:"from"`
+	Until   int64    `json:"until"`
+}
+
+// Explainer is implemented by groups that can tell how request would be routed without sending it
+type Explainer interface {
+	Explain(ctx context.Context, request *protov3.MultiFetchRequest) []Explanation
+}
@@ -0,0 +1,19 @@
+package types
+
+// Failover sends requests to the primary cluster of backend groups while it's available, and to the first
+// available standby cluster otherwise. Groups that aren't in any cluster are always used.
+type Failover struct {
+	Primary FailoverCluster   `mapstructure:"primary"`
+	Standby []FailoverCluster `mapstructure:"standby"`
+	// Threshold is minimal availability of the cluster, average health score of its servers (share of good
+	// responses, recent ones weigh more), between 0 and 1
+	Threshold float64 `mapstructure:"threshold"`
+}
+
+// FailoverCluster is set of backend groups, e.x. the ones in the same datacenter
+type FailoverCluster struct {
+	// Name is used in logs and metrics, default is "primary" and "standby1", "standby2" and so on
+	Name string `mapstructure:"name"`
+	// Backends are names of the backend groups (groupName) of the cluster
+	Backends []string `mapstructure:"backends"`
+}
@@ -2,6 +2,7 @@ package types
 
 import (
 	"context"
+	"net/http"
 
 	"github.com/go-graphite/carbonapi/zipper/errors"
 	protov3 "github.com/go-graphite/protocol/carbonapi_v3_pb"
@@ -12,6 +13,13 @@ type Request interface {
 	LogInfo() interface{}
 }
 
+// HTTPRequest is a Request that has to be sent with its own method and headers instead of plain GET
+type HTTPRequest interface {
+	Request
+	Method() string
+	Header() http.Header
+}
+
 type ServerClient interface {
 	Name() string
 	Backends() []string
@@ -29,6 +37,13 @@ type ServerClient interface {
 	Children() []ServerClient
 }
 
+// PassthroughFetcher is implemented by clients that can return carbonapi_v2_pb response of the backend as is,
+// without decoding it
+type PassthroughFetcher interface {
+	// FetchPassthrough returns nil response without errors if it's not possible for the request
+	FetchPassthrough(ctx context.Context, request *protov3.MultiFetchRequest) ([]byte, *Stats, *errors.Errors)
+}
+
 /*
 type Fetcher interface {
 	// PB-compatible methods
@@ -13,6 +13,8 @@ type LBMethod int
 const (
 	RoundRobinLB LBMethod = iota
 	BroadcastLB
+	// ReplicaSetLB sends request to quorum of the servers and replaces the ones that fail with the others
+	ReplicaSetLB
 )
 
 func (p LBMethod) keys(m map[string]LBMethod) []string {
@@ -29,6 +31,8 @@ var supportedLBMethods = map[string]LBMethod{
 	"any":        RoundRobinLB,
 	"broadcast":  BroadcastLB,
 	"all":        BroadcastLB,
+	"replicaset": ReplicaSetLB,
+	"replicas":   ReplicaSetLB,
 }
 
 func (m *LBMethod) FromString(method string) error {
@@ -60,6 +64,8 @@ func (m LBMethod) MarshalJSON() ([]byte, error) {
 		return json.Marshal("RoundRobin")
 	case BroadcastLB:
 		return json.Marshal("Broadcast")
+	case ReplicaSetLB:
+		return json.Marshal("ReplicaSet")
 	}
 
 	return nil, fmt.Errorf(ErrUnknownLBMethodFmt, m, m.keys(supportedLBMethods))
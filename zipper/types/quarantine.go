@@ -0,0 +1,14 @@
+package types
+
+import (
+	"time"
+)
+
+// DecodeErrorQuarantine is a global structure that contains configuration for quarantine of the servers that send
+// responses that can't be decoded
+type DecodeErrorQuarantine struct {
+	// Threshold is amount of consecutive decode errors after which server is quarantined. 0 disables quarantine
+	Threshold int `mapstructure:"threshold"`
+	// Duration is for how long server is not queried. After that one request is sent to check if it's fixed
+	Duration time.Duration `mapstructure:"duration"`
+}
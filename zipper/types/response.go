@@ -1,11 +1,15 @@
 package types
 
 import (
+	"context"
 	"math"
+	"strings"
+	"sync/atomic"
 
+	util "github.com/go-graphite/carbonapi/util/ctx"
+	"github.com/go-graphite/carbonapi/util/instance"
 	"github.com/go-graphite/carbonapi/zipper/errors"
 	protov3 "github.com/go-graphite/protocol/carbonapi_v3_pb"
-	"github.com/lomik/zapwriter"
 	"go.uber.org/zap"
 )
 
@@ -79,12 +83,19 @@ func (first *ServerFindResponse) Merge(second *ServerFindResponse) *errors.Error
 		return first.Err
 	}
 
+	// Same path can be a leaf on one backend and a branch on another (or both on the same one, e.x. whisper file
+	// next to the directory with the same name). Both matches are kept, so clients can fetch the metric and still
+	// expand the branch.
+	type matchKey struct {
+		path   string
+		isLeaf bool
+	}
 	seenMetrics := make(map[string]int)
-	seenMatches := make(map[string]struct{})
+	seenMatches := make(map[matchKey]struct{})
 	for i, m := range first.Response.Metrics {
 		seenMetrics[m.Name] = i
 		for _, mm := range m.Matches {
-			seenMatches[m.Name+"."+mm.Path] = struct{}{}
+			seenMatches[matchKey{m.Name + "." + mm.Path, mm.IsLeaf}] = struct{}{}
 		}
 	}
 
@@ -97,7 +108,7 @@ func (first *ServerFindResponse) Merge(second *ServerFindResponse) *errors.Error
 		}
 
 		for _, mm := range m.Matches {
-			key := first.Response.Metrics[i].Name + "." + mm.Path
+			key := matchKey{first.Response.Metrics[i].Name + "." + mm.Path, mm.IsLeaf}
 			if _, ok := seenMatches[key]; !ok {
 				seenMatches[key] = struct{}{}
 				first.Response.Metrics[i].Matches = append(first.Response.Metrics[i].Matches, mm)
@@ -139,14 +150,113 @@ func swapFetchResponses(m1, m2 *protov3.FetchResponse) {
 	m1.StopTime, m2.StopTime = m2.StopTime, m1.StopTime
 }
 
-func mergeFetchResponsesWithEqualStepTimes(m1, m2 *protov3.FetchResponse, uuid string) error {
-	if m1.StartTime != m2.StartTime {
+// extendFetchResponse pads values with nulls, so series covers [start, end) time range. Bounds are rounded
+// to the closest points of the series outside of the range.
+func extendFetchResponse(m *protov3.FetchResponse, start, end, stop int64) {
+	step := m.StepTime
+	prepend := int64(0)
+	if start < m.StartTime {
+		prepend = (m.StartTime - start + step - 1) / step
+	}
+	length := int64(len(m.Values)) + prepend
+	if mEnd := m.StartTime + int64(len(m.Values))*step; end > mEnd {
+		length += (end - mEnd + step - 1) / step
+	}
+
+	if length != int64(len(m.Values)) {
+		values := make([]float64, length)
+		for i := range values {
+			values[i] = math.NaN()
+		}
+		copy(values[prepend:], m.Values)
+		m.Values = values
+		m.StartTime -= prepend * step
+	}
+	if stop > m.StopTime {
+		m.StopTime = stop
+	}
+}
+
+// seriesEnd returns timestamp right after the last point of the series
+func seriesEnd(m *protov3.FetchResponse) int64 {
+	return m.StartTime + int64(len(m.Values))*m.StepTime
+}
+
+// alignFetchResponses pads values of both series with nulls, so they cover the same time range. Series must have
+// the same step and their points must be on the same grid.
+func alignFetchResponses(m1, m2 *protov3.FetchResponse) error {
+	step := m1.StepTime
+	if step <= 0 || (m1.StartTime-m2.StartTime)%step != 0 {
 		return ErrResponseStartTimeMismatch
 	}
 
+	extendFetchResponse(m1, m2.StartTime, seriesEnd(m2), m2.StopTime)
+	extendFetchResponse(m2, m1.StartTime, seriesEnd(m1), m1.StopTime)
+	return nil
+}
+
+// mismatchedPoints is total amount of points that had different non-null values in merged responses
+var mismatchedPoints int64
+
+// MismatchedPoints returns amount of timestamps where replicas returned different non-null values. Growing value
+// usually means that replication between storage nodes is drifting.
+func MismatchedPoints() int64 {
+	return atomic.LoadInt64(&mismatchedPoints)
+}
+
+// countMismatches returns amount of points that are non-null in both series and have different values.
+// Series must be aligned.
+func countMismatches(m1, m2 *protov3.FetchResponse, uuid string) int {
+	mismatches := 0
+	first := -1
+	for i := 0; i < len(m1.Values) && i < len(m2.Values); i++ {
+		v1, v2 := m1.Values[i], m2.Values[i]
+		if math.IsNaN(v1) || math.IsNaN(v2) || v1 == v2 {
+			continue
+		}
+		if first == -1 {
+			first = i
+		}
+		mismatches++
+	}
+	if mismatches == 0 {
+		return 0
+	}
+
+	atomic.AddInt64(&mismatchedPoints, int64(mismatches))
+	instance.Logger("zipper_render").Debug("replicas returned different values",
+		zap.String("name", m1.Name),
+		zap.Int("mismatched_points", mismatches),
+		zap.Int64("first_mismatch", m1.StartTime+int64(first)*m1.StepTime),
+		zap.Float64("m1_value", m1.Values[first]),
+		zap.Float64("m2_value", m2.Values[first]),
+		zap.String("carbonapi_uuid", uuid),
+	)
+	return mismatches
+}
+
+func mergeFetchResponsesWithEqualStepTimes(m1, m2 *protov3.FetchResponse, opts MergeOptions) error {
+	if m1.StartTime != m2.StartTime {
+		// e.x. one of the backends was down for a while and doesn't have the beginning of the series
+		if err := alignFetchResponses(m1, m2); err != nil {
+			return err
+		}
+	}
+
 	if len(m1.Values) < len(m2.Values) {
 		swapFetchResponses(m1, m2)
 	}
+	countMismatches(m1, m2, opts.UUID)
+
+	if opts.Policy == MergePolicyNewest && lastNonNullIndex(m2.Values) > lastNonNullIndex(m1.Values) {
+		// m2 has more recent data, so its points are used where it has them
+		for i := 0; i < len(m2.Values); i++ {
+			if !math.IsNaN(m2.Values[i]) {
+				m1.Values[i] = m2.Values[i]
+			}
+		}
+		return nil
+	}
 
 	for i := 0; i < len(m2.Values); i++ {
 		if math.IsNaN(m1.Values[i]) {
@@ -157,38 +267,294 @@ func mergeFetchResponsesWithEqualStepTimes(m1, m2 *protov3.FetchResponse, uuid s
 	return nil
 }
 
-func mergeFetchResponsesWithUnequalStepTimes(m1, m2 *protov3.FetchResponse, uuid string) error {
+// lastNonNullIndex returns index of the last non-null value or -1 if all of them are null
+func lastNonNullIndex(values []float64) int {
+	for i := len(values) - 1; i >= 0; i-- {
+		if !math.IsNaN(values[i]) {
+			return i
+		}
+	}
+	return -1
+}
+
+// MergeOptions controls how fetch responses from different backends are merged together
+type MergeOptions struct {
+	UUID string
+	// ConsolidateBy is the function (avg, sum, min, max or last) that is used to aggregate points of the finer series
+	// when backends return different resolutions. If it's empty, finer series is kept as is.
+	ConsolidateBy string
+	// XFilesFactor is the minimal ratio of non-null points that is required to consolidate them into one.
+	// Otherwise consolidated point is null, the same way whisper does that.
+	XFilesFactor float32
+	// Policy defines which of the responses with the same resolution is used as a base, other one only fills
+	// its gaps. See MergePolicyFirst, MergePolicyHealthiest and MergePolicyNewest.
+	Policy string
+}
+
+const (
+	// MergePolicyFirst uses the response that was received first as a base
+	MergePolicyFirst = "first"
+	// MergePolicyHealthiest uses response of the backend with the best health score as a base
+	MergePolicyHealthiest = "healthiest"
+	// MergePolicyNewest uses response that has the most recent non-null point as a base
+	MergePolicyNewest = "newest"
+	// MergePolicyMajority replaces conflicting points with the value returned by the majority of replicas,
+	// if there are at least 3 of them, otherwise works the same way as MergePolicyHealthiest
+	MergePolicyMajority = "majority"
+)
+
+var defaultConsolidateBy string
+var defaultXFilesFactor float32
+var defaultMergePolicy = MergePolicyFirst
+
+// SetDefaultConsolidateBy sets consolidation function that is used when request doesn't specify one.
+// Empty value keeps finer series as is.
+func SetDefaultConsolidateBy(consolidateBy string) {
+	defaultConsolidateBy = consolidateBy
+}
+
+// SetDefaultXFilesFactor sets xFilesFactor that is used when request doesn't specify one
+func SetDefaultXFilesFactor(xFilesFactor float32) {
+	defaultXFilesFactor = xFilesFactor
+}
+
+// SetDefaultMergePolicy sets merge policy of the responses
+func SetDefaultMergePolicy(policy string) {
+	defaultMergePolicy = policy
+}
+
+// IsValidMergePolicy checks if merge policy is supported
+func IsValidMergePolicy(policy string) bool {
+	return policy == MergePolicyFirst || policy == MergePolicyHealthiest || policy == MergePolicyNewest || policy == MergePolicyMajority
+}
+
+// IsValidXFilesFactor checks if xFilesFactor is in [0, 1] range
+func IsValidXFilesFactor(xFilesFactor float32) bool {
+	return xFilesFactor >= 0 && xFilesFactor <= 1
+}
+
+// MergeOptionsFromContext returns merge options for current request
+func MergeOptionsFromContext(ctx context.Context) MergeOptions {
+	consolidateBy := util.GetConsolidateBy(ctx)
+	if consolidateBy == "" {
+		consolidateBy = defaultConsolidateBy
+	}
+	xFilesFactor, ok := util.GetXFilesFactor(ctx)
+	if !ok {
+		xFilesFactor = defaultXFilesFactor
+	}
+	return MergeOptions{
+		UUID:          util.GetUUID(ctx),
+		ConsolidateBy: consolidateBy,
+		XFilesFactor:  xFilesFactor,
+		Policy:        defaultMergePolicy,
+	}
+}
+
+var consolidationFunctions = map[string]func([]float64) float64{
+	"avg":  consolidateAvg,
+	"sum":  consolidateSum,
+	"min":  consolidateMin,
+	"max":  consolidateMax,
+	"last": consolidateLast,
+}
+
+// SeriesConsolidation returns function that consolidates points according to consolidation function of the series,
+// e.x. "average" or "sum". Points are averaged if it's unknown.
+func SeriesConsolidation(consolidationFunc string) func([]float64) float64 {
+	switch strings.ToLower(consolidationFunc) {
+	case "sum":
+		return consolidateSum
+	case "min", "minimum":
+		return consolidateMin
+	case "max", "maximum":
+		return consolidateMax
+	case "last":
+		return consolidateLast
+	}
+	return consolidateAvg
+}
+
+// IsValidConsolidation checks if consolidateBy value is supported
+func IsValidConsolidation(consolidateBy string) bool {
+	_, ok := consolidationFunctions[consolidateBy]
+	return ok
+}
+
+func consolidateAvg(values []float64) float64 {
+	sum := 0.0
+	cnt := 0
+	for _, v := range values {
+		if !math.IsNaN(v) {
+			sum += v
+			cnt++
+		}
+	}
+	if cnt == 0 {
+		return math.NaN()
+	}
+	return sum / float64(cnt)
+}
+
+func consolidateSum(values []float64) float64 {
+	sum := math.NaN()
+	for _, v := range values {
+		if math.IsNaN(v) {
+			continue
+		}
+		if math.IsNaN(sum) {
+			sum = v
+		} else {
+			sum += v
+		}
+	}
+	return sum
+}
+
+func consolidateMin(values []float64) float64 {
+	res := math.NaN()
+	for _, v := range values {
+		if !math.IsNaN(v) && (math.IsNaN(res) || v < res) {
+			res = v
+		}
+	}
+	return res
+}
+
+func consolidateMax(values []float64) float64 {
+	res := math.NaN()
+	for _, v := range values {
+		if !math.IsNaN(v) && (math.IsNaN(res) || v > res) {
+			res = v
+		}
+	}
+	return res
+}
+
+func consolidateLast(values []float64) float64 {
+	for i := len(values) - 1; i >= 0; i-- {
+		if !math.IsNaN(values[i]) {
+			return values[i]
+		}
+	}
+	return math.NaN()
+}
+
+// consolidateFetchResponse aggregates points of m (finer series) to the step and start time of the coarse one.
+// Bucket is null if ratio of non-null points in it is less than xFilesFactor.
+func consolidateFetchResponse(m *protov3.FetchResponse, coarse *protov3.FetchResponse, f func([]float64) float64, xFilesFactor float32) []float64 {
+	res := make([]float64, len(coarse.Values))
+	for i := range res {
+		res[i] = math.NaN()
+	}
+
+	buckets := make([][]float64, len(coarse.Values))
+	for i, v := range m.Values {
+		ts := m.StartTime + int64(i)*m.StepTime
+		if ts < coarse.StartTime {
+			continue
+		}
+		idx := (ts - coarse.StartTime) / coarse.StepTime
+		if idx >= int64(len(buckets)) {
+			break
+		}
+		buckets[idx] = append(buckets[idx], v)
+	}
+
+	pointsPerBucket := float64(coarse.StepTime) / float64(m.StepTime)
+	for i := range buckets {
+		if len(buckets[i]) == 0 {
+			continue
+		}
+		nonNull := 0
+		for _, v := range buckets[i] {
+			if !math.IsNaN(v) {
+				nonNull++
+			}
+		}
+		if float64(nonNull)/pointsPerBucket < float64(xFilesFactor) {
+			continue
+		}
+		res[i] = f(buckets[i])
+	}
+
+	return res
+}
+
+// ResampleFetchResponse consolidates points of the series to the coarser step, buckets are aligned to multiples of
+// the step. Bucket is null if ratio of non-null points in it is less than xFilesFactor.
+func ResampleFetchResponse(m *protov3.FetchResponse, step int64, f func([]float64) float64, xFilesFactor float32) {
+	if m.StepTime <= 0 || step <= m.StepTime {
+		return
+	}
+	start := m.StartTime - m.StartTime%step
+	coarse := &protov3.FetchResponse{
+		StartTime: start,
+		StepTime:  step,
+		Values:    make([]float64, (seriesEnd(m)-start+step-1)/step),
+	}
+	m.Values = consolidateFetchResponse(m, coarse, f, xFilesFactor)
+	m.StartTime = start
+	m.StepTime = step
+}
+
+func mergeFetchResponsesWithUnequalStepTimes(m1, m2 *protov3.FetchResponse, opts MergeOptions) error {
 	if m1.StepTime > m2.StepTime {
 		swapFetchResponses(m1, m2)
 	}
 
-	zapwriter.Logger("zipper_render").Warn("Fetch responses had different step times",
-		zap.Int64("m1_request_start_time", m1.RequestStartTime),
-		zap.Int64("m1_start_time", m1.StartTime),
-		zap.Int64("m1_stop_time", m1.StopTime),
-		zap.Int64("m1_step_time", m1.StepTime),
-		zap.Int64("m2_request_start_time", m2.RequestStartTime),
-		zap.Int64("m2_start_time", m2.StartTime),
-		zap.Int64("m2_stop_time", m2.StopTime),
-		zap.Int64("m2_step_time", m2.StepTime),
-		zap.String("carbonapi_uuid", uuid),
-	)
+	f, ok := consolidationFunctions[opts.ConsolidateBy]
+	if !ok {
+		instance.Logger("zipper_render").Warn("Fetch responses had different step times",
+			zap.Int64("m1_request_start_time", m1.RequestStartTime),
+			zap.Int64("m1_start_time", m1.StartTime),
+			zap.Int64("m1_stop_time", m1.StopTime),
+			zap.Int64("m1_step_time", m1.StepTime),
+			zap.Int64("m2_request_start_time", m2.RequestStartTime),
+			zap.Int64("m2_start_time", m2.StartTime),
+			zap.Int64("m2_stop_time", m2.StopTime),
+			zap.Int64("m2_step_time", m2.StepTime),
+			zap.String("carbonapi_uuid", opts.UUID),
+		)
+		return nil
+	}
+
+	// m1 is finer, so consolidate it to m2's resolution and use it to fill the gaps. Backends might keep different
+	// parts of the history (e.x. one has only recent high resolution data, another one only older points), so
+	// coarse series is extended to cover both of them first.
+	extendFetchResponse(m2, m1.StartTime, seriesEnd(m1), m1.StopTime)
+	consolidated := consolidateFetchResponse(m1, m2, f, opts.XFilesFactor)
+	for i := range m2.Values {
+		if math.IsNaN(m2.Values[i]) {
+			m2.Values[i] = consolidated[i]
+		}
+	}
+	swapFetchResponses(m1, m2)
+	m1.ConsolidationFunc = opts.ConsolidateBy
+	m1.XFilesFactor = opts.XFilesFactor
 
 	return nil
 }
 
+// MergeFetchResponses merges m2 into m1 with default merge options
 func MergeFetchResponses(m1, m2 *protov3.FetchResponse, uuid string) *errors.Errors {
+	return MergeFetchResponsesWithOptions(m1, m2, MergeOptions{UUID: uuid})
+}
+
+// MergeFetchResponsesWithOptions merges m2 into m1
+func MergeFetchResponsesWithOptions(m1, m2 *protov3.FetchResponse, opts MergeOptions) *errors.Errors {
+	uuid := opts.UUID
 	var err error
 	if m1.RequestStartTime != m2.RequestStartTime {
 		err = ErrResponseStartTimeMismatch
 	} else if m1.StepTime == m2.StepTime {
-		err = mergeFetchResponsesWithEqualStepTimes(m1, m2, uuid)
+		err = mergeFetchResponsesWithEqualStepTimes(m1, m2, opts)
 	} else {
-		err = mergeFetchResponsesWithUnequalStepTimes(m1, m2, uuid)
+		err = mergeFetchResponsesWithUnequalStepTimes(m1, m2, opts)
 	}
 
 	if err != nil {
-		zapwriter.Logger("zipper_render").Error("Unable to merge fetch responses",
+		instance.Logger("zipper_render").Error("Unable to merge fetch responses",
 			zap.Error(err),
 			zap.Int64("m1_request_start_time", m1.RequestStartTime),
 			zap.Int64("m1_start_time", m1.StartTime),
@@ -205,7 +571,7 @@ func MergeFetchResponses(m1, m2 *protov3.FetchResponse, uuid string) *errors.Err
 	return errors.FromErr(err)
 }
 
-func (first *ServerFetchResponse) Merge(second *ServerFetchResponse, uuid string) {
+func (first *ServerFetchResponse) Merge(second *ServerFetchResponse, opts MergeOptions) {
 	if first.Server == "" && second.Server != "" {
 		first.Server = second.Server
 	}
@@ -234,7 +600,7 @@ func (first *ServerFetchResponse) Merge(second *ServerFetchResponse, uuid string
 
 	for i := range second.Response.Metrics {
 		if j, ok := metrics[coordinates(&second.Response.Metrics[i])]; ok {
-			err := MergeFetchResponses(&first.Response.Metrics[j], &second.Response.Metrics[i], uuid)
+			err := MergeFetchResponsesWithOptions(&first.Response.Metrics[j], &second.Response.Metrics[i], opts)
 			if err != nil {
 				// TODO: Normal error handling
 				continue
@@ -1,9 +1,11 @@
 package types
 
 import (
+	"context"
 	"math"
 	"testing"
 
+	util "github.com/go-graphite/carbonapi/util/ctx"
 	protov3 "github.com/go-graphite/protocol/carbonapi_v3_pb"
 )
 
@@ -31,6 +33,204 @@ func TestMergeFetchResponsesWithNanAppending(t *testing.T) {
 	}
 }
 
+func TestMergeFetchResponsesWithConsolidation(t *testing.T) {
+	tests := []struct {
+		consolidateBy string
+		expected      []float64
+	}{
+		{"avg", []float64{1.5, 100, 5.5}},
+		{"sum", []float64{3, 100, 11}},
+		{"min", []float64{1, 100, 5}},
+		{"max", []float64{2, 100, 6}},
+		{"last", []float64{2, 100, 6}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.consolidateBy, func(t *testing.T) {
+			// 60 seconds
+			m1 := protov3.FetchResponse{
+				StartTime: 120,
+				StepTime:  60,
+				StopTime:  480,
+				Values:    []float64{1, 2, 3, 4, 5, 6},
+			}
+			// 120 seconds
+			m2 := protov3.FetchResponse{
+				StartTime: 120,
+				StepTime:  120,
+				StopTime:  480,
+				Values:    []float64{math.NaN(), 100, math.NaN()},
+			}
+
+			err := MergeFetchResponsesWithOptions(&m1, &m2, MergeOptions{UUID: "test", ConsolidateBy: tt.consolidateBy})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if m1.StepTime != 120 {
+				t.Errorf("unexpected step time, got %v, expected %v", m1.StepTime, 120)
+			}
+
+			if !cmpFloat64Arrays(m1.Values, tt.expected, 0.00001) {
+				t.Errorf("Error merging responses\nExp: %v\nGot: %v", tt.expected, m1.Values)
+			}
+		})
+	}
+}
+
+func TestMergeFetchResponsesWithDifferentStartTimes(t *testing.T) {
+	nan := math.NaN()
+	// m2 was down during first 2 minutes, m1 lost last points
+	m1 := protov3.FetchResponse{RequestStartTime: 60, StartTime: 60, StepTime: 60, StopTime: 180, Values: []float64{1, 2, 3}}
+	m2 := protov3.FetchResponse{RequestStartTime: 60, StartTime: 180, StepTime: 60, StopTime: 300, Values: []float64{nan, 4, 5}}
+
+	err := MergeFetchResponsesWithOptions(&m1, &m2, MergeOptions{UUID: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []float64{1, 2, 3, 4, 5}
+	if m1.StartTime != 60 || m1.StopTime != 300 || !cmpFloat64Arrays(m1.Values, expected, 0.00001) {
+		t.Errorf("Error merging responses\nExp: %v\nGot: %v (start: %v, stop: %v)", expected, m1.Values, m1.StartTime, m1.StopTime)
+	}
+
+	m3 := protov3.FetchResponse{RequestStartTime: 60, StartTime: 90, StepTime: 60, StopTime: 210, Values: []float64{1, 2}}
+	if err := MergeFetchResponsesWithOptions(&m1, &m3, MergeOptions{UUID: "test"}); err == nil {
+		t.Error("series that are not on the same grid shouldn't be merged")
+	}
+}
+
+func TestMergeFetchResponsesWithDifferentRetentions(t *testing.T) {
+	nan := math.NaN()
+	// m1 keeps only last 6 minutes with 60 seconds resolution, m2 only older points with 120 seconds resolution
+	m1 := protov3.FetchResponse{RequestStartTime: 120, StartTime: 480, StepTime: 60, StopTime: 840, Values: []float64{1, 2, 3, 4, 5, 6}}
+	m2 := protov3.FetchResponse{RequestStartTime: 120, StartTime: 120, StepTime: 120, StopTime: 600, Values: []float64{10, 20, 30, nan}}
+
+	err := MergeFetchResponsesWithOptions(&m1, &m2, MergeOptions{UUID: "test", ConsolidateBy: "sum"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []float64{10, 20, 30, 3, 7, 11}
+	if m1.StepTime != 120 || m1.StartTime != 120 || m1.StopTime != 840 || !cmpFloat64Arrays(m1.Values, expected, 0.00001) {
+		t.Errorf("Error merging responses\nExp: %v\nGot: %v (start: %v, stop: %v, step: %v)", expected, m1.Values, m1.StartTime, m1.StopTime, m1.StepTime)
+	}
+}
+
+func TestMergeFetchResponsesMismatches(t *testing.T) {
+	nan := math.NaN()
+	m1 := protov3.FetchResponse{Name: "foo", StartTime: 60, StepTime: 60, StopTime: 360, Values: []float64{1, 2, nan, 4, 5}}
+	m2 := protov3.FetchResponse{Name: "foo", StartTime: 60, StepTime: 60, StopTime: 360, Values: []float64{1, 3, 3, nan, 6}}
+
+	before := MismatchedPoints()
+	err := MergeFetchResponsesWithOptions(&m1, &m2, MergeOptions{UUID: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if mismatches := MismatchedPoints() - before; mismatches != 2 {
+		t.Errorf("unexpected amount of mismatched points, got %v, expected %v", mismatches, 2)
+	}
+	expected := []float64{1, 2, 3, 4, 5}
+	if !cmpFloat64Arrays(m1.Values, expected, 0.00001) {
+		t.Errorf("Error merging responses\nExp: %v\nGot: %v", expected, m1.Values)
+	}
+}
+
+func TestMergeFetchResponsesWithPolicy(t *testing.T) {
+	nan := math.NaN()
+	tests := []struct {
+		policy   string
+		expected []float64
+	}{
+		{MergePolicyHealthiest, []float64{1, 2, 3, 4, 50}},
+		{MergePolicyNewest, []float64{1, 20, 30, 40, 50}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			// lagging replica
+			m1 := protov3.FetchResponse{StartTime: 120, StepTime: 60, StopTime: 360, Values: []float64{1, 2, 3, 4, nan}}
+			m2 := protov3.FetchResponse{StartTime: 120, StepTime: 60, StopTime: 360, Values: []float64{nan, 20, 30, 40, 50}}
+
+			err := MergeFetchResponsesWithOptions(&m1, &m2, MergeOptions{UUID: "test", Policy: tt.policy})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !cmpFloat64Arrays(m1.Values, tt.expected, 0.00001) {
+				t.Errorf("Error merging responses\nExp: %v\nGot: %v", tt.expected, m1.Values)
+			}
+		})
+	}
+}
+
+func TestMergeFetchResponsesWithXFilesFactor(t *testing.T) {
+	tests := []struct {
+		xFilesFactor float32
+		expected     []float64
+	}{
+		{0, []float64{1, 100, 5.5}},
+		{0.5, []float64{1, 100, 5.5}},
+		{0.6, []float64{math.NaN(), 100, 5.5}},
+		{1, []float64{math.NaN(), 100, 5.5}},
+	}
+
+	for _, tt := range tests {
+		// 60 seconds, second point is missing
+		m1 := protov3.FetchResponse{
+			StartTime: 120,
+			StepTime:  60,
+			StopTime:  480,
+			Values:    []float64{1, math.NaN(), 3, 4, 5, 6},
+		}
+		// 120 seconds
+		m2 := protov3.FetchResponse{
+			StartTime: 120,
+			StepTime:  120,
+			StopTime:  480,
+			Values:    []float64{math.NaN(), 100, math.NaN()},
+		}
+
+		err := MergeFetchResponsesWithOptions(&m1, &m2, MergeOptions{UUID: "test", ConsolidateBy: "avg", XFilesFactor: tt.xFilesFactor})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !cmpFloat64Arrays(m1.Values, tt.expected, 0.00001) {
+			t.Errorf("xFilesFactor=%v: error merging responses\nExp: %v\nGot: %v", tt.xFilesFactor, tt.expected, m1.Values)
+		}
+		if m1.XFilesFactor != tt.xFilesFactor {
+			t.Errorf("xFilesFactor=%v: unexpected xFilesFactor in response %v", tt.xFilesFactor, m1.XFilesFactor)
+		}
+	}
+}
+
+func TestMergeOptionsFromContext(t *testing.T) {
+	SetDefaultConsolidateBy("max")
+	defer SetDefaultConsolidateBy("")
+
+	ctx := context.Background()
+	if opts := MergeOptionsFromContext(ctx); opts.ConsolidateBy != "max" {
+		t.Errorf("default consolidation wasn't applied, got %q", opts.ConsolidateBy)
+	}
+
+	ctx = util.SetConsolidateBy(ctx, "sum")
+	if opts := MergeOptionsFromContext(ctx); opts.ConsolidateBy != "sum" {
+		t.Errorf("request consolidation should override default, got %q", opts.ConsolidateBy)
+	}
+
+	SetDefaultXFilesFactor(0.5)
+	defer SetDefaultXFilesFactor(0)
+	if opts := MergeOptionsFromContext(ctx); opts.XFilesFactor != 0.5 {
+		t.Errorf("default xFilesFactor wasn't applied, got %v", opts.XFilesFactor)
+	}
+	ctx = util.SetXFilesFactor(ctx, 0)
+	if opts := MergeOptionsFromContext(ctx); opts.XFilesFactor != 0 {
+		t.Errorf("request xFilesFactor should override default, got %v", opts.XFilesFactor)
+	}
+}
+
 func cmpFloat64Arrays(a, b []float64, epsilon float64) bool {
 	if len(a) != len(b) {
 		return false
@@ -52,3 +252,35 @@ func cmpFloat64Arrays(a, b []float64, epsilon float64) bool {
 
 	return true
 }
+
+func TestMergeFindResponsesLeafAndBranch(t *testing.T) {
+	first := NewServerFindResponse()
+	first.Response.Metrics = []protov3.GlobResponse{{
+		Name:    "foo.*",
+		Matches: []protov3.GlobMatch{{Path: "foo.bar", IsLeaf: false}, {Path: "foo.baz", IsLeaf: true}},
+	}}
+
+	second := NewServerFindResponse()
+	second.Response.Metrics = []protov3.GlobResponse{{
+		Name:    "foo.*",
+		Matches: []protov3.GlobMatch{{Path: "foo.bar", IsLeaf: true}, {Path: "foo.baz", IsLeaf: true}, {Path: "foo.qux", IsLeaf: false}},
+	}}
+
+	first.Merge(second)
+
+	expected := []protov3.GlobMatch{
+		{Path: "foo.bar", IsLeaf: false},
+		{Path: "foo.baz", IsLeaf: true},
+		{Path: "foo.bar", IsLeaf: true},
+		{Path: "foo.qux", IsLeaf: false},
+	}
+	matches := first.Response.Metrics[0].Matches
+	if len(matches) != len(expected) {
+		t.Fatalf("unexpected matches, got %+v, expected %+v", matches, expected)
+	}
+	for i := range expected {
+		if matches[i] != expected[i] {
+			t.Fatalf("unexpected matches, got %+v, expected %+v", matches, expected)
+		}
+	}
+}
@@ -0,0 +1,15 @@
+package types
+
+import (
+	"time"
+)
+
+// RetryBudget is a global structure that contains configuration for retry budget
+type RetryBudget struct {
+	// Ratio of retries to requests that is allowed during Window. 0 disables the budget
+	Ratio float64 `mapstructure:"ratio"`
+	// MinRetries is amount of retries per Window that's allowed regardless of Ratio
+	MinRetries int `mapstructure:"minRetries"`
+	// Window is a sliding window that's used to count requests and retries
+	Window time.Duration `mapstructure:"window"`
+}
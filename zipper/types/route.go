@@ -0,0 +1,9 @@
+package types
+
+// Route sends requests for metrics under Prefix to listed backend groups only
+type Route struct {
+	// Prefix is dot separated metric prefix, e.x. "prod.dc1" or "prod.dc1.*". Its nodes may contain *, ? and [...]
+	Prefix string `mapstructure:"prefix"`
+	// Backends are names of the backend groups (groupName) that hold metrics under Prefix
+	Backends []string `mapstructure:"backends"`
+}
@@ -0,0 +1,16 @@
+package types
+
+import (
+	"time"
+)
+
+// StatePersistence is a global structure that contains configuration for saving of statistics that zipper learns
+// about backends (health scores, latency spread), so restarted zipper doesn't have to learn them from scratch
+type StatePersistence struct {
+	// File where statistics are saved. Empty disables persistence
+	File string `mapstructure:"file"`
+	// Interval between saves. Statistics are also saved before zipper is replaced after config reload
+	Interval time.Duration `mapstructure:"interval"`
+	// MaxAge of saved statistics. Older ones don't describe backends anymore and are ignored on start
+	MaxAge time.Duration `mapstructure:"maxAge"`
+}
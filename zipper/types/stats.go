@@ -11,6 +11,7 @@ type Stats struct {
 	SearchCacheMisses int64
 	ZipperRequests    int64
 	TotalMetricsCount int64
+	NotFound          int64
 
 	MemoryUsage int64
 
@@ -19,6 +20,10 @@ type Stats struct {
 
 	Servers       []string
 	FailedServers []string
+
+	// Sources contains backends that returned points for every series, it's only filled if requested,
+	// see ctx.SetTrackSources
+	Sources map[string][]string
 }
 
 func (s *Stats) Merge(stats *Stats) {
@@ -32,6 +37,25 @@ func (s *Stats) Merge(stats *Stats) {
 	s.MemoryUsage += stats.MemoryUsage
 	s.CacheMisses += stats.CacheMisses
 	s.CacheHits += stats.CacheHits
+	s.NotFound += stats.NotFound
 	s.Servers = append(s.Servers, stats.Servers...)
 	s.FailedServers = append(s.FailedServers, stats.FailedServers...)
+	for name, servers := range stats.Sources {
+		for _, server := range servers {
+			s.AddSource(name, server)
+		}
+	}
+}
+
+// AddSource records that server have returned points of the series
+func (s *Stats) AddSource(name, server string) {
+	if s.Sources == nil {
+		s.Sources = make(map[string][]string)
+	}
+	for _, srv := range s.Sources[name] {
+		if srv == server {
+			return
+		}
+	}
+	s.Sources[name] = append(s.Sources[name], server)
 }
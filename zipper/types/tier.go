@@ -0,0 +1,20 @@
+package types
+
+import "time"
+
+// StorageTiers send render requests to backend groups that have requested time range, e.x. recent data is fetched
+// from SSD backed servers and older one from archival storage
+type StorageTiers struct {
+	// Split makes request that spans several tiers fetch each part of the time range from its own tier, results are
+	// stitched together. Otherwise the whole request goes to the hottest tier that has all of the range.
+	Split bool   `mapstructure:"split"`
+	Tiers []Tier `mapstructure:"tiers"`
+}
+
+// Tier is set of backend groups that keep data of limited age
+type Tier struct {
+	// Retention is how old data the groups have, 0 means they have all of it
+	Retention time.Duration `mapstructure:"retention"`
+	// Backends are names of the backend groups (groupName) of the tier
+	Backends []string `mapstructure:"backends"`
+}
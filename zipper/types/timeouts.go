@@ -9,4 +9,16 @@ type Timeouts struct {
 	Find    time.Duration `yaml:"find"`
 	Render  time.Duration `yaml:"render"`
 	Connect time.Duration `yaml:"connect"`
+
+	AfterFirstResponse AfterFirstResponse `yaml:"afterFirstResponse"`
+}
+
+// AfterFirstResponse controls how long broadcast group waits for the rest of backends once first of them have answered.
+// Wait time is estimated from observed spread of backend latencies and is clamped to [Min, Max].
+// Max = 0 disables it and broadcast group waits for all backends (or until render timeout).
+type AfterFirstResponse struct {
+	// Percentile of historical stragglers that should have arrived before we stop waiting, 95 by default
+	Percentile float64       `yaml:"percentile"`
+	Min        time.Duration `yaml:"min"`
+	Max        time.Duration `yaml:"max"`
 }
@@ -0,0 +1,90 @@
+package types
+
+import (
+	"math"
+	"sync/atomic"
+
+	protov3 "github.com/go-graphite/protocol/carbonapi_v3_pb"
+)
+
+// divergentPoints is total amount of points that replicas disagreed about
+var divergentPoints int64
+
+// DivergentPoints returns amount of points that had conflicting values on different replicas
+func DivergentPoints() int64 {
+	return atomic.LoadInt64(&divergentPoints)
+}
+
+// VoteFetchResponses compares points of the same series returned by different servers. If at least 3 of them have
+// non-null values for the same timestamp and they disagree, value of the majority is written to every series, so
+// it doesn't matter which one is used as a base later. Points without a majority are kept as is. Only series with
+// the same resolution and start time are compared. It returns amount of points that had conflicting values.
+func VoteFetchResponses(responses []*ServerFetchResponse) int {
+	type voteKey struct {
+		coords fetchResponseCoordinates
+		start  int64
+		step   int64
+	}
+	groups := make(map[voteKey][]*protov3.FetchResponse)
+	for _, r := range responses {
+		if r.Response == nil {
+			continue
+		}
+		for i := range r.Response.Metrics {
+			m := &r.Response.Metrics[i]
+			k := voteKey{coordinates(m), m.StartTime, m.StepTime}
+			groups[k] = append(groups[k], m)
+		}
+	}
+
+	divergent := 0
+	for _, series := range groups {
+		if len(series) < 3 {
+			continue
+		}
+		divergent += voteSeries(series)
+	}
+	atomic.AddInt64(&divergentPoints, int64(divergent))
+	return divergent
+}
+
+func voteSeries(series []*protov3.FetchResponse) int {
+	length := 0
+	for _, s := range series {
+		if len(s.Values) > length {
+			length = len(s.Values)
+		}
+	}
+
+	divergent := 0
+	counts := make(map[float64]int, len(series))
+	for i := 0; i < length; i++ {
+		for k := range counts {
+			delete(counts, k)
+		}
+		voters := 0
+		for _, s := range series {
+			if i < len(s.Values) && !math.IsNaN(s.Values[i]) {
+				counts[s.Values[i]]++
+				voters++
+			}
+		}
+		if voters < 3 || len(counts) < 2 {
+			continue
+		}
+		divergent++
+
+		for v, cnt := range counts {
+			if cnt*2 <= voters {
+				continue
+			}
+			for _, s := range series {
+				if i < len(s.Values) && !math.IsNaN(s.Values[i]) {
+					s.Values[i] = v
+				}
+			}
+			break
+		}
+	}
+	return divergent
+}
@@ -0,0 +1,59 @@
+package types
+
+import (
+	"math"
+	"testing"
+
+	protov3 "github.com/go-graphite/protocol/carbonapi_v3_pb"
+)
+
+func TestVoteFetchResponses(t *testing.T) {
+	nan := math.NaN()
+	response := func(server string, values ...float64) *ServerFetchResponse {
+		r := NewServerFetchResponse()
+		r.Server = server
+		r.Response.Metrics = []protov3.FetchResponse{{
+			Name:             "foo",
+			RequestStartTime: 60,
+			RequestStopTime:  360,
+			StartTime:        60,
+			StepTime:         60,
+			StopTime:         360,
+			Values:           values,
+		}}
+		return r
+	}
+
+	responses := []*ServerFetchResponse{
+		response("corrupt", 1, 100, 3, 4, 5),
+		response("a", 1, 2, 3, 40, nan),
+		response("b", 1, 2, 30, nan, 5),
+	}
+	before := DivergentPoints()
+	divergent := VoteFetchResponses(responses)
+
+	// points 1 and 2 conflict, point 3 has just 2 voters
+	if divergent != 2 || DivergentPoints()-before != 2 {
+		t.Fatalf("unexpected amount of divergent points %v", divergent)
+	}
+	expected := [][]float64{
+		{1, 2, 3, 4, 5},
+		{1, 2, 3, 40, nan},
+		{1, 2, 3, nan, 5},
+	}
+	for i, r := range responses {
+		if !cmpFloat64Arrays(r.Response.Metrics[0].Values, expected[i], 0.00001) {
+			t.Errorf("%v: got %v, expected %v", r.Server, r.Response.Metrics[0].Values, expected[i])
+		}
+	}
+
+	noMajority := []*ServerFetchResponse{response("a", 1), response("b", 2), response("c", 3)}
+	if VoteFetchResponses(noMajority) != 1 || noMajority[0].Response.Metrics[0].Values[0] != 1 {
+		t.Fatal("points without a majority should be kept as is")
+	}
+
+	two := []*ServerFetchResponse{response("a", 1, 2), response("b", 10, 2)}
+	if VoteFetchResponses(two) != 0 || two[0].Response.Metrics[0].Values[0] != 1 {
+		t.Fatal("two replicas can't have a majority")
+	}
+}
@@ -2,33 +2,96 @@ package zipper
 
 import (
 	"context"
+	"fmt"
 	"math"
 	_ "net/http/pprof"
 	"strings"
 	"time"
 
+	"github.com/go-graphite/carbonapi/limiter"
+	util "github.com/go-graphite/carbonapi/util/ctx"
 	"github.com/go-graphite/carbonapi/zipper/broadcast"
 	"github.com/go-graphite/carbonapi/zipper/config"
 	"github.com/go-graphite/carbonapi/zipper/errors"
+	"github.com/go-graphite/carbonapi/zipper/helper"
 	"github.com/go-graphite/carbonapi/zipper/metadata"
+	"github.com/go-graphite/carbonapi/zipper/replicaset"
 	"github.com/go-graphite/carbonapi/zipper/types"
 	protov2 "github.com/go-graphite/protocol/carbonapi_v2_pb"
 	protov3 "github.com/go-graphite/protocol/carbonapi_v3_pb"
 	"go.uber.org/zap"
 
 	_ "github.com/go-graphite/carbonapi/zipper/protocols/auto"
+	_ "github.com/go-graphite/carbonapi/zipper/protocols/clickhouse"
 	_ "github.com/go-graphite/carbonapi/zipper/protocols/graphite"
-	_ "github.com/go-graphite/carbonapi/zipper/protocols/grpc"
+	_ "github.com/go-graphite/carbonapi/zipper/protocols/local"
+	_ "github.com/go-graphite/carbonapi/zipper/protocols/opentsdb"
+	_ "github.com/go-graphite/carbonapi/zipper/protocols/prometheus"
 	_ "github.com/go-graphite/carbonapi/zipper/protocols/v2"
 	_ "github.com/go-graphite/carbonapi/zipper/protocols/v3"
 )
 
+// defaultBackendProtocol is used for old style backends that don't specify protocol
+const defaultBackendProtocol = "carbonapi_v2_pb"
+
+const (
+	// defaultFallbackProtocol is used for servers that reject protobuf requests if fallback protocol isn't set
+	defaultFallbackProtocol = "pickle"
+	// noFallbackProtocol disables fallback completely
+	noFallbackProtocol = "none"
+)
+
+// rejectingProtocols are protobuf protocols that older servers can answer with 400 or 406, requests to them are
+// retried with defaultFallbackProtocol
+var rejectingProtocols = map[string]bool{
+	"carbonapi_v2_pb": true,
+	"proto_v2_pb":     true,
+	"v2_pb":           true,
+	"pb":              true,
+	"pb3":             true,
+	"protobuf":        true,
+	"protobuf3":       true,
+	"carbonapi_v3_pb": true,
+	"proto_v3_pb":     true,
+	"v3_pb":           true,
+}
+
+// groupBackendsByProtocol splits old style backends by protocol. Backend can specify it as a prefix of the address,
+// e.x. "msgpack+http://host:port", protocols are returned in order of their first appearance.
+func groupBackendsByProtocol(backends []string) ([]string, map[string][]string, error) {
+	var protocols []string
+	servers := make(map[string][]string)
+	for _, backend := range backends {
+		protocol := defaultBackendProtocol
+		// protocol names can't be a part of URL scheme, as they have underscores, so anything before "+" that
+		// isn't a scheme or a host is a protocol
+		if i := strings.Index(backend, "+"); i > 0 && !strings.ContainsAny(backend[:i], ":/") {
+			protocol = backend[:i]
+			backend = backend[i+1:]
+			metadata.Metadata.RLock()
+			_, ok := metadata.Metadata.SupportedProtocols[protocol]
+			metadata.Metadata.RUnlock()
+			if !ok {
+				return nil, nil, fmt.Errorf("unknown protocol '%v' of backend '%v'", protocol, backend)
+			}
+		}
+		if _, ok := servers[protocol]; !ok {
+			protocols = append(protocols, protocol)
+		}
+		servers[protocol] = append(servers[protocol], backend)
+	}
+	return protocols, servers, nil
+}
+
 // Zipper provides interface to Zipper-related functions
 type Zipper struct {
 	probeTicker *time.Ticker
 	ProbeQuit   chan struct{}
 	ProbeForce  chan int
 
+	statePersistence types.StatePersistence
+	stateTicker      *time.Ticker
+
 	timeout           time.Duration
 	timeoutConnect    time.Duration
 	keepAliveInterval time.Duration
@@ -37,6 +100,8 @@ type Zipper struct {
 	searchBackends   types.ServerClient
 	searchPrefix     string
 
+	carbonlink *carbonlink
+
 	// Will broadcast to all servers there
 	storeBackends             types.ServerClient
 	concurrencyLimitPerServer int
@@ -77,6 +142,7 @@ func createBackendsV2(logger *zap.Logger, backends types.BackendsV2, expireDelay
 		tries := backends.MaxTries
 		maxIdleConnsPerHost := backends.MaxIdleConnsPerHost
 		keepAliveInterval := backends.KeepAliveInterval
+		transport := backends.Transport
 
 		if backend.Timeouts == nil {
 			backend.Timeouts = &timeouts
@@ -93,6 +159,9 @@ func createBackendsV2(logger *zap.Logger, backends types.BackendsV2, expireDelay
 		if backend.KeepAliveInterval == nil {
 			backend.KeepAliveInterval = &keepAliveInterval
 		}
+		if backend.Transport == nil {
+			backend.Transport = &transport
+		}
 
 		var client types.ServerClient
 		logger.Debug("creating lb group",
@@ -119,13 +188,51 @@ func createBackendsV2(logger *zap.Logger, backends types.BackendsV2, expireDelay
 			return nil, errors.Fatalf("unknown backend protocol '%v'", backend.Protocol)
 		}
 
+		fallbackProtocol := backend.FallbackProtocol
+		rejectedOnly := false
+		if fallbackProtocol == "" && rejectingProtocols[backend.Protocol] {
+			fallbackProtocol = defaultFallbackProtocol
+			rejectedOnly = true
+		} else if fallbackProtocol == noFallbackProtocol {
+			fallbackProtocol = ""
+		}
+		if fallbackProtocol != "" {
+			primaryInit := backendInit
+			metadata.Metadata.RLock()
+			fallbackInit, ok := metadata.Metadata.ProtocolInits[fallbackProtocol]
+			metadata.Metadata.RUnlock()
+			if !ok {
+				logger.Error("unknown backend fallback protocol",
+					zap.Any("backend", backend),
+					zap.String("requested_protocol", fallbackProtocol),
+				)
+				return nil, errors.Fatalf("unknown backend fallback protocol '%v'", fallbackProtocol)
+			}
+			backendInit = func(logger *zap.Logger, config types.BackendV2) (types.ServerClient, *errors.Errors) {
+				primary, e := primaryInit(logger, config)
+				if e != nil && e.HaveFatalErrors {
+					return nil, e
+				}
+				config.GroupName += "_" + fallbackProtocol
+				config.Protocol = fallbackProtocol
+				fallback, e := fallbackInit(logger, config)
+				if e != nil && e.HaveFatalErrors {
+					return nil, e
+				}
+				client := newDowngradeClient(logger, primary, fallback, config.FallbackAfter, config.FallbackDuration)
+				client.rejectedOnly = rejectedOnly
+				return client, nil
+			}
+		}
+
 		var lbMethod types.LBMethod
 		err := lbMethod.FromString(backend.LBMethod)
 		if err != nil {
-			logger.Fatal("failed to parse lbMethod",
+			logger.Error("failed to parse lbMethod",
 				zap.String("lbMethod", backend.LBMethod),
 				zap.Error(err),
 			)
+			return nil, errors.Fatalf("unknown lbMethod '%v'", backend.LBMethod)
 		}
 		if lbMethod == types.RoundRobinLB {
 			client, ePtr = backendInit(logger, backend)
@@ -148,11 +255,33 @@ func createBackendsV2(logger *zap.Logger, backends types.BackendsV2, expireDelay
 				backends = append(backends, client)
 			}
 
-			client, ePtr = broadcast.NewBroadcastGroup(logger, backend.GroupName, backends, expireDelaySec, *backend.ConcurrencyLimit, timeouts)
+			if lbMethod == types.ReplicaSetLB {
+				rs, ePtr := replicaset.New(logger, backend.GroupName, backends, backend.Quorum, *backend.Timeouts)
+				e.Merge(ePtr)
+				if e.HaveFatalErrors {
+					return nil, &e
+				}
+				if err := rs.SetWeights(backend.Weights); err != nil {
+					return nil, errors.Fatalf("invalid weights of group %v: %v", backend.GroupName, err)
+				}
+				rs.SetFailoverTimeout(backend.FailoverTimeout)
+				rs.SetPreferFastest(backend.PreferFastest)
+				storeClients = append(storeClients, rs)
+				continue
+			}
+
+			group, ePtr := broadcast.NewBroadcastGroup(logger, backend.GroupName, backends, expireDelaySec, *backend.ConcurrencyLimit, timeouts)
 			e.Merge(ePtr)
 			if e.HaveFatalErrors {
 				return nil, &e
 			}
+			group.SetQuorum(backend.Quorum)
+			if backend.Hashing.Type != "" {
+				if err := group.SetHashing(backend.Hashing); err != nil {
+					return nil, errors.Fatalf("invalid hashing of group %v: %v", backend.GroupName, err)
+				}
+			}
+			client = group
 		}
 		storeClients = append(storeClients, client)
 	}
@@ -174,6 +303,52 @@ func NewZipper(sender func(*types.Stats), config *config.Config, logger *zap.Log
 		config.InternalRoutingCache = 60 * time.Second
 	}
 
+	// budget of the previous config must not stay in force if it's disabled now
+	var retryBudget *limiter.RetryBudget
+	if config.RetryBudget.Ratio > 0 {
+		if config.RetryBudget.Window > 0 && config.RetryBudget.Window < limiter.MinRetryBudgetWindow {
+			logger.Warn("retryBudget.window is too short, using minimal one",
+				zap.Duration("window", config.RetryBudget.Window),
+				zap.Duration("minimal", limiter.MinRetryBudgetWindow),
+			)
+		}
+		retryBudget = limiter.NewRetryBudget(config.RetryBudget.Ratio, config.RetryBudget.MinRetries, config.RetryBudget.Window)
+	}
+	helper.SetRetryBudget(retryBudget)
+	helper.SetNotFoundCacheTTL(config.NotFoundCacheTTL)
+	helper.SetDecodeErrorQuarantine(config.DecodeQuarantine.Threshold, config.DecodeQuarantine.Duration)
+	helper.SetStrictDecode(config.StrictDecode)
+	helper.SetMaxResponseSize(config.MaxResponseSize)
+
+	if config.ConsolidateBy != "" && !types.IsValidConsolidation(config.ConsolidateBy) {
+		logger.Error("unknown consolidateBy, series with different resolutions won't be consolidated",
+			zap.String("consolidateBy", config.ConsolidateBy),
+			zap.Strings("supported", []string{"avg", "sum", "min", "max", "last"}),
+		)
+		config.ConsolidateBy = ""
+	}
+	types.SetDefaultConsolidateBy(config.ConsolidateBy)
+
+	if !types.IsValidXFilesFactor(config.XFilesFactor) {
+		logger.Error("xFilesFactor must be between 0 and 1, ignoring it",
+			zap.Float32("xFilesFactor", config.XFilesFactor),
+		)
+		config.XFilesFactor = 0
+	}
+	types.SetDefaultXFilesFactor(config.XFilesFactor)
+
+	if config.MergePolicy == "" {
+		config.MergePolicy = types.MergePolicyFirst
+	}
+	if !types.IsValidMergePolicy(config.MergePolicy) {
+		logger.Error("unknown mergePolicy, using default one",
+			zap.String("mergePolicy", config.MergePolicy),
+			zap.Strings("supported", []string{types.MergePolicyFirst, types.MergePolicyHealthiest, types.MergePolicyNewest, types.MergePolicyMajority}),
+		)
+		config.MergePolicy = types.MergePolicyFirst
+	}
+	types.SetDefaultMergePolicy(config.MergePolicy)
+
 	// Convert old config format to new one
 	if config.CarbonSearch.Backend != "" {
 		config.CarbonSearchV2.BackendsV2 = types.BackendsV2{
@@ -186,12 +361,14 @@ func NewZipper(sender func(*types.Stats), config *config.Config, logger *zap.Log
 				ConcurrencyLimit:    &config.ConcurrencyLimitPerServer,
 				KeepAliveInterval:   &config.KeepAliveInterval,
 				MaxIdleConnsPerHost: &config.MaxIdleConnsPerHost,
+				Transport:           &config.Transport,
 				MaxTries:            &config.MaxTries,
 			}},
 			MaxIdleConnsPerHost:       config.MaxIdleConnsPerHost,
 			ConcurrencyLimitPerServer: config.ConcurrencyLimitPerServer,
 			Timeouts:                  config.Timeouts,
 			KeepAliveInterval:         config.KeepAliveInterval,
+			Transport:                 config.Transport,
 			MaxTries:                  config.MaxTries,
 		}
 		config.CarbonSearchV2.Prefix = config.CarbonSearch.Prefix
@@ -201,46 +378,58 @@ func NewZipper(sender func(*types.Stats), config *config.Config, logger *zap.Log
 		prefix = config.CarbonSearchV2.Prefix
 		searchClients, err := createBackendsV2(logger, config.CarbonSearchV2.BackendsV2, int32(config.InternalRoutingCache.Seconds()))
 		if err != nil && err.HaveFatalErrors {
-			logger.Fatal("errors while initialing zipper search backends",
-				zap.Any("errors", err.Errors),
-			)
+			return nil, fmt.Errorf("errors while initialing zipper search backends: %v", err.Errors)
 		}
 
 		searchBackends, err = broadcast.NewBroadcastGroup(logger, "search", searchClients, int32(config.InternalRoutingCache.Seconds()), config.ConcurrencyLimitPerServer, config.Timeouts)
 		if err != nil && err.HaveFatalErrors {
-			logger.Fatal("errors while initialing zipper search backends",
-				zap.Any("errors", err.Errors),
-			)
+			return nil, fmt.Errorf("errors while initialing zipper search backends: %v", err.Errors)
 		}
 	}
 
-	// Convert old config format to new one
+	// Convert old config format to new one, backends of every protocol are put to a separate group
 	if config.Backends != nil && len(config.Backends) != 0 {
+		protocols, servers, err := groupBackendsByProtocol(config.Backends)
+		if err != nil {
+			return nil, err
+		}
+		var groups []types.BackendV2
+		for _, protocol := range protocols {
+			groupName := "backends"
+			if protocol != defaultBackendProtocol {
+				groupName += "_" + protocol
+			}
+			groups = append(groups, types.BackendV2{
+				GroupName:           groupName,
+				Protocol:            protocol,
+				LBMethod:            "broadcast",
+				Servers:             servers[protocol],
+				Timeouts:            &config.Timeouts,
+				ConcurrencyLimit:    &config.ConcurrencyLimitPerServer,
+				KeepAliveInterval:   &config.KeepAliveInterval,
+				MaxIdleConnsPerHost: &config.MaxIdleConnsPerHost,
+				Transport:           &config.Transport,
+				MaxTries:            &config.MaxTries,
+				MaxBatchSize:        config.MaxBatchSize,
+				Quorum:              config.Quorum,
+			})
+		}
 		config.BackendsV2 = types.BackendsV2{
-			Backends: []types.BackendV2{
-				{
-					GroupName:           "backends",
-					Protocol:            "carbonapi_v2_pb",
-					LBMethod:            "broadcast",
-					Servers:             config.Backends,
-					Timeouts:            &config.Timeouts,
-					ConcurrencyLimit:    &config.ConcurrencyLimitPerServer,
-					KeepAliveInterval:   &config.KeepAliveInterval,
-					MaxIdleConnsPerHost: &config.MaxIdleConnsPerHost,
-					MaxTries:            &config.MaxTries,
-					MaxBatchSize:        config.MaxBatchSize,
-				},
-			},
+			Backends:                  groups,
 			MaxIdleConnsPerHost:       config.MaxIdleConnsPerHost,
 			ConcurrencyLimitPerServer: config.ConcurrencyLimitPerServer,
 			Timeouts:                  config.Timeouts,
 			KeepAliveInterval:         config.KeepAliveInterval,
+			Transport:                 config.Transport,
 			MaxTries:                  config.MaxTries,
 			MaxBatchSize:              config.MaxBatchSize,
 		}
 	}
 
 	config.BackendsV2.Timeouts = sanitizeTimouts(config.BackendsV2.Timeouts, config.Timeouts)
+	if config.BackendsV2.Transport == (types.Transport{}) {
+		config.BackendsV2.Transport = config.Transport
+	}
 	for i := range config.BackendsV2.Backends {
 		if config.BackendsV2.Backends[i].Timeouts == nil {
 			timeouts := config.BackendsV2.Timeouts
@@ -252,17 +441,30 @@ func NewZipper(sender func(*types.Stats), config *config.Config, logger *zap.Log
 
 	storeClients, err := createBackendsV2(logger, config.BackendsV2, int32(config.InternalRoutingCache.Seconds()))
 	if err != nil && err.HaveFatalErrors {
-		logger.Fatal("errors while initialing zipper store backends",
-			zap.Any("errors", err.Errors),
-		)
+		return nil, fmt.Errorf("errors while initialing zipper store backends: %v", err.Errors)
 	}
 
 	var storeBackends types.ServerClient
-	storeBackends, err = broadcast.NewBroadcastGroup(logger, "root", storeClients, int32(config.InternalRoutingCache.Seconds()), config.ConcurrencyLimitPerServer, config.Timeouts)
+	rootGroup, err := broadcast.NewBroadcastGroup(logger, "root", storeClients, int32(config.InternalRoutingCache.Seconds()), config.ConcurrencyLimitPerServer, config.Timeouts)
 	if err != nil && err.HaveFatalErrors {
-		logger.Fatal("errors while initialing zipper store backends",
-			zap.Any("errors", err.Errors),
-		)
+		return nil, fmt.Errorf("errors while initialing zipper store backends: %v", err.Errors)
+	}
+	rootGroup.SetExpandGlobs(config.ExpandGlobs)
+	rootGroup.SetQuorum(config.Quorum)
+	if err := rootGroup.SetRoutes(config.Routes); err != nil {
+		return nil, fmt.Errorf("invalid routes: %v", err)
+	}
+	if err := rootGroup.SetStorageTiers(config.StorageTiers); err != nil {
+		return nil, fmt.Errorf("invalid storage tiers: %v", err)
+	}
+	if err := rootGroup.SetFailover(config.Failover); err != nil {
+		return nil, fmt.Errorf("invalid failover: %v", err)
+	}
+	storeBackends = rootGroup
+
+	carbonlink, clErr := newCarbonlink(logger, config.Carbonlink)
+	if clErr != nil {
+		return nil, fmt.Errorf("invalid carbonlink: %v", clErr)
 	}
 
 	z := &Zipper{
@@ -276,6 +478,7 @@ func NewZipper(sender func(*types.Stats), config *config.Config, logger *zap.Log
 		searchBackends:            searchBackends,
 		searchPrefix:              prefix,
 		searchConfigured:          len(prefix) > 0 && len(searchBackends.Backends()) > 0,
+		carbonlink:                carbonlink,
 		concurrencyLimitPerServer: config.ConcurrencyLimitPerServer,
 		keepAliveInterval:         config.KeepAliveInterval,
 		timeout:                   config.Timeouts.Render,
@@ -287,6 +490,23 @@ func NewZipper(sender func(*types.Stats), config *config.Config, logger *zap.Log
 		zap.Any("config", config),
 	)
 
+	if config.StatePersistence.File != "" {
+		z.statePersistence = config.StatePersistence
+		if z.statePersistence.Interval <= 0 {
+			z.statePersistence.Interval = time.Minute
+		}
+		if z.statePersistence.MaxAge <= 0 {
+			z.statePersistence.MaxAge = time.Hour
+		}
+		if err := z.loadState(); err != nil {
+			logger.Error("failed to load saved backends statistics",
+				zap.String("file", z.statePersistence.File),
+				zap.Error(err),
+			)
+		}
+		z.stateTicker = time.NewTicker(z.statePersistence.Interval)
+	}
+
 	go z.probeTlds()
 
 	z.ProbeForce <- 1
@@ -304,23 +524,65 @@ func (z *Zipper) doProbe(logger *zap.Logger) {
 	}
 }
 
+func (z *Zipper) saveState() {
+	if err := z.SaveState(); err != nil {
+		z.logger.Error("failed to save backends statistics",
+			zap.String("file", z.statePersistence.File),
+			zap.Error(err),
+		)
+	}
+}
+
+// CloseIdleConnections closes idle connections to carbonlink caches, it must be called once zipper is replaced
+func (z *Zipper) CloseIdleConnections() {
+	z.carbonlink.close()
+}
+
 func (z *Zipper) probeTlds() {
 	logger := z.logger.With(zap.String("type", "probe"))
+	// nil channel blocks forever, so statistics are not saved if persistence is disabled
+	var saveTick <-chan time.Time
+	if z.stateTicker != nil {
+		saveTick = z.stateTicker.C
+	}
 	for {
 		select {
 		case <-z.probeTicker.C:
 			z.doProbe(logger)
 		case <-z.ProbeForce:
 			z.doProbe(logger)
+		case <-saveTick:
+			z.saveState()
 		case <-z.ProbeQuit:
 			z.probeTicker.Stop()
+			if z.stateTicker != nil {
+				z.stateTicker.Stop()
+			}
 			return
 		}
 	}
 }
 
+// passthroughFetcher is implemented by groups that can pass response of the only backend through, see
+// broadcast.FetchWithPassthrough
+type passthroughFetcher interface {
+	FetchWithPassthrough(ctx context.Context, request *protov3.MultiFetchRequest) ([]byte, *protov3.MultiFetchResponse, *types.Stats, *errors.Errors)
+}
+
+// isSearchQuery returns true if query is sent to carbonsearch backends instead of store ones
+func (z Zipper) isSearchQuery(query string) bool {
+	return z.searchConfigured && strings.HasPrefix(query, z.searchPrefix)
+}
+
 // GRPC-compatible methods
 func (z Zipper) FetchProtoV3(ctx context.Context, request *protov3.MultiFetchRequest) (*protov3.MultiFetchResponse, *types.Stats, error) {
+	_, res, stats, err := z.fetchProtoV3(ctx, request, false)
+	return res, stats, err
+}
+
+// fetchProtoV3 fetches the request, if passthrough is set and the only backend has requested metrics, its response
+// might be returned as is, instead of decoded one
+func (z Zipper) fetchProtoV3(ctx context.Context, request *protov3.MultiFetchRequest, passthrough bool) ([]byte, *protov3.MultiFetchResponse, *types.Stats, error) {
 	var statsSearch *types.Stats
 	var e errors.Errors
 
@@ -330,53 +592,72 @@ func (z Zipper) FetchProtoV3(ctx context.Context, request *protov3.MultiFetchReq
 		}
 
 		for _, metric := range request.Metrics {
-			if strings.HasPrefix(metric.Name, z.searchPrefix) {
-				res, stat, err := z.searchBackends.Find(ctx, &protov3.MultiGlobRequest{
-					Metrics: []string{metric.Name},
-				})
-
-				if statsSearch == nil {
-					statsSearch = stat
-				} else {
-					statsSearch.Merge(stat)
-				}
+			if !z.isSearchQuery(metric.Name) {
+				realRequest.Metrics = append(realRequest.Metrics, metric)
+				continue
+			}
 
-				if err != nil {
-					e.Merge(err)
-					continue
-				}
+			res, stat, err := z.searchBackends.Find(ctx, &protov3.MultiGlobRequest{
+				Metrics: []string{metric.Name},
+			})
+			if stat == nil {
+				stat = &types.Stats{}
+			}
+			stat.SearchRequests++
+			if statsSearch == nil {
+				statsSearch = stat
+			} else {
+				statsSearch.Merge(stat)
+			}
 
-				if len(res.Metrics) == 0 {
-					continue
-				}
+			if err != nil {
+				e.Merge(err)
+			}
+			if res == nil {
+				continue
+			}
 
-				metricRequests := make([]protov3.FetchRequest, 0, len(res.Metrics))
-				for _, n := range res.Metrics {
-					for _, m := range n.Matches {
-						metricRequests = append(metricRequests, protov3.FetchRequest{
-							Name:            m.Path,
-							StartTime:       metric.StartTime,
-							StopTime:        metric.StopTime,
-							FilterFunctions: metric.FilterFunctions,
-						})
+			// series are fetched by their real names, but they still belong to the search query
+			pathExpression := metric.PathExpression
+			if pathExpression == "" {
+				pathExpression = metric.Name
+			}
+			for _, n := range res.Metrics {
+				for _, m := range n.Matches {
+					if !m.IsLeaf {
+						continue
 					}
+					realRequest.Metrics = append(realRequest.Metrics, protov3.FetchRequest{
+						Name:            m.Path,
+						PathExpression:  pathExpression,
+						StartTime:       metric.StartTime,
+						StopTime:        metric.StopTime,
+						FilterFunctions: metric.FilterFunctions,
+					})
 				}
-
-				if len(metricRequests) > 0 {
-					realRequest.Metrics = append(realRequest.Metrics, metricRequests...)
-				}
-
-			} else {
-				realRequest.Metrics = append(realRequest.Metrics, metric)
 			}
 		}
 
-		if len(realRequest.Metrics) > 0 {
-			request = realRequest
+		if len(realRequest.Metrics) == 0 {
+			if e.HaveFatalErrors {
+				return nil, nil, statsSearch, types.ErrNoMetricsFetched
+			}
+			return nil, nil, statsSearch, types.ErrNotFound
 		}
+		request = realRequest
 	}
 
-	res, stats, err := z.storeBackends.Fetch(ctx, request)
+	var raw []byte
+	var res *protov3.MultiFetchResponse
+	var stats *types.Stats
+	var err *errors.Errors
+	// search backends resolve metrics on their own, passthrough is only possible for plain requests. Points of the
+	// caches are put onto decoded series.
+	if fetcher, ok := z.storeBackends.(passthroughFetcher); ok && passthrough && statsSearch == nil && z.carbonlink == nil {
+		raw, res, stats, err = fetcher.FetchWithPassthrough(ctx, request)
+	} else {
+		res, stats, err = z.storeBackends.Fetch(ctx, request)
+	}
 	if statsSearch != nil {
 		if stats == nil {
 			stats = statsSearch
@@ -387,51 +668,107 @@ func (z Zipper) FetchProtoV3(ctx context.Context, request *protov3.MultiFetchReq
 
 	e.Merge(err)
 
-	if e.HaveFatalErrors || res == nil {
+	if types.IsNotFound(&e) {
+		return nil, nil, stats, types.ErrNotFound
+	}
+
+	for _, err := range e.Errors {
+		if err == types.ErrTooManyBackends || err == types.ErrQuorumNotReached {
+			return nil, nil, stats, err
+		}
+	}
+
+	if e.HaveFatalErrors || (res == nil && raw == nil) {
 		z.logger.Error("had fatal errors while fetching result",
 			zap.Any("errors", e.Errors),
 		)
-		return nil, nil, types.ErrNoMetricsFetched
+		return nil, nil, nil, types.ErrNoMetricsFetched
 	}
 
-	return res, stats, nil
+	if util.GetStrict(ctx) && types.HaveFailures(&e) {
+		z.logger.Warn("partial response refused in strict mode",
+			zap.Any("errors", e.Errors),
+		)
+		return nil, nil, stats, types.ErrPartialResponse
+	}
+
+	if z.carbonlink != nil && res != nil {
+		z.carbonlink.overlay(ctx, res.Metrics)
+	}
+
+	return raw, res, stats, nil
+}
+
+// Explain tells which backends every metric of the request would be fetched from and why, without fetching it
+func (z Zipper) Explain(ctx context.Context, request *protov3.MultiFetchRequest) ([]types.Explanation, error) {
+	explainer, ok := z.storeBackends.(types.Explainer)
+	if !ok {
+		return nil, types.ErrNotImplementedYet
+	}
+
+	explanations := make([]types.Explanation, 0, len(request.Metrics))
+	storeRequest := &protov3.MultiFetchRequest{}
+	for _, metric := range request.Metrics {
+		if !z.isSearchQuery(metric.Name) {
+			storeRequest.Metrics = append(storeRequest.Metrics, metric)
+			continue
+		}
+		e := types.Explanation{Metric: metric.Name}
+		for _, client := range z.searchBackends.Children() {
+			e.Backends = append(e.Backends, types.ExplainedBackend{
+				Name:    client.Name(),
+				Servers: client.Backends(),
+				Reason:  types.ReasonSearch,
+				From:    metric.StartTime,
+				Until:   metric.StopTime,
+			})
+		}
+		explanations = append(explanations, e)
+	}
+	if len(storeRequest.Metrics) > 0 {
+		explanations = append(explanations, explainer.Explain(ctx, storeRequest)...)
+	}
+	return explanations, nil
 }
 
 func (z Zipper) FindProtoV3(ctx context.Context, request *protov3.MultiGlobRequest) (*protov3.MultiGlobResponse, *types.Stats, error) {
-	searchRequests := &protov3.MultiGlobRequest{}
+	searchRequest := &protov3.MultiGlobRequest{}
 	if z.searchConfigured {
 		realRequest := &protov3.MultiGlobRequest{Metrics: make([]string, 0, len(request.Metrics))}
 		for _, m := range request.Metrics {
-			if strings.HasPrefix(m, z.searchPrefix) {
-				searchRequests.Metrics = append(searchRequests.Metrics, m)
+			if z.isSearchQuery(m) {
+				searchRequest.Metrics = append(searchRequest.Metrics, m)
 			} else {
 				realRequest.Metrics = append(realRequest.Metrics, m)
 			}
 		}
-		if len(searchRequests.Metrics) > 0 {
-			request = realRequest
-		}
+		request = realRequest
 	}
 
-	res, stats, err := z.storeBackends.Find(ctx, request)
-	if err == nil {
-		err = &errors.Errors{}
+	findResponse := &types.ServerFindResponse{
+		Response: &protov3.MultiGlobResponse{},
+		Stats:    &types.Stats{},
+		Err:      &errors.Errors{},
 	}
 
-	findResponse := &types.ServerFindResponse{
-		Response: res,
-		Stats:    stats,
-		Err:      err,
+	if len(request.Metrics) > 0 {
+		res, stats, err := z.storeBackends.Find(ctx, request)
+		findResponse.Merge(&types.ServerFindResponse{
+			Response: res,
+			Stats:    stats,
+			Err:      err,
+		})
 	}
 
-	if len(searchRequests.Metrics) > 0 {
-		resSearch, statsSearch, err := z.searchBackends.Find(ctx, request)
-		searchResponse := &types.ServerFindResponse{
-			Response: resSearch,
-			Stats:    statsSearch,
+	// results of the search are merged with glob matches, so both kinds of queries can be sent at once
+	if len(searchRequest.Metrics) > 0 {
+		res, stats, err := z.searchBackends.Find(ctx, searchRequest)
+		findResponse.Stats.SearchRequests += int64(len(searchRequest.Metrics))
+		findResponse.Merge(&types.ServerFindResponse{
+			Response: res,
+			Stats:    stats,
 			Err:      err,
-		}
-		findResponse.Merge(searchResponse)
+		})
 	}
 
 	if findResponse.Err.HaveFatalErrors {
@@ -507,6 +844,29 @@ func (z Zipper) StatsProtoV3(ctx context.Context) (*protov3.MetricDetailsRespons
 
 // PB3-compatible methods
 func (z Zipper) FetchProtoV2(ctx context.Context, query []string, startTime, stopTime int32) (*protov2.MultiFetchResponse, *types.Stats, error) {
+	grpcRes, stats, err := z.FetchProtoV3(ctx, fetchRequestV2(query, startTime, stopTime))
+	if err != nil {
+		return nil, stats, err
+	}
+
+	return fetchResponseV2(grpcRes), stats, nil
+}
+
+// FetchProtoV2Passthrough is FetchProtoV2 that returns carbonapi_v2_pb encoded response of the backend as is, if
+// it's the only one that has requested metrics. Otherwise decoded response is returned.
+func (z Zipper) FetchProtoV2Passthrough(ctx context.Context, query []string, startTime, stopTime int32) ([]byte, *protov2.MultiFetchResponse, *types.Stats, error) {
+	raw, grpcRes, stats, err := z.fetchProtoV3(ctx, fetchRequestV2(query, startTime, stopTime), true)
+	if err != nil {
+		return nil, nil, stats, err
+	}
+	if raw != nil {
+		return raw, nil, stats, nil
+	}
+
+	return nil, fetchResponseV2(grpcRes), stats, nil
+}
+
+func fetchRequestV2(query []string, startTime, stopTime int32) *protov3.MultiFetchRequest {
 	request := &protov3.MultiFetchRequest{}
 	for _, q := range query {
 		request.Metrics = append(request.Metrics, protov3.FetchRequest{
@@ -515,12 +875,10 @@ func (z Zipper) FetchProtoV2(ctx context.Context, query []string, startTime, sto
 			StopTime:  int64(stopTime),
 		})
 	}
+	return request
+}
 
-	grpcRes, stats, err := z.FetchProtoV3(ctx, request)
-	if err != nil {
-		return nil, nil, err
-	}
-
+func fetchResponseV2(grpcRes *protov3.MultiFetchResponse) *protov2.MultiFetchResponse {
 	var res protov2.MultiFetchResponse
 	for i := range grpcRes.Metrics {
 		vals := make([]float64, 0, len(grpcRes.Metrics[i].Values))
@@ -545,7 +903,7 @@ func (z Zipper) FetchProtoV2(ctx context.Context, query []string, startTime, sto
 			})
 	}
 
-	return &res, stats, nil
+	return &res
 }
 
 func (z Zipper) FindProtoV2(ctx context.Context, query []string) ([]*protov2.GlobResponse, *types.Stats, error) {
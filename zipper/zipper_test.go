@@ -1,13 +1,20 @@
 package zipper
 
 import (
+	"context"
 	"fmt"
 	"math"
+	"reflect"
 	"testing"
+	"time"
 
+	util "github.com/go-graphite/carbonapi/util/ctx"
+	"github.com/go-graphite/carbonapi/zipper/broadcast"
+	"github.com/go-graphite/carbonapi/zipper/dummy"
 	"github.com/go-graphite/carbonapi/zipper/errors"
 	"github.com/go-graphite/carbonapi/zipper/types"
 	protov3 "github.com/go-graphite/protocol/carbonapi_v3_pb"
+	"go.uber.org/zap"
 )
 
 type mergeValuesData struct {
@@ -211,3 +218,115 @@ func TestMergeValues(t *testing.T) {
 		})
 	}
 }
+
+func TestFetchStrict(t *testing.T) {
+	request := &protov3.MultiFetchRequest{
+		Metrics: []protov3.FetchRequest{{Name: "foo", StartTime: 0, StopTime: 120, PathExpression: "foo"}},
+	}
+	response := &protov3.MultiFetchResponse{
+		Metrics: []protov3.FetchResponse{{Name: "foo", PathExpression: "foo", StopTime: 120, StepTime: 60, Values: []float64{0, 1, 2}}},
+	}
+
+	c1 := dummy.NewDummyClient("client1", []string{"backend1"}, 1)
+	c1.AddFetchResponse(request, response, &types.Stats{}, nil)
+	c2 := dummy.NewDummyClient("client2", []string{"backend2"}, 1)
+	c2.AddFetchResponse(request, nil, &types.Stats{}, errors.FromErrNonFatal(types.ErrTimeoutExceeded))
+
+	timeouts := types.Timeouts{Find: time.Second, Render: time.Second, Connect: time.Second}
+	group, e := broadcast.NewBroadcastGroup(zap.NewNop(), "root", []types.ServerClient{c1, c2}, 60, 10, timeouts)
+	if e != nil && e.HaveFatalErrors {
+		t.Fatalf("error while initializing group: %v", e)
+	}
+	z := Zipper{storeBackends: group, logger: zap.NewNop()}
+
+	res, _, err := z.FetchProtoV3(context.Background(), request)
+	if err != nil || len(res.Metrics) != 1 {
+		t.Fatalf("partial response is expected by default, got %v, error %v", res, err)
+	}
+
+	_, _, err = z.FetchProtoV3(util.SetStrict(context.Background(), true), request)
+	if err != types.ErrPartialResponse {
+		t.Fatalf("unexpected error in strict mode, got %v, expected %v", err, types.ErrPartialResponse)
+	}
+}
+
+func TestGroupBackendsByProtocol(t *testing.T) {
+	protocols, servers, err := groupBackendsByProtocol([]string{
+		"http://10.0.0.1:8080",
+		"msgpack+http://10.0.0.2:8080",
+		"http://10.0.0.3:8080/a+b",
+		"msgpack+http://10.0.0.4:8080",
+		"carbonapi_v3_pb+http://10.0.0.5:8080",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectedProtocols := []string{"carbonapi_v2_pb", "msgpack", "carbonapi_v3_pb"}
+	if !reflect.DeepEqual(protocols, expectedProtocols) {
+		t.Fatalf("unexpected protocols %v, expected %v", protocols, expectedProtocols)
+	}
+	expectedServers := map[string][]string{
+		"carbonapi_v2_pb": {"http://10.0.0.1:8080", "http://10.0.0.3:8080/a+b"},
+		"msgpack":         {"http://10.0.0.2:8080", "http://10.0.0.4:8080"},
+		"carbonapi_v3_pb": {"http://10.0.0.5:8080"},
+	}
+	if !reflect.DeepEqual(servers, expectedServers) {
+		t.Fatalf("unexpected servers %v, expected %v", servers, expectedServers)
+	}
+
+	if _, _, err := groupBackendsByProtocol([]string{"protobuff+http://10.0.0.1:8080"}); err == nil {
+		t.Fatal("expected error for unknown protocol")
+	}
+}
+
+func TestSearch(t *testing.T) {
+	store := dummy.NewDummyClient("store", []string{"backend1"}, 1)
+	fetchRequest := &protov3.MultiFetchRequest{
+		Metrics: []protov3.FetchRequest{{Name: "foo.bar", PathExpression: "virt.v1.*.dc:x", StartTime: 0, StopTime: 120}},
+	}
+	store.AddFetchResponse(fetchRequest, &protov3.MultiFetchResponse{
+		Metrics: []protov3.FetchResponse{{Name: "foo.bar", PathExpression: "virt.v1.*.dc:x", StopTime: 120, StepTime: 60, Values: []float64{0, 1, 2}}},
+	}, &types.Stats{}, nil)
+	store.AddFindResponse(&protov3.MultiGlobRequest{Metrics: []string{"foo.*"}}, &protov3.MultiGlobResponse{
+		Metrics: []protov3.GlobResponse{{Name: "foo.*", Matches: []protov3.GlobMatch{{Path: "foo.bar", IsLeaf: true}}}},
+	}, &types.Stats{}, nil)
+
+	search := dummy.NewDummyClient("search", []string{"carbonsearch"}, 1)
+	search.AddFindResponse(&protov3.MultiGlobRequest{Metrics: []string{"virt.v1.*.dc:x"}}, &protov3.MultiGlobResponse{
+		Metrics: []protov3.GlobResponse{{Name: "virt.v1.*.dc:x", Matches: []protov3.GlobMatch{{Path: "foo.bar", IsLeaf: true}}}},
+	}, &types.Stats{}, nil)
+
+	z := Zipper{
+		storeBackends:    store,
+		searchBackends:   search,
+		searchPrefix:     "virt.v1.*",
+		searchConfigured: true,
+		logger:           zap.NewNop(),
+	}
+
+	res, stats, err := z.FindProtoV3(context.Background(), &protov3.MultiGlobRequest{Metrics: []string{"foo.*", "virt.v1.*.dc:x"}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(res.Metrics) != 2 || res.Metrics[0].Name != "foo.*" || res.Metrics[1].Name != "virt.v1.*.dc:x" || stats.SearchRequests != 1 {
+		t.Fatalf("search results should be merged with glob matches, got %+v", res.Metrics)
+	}
+
+	fetched, stats, err := z.FetchProtoV3(context.Background(), &protov3.MultiFetchRequest{
+		Metrics: []protov3.FetchRequest{{Name: "virt.v1.*.dc:x", StartTime: 0, StopTime: 120}},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(fetched.Metrics) != 1 || fetched.Metrics[0].Name != "foo.bar" || stats.SearchRequests != 1 {
+		t.Fatalf("unexpected response %+v", fetched.Metrics)
+	}
+
+	_, _, err = z.FetchProtoV3(context.Background(), &protov3.MultiFetchRequest{
+		Metrics: []protov3.FetchRequest{{Name: "virt.v1.*.dc:unknown", StartTime: 0, StopTime: 120}},
+	})
+	if err != types.ErrNotFound {
+		t.Fatalf("search without results should not be found, got %v", err)
+	}
+}